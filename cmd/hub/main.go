@@ -2,50 +2,87 @@ package main
 
 import (
 	"context"
+	"expvar"
+	"flag"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/legitlolly/SmartHomeHub/internal/api"
+	"github.com/legitlolly/SmartHomeHub/internal/batch"
+	"github.com/legitlolly/SmartHomeHub/internal/config"
 	"github.com/legitlolly/SmartHomeHub/internal/device"
-	"github.com/legitlolly/SmartHomeHub/internal/providers/hue"
-	"github.com/legitlolly/SmartHomeHub/internal/providers/simulator"
+	"github.com/legitlolly/SmartHomeHub/internal/dispatch"
+	"github.com/legitlolly/SmartHomeHub/internal/idempotency"
+	"github.com/legitlolly/SmartHomeHub/internal/jobs"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	_ "github.com/legitlolly/SmartHomeHub/internal/providers/all"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+	"github.com/legitlolly/SmartHomeHub/internal/trace"
 )
 
 func main() {
+	configPath := flag.String("config", os.Getenv("HUB_CONFIG"), "path to the hub config file (JSON)")
+	recordPath := flag.String("record", os.Getenv("HUB_RECORD"), "record device traffic to this trace file, for the replay provider")
+	recordProviders := flag.String("record-providers", "", "comma-separated providers to record (default all)")
+	flag.Parse()
+
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	registry := device.NewRegistry()
-
-	//temp test device
-	tempDevice := simulator.NewSimulatedDevice("temp-light-1")
-	if err := registry.Register(tempDevice); err != nil {
-		log.Printf("Failed to register temp device: %v", err)
+	cfg := config.Default()
+	if *configPath != "" {
+		loaded, err := config.Load(*configPath)
+		if err != nil {
+			log.Fatalf("config error: %v", err)
+		}
+		cfg = loaded
+	} else {
+		log.Println("No config file given, using the default providers")
 	}
-	log.Println("Registered temp device: temp-light-1")
 
-	// Load Hue configuration from environment
-	hueIP := os.Getenv("HUE_BRIDGE_IP")
-	hueUsername := os.Getenv("HUE_USERNAME")
+	registry := device.NewRegistry()
 
-	if hueIP != "" && hueUsername != "" {
-		log.Println("Hue configuration detected, discovering Hue devices...")
+	guards := resilience.NewRegistry()
+	guards.Publish("resilience")
 
-		// Discover and register all lights on the bridge
-		if err := hue.DiscoverAndRegisterLights(ctx, registry, hueIP, hueUsername); err != nil {
-			log.Printf("Failed to discover Hue devices: %v", err)
+	providers := provider.NewManager(registry, provider.Env{Guards: guards})
+	if err := providers.Load(cfg); err != nil {
+		log.Fatalf("provider config error: %v", err)
+	}
+	if *recordPath != "" {
+		recorder, err := trace.Create(*recordPath)
+		if err != nil {
+			log.Fatalf("record error: %v", err)
 		}
-	} else {
-		log.Println("Hue configuration not found (HUE_BRIDGE_IP and HUE_USERNAME not set)")
+		defer recorder.Close()
+		var names []string
+		if *recordProviders != "" {
+			names = strings.Split(*recordProviders, ",")
+		}
+		providers.Record(recorder, names...)
+		log.Printf("Recording device traffic to %s", *recordPath)
 	}
+	providers.StartAll(ctx)
+	expvar.Publish("providers", expvar.Func(func() any { return providers.Statuses() }))
 
-	handler := api.NewHandler(registry)
+	dispatcher := dispatch.New(registry, providers, cfg)
+	jobManager := jobs.NewManager(dispatcher, cfg.Jobs)
+	// Clients retrying a command with the same Idempotency-Key get the first answer
+	// back instead of running it again
+	keys := idempotency.NewStore(cfg.Idempotency)
+	handler := api.NewHandler(registry, dispatcher, jobManager, keys)
 	mux := http.NewServeMux()
 	handler.RegisterRoutes(mux)
+	api.NewProviderHandler(providers).RegisterRoutes(mux)
+	api.NewJobHandler(jobManager).RegisterRoutes(mux)
+	api.NewBatchHandler(batch.NewRunner(dispatcher), jobManager, keys).RegisterRoutes(mux)
+	api.NewSimulatorHandler(registry, providers).RegisterRoutes(mux)
+	mux.Handle("GET /debug/vars", expvar.Handler())
 
 	srv := &http.Server{
 		Addr:         ":8080",
@@ -62,6 +99,23 @@ func main() {
 		}
 	}()
 
+	// SIGHUP rediscovers every provider, which reloads the simulator's home file
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Println("reload signal received, rediscovering devices")
+			for _, status := range providers.Statuses() {
+				if status.State != provider.StateRunning {
+					continue
+				}
+				if err := providers.Discover(ctx, status.Name); err != nil {
+					log.Printf("rediscovering %s failed: %v", status.Name, err)
+				}
+			}
+		}
+	}()
+
 	// Wait for shutdown signal
 	<-ctx.Done()
 	log.Println("shutdown signal received")
@@ -72,4 +126,10 @@ func main() {
 	if err := srv.Shutdown(shutdownCtx); err != nil {
 		log.Printf("graceful shutdown failed: %v", err)
 	}
+
+	// Jobs still sending commands need their providers
+	if err := jobManager.Close(shutdownCtx); err != nil {
+		log.Printf("waiting for jobs failed: %v", err)
+	}
+	providers.StopAll(shutdownCtx)
 }
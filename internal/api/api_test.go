@@ -0,0 +1,263 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/batch"
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/dispatch"
+	"github.com/legitlolly/SmartHomeHub/internal/idempotency"
+	"github.com/legitlolly/SmartHomeHub/internal/jobs"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/simulator"
+)
+
+type simulatorOwners struct{}
+
+func (simulatorOwners) ProviderOf(device.ID) (string, bool) { return "simulator", true }
+
+// startHub serves the API wired the way cmd/hub does, for the devices given, and
+// returns its URL. Commands time out after timeout.
+func startHub(t *testing.T, timeout time.Duration, devices ...device.Device) string {
+	t.Helper()
+	registry := device.NewRegistry()
+	for _, d := range devices {
+		if err := registry.Register(d); err != nil {
+			t.Fatalf("Register failed: %v", err)
+		}
+	}
+	dispatcher := dispatch.New(registry, simulatorOwners{}, &config.Config{CommandTimeout: config.Duration(timeout)})
+	jobManager := jobs.NewManager(dispatcher, config.Jobs{})
+	keys := idempotency.NewStore(config.Idempotency{})
+
+	mux := http.NewServeMux()
+	NewHandler(registry, dispatcher, jobManager, keys).RegisterRoutes(mux)
+	NewJobHandler(jobManager).RegisterRoutes(mux)
+	NewBatchHandler(batch.NewRunner(dispatcher), jobManager, keys).RegisterRoutes(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		srv.Close()
+		jobManager.Close(context.Background())
+	})
+	return srv.URL
+}
+
+// send makes a request, with an Idempotency-Key if key isn't empty, and returns the
+// response and its body.
+func send(t *testing.T, ctx context.Context, method, url, key, body string) (*http.Response, string, error) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "" {
+		req.Header.Set(idempotency.Header, key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp, string(b), nil
+}
+
+// do is send for requests that must reach the hub.
+func do(t *testing.T, method, url, key, body string) (*http.Response, string) {
+	t.Helper()
+	resp, b, err := send(t, context.Background(), method, url, key, body)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	return resp, b
+}
+
+func power(t *testing.T, dev device.Device) string {
+	t.Helper()
+	state, err := dev.State(context.Background())
+	if err != nil {
+		t.Fatalf("State failed: %v", err)
+	}
+	p, _ := state.Attributes["power"].(string)
+	return p
+}
+
+func TestAPI_IdempotentReplay(t *testing.T) {
+	light := simulator.NewSimulatedDevice("light-1")
+	url := startHub(t, time.Second, light)
+
+	for i := range 2 {
+		resp, body := do(t, http.MethodPost, url+"/devices/light-1/command", "toggle-1", `{"action": "toggle"}`)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got %d %s", resp.StatusCode, body)
+		}
+		if replayed := resp.Header.Get(idempotency.ReplayedHeader) == "true"; replayed != (i == 1) {
+			t.Errorf("Expected only the retry to be replayed, request %d was %v", i, replayed)
+		}
+	}
+	if p := power(t, light); p != "on" {
+		t.Errorf("Expected the light toggled once, it is %s", p)
+	}
+
+	batchBody := `{"commands": [{"device": "light-1", "action": "toggle"}]}`
+	do(t, http.MethodPost, url+"/commands/batch", "batch-1", batchBody)
+	resp, body := do(t, http.MethodPost, url+"/commands/batch", "batch-1", batchBody)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(idempotency.ReplayedHeader) != "true" {
+		t.Errorf("Expected the batch replayed, got %d %s", resp.StatusCode, body)
+	}
+	if p := power(t, light); p != "off" {
+		t.Errorf("Expected the batch to toggle the light once, it is %s", p)
+	}
+}
+
+func TestAPI_IdempotentToggleOutlivesItsClient(t *testing.T) {
+	// The simulated light takes 100ms over a command
+	light := simulator.NewSimulatedDevice("light-1")
+	url := startHub(t, time.Second, light)
+
+	ctx, hangUp := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer hangUp()
+	if _, _, err := send(t, ctx, http.MethodPost, url+"/devices/light-1/command", "toggle-1", `{"action": "toggle"}`); err == nil {
+		t.Fatal("Expected the client to hang up before the light answered")
+	}
+
+	// The retry waits for the toggle its first attempt started, and gets its answer
+	resp, body := do(t, http.MethodPost, url+"/devices/light-1/command", "toggle-1", `{"action": "toggle"}`)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(idempotency.ReplayedHeader) != "true" {
+		t.Errorf("Expected the first toggle's answer replayed, got %d %s", resp.StatusCode, body)
+	}
+	if p := power(t, light); p != "on" {
+		t.Errorf("Expected the light toggled once, it is %s", p)
+	}
+}
+
+func TestAPI_CommandTimesOut(t *testing.T) {
+	light := simulator.NewSimulatedDevice("light-1")
+	light.SetFaults(simulator.Faults{TimeoutRate: 1})
+	url := startHub(t, 50*time.Millisecond, light)
+
+	resp, body := do(t, http.MethodPost, url+"/devices/light-1/command", "", `{"action": "turn_on"}`)
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("Expected 504, got %d %s", resp.StatusCode, body)
+	}
+	location := resp.Header.Get("Location")
+	if !strings.HasPrefix(location, "/commands/timeouts/") {
+		t.Fatalf("Expected the timed out command's location, got %q", location)
+	}
+
+	resp, body = do(t, http.MethodGet, url+location, "", "")
+	var cmd dispatch.TimedOut
+	if err := json.Unmarshal([]byte(body), &cmd); resp.StatusCode != http.StatusOK || err != nil {
+		t.Fatalf("Expected the timed out command, got %d %s", resp.StatusCode, body)
+	}
+	if cmd.Device != "light-1" || cmd.Action != "turn_on" || cmd.Timeout.Duration() != 50*time.Millisecond {
+		t.Errorf("Unexpected timed out command: %+v", cmd)
+	}
+}
+
+// waitForJob polls a job until it has finished.
+func waitForJob(t *testing.T, url string) jobs.Job {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		resp, body := do(t, http.MethodGet, url, "", "")
+		var job jobs.Job
+		if err := json.Unmarshal([]byte(body), &job); resp.StatusCode != http.StatusOK || err != nil {
+			t.Fatalf("Expected the job, got %d %s", resp.StatusCode, body)
+		}
+		if !job.Finished.IsZero() {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the job: %+v", job)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAPI_AsyncCommand(t *testing.T) {
+	light := simulator.NewSimulatedDevice("light-1")
+	url := startHub(t, time.Second, light)
+
+	resp, body := do(t, http.MethodPost, url+"/devices/light-1/command", "", `{"action": "turn_on", "async": true}`)
+	if resp.StatusCode != http.StatusAccepted || !strings.HasPrefix(resp.Header.Get("Location"), "/jobs/") {
+		t.Fatalf("Expected 202 with the job's location, got %d %s", resp.StatusCode, body)
+	}
+	if job := waitForJob(t, url+resp.Header.Get("Location")); job.Status != jobs.StatusSucceeded {
+		t.Errorf("Expected the job to succeed, got %+v", job)
+	}
+	if p := power(t, light); p != "on" {
+		t.Errorf("Expected the light on, it is %s", p)
+	}
+}
+
+func TestAPI_CancelJob(t *testing.T) {
+	light := simulator.NewSimulatedDevice("light-1")
+	light.SetFaults(simulator.Faults{Latency: simulator.Latency{Distribution: "fixed", Mean: config.Duration(time.Minute)}})
+	url := startHub(t, time.Second, light)
+
+	resp, body := do(t, http.MethodPost, url+"/devices/light-1/command", "", `{"action": "turn_on", "async": true}`)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d %s", resp.StatusCode, body)
+	}
+	location := resp.Header.Get("Location")
+
+	if resp, body := do(t, http.MethodDelete, url+location, "", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the job cancelled, got %d %s", resp.StatusCode, body)
+	}
+	if job := waitForJob(t, url+location); job.Status != jobs.StatusCancelled {
+		t.Errorf("Expected the job cancelled, got %+v", job)
+	}
+	if p := power(t, light); p != "off" {
+		t.Errorf("Expected the cancelled command not to reach the light, it is %s", p)
+	}
+	if resp, _ := do(t, http.MethodDelete, url+"/jobs/nope", "", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown job, got %d", resp.StatusCode)
+	}
+}
+
+func TestAPI_Batch(t *testing.T) {
+	light1 := simulator.NewSimulatedDevice("light-1")
+	light2 := simulator.NewSimulatedDevice("light-2")
+	url := startHub(t, time.Second, light1, light2)
+
+	commands := `"commands": [{"device": "light-1", "action": "turn_on"}, {"device": "light-2", "action": "turn_on"}]`
+	resp, body := do(t, http.MethodPost, url+"/commands/batch", "", "{"+commands+"}")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 when every command went through, got %d %s", resp.StatusCode, body)
+	}
+
+	tests := []struct {
+		mode  string
+		want  map[device.ID]string
+		power string
+	}{
+		{batch.ModeBestEffort, map[device.ID]string{"light-1": batch.StatusSucceeded, "light-2": batch.StatusFailed}, "on"},
+		{batch.ModeAllOrNothing, map[device.ID]string{"light-1": batch.StatusRolledBack, "light-2": batch.StatusFailed}, "off"},
+	}
+	for _, tt := range tests {
+		do(t, http.MethodPost, url+"/commands/batch", "", `{"commands": [{"device": "light-1", "action": "turn_off"}, {"device": "light-2", "action": "turn_off"}]}`)
+		light2.SetFaults(simulator.Faults{FailNext: 1})
+
+		resp, body := do(t, http.MethodPost, url+"/commands/batch", "", `{"mode": "`+tt.mode+`", "concurrency": 1, `+commands+"}")
+		var outcome batch.Outcome
+		if err := json.Unmarshal([]byte(body), &outcome); resp.StatusCode != http.StatusMultiStatus || err != nil {
+			t.Fatalf("%s: expected 207, got %d %s", tt.mode, resp.StatusCode, body)
+		}
+		for _, r := range outcome.Results {
+			if r.Status != tt.want[r.Device] {
+				t.Errorf("%s: expected %s to have %s, got %+v", tt.mode, r.Device, tt.want[r.Device], r)
+			}
+		}
+		if p := power(t, light1); p != tt.power {
+			t.Errorf("%s: expected light-1 %s, it is %s", tt.mode, tt.power, p)
+		}
+	}
+}
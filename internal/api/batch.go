@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/legitlolly/SmartHomeHub/internal/batch"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/idempotency"
+	"github.com/legitlolly/SmartHomeHub/internal/jobs"
+)
+
+// BatchHandler runs many commands in one request.
+type BatchHandler struct {
+	runner *batch.Runner
+	jobs   *jobs.Manager
+	keys   *idempotency.Store
+}
+
+// NewBatchHandler creates the batch API. Batches sent with an Idempotency-Key are run
+// once per key through keys, if it isn't nil.
+func NewBatchHandler(runner *batch.Runner, jobs *jobs.Manager, keys *idempotency.Store) *BatchHandler {
+	return &BatchHandler{
+		runner: runner,
+		jobs:   jobs,
+		keys:   keys,
+	}
+}
+
+// ExecuteBatch runs the batch and answers with a result per command: 200 if they all
+// went through, 207 otherwise. An async batch is run as a job instead; jobs have no
+// rollback, so only best effort batches can be async.
+func (h *BatchHandler) ExecuteBatch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		batch.Batch
+		Async bool `json:"async"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Async {
+		if err := req.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Mode != batch.ModeBestEffort {
+			http.Error(w, "Only best_effort batches can be async", http.StatusBadRequest)
+			return
+		}
+		cmds := make([]device.Command, len(req.Commands))
+		for i, cmd := range req.Commands {
+			cmds[i] = device.Command{DeviceID: cmd.Device, Action: cmd.Action, Params: cmd.Params}
+		}
+		submitJob(w, h.jobs, cmds)
+		return
+	}
+
+	outcome, err := h.runner.Run(r.Context(), req.Batch)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, batch.ErrInvalidBatch) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if r.Context().Err() != nil {
+		// The client has gone; there is no one to answer
+		return
+	}
+
+	status := http.StatusOK
+	if !outcome.Succeeded {
+		status = http.StatusMultiStatus
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(outcome)
+}
+
+func (h *BatchHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.Handle("POST /commands/batch", h.keys.Middleware(http.HandlerFunc(h.ExecuteBatch)))
+}
@@ -1,19 +1,41 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/dispatch"
+	"github.com/legitlolly/SmartHomeHub/internal/idempotency"
+	"github.com/legitlolly/SmartHomeHub/internal/jobs"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
 )
 
+// settleTimeout is how long the outcome of a command that timed out is waited for, for
+// retries with its Idempotency-Key. A device that hasn't answered by then likely never
+// will.
+const settleTimeout = time.Minute
+
 type Handler struct {
-	registry *device.Registry
+	registry   *device.Registry
+	dispatcher *dispatch.Dispatcher
+	jobs       *jobs.Manager
+	keys       *idempotency.Store
 }
 
-func NewHandler(registry *device.Registry) *Handler {
+// NewHandler creates the device API. Commands sent with an Idempotency-Key are run
+// once per key through keys, if it isn't nil.
+func NewHandler(registry *device.Registry, dispatcher *dispatch.Dispatcher, jobs *jobs.Manager, keys *idempotency.Store) *Handler {
 	return &Handler{
-		registry: registry,
+		registry:   registry,
+		dispatcher: dispatcher,
+		jobs:       jobs,
+		keys:       keys,
 	}
 }
 
@@ -60,6 +82,10 @@ func (h *Handler) GetDeviceState(w http.ResponseWriter, r *http.Request) {
 
 	state, err := dev.State(r.Context())
 	if err != nil {
+		if errors.Is(err, resilience.ErrCircuitOpen) || errors.Is(err, device.ErrDeviceUnavailable) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
 		http.Error(w, "Failed to get device state", http.StatusInternalServerError)
 		return
 	}
@@ -81,6 +107,8 @@ func (h *Handler) ExecuteCommand(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Action string                 `json:"action"`
 		Params map[string]interface{} `json:"params"`
+		// Async runs the command as a job instead of waiting for it
+		Async bool `json:"async"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -93,20 +121,54 @@ func (h *Handler) ExecuteCommand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dev, err := h.registry.Get(device.ID(deviceID))
-	if err != nil {
-		http.Error(w, "Device not found", http.StatusNotFound)
-		return
-	}
-
 	cmd := device.Command{
 		DeviceID: device.ID(deviceID),
 		Action:   req.Action,
 		Params:   req.Params,
 	}
 
-	if err := dev.Execute(r.Context(), cmd); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if req.Async {
+		if _, err := h.registry.Get(cmd.DeviceID); err != nil {
+			http.Error(w, "Device not found", http.StatusNotFound)
+			return
+		}
+		submitJob(w, h.jobs, []device.Command{cmd})
+		return
+	}
+
+	err := h.dispatcher.Execute(r.Context(), cmd)
+	var timeout *dispatch.TimeoutError
+	if errors.As(err, &timeout) {
+		// Retries with the same Idempotency-Key get how the command finally went
+		idempotency.SettleLater(r, func(w http.ResponseWriter) {
+			ctx, cancel := context.WithTimeout(context.Background(), settleTimeout)
+			defer cancel()
+			if outcome, ok := h.dispatcher.Wait(ctx, timeout.ID); ok && outcome.Status != dispatch.StatusPending {
+				writeCommandResult(w, outcome.Err())
+				return
+			}
+			writeCommandResult(w, err)
+		})
+	}
+	if err != nil && r.Context().Err() != nil {
+		// The client has gone; there is no one to answer
+		return
+	}
+	writeCommandResult(w, err)
+}
+
+// writeCommandResult answers a command with how it went.
+func writeCommandResult(w http.ResponseWriter, err error) {
+	if errors.Is(err, device.ErrDeviceNotFound) {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+	var timeout *dispatch.TimeoutError
+	if errors.As(err, &timeout) {
+		w.Header().Set("Location", fmt.Sprintf("/commands/timeouts/%d", timeout.ID))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), commandErrorStatus(err))
 		return
 	}
 
@@ -117,6 +179,48 @@ func (h *Handler) ExecuteCommand(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// commandErrorStatus keeps 400 for commands the device can't take. The rest are not
+// the client's mistake: a provider backing off from its bridge or an offline device
+// is unavailable, a command that ran out of time is a gateway timeout, and any other
+// failure of the device or the network to it is a bad gateway.
+func commandErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, device.ErrInvalidParameter), errors.Is(err, device.ErrUnknownCommand):
+		return http.StatusBadRequest
+	case errors.Is(err, resilience.ErrCircuitOpen), errors.Is(err, device.ErrDeviceUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, dispatch.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// ListTimedOutCommands lists the commands that timed out recently, with what
+// became of them once their device answered.
+func (h *Handler) ListTimedOutCommands(w http.ResponseWriter, r *http.Request) {
+	commands := h.dispatcher.TimedOutCommands()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"count":    len(commands),
+		"commands": commands,
+	})
+}
+
+func (h *Handler) GetTimedOutCommand(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid command id", http.StatusBadRequest)
+		return
+	}
+	cmd, ok := h.dispatcher.TimedOut(id)
+	if !ok {
+		http.Error(w, "Command not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cmd)
+}
+
 func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
@@ -125,6 +229,8 @@ func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("GET /devices", h.ListDevices)
 	mux.HandleFunc("GET /devices/{id}/state", h.GetDeviceState)
-	mux.HandleFunc("POST /devices/{id}/command", h.ExecuteCommand)
+	mux.Handle("POST /devices/{id}/command", h.keys.Middleware(http.HandlerFunc(h.ExecuteCommand)))
+	mux.HandleFunc("GET /commands/timeouts", h.ListTimedOutCommands)
+	mux.HandleFunc("GET /commands/timeouts/{id}", h.GetTimedOutCommand)
 	mux.HandleFunc("GET /health", h.Health)
 }
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/dispatch"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+func TestCommandErrorStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{fmt.Errorf("%w: value must be 0-100", device.ErrInvalidParameter), http.StatusBadRequest},
+		{fmt.Errorf("%w: dance", device.ErrUnknownCommand), http.StatusBadRequest},
+		{resilience.ErrCircuitOpen, http.StatusServiceUnavailable},
+		{resilience.Unavailable(errors.New("no route to host")), http.StatusServiceUnavailable},
+		{&dispatch.TimeoutError{ID: 1, Device: "light-1", Action: "turn_on"}, http.StatusGatewayTimeout},
+		{fmt.Errorf("read: %w", context.DeadlineExceeded), http.StatusGatewayTimeout},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, http.StatusBadGateway},
+		{errors.New("device said no"), http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		if status := commandErrorStatus(tt.err); status != tt.status {
+			t.Errorf("commandErrorStatus(%v) = %d, expected %d", tt.err, status, tt.status)
+		}
+	}
+}
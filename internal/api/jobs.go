@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/jobs"
+)
+
+// JobHandler lets clients follow and cancel the jobs started by async commands.
+type JobHandler struct {
+	jobs *jobs.Manager
+}
+
+func NewJobHandler(jobs *jobs.Manager) *JobHandler {
+	return &JobHandler{
+		jobs: jobs,
+	}
+}
+
+// submitJob starts a job and answers 202 with where to poll it.
+func submitJob(w http.ResponseWriter, manager *jobs.Manager, cmds []device.Command) {
+	job, err := manager.Submit(cmds)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, jobs.ErrTooManyJobs) || errors.Is(err, jobs.ErrClosed) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+func (h *JobHandler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	list := h.jobs.List()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"count": len(list),
+		"jobs":  list,
+	})
+}
+
+func (h *JobHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := h.jobs.Get(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// CancelJob cancels a running job. It answers with the job as it stands, which stays
+// running until the commands in flight have stopped.
+func (h *JobHandler) CancelJob(w http.ResponseWriter, r *http.Request) {
+	job, err := h.jobs.Cancel(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+func (h *JobHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /jobs", h.ListJobs)
+	mux.HandleFunc("GET /jobs/{id}", h.GetJob)
+	mux.HandleFunc("DELETE /jobs/{id}", h.CancelJob)
+}
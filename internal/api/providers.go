@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+)
+
+type ProviderHandler struct {
+	providers *provider.Manager
+}
+
+func NewProviderHandler(providers *provider.Manager) *ProviderHandler {
+	return &ProviderHandler{
+		providers: providers,
+	}
+}
+
+func (h *ProviderHandler) ListProviders(w http.ResponseWriter, r *http.Request) {
+	statuses := h.providers.Statuses()
+
+	response := map[string]interface{}{
+		"count":     len(statuses),
+		"providers": statuses,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *ProviderHandler) GetProviderHealth(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	health, ok := h.providers.Health(r.Context(), name)
+	if !ok {
+		http.Error(w, "Provider not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if health.Status == provider.HealthDown {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(health)
+}
+
+func (h *ProviderHandler) DiscoverDevices(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	if _, ok := h.providers.Status(name); !ok {
+		http.Error(w, "Provider not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.providers.Discover(r.Context(), name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	status, _ := h.providers.Status(name)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func (h *ProviderHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /providers", h.ListProviders)
+	mux.HandleFunc("GET /providers/{name}/health", h.GetProviderHealth)
+	mux.HandleFunc("POST /providers/{name}/discover", h.DiscoverDevices)
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/simulator"
+)
+
+// SimulatorHandler is the admin API for the simulator: it sets the fault profiles of
+// simulated devices, and drives the virtual clocks and scenarios of simulator
+// providers, while the hub runs.
+type SimulatorHandler struct {
+	registry  *device.Registry
+	providers *provider.Manager
+}
+
+func NewSimulatorHandler(registry *device.Registry, providers *provider.Manager) *SimulatorHandler {
+	return &SimulatorHandler{
+		registry:  registry,
+		providers: providers,
+	}
+}
+
+// simulated looks up the device in the path, writing the error response if it isn't
+// a simulated device.
+func (h *SimulatorHandler) simulated(w http.ResponseWriter, r *http.Request) (simulator.Faulty, bool) {
+	dev, err := h.registry.Get(device.ID(r.PathValue("id")))
+	if err != nil {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return nil, false
+	}
+	faulty, ok := device.Unwrap(dev).(simulator.Faulty)
+	if !ok {
+		http.Error(w, "Device is not simulated", http.StatusBadRequest)
+		return nil, false
+	}
+	return faulty, true
+}
+
+func writeFaults(w http.ResponseWriter, dev simulator.Faulty) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":     dev.ID(),
+		"faults": dev.Faults(),
+	})
+}
+
+func (h *SimulatorHandler) ListFaults(w http.ResponseWriter, r *http.Request) {
+	devices := make([]map[string]interface{}, 0)
+	for id, dev := range h.registry.List() {
+		if faulty, ok := device.Unwrap(dev).(simulator.Faulty); ok {
+			devices = append(devices, map[string]interface{}{
+				"id":     id,
+				"faults": faulty.Faults(),
+			})
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i]["id"].(device.ID) < devices[j]["id"].(device.ID)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"count":   len(devices),
+		"devices": devices,
+	})
+}
+
+func (h *SimulatorHandler) GetFaults(w http.ResponseWriter, r *http.Request) {
+	dev, ok := h.simulated(w, r)
+	if !ok {
+		return
+	}
+	writeFaults(w, dev)
+}
+
+func (h *SimulatorHandler) SetFaults(w http.ResponseWriter, r *http.Request) {
+	dev, ok := h.simulated(w, r)
+	if !ok {
+		return
+	}
+
+	var faults simulator.Faults
+	if err := json.NewDecoder(r.Body).Decode(&faults); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := dev.SetFaults(faults); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, simulator.ErrInvalidFaults) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	writeFaults(w, dev)
+}
+
+func (h *SimulatorHandler) ClearFaults(w http.ResponseWriter, r *http.Request) {
+	dev, ok := h.simulated(w, r)
+	if !ok {
+		return
+	}
+	dev.SetFaults(simulator.Faults{})
+	writeFaults(w, dev)
+}
+
+// virtual looks up the simulator provider in the path, writing the error response if
+// there is none or it doesn't run on virtual time.
+func (h *SimulatorHandler) virtual(w http.ResponseWriter, r *http.Request) (*simulator.Provider, bool) {
+	p, ok := h.providers.Provider(r.PathValue("name"))
+	if !ok {
+		http.Error(w, "Provider not found", http.StatusNotFound)
+		return nil, false
+	}
+	sim, ok := p.(*simulator.Provider)
+	if !ok {
+		http.Error(w, "Provider is not a simulator", http.StatusBadRequest)
+		return nil, false
+	}
+	if sim.VirtualClock() == nil {
+		http.Error(w, "Provider does not run on virtual time", http.StatusConflict)
+		return nil, false
+	}
+	return sim, true
+}
+
+func writeClock(w http.ResponseWriter, clock *simulator.VirtualClock) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"now":   clock.Now(),
+		"speed": clock.Speed(),
+	})
+}
+
+func (h *SimulatorHandler) GetClock(w http.ResponseWriter, r *http.Request) {
+	sim, ok := h.virtual(w, r)
+	if !ok {
+		return
+	}
+	writeClock(w, sim.VirtualClock())
+}
+
+// SetClock moves the clock on and/or changes its speed: {"advance": "5m"},
+// {"speed": 60}, {"speed": 0} to pause.
+func (h *SimulatorHandler) SetClock(w http.ResponseWriter, r *http.Request) {
+	sim, ok := h.virtual(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Advance config.Duration `json:"advance"`
+		Speed   *float64        `json:"speed"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Advance < 0 || (req.Speed != nil && *req.Speed < 0) {
+		http.Error(w, "The clock only goes forward", http.StatusBadRequest)
+		return
+	}
+
+	clock := sim.VirtualClock()
+	if req.Speed != nil {
+		clock.SetSpeed(*req.Speed)
+	}
+	clock.Advance(req.Advance.Duration())
+	writeClock(w, clock)
+}
+
+// RunScenario plays a scenario and returns its result, whether it passed or not.
+func (h *SimulatorHandler) RunScenario(w http.ResponseWriter, r *http.Request) {
+	sim, ok := h.virtual(w, r)
+	if !ok {
+		return
+	}
+
+	var scenario simulator.Scenario
+	if err := json.NewDecoder(r.Body).Decode(&scenario); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	result, err := sim.Runner().Run(r.Context(), scenario)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, simulator.ErrInvalidScenario) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (h *SimulatorHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /admin/simulator/faults", h.ListFaults)
+	mux.HandleFunc("GET /admin/simulator/devices/{id}/faults", h.GetFaults)
+	mux.HandleFunc("PUT /admin/simulator/devices/{id}/faults", h.SetFaults)
+	mux.HandleFunc("DELETE /admin/simulator/devices/{id}/faults", h.ClearFaults)
+	mux.HandleFunc("GET /admin/simulator/providers/{name}/clock", h.GetClock)
+	mux.HandleFunc("POST /admin/simulator/providers/{name}/clock", h.SetClock)
+	mux.HandleFunc("POST /admin/simulator/providers/{name}/scenarios", h.RunScenario)
+}
@@ -0,0 +1,328 @@
+// Package batch runs many commands at once, a limited number at a time. Best effort
+// runs them all and reports each; all or nothing stops at the first failure and puts
+// the devices whose commands went through back the way they were.
+package batch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/dispatch"
+)
+
+const (
+	ModeBestEffort   = "best_effort"
+	ModeAllOrNothing = "all_or_nothing"
+
+	DefaultConcurrency = 8
+	MaxConcurrency     = 32
+)
+
+var (
+	ErrInvalidBatch = errors.New("invalid batch")
+	// ErrNoUndo is for a command all or nothing can't roll back
+	ErrNoUndo = errors.New("command can't be rolled back")
+)
+
+// Result statuses. Skipped commands were never sent because an all or nothing batch
+// had already failed.
+const (
+	StatusSucceeded      = "succeeded"
+	StatusFailed         = "failed"
+	StatusSkipped        = "skipped"
+	StatusRolledBack     = "rolled_back"
+	StatusRollbackFailed = "rollback_failed"
+)
+
+type Batch struct {
+	// Mode is best_effort, the default, or all_or_nothing
+	Mode string `json:"mode,omitempty"`
+	// Concurrency is how many commands run at once, DefaultConcurrency by default
+	Concurrency int       `json:"concurrency,omitempty"`
+	Commands    []Command `json:"commands"`
+}
+
+type Command struct {
+	Device device.ID      `json:"device"`
+	Action string         `json:"action"`
+	Params map[string]any `json:"params,omitempty"`
+}
+
+type Result struct {
+	Device device.ID `json:"device"`
+	Action string    `json:"action"`
+	Status string    `json:"status"`
+	Error  string    `json:"error,omitempty"`
+	// RollbackError is why a command could not be undone
+	RollbackError string `json:"rollback_error,omitempty"`
+}
+
+type Outcome struct {
+	Mode string `json:"mode"`
+	// Succeeded is whether every command went through
+	Succeeded bool     `json:"succeeded"`
+	Results   []Result `json:"results"`
+}
+
+// Executor sends commands to devices and reads their state, within their provider's
+// timeout; dispatch.Dispatcher does. ExecuteSettled also hands back the device's own
+// answer, which for a command cut short comes later.
+type Executor interface {
+	Execute(ctx context.Context, cmd device.Command) error
+	ExecuteSettled(ctx context.Context, cmd device.Command) (<-chan error, error)
+	State(ctx context.Context, id device.ID) (device.State, error)
+}
+
+type Runner struct {
+	executor Executor
+}
+
+func NewRunner(executor Executor) *Runner {
+	return &Runner{executor: executor}
+}
+
+// Validate fills in the defaults and checks the batch can be run. All or nothing
+// needs to know how to undo each command, and takes each device only once.
+func (b *Batch) Validate() error {
+	if b.Mode == "" {
+		b.Mode = ModeBestEffort
+	}
+	if b.Mode != ModeBestEffort && b.Mode != ModeAllOrNothing {
+		return fmt.Errorf("%w: mode must be %s or %s", ErrInvalidBatch, ModeBestEffort, ModeAllOrNothing)
+	}
+	if b.Concurrency < 0 || b.Concurrency > MaxConcurrency {
+		return fmt.Errorf("%w: concurrency must be 1-%d", ErrInvalidBatch, MaxConcurrency)
+	}
+	if b.Concurrency == 0 {
+		b.Concurrency = DefaultConcurrency
+	}
+	if len(b.Commands) == 0 {
+		return fmt.Errorf("%w: no commands", ErrInvalidBatch)
+	}
+
+	seen := make(map[device.ID]bool)
+	for i, cmd := range b.Commands {
+		if cmd.Device == "" || cmd.Action == "" {
+			return fmt.Errorf("%w: command %d needs a device and an action", ErrInvalidBatch, i)
+		}
+		if b.Mode != ModeAllOrNothing {
+			continue
+		}
+		if _, ok := undoers[cmd.Action]; !ok {
+			return fmt.Errorf("%w: %w: %s", ErrInvalidBatch, ErrNoUndo, cmd.Action)
+		}
+		if seen[cmd.Device] {
+			return fmt.Errorf("%w: %s is in the batch twice", ErrInvalidBatch, cmd.Device)
+		}
+		seen[cmd.Device] = true
+	}
+	return nil
+}
+
+// Run validates and runs the batch. Rollbacks carry on even if ctx is cancelled.
+func (r *Runner) Run(ctx context.Context, b Batch) (*Outcome, error) {
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+	allOrNothing := b.Mode == ModeAllOrNothing
+
+	results := make([]Result, len(b.Commands))
+	before := make([]map[string]any, len(b.Commands))
+	undo := make([]bool, len(b.Commands)) // sent and maybe carried out
+	// settled gets the answer of a command cut short, which may still go through
+	settled := make([]<-chan error, len(b.Commands))
+	for i, cmd := range b.Commands {
+		results[i] = Result{Device: cmd.Device, Action: cmd.Action, Status: StatusSkipped}
+	}
+
+	runCtx, abort := context.WithCancel(ctx)
+	defer abort()
+	var (
+		mu     sync.Mutex
+		failed bool
+		wg     sync.WaitGroup
+	)
+	sem := make(chan struct{}, b.Concurrency)
+	for i, cmd := range b.Commands {
+		select {
+		case sem <- struct{}{}:
+		case <-runCtx.Done():
+		}
+		if runCtx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			var err error
+			sent := false
+			if allOrNothing {
+				before[i], err = r.state(runCtx, cmd.Device)
+			}
+			var answer <-chan error
+			if err == nil {
+				sent = true
+				answer, err = r.executor.ExecuteSettled(runCtx, device.Command{DeviceID: cmd.Device, Action: cmd.Action, Params: cmd.Params})
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			// A command cut short by the abort, or that timed out, may still go
+			// through
+			cutShort := errors.Is(err, context.Canceled) || errors.Is(err, dispatch.ErrTimeout)
+			undo[i] = sent && (err == nil || cutShort)
+			if sent && cutShort {
+				settled[i] = answer
+			}
+			if err == nil {
+				results[i].Status = StatusSucceeded
+				return
+			}
+			results[i].Status = StatusFailed
+			results[i].Error = err.Error()
+			failed = true
+			if allOrNothing {
+				abort()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failed && allOrNothing {
+		// Undoing a command the device is still carrying out could be undone in turn
+		for _, answer := range settled {
+			if answer != nil {
+				<-answer
+			}
+		}
+		r.rollback(context.WithoutCancel(ctx), b, results, before, undo)
+	}
+	for _, result := range results {
+		if result.Status != StatusSucceeded {
+			failed = true
+		}
+	}
+	return &Outcome{Mode: b.Mode, Succeeded: !failed, Results: results}, nil
+}
+
+func (r *Runner) state(ctx context.Context, id device.ID) (map[string]any, error) {
+	state, err := r.executor.State(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the state to roll back to: %w", err)
+	}
+	return state.Attributes, nil
+}
+
+// rollback puts back the devices of the commands marked in undo, concurrency at a
+// time.
+func (r *Runner) rollback(ctx context.Context, b Batch, results []Result, before []map[string]any, undo []bool) {
+	sem := make(chan struct{}, b.Concurrency)
+	var wg sync.WaitGroup
+	for i, cmd := range b.Commands {
+		if !undo[i] {
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := r.undo(ctx, cmd, before[i])
+			if err != nil {
+				results[i].Status = StatusRollbackFailed
+				results[i].RollbackError = err.Error()
+				return
+			}
+			results[i].Status = StatusRolledBack
+		}()
+	}
+	wg.Wait()
+}
+
+func (r *Runner) undo(ctx context.Context, cmd Command, before map[string]any) error {
+	cmds, err := undoers[cmd.Action](before)
+	if err != nil {
+		return err
+	}
+	for _, undo := range cmds {
+		undo.DeviceID = cmd.Device
+		if err := r.executor.Execute(ctx, undo); err != nil {
+			return fmt.Errorf("%s: %w", undo.Action, err)
+		}
+	}
+	return nil
+}
+
+// undoers turn a device's attributes from before a command into the commands that
+// put them back, by the command's action.
+var undoers = map[string]func(before map[string]any) ([]device.Command, error){
+	"turn_on":        restorePower,
+	"turn_off":       restorePower,
+	"toggle":         restorePower,
+	"set_brightness": restoreBrightness,
+	"lock":           restoreLocked,
+	"unlock":         restoreLocked,
+	"set_temperature": func(before map[string]any) ([]device.Command, error) {
+		return restoreValue(before, "target_temperature", "set_temperature")
+	},
+	"set_mode": func(before map[string]any) ([]device.Command, error) {
+		return restoreValue(before, "mode", "set_mode")
+	},
+	"open":         restorePosition,
+	"close":        restorePosition,
+	"stop":         restorePosition,
+	"set_position": restorePosition,
+}
+
+func restorePower(before map[string]any) ([]device.Command, error) {
+	switch before["power"] {
+	case "on", true:
+		return []device.Command{{Action: "turn_on"}}, nil
+	case "off", false:
+		return []device.Command{{Action: "turn_off"}}, nil
+	}
+	return nil, fmt.Errorf("%w: no power state to go back to", ErrNoUndo)
+}
+
+// restoreBrightness sets the brightness first, as on some lights that turns them on,
+// and then the power.
+func restoreBrightness(before map[string]any) ([]device.Command, error) {
+	brightness, err := restoreValue(before, "brightness", "set_brightness")
+	if err != nil {
+		return nil, err
+	}
+	power, err := restorePower(before)
+	if err != nil {
+		return nil, err
+	}
+	return append(brightness, power...), nil
+}
+
+func restoreLocked(before map[string]any) ([]device.Command, error) {
+	locked, ok := before["locked"].(bool)
+	if !ok {
+		return nil, fmt.Errorf("%w: no lock state to go back to", ErrNoUndo)
+	}
+	if locked {
+		return []device.Command{{Action: "lock"}}, nil
+	}
+	return []device.Command{{Action: "unlock"}}, nil
+}
+
+func restorePosition(before map[string]any) ([]device.Command, error) {
+	return restoreValue(before, "position", "set_position")
+}
+
+// restoreValue sets an attribute back with an action taking it as its "value".
+func restoreValue(before map[string]any, attribute, action string) ([]device.Command, error) {
+	v, ok := before[attribute]
+	if !ok {
+		return nil, fmt.Errorf("%w: no %s to go back to", ErrNoUndo, attribute)
+	}
+	return []device.Command{{Action: action, Params: map[string]any{"value": v}}}, nil
+}
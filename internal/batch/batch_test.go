@@ -0,0 +1,347 @@
+package batch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/dispatch"
+)
+
+// fakeLight is a dimmable light whose turn_on can be made to fail, to hang until its
+// context is done, to time out after it has taken effect, or to take effect late,
+// after its context is done.
+type fakeLight struct {
+	id      device.ID
+	fail    bool
+	hang    bool
+	timeout bool
+	late    bool
+
+	mu         sync.Mutex
+	power      string
+	brightness int
+}
+
+func (l *fakeLight) ID() device.ID { return l.id }
+
+func (l *fakeLight) Execute(ctx context.Context, cmd device.Command) error {
+	if l.hang && cmd.Action == "turn_on" {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if l.late && cmd.Action == "turn_on" {
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+	}
+	time.Sleep(5 * time.Millisecond)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.fail && cmd.Action == "turn_on" {
+		return errors.New("bulb says no")
+	}
+	switch cmd.Action {
+	case "turn_on":
+		l.power = "on"
+		if l.timeout {
+			return &dispatch.TimeoutError{Device: l.id, Action: cmd.Action, Timeout: time.Second}
+		}
+	case "turn_off":
+		l.power = "off"
+	case "set_brightness":
+		v, ok := cmd.Params["value"].(int)
+		if !ok {
+			return errors.New("value must be an int")
+		}
+		l.brightness = v
+	default:
+		return errors.New("unknown command")
+	}
+	return nil
+}
+
+func (l *fakeLight) State(ctx context.Context) (device.State, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return device.State{
+		DeviceType: "light",
+		Attributes: map[string]any{"power": l.power, "brightness": l.brightness},
+	}, nil
+}
+
+func (l *fakeLight) get() (string, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.power, l.brightness
+}
+
+// countingExecutor sends commands to the devices and counts how many run at once.
+// Like the dispatcher, it stops waiting for a device once the context is done.
+type countingExecutor struct {
+	registry *device.Registry
+
+	mu      sync.Mutex
+	running int
+	maxSeen int
+}
+
+func (e *countingExecutor) Execute(ctx context.Context, cmd device.Command) error {
+	_, err := e.ExecuteSettled(ctx, cmd)
+	return err
+}
+
+func (e *countingExecutor) ExecuteSettled(ctx context.Context, cmd device.Command) (<-chan error, error) {
+	e.mu.Lock()
+	e.running++
+	e.maxSeen = max(e.maxSeen, e.running)
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		e.running--
+		e.mu.Unlock()
+	}()
+
+	settled := make(chan error, 1)
+	d, err := e.registry.Get(cmd.DeviceID)
+	if err != nil {
+		settled <- err
+		return settled, err
+	}
+	go func() {
+		settled <- d.Execute(ctx, cmd)
+	}()
+	select {
+	case <-ctx.Done():
+		return settled, ctx.Err()
+	case err := <-settled:
+		settled <- err
+		return settled, err
+	}
+}
+
+func (e *countingExecutor) State(ctx context.Context, id device.ID) (device.State, error) {
+	d, err := e.registry.Get(id)
+	if err != nil {
+		return device.State{}, err
+	}
+	return d.State(ctx)
+}
+
+func setup(t *testing.T, lights ...*fakeLight) (*Runner, *countingExecutor) {
+	t.Helper()
+	registry := device.NewRegistry()
+	for _, l := range lights {
+		if l.power == "" {
+			l.power = "off"
+		}
+		if err := registry.Register(l); err != nil {
+			t.Fatalf("Register failed: %v", err)
+		}
+	}
+	exec := &countingExecutor{registry: registry}
+	return NewRunner(exec), exec
+}
+
+func statuses(outcome *Outcome) map[device.ID]string {
+	s := make(map[device.ID]string)
+	for _, r := range outcome.Results {
+		s[r.Device] = r.Status
+	}
+	return s
+}
+
+func TestRunner_BestEffort(t *testing.T) {
+	lights := []*fakeLight{{id: "bulb-1"}, {id: "bulb-2"}, {id: "bulb-3", fail: true}, {id: "bulb-4"}, {id: "bulb-5"}, {id: "bulb-6"}}
+	r, exec := setup(t, lights...)
+
+	b := Batch{Concurrency: 2}
+	for _, l := range lights {
+		b.Commands = append(b.Commands, Command{Device: l.id, Action: "turn_on"})
+	}
+	b.Commands = append(b.Commands, Command{Device: "missing", Action: "turn_on"})
+
+	outcome, err := r.Run(context.Background(), b)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if outcome.Mode != ModeBestEffort || outcome.Succeeded {
+		t.Errorf("Expected a best effort batch that didn't all succeed, got %+v", outcome)
+	}
+	want := map[device.ID]string{
+		"bulb-1": StatusSucceeded, "bulb-2": StatusSucceeded, "bulb-3": StatusFailed,
+		"bulb-4": StatusSucceeded, "bulb-5": StatusSucceeded, "bulb-6": StatusSucceeded,
+		"missing": StatusFailed,
+	}
+	got := statuses(outcome)
+	for id, status := range want {
+		if got[id] != status {
+			t.Errorf("Expected %s to have %s, got %s", id, status, got[id])
+		}
+	}
+	if outcome.Results[2].Error == "" || outcome.Results[6].Error == "" {
+		t.Error("Expected the failures to be reported")
+	}
+	for _, l := range lights {
+		if power, _ := l.get(); (power == "on") == l.fail {
+			t.Errorf("Expected %s to be on only if its command succeeded, it is %s", l.id, power)
+		}
+	}
+	if exec.maxSeen > 2 {
+		t.Errorf("Expected at most 2 commands at once, saw %d", exec.maxSeen)
+	}
+}
+
+func TestRunner_AllOrNothing(t *testing.T) {
+	lights := []*fakeLight{
+		{id: "bulb-1", brightness: 20},
+		{id: "bulb-2", power: "on", brightness: 40},
+		{id: "bulb-3", fail: true},
+	}
+	r, _ := setup(t, lights...)
+
+	outcome, err := r.Run(context.Background(), Batch{
+		Mode:        ModeAllOrNothing,
+		Concurrency: 1,
+		Commands: []Command{
+			{Device: "bulb-1", Action: "turn_on"},
+			{Device: "bulb-2", Action: "set_brightness", Params: map[string]any{"value": 90}},
+			{Device: "bulb-3", Action: "turn_on"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if outcome.Succeeded {
+		t.Fatal("Expected the batch to fail")
+	}
+	want := map[device.ID]string{"bulb-1": StatusRolledBack, "bulb-2": StatusRolledBack, "bulb-3": StatusFailed}
+	got := statuses(outcome)
+	for id, status := range want {
+		if got[id] != status {
+			t.Errorf("Expected %s to have %s, got %s", id, status, got[id])
+		}
+	}
+	if power, brightness := lights[0].get(); power != "off" || brightness != 20 {
+		t.Errorf("Expected bulb-1 back off at 20, got %s at %d", power, brightness)
+	}
+	if power, brightness := lights[1].get(); power != "on" || brightness != 40 {
+		t.Errorf("Expected bulb-2 back on at 40, got %s at %d", power, brightness)
+	}
+}
+
+func TestRunner_AllOrNothingSkipsAndRollsBackInFlight(t *testing.T) {
+	lights := []*fakeLight{{id: "bulb-1", hang: true}, {id: "bulb-2", fail: true}, {id: "bulb-3"}}
+	r, _ := setup(t, lights...)
+
+	outcome, err := r.Run(context.Background(), Batch{
+		Mode:        ModeAllOrNothing,
+		Concurrency: 2,
+		Commands: []Command{
+			{Device: "bulb-1", Action: "turn_on"},
+			{Device: "bulb-2", Action: "turn_on"},
+			{Device: "bulb-3", Action: "turn_on"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	got := statuses(outcome)
+	if got["bulb-2"] != StatusFailed || got["bulb-3"] != StatusSkipped {
+		t.Errorf("Expected bulb-2 failed and bulb-3 skipped, got %v", got)
+	}
+	// bulb-1 was cut short by the abort but might have turned on all the same
+	if got["bulb-1"] != StatusRolledBack {
+		t.Errorf("Expected bulb-1 rolled back, got %v", got["bulb-1"])
+	}
+	if power, _ := lights[2].get(); power != "off" {
+		t.Errorf("Expected the skipped bulb-3 to stay off, got %s", power)
+	}
+}
+
+func TestRunner_AllOrNothingWaitsForAbortedCommands(t *testing.T) {
+	lights := []*fakeLight{{id: "bulb-1", late: true}, {id: "bulb-2", fail: true}}
+	r, _ := setup(t, lights...)
+
+	outcome, err := r.Run(context.Background(), Batch{
+		Mode:        ModeAllOrNothing,
+		Concurrency: 2,
+		Commands: []Command{
+			{Device: "bulb-1", Action: "turn_on"},
+			{Device: "bulb-2", Action: "turn_on"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got := statuses(outcome); got["bulb-1"] != StatusRolledBack {
+		t.Errorf("Expected bulb-1 rolled back, got %v", got["bulb-1"])
+	}
+	// bulb-1 turned on after the abort; the rollback has to come after that
+	time.Sleep(30 * time.Millisecond)
+	if power, _ := lights[0].get(); power != "off" {
+		t.Errorf("Expected bulb-1 to end up off, got %s", power)
+	}
+}
+
+func TestRunner_AllOrNothingRollsBackTimedOut(t *testing.T) {
+	lights := []*fakeLight{{id: "bulb-1"}, {id: "bulb-2", timeout: true}, {id: "bulb-3"}}
+	r, _ := setup(t, lights...)
+
+	outcome, err := r.Run(context.Background(), Batch{
+		Mode:        ModeAllOrNothing,
+		Concurrency: 1,
+		Commands: []Command{
+			{Device: "bulb-1", Action: "turn_on"},
+			{Device: "bulb-2", Action: "turn_on"},
+			{Device: "bulb-3", Action: "turn_on"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	// bulb-2 timed out, but the device carried the command out late
+	want := map[device.ID]string{"bulb-1": StatusRolledBack, "bulb-2": StatusRolledBack, "bulb-3": StatusSkipped}
+	got := statuses(outcome)
+	for id, status := range want {
+		if got[id] != status {
+			t.Errorf("Expected %s to have %s, got %s", id, status, got[id])
+		}
+	}
+	for _, l := range lights {
+		if power, _ := l.get(); power != "off" {
+			t.Errorf("Expected %s to be off, got %s", l.id, power)
+		}
+	}
+}
+
+func TestBatch_Validate(t *testing.T) {
+	tests := []struct {
+		name  string
+		batch Batch
+		err   error
+	}{
+		{"no commands", Batch{}, ErrInvalidBatch},
+		{"bad mode", Batch{Mode: "some", Commands: []Command{{Device: "a", Action: "turn_on"}}}, ErrInvalidBatch},
+		{"too concurrent", Batch{Concurrency: MaxConcurrency + 1, Commands: []Command{{Device: "a", Action: "turn_on"}}}, ErrInvalidBatch},
+		{"no action", Batch{Commands: []Command{{Device: "a"}}}, ErrInvalidBatch},
+		{"no undo", Batch{Mode: ModeAllOrNothing, Commands: []Command{{Device: "a", Action: "blink"}}}, ErrNoUndo},
+		{"device twice", Batch{Mode: ModeAllOrNothing, Commands: []Command{{Device: "a", Action: "turn_on"}, {Device: "a", Action: "turn_off"}}}, ErrInvalidBatch},
+		{"best effort without undo", Batch{Commands: []Command{{Device: "a", Action: "blink"}, {Device: "a", Action: "blink"}}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.batch.Validate()
+			if !errors.Is(err, tt.err) || (tt.err == nil) != (err == nil) {
+				t.Errorf("Expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+
+	b := Batch{Commands: []Command{{Device: "a", Action: "turn_on"}}}
+	if err := b.Validate(); err != nil || b.Mode != ModeBestEffort || b.Concurrency != DefaultConcurrency {
+		t.Errorf("Expected the defaults filled in, got %+v, %v", b, err)
+	}
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Config is the hub configuration file. Each provider entry is handed to the provider
+// manager, which looks up the factory for its type and passes it the raw settings.
+type Config struct {
+	Providers []Provider `json:"providers"`
+	// CommandTimeout is how long a command may take before the API gives up on it,
+	// for providers that don't set their own
+	CommandTimeout Duration    `json:"command_timeout,omitempty"`
+	Jobs           Jobs        `json:"jobs,omitzero"`
+	Idempotency    Idempotency `json:"idempotency,omitzero"`
+}
+
+// Jobs limits the asynchronous command jobs.
+type Jobs struct {
+	// CommandTimeout is how long each command of a job may take, 5m by default
+	CommandTimeout Duration `json:"command_timeout,omitempty"`
+	// Retention is how long finished jobs can be looked up, 1h by default
+	Retention Duration `json:"retention,omitempty"`
+	// MaxJobs is how many jobs are kept, 1000 by default. The oldest finished jobs
+	// make way for new ones; when all of them are still running, new jobs are refused.
+	MaxJobs int `json:"max_jobs,omitempty"`
+	// Concurrency is how many commands of a job run at once, 8 by default
+	Concurrency int `json:"concurrency,omitempty"`
+}
+
+// Idempotency bounds the responses kept for requests sent with an Idempotency-Key.
+type Idempotency struct {
+	// TTL is how long a response is replayed for its key, 24h by default
+	TTL Duration `json:"ttl,omitempty"`
+	// MaxKeys is how many keys are kept in all, 10000 by default, and
+	// MaxKeysPerClient how many for one client, 1000 by default. The oldest keys
+	// make way for new ones.
+	MaxKeys          int `json:"max_keys,omitempty"`
+	MaxKeysPerClient int `json:"max_keys_per_client,omitempty"`
+}
+
+type Provider struct {
+	Name     string          `json:"name"` // defaults to Type
+	Type     string          `json:"type"`
+	Enabled  *bool           `json:"enabled,omitempty"`
+	Settings json.RawMessage `json:"settings,omitempty"`
+	// CommandTimeout overrides the hub's CommandTimeout for this provider's devices
+	CommandTimeout Duration `json:"command_timeout,omitempty"`
+}
+
+func (p Provider) IsEnabled() bool {
+	return p.Enabled == nil || *p.Enabled
+}
+
+// Default is used when no config file is given: the simulator with a single test light,
+// or the simulated home in SIMULATOR_HOME, and Hue, which picks its bridge up from
+// HUE_BRIDGE_IP and HUE_USERNAME.
+func Default() *Config {
+	simulator := json.RawMessage(`{"devices":["temp-light-1"]}`)
+	if home := os.Getenv("SIMULATOR_HOME"); home != "" {
+		simulator, _ = json.Marshal(map[string]string{"home": home})
+	}
+	return &Config{
+		Providers: []Provider{
+			{Name: "simulator", Type: "simulator", Settings: simulator},
+			{Name: "hue", Type: "hue"},
+		},
+	}
+}
+
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	seen := make(map[string]bool)
+	for i := range cfg.Providers {
+		p := &cfg.Providers[i]
+		if p.Type == "" {
+			return nil, fmt.Errorf("provider %d: type is required", i)
+		}
+		if p.Name == "" {
+			p.Name = p.Type
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("provider %q configured twice", p.Name)
+		}
+		seen[p.Name] = true
+	}
+
+	return &cfg, nil
+}
+
+// Duration is a time.Duration written as a string ("250ms", "30s") in config files.
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		*d = Duration(time.Duration(value))
+	case string:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return errors.New("invalid duration")
+	}
+	return nil
+}
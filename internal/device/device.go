@@ -1,6 +1,12 @@
 package device
 
-import "context"
+import (
+	"context"
+	"errors"
+)
+
+// ErrDeviceUnavailable is returned by devices that are known to be offline
+var ErrDeviceUnavailable = errors.New("device unavailable")
 
 type ID string
 
@@ -9,3 +15,16 @@ type Device interface {
 	Execute(ctx context.Context, cmd Command) error
 	State(ctx context.Context) (State, error)
 }
+
+// Unwrap returns the device behind any wrappers around d (a recording, say), for
+// callers that need more than the Device interface. A wrapper has an Unwrap method
+// returning the device it wraps.
+func Unwrap(d Device) Device {
+	for {
+		w, ok := d.(interface{ Unwrap() Device })
+		if !ok {
+			return d
+		}
+		d = w.Unwrap()
+	}
+}
@@ -0,0 +1,22 @@
+package device_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/simulator"
+	"github.com/legitlolly/SmartHomeHub/internal/trace"
+)
+
+func TestUnwrap(t *testing.T) {
+	dev := simulator.NewSimulatedDevice("light-1")
+	recorded := trace.NewRecorder(io.Discard).Wrap("sim", dev)
+
+	if _, ok := device.Unwrap(recorded).(simulator.Faulty); !ok {
+		t.Error("Expected Unwrap to reach the simulated device")
+	}
+	if device.Unwrap(dev) != device.Device(dev) {
+		t.Error("Expected an unwrapped device to be returned as it is")
+	}
+}
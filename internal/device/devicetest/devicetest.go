@@ -0,0 +1,285 @@
+// Package devicetest checks that a device.Device implementation behaves the way the
+// hub expects of every device: its commands work and show up in its state, bad
+// parameters and unknown commands fail with device.ErrInvalidParameter and
+// device.ErrUnknownCommand and change nothing, a cancelled context is honoured and it
+// is safe to use from many goroutines (run the tests with -race for that to mean
+// anything).
+//
+// A provider's tests describe its device and hand it to Run:
+//
+//	func TestConformance(t *testing.T) {
+//		devicetest.Run(t, devicetest.Light(func(t *testing.T) device.Device {
+//			return NewLamp(...)
+//		}))
+//	}
+package devicetest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+)
+
+// Command is a command the device supports and the attributes it must report after.
+type Command struct {
+	Action string
+	Params map[string]any
+	// Expect are attributes State must report once the command has run. Numbers
+	// compare by value, whatever their type.
+	Expect map[string]any
+}
+
+type Suite struct {
+	// New returns a fresh device; every check starts with its own.
+	New func(t *testing.T) device.Device
+	// DeviceType is what State must report as the device type
+	DeviceType string
+	// Commands are the capabilities the device must have. They are run one at a
+	// time, in order and then in reverse, so each should change what the one
+	// before it did.
+	Commands []Command
+	// Invalid are commands with bad parameters, which must fail and change nothing
+	Invalid []Command
+	// Offline, if set, makes a device from New unreachable. Its commands and state
+	// reads must then fail with device.ErrDeviceUnavailable.
+	Offline func(t *testing.T, d device.Device)
+	// Goroutines is how many goroutines use one device at once, 8 by default.
+	Goroutines int
+}
+
+// Light is the suite for a dimmable light: turn_on, turn_off and set_brightness,
+// with a "value" of 0-100 given as any number. The caller fills in Offline.
+func Light(newDevice func(t *testing.T) device.Device) Suite {
+	return Suite{
+		New:        newDevice,
+		DeviceType: "light",
+		Commands: []Command{
+			{Action: "turn_on", Expect: map[string]any{"power": "on"}},
+			{Action: "set_brightness", Params: map[string]any{"value": 50}, Expect: map[string]any{"brightness": 50}},
+			{Action: "set_brightness", Params: map[string]any{"value": 75.0}, Expect: map[string]any{"brightness": 75}},
+			{Action: "turn_off", Expect: map[string]any{"power": "off"}},
+		},
+		Invalid: []Command{
+			{Action: "set_brightness", Params: map[string]any{"value": 150}},
+			{Action: "set_brightness", Params: map[string]any{"value": -1}},
+			{Action: "set_brightness", Params: map[string]any{"value": "fifty"}},
+			{Action: "set_brightness"},
+		},
+	}
+}
+
+// Run runs every check of the suite as a subtest.
+func Run(t *testing.T, s Suite) {
+	t.Helper()
+	if s.New == nil || len(s.Commands) == 0 {
+		t.Fatal("devicetest: the suite needs New and at least one command")
+	}
+	t.Run("Identity", s.testIdentity)
+	t.Run("Commands", s.testCommands)
+	t.Run("Sequence", s.testSequence)
+	t.Run("InvalidParameters", s.testInvalid)
+	t.Run("UnknownCommand", s.testUnknown)
+	t.Run("Cancelled", s.testCancelled)
+	t.Run("Concurrent", s.testConcurrent)
+	if s.Offline != nil {
+		t.Run("Offline", s.testOffline)
+	}
+}
+
+func (s Suite) testIdentity(t *testing.T) {
+	d := s.New(t)
+	id := d.ID()
+	if id == "" {
+		t.Fatal("Expected an ID")
+	}
+	state := s.state(t, d)
+	if d.ID() != id {
+		t.Errorf("Expected the ID to stay %s, got %s", id, d.ID())
+	}
+	if s.DeviceType != "" && state.DeviceType != s.DeviceType {
+		t.Errorf("Expected device type %q, got %q", s.DeviceType, state.DeviceType)
+	}
+	if state.Attributes == nil {
+		t.Error("Expected attributes")
+	}
+}
+
+// testCommands runs each command on a fresh device.
+func (s Suite) testCommands(t *testing.T) {
+	for _, cmd := range s.Commands {
+		t.Run(cmd.Action, func(t *testing.T) {
+			d := s.New(t)
+			s.execute(t, d, cmd)
+		})
+	}
+}
+
+// testSequence runs the commands one after the other on one device, in order and
+// back, checking the state keeps up.
+func (s Suite) testSequence(t *testing.T) {
+	d := s.New(t)
+	for _, cmd := range s.Commands {
+		s.execute(t, d, cmd)
+	}
+	for i := len(s.Commands) - 1; i >= 0; i-- {
+		s.execute(t, d, s.Commands[i])
+	}
+}
+
+func (s Suite) testInvalid(t *testing.T) {
+	d := s.New(t)
+	ctx := context.Background()
+	before := s.watched(s.state(t, d))
+	for _, cmd := range s.Invalid {
+		err := d.Execute(ctx, device.Command{DeviceID: d.ID(), Action: cmd.Action, Params: cmd.Params})
+		switch {
+		case err == nil:
+			t.Errorf("Expected %s %v to fail", cmd.Action, cmd.Params)
+		case !errors.Is(err, device.ErrInvalidParameter):
+			t.Errorf("Expected %s %v to fail with ErrInvalidParameter, got %v", cmd.Action, cmd.Params, err)
+		}
+	}
+	if after := s.watched(s.state(t, d)); !reflect.DeepEqual(before, after) {
+		t.Errorf("Expected invalid commands to change nothing, went from %v to %v", before, after)
+	}
+}
+
+func (s Suite) testUnknown(t *testing.T) {
+	d := s.New(t)
+	err := d.Execute(context.Background(), device.Command{DeviceID: d.ID(), Action: "devicetest_no_such_command"})
+	switch {
+	case err == nil:
+		t.Error("Expected an unknown command to fail")
+	case !errors.Is(err, device.ErrUnknownCommand):
+		t.Errorf("Expected ErrUnknownCommand, got %v", err)
+	}
+}
+
+// testCancelled sends every command with a cancelled context, which must fail them
+// without effect. A state read may still succeed if it needs no I/O, but if it fails
+// it must be for the cancellation.
+func (s Suite) testCancelled(t *testing.T) {
+	d := s.New(t)
+	before := s.watched(s.state(t, d))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, cmd := range s.Commands {
+		err := d.Execute(ctx, device.Command{DeviceID: d.ID(), Action: cmd.Action, Params: cmd.Params})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected %s to fail with context.Canceled, got %v", cmd.Action, err)
+		}
+	}
+	if _, err := d.State(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a failed state read to be context.Canceled, got %v", err)
+	}
+
+	if after := s.watched(s.state(t, d)); !reflect.DeepEqual(before, after) {
+		t.Errorf("Expected cancelled commands to change nothing, went from %v to %v", before, after)
+	}
+}
+
+// testConcurrent has several goroutines send every command and read the state of
+// one device at once. Nothing may fail, and the race detector checks the rest.
+func (s Suite) testConcurrent(t *testing.T) {
+	d := s.New(t)
+	ctx := context.Background()
+	goroutines := s.Goroutines
+	if goroutines <= 0 {
+		goroutines = 8
+	}
+
+	var wg sync.WaitGroup
+	for range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, cmd := range s.Commands {
+				if err := d.Execute(ctx, device.Command{DeviceID: d.ID(), Action: cmd.Action, Params: cmd.Params}); err != nil {
+					t.Errorf("%s failed: %v", cmd.Action, err)
+				}
+				if _, err := d.State(ctx); err != nil {
+					t.Errorf("State failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Whatever the interleaving, a command run on its own afterwards sticks
+	s.execute(t, d, s.Commands[0])
+}
+
+func (s Suite) testOffline(t *testing.T) {
+	d := s.New(t)
+	s.Offline(t, d)
+	ctx := context.Background()
+
+	cmd := s.Commands[0]
+	if err := d.Execute(ctx, device.Command{DeviceID: d.ID(), Action: cmd.Action, Params: cmd.Params}); !errors.Is(err, device.ErrDeviceUnavailable) {
+		t.Errorf("Expected %s to fail with ErrDeviceUnavailable, got %v", cmd.Action, err)
+	}
+	if _, err := d.State(ctx); !errors.Is(err, device.ErrDeviceUnavailable) {
+		t.Errorf("Expected State to fail with ErrDeviceUnavailable, got %v", err)
+	}
+}
+
+// execute runs a command and checks the state reflects it.
+func (s Suite) execute(t *testing.T, d device.Device, cmd Command) {
+	t.Helper()
+	if err := d.Execute(context.Background(), device.Command{DeviceID: d.ID(), Action: cmd.Action, Params: cmd.Params}); err != nil {
+		t.Fatalf("%s %v failed: %v", cmd.Action, cmd.Params, err)
+	}
+	state := s.state(t, d)
+	for name, want := range cmd.Expect {
+		if got, ok := state.Attributes[name]; !ok || !equal(want, got) {
+			t.Errorf("Expected %s %v after %s %v, got %v", name, want, cmd.Action, cmd.Params, got)
+		}
+	}
+}
+
+func (s Suite) state(t *testing.T, d device.Device) device.State {
+	t.Helper()
+	state, err := d.State(context.Background())
+	if err != nil {
+		t.Fatalf("State failed: %v", err)
+	}
+	return state
+}
+
+// watched picks out the attributes the commands change, the ones that must stay put
+// when a command fails.
+func (s Suite) watched(state device.State) map[string]any {
+	watched := make(map[string]any)
+	for _, cmd := range s.Commands {
+		for name := range cmd.Expect {
+			watched[name] = state.Attributes[name]
+		}
+	}
+	return watched
+}
+
+func equal(want, got any) bool {
+	if w, ok := toFloat(want); ok {
+		g, ok := toFloat(got)
+		return ok && g == w
+	}
+	return reflect.DeepEqual(want, got)
+}
+
+func toFloat(v any) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
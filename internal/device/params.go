@@ -0,0 +1,40 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownCommand and ErrInvalidParameter are returned by devices for an action they
+// don't support and for parameters they can't use. Both are the caller's mistake, not
+// a fault in the device.
+var (
+	ErrUnknownCommand   = errors.New("unknown command")
+	ErrInvalidParameter = errors.New("invalid parameter value")
+)
+
+// NumberParam returns params[key] as a number between min and max inclusive. JSON
+// numbers decode as float64; ints are accepted for commands built in code.
+func NumberParam(params map[string]any, key string, min, max float64) (float64, error) {
+	var v float64
+	switch n := params[key].(type) {
+	case float64:
+		v = n
+	case int:
+		v = float64(n)
+	default:
+		return 0, fmt.Errorf("%w: %s must be a number", ErrInvalidParameter, key)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("%w: %s must be %g-%g", ErrInvalidParameter, key, min, max)
+	}
+	return v, nil
+}
+
+// OnOff is how devices report a power attribute.
+func OnOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,283 @@
+// Package dispatch sends commands to devices on behalf of the API. It gives every
+// command a deadline, set per provider, passes on the caller's cancellation (an HTTP
+// client hanging up) and keeps track of commands that ran out of time until the
+// device finally answers, so what became of them can be looked up afterwards.
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+)
+
+const (
+	// DefaultTimeout is the command timeout when the config doesn't give one. It is
+	// below the HTTP server's write timeout, so the timeout is what the client sees.
+	DefaultTimeout = 5 * time.Second
+	// keepTimedOut is how many timed out commands are remembered
+	keepTimedOut = 100
+)
+
+var ErrTimeout = errors.New("command timed out")
+
+// TimeoutError is returned for a command that didn't finish in time. The device may
+// still carry it out; ID looks up what happened with TimedOut.
+type TimeoutError struct {
+	ID      int64
+	Device  device.ID
+	Action  string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s: %s on %s after %s", ErrTimeout, e.Action, e.Device, e.Timeout)
+}
+
+func (e *TimeoutError) Unwrap() error { return ErrTimeout }
+
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// TimedOut is a command that ran out of time, and what became of it once the device
+// answered.
+type TimedOut struct {
+	ID       int64           `json:"id"`
+	Device   device.ID       `json:"device"`
+	Provider string          `json:"provider,omitempty"`
+	Action   string          `json:"action"`
+	Params   map[string]any  `json:"params,omitempty"`
+	Timeout  config.Duration `json:"timeout"`
+	Started  time.Time       `json:"started"`
+	Finished time.Time       `json:"finished,omitzero"`
+	// Status is pending until the device answers
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+
+	err  error
+	done chan struct{} // closed once the device answers
+}
+
+// Err is the device's error once it has answered.
+func (t TimedOut) Err() error {
+	return t.err
+}
+
+// Owners tells which provider registered a device; provider.Manager does.
+type Owners interface {
+	ProviderOf(id device.ID) (string, bool)
+}
+
+type Dispatcher struct {
+	registry *device.Registry
+	owners   Owners
+	timeout  time.Duration
+	timeouts map[string]time.Duration // by provider
+
+	mu       sync.Mutex
+	nextID   int64
+	timedOut []*TimedOut // oldest first
+}
+
+// New creates a dispatcher for the devices in registry, with the command timeouts
+// from cfg.
+func New(registry *device.Registry, owners Owners, cfg *config.Config) *Dispatcher {
+	d := &Dispatcher{
+		registry: registry,
+		owners:   owners,
+		timeout:  DefaultTimeout,
+		timeouts: make(map[string]time.Duration),
+	}
+	if cfg.CommandTimeout > 0 {
+		d.timeout = cfg.CommandTimeout.Duration()
+	}
+	for _, p := range cfg.Providers {
+		if p.CommandTimeout > 0 {
+			name := p.Name
+			if name == "" {
+				name = p.Type
+			}
+			d.timeouts[name] = p.CommandTimeout.Duration()
+		}
+	}
+	return d
+}
+
+// Timeout is the command timeout for a provider's devices.
+func (d *Dispatcher) Timeout(provider string) time.Duration {
+	if t, ok := d.timeouts[provider]; ok {
+		return t
+	}
+	return d.timeout
+}
+
+// Execute sends cmd to its device and waits for it until the provider's timeout or
+// ctx is done. A command that times out returns a *TimeoutError and is tracked until
+// the device answers; one whose caller went away is only logged.
+func (d *Dispatcher) Execute(ctx context.Context, cmd device.Command) error {
+	return d.ExecuteWithin(ctx, cmd, 0)
+}
+
+// ExecuteWithin is Execute with a timeout of its own, for callers that aren't bound
+// by an HTTP request. A timeout of 0 is the provider's.
+func (d *Dispatcher) ExecuteWithin(ctx context.Context, cmd device.Command, timeout time.Duration) error {
+	_, err := d.execute(ctx, cmd, timeout)
+	return err
+}
+
+// ExecuteSettled is Execute that also hands back a channel getting the device's own
+// answer. For a command that timed out or was abandoned it comes after Execute has
+// returned, and until then the device may still carry the command out.
+func (d *Dispatcher) ExecuteSettled(ctx context.Context, cmd device.Command) (<-chan error, error) {
+	return d.execute(ctx, cmd, 0)
+}
+
+func (d *Dispatcher) execute(ctx context.Context, cmd device.Command, timeout time.Duration) (<-chan error, error) {
+	settled := make(chan error, 1)
+	dev, err := d.registry.Get(cmd.DeviceID)
+	if err != nil {
+		settled <- err
+		return settled, err
+	}
+	provider, _ := d.owners.ProviderOf(cmd.DeviceID)
+	if timeout <= 0 {
+		timeout = d.Timeout(provider)
+	}
+
+	started := time.Now()
+	cmdCtx, cancel := context.WithTimeout(ctx, timeout)
+	done := make(chan error, 1)
+	go func() {
+		done <- dev.Execute(cmdCtx, cmd)
+	}()
+
+	select {
+	case err := <-done:
+		cancel()
+		settled <- err
+		return settled, err
+	case <-cmdCtx.Done():
+	}
+
+	if err := ctx.Err(); err != nil {
+		go func() {
+			err := <-done
+			cancel()
+			log.Printf("dispatch: %s on %s, abandoned by its caller, finished with %v", cmd.Action, cmd.DeviceID, err)
+			settled <- err
+		}()
+		return settled, err
+	}
+
+	t := d.track(&TimedOut{
+		Device:   cmd.DeviceID,
+		Provider: provider,
+		Action:   cmd.Action,
+		Params:   cmd.Params,
+		Timeout:  config.Duration(timeout),
+		Started:  started,
+		Status:   StatusPending,
+		done:     make(chan struct{}),
+	})
+	go func() {
+		err := <-done
+		cancel()
+		d.finish(t, err)
+		settled <- err
+	}()
+	return settled, &TimeoutError{ID: t.ID, Device: cmd.DeviceID, Action: cmd.Action, Timeout: timeout}
+}
+
+// State reads a device's state within its provider's command timeout.
+func (d *Dispatcher) State(ctx context.Context, id device.ID) (device.State, error) {
+	dev, err := d.registry.Get(id)
+	if err != nil {
+		return device.State{}, err
+	}
+	provider, _ := d.owners.ProviderOf(id)
+	ctx, cancel := context.WithTimeout(ctx, d.Timeout(provider))
+	defer cancel()
+	return dev.State(ctx)
+}
+
+func (d *Dispatcher) track(t *TimedOut) *TimedOut {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nextID++
+	t.ID = d.nextID
+	d.timedOut = append(d.timedOut, t)
+	if len(d.timedOut) > keepTimedOut {
+		d.timedOut = d.timedOut[len(d.timedOut)-keepTimedOut:]
+	}
+	return t
+}
+
+func (d *Dispatcher) finish(t *TimedOut, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer close(t.done)
+	t.Finished = time.Now()
+	t.err = err
+	t.Status = StatusSucceeded
+	if err != nil {
+		t.Status = StatusFailed
+		t.Error = err.Error()
+	}
+	log.Printf("dispatch: timed out %s on %s finally %s after %s", t.Action, t.Device, t.Status, t.Finished.Sub(t.Started).Round(time.Millisecond))
+}
+
+// TimedOut returns a command that timed out, as it stands now.
+func (d *Dispatcher) TimedOut(id int64) (TimedOut, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, t := range d.timedOut {
+		if t.ID == id {
+			return *t, true
+		}
+	}
+	return TimedOut{}, false
+}
+
+// Wait waits for a command that timed out to be answered, or for ctx to be done, and
+// returns it as it stands then. It is false for a command no longer remembered.
+func (d *Dispatcher) Wait(ctx context.Context, id int64) (TimedOut, bool) {
+	d.mu.Lock()
+	var t *TimedOut
+	for _, c := range d.timedOut {
+		if c.ID == id {
+			t = c
+			break
+		}
+	}
+	d.mu.Unlock()
+	if t == nil {
+		return TimedOut{}, false
+	}
+
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return *t, true
+}
+
+// TimedOutCommands returns the commands that timed out most recently, newest first.
+func (d *Dispatcher) TimedOutCommands() []TimedOut {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	list := make([]TimedOut, 0, len(d.timedOut))
+	for i := len(d.timedOut) - 1; i >= 0; i-- {
+		list = append(list, *d.timedOut[i])
+	}
+	return list
+}
@@ -0,0 +1,199 @@
+package dispatch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+)
+
+// slowDevice answers a command when release is closed, or when its context is done
+// if it honours it. Its state hangs until the context is done if hangState is set.
+type slowDevice struct {
+	id        device.ID
+	honours   bool
+	hangState bool
+	release   chan struct{}
+	err       error
+	cancelled chan error
+}
+
+func (d *slowDevice) ID() device.ID { return d.id }
+
+func (d *slowDevice) Execute(ctx context.Context, cmd device.Command) error {
+	if !d.honours {
+		<-d.release
+		return d.err
+	}
+	select {
+	case <-d.release:
+		return d.err
+	case <-ctx.Done():
+		if d.cancelled != nil {
+			d.cancelled <- ctx.Err()
+		}
+		return ctx.Err()
+	}
+}
+
+func (d *slowDevice) State(ctx context.Context) (device.State, error) {
+	if d.hangState {
+		<-ctx.Done()
+		return device.State{}, ctx.Err()
+	}
+	return device.State{DeviceType: "light"}, nil
+}
+
+type owners map[device.ID]string
+
+func (o owners) ProviderOf(id device.ID) (string, bool) {
+	name, ok := o[id]
+	return name, ok
+}
+
+func newDispatcher(t *testing.T, cfg string, devices ...*slowDevice) *Dispatcher {
+	t.Helper()
+	var c config.Config
+	if err := json.Unmarshal([]byte(cfg), &c); err != nil {
+		t.Fatal(err)
+	}
+	registry := device.NewRegistry()
+	o := owners{}
+	for _, d := range devices {
+		registry.Register(d)
+		o[d.id] = "slow"
+	}
+	o["quick"] = "quick"
+	return New(registry, o, &c)
+}
+
+func TestDispatcher_Timeouts(t *testing.T) {
+	d := newDispatcher(t, `{"command_timeout": "1s", "providers": [{"type": "slow", "command_timeout": "20ms"}]}`)
+	if d.Timeout("slow") != 20*time.Millisecond || d.Timeout("quick") != time.Second {
+		t.Errorf("Expected per-provider timeouts, got %v %v", d.Timeout("slow"), d.Timeout("quick"))
+	}
+	if d := newDispatcher(t, `{}`); d.Timeout("slow") != DefaultTimeout {
+		t.Errorf("Expected the default timeout, got %v", d.Timeout("slow"))
+	}
+}
+
+func TestDispatcher_Execute(t *testing.T) {
+	dev := &slowDevice{id: "lamp", release: make(chan struct{}), err: errors.New("bulb says no")}
+	close(dev.release)
+	d := newDispatcher(t, `{}`, dev)
+
+	if err := d.Execute(context.Background(), device.Command{DeviceID: "lamp", Action: "turn_on"}); err != dev.err {
+		t.Errorf("Expected the device's error, got %v", err)
+	}
+	if err := d.Execute(context.Background(), device.Command{DeviceID: "attic", Action: "turn_on"}); !errors.Is(err, device.ErrDeviceNotFound) {
+		t.Errorf("Expected ErrDeviceNotFound, got %v", err)
+	}
+}
+
+func TestDispatcher_TimeoutRecordsOutcome(t *testing.T) {
+	// The device ignores its context and answers late
+	dev := &slowDevice{id: "lamp", release: make(chan struct{})}
+	d := newDispatcher(t, `{"providers": [{"type": "slow", "command_timeout": "20ms"}]}`, dev)
+
+	start := time.Now()
+	err := d.Execute(context.Background(), device.Command{DeviceID: "lamp", Action: "turn_on"})
+	var timeout *TimeoutError
+	if !errors.As(err, &timeout) || !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Expected a TimeoutError, got %v", err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("Expected to give up after the timeout, took %v", took)
+	}
+
+	cmd, ok := d.TimedOut(timeout.ID)
+	if !ok || cmd.Status != StatusPending || cmd.Provider != "slow" || cmd.Timeout.Duration() != 20*time.Millisecond {
+		t.Fatalf("Expected a pending command, got %+v", cmd)
+	}
+
+	close(dev.release)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	cmd, _ = d.Wait(ctx, timeout.ID)
+	if cmd.Status != StatusSucceeded || cmd.Finished.IsZero() || cmd.Err() != nil {
+		t.Errorf("Expected the late answer to be recorded, got %+v", cmd)
+	}
+	if list := d.TimedOutCommands(); len(list) != 1 || list[0].ID != timeout.ID {
+		t.Errorf("Expected the command in the list, got %+v", list)
+	}
+	if _, ok := d.Wait(ctx, timeout.ID+1); ok {
+		t.Error("Expected Wait to know nothing of an unknown command")
+	}
+}
+
+func TestDispatcher_ExecuteSettled(t *testing.T) {
+	// The device ignores its context and answers late
+	dev := &slowDevice{id: "lamp", release: make(chan struct{}), err: errors.New("bulb says no")}
+	d := newDispatcher(t, `{}`, dev)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	settled, err := d.ExecuteSettled(ctx, device.Command{DeviceID: "lamp", Action: "turn_on"})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	select {
+	case err := <-settled:
+		t.Fatalf("Expected no answer before the device gives one, got %v", err)
+	default:
+	}
+
+	close(dev.release)
+	select {
+	case err := <-settled:
+		if err != dev.err {
+			t.Errorf("Expected the device's own answer, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the device's answer once it came")
+	}
+}
+
+func TestDispatcher_CallerCancels(t *testing.T) {
+	dev := &slowDevice{id: "lamp", honours: true, release: make(chan struct{}), cancelled: make(chan error, 1)}
+	d := newDispatcher(t, `{}`, dev)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if err := d.Execute(ctx, device.Command{DeviceID: "lamp", Action: "turn_on"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	select {
+	case err := <-dev.cancelled:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the device to see the cancellation, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the cancellation to reach the device")
+	}
+	if list := d.TimedOutCommands(); len(list) != 0 {
+		t.Errorf("Expected a cancelled command not to count as timed out, got %+v", list)
+	}
+}
+
+func TestDispatcher_StateTimesOut(t *testing.T) {
+	dev := &slowDevice{id: "lamp", hangState: true}
+	d := newDispatcher(t, `{"providers": [{"type": "slow", "command_timeout": "20ms"}]}`, dev)
+
+	start := time.Now()
+	if _, err := d.State(context.Background(), "lamp"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the state read to time out, got %v", err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("Expected to give up after the provider timeout, took %v", took)
+	}
+}
@@ -0,0 +1,335 @@
+// Package idempotency lets clients retry requests safely. A request sent with an
+// Idempotency-Key header runs once; its response is kept and replayed to retries with
+// the same key from the same client, for as long as the key is kept. A retry that
+// arrives while the first request is still running waits for it.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+)
+
+const (
+	Header = "Idempotency-Key"
+	// ReplayedHeader is set on responses replayed from the store
+	ReplayedHeader = "Idempotent-Replayed"
+	// ClientHeader names the client a key belongs to. Without it the client is its IP
+	// address, which a phone moving between networks doesn't keep. It is taken at its
+	// word: it keeps clients that pick the same keys apart, but any client can send
+	// another's ID.
+	ClientHeader = "X-Client-ID"
+
+	DefaultTTL              = 24 * time.Hour
+	DefaultMaxKeys          = 10000
+	DefaultMaxKeysPerClient = 1000
+
+	maxKeyLength = 255
+	maxBody      = 1 << 20
+)
+
+type Store struct {
+	ttl          time.Duration
+	maxKeys      int
+	maxPerClient int
+
+	mu        sync.Mutex
+	entries   map[scope]*entry
+	order     []*entry // oldest first
+	perClient map[string]int
+}
+
+// scope is a key as one client used it, so clients picking the same keys don't get
+// each other's responses.
+type scope struct {
+	client, key string
+}
+
+type entry struct {
+	scope
+	// fingerprint tells a retry from another request reusing the key
+	fingerprint [sha256.Size]byte
+	// done is closed once the first request has finished, and response set if it is
+	// to be replayed
+	done     chan struct{}
+	response *response
+	expires  time.Time
+}
+
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// NewStore creates a store with the limits from cfg, defaults filled in.
+func NewStore(cfg config.Idempotency) *Store {
+	s := &Store{
+		ttl:          DefaultTTL,
+		maxKeys:      DefaultMaxKeys,
+		maxPerClient: DefaultMaxKeysPerClient,
+		entries:      make(map[scope]*entry),
+		perClient:    make(map[string]int),
+	}
+	if cfg.TTL > 0 {
+		s.ttl = cfg.TTL.Duration()
+	}
+	if cfg.MaxKeys > 0 {
+		s.maxKeys = cfg.MaxKeys
+	}
+	if cfg.MaxKeysPerClient > 0 {
+		s.maxPerClient = cfg.MaxKeysPerClient
+	}
+	return s
+}
+
+// Middleware runs requests that change something (POST, PUT, PATCH, DELETE) and carry
+// an Idempotency-Key at most once per key. Reusing a key for a different request is a
+// 422. Such requests run to the end even if their client hangs up, so its retry gets
+// the outcome. Responses are replayed whatever their status, except a 503, when
+// nothing was carried out (a provider backing off, too many jobs), and a 504, when the
+// outcome isn't known; then the retry runs. A handler that will know the outcome later
+// says so with SettleLater. A nil store runs every request.
+func (s *Store) Middleware(next http.Handler) http.Handler {
+	if s == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		if key == "" || !changes(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxKeyLength {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+		if err != nil {
+			http.Error(w, "Request body is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := sha256.Sum256(bytes.Join([][]byte{[]byte(r.Method), []byte(r.URL.RequestURI()), body}, []byte{0}))
+
+		id := scope{client: clientOf(r), key: key}
+		for {
+			e, first := s.begin(id, fingerprint)
+			if e.fingerprint != fingerprint {
+				http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+				return
+			}
+			if first {
+				s.run(e, next, w, r)
+				return
+			}
+
+			select {
+			case <-e.done:
+			case <-r.Context().Done():
+				return
+			}
+			if e.response != nil {
+				e.response.replay(w)
+				return
+			}
+			// The first request wasn't kept; this one takes its place
+		}
+	})
+}
+
+// run runs the first request with a key, detached from its client, and keeps its
+// response or the one settled later.
+func (s *Store) run(e *entry, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	rec := &recorder{ResponseWriter: w}
+	p := &pending{}
+	ctx := context.WithValue(context.WithoutCancel(r.Context()), pendingKey{}, p)
+	next.ServeHTTP(rec, r.WithContext(ctx))
+
+	if p.outcome == nil {
+		s.finish(e, rec)
+		return
+	}
+	go func() {
+		rec := &recorder{ResponseWriter: discard(http.Header{})}
+		p.outcome(rec)
+		s.finish(e, rec)
+	}()
+}
+
+type pendingKey struct{}
+
+// pending is how a request's outcome is settled after its handler has answered.
+type pending struct {
+	outcome func(w http.ResponseWriter)
+}
+
+// SettleLater is for a handler whose answer doesn't settle its request, like a
+// command that timed out but may still go through. If the request has an
+// Idempotency-Key, the key is kept pending after the handler returns, with retries
+// waiting, until outcome has written the response to replay instead. outcome is
+// called once the handler has returned.
+func SettleLater(r *http.Request, outcome func(w http.ResponseWriter)) {
+	if p, ok := r.Context().Value(pendingKey{}).(*pending); ok {
+		p.outcome = outcome
+	}
+}
+
+// begin returns the entry for a key, and whether it is new and so the request is to
+// be run.
+func (s *Store) begin(id scope, fingerprint [sha256.Size]byte) (*entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if e, ok := s.entries[id]; ok && !e.expired(now) {
+		return e, false
+	}
+	s.prune(now, id.client)
+	e := &entry{scope: id, fingerprint: fingerprint, done: make(chan struct{})}
+	s.entries[id] = e
+	s.order = append(s.order, e)
+	s.perClient[id.client]++
+	return e, true
+}
+
+// finish keeps the response for replays, or drops the key if it isn't to be
+// replayed, and lets waiting retries go on.
+func (s *Store) finish(e *entry, rec *recorder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer close(e.done)
+	switch rec.status {
+	case 0, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		if s.entries[e.scope] == e {
+			s.remove(e)
+		}
+		return
+	}
+	e.response = &response{status: rec.status, header: rec.header, body: rec.body.Bytes()}
+	e.expires = time.Now().Add(s.ttl)
+}
+
+// prune drops expired keys, then the oldest keys while there is no room for another
+// in all or for the client. Keys whose request is still running are never dropped, as
+// their retries are waiting on them; there can be more keys than the limits while
+// they run. Must be called with mu held.
+func (s *Store) prune(now time.Time, client string) {
+	excess := len(s.order) - s.maxKeys + 1
+	clientExcess := s.perClient[client] - s.maxPerClient + 1
+	kept := s.order[:0]
+	for _, e := range s.order {
+		running := e.response == nil
+		if !running && (e.expired(now) || excess > 0 || (clientExcess > 0 && e.client == client)) {
+			excess--
+			if e.client == client {
+				clientExcess--
+			}
+			s.drop(e)
+			continue
+		}
+		kept = append(kept, e)
+	}
+	clear(s.order[len(kept):])
+	s.order = kept
+}
+
+// remove drops an entry and takes it out of the order. Must be called with mu held.
+func (s *Store) remove(e *entry) {
+	for i, o := range s.order {
+		if o == e {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	s.drop(e)
+}
+
+// drop forgets an entry but leaves the order to the caller. Must be called with mu
+// held.
+func (s *Store) drop(e *entry) {
+	delete(s.entries, e.scope)
+	if s.perClient[e.client]--; s.perClient[e.client] <= 0 {
+		delete(s.perClient, e.client)
+	}
+}
+
+// expired is whether a finished request's response has been kept long enough. Must
+// be called with mu held.
+func (e *entry) expired(now time.Time) bool {
+	return e.response != nil && now.After(e.expires)
+}
+
+// Len is how many keys are kept.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.order)
+}
+
+func changes(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func clientOf(r *http.Request) string {
+	if client := r.Header.Get(ClientHeader); client != "" {
+		return "id:" + client
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// recorder passes a response through to the client and keeps a copy of it.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+		r.header = r.ResponseWriter.Header().Clone()
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// discard takes a response settled after the client got its answer, for the recorder
+// to keep.
+type discard http.Header
+
+func (d discard) Header() http.Header       { return http.Header(d) }
+func (discard) Write(b []byte) (int, error) { return len(b), nil }
+func (discard) WriteHeader(int)             {}
+
+func (resp *response) replay(w http.ResponseWriter) {
+	for name, values := range resp.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(resp.status)
+	w.Write(resp.body)
+}
@@ -0,0 +1,323 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+)
+
+// toggle is a handler that counts the requests it runs, answering each with the count.
+// A body of "busy" answers 503 and "slow" 504. "hang" waits for release first, giving
+// up without an answer if the client goes, and "late" answers 504 and settles once
+// released.
+type toggle struct {
+	runs    atomic.Int32
+	release chan struct{}
+}
+
+func newToggle() *toggle {
+	return &toggle{release: make(chan struct{})}
+}
+
+func (h *toggle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	n := h.runs.Add(1)
+	switch string(body) {
+	case "busy":
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+		return
+	case "slow":
+		http.Error(w, "timed out", http.StatusGatewayTimeout)
+		return
+	case "hang":
+		select {
+		case <-h.release:
+		case <-r.Context().Done():
+			return
+		}
+	case "late":
+		SettleLater(r, func(w http.ResponseWriter) {
+			<-h.release
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, "run %d", n)
+		})
+		http.Error(w, "timed out", http.StatusGatewayTimeout)
+		return
+	}
+	w.Header().Set("X-Run", fmt.Sprint(n))
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "run %d", n)
+}
+
+func send(t *testing.T, h http.Handler, method, key, client, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, "/devices/lamp/command", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+	if client != "" {
+		req.Header.Set(ClientHeader, client)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestStore_Replays(t *testing.T) {
+	next := newToggle()
+	h := NewStore(config.Idempotency{}).Middleware(next)
+
+	first := send(t, h, http.MethodPost, "k1", "phone", "on")
+	again := send(t, h, http.MethodPost, "k1", "phone", "on")
+	if next.runs.Load() != 1 {
+		t.Fatalf("Expected the request to run once, ran %d times", next.runs.Load())
+	}
+	if again.Code != http.StatusCreated || again.Body.String() != "run 1" || again.Header().Get("X-Run") != "1" {
+		t.Errorf("Expected the first response replayed, got %d %q %v", again.Code, again.Body.String(), again.Header())
+	}
+	if first.Header().Get(ReplayedHeader) != "" || again.Header().Get(ReplayedHeader) != "true" {
+		t.Error("Expected only the replay to be marked as one")
+	}
+
+	// Other keys, other clients, requests without a key and reads all run
+	send(t, h, http.MethodPost, "k2", "phone", "on")
+	send(t, h, http.MethodPost, "k1", "tablet", "on")
+	send(t, h, http.MethodPost, "", "phone", "on")
+	send(t, h, http.MethodPost, "", "phone", "on")
+	send(t, h, http.MethodGet, "k1", "phone", "")
+	if next.runs.Load() != 6 {
+		t.Errorf("Expected 6 runs, got %d", next.runs.Load())
+	}
+}
+
+func TestStore_ClientByAddress(t *testing.T) {
+	next := newToggle()
+	h := NewStore(config.Idempotency{}).Middleware(next)
+
+	for _, addr := range []string{"10.0.0.5:1234", "10.0.0.5:5678", "10.0.0.6:1234"} {
+		req := httptest.NewRequest(http.MethodPost, "/devices/lamp/command", strings.NewReader("on"))
+		req.RemoteAddr = addr
+		req.Header.Set(Header, "k1")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if next.runs.Load() != 2 {
+		t.Errorf("Expected one run per address, got %d", next.runs.Load())
+	}
+}
+
+func TestStore_KeyReused(t *testing.T) {
+	next := newToggle()
+	h := NewStore(config.Idempotency{}).Middleware(next)
+
+	send(t, h, http.MethodPost, "k1", "phone", "on")
+	if w := send(t, h, http.MethodPost, "k1", "phone", "off"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for a key reused with another body, got %d", w.Code)
+	}
+	if w := send(t, h, http.MethodDelete, "k1", "phone", "on"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for a key reused with another method, got %d", w.Code)
+	}
+	if next.runs.Load() != 1 {
+		t.Errorf("Expected the request to run once, ran %d times", next.runs.Load())
+	}
+}
+
+func TestStore_Unavailable(t *testing.T) {
+	next := newToggle()
+	s := NewStore(config.Idempotency{})
+	h := s.Middleware(next)
+
+	send(t, h, http.MethodPost, "k1", "phone", "busy")
+	if w := send(t, h, http.MethodPost, "k1", "phone", "busy"); w.Header().Get(ReplayedHeader) != "" {
+		t.Error("Expected a 503 not to be replayed")
+	}
+	if next.runs.Load() != 2 || s.Len() != 0 {
+		t.Errorf("Expected both requests to run and no key kept, got %d runs and %d keys", next.runs.Load(), s.Len())
+	}
+
+	// A command that timed out may yet go through, so its outcome isn't kept either
+	send(t, h, http.MethodPost, "k2", "phone", "slow")
+	if w := send(t, h, http.MethodPost, "k2", "phone", "slow"); w.Header().Get(ReplayedHeader) != "" {
+		t.Error("Expected a 504 not to be replayed")
+	}
+	if next.runs.Load() != 4 || s.Len() != 0 {
+		t.Errorf("Expected both requests to run and no key kept, got %d runs and %d keys", next.runs.Load(), s.Len())
+	}
+}
+
+func TestStore_ClientHangsUp(t *testing.T) {
+	next := newToggle()
+	s := NewStore(config.Idempotency{})
+	h := s.Middleware(next)
+
+	ctx, hangUp := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodPost, "/devices/lamp/command", strings.NewReader("hang")).WithContext(ctx)
+	req.Header.Set(Header, "k1")
+	first := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		close(first)
+	}()
+	for s.Len() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	hangUp()
+
+	// The request carries on without its client, and the retry gets its outcome
+	retry := make(chan *httptest.ResponseRecorder)
+	go func() { retry <- send(t, h, http.MethodPost, "k1", "", "hang") }()
+	time.Sleep(20 * time.Millisecond)
+	close(next.release)
+	<-first
+	if w := <-retry; w.Body.String() != "run 1" || w.Header().Get(ReplayedHeader) != "true" {
+		t.Errorf("Expected the retry to get the first outcome, got %d %q", w.Code, w.Body.String())
+	}
+	if next.runs.Load() != 1 {
+		t.Errorf("Expected the request to run once, ran %d times", next.runs.Load())
+	}
+}
+
+func TestStore_SettledLater(t *testing.T) {
+	next := newToggle()
+	s := NewStore(config.Idempotency{})
+	h := s.Middleware(next)
+
+	if w := send(t, h, http.MethodPost, "k1", "phone", "late"); w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("Expected the client to get the 504, got %d", w.Code)
+	}
+
+	// The key stays pending until the outcome is known, and then that is replayed
+	retry := make(chan *httptest.ResponseRecorder)
+	go func() { retry <- send(t, h, http.MethodPost, "k1", "phone", "late") }()
+	time.Sleep(20 * time.Millisecond)
+	close(next.release)
+	if w := <-retry; w.Code != http.StatusCreated || w.Body.String() != "run 1" || w.Header().Get(ReplayedHeader) != "true" {
+		t.Errorf("Expected the settled outcome replayed, got %d %q", w.Code, w.Body.String())
+	}
+	if next.runs.Load() != 1 || s.Len() != 1 {
+		t.Errorf("Expected one run and its key kept, got %d runs and %d keys", next.runs.Load(), s.Len())
+	}
+}
+
+func TestStore_Nil(t *testing.T) {
+	next := newToggle()
+	var s *Store
+	h := s.Middleware(next)
+
+	send(t, h, http.MethodPost, "k1", "phone", "on")
+	send(t, h, http.MethodPost, "k1", "phone", "on")
+	if next.runs.Load() != 2 {
+		t.Errorf("Expected both requests to run without a store, ran %d", next.runs.Load())
+	}
+}
+
+func TestStore_Concurrent(t *testing.T) {
+	next := newToggle()
+	h := NewStore(config.Idempotency{}).Middleware(next)
+
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, 5)
+	for i := range responses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i] = send(t, h, http.MethodPost, "k1", "phone", "hang")
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(next.release)
+	wg.Wait()
+
+	if next.runs.Load() != 1 {
+		t.Fatalf("Expected the request to run once, ran %d times", next.runs.Load())
+	}
+	for _, w := range responses {
+		if w.Code != http.StatusCreated || w.Body.String() != "run 1" {
+			t.Errorf("Expected every retry to get the first response, got %d %q", w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestStore_Expiry(t *testing.T) {
+	next := newToggle()
+	s := NewStore(config.Idempotency{TTL: config.Duration(20 * time.Millisecond)})
+	h := s.Middleware(next)
+
+	send(t, h, http.MethodPost, "k1", "phone", "on")
+	send(t, h, http.MethodPost, "k1", "phone", "on")
+	time.Sleep(30 * time.Millisecond)
+	if w := send(t, h, http.MethodPost, "k1", "phone", "on"); w.Body.String() != "run 2" {
+		t.Errorf("Expected an expired key to run again, got %q", w.Body.String())
+	}
+	if s.Len() != 1 {
+		t.Errorf("Expected the expired key replaced, got %d keys", s.Len())
+	}
+}
+
+func TestStore_Bounded(t *testing.T) {
+	next := newToggle()
+	s := NewStore(config.Idempotency{MaxKeys: 5, MaxKeysPerClient: 3})
+	h := s.Middleware(next)
+
+	for i := range 4 {
+		send(t, h, http.MethodPost, fmt.Sprint("k", i), "phone", "on")
+	}
+	if s.Len() != 3 {
+		t.Fatalf("Expected 3 keys for the client, got %d", s.Len())
+	}
+	// The client's oldest key made way
+	if w := send(t, h, http.MethodPost, "k0", "phone", "on"); w.Header().Get(ReplayedHeader) != "" {
+		t.Error("Expected the oldest key to be forgotten")
+	}
+	if w := send(t, h, http.MethodPost, "k3", "phone", "on"); w.Header().Get(ReplayedHeader) != "true" {
+		t.Error("Expected the newest key to be kept")
+	}
+
+	// Another client's keys push out the oldest of any client's past MaxKeys
+	for i := range 3 {
+		send(t, h, http.MethodPost, fmt.Sprint("k", i), "tablet", "on")
+	}
+	if s.Len() != 5 {
+		t.Errorf("Expected 5 keys in all, got %d", s.Len())
+	}
+	if w := send(t, h, http.MethodPost, "k2", "phone", "on"); w.Header().Get(ReplayedHeader) != "" {
+		t.Error("Expected the oldest key of all to be forgotten")
+	}
+}
+
+func TestStore_KeepsRunningKeys(t *testing.T) {
+	next := newToggle()
+	s := NewStore(config.Idempotency{MaxKeys: 2})
+	h := s.Middleware(next)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- send(t, h, http.MethodPost, "k0", "phone", "hang") }()
+	for s.Len() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Keys past the limit push out finished keys, never the running one
+	for i := 1; i <= 3; i++ {
+		send(t, h, http.MethodPost, fmt.Sprint("k", i), "phone", "on")
+	}
+	if s.Len() != 2 {
+		t.Errorf("Expected the running key and the newest, got %d keys", s.Len())
+	}
+
+	retry := make(chan *httptest.ResponseRecorder)
+	go func() { retry <- send(t, h, http.MethodPost, "k0", "phone", "hang") }()
+	time.Sleep(20 * time.Millisecond)
+	close(next.release)
+	first, again := <-done, <-retry
+	if first.Body.String() != "run 1" || again.Body.String() != "run 1" || again.Header().Get(ReplayedHeader) != "true" {
+		t.Errorf("Expected the retry to wait for the running request, got %q and %q", first.Body.String(), again.Body.String())
+	}
+}
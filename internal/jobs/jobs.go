@@ -0,0 +1,315 @@
+// Package jobs runs commands in the background for callers that can't wait for them
+// within an HTTP request: long fades, a command to every bulb in the house. A job
+// sends its commands through the dispatcher and can be polled for its progress and
+// the result for each device, or cancelled.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+)
+
+const (
+	DefaultCommandTimeout = 5 * time.Minute
+	DefaultRetention      = time.Hour
+	DefaultMaxJobs        = 1000
+	DefaultConcurrency    = 8
+)
+
+var (
+	ErrNotFound = errors.New("job not found")
+	// ErrTooManyJobs is returned when MaxJobs jobs are all still running
+	ErrTooManyJobs = errors.New("too many jobs running")
+	// ErrClosed is returned by Submit once the manager is closed
+	ErrClosed = errors.New("job manager closed")
+)
+
+// Job and result statuses. A job is running until every command is done; it then
+// succeeded if they all did, was cancelled if any didn't get to finish and failed
+// otherwise.
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+type Job struct {
+	ID       string    `json:"id"`
+	Status   string    `json:"status"`
+	Created  time.Time `json:"created"`
+	Finished time.Time `json:"finished,omitzero"`
+	// Total and Done count the commands, for progress
+	Total   int      `json:"total"`
+	Done    int      `json:"done"`
+	Results []Result `json:"results"`
+}
+
+// Result is how one command of a job went.
+type Result struct {
+	Device   device.ID      `json:"device"`
+	Action   string         `json:"action"`
+	Params   map[string]any `json:"params,omitempty"`
+	Status   string         `json:"status"`
+	Error    string         `json:"error,omitempty"`
+	Started  time.Time      `json:"started,omitzero"`
+	Finished time.Time      `json:"finished,omitzero"`
+}
+
+// Executor sends a command to its device; dispatch.Dispatcher does.
+type Executor interface {
+	ExecuteWithin(ctx context.Context, cmd device.Command, timeout time.Duration) error
+}
+
+type Manager struct {
+	executor       Executor
+	commandTimeout time.Duration
+	retention      time.Duration
+	maxJobs        int
+	concurrency    int
+
+	mu      sync.Mutex
+	jobs    map[string]*job
+	order   []*job // oldest first
+	closed  bool
+	running sync.WaitGroup
+}
+
+type job struct {
+	Job
+	cancel context.CancelFunc
+}
+
+// NewManager creates a job manager with the limits from cfg, defaults filled in.
+func NewManager(executor Executor, cfg config.Jobs) *Manager {
+	m := &Manager{
+		executor:       executor,
+		commandTimeout: DefaultCommandTimeout,
+		retention:      DefaultRetention,
+		maxJobs:        DefaultMaxJobs,
+		concurrency:    DefaultConcurrency,
+		jobs:           make(map[string]*job),
+	}
+	if cfg.CommandTimeout > 0 {
+		m.commandTimeout = cfg.CommandTimeout.Duration()
+	}
+	if cfg.Retention > 0 {
+		m.retention = cfg.Retention.Duration()
+	}
+	if cfg.MaxJobs > 0 {
+		m.maxJobs = cfg.MaxJobs
+	}
+	if cfg.Concurrency > 0 {
+		m.concurrency = cfg.Concurrency
+	}
+	return m
+}
+
+// Submit starts a job running the commands, and returns it as it starts.
+func (m *Manager) Submit(cmds []device.Command) (Job, error) {
+	if len(cmds) == 0 {
+		return Job{}, errors.New("a job needs at least one command")
+	}
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return Job{}, ErrClosed
+	}
+	m.prune(time.Now(), 1)
+	if len(m.order) >= m.maxJobs {
+		m.mu.Unlock()
+		return Job{}, ErrTooManyJobs
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		Job: Job{
+			ID:      newID(),
+			Status:  StatusRunning,
+			Created: time.Now(),
+			Total:   len(cmds),
+			Results: make([]Result, len(cmds)),
+		},
+		cancel: cancel,
+	}
+	for i, cmd := range cmds {
+		j.Results[i] = Result{Device: cmd.DeviceID, Action: cmd.Action, Params: cmd.Params, Status: StatusPending}
+	}
+	m.jobs[j.ID] = j
+	m.order = append(m.order, j)
+	snapshot := j.snapshot()
+	m.running.Add(1)
+	m.mu.Unlock()
+
+	go func() {
+		defer m.running.Done()
+		m.run(ctx, j, cmds)
+	}()
+	return snapshot, nil
+}
+
+// run sends the commands, concurrency at a time, and settles the job's status.
+func (m *Manager) run(ctx context.Context, j *job, cmds []device.Command) {
+	defer j.cancel()
+
+	sem := make(chan struct{}, m.concurrency)
+	var wg sync.WaitGroup
+	for i, cmd := range cmds {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			m.update(func() {
+				j.Results[i].Status = StatusRunning
+				j.Results[i].Started = time.Now()
+			})
+			err := m.executor.ExecuteWithin(ctx, cmd, m.commandTimeout)
+			m.update(func() {
+				r := &j.Results[i]
+				r.Finished = time.Now()
+				switch {
+				case err == nil:
+					r.Status = StatusSucceeded
+				case ctx.Err() != nil:
+					r.Status = StatusCancelled
+					r.Error = err.Error()
+				default:
+					r.Status = StatusFailed
+					r.Error = err.Error()
+				}
+				j.Done++
+			})
+		}()
+	}
+	wg.Wait()
+
+	m.update(func() {
+		j.Finished = time.Now()
+		j.Status = StatusSucceeded
+		for i := range j.Results {
+			r := &j.Results[i]
+			if r.Status == StatusPending {
+				r.Status = StatusCancelled
+			}
+			switch {
+			case r.Status == StatusCancelled:
+				j.Status = StatusCancelled
+			case r.Status == StatusFailed && j.Status != StatusCancelled:
+				j.Status = StatusFailed
+			}
+		}
+	})
+}
+
+func (m *Manager) update(fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fn()
+}
+
+// Get returns a job as it stands.
+func (m *Manager) Get(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prune(time.Now(), 0)
+	j, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return j.snapshot(), nil
+}
+
+// List returns the jobs kept, newest first.
+func (m *Manager) List() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prune(time.Now(), 0)
+	list := make([]Job, 0, len(m.order))
+	for i := len(m.order) - 1; i >= 0; i-- {
+		list = append(list, m.order[i].snapshot())
+	}
+	return list
+}
+
+// Cancel stops a running job: commands not yet sent are skipped and those in flight
+// have their context cancelled. The job is returned as it stands; it is cancelled
+// once the commands in flight are done. A finished job is left as it is.
+func (m *Manager) Cancel(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	j.cancel()
+	return j.snapshot(), nil
+}
+
+// Close cancels every running job and waits for their commands in flight to return,
+// or for ctx to be done. Jobs can't be submitted afterwards.
+func (m *Manager) Close(ctx context.Context) error {
+	m.mu.Lock()
+	m.closed = true
+	for _, j := range m.jobs {
+		j.cancel()
+	}
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// prune drops finished jobs past their retention, and the oldest finished jobs while
+// there are more than maxJobs less room. Must be called with mu held.
+func (m *Manager) prune(now time.Time, room int) {
+	excess := len(m.order) - m.maxJobs + room
+	kept := m.order[:0]
+	for _, j := range m.order {
+		finished := !j.Finished.IsZero()
+		if finished && (now.Sub(j.Finished) > m.retention || excess > 0) {
+			delete(m.jobs, j.ID)
+			excess--
+			continue
+		}
+		kept = append(kept, j)
+	}
+	clear(m.order[len(kept):])
+	m.order = kept
+}
+
+// snapshot copies the job for callers. Must be called with mu held.
+func (j *job) snapshot() Job {
+	s := j.Job
+	s.Results = append([]Result(nil), j.Results...)
+	return s
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,204 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+)
+
+// fakeExecutor fails commands to devices in fail and holds commands to devices in
+// hold until released or cancelled.
+type fakeExecutor struct {
+	fail    map[device.ID]bool
+	hold    map[device.ID]bool
+	release chan struct{}
+
+	mu       sync.Mutex
+	running  int
+	maxSeen  int
+	timeouts []time.Duration
+}
+
+func newFakeExecutor() *fakeExecutor {
+	return &fakeExecutor{fail: map[device.ID]bool{}, hold: map[device.ID]bool{}, release: make(chan struct{})}
+}
+
+func (e *fakeExecutor) ExecuteWithin(ctx context.Context, cmd device.Command, timeout time.Duration) error {
+	e.mu.Lock()
+	e.running++
+	e.maxSeen = max(e.maxSeen, e.running)
+	e.timeouts = append(e.timeouts, timeout)
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		e.running--
+		e.mu.Unlock()
+	}()
+
+	if e.hold[cmd.DeviceID] {
+		select {
+		case <-e.release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	} else {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if e.fail[cmd.DeviceID] {
+		return errors.New("bulb says no")
+	}
+	return nil
+}
+
+func commands(ids ...device.ID) []device.Command {
+	cmds := make([]device.Command, len(ids))
+	for i, id := range ids {
+		cmds[i] = device.Command{DeviceID: id, Action: "turn_on"}
+	}
+	return cmds
+}
+
+func wait(t *testing.T, m *Manager, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := m.Get(id)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if !job.Finished.IsZero() {
+			return job
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	t.Fatal("Expected the job to finish")
+	return Job{}
+}
+
+func TestManager_RunsJob(t *testing.T) {
+	exec := newFakeExecutor()
+	exec.fail["bulb-3"] = true
+	m := NewManager(exec, config.Jobs{Concurrency: 2, CommandTimeout: config.Duration(time.Minute)})
+
+	job, err := m.Submit(commands("bulb-1", "bulb-2", "bulb-3", "bulb-4", "bulb-5", "bulb-6"))
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if job.Status != StatusRunning || job.Total != 6 || job.ID == "" {
+		t.Errorf("Expected a running job of 6, got %+v", job)
+	}
+
+	job = wait(t, m, job.ID)
+	if job.Status != StatusFailed || job.Done != 6 {
+		t.Errorf("Expected a failed job with every command done, got %+v", job)
+	}
+	for _, r := range job.Results {
+		want := StatusSucceeded
+		if r.Device == "bulb-3" {
+			want = StatusFailed
+		}
+		if r.Status != want || r.Finished.IsZero() {
+			t.Errorf("Expected %s %s, got %+v", r.Device, want, r)
+		}
+	}
+	if exec.maxSeen > 2 {
+		t.Errorf("Expected at most 2 commands at once, saw %d", exec.maxSeen)
+	}
+	if exec.timeouts[0] != time.Minute {
+		t.Errorf("Expected the job command timeout, got %v", exec.timeouts[0])
+	}
+
+	delete(exec.fail, "bulb-3")
+	job, _ = m.Submit(commands("bulb-3"))
+	if job = wait(t, m, job.ID); job.Status != StatusSucceeded {
+		t.Errorf("Expected the job to succeed, got %+v", job)
+	}
+	if _, err := m.Submit(nil); err == nil {
+		t.Error("Expected an empty job to be refused")
+	}
+}
+
+func TestManager_Cancel(t *testing.T) {
+	exec := newFakeExecutor()
+	exec.hold["slow"] = true
+	m := NewManager(exec, config.Jobs{Concurrency: 1})
+
+	job, _ := m.Submit(commands("slow", "next"))
+	time.Sleep(10 * time.Millisecond)
+	if _, err := m.Cancel(job.ID); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	job = wait(t, m, job.ID)
+	if job.Status != StatusCancelled || job.Results[0].Status != StatusCancelled || job.Results[1].Status != StatusCancelled {
+		t.Errorf("Expected the job and both commands cancelled, got %+v", job)
+	}
+	if job.Done != 1 {
+		t.Errorf("Expected only the command in flight to be done, got %d", job.Done)
+	}
+	if _, err := m.Cancel("nope"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestManager_Close(t *testing.T) {
+	exec := newFakeExecutor()
+	exec.hold["slow"] = true
+	m := NewManager(exec, config.Jobs{})
+
+	job, _ := m.Submit(commands("slow", "bulb"))
+	time.Sleep(10 * time.Millisecond)
+	if err := m.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	// The held command only returns once cancelled, so the job settled because of Close
+	if job, _ = m.Get(job.ID); job.Status != StatusCancelled || job.Finished.IsZero() {
+		t.Errorf("Expected the job cancelled by the time Close returns, got %+v", job)
+	}
+	if _, err := m.Submit(commands("bulb")); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestManager_Retention(t *testing.T) {
+	exec := newFakeExecutor()
+	exec.hold["slow"] = true
+	exec.hold["slow-2"] = true
+	m := NewManager(exec, config.Jobs{MaxJobs: 2, Retention: config.Duration(50 * time.Millisecond)})
+
+	first, _ := m.Submit(commands("bulb"))
+	wait(t, m, first.ID)
+	slow, _ := m.Submit(commands("slow"))
+
+	// The oldest finished job makes way
+	third, err := m.Submit(commands("bulb"))
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if _, err := m.Get(first.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the oldest job to be dropped, got %v", err)
+	}
+	if list := m.List(); len(list) != 2 || list[0].ID != third.ID {
+		t.Errorf("Expected the 2 newest jobs, newest first, got %+v", list)
+	}
+
+	// Running jobs are never dropped
+	wait(t, m, third.ID)
+	fourth, _ := m.Submit(commands("slow-2"))
+	if _, err := m.Submit(commands("bulb")); !errors.Is(err, ErrTooManyJobs) {
+		t.Errorf("Expected ErrTooManyJobs with every job running, got %v", err)
+	}
+
+	// Finished jobs expire
+	close(exec.release)
+	wait(t, m, slow.ID)
+	wait(t, m, fourth.ID)
+	time.Sleep(60 * time.Millisecond)
+	if list := m.List(); len(list) != 0 {
+		t.Errorf("Expected finished jobs to expire, got %+v", list)
+	}
+}
@@ -0,0 +1,420 @@
+// Package mqttbridge publishes every device in the registry to MQTT and accepts
+// commands for them, with Home Assistant discovery configs so they show up there
+// without manual setup.
+//
+// Topics, with the default base topic:
+//
+//	smarthomehub/status         "online"/"offline" (retained, offline is the will)
+//	smarthomehub/<id>/state     device attributes as JSON (retained)
+//	smarthomehub/<id>/availability "online"/"offline" (retained)
+//	smarthomehub/<id>/set       {"action": "...", "params": {...}}
+package mqttbridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/mqttclient"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+)
+
+func init() {
+	provider.RegisterFactory("mqtt_bridge", NewBridge)
+}
+
+type Settings struct {
+	mqttclient.Settings
+	BaseTopic       string          `json:"base_topic"`
+	DiscoveryPrefix string          `json:"discovery_prefix"`
+	Discovery       *bool           `json:"discovery,omitempty"` // Home Assistant discovery, on by default
+	PollInterval    config.Duration `json:"poll_interval"`
+}
+
+// Bridge is configured like a provider so it is started, stopped and health checked
+// by the provider manager, but it registers no devices of its own.
+type Bridge struct {
+	name     string
+	settings Settings
+	devices  *device.Registry
+
+	// publishMu serializes publishes from the poll loop and the command handler
+	publishMu sync.Mutex
+
+	mu        sync.Mutex
+	client    *mqttclient.Client
+	published map[device.ID]*publishedDevice
+	objects   map[string]device.ID // object id -> device id, for routing /set
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+type publishedDevice struct {
+	state        map[string]any
+	availability string          // last published to the availability topic
+	discovery    map[string]bool // discovery config topics
+}
+
+func NewBridge(name string, raw json.RawMessage, env provider.Env) (provider.Provider, error) {
+	settings := Settings{
+		BaseTopic:       "smarthomehub",
+		DiscoveryPrefix: "homeassistant",
+		PollInterval:    config.Duration(5 * time.Second),
+	}
+	if err := provider.DecodeSettings(raw, &settings); err != nil {
+		return nil, err
+	}
+	if settings.ClientID == "" {
+		settings.ClientID = "smarthomehub-" + name
+	}
+	if settings.PollInterval <= 0 {
+		settings.PollInterval = config.Duration(5 * time.Second)
+	}
+	if env.Devices == nil {
+		return nil, errors.New("mqtt bridge needs the device registry")
+	}
+	return &Bridge{
+		name:      name,
+		settings:  settings,
+		devices:   env.Devices,
+		published: make(map[device.ID]*publishedDevice),
+		objects:   make(map[string]device.ID),
+	}, nil
+}
+
+func (b *Bridge) Name() string {
+	return b.name
+}
+
+func (b *Bridge) statusTopic() string {
+	return b.settings.BaseTopic + "/status"
+}
+
+func (b *Bridge) stateTopic(obj string) string {
+	return b.settings.BaseTopic + "/" + obj + "/state"
+}
+
+func (b *Bridge) availabilityTopic(obj string) string {
+	return b.settings.BaseTopic + "/" + obj + "/availability"
+}
+
+func (b *Bridge) commandTopic(obj string) string {
+	return b.settings.BaseTopic + "/" + obj + "/set"
+}
+
+func (b *Bridge) Start(ctx context.Context, _ provider.Registry) error {
+	if b.settings.Broker == "" {
+		return fmt.Errorf("%w: broker is required", provider.ErrNotConfigured)
+	}
+
+	settings := b.settings.Settings
+	settings.WillTopic = b.statusTopic()
+	settings.WillPayload = "offline"
+
+	client, err := mqttclient.Connect(ctx, settings)
+	if err != nil {
+		return err
+	}
+	if _, err := client.Subscribe(ctx, b.settings.BaseTopic+"/+/set", b.handleCommand); err != nil {
+		client.Close()
+		return err
+	}
+	if err := client.Publish(ctx, b.statusTopic(), []byte("online"), true); err != nil {
+		client.Close()
+		return err
+	}
+
+	loopCtx, cancel := context.WithCancel(context.Background())
+	b.mu.Lock()
+	b.client = client
+	b.cancel = cancel
+	b.done = make(chan struct{})
+	b.mu.Unlock()
+
+	go b.loop(loopCtx)
+	return nil
+}
+
+// Discover publishes the current state of every device straight away instead of
+// waiting for the next poll.
+func (b *Bridge) Discover(ctx context.Context) error {
+	return b.publishAll(ctx)
+}
+
+func (b *Bridge) loop(ctx context.Context) {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.settings.PollInterval.Duration())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.publishAll(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("mqtt bridge: %v", err)
+			}
+		}
+	}
+}
+
+// publishAll publishes devices whose state changed since the last call, discovery
+// configs for new devices and attributes, and removes devices that disappeared.
+func (b *Bridge) publishAll(ctx context.Context) error {
+	devices := b.devices.List()
+
+	var errs []error
+	for id, dev := range devices {
+		if err := b.publishDevice(ctx, id, dev); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", id, err))
+		}
+	}
+
+	b.mu.Lock()
+	var removed []device.ID
+	for id := range b.published {
+		if _, ok := devices[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, id := range removed {
+		if err := b.unpublishDevice(ctx, id); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// publishDevice reads the device's state under publishMu, so an older state can't be
+// published after a newer one.
+func (b *Bridge) publishDevice(ctx context.Context, id device.ID, dev device.Device) error {
+	b.publishMu.Lock()
+	defer b.publishMu.Unlock()
+
+	state, err := dev.State(ctx)
+	if err != nil {
+		if errors.Is(err, device.ErrDeviceUnavailable) {
+			// Unavailable devices keep their last published state but show as offline
+			return b.setAvailability(ctx, id, "offline")
+		}
+		return nil
+	}
+
+	b.mu.Lock()
+	client := b.client
+	pd := b.entry(id)
+	changed := !reflect.DeepEqual(pd.state, state.Attributes)
+	b.mu.Unlock()
+
+	if client == nil {
+		return nil
+	}
+	if !changed {
+		return b.setAvailability(ctx, id, "online")
+	}
+
+	obj := objectID(id)
+	if b.discoveryEnabled() {
+		for _, e := range b.entitiesFor(id, state) {
+			topic := fmt.Sprintf("%s/%s/smarthomehub/%s/config", b.settings.DiscoveryPrefix, e.component, e.objectID)
+			b.mu.Lock()
+			seen := pd.discovery[topic]
+			b.mu.Unlock()
+			if seen {
+				continue
+			}
+			payload, err := json.Marshal(e.config)
+			if err != nil {
+				return err
+			}
+			if err := client.Publish(ctx, topic, payload, true); err != nil {
+				return err
+			}
+			b.mu.Lock()
+			pd.discovery[topic] = true
+			b.mu.Unlock()
+		}
+	}
+
+	payload, err := json.Marshal(state.Attributes)
+	if err != nil {
+		return err
+	}
+	if err := client.Publish(ctx, b.stateTopic(obj), payload, true); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	pd.state = copyAttributes(state.Attributes)
+	b.mu.Unlock()
+	return b.setAvailability(ctx, id, "online")
+}
+
+// entry returns the published record for id, adding one if needed. Must be called
+// with mu held.
+func (b *Bridge) entry(id device.ID) *publishedDevice {
+	pd, ok := b.published[id]
+	if !ok {
+		pd = &publishedDevice{discovery: make(map[string]bool)}
+		b.published[id] = pd
+		b.objects[objectID(id)] = id
+	}
+	return pd
+}
+
+// setAvailability publishes whether the device can be reached, when that changed
+// since the last call. Must be called with publishMu held.
+func (b *Bridge) setAvailability(ctx context.Context, id device.ID, availability string) error {
+	b.mu.Lock()
+	client := b.client
+	pd := b.entry(id)
+	same := pd.availability == availability
+	b.mu.Unlock()
+
+	if client == nil || same {
+		return nil
+	}
+	if err := client.Publish(ctx, b.availabilityTopic(objectID(id)), []byte(availability), true); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	pd.availability = availability
+	b.mu.Unlock()
+	return nil
+}
+
+// unpublishDevice clears the retained messages of a removed device, which also makes
+// Home Assistant drop its entities.
+func (b *Bridge) unpublishDevice(ctx context.Context, id device.ID) error {
+	b.publishMu.Lock()
+	defer b.publishMu.Unlock()
+
+	b.mu.Lock()
+	client := b.client
+	pd := b.published[id]
+	delete(b.published, id)
+	delete(b.objects, objectID(id))
+	b.mu.Unlock()
+
+	if client == nil || pd == nil {
+		return nil
+	}
+	for topic := range pd.discovery {
+		if err := client.Publish(ctx, topic, nil, true); err != nil {
+			return err
+		}
+	}
+	if err := client.Publish(ctx, b.availabilityTopic(objectID(id)), nil, true); err != nil {
+		return err
+	}
+	return client.Publish(ctx, b.stateTopic(objectID(id)), nil, true)
+}
+
+func (b *Bridge) discoveryEnabled() bool {
+	return b.settings.Discovery == nil || *b.settings.Discovery
+}
+
+type commandRequest struct {
+	Action string         `json:"action"`
+	Params map[string]any `json:"params"`
+}
+
+// handleCommand routes <base>/<id>/set messages to the device's Execute.
+func (b *Bridge) handleCommand(topic string, payload []byte) {
+	obj := strings.TrimSuffix(strings.TrimPrefix(topic, b.settings.BaseTopic+"/"), "/set")
+
+	b.mu.Lock()
+	id, ok := b.objects[obj]
+	b.mu.Unlock()
+	if !ok {
+		log.Printf("mqtt bridge: command for unknown device %s", obj)
+		return
+	}
+
+	var req commandRequest
+	if err := json.Unmarshal(payload, &req); err != nil || req.Action == "" {
+		// Plain ON/OFF as sent by simple MQTT clients
+		switch strings.ToUpper(strings.TrimSpace(string(payload))) {
+		case "ON":
+			req = commandRequest{Action: "turn_on"}
+		case "OFF":
+			req = commandRequest{Action: "turn_off"}
+		default:
+			log.Printf("mqtt bridge: invalid command for %s: %q", id, payload)
+			return
+		}
+	}
+
+	dev, err := b.devices.Get(id)
+	if err != nil {
+		log.Printf("mqtt bridge: %s: %v", id, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cmd := device.Command{DeviceID: id, Action: req.Action, Params: req.Params}
+	if err := dev.Execute(ctx, cmd); err != nil {
+		log.Printf("mqtt bridge: %s %s failed: %v", id, req.Action, err)
+		return
+	}
+
+	// Publish the new state right away rather than on the next poll
+	if err := b.publishDevice(ctx, id, dev); err != nil {
+		log.Printf("mqtt bridge: %s: %v", id, err)
+	}
+}
+
+func (b *Bridge) Stop(ctx context.Context) error {
+	b.mu.Lock()
+	cancel, done, client := b.cancel, b.done, b.client
+	b.cancel = nil
+	b.client = nil
+	b.published = make(map[device.ID]*publishedDevice)
+	b.objects = make(map[string]device.ID)
+	b.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+	if client != nil {
+		client.Publish(ctx, b.statusTopic(), []byte("offline"), true)
+		client.Close()
+	}
+	return nil
+}
+
+func (b *Bridge) Health(ctx context.Context) provider.Health {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.client == nil || !b.client.IsConnected() {
+		return provider.Health{Status: provider.HealthDown, Message: "not connected to broker"}
+	}
+	return provider.Health{
+		Status:  provider.HealthOK,
+		Message: fmt.Sprintf("publishing %d devices", len(b.published)),
+	}
+}
+
+func copyAttributes(attrs map[string]any) map[string]any {
+	c := make(map[string]any, len(attrs))
+	for k, v := range attrs {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,260 @@
+package mqttbridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/mqttclient/mqtttest"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/simulator"
+)
+
+func startBridge(t *testing.T, registry *device.Registry) (*Bridge, string) {
+	t.Helper()
+	broker := mqtttest.NewBroker(t)
+
+	raw := []byte(fmt.Sprintf(`{"broker": %q, "poll_interval": "50ms"}`, broker))
+	p, err := NewBridge("bridge", raw, provider.Env{Devices: registry})
+	if err != nil {
+		t.Fatalf("NewBridge failed: %v", err)
+	}
+	b := p.(*Bridge)
+	if err := b.Start(context.Background(), registry); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	t.Cleanup(func() { b.Stop(context.Background()) })
+	return b, broker
+}
+
+// next returns the next message on topic, skipping others.
+func next(t *testing.T, msgs *mqtttest.Messages, topic string) mqtttest.Message {
+	t.Helper()
+	for {
+		msg := msgs.Next(t)
+		if msg.Topic == topic {
+			return msg
+		}
+	}
+}
+
+func TestBridge_PublishesStateAndDiscovery(t *testing.T) {
+	registry := device.NewRegistry()
+	registry.Register(simulator.NewSimulatedDevice("light-1"))
+
+	_, broker := startBridge(t, registry)
+	client := mqtttest.Connect(t, broker)
+	msgs := mqtttest.Subscribe(t, client, "#")
+
+	config := next(t, msgs, "homeassistant/light/smarthomehub/light-1/config")
+	var cfg map[string]any
+	if err := json.Unmarshal([]byte(config.Payload), &cfg); err != nil {
+		t.Fatalf("Invalid discovery config: %v", err)
+	}
+	if cfg["command_topic"] != "smarthomehub/light-1/set" || cfg["state_topic"] != "smarthomehub/light-1/state" {
+		t.Errorf("Unexpected topics in discovery config: %v", cfg)
+	}
+	availability, _ := json.Marshal(cfg["availability"])
+	if want := `[{"topic":"smarthomehub/status"},{"topic":"smarthomehub/light-1/availability"}]`; string(availability) != want || cfg["availability_mode"] != "all" {
+		t.Errorf("Expected availability on the hub and device topics, got %s (%v)", availability, cfg["availability_mode"])
+	}
+
+	state := next(t, msgs, "smarthomehub/light-1/state")
+	var attrs map[string]any
+	json.Unmarshal([]byte(state.Payload), &attrs)
+	if attrs["power"] != "off" {
+		t.Errorf("Expected power off, got %v", attrs["power"])
+	}
+}
+
+func TestBridge_CommandsRouteToExecute(t *testing.T) {
+	registry := device.NewRegistry()
+	dev := simulator.NewSimulatedDevice("light-1")
+	registry.Register(dev)
+
+	_, broker := startBridge(t, registry)
+	client := mqtttest.Connect(t, broker)
+	msgs := mqtttest.Subscribe(t, client, "smarthomehub/light-1/state")
+	msgs.Next(t) // initial state
+
+	ctx := context.Background()
+	client.Publish(ctx, "smarthomehub/light-1/set", []byte(`{"action":"set_brightness","params":{"value":40}}`), false)
+
+	var attrs map[string]any
+	json.Unmarshal([]byte(msgs.Next(t).Payload), &attrs)
+	if attrs["brightness"] != float64(40) {
+		t.Errorf("Expected brightness 40 in published state, got %v", attrs["brightness"])
+	}
+
+	client.Publish(ctx, "smarthomehub/light-1/set", []byte("ON"), false)
+	json.Unmarshal([]byte(msgs.Next(t).Payload), &attrs)
+	if attrs["power"] != "on" {
+		t.Errorf("Expected power on, got %v", attrs["power"])
+	}
+
+	state, _ := dev.State(ctx)
+	if state.Attributes["power"] != "on" || state.Attributes["brightness"] != 40 {
+		t.Errorf("Device state not updated: %v", state.Attributes)
+	}
+}
+
+func TestBridge_RemovedDeviceIsCleared(t *testing.T) {
+	registry := device.NewRegistry()
+	registry.Register(simulator.NewSimulatedDevice("light-1"))
+
+	b, broker := startBridge(t, registry)
+	client := mqtttest.Connect(t, broker)
+	msgs := mqtttest.Subscribe(t, client, "homeassistant/light/smarthomehub/light-1/config")
+	if msg := msgs.Next(t); msg.Payload == "" {
+		t.Fatal("Expected a discovery config")
+	}
+
+	registry.Unregister("light-1")
+	if err := b.Discover(context.Background()); err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	if msg := msgs.Next(t); msg.Payload != "" {
+		t.Errorf("Expected an empty retained config, got %q", msg.Payload)
+	}
+}
+
+func TestBridge_PublishesAvailability(t *testing.T) {
+	registry := device.NewRegistry()
+	dev := simulator.NewSimulatedDevice("light-1")
+	registry.Register(dev)
+
+	_, broker := startBridge(t, registry)
+	client := mqtttest.Connect(t, broker)
+	msgs := mqtttest.Subscribe(t, client, "smarthomehub/light-1/availability")
+	if msg := msgs.Next(t); msg.Payload != "online" {
+		t.Fatalf("Expected online, got %q", msg.Payload)
+	}
+
+	dev.SetFaults(simulator.Faults{Offline: true})
+	if msg := msgs.Next(t); msg.Payload != "offline" {
+		t.Fatalf("Expected offline once the device is unavailable, got %q", msg.Payload)
+	}
+
+	dev.SetFaults(simulator.Faults{})
+	if msg := msgs.Next(t); msg.Payload != "online" {
+		t.Errorf("Expected online once the device is back, got %q", msg.Payload)
+	}
+}
+
+func TestBridge_Health(t *testing.T) {
+	registry := device.NewRegistry()
+	b, _ := startBridge(t, registry)
+
+	if h := b.Health(context.Background()); h.Status != provider.HealthOK {
+		t.Errorf("Expected ok, got %+v", h)
+	}
+	b.Stop(context.Background())
+	deadline := time.Now().Add(time.Second)
+	for b.Health(context.Background()).Status != provider.HealthDown {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected down after Stop")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEntitiesFor(t *testing.T) {
+	b := &Bridge{settings: Settings{BaseTopic: "smarthomehub"}}
+	state := device.State{
+		DeviceType: "thermostat",
+		Attributes: map[string]any{
+			"current_temperature": 19.5,
+			"target_temperature":  21.0,
+			"humidity":            45,
+			"occupancy":           true,
+		},
+	}
+
+	components := map[string]string{}
+	for _, e := range b.entitiesFor("living_room_thermostat", state) {
+		components[e.objectID] = e.component
+	}
+
+	want := map[string]string{
+		"living_room_thermostat":           "climate",
+		"living_room_thermostat_humidity":  "sensor",
+		"living_room_thermostat_occupancy": "binary_sensor",
+		// target_temperature has no sensor class, current_temperature is on the climate entity
+	}
+	if len(components) != len(want) {
+		t.Errorf("Expected %d entities, got %v", len(want), components)
+	}
+	for obj, component := range want {
+		if components[obj] != component {
+			t.Errorf("Expected %s to be a %s, got %q", obj, component, components[obj])
+		}
+	}
+}
+
+func TestEntitiesFor_ThermostatModes(t *testing.T) {
+	b := &Bridge{settings: Settings{BaseTopic: "smarthomehub"}}
+	state := device.State{
+		DeviceType: "thermostat",
+		Attributes: map[string]any{
+			"current_temperature": 19.5,
+			"target_temperature":  21.0,
+			"mode":                "cool",
+			"modes":               []string{"cool", "heat", "eco", "off"},
+		},
+	}
+
+	cfg := b.entitiesFor("hall", state)[0].config
+	modes, _ := cfg["modes"].([]string)
+	if fmt.Sprint(modes) != "[cool heat off]" {
+		t.Errorf("Expected the modes Home Assistant knows, got %v", cfg["modes"])
+	}
+	if cfg["mode_command_topic"] != "smarthomehub/hall/set" || cfg["mode_state_topic"] != "smarthomehub/hall/state" {
+		t.Errorf("Expected mode topics, got %v", cfg)
+	}
+
+	// Without a list of modes the current one is all there is, and it can't be set
+	delete(state.Attributes, "modes")
+	cfg = b.entitiesFor("hall", state)[0].config
+	if modes, _ := cfg["modes"].([]string); fmt.Sprint(modes) != "[cool]" {
+		t.Errorf("Expected the current mode, got %v", cfg["modes"])
+	}
+	if _, ok := cfg["mode_command_topic"]; ok {
+		t.Error("Expected no mode command topic")
+	}
+}
+
+func TestEntitiesFor_EnergyIsTotal(t *testing.T) {
+	b := &Bridge{settings: Settings{BaseTopic: "smarthomehub"}}
+	state := device.State{
+		DeviceType: "plug",
+		Attributes: map[string]any{"power_w": 12.5, "energy_kwh": 3.2},
+	}
+
+	classes := map[string]any{}
+	for _, e := range b.entitiesFor("plug", state) {
+		classes[e.objectID] = e.config["state_class"]
+	}
+	if classes["plug_energy_kwh"] != "total_increasing" {
+		t.Errorf("Expected energy to be total_increasing, got %v", classes["plug_energy_kwh"])
+	}
+	if classes["plug_power_w"] != "measurement" {
+		t.Errorf("Expected power to be a measurement, got %v", classes["plug_power_w"])
+	}
+}
+
+func TestObjectID_Unique(t *testing.T) {
+	if id := objectID("lamp-1_a"); id != "lamp-1_a" {
+		t.Errorf("Expected a valid ID kept as it is, got %q", id)
+	}
+	dotted, spaced := objectID("a.b"), objectID("a b")
+	if dotted == objectID("a_b") || dotted == spaced {
+		t.Errorf("Expected distinct object IDs, got %q and %q", dotted, spaced)
+	}
+	if !strings.HasPrefix(dotted, "a_b_") {
+		t.Errorf("Expected the sanitised ID with a suffix, got %q", dotted)
+	}
+}
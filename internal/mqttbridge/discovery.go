@@ -0,0 +1,280 @@
+package mqttbridge
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+)
+
+// entity is one Home Assistant MQTT discovery config, published to
+// <discovery prefix>/<component>/smarthomehub/<object id>/config.
+type entity struct {
+	component string
+	objectID  string
+	config    map[string]any
+}
+
+// Attributes that become sensors or binary sensors on devices without a more specific
+// Home Assistant component, with their device class and unit.
+var sensorClasses = map[string][2]string{
+	"temperature":         {"temperature", "°C"},
+	"current_temperature": {"temperature", "°C"},
+	"humidity":            {"humidity", "%"},
+	"battery":             {"battery", "%"},
+	"illuminance":         {"illuminance", "lx"},
+	"pressure":            {"pressure", "hPa"},
+	"power_w":             {"power", "W"},
+	"power_watts":         {"power", "W"},
+	"energy_kwh":          {"energy", "kWh"},
+	"voltage":             {"voltage", "V"},
+	"current":             {"current", "A"},
+	"linkquality":         {"", "lqi"},
+}
+
+var binarySensorClasses = map[string]string{
+	"occupancy":  "occupancy",
+	"motion":     "motion",
+	"contact":    "door",
+	"open":       "opening",
+	"water_leak": "moisture",
+	"smoke":      "smoke",
+}
+
+// climateModes are the HVAC modes Home Assistant knows
+var climateModes = []string{"auto", "off", "cool", "heat", "dry", "fan_only", "heat_cool"}
+
+// objectID turns a hub device ID into something Home Assistant accepts in topics and
+// unique IDs. An ID that had to be changed gets a hash of the original on the end, so
+// "a.b" and "a_b" don't end up as the same entity.
+func objectID(id device.ID) string {
+	var b strings.Builder
+	changed := false
+	for _, r := range string(id) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+			changed = true
+		}
+	}
+	if changed {
+		h := fnv.New32a()
+		h.Write([]byte(id))
+		fmt.Fprintf(&b, "_%08x", h.Sum32())
+	}
+	return b.String()
+}
+
+// thermostatModes are the modes a thermostat reports in "modes" that Home Assistant
+// knows, or else just the one it is in. settable is whether it reported any.
+func thermostatModes(attributes map[string]any) (modes []string, settable bool) {
+	var reported []string
+	switch v := attributes["modes"].(type) {
+	case []string:
+		reported = v
+	case []any:
+		for _, m := range v {
+			if s, ok := m.(string); ok {
+				reported = append(reported, s)
+			}
+		}
+	}
+	for _, m := range reported {
+		if slices.Contains(climateModes, m) && !slices.Contains(modes, m) {
+			modes = append(modes, m)
+		}
+	}
+	if len(modes) > 0 {
+		return modes, true
+	}
+	if mode, ok := attributes["mode"].(string); ok && slices.Contains(climateModes, mode) {
+		return []string{mode}, false
+	}
+	return []string{"heat"}, false
+}
+
+// entitiesFor derives the discovery configs for a device from its type and state.
+func (b *Bridge) entitiesFor(id device.ID, state device.State) []entity {
+	obj := objectID(id)
+	stateTopic := b.stateTopic(obj)
+	commandTopic := b.commandTopic(obj)
+
+	name := string(id)
+	if n, ok := state.Attributes["name"].(string); ok && n != "" {
+		name = n
+	}
+	dev := map[string]any{
+		"identifiers": []string{"smarthomehub_" + obj},
+		"name":        name,
+	}
+	if v, ok := state.Attributes["vendor"].(string); ok {
+		dev["manufacturer"] = v
+	}
+	if m, ok := state.Attributes["model"].(string); ok {
+		dev["model"] = m
+	}
+
+	base := func(extra map[string]any) map[string]any {
+		cfg := map[string]any{
+			"unique_id":             "smarthomehub_" + obj,
+			"object_id":             obj,
+			"name":                  nil, // use the device name
+			"state_topic":           stateTopic,
+			"json_attributes_topic": stateTopic,
+			// Offline when either the hub or the device is
+			"availability": []map[string]any{
+				{"topic": b.statusTopic()},
+				{"topic": b.availabilityTopic(obj)},
+			},
+			"availability_mode": "all",
+			"device":            dev,
+		}
+		for k, v := range extra {
+			cfg[k] = v
+		}
+		return cfg
+	}
+
+	var entities []entity
+	switch state.DeviceType {
+	case "light":
+		entities = append(entities, entity{component: "light", objectID: obj, config: base(map[string]any{
+			"schema":        "template",
+			"command_topic": commandTopic,
+			// Home Assistant uses 0-255 brightness, the hub 0-100
+			"command_on_template":  `{% if brightness is defined %}{"action":"set_brightness","params":{"value":{{ (brightness / 2.55) | round(0) | int }}}}{% else %}{"action":"turn_on"}{% endif %}`,
+			"command_off_template": `{"action":"turn_off"}`,
+			"state_template":       `{{ value_json.power }}`,
+			"brightness_template":  `{{ ((value_json.brightness | float(0)) * 2.55) | round(0) | int }}`,
+		})})
+
+	case "switch", "plug":
+		entities = append(entities, entity{component: "switch", objectID: obj, config: base(map[string]any{
+			"command_topic":  commandTopic,
+			"payload_on":     `{"action":"turn_on"}`,
+			"payload_off":    `{"action":"turn_off"}`,
+			"value_template": `{{ value_json.power }}`,
+			"state_on":       "on",
+			"state_off":      "off",
+		})})
+
+	case "cover":
+		entities = append(entities, entity{component: "cover", objectID: obj, config: base(map[string]any{
+			"command_topic":         commandTopic,
+			"payload_open":          `{"action":"open"}`,
+			"payload_close":         `{"action":"close"}`,
+			"payload_stop":          `{"action":"stop"}`,
+			"value_template":        `{{ value_json.cover_state }}`,
+			"state_open":            "open",
+			"state_closed":          "closed",
+			"state_opening":         "opening",
+			"state_closing":         "closing",
+			"position_topic":        stateTopic,
+			"position_template":     `{{ value_json.position }}`,
+			"set_position_topic":    commandTopic,
+			"set_position_template": `{"action":"set_position","params":{"value":{{ position }}}}`,
+		})})
+
+	case "lock":
+		entities = append(entities, entity{component: "lock", objectID: obj, config: base(map[string]any{
+			"command_topic":  commandTopic,
+			"payload_lock":   `{"action":"lock"}`,
+			"payload_unlock": `{"action":"unlock"}`,
+			"value_template": `{{ 'LOCKED' if value_json.locked else 'UNLOCKED' }}`,
+			"state_locked":   "LOCKED",
+			"state_unlocked": "UNLOCKED",
+		})})
+
+	case "thermostat":
+		modes, settable := thermostatModes(state.Attributes)
+		cfg := base(map[string]any{
+			"modes":                        modes,
+			"temperature_command_topic":    commandTopic,
+			"temperature_command_template": `{"action":"set_temperature","params":{"value":{{ value }}}}`,
+			"temperature_state_topic":      stateTopic,
+			"temperature_state_template":   `{{ value_json.target_temperature }}`,
+			"current_temperature_topic":    stateTopic,
+			"current_temperature_template": `{{ value_json.current_temperature }}`,
+			"temperature_unit":             "C",
+		})
+		if _, ok := state.Attributes["mode"]; ok {
+			cfg["mode_state_topic"] = stateTopic
+			cfg["mode_state_template"] = `{{ value_json.mode }}`
+		}
+		if settable {
+			cfg["mode_command_topic"] = commandTopic
+			cfg["mode_command_template"] = `{"action":"set_mode","params":{"value":"{{ value }}"}}`
+		}
+		entities = append(entities, entity{component: "climate", objectID: obj, config: cfg})
+	}
+
+	// Everything else, and the extra readings of the devices above, become sensors.
+	// Attributes a specific component already covers are skipped.
+	covered := map[string]bool{}
+	if state.DeviceType == "thermostat" {
+		covered["current_temperature"] = true
+	}
+
+	keys := make([]string, 0, len(state.Attributes))
+	for k := range state.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, attr := range keys {
+		if covered[attr] {
+			continue
+		}
+		v := state.Attributes[attr]
+		sensorObj := fmt.Sprintf("%s_%s", obj, objectID(device.ID(attr)))
+
+		if class, ok := sensorClasses[attr]; ok && isNumber(v) {
+			cfg := base(map[string]any{
+				"unique_id":      "smarthomehub_" + sensorObj,
+				"object_id":      sensorObj,
+				"name":           attr,
+				"value_template": fmt.Sprintf("{{ value_json.%s }}", attr),
+				"state_class":    "measurement",
+			})
+			if class[0] == "energy" {
+				// A meter reading; Home Assistant only tracks energy that adds up
+				cfg["state_class"] = "total_increasing"
+			}
+			if class[0] != "" {
+				cfg["device_class"] = class[0]
+			}
+			if class[1] != "" {
+				cfg["unit_of_measurement"] = class[1]
+			}
+			entities = append(entities, entity{component: "sensor", objectID: sensorObj, config: cfg})
+			continue
+		}
+
+		if class, ok := binarySensorClasses[attr]; ok {
+			if _, isBool := v.(bool); isBool {
+				entities = append(entities, entity{component: "binary_sensor", objectID: sensorObj, config: base(map[string]any{
+					"unique_id":      "smarthomehub_" + sensorObj,
+					"object_id":      sensorObj,
+					"name":           attr,
+					"device_class":   class,
+					"value_template": fmt.Sprintf("{{ 'ON' if value_json.%s else 'OFF' }}", attr),
+				})})
+			}
+		}
+	}
+
+	return entities
+}
+
+func isNumber(v any) bool {
+	switch v.(type) {
+	case int, int64, float32, float64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package mqttclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+)
+
+var ErrNotConnected = errors.New("mqtt client not connected")
+
+// Settings is the broker connection part of every MQTT based provider's settings.
+type Settings struct {
+	Broker    string          `json:"broker"` // e.g. tcp://localhost:1883
+	ClientID  string          `json:"client_id"`
+	Username  string          `json:"username"`
+	Password  string          `json:"password"`
+	KeepAlive config.Duration `json:"keep_alive"`
+	// Will is published by the broker if the hub disconnects without saying goodbye
+	WillTopic   string `json:"will_topic,omitempty"`
+	WillPayload string `json:"will_payload,omitempty"`
+}
+
+type Handler func(topic string, payload []byte)
+
+// Subscription is one handler's subscription to a topic, for Unsubscribe.
+type Subscription struct {
+	Topic string
+	id    uint64
+}
+
+// Client wraps a paho client, remembering subscriptions so they are restored after
+// the broker connection drops. Any number of handlers can subscribe to a topic, e.g.
+// two devices sharing a state topic; each message goes to all of them.
+type Client struct {
+	client paho.Client
+
+	mu            sync.Mutex
+	nextID        uint64
+	subscriptions map[string]map[uint64]Handler // by topic, then subscription
+}
+
+func Connect(ctx context.Context, settings Settings) (*Client, error) {
+	if settings.Broker == "" {
+		return nil, errors.New("mqtt broker address is required")
+	}
+
+	c := &Client{
+		subscriptions: make(map[string]map[uint64]Handler),
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(settings.Broker).
+		SetClientID(settings.ClientID).
+		SetUsername(settings.Username).
+		SetPassword(settings.Password).
+		SetAutoReconnect(true).
+		SetCleanSession(true).
+		SetOrderMatters(false).
+		SetOnConnectHandler(c.resubscribe)
+	if settings.KeepAlive > 0 {
+		opts.SetKeepAlive(settings.KeepAlive.Duration())
+	}
+	if settings.WillTopic != "" {
+		opts.SetWill(settings.WillTopic, settings.WillPayload, 1, true)
+	}
+
+	c.client = paho.NewClient(opts)
+	if err := wait(ctx, c.client.Connect()); err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", settings.Broker, err)
+	}
+	return c, nil
+}
+
+// resubscribe restores subscriptions after a reconnect, the broker forgets them
+// because we use clean sessions.
+func (c *Client) resubscribe(client paho.Client) {
+	c.mu.Lock()
+	topics := make([]string, 0, len(c.subscriptions))
+	for topic := range c.subscriptions {
+		topics = append(topics, topic)
+	}
+	c.mu.Unlock()
+
+	for _, topic := range topics {
+		token := client.Subscribe(topic, 1, c.dispatch(topic))
+		go func(topic string) {
+			if token.WaitTimeout(10*time.Second) && token.Error() != nil {
+				log.Printf("mqtt: failed to resubscribe to %s: %v", topic, token.Error())
+			}
+		}(topic)
+	}
+}
+
+// dispatch hands a message on a topic to every handler subscribed to it.
+func (c *Client) dispatch(topic string) paho.MessageHandler {
+	return func(_ paho.Client, msg paho.Message) {
+		c.mu.Lock()
+		handlers := make([]Handler, 0, len(c.subscriptions[topic]))
+		for _, h := range c.subscriptions[topic] {
+			handlers = append(handlers, h)
+		}
+		c.mu.Unlock()
+
+		for _, h := range handlers {
+			h(msg.Topic(), msg.Payload())
+		}
+	}
+}
+
+// Subscribe adds a handler for a topic. The topic is subscribed to again even if it
+// already has handlers, so the broker sends its retained message for the new one.
+func (c *Client) Subscribe(ctx context.Context, topic string, h Handler) (Subscription, error) {
+	c.mu.Lock()
+	c.nextID++
+	sub := Subscription{Topic: topic, id: c.nextID}
+	if c.subscriptions[topic] == nil {
+		c.subscriptions[topic] = make(map[uint64]Handler)
+	}
+	c.subscriptions[topic][sub.id] = h
+	c.mu.Unlock()
+
+	return sub, wait(ctx, c.client.Subscribe(topic, 1, c.dispatch(topic)))
+}
+
+// Unsubscribe removes handlers. A topic is unsubscribed from at the broker once its
+// last handler is gone.
+func (c *Client) Unsubscribe(ctx context.Context, subs ...Subscription) error {
+	var topics []string
+	c.mu.Lock()
+	for _, sub := range subs {
+		handlers, ok := c.subscriptions[sub.Topic]
+		if !ok {
+			continue
+		}
+		delete(handlers, sub.id)
+		if len(handlers) == 0 {
+			delete(c.subscriptions, sub.Topic)
+			topics = append(topics, sub.Topic)
+		}
+	}
+	c.mu.Unlock()
+
+	if len(topics) == 0 {
+		return nil
+	}
+	return wait(ctx, c.client.Unsubscribe(topics...))
+}
+
+func (c *Client) Publish(ctx context.Context, topic string, payload []byte, retain bool) error {
+	if !c.client.IsConnectionOpen() {
+		return ErrNotConnected
+	}
+	return wait(ctx, c.client.Publish(topic, 1, retain, payload))
+}
+
+func (c *Client) IsConnected() bool {
+	return c.client.IsConnectionOpen()
+}
+
+func (c *Client) Close() {
+	c.client.Disconnect(250)
+}
+
+func wait(ctx context.Context, token paho.Token) error {
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
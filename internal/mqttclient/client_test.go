@@ -0,0 +1,87 @@
+package mqttclient_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/mqttclient"
+	"github.com/legitlolly/SmartHomeHub/internal/mqttclient/mqtttest"
+)
+
+func collect(t *testing.T, c *mqttclient.Client, topic string) (mqttclient.Subscription, chan string) {
+	t.Helper()
+	got := make(chan string, 10)
+	sub, err := c.Subscribe(context.Background(), topic, func(topic string, payload []byte) {
+		got <- string(payload)
+	})
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	return sub, got
+}
+
+func expect(t *testing.T, got chan string, want string) {
+	t.Helper()
+	select {
+	case payload := <-got:
+		if payload != want {
+			t.Errorf("Expected %q, got %q", want, payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %q", want)
+	}
+}
+
+func expectNothing(t *testing.T, got chan string) {
+	t.Helper()
+	select {
+	case payload := <-got:
+		t.Errorf("Expected no message, got %q", payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestClient_SharedTopic(t *testing.T) {
+	broker := mqtttest.NewBroker(t)
+	c := mqtttest.Connect(t, broker)
+	publisher := mqtttest.Connect(t, broker)
+	ctx := context.Background()
+
+	first, gotFirst := collect(t, c, "home/shared/state")
+	second, gotSecond := collect(t, c, "home/shared/state")
+
+	if err := publisher.Publish(ctx, "home/shared/state", []byte("one"), false); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	expect(t, gotFirst, "one")
+	expect(t, gotSecond, "one")
+
+	// Dropping one handler leaves the other subscribed
+	if err := c.Unsubscribe(ctx, first); err != nil {
+		t.Fatalf("Unsubscribe failed: %v", err)
+	}
+	publisher.Publish(ctx, "home/shared/state", []byte("two"), false)
+	expect(t, gotSecond, "two")
+	expectNothing(t, gotFirst)
+
+	if err := c.Unsubscribe(ctx, second); err != nil {
+		t.Fatalf("Unsubscribe failed: %v", err)
+	}
+	publisher.Publish(ctx, "home/shared/state", []byte("three"), false)
+	expectNothing(t, gotSecond)
+}
+
+func TestClient_LateSubscriberGetsRetained(t *testing.T) {
+	broker := mqtttest.NewBroker(t)
+	c := mqtttest.Connect(t, broker)
+	publisher := mqtttest.Connect(t, broker)
+	ctx := context.Background()
+
+	_, gotFirst := collect(t, c, "home/shared/state")
+	publisher.Publish(ctx, "home/shared/state", []byte("on"), true)
+	expect(t, gotFirst, "on")
+
+	_, gotSecond := collect(t, c, "home/shared/state")
+	expect(t, gotSecond, "on")
+}
@@ -0,0 +1,112 @@
+// Package mqtttest runs an in-process MQTT broker for tests.
+package mqtttest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/mqttclient"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+// NewBroker starts a broker on a free local port and returns its address in the
+// form expected by mqttclient.Settings.Broker. It is closed when the test ends.
+func NewBroker(t testing.TB) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	server := mqtt.New(&mqtt.Options{
+		InlineClient: true,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatalf("failed to add auth hook: %v", err)
+	}
+	if err := server.AddListener(listeners.NewTCP(listeners.Config{ID: "test", Address: addr})); err != nil {
+		t.Fatalf("failed to add listener: %v", err)
+	}
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+
+	// Serve starts the listener asynchronously
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("broker did not start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return "tcp://" + addr
+}
+
+// Connect returns a client connected to broker, closed when the test ends.
+func Connect(t testing.TB, broker string) *mqttclient.Client {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := mqttclient.Connect(ctx, mqttclient.Settings{
+		Broker:   broker,
+		ClientID: fmt.Sprintf("test-%d", time.Now().UnixNano()),
+	})
+	if err != nil {
+		t.Fatalf("failed to connect test client: %v", err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// Messages collects the payloads published to a topic filter.
+type Messages struct {
+	ch chan Message
+}
+
+type Message struct {
+	Topic   string
+	Payload string
+}
+
+func Subscribe(t testing.TB, c *mqttclient.Client, topic string) *Messages {
+	t.Helper()
+
+	m := &Messages{ch: make(chan Message, 100)}
+	_, err := c.Subscribe(context.Background(), topic, func(topic string, payload []byte) {
+		m.ch <- Message{Topic: topic, Payload: string(payload)}
+	})
+	if err != nil {
+		t.Fatalf("failed to subscribe to %s: %v", topic, err)
+	}
+	return m
+}
+
+// Next waits for the next message.
+func (m *Messages) Next(t testing.TB) Message {
+	t.Helper()
+	select {
+	case msg := <-m.ch:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for mqtt message")
+		return Message{}
+	}
+}
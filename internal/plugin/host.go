@@ -0,0 +1,579 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func init() {
+	provider.RegisterFactory("plugin", NewHost)
+}
+
+type Settings struct {
+	Command          string            `json:"command"`
+	Args             []string          `json:"args"`
+	Env              map[string]string `json:"env"`
+	HandshakeTimeout config.Duration   `json:"handshake_timeout"`
+	RestartMinDelay  config.Duration   `json:"restart_min_delay"`
+	RestartMaxDelay  config.Duration   `json:"restart_max_delay"`
+}
+
+// Host runs a plugin executable and exposes its devices as "<provider name>:<device id>".
+// If the plugin exits it is restarted with exponential backoff; its devices are
+// unregistered while it is down.
+type Host struct {
+	name     string
+	settings Settings
+
+	mu        sync.Mutex
+	registry  provider.Registry
+	proc      *process
+	client    *providerClient
+	devices   map[device.ID]*remoteDevice
+	restarts  int
+	lastError string
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// process is one run of the plugin executable.
+type process struct {
+	cmd       *exec.Cmd
+	conn      *grpc.ClientConn
+	dir       string
+	startedAt time.Time
+	exited    chan struct{}
+	exitErr   error
+}
+
+func NewHost(name string, raw json.RawMessage, env provider.Env) (provider.Provider, error) {
+	settings := Settings{
+		HandshakeTimeout: config.Duration(10 * time.Second),
+		RestartMinDelay:  config.Duration(time.Second),
+		RestartMaxDelay:  config.Duration(time.Minute),
+	}
+	if err := provider.DecodeSettings(raw, &settings); err != nil {
+		return nil, err
+	}
+	if settings.Command == "" {
+		return nil, errors.New("plugin command is required")
+	}
+	return &Host{
+		name:     name,
+		settings: settings,
+		devices:  make(map[device.ID]*remoteDevice),
+	}, nil
+}
+
+func (h *Host) Name() string {
+	return h.name
+}
+
+func (h *Host) Start(ctx context.Context, registry provider.Registry) error {
+	h.mu.Lock()
+	h.registry = registry
+	h.mu.Unlock()
+
+	if err := h.launch(ctx); err != nil {
+		return err
+	}
+
+	superviseCtx, cancel := context.WithCancel(context.Background())
+	h.mu.Lock()
+	h.cancel = cancel
+	h.done = make(chan struct{})
+	h.mu.Unlock()
+
+	go h.supervise(superviseCtx)
+	return nil
+}
+
+// launch starts the executable, waits for its handshake and connects to it. ctx only
+// bounds the wait for the handshake; the plugin keeps running once it has started.
+func (h *Host) launch(ctx context.Context) error {
+	dir, err := os.MkdirTemp("", "shh-plugin-")
+	if err != nil {
+		return fmt.Errorf("failed to create socket dir: %w", err)
+	}
+	socket := filepath.Join(dir, "plugin.sock")
+
+	cmd := exec.Command(h.settings.Command, h.settings.Args...)
+	cmd.Env = append(os.Environ(), SocketEnv+"="+socket, CookieEnv+"="+CookieValue)
+	for k, v := range h.settings.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stderr = &logWriter{prefix: "plugin " + h.name + ": "}
+	// The pipe is ours rather than from StdoutPipe, which Wait closes: the plugin may
+	// exit while its handshake is still being read
+	stdout, w, err := os.Pipe()
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	cmd.Stdout = w
+
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		stdout.Close()
+		os.RemoveAll(dir)
+		return fmt.Errorf("failed to start plugin: %w", err)
+	}
+
+	proc := &process{
+		cmd:       cmd,
+		dir:       dir,
+		startedAt: time.Now(),
+		exited:    make(chan struct{}),
+	}
+
+	handshake := make(chan string, 1)
+	go func() {
+		defer stdout.Close()
+		scanner := bufio.NewScanner(stdout)
+		first := true
+		for scanner.Scan() {
+			if first {
+				handshake <- scanner.Text()
+				first = false
+				continue
+			}
+			log.Printf("plugin %s: %s", h.name, scanner.Text())
+		}
+		if first {
+			close(handshake)
+		}
+	}()
+	go func() {
+		proc.exitErr = cmd.Wait()
+		close(proc.exited)
+	}()
+
+	timeout := time.NewTimer(h.settings.HandshakeTimeout.Duration())
+	defer timeout.Stop()
+	var line string
+	select {
+	case l, ok := <-handshake:
+		if !ok {
+			<-proc.exited
+			proc.cleanup()
+			return fmt.Errorf("plugin exited before handshake: %v", proc.exitErr)
+		}
+		line = l
+	case <-proc.exited:
+		proc.cleanup()
+		return fmt.Errorf("plugin exited before handshake: %v", proc.exitErr)
+	case <-timeout.C:
+		proc.kill()
+		return errors.New("plugin handshake timed out")
+	case <-ctx.Done():
+		proc.kill()
+		return fmt.Errorf("plugin handshake abandoned: %w", ctx.Err())
+	}
+
+	addr, err := parseHandshake(line)
+	if err != nil {
+		proc.kill()
+		return err
+	}
+
+	conn, err := grpc.NewClient("unix://"+addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		proc.kill()
+		return fmt.Errorf("failed to connect to plugin: %w", err)
+	}
+	proc.conn = conn
+	client := &providerClient{cc: conn}
+
+	h.mu.Lock()
+	h.proc = proc
+	h.client = client
+	h.mu.Unlock()
+
+	go h.watch(proc, client)
+	log.Printf("Plugin %s started (pid %d)", h.name, cmd.Process.Pid)
+	return nil
+}
+
+func parseHandshake(line string) (string, error) {
+	parts := strings.Split(strings.TrimSpace(line), "|")
+	if len(parts) != 4 || parts[0] != HandshakePrefix {
+		return "", fmt.Errorf("invalid plugin handshake %q", line)
+	}
+	if parts[1] != ProtocolVersion {
+		return "", fmt.Errorf("unsupported plugin protocol version %s", parts[1])
+	}
+	if parts[2] != "unix" {
+		return "", fmt.Errorf("unsupported plugin network %s", parts[2])
+	}
+	return parts[3], nil
+}
+
+// supervise restarts the plugin whenever it exits, until Stop is called.
+func (h *Host) supervise(ctx context.Context) {
+	defer close(h.done)
+
+	delay := h.settings.RestartMinDelay.Duration()
+	for {
+		h.mu.Lock()
+		proc := h.proc
+		h.mu.Unlock()
+
+		if proc != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-proc.exited:
+			}
+
+			log.Printf("Plugin %s exited: %v", h.name, proc.exitErr)
+			h.mu.Lock()
+			h.proc = nil
+			h.client = nil
+			h.lastError = fmt.Sprintf("exited: %v", proc.exitErr)
+			h.mu.Unlock()
+			proc.cleanup()
+			h.unregisterAll()
+
+			// A plugin that ran for a while gets a fresh backoff
+			if time.Since(proc.startedAt) > h.settings.RestartMaxDelay.Duration() {
+				delay = h.settings.RestartMinDelay.Duration()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, h.settings.RestartMaxDelay.Duration())
+
+		h.mu.Lock()
+		h.restarts++
+		h.mu.Unlock()
+
+		if err := h.launch(ctx); err != nil {
+			log.Printf("Plugin %s: restart failed: %v", h.name, err)
+			h.mu.Lock()
+			h.lastError = err.Error()
+			h.mu.Unlock()
+			continue
+		}
+		if err := h.Discover(ctx); err != nil {
+			log.Printf("Plugin %s: discovery after restart failed: %v", h.name, err)
+		}
+	}
+}
+
+// watch keeps the cached state of the plugin's devices current while proc runs. The
+// cache is only good while the stream is up, so it is dropped whenever the stream
+// ends and State asks the plugin until it has been subscribed to again.
+func (h *Host) watch(proc *process, client *providerClient) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-proc.exited
+		cancel()
+	}()
+
+	resilience.Reconnect(ctx, "plugin "+h.name+": state stream closed", func(ctx context.Context) (bool, error) {
+		defer h.dropCached()
+		received, err := h.watchOnce(ctx, client)
+		// A stream that ended with the plugin needs no reconnect; give the exit a
+		// moment to be noticed
+		select {
+		case <-proc.exited:
+			cancel()
+		case <-time.After(100 * time.Millisecond):
+		}
+		return received, err
+	})
+}
+
+// watchOnce applies the states pushed on one WatchState stream until it ends. It
+// reports whether the stream delivered anything.
+func (h *Host) watchOnce(ctx context.Context, client *providerClient) (bool, error) {
+	stream, err := client.WatchState(ctx)
+	if err != nil {
+		return false, err
+	}
+	for received := false; ; received = true {
+		msg, err := stream.Recv()
+		if err != nil {
+			return received, err
+		}
+		remoteID, _ := msg.AsMap()["device_id"].(string)
+		st := msg.GetFields()["state"].GetStructValue()
+		if st == nil {
+			continue
+		}
+
+		h.mu.Lock()
+		d, ok := h.devices[h.namespaced(remoteID)]
+		h.mu.Unlock()
+		if ok {
+			d.setCached(decodeState(st))
+		}
+	}
+}
+
+func (h *Host) dropCached() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, d := range h.devices {
+		d.dropCached()
+	}
+}
+
+func (h *Host) namespaced(remoteID string) device.ID {
+	return device.ID(h.name + ":" + remoteID)
+}
+
+// Discover registers the plugin's devices that are not registered yet and removes
+// the ones the plugin no longer reports.
+func (h *Host) Discover(ctx context.Context) error {
+	h.mu.Lock()
+	client := h.client
+	h.mu.Unlock()
+	if client == nil {
+		return fmt.Errorf("%w: %s", ErrPluginUnavailable, h.name)
+	}
+
+	resp, err := client.ListDevices(ctx)
+	if err != nil {
+		return fromStatus(h.name, err)
+	}
+
+	seen := make(map[device.ID]bool)
+	for _, v := range resp.GetFields()["devices"].GetListValue().GetValues() {
+		remoteID, _ := v.GetStructValue().AsMap()["id"].(string)
+		if remoteID == "" {
+			continue
+		}
+		id := h.namespaced(remoteID)
+		seen[id] = true
+
+		h.mu.Lock()
+		_, exists := h.devices[id]
+		h.mu.Unlock()
+		if exists {
+			continue
+		}
+
+		d := &remoteDevice{host: h, id: id, remoteID: remoteID}
+		if err := h.registry.Register(d); err != nil {
+			log.Printf("Plugin %s: failed to register %s: %v", h.name, id, err)
+			continue
+		}
+		h.mu.Lock()
+		h.devices[id] = d
+		h.mu.Unlock()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id := range h.devices {
+		if !seen[id] {
+			h.registry.Unregister(id)
+			delete(h.devices, id)
+		}
+	}
+	return nil
+}
+
+func (h *Host) unregisterAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, d := range h.devices {
+		h.registry.Unregister(id)
+		// Callers may still hold the device; it has nothing to say until it is back
+		d.dropCached()
+	}
+	h.devices = make(map[device.ID]*remoteDevice)
+}
+
+func (h *Host) Stop(ctx context.Context) error {
+	h.mu.Lock()
+	cancel, done := h.cancel, h.done
+	h.cancel = nil
+	h.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+
+	h.mu.Lock()
+	proc := h.proc
+	h.proc = nil
+	h.client = nil
+	h.mu.Unlock()
+
+	h.unregisterAll()
+	if proc == nil {
+		return nil
+	}
+
+	// Ask nicely first, then kill
+	proc.cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-proc.exited:
+		proc.cleanup()
+	case <-ctx.Done():
+		proc.kill()
+	case <-time.After(5 * time.Second):
+		proc.kill()
+	}
+	return nil
+}
+
+type HealthDetails struct {
+	PID       int    `json:"pid,omitempty"`
+	Restarts  int    `json:"restarts"`
+	Devices   int    `json:"devices"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+func (h *Host) Health(ctx context.Context) provider.Health {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	details := HealthDetails{
+		Restarts:  h.restarts,
+		Devices:   len(h.devices),
+		LastError: h.lastError,
+	}
+	if h.proc == nil {
+		return provider.Health{Status: provider.HealthDown, Message: "plugin is restarting", Details: details}
+	}
+	details.PID = h.proc.cmd.Process.Pid
+	return provider.Health{Status: provider.HealthOK, Details: details}
+}
+
+func (p *process) kill() {
+	p.cmd.Process.Kill()
+	<-p.exited
+	p.cleanup()
+}
+
+func (p *process) cleanup() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	os.RemoveAll(p.dir)
+}
+
+// remoteDevice is a device served by a plugin.
+type remoteDevice struct {
+	host     *Host
+	id       device.ID
+	remoteID string
+
+	mu     sync.RWMutex
+	cached *device.State
+}
+
+func (d *remoteDevice) ID() device.ID {
+	return d.id
+}
+
+func (d *remoteDevice) Execute(ctx context.Context, cmd device.Command) error {
+	d.host.mu.Lock()
+	client := d.host.client
+	d.host.mu.Unlock()
+	if client == nil {
+		return fmt.Errorf("%w: %s", ErrPluginUnavailable, d.host.name)
+	}
+
+	params := cmd.Params
+	if params == nil {
+		params = map[string]any{}
+	}
+	req, err := structpb.NewStruct(map[string]any{
+		"device_id": d.remoteID,
+		"action":    cmd.Action,
+		"params":    params,
+	})
+	if err != nil {
+		return fmt.Errorf("invalid params: %w", err)
+	}
+
+	return fromStatus(d.host.name, client.Execute(ctx, req))
+}
+
+// State returns the last state pushed by the plugin, asking the plugin directly
+// while it hasn't pushed one since the state stream (re)started.
+func (d *remoteDevice) State(ctx context.Context) (device.State, error) {
+	d.host.mu.Lock()
+	client := d.host.client
+	d.host.mu.Unlock()
+	if client == nil {
+		return device.State{}, fmt.Errorf("%w: %s", ErrPluginUnavailable, d.host.name)
+	}
+
+	d.mu.RLock()
+	cached := d.cached
+	d.mu.RUnlock()
+	if cached != nil {
+		state := *cached
+		state.Attributes = maps.Clone(cached.Attributes)
+		return state, nil
+	}
+
+	req, _ := structpb.NewStruct(map[string]any{"device_id": d.remoteID})
+	resp, err := client.State(ctx, req)
+	if err != nil {
+		return device.State{}, fromStatus(d.host.name, err)
+	}
+	return decodeState(resp), nil
+}
+
+func (d *remoteDevice) setCached(s device.State) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cached = &s
+}
+
+func (d *remoteDevice) dropCached() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cached = nil
+}
+
+// logWriter forwards plugin stderr to the hub log line by line.
+type logWriter struct {
+	prefix string
+	buf    []byte
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := strings.IndexByte(string(w.buf), '\n')
+		if i < 0 {
+			break
+		}
+		log.Print(w.prefix + string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
@@ -0,0 +1,259 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/simulator"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// The test binary doubles as the plugin executable: when the host launches it with
+// the plugin environment set it serves two simulated lights instead of running tests.
+func TestMain(m *testing.M) {
+	switch os.Getenv("SMARTHOMEHUB_TEST_PLUGIN") {
+	case "silent":
+		// A plugin that never gets round to its handshake
+		time.Sleep(time.Minute)
+		os.Exit(0)
+	case "1":
+		srv := NewServer(
+			simulator.NewSimulatedDevice("lamp-1"),
+			simulator.NewSimulatedDevice("lamp-2"),
+		)
+		if err := srv.Serve(); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func startTestHost(t *testing.T) (*Host, *device.Registry) {
+	t.Helper()
+
+	settings, _ := json.Marshal(map[string]any{
+		"command":           os.Args[0],
+		"env":               map[string]string{"SMARTHOMEHUB_TEST_PLUGIN": "1"},
+		"restart_min_delay": "10ms",
+		"restart_max_delay": "100ms",
+	})
+	p, err := NewHost("test", settings, provider.Env{})
+	if err != nil {
+		t.Fatalf("NewHost failed: %v", err)
+	}
+	host := p.(*Host)
+
+	registry := device.NewRegistry()
+	m := provider.NewManager(registry, provider.Env{})
+	m.Add(host, "plugin")
+	m.StartAll(context.Background())
+	t.Cleanup(func() { m.StopAll(context.Background()) })
+
+	if status, _ := m.Status("test"); status.State != provider.StateRunning {
+		t.Fatalf("Expected plugin provider to be running, got %+v", status)
+	}
+	return host, registry
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHost_ExposesNamespacedDevices(t *testing.T) {
+	ctx := context.Background()
+	_, registry := startTestHost(t)
+
+	if n := len(registry.List()); n != 2 {
+		t.Fatalf("Expected 2 plugin devices, got %d", n)
+	}
+
+	dev, err := registry.Get("test:lamp-1")
+	if err != nil {
+		t.Fatalf("Expected namespaced device test:lamp-1: %v", err)
+	}
+
+	cmd := device.Command{DeviceID: dev.ID(), Action: "set_brightness", Params: map[string]any{"value": 40}}
+	if err := dev.Execute(ctx, cmd); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	// The plugin pushes the new state after the command
+	waitFor(t, "pushed state", func() bool {
+		state, err := dev.State(ctx)
+		return err == nil && state.Attributes["brightness"] == float64(40)
+	})
+
+	state, _ := dev.State(ctx)
+	if state.DeviceType != "light" {
+		t.Errorf("Expected device_type light, got %q", state.DeviceType)
+	}
+
+	err = dev.Execute(ctx, device.Command{DeviceID: dev.ID(), Action: "explode"})
+	if !errors.Is(err, device.ErrUnknownCommand) || err.Error() != "unknown command" {
+		t.Errorf("Expected the plugin's error to be passed through, got %v", err)
+	}
+
+	cmd.Params["value"] = 150
+	if err := dev.Execute(ctx, cmd); !errors.Is(err, device.ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter from the plugin, got %v", err)
+	}
+}
+
+func TestHost_RestartsCrashedPlugin(t *testing.T) {
+	host, registry := startTestHost(t)
+
+	host.mu.Lock()
+	pid := host.proc.cmd.Process.Pid
+	host.proc.cmd.Process.Kill()
+	host.mu.Unlock()
+
+	waitFor(t, "plugin restart", func() bool {
+		host.mu.Lock()
+		defer host.mu.Unlock()
+		return host.proc != nil && host.proc.cmd.Process.Pid != pid && len(host.devices) == 2
+	})
+
+	if _, err := registry.Get("test:lamp-2"); err != nil {
+		t.Errorf("Expected devices to be registered again after restart: %v", err)
+	}
+	health := host.Health(context.Background())
+	if details := health.Details.(HealthDetails); details.Restarts != 1 {
+		t.Errorf("Expected 1 restart, got %+v", details)
+	}
+}
+
+func TestHost_DropsCachedStateWithThePlugin(t *testing.T) {
+	ctx := context.Background()
+	host, registry := startTestHost(t)
+
+	dev, _ := registry.Get("test:lamp-1")
+	cmd := device.Command{DeviceID: dev.ID(), Action: "set_brightness", Params: map[string]any{"value": 40}}
+	if err := dev.Execute(ctx, cmd); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	waitFor(t, "pushed state", func() bool {
+		state, err := dev.State(ctx)
+		return err == nil && state.Attributes["brightness"] == float64(40)
+	})
+
+	state, err := dev.State(ctx)
+	if err != nil {
+		t.Fatalf("State failed: %v", err)
+	}
+	state.Attributes["brightness"] = float64(99)
+	if state, _ := dev.State(ctx); state.Attributes["brightness"] != float64(40) {
+		t.Errorf("Expected the cached state to be unaffected by callers, got %v", state.Attributes["brightness"])
+	}
+
+	host.mu.Lock()
+	pid := host.proc.cmd.Process.Pid
+	host.proc.cmd.Process.Kill()
+	host.mu.Unlock()
+
+	waitFor(t, "plugin restart", func() bool {
+		host.mu.Lock()
+		defer host.mu.Unlock()
+		return host.proc != nil && host.proc.cmd.Process.Pid != pid && len(host.devices) == 2
+	})
+
+	// The restarted plugin's lamp starts over, and the old state mustn't outlive it
+	state, err = dev.State(ctx)
+	if err != nil {
+		t.Fatalf("State failed after the restart: %v", err)
+	}
+	if state.Attributes["brightness"] == float64(40) {
+		t.Error("Expected the state cached before the restart to be dropped")
+	}
+}
+
+func TestHost_HandshakeHonoursContext(t *testing.T) {
+	settings, _ := json.Marshal(map[string]any{
+		"command":           os.Args[0],
+		"env":               map[string]string{"SMARTHOMEHUB_TEST_PLUGIN": "silent"},
+		"handshake_timeout": "1m",
+	})
+	p, err := NewHost("test", settings, provider.Env{})
+	if err != nil {
+		t.Fatalf("NewHost failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	err = p.Start(ctx, device.NewRegistry())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the handshake to be abandoned with the context, got %v", err)
+	}
+	if took := time.Since(started); took > 5*time.Second {
+		t.Errorf("Expected Start to return with the context, took %s", took)
+	}
+}
+
+func TestHost_HandshakeTimeout(t *testing.T) {
+	settings, _ := json.Marshal(map[string]any{
+		"command":           os.Args[0],
+		"env":               map[string]string{"SMARTHOMEHUB_TEST_PLUGIN": "silent"},
+		"handshake_timeout": "50ms",
+	})
+	p, err := NewHost("test", settings, provider.Env{})
+	if err != nil {
+		t.Fatalf("NewHost failed: %v", err)
+	}
+	if err := p.Start(context.Background(), device.NewRegistry()); err == nil || err.Error() != "plugin handshake timed out" {
+		t.Errorf("Expected the handshake to time out, got %v", err)
+	}
+}
+
+func TestServer_EndsSlowWatchers(t *testing.T) {
+	srv := NewServer(simulator.NewSimulatedDevice("lamp-1"))
+	ch := make(chan *structpb.Struct, 1)
+	srv.watchers[ch] = struct{}{}
+
+	for range 2 {
+		if err := srv.Publish(context.Background(), "lamp-1"); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+	}
+
+	<-ch
+	if _, ok := <-ch; ok {
+		t.Error("Expected a watcher that fell behind to be closed")
+	}
+	if len(srv.watchers) != 0 {
+		t.Error("Expected a watcher that fell behind to be removed")
+	}
+}
+
+func TestServer_RefusesToRunOutsideHub(t *testing.T) {
+	t.Setenv(SocketEnv, "")
+	if err := NewServer().Serve(); !errors.Is(err, ErrNotPlugin) {
+		t.Errorf("Expected ErrNotPlugin, got %v", err)
+	}
+}
+
+func TestParseHandshake(t *testing.T) {
+	addr, err := parseHandshake("SMARTHOMEHUB_PLUGIN|1|unix|/tmp/x.sock\n")
+	if err != nil || addr != "/tmp/x.sock" {
+		t.Errorf("Unexpected result %q, %v", addr, err)
+	}
+	if _, err := parseHandshake("SMARTHOMEHUB_PLUGIN|2|unix|/tmp/x.sock"); err == nil {
+		t.Error("Expected unsupported version to be rejected")
+	}
+	if _, err := parseHandshake("hello"); err == nil {
+		t.Error("Expected garbage to be rejected")
+	}
+}
@@ -0,0 +1,208 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Handshake values, see proto/provider.proto for the protocol description
+const (
+	ProtocolVersion = "1"
+	HandshakePrefix = "SMARTHOMEHUB_PLUGIN"
+	SocketEnv       = "SMARTHOMEHUB_PLUGIN_SOCKET"
+	CookieEnv       = "SMARTHOMEHUB_PLUGIN_COOKIE"
+	CookieValue     = "4c1d2d57-smarthomehub-plugin"
+)
+
+const serviceName = "smarthomehub.plugin.v1.Provider"
+
+var ErrNotPlugin = errors.New("not started as a SmartHomeHub plugin")
+
+// providerServer is the server side of the Provider service.
+type providerServer interface {
+	ListDevices(ctx context.Context, req *emptypb.Empty) (*structpb.Struct, error)
+	Execute(ctx context.Context, req *structpb.Struct) (*emptypb.Empty, error)
+	State(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	WatchState(req *emptypb.Empty, stream grpc.ServerStreamingServer[structpb.Struct]) error
+}
+
+// serviceDesc is what protoc-gen-go-grpc would generate for proto/provider.proto.
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*providerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "ListDevices", Handler: unaryHandler("ListDevices", providerServer.ListDevices)},
+		{MethodName: "Execute", Handler: unaryHandler("Execute", providerServer.Execute)},
+		{MethodName: "State", Handler: unaryHandler("State", providerServer.State)},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchState",
+			ServerStreams: true,
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				req := new(emptypb.Empty)
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+				return srv.(providerServer).WatchState(req, &grpc.GenericServerStream[emptypb.Empty, structpb.Struct]{ServerStream: stream})
+			},
+		},
+	},
+	Metadata: "proto/provider.proto",
+}
+
+func unaryHandler[Req any, Resp any, PReq interface {
+	*Req
+	any
+}](method string, call func(providerServer, context.Context, PReq) (Resp, error)) grpc.MethodHandler {
+	return func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		req := PReq(new(Req))
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(providerServer), ctx, req)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/" + method}
+		return interceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+			return call(srv.(providerServer), ctx, req.(PReq))
+		})
+	}
+}
+
+// providerClient is the client side of the Provider service.
+type providerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func (c *providerClient) ListDevices(ctx context.Context) (*structpb.Struct, error) {
+	out := new(structpb.Struct)
+	err := c.cc.Invoke(ctx, "/"+serviceName+"/ListDevices", &emptypb.Empty{}, out)
+	return out, err
+}
+
+func (c *providerClient) Execute(ctx context.Context, req *structpb.Struct) error {
+	return c.cc.Invoke(ctx, "/"+serviceName+"/Execute", req, new(emptypb.Empty))
+}
+
+func (c *providerClient) State(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	out := new(structpb.Struct)
+	err := c.cc.Invoke(ctx, "/"+serviceName+"/State", req, out)
+	return out, err
+}
+
+func (c *providerClient) WatchState(ctx context.Context) (grpc.ServerStreamingClient[structpb.Struct], error) {
+	stream, err := c.cc.NewStream(ctx, &serviceDesc.Streams[0], "/"+serviceName+"/WatchState")
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[emptypb.Empty, structpb.Struct]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(&emptypb.Empty{}); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func encodeState(s device.State) (*structpb.Struct, error) {
+	attrs := make(map[string]any, len(s.Attributes))
+	for k, v := range s.Attributes {
+		attrs[k] = v
+	}
+	return structpb.NewStruct(map[string]any{
+		"device_type": s.DeviceType,
+		"updated_at":  s.UpdatedAt.UTC().Format(time.RFC3339Nano),
+		"attributes":  attrs,
+	})
+}
+
+func decodeState(pb *structpb.Struct) device.State {
+	m := pb.AsMap()
+	state := device.State{Attributes: map[string]interface{}{}}
+	state.DeviceType, _ = m["device_type"].(string)
+	if ts, ok := m["updated_at"].(string); ok {
+		state.UpdatedAt, _ = time.Parse(time.RFC3339Nano, ts)
+	}
+	if attrs, ok := m["attributes"].(map[string]any); ok {
+		state.Attributes = attrs
+	}
+	return state
+}
+
+// toStatus maps device errors to gRPC codes so the hub can tell them apart.
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, device.ErrDeviceNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, device.ErrDeviceUnavailable) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	if errors.Is(err, context.Canceled) {
+		return status.Error(codes.Canceled, err.Error())
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	if errors.Is(err, device.ErrInvalidParameter) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, device.ErrUnknownCommand) {
+		return status.Error(codes.Unimplemented, err.Error())
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+// ErrPluginUnavailable is returned while the plugin process is down or restarting
+var ErrPluginUnavailable = fmt.Errorf("plugin %w", device.ErrDeviceUnavailable)
+
+// fromStatus is the reverse of toStatus on the hub side.
+func fromStatus(plugin string, err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	switch st.Code() {
+	case codes.NotFound:
+		return device.ErrDeviceNotFound
+	case codes.Unavailable:
+		return fmt.Errorf("%w: %s: %s", ErrPluginUnavailable, plugin, st.Message())
+	case codes.Canceled:
+		return context.Canceled
+	case codes.DeadlineExceeded:
+		return context.DeadlineExceeded
+	case codes.InvalidArgument:
+		return &remoteError{msg: st.Message(), err: device.ErrInvalidParameter}
+	case codes.Unimplemented:
+		return &remoteError{msg: st.Message(), err: device.ErrUnknownCommand}
+	default:
+		return errors.New(st.Message())
+	}
+}
+
+// remoteError is an error from the plugin: its message, standing for a device error
+// the hub knows.
+type remoteError struct {
+	msg string
+	err error
+}
+
+func (e *remoteError) Error() string { return e.msg }
+
+func (e *remoteError) Unwrap() error { return e.err }
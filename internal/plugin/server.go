@@ -0,0 +1,178 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Server lets a Go program serve device.Device implementations to the hub as a plugin:
+//
+//	srv := plugin.NewServer(myDevices...)
+//	log.Fatal(srv.Serve())
+type Server struct {
+	mu       sync.RWMutex
+	devices  map[device.ID]device.Device
+	order    []device.ID
+	watchers map[chan *structpb.Struct]struct{}
+}
+
+func NewServer(devices ...device.Device) *Server {
+	s := &Server{
+		devices:  make(map[device.ID]device.Device),
+		watchers: make(map[chan *structpb.Struct]struct{}),
+	}
+	for _, d := range devices {
+		s.devices[d.ID()] = d
+		s.order = append(s.order, d.ID())
+	}
+	return s
+}
+
+// Serve listens on the socket the hub asked for, writes the handshake line and serves
+// until the listener fails. It returns ErrNotPlugin when not launched by the hub.
+func (s *Server) Serve() error {
+	socket := os.Getenv(SocketEnv)
+	if socket == "" || os.Getenv(CookieEnv) != CookieValue {
+		return ErrNotPlugin
+	}
+
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", socket, err)
+	}
+
+	gs := grpc.NewServer()
+	gs.RegisterService(&serviceDesc, s)
+
+	fmt.Printf("%s|%s|unix|%s\n", HandshakePrefix, ProtocolVersion, socket)
+	return gs.Serve(lis)
+}
+
+// Publish pushes the current state of id to the hub. Execute calls it automatically
+// after every successful command; call it yourself for changes made outside the hub.
+func (s *Server) Publish(ctx context.Context, id device.ID) error {
+	s.mu.RLock()
+	d, ok := s.devices[id]
+	s.mu.RUnlock()
+	if !ok {
+		return device.ErrDeviceNotFound
+	}
+
+	state, err := d.State(ctx)
+	if err != nil {
+		return err
+	}
+	encoded, err := encodeState(state)
+	if err != nil {
+		return err
+	}
+	msg, err := structpb.NewStruct(map[string]any{"device_id": string(id)})
+	if err != nil {
+		return err
+	}
+	msg.Fields["state"] = structpb.NewStructValue(encoded)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.watchers {
+		select {
+		case ch <- msg:
+		default:
+			// A watcher that fell behind would keep a stale state, so end its
+			// stream: the hub drops what it cached and subscribes again
+			delete(s.watchers, ch)
+			close(ch)
+		}
+	}
+	return nil
+}
+
+func (s *Server) ListDevices(ctx context.Context, _ *emptypb.Empty) (*structpb.Struct, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	devices := make([]any, 0, len(s.order))
+	for _, id := range s.order {
+		info := map[string]any{"id": string(id)}
+		if state, err := s.devices[id].State(ctx); err == nil {
+			info["device_type"] = state.DeviceType
+		}
+		devices = append(devices, info)
+	}
+	return structpb.NewStruct(map[string]any{"devices": devices})
+}
+
+func (s *Server) Execute(ctx context.Context, req *structpb.Struct) (*emptypb.Empty, error) {
+	m := req.AsMap()
+	id, _ := m["device_id"].(string)
+	action, _ := m["action"].(string)
+	params, _ := m["params"].(map[string]any)
+
+	s.mu.RLock()
+	d, ok := s.devices[device.ID(id)]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, toStatus(device.ErrDeviceNotFound)
+	}
+
+	cmd := device.Command{DeviceID: device.ID(id), Action: action, Params: params}
+	if err := d.Execute(ctx, cmd); err != nil {
+		return nil, toStatus(err)
+	}
+	s.Publish(ctx, device.ID(id))
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) State(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	id, _ := req.AsMap()["device_id"].(string)
+
+	s.mu.RLock()
+	d, ok := s.devices[device.ID(id)]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, toStatus(device.ErrDeviceNotFound)
+	}
+
+	state, err := d.State(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return encodeState(state)
+}
+
+func (s *Server) WatchState(_ *emptypb.Empty, stream grpc.ServerStreamingServer[structpb.Struct]) error {
+	ch := make(chan *structpb.Struct, 64)
+	s.mu.Lock()
+	s.watchers[ch] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.watchers, ch)
+		s.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "watcher fell behind")
+			}
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/trace"
+)
+
+const (
+	StateStopped       = "stopped"
+	StateRunning       = "running"
+	StateFailed        = "failed"
+	StateNotConfigured = "not_configured"
+	StateDisabled      = "disabled"
+)
+
+type Status struct {
+	Name      string     `json:"name"`
+	Type      string     `json:"type"`
+	State     string     `json:"state"`
+	Error     string     `json:"error,omitempty"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	Devices   int        `json:"devices"`
+}
+
+// Manager starts and stops providers and keeps track of the devices each one registers.
+// Panics in provider code are recovered and reported as a failed provider.
+type Manager struct {
+	registry *device.Registry
+	env      Env
+
+	mu        sync.RWMutex
+	providers []*managed
+	byName    map[string]*managed
+	recorder  *trace.Recorder
+	recording map[string]bool
+}
+
+type managed struct {
+	provider Provider
+	typ      string
+	devices  *trackingRegistry
+
+	mu        sync.Mutex
+	state     string
+	err       string
+	startedAt time.Time
+}
+
+func NewManager(registry *device.Registry, env Env) *Manager {
+	if env.Devices == nil {
+		env.Devices = registry
+	}
+	return &Manager{
+		registry: registry,
+		env:      env,
+		byName:   make(map[string]*managed),
+	}
+}
+
+// Load creates a provider for every entry in cfg. Disabled entries are listed with
+// state disabled so they still show up in GET /providers.
+func (m *Manager) Load(cfg *config.Config) error {
+	for _, entry := range cfg.Providers {
+		name := entry.Name
+		if name == "" {
+			name = entry.Type
+		}
+
+		if !entry.IsEnabled() {
+			if err := m.add(&disabledProvider{name: name}, entry.Type, StateDisabled); err != nil {
+				return err
+			}
+			continue
+		}
+
+		factory, ok := lookupFactory(entry.Type)
+		if !ok {
+			return fmt.Errorf("provider %q: %w: %s", name, ErrUnknownType, entry.Type)
+		}
+
+		var p Provider
+		err := safeCall(func() error {
+			var err error
+			p, err = factory(name, entry.Settings, m.env)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("provider %q: %w", name, err)
+		}
+		if err := m.Add(p, entry.Type); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add registers an already constructed provider with the manager.
+func (m *Manager) Add(p Provider, typ string) error {
+	return m.add(p, typ, StateStopped)
+}
+
+// add registers p unless a provider of the same name already is, disabled or not.
+func (m *Manager) add(p Provider, typ, state string) error {
+	mp := &managed{
+		provider: p,
+		typ:      typ,
+		devices:  newTrackingRegistry(m.registry),
+		state:    state,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.byName[p.Name()]; exists {
+		return fmt.Errorf("provider %q already added", p.Name())
+	}
+	m.providers = append(m.providers, mp)
+	m.byName[p.Name()] = mp
+	m.record(mp)
+	return nil
+}
+
+// Record makes providers record the traffic to and from their devices into rec: those
+// named, or all of them if none are. Only devices registered after the call are
+// recorded, so it belongs before StartAll.
+func (m *Manager) Record(rec *trace.Recorder, names ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recorder = rec
+	m.recording = make(map[string]bool, len(names))
+	for _, name := range names {
+		m.recording[name] = true
+	}
+	for _, mp := range m.providers {
+		m.record(mp)
+	}
+}
+
+// record sets up recording for a provider if it is to be recorded. Must be called
+// with mu held.
+func (m *Manager) record(mp *managed) {
+	name := mp.provider.Name()
+	if m.recorder == nil || (len(m.recording) > 0 && !m.recording[name]) {
+		return
+	}
+	mp.devices.mu.Lock()
+	defer mp.devices.mu.Unlock()
+	mp.devices.provider = name
+	mp.devices.recorder = m.recorder
+}
+
+// StartAll starts every provider and runs its first discovery. A provider that fails
+// to start is logged and left failed; the others carry on.
+func (m *Manager) StartAll(ctx context.Context) {
+	for _, mp := range m.list() {
+		if err := m.start(ctx, mp); err != nil {
+			log.Printf("Provider %s: %v", mp.provider.Name(), err)
+		}
+	}
+}
+
+func (m *Manager) start(ctx context.Context, mp *managed) error {
+	mp.mu.Lock()
+	if mp.state == StateDisabled || mp.state == StateRunning {
+		mp.mu.Unlock()
+		return nil
+	}
+	mp.mu.Unlock()
+
+	name := mp.provider.Name()
+	err := safeCall(func() error { return mp.provider.Start(ctx, mp.devices) })
+	if err != nil {
+		if errors.Is(err, ErrNotConfigured) {
+			mp.setState(StateNotConfigured, err)
+			return err
+		}
+		mp.setState(StateFailed, err)
+		mp.devices.unregisterAll()
+		return fmt.Errorf("failed to start: %w", err)
+	}
+
+	mp.mu.Lock()
+	mp.state = StateRunning
+	mp.err = ""
+	mp.startedAt = time.Now()
+	mp.mu.Unlock()
+	log.Printf("Provider %s started", name)
+
+	return m.discover(ctx, mp)
+}
+
+// Discover asks a running provider to look for new devices.
+func (m *Manager) Discover(ctx context.Context, name string) error {
+	mp, ok := m.get(name)
+	if !ok {
+		return fmt.Errorf("provider %q not found", name)
+	}
+	mp.mu.Lock()
+	running := mp.state == StateRunning
+	mp.mu.Unlock()
+	if !running {
+		return fmt.Errorf("provider %q is not running", name)
+	}
+	return m.discover(ctx, mp)
+}
+
+func (m *Manager) discover(ctx context.Context, mp *managed) error {
+	err := safeCall(func() error { return mp.provider.Discover(ctx) })
+
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	if err != nil {
+		mp.err = fmt.Sprintf("discovery failed: %v", err)
+		return fmt.Errorf("discovery failed: %w", err)
+	}
+	mp.err = ""
+	return nil
+}
+
+// StopAll stops providers in the reverse order they were added and unregisters the
+// devices they registered.
+func (m *Manager) StopAll(ctx context.Context) {
+	providers := m.list()
+	for i := len(providers) - 1; i >= 0; i-- {
+		mp := providers[i]
+		mp.mu.Lock()
+		running := mp.state == StateRunning
+		mp.mu.Unlock()
+		if !running {
+			continue
+		}
+
+		err := safeCall(func() error { return mp.provider.Stop(ctx) })
+		mp.devices.unregisterAll()
+		if err != nil {
+			log.Printf("Provider %s: failed to stop cleanly: %v", mp.provider.Name(), err)
+			mp.setState(StateStopped, err)
+			continue
+		}
+		mp.setState(StateStopped, nil)
+		log.Printf("Provider %s stopped", mp.provider.Name())
+	}
+}
+
+func (m *Manager) Statuses() []Status {
+	providers := m.list()
+	statuses := make([]Status, 0, len(providers))
+	for _, mp := range providers {
+		statuses = append(statuses, mp.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+func (m *Manager) Status(name string) (Status, bool) {
+	mp, ok := m.get(name)
+	if !ok {
+		return Status{}, false
+	}
+	return mp.status(), true
+}
+
+// Health asks the provider for its health. Providers that are not running report
+// down without being called.
+func (m *Manager) Health(ctx context.Context, name string) (Health, bool) {
+	mp, ok := m.get(name)
+	if !ok {
+		return Health{}, false
+	}
+
+	status := mp.status()
+	if status.State != StateRunning {
+		return Health{Status: HealthDown, Message: status.State}, true
+	}
+
+	var health Health
+	err := safeCall(func() error {
+		health = mp.provider.Health(ctx)
+		return nil
+	})
+	if err != nil {
+		return Health{Status: HealthDown, Message: err.Error()}, true
+	}
+	if health.Status == "" {
+		health.Status = HealthOK
+	}
+	return health, true
+}
+
+// Provider returns the named provider, for callers that need a provider-specific API.
+func (m *Manager) Provider(name string) (Provider, bool) {
+	mp, ok := m.get(name)
+	if !ok {
+		return nil, false
+	}
+	return mp.provider, true
+}
+
+// ProviderOf returns the name of the provider that registered id.
+func (m *Manager) ProviderOf(id device.ID) (string, bool) {
+	for _, mp := range m.list() {
+		if mp.devices.owns(id) {
+			return mp.provider.Name(), true
+		}
+	}
+	return "", false
+}
+
+func (m *Manager) list() []*managed {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]*managed(nil), m.providers...)
+}
+
+func (m *Manager) get(name string) (*managed, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	mp, ok := m.byName[name]
+	return mp, ok
+}
+
+func (mp *managed) setState(state string, err error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.state = state
+	mp.err = ""
+	if err != nil {
+		mp.err = err.Error()
+	}
+}
+
+func (mp *managed) status() Status {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	s := Status{
+		Name:    mp.provider.Name(),
+		Type:    mp.typ,
+		State:   mp.state,
+		Error:   mp.err,
+		Devices: mp.devices.count(),
+	}
+	if !mp.startedAt.IsZero() {
+		startedAt := mp.startedAt
+		s.StartedAt = &startedAt
+	}
+	return s
+}
+
+// safeCall runs fn, turning a panic into an error so one misbehaving provider cannot
+// take the hub down.
+func safeCall(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("provider panic: %v\n%s", r, debug.Stack())
+			err = fmt.Errorf("provider panicked: %v", r)
+		}
+	}()
+	return fn()
+}
+
+// trackingRegistry records which devices a provider registered, and with a recorder
+// wraps them to record their traffic.
+type trackingRegistry struct {
+	registry *device.Registry
+
+	mu       sync.Mutex
+	ids      map[device.ID]bool
+	provider string
+	recorder *trace.Recorder
+}
+
+func newTrackingRegistry(registry *device.Registry) *trackingRegistry {
+	return &trackingRegistry{
+		registry: registry,
+		ids:      make(map[device.ID]bool),
+	}
+}
+
+func (t *trackingRegistry) Register(d device.Device) error {
+	t.mu.Lock()
+	recorder, provider := t.recorder, t.provider
+	t.mu.Unlock()
+	if recorder != nil {
+		d = recorder.Wrap(provider, d)
+	}
+
+	if err := t.registry.Register(d); err != nil {
+		return err
+	}
+	if recorder != nil {
+		recorder.Registered(provider, d.ID())
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ids[d.ID()] = true
+	return nil
+}
+
+func (t *trackingRegistry) Unregister(id device.ID) {
+	t.mu.Lock()
+	owned := t.ids[id]
+	delete(t.ids, id)
+	t.mu.Unlock()
+
+	// Only remove devices this provider registered itself
+	if owned {
+		t.registry.Unregister(id)
+	}
+}
+
+func (t *trackingRegistry) unregisterAll() {
+	t.mu.Lock()
+	ids := t.ids
+	t.ids = make(map[device.ID]bool)
+	t.mu.Unlock()
+
+	for id := range ids {
+		t.registry.Unregister(id)
+	}
+}
+
+func (t *trackingRegistry) owns(id device.ID) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ids[id]
+}
+
+func (t *trackingRegistry) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.ids)
+}
+
+type disabledProvider struct {
+	name string
+}
+
+func (d *disabledProvider) Name() string                          { return d.name }
+func (d *disabledProvider) Start(context.Context, Registry) error { return nil }
+func (d *disabledProvider) Stop(context.Context) error            { return nil }
+func (d *disabledProvider) Discover(context.Context) error        { return nil }
+func (d *disabledProvider) Health(context.Context) Health         { return Health{Status: HealthDown} }
@@ -0,0 +1,242 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/trace"
+)
+
+type fakeDevice struct {
+	id device.ID
+}
+
+func (d *fakeDevice) ID() device.ID { return d.id }
+
+func (d *fakeDevice) Execute(ctx context.Context, cmd device.Command) error { return nil }
+
+func (d *fakeDevice) State(ctx context.Context) (device.State, error) {
+	return device.State{DeviceType: "light"}, nil
+}
+
+type fakeProvider struct {
+	name        string
+	devices     []device.ID
+	startErr    error
+	panicOn     string
+	registry    Registry
+	stopped     bool
+	discoveries int
+}
+
+func (p *fakeProvider) Name() string { return p.name }
+
+func (p *fakeProvider) Start(ctx context.Context, registry Registry) error {
+	if p.panicOn == "start" {
+		panic("boom")
+	}
+	p.registry = registry
+	return p.startErr
+}
+
+func (p *fakeProvider) Discover(ctx context.Context) error {
+	p.discoveries++
+	for _, id := range p.devices {
+		p.registry.Register(&fakeDevice{id: id})
+	}
+	return nil
+}
+
+func (p *fakeProvider) Stop(ctx context.Context) error {
+	p.stopped = true
+	return nil
+}
+
+func (p *fakeProvider) Health(ctx context.Context) Health {
+	if p.panicOn == "health" {
+		panic("boom")
+	}
+	return Health{Status: HealthOK}
+}
+
+func TestManager_StartRegistersAndStopUnregisters(t *testing.T) {
+	ctx := context.Background()
+	registry := device.NewRegistry()
+	m := NewManager(registry, Env{})
+
+	p := &fakeProvider{name: "fake", devices: []device.ID{"fake-1", "fake-2"}}
+	if err := m.Add(p, "fake"); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	m.StartAll(ctx)
+
+	status, _ := m.Status("fake")
+	if status.State != StateRunning || status.Devices != 2 {
+		t.Fatalf("Expected running with 2 devices, got %+v", status)
+	}
+	if owner, _ := m.ProviderOf("fake-1"); owner != "fake" {
+		t.Errorf("Expected fake-1 to belong to fake, got %q", owner)
+	}
+
+	m.StopAll(ctx)
+
+	if !p.stopped {
+		t.Error("Expected provider to be stopped")
+	}
+	if n := len(registry.List()); n != 0 {
+		t.Errorf("Expected devices to be unregistered, %d left", n)
+	}
+}
+
+func TestManager_PanicsAreIsolated(t *testing.T) {
+	ctx := context.Background()
+	registry := device.NewRegistry()
+	m := NewManager(registry, Env{})
+
+	m.Add(&fakeProvider{name: "bad", panicOn: "start"}, "fake")
+	m.Add(&fakeProvider{name: "flaky", panicOn: "health", devices: []device.ID{"flaky-1"}}, "fake")
+	m.Add(&fakeProvider{name: "good", devices: []device.ID{"good-1"}}, "fake")
+	m.StartAll(ctx)
+
+	if status, _ := m.Status("bad"); status.State != StateFailed || status.Error == "" {
+		t.Errorf("Expected bad provider to be failed, got %+v", status)
+	}
+	if status, _ := m.Status("good"); status.State != StateRunning {
+		t.Errorf("Expected good provider to be running, got %+v", status)
+	}
+
+	health, _ := m.Health(ctx, "flaky")
+	if health.Status != HealthDown {
+		t.Errorf("Expected panicking health check to report down, got %+v", health)
+	}
+	if _, err := registry.Get("good-1"); err != nil {
+		t.Errorf("Expected good-1 to be registered: %v", err)
+	}
+}
+
+func TestManager_NotConfigured(t *testing.T) {
+	m := NewManager(device.NewRegistry(), Env{})
+	m.Add(&fakeProvider{name: "hue", startErr: ErrNotConfigured}, "fake")
+	m.StartAll(context.Background())
+
+	status, _ := m.Status("hue")
+	if status.State != StateNotConfigured {
+		t.Errorf("Expected not_configured, got %+v", status)
+	}
+}
+
+func TestManager_LoadFromConfig(t *testing.T) {
+	RegisterFactory("test-fake", func(name string, raw json.RawMessage, env Env) (Provider, error) {
+		var settings struct {
+			Devices []device.ID `json:"devices"`
+		}
+		if err := DecodeSettings(raw, &settings); err != nil {
+			return nil, err
+		}
+		return &fakeProvider{name: name, devices: settings.Devices}, nil
+	})
+
+	disabled := false
+	cfg := &config.Config{
+		Providers: []config.Provider{
+			{Name: "one", Type: "test-fake", Settings: json.RawMessage(`{"devices":["one-1"]}`)},
+			{Name: "two", Type: "test-fake", Enabled: &disabled},
+		},
+	}
+
+	registry := device.NewRegistry()
+	m := NewManager(registry, Env{})
+	if err := m.Load(cfg); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	m.StartAll(context.Background())
+
+	statuses := m.Statuses()
+	if len(statuses) != 2 {
+		t.Fatalf("Expected 2 providers, got %+v", statuses)
+	}
+	if statuses[0].State != StateRunning || statuses[1].State != StateDisabled {
+		t.Errorf("Unexpected states: %+v", statuses)
+	}
+	if _, err := registry.Get("one-1"); err != nil {
+		t.Errorf("Expected one-1 to be registered: %v", err)
+	}
+
+	err := NewManager(registry, Env{}).Load(&config.Config{
+		Providers: []config.Provider{{Name: "x", Type: "does-not-exist"}},
+	})
+	if !errors.Is(err, ErrUnknownType) {
+		t.Errorf("Expected ErrUnknownType, got %v", err)
+	}
+}
+
+func TestManager_DuplicateNames(t *testing.T) {
+	disabled := false
+	err := NewManager(device.NewRegistry(), Env{}).Load(&config.Config{
+		Providers: []config.Provider{
+			{Name: "lights", Type: "test-fake", Enabled: &disabled},
+			{Name: "lights", Type: "test-fake", Enabled: &disabled},
+		},
+	})
+	if err == nil {
+		t.Error("Expected a disabled provider to clash with one of the same name")
+	}
+
+	m := NewManager(device.NewRegistry(), Env{})
+	var wg sync.WaitGroup
+	var added atomic.Int32
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if m.Add(&fakeProvider{name: "lights"}, "test-fake") == nil {
+				added.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := added.Load(); n != 1 || len(m.Statuses()) != 1 {
+		t.Errorf("Expected one of the concurrent adds to win, got %d adds and %+v", n, m.Statuses())
+	}
+}
+
+func TestManager_Record(t *testing.T) {
+	ctx := context.Background()
+	registry := device.NewRegistry()
+	m := NewManager(registry, Env{})
+	m.Add(&fakeProvider{name: "hue", devices: []device.ID{"hue-1"}}, "fake")
+	m.Add(&fakeProvider{name: "kasa", devices: []device.ID{"kasa-1"}}, "fake")
+
+	var buf bytes.Buffer
+	m.Record(trace.NewRecorder(&buf), "hue")
+	m.StartAll(ctx)
+
+	hue, _ := registry.Get("hue-1")
+	hue.Execute(ctx, device.Command{DeviceID: "hue-1", Action: "turn_on"})
+	hue.State(ctx)
+	kasa, _ := registry.Get("kasa-1")
+	kasa.State(ctx)
+
+	events, err := trace.Read(&buf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	var kinds []string
+	for _, e := range events {
+		if e.Provider != "hue" || e.Device != "hue-1" {
+			t.Errorf("Expected only hue-1 to be recorded, got %+v", e)
+		}
+		kinds = append(kinds, e.Kind)
+	}
+	if want := []string{trace.KindRegister, trace.KindCommand, trace.KindState}; !slices.Equal(kinds, want) {
+		t.Errorf("Expected %v, got %v", want, kinds)
+	}
+}
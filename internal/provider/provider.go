@@ -0,0 +1,109 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+// ErrNotConfigured is returned from Start by providers that are enabled but missing
+// the settings they need (e.g. no bridge address). The manager reports them as
+// not configured instead of failed.
+var ErrNotConfigured = errors.New("provider not configured")
+
+var ErrUnknownType = errors.New("unknown provider type")
+
+// Registry is the part of device.Registry a provider may use. The manager hands each
+// provider its own view so it can remove the provider's devices when it stops.
+type Registry interface {
+	Register(d device.Device) error
+	Unregister(id device.ID)
+}
+
+// Provider is an integration that owns a set of devices.
+type Provider interface {
+	Name() string
+	// Start connects to the integration. Devices are registered by Discover.
+	Start(ctx context.Context, registry Registry) error
+	Stop(ctx context.Context) error
+	// Discover registers devices that are not registered yet. It may be called again
+	// at any time after Start.
+	Discover(ctx context.Context) error
+	Health(ctx context.Context) Health
+}
+
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+	HealthDown     = "down"
+)
+
+type Health struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	Details any    `json:"details,omitempty"`
+}
+
+// Env carries the hub-wide services a factory may hand to its provider.
+type Env struct {
+	Guards *resilience.Registry
+	// Devices is the full device registry, for providers that export or act on
+	// other providers' devices. Register through the Registry passed to Start instead.
+	Devices *device.Registry
+}
+
+// Factory creates a provider called name from the raw settings of its config entry.
+type Factory func(name string, settings json.RawMessage, env Env) (Provider, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// RegisterFactory makes a provider type available to the config. Providers call it
+// from init, so importing the package is enough to enable the integration.
+func RegisterFactory(typ string, f Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if _, exists := factories[typ]; exists {
+		panic(fmt.Sprintf("provider: factory for %q registered twice", typ))
+	}
+	factories[typ] = f
+}
+
+func lookupFactory(typ string) (Factory, bool) {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	f, ok := factories[typ]
+	return f, ok
+}
+
+// Types lists the registered provider types.
+func Types() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	types := make([]string, 0, len(factories))
+	for t := range factories {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// DecodeSettings unmarshals a provider's settings into v, leaving v untouched when
+// there are none.
+func DecodeSettings(settings json.RawMessage, v any) error {
+	if len(settings) == 0 || string(settings) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(settings, v); err != nil {
+		return fmt.Errorf("invalid settings: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// Package providertest runs providers the way the hub does, for their tests.
+package providertest
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/device/devicetest"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+)
+
+// Start creates a provider of type typ from settings and runs it under a manager until
+// the test ends. The provider is named after its type. The test fails unless the
+// provider started cleanly.
+func Start(t *testing.T, newProvider provider.Factory, typ string, settings any) (*device.Registry, *provider.Manager) {
+	t.Helper()
+
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("Invalid settings: %v", err)
+	}
+	p, err := newProvider(typ, raw, provider.Env{})
+	if err != nil {
+		t.Fatalf("NewProvider failed: %v", err)
+	}
+
+	registry := device.NewRegistry()
+	m := provider.NewManager(registry, provider.Env{})
+	m.Add(p, typ)
+	m.StartAll(context.Background())
+	t.Cleanup(func() { m.StopAll(context.Background()) })
+
+	if status, _ := m.Status(typ); status.State != provider.StateRunning || status.Error != "" {
+		t.Fatalf("Expected provider to be running, got %+v", status)
+	}
+	return registry, m
+}
+
+// Addresses lists the addresses of fake devices, for a provider's settings.
+func Addresses[T any](fakes []T, address func(T) string) []string {
+	addresses := make([]string, 0, len(fakes))
+	for _, f := range fakes {
+		addresses = append(addresses, address(f))
+	}
+	return addresses
+}
+
+// WaitFor polls cond until it holds, failing the test once timeout has passed.
+func WaitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// LightConformance runs the devicetest suite for a dimmable light against the device
+// registered as id. start runs the provider for a fresh fake device each time.
+func LightConformance(t *testing.T, id device.ID, start func(t *testing.T) *device.Registry) {
+	t.Helper()
+	devicetest.Run(t, devicetest.Light(func(t *testing.T) device.Device {
+		dev, err := start(t).Get(id)
+		if err != nil {
+			t.Fatalf("Expected %s to be registered: %v", id, err)
+		}
+		return dev
+	}))
+}
@@ -0,0 +1,23 @@
+// Package all links every built-in provider into the hub. Adding an integration only
+// needs an import here and an entry in the config file.
+package all
+
+import (
+	_ "github.com/legitlolly/SmartHomeHub/internal/mqttbridge"
+	_ "github.com/legitlolly/SmartHomeHub/internal/plugin"
+	_ "github.com/legitlolly/SmartHomeHub/internal/providers/esphome"
+	_ "github.com/legitlolly/SmartHomeHub/internal/providers/hue"
+	_ "github.com/legitlolly/SmartHomeHub/internal/providers/kasa"
+	_ "github.com/legitlolly/SmartHomeHub/internal/providers/knx"
+	_ "github.com/legitlolly/SmartHomeHub/internal/providers/lifx"
+	_ "github.com/legitlolly/SmartHomeHub/internal/providers/modbus"
+	_ "github.com/legitlolly/SmartHomeHub/internal/providers/mqtt"
+	_ "github.com/legitlolly/SmartHomeHub/internal/providers/replay"
+	_ "github.com/legitlolly/SmartHomeHub/internal/providers/shelly"
+	_ "github.com/legitlolly/SmartHomeHub/internal/providers/simulator"
+	_ "github.com/legitlolly/SmartHomeHub/internal/providers/wiz"
+	_ "github.com/legitlolly/SmartHomeHub/internal/providers/wled"
+	_ "github.com/legitlolly/SmartHomeHub/internal/providers/yeelight"
+	_ "github.com/legitlolly/SmartHomeHub/internal/providers/zigbee2mqtt"
+	_ "github.com/legitlolly/SmartHomeHub/internal/providers/zwavejs"
+)
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+const testKey = "px7tsbK3C7bpXHr2OevEV2ZMg/FrNBw2+O2pNPbedtA="
+
+func TestMarshal_SwitchCommand(t *testing.T) {
+	got := Marshal(&SwitchCommandRequest{Key: 0x01020304, State: true})
+	want, _ := hex.DecodeString("0d04030201" + "1001")
+	if !bytes.Equal(got, want) {
+		t.Errorf("Unexpected payload\n got % x\nwant % x", got, want)
+	}
+}
+
+func TestUnmarshal_RoundTrip(t *testing.T) {
+	messages := []Message{
+		&HelloRequest{ClientInfo: "hub", APIVersionMajor: 1, APIVersionMinor: 10},
+		&ConnectResponse{InvalidPassword: true},
+		&DeviceInfoResponse{Name: "kitchen", MACAddress: "AC:67:B2:00:00:01", ESPHomeVersion: "2024.6.0", Model: "esp32dev"},
+		&ListEntitiesResponse{MessageType: TypeListEntitiesSensorResponse, ObjectID: "temp", Key: 7, Name: "Temp", DeviceClass: "temperature", UnitOfMeasurement: "°C", AccuracyDecimals: 1},
+		&ListEntitiesResponse{MessageType: TypeListEntitiesLightResponse, ObjectID: "strip", Key: 8, SupportedColorModes: []uint32{3, 35}, MinMireds: 153, MaxMireds: 500, Effects: []string{"None", "Rainbow"}},
+		&StateResponse{MessageType: TypeSensorStateResponse, Key: 7, Value: 21.5},
+		&StateResponse{MessageType: TypeBinarySensorStateResponse, Key: 9, State: true, MissingState: true},
+		&LightStateResponse{Key: 8, State: true, Brightness: 0.5, Red: 1, ColorMode: 35, ColorTemperature: 370},
+		&LightCommandRequest{Key: 8, HasState: true, State: true, HasRGB: true, Green: 1, HasColorTemperature: true, ColorTemperature: 250},
+		&Empty{MessageType: TypePingRequest},
+	}
+	for _, msg := range messages {
+		got, err := Unmarshal(msg.Type(), Marshal(msg))
+		if err != nil {
+			t.Errorf("%T: Unmarshal failed: %v", msg, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, msg) {
+			t.Errorf("%T: expected %+v, got %+v", msg, msg, got)
+		}
+	}
+}
+
+func TestUnmarshal_UnpackedColorModes(t *testing.T) {
+	// Two color modes as separate varint fields
+	payload, _ := hex.DecodeString("6003" + "6023")
+	msg, err := Unmarshal(TypeListEntitiesLightResponse, payload)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if modes := msg.(*ListEntitiesResponse).SupportedColorModes; !reflect.DeepEqual(modes, []uint32{3, 35}) {
+		t.Errorf("Expected modes [3 35], got %v", modes)
+	}
+
+	if _, err := Unmarshal(TypeSwitchStateResponse, []byte{0x0d, 0x01}); !errors.Is(err, ErrBadFrame) {
+		t.Errorf("Expected ErrBadFrame for a truncated payload, got %v", err)
+	}
+	if msg, _ := Unmarshal(999, []byte{0x08, 0x01}); msg.Type() != 999 {
+		t.Errorf("Expected an unknown message to keep its type, got %d", msg.Type())
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	psk, _ := ParseKey(testKey)
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		conn, err := ServerHandshake(server, psk, "kitchen")
+		if err == nil {
+			var msg Message
+			if msg, err = conn.Receive(); err == nil {
+				err = conn.Send(msg)
+			}
+		}
+		done <- err
+	}()
+
+	conn, name, err := ClientHandshake(client, psk)
+	if err != nil {
+		t.Fatalf("ClientHandshake failed: %v", err)
+	}
+	if name != "kitchen" {
+		t.Errorf("Expected node name kitchen, got %q", name)
+	}
+	sent := &SwitchCommandRequest{Key: 42, State: true}
+	if err := conn.Send(sent); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	got, err := conn.Receive()
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, sent) {
+		t.Errorf("Expected echo of %+v, got %+v", sent, got)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Server failed: %v", err)
+	}
+}
+
+func TestHandshake_WrongKey(t *testing.T) {
+	psk, _ := ParseKey(testKey)
+	wrong := bytes.Repeat([]byte{1}, 32)
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go ServerHandshake(server, psk, "kitchen")
+	if _, _, err := ClientHandshake(client, wrong); !errors.Is(err, ErrHandshake) {
+		t.Errorf("Expected ErrHandshake, got %v", err)
+	}
+
+	if _, err := ParseKey("c2hvcnQ="); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey for a short key, got %v", err)
+	}
+}
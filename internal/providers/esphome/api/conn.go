@@ -0,0 +1,302 @@
+// Package api implements the transport of the ESPHome native API: protobuf messages
+// over TCP, either in plaintext frames or encrypted with the Noise protocol. It is
+// shared by the provider and the fake node in esphometest.
+//
+// Plaintext frames are a zero byte, the payload size and message type as varints,
+// and the payload. Noise frames are a one byte, a big-endian 16-bit size and the
+// frame; after the handshake each frame decrypts to a 16-bit type, a 16-bit size
+// and the payload.
+package api
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/flynn/noise"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// DefaultPort is where nodes listen for API connections.
+const DefaultPort = 6053
+
+const (
+	indicatorPlaintext = 0x00
+	indicatorNoise     = 0x01
+	maxNoiseFrame      = 65535
+	maxPlaintextSize   = 1 << 20
+)
+
+var (
+	ErrBadFrame         = errors.New("invalid esphome api frame")
+	ErrHandshake        = errors.New("esphome noise handshake failed")
+	ErrEncryptionNeeded = errors.New("esphome node requires encryption")
+	ErrInvalidKey       = errors.New("invalid esphome encryption key")
+)
+
+var (
+	noisePrologue = []byte("NoiseAPIInit\x00\x00")
+	cipherSuite   = noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashSHA256)
+)
+
+// ParseKey decodes a base64 encryption key from the node's YAML.
+func ParseKey(key string) ([]byte, error) {
+	psk, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(psk) != 32 {
+		return nil, fmt.Errorf("%w: expected 32 bytes of base64", ErrInvalidKey)
+	}
+	return psk, nil
+}
+
+// Conn sends and receives messages over one connection. Sends may be concurrent
+// with each other and with Receive; Receive must be called from one goroutine.
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// Set once the noise handshake is done
+	encrypt, decrypt *noise.CipherState
+
+	writeMu sync.Mutex
+}
+
+// NewPlaintextConn wraps a connection that doesn't use encryption.
+func NewPlaintextConn(conn net.Conn) *Conn {
+	return &Conn{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// NetConn is the underlying connection, for deadlines.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// Send encodes and writes msg.
+func (c *Conn) Send(msg Message) error {
+	payload := msg.marshal(nil)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.encrypt == nil {
+		frame := []byte{indicatorPlaintext}
+		frame = protowire.AppendVarint(frame, uint64(len(payload)))
+		frame = protowire.AppendVarint(frame, uint64(msg.Type()))
+		_, err := c.conn.Write(append(frame, payload...))
+		return err
+	}
+
+	plain := binary.BigEndian.AppendUint16(nil, msg.Type())
+	plain = binary.BigEndian.AppendUint16(plain, uint16(len(payload)))
+	cipher, err := c.encrypt.Encrypt(nil, nil, append(plain, payload...))
+	if err != nil {
+		return err
+	}
+	return c.writeNoiseFrame(cipher)
+}
+
+// Receive reads and decodes the next message.
+func (c *Conn) Receive() (Message, error) {
+	if c.decrypt == nil {
+		indicator, err := c.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if indicator == indicatorNoise {
+			return nil, ErrEncryptionNeeded
+		}
+		if indicator != indicatorPlaintext {
+			return nil, fmt.Errorf("%w: indicator %#02x", ErrBadFrame, indicator)
+		}
+		size, err := binary.ReadUvarint(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		typ, err := binary.ReadUvarint(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		if size > maxPlaintextSize || typ > 0xffff {
+			return nil, fmt.Errorf("%w: size %d, type %d", ErrBadFrame, size, typ)
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(c.reader, payload); err != nil {
+			return nil, err
+		}
+		return Unmarshal(uint16(typ), payload)
+	}
+
+	frame, err := c.readNoiseFrame()
+	if err != nil {
+		return nil, err
+	}
+	plain, err := c.decrypt.Decrypt(nil, nil, frame)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadFrame, err)
+	}
+	if len(plain) < 4 || int(binary.BigEndian.Uint16(plain[2:])) != len(plain)-4 {
+		return nil, fmt.Errorf("%w: bad message header", ErrBadFrame)
+	}
+	return Unmarshal(binary.BigEndian.Uint16(plain), plain[4:])
+}
+
+func (c *Conn) writeNoiseFrame(frame []byte) error {
+	if len(frame) > maxNoiseFrame {
+		return fmt.Errorf("%w: frame of %d bytes", ErrBadFrame, len(frame))
+	}
+	header := []byte{indicatorNoise, 0, 0}
+	binary.BigEndian.PutUint16(header[1:], uint16(len(frame)))
+	_, err := c.conn.Write(append(header, frame...))
+	return err
+}
+
+func (c *Conn) readNoiseFrame() ([]byte, error) {
+	var header [3]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != indicatorNoise {
+		return nil, fmt.Errorf("%w: indicator %#02x", ErrBadFrame, header[0])
+	}
+	frame := make([]byte, binary.BigEndian.Uint16(header[1:]))
+	if _, err := io.ReadFull(c.reader, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// ClientHandshake runs the Noise_NNpsk0 handshake as the client and returns the
+// encrypted connection and the node name from the server hello.
+func ClientHandshake(conn net.Conn, psk []byte) (*Conn, string, error) {
+	c := NewPlaintextConn(conn)
+	hs, err := noise.NewHandshakeState(noise.Config{
+		CipherSuite:           cipherSuite,
+		Pattern:               noise.HandshakeNN,
+		Initiator:             true,
+		Prologue:              noisePrologue,
+		PresharedKey:          psk,
+		PresharedKeyPlacement: 0,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	// An empty hello frame, then the first handshake message behind a zero byte
+	msg, _, _, err := hs.WriteMessage([]byte{0}, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := c.writeNoiseFrame(nil); err != nil {
+		return nil, "", err
+	}
+	if err := c.writeNoiseFrame(msg); err != nil {
+		return nil, "", err
+	}
+
+	// The server hello chooses the protocol and names the node
+	hello, err := c.readNoiseFrame()
+	if err != nil {
+		return nil, "", noiseReadErr(err)
+	}
+	if len(hello) < 1 || hello[0] != indicatorNoise {
+		return nil, "", fmt.Errorf("%w: unsupported protocol", ErrHandshake)
+	}
+	name, _, _ := cutNul(hello[1:])
+
+	reply, err := c.readNoiseFrame()
+	if err != nil {
+		return nil, "", noiseReadErr(err)
+	}
+	if len(reply) < 1 {
+		return nil, "", fmt.Errorf("%w: empty reply", ErrHandshake)
+	}
+	if reply[0] != 0 {
+		return nil, "", fmt.Errorf("%w: %s", ErrHandshake, reply[1:])
+	}
+	_, encrypt, decrypt, err := hs.ReadMessage(nil, reply[1:])
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v (wrong key?)", ErrHandshake, err)
+	}
+	c.encrypt, c.decrypt = encrypt, decrypt
+	return c, name, nil
+}
+
+// noiseReadErr explains a connection closed in the middle of the handshake, which
+// is what a node with encryption off does with a noise hello.
+func noiseReadErr(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: connection closed (is encryption enabled on the node?)", ErrHandshake)
+	}
+	if errors.Is(err, ErrBadFrame) {
+		return fmt.Errorf("%w: %v (is encryption enabled on the node?)", ErrHandshake, err)
+	}
+	return err
+}
+
+// ServerHandshake runs the handshake as a node called name. A client that starts
+// in plaintext is told that encryption is required.
+func ServerHandshake(conn net.Conn, psk []byte, name string) (*Conn, error) {
+	c := NewPlaintextConn(conn)
+	hs, err := noise.NewHandshakeState(noise.Config{
+		CipherSuite:           cipherSuite,
+		Pattern:               noise.HandshakeNN,
+		Prologue:              noisePrologue,
+		PresharedKey:          psk,
+		PresharedKeyPlacement: 0,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := c.readNoiseFrame(); err != nil {
+		if errors.Is(err, ErrBadFrame) {
+			// A plaintext client: answer with a noise frame it will reject
+			c.writeNoiseFrame([]byte{indicatorNoise})
+			return nil, ErrEncryptionNeeded
+		}
+		return nil, err
+	}
+	msg, err := c.readNoiseFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	hello := append([]byte{indicatorNoise}, name...)
+	if err := c.writeNoiseFrame(append(hello, 0)); err != nil {
+		return nil, err
+	}
+
+	if len(msg) < 1 || msg[0] != 0 {
+		c.writeNoiseFrame(append([]byte{1}, "Bad handshake"...))
+		return nil, fmt.Errorf("%w: bad handshake message", ErrHandshake)
+	}
+	if _, _, _, err := hs.ReadMessage(nil, msg[1:]); err != nil {
+		c.writeNoiseFrame(append([]byte{1}, "Handshake MAC failure"...))
+		return nil, fmt.Errorf("%w: %v", ErrHandshake, err)
+	}
+	reply, decrypt, encrypt, err := hs.WriteMessage([]byte{0}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.writeNoiseFrame(reply); err != nil {
+		return nil, err
+	}
+	c.encrypt, c.decrypt = encrypt, decrypt
+	return c, nil
+}
+
+func cutNul(b []byte) (string, []byte, bool) {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i]), b[i+1:], true
+		}
+	}
+	return string(b), nil, false
+}
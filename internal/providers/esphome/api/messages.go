@@ -0,0 +1,609 @@
+package api
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Message types from ESPHome's api.proto, for the messages the hub uses.
+const (
+	TypeHelloRequest                     = 1
+	TypeHelloResponse                    = 2
+	TypeConnectRequest                   = 3
+	TypeConnectResponse                  = 4
+	TypeDisconnectRequest                = 5
+	TypeDisconnectResponse               = 6
+	TypePingRequest                      = 7
+	TypePingResponse                     = 8
+	TypeDeviceInfoRequest                = 9
+	TypeDeviceInfoResponse               = 10
+	TypeListEntitiesRequest              = 11
+	TypeListEntitiesBinarySensorResponse = 12
+	TypeListEntitiesLightResponse        = 15
+	TypeListEntitiesSensorResponse       = 16
+	TypeListEntitiesSwitchResponse       = 17
+	TypeListEntitiesDoneResponse         = 19
+	TypeSubscribeStatesRequest           = 20
+	TypeBinarySensorStateResponse        = 21
+	TypeLightStateResponse               = 24
+	TypeSensorStateResponse              = 25
+	TypeSwitchStateResponse              = 26
+	TypeLightCommandRequest              = 32
+	TypeSwitchCommandRequest             = 33
+)
+
+// Message is a protobuf message of the API.
+type Message interface {
+	Type() uint16
+	marshal(b []byte) []byte
+	unmarshal(f field) error
+}
+
+// Marshal encodes msg's protobuf payload.
+func Marshal(msg Message) []byte {
+	return msg.marshal(nil)
+}
+
+// Unmarshal decodes a payload of type typ. Types this package doesn't know are
+// returned as Unknown.
+func Unmarshal(typ uint16, payload []byte) (Message, error) {
+	msg := newMessage(typ)
+	for len(payload) > 0 {
+		num, wtyp, n := protowire.ConsumeTag(payload)
+		if n < 0 {
+			return nil, fmt.Errorf("%w: message %d: %v", ErrBadFrame, typ, protowire.ParseError(n))
+		}
+		payload = payload[n:]
+		f := field{num: num, typ: wtyp}
+		switch wtyp {
+		case protowire.VarintType:
+			f.varint, n = protowire.ConsumeVarint(payload)
+		case protowire.Fixed32Type:
+			f.fixed32, n = protowire.ConsumeFixed32(payload)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(payload)
+		default:
+			n = protowire.ConsumeFieldValue(num, wtyp, payload)
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("%w: message %d: %v", ErrBadFrame, typ, protowire.ParseError(n))
+		}
+		payload = payload[n:]
+		if err := msg.unmarshal(f); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+func newMessage(typ uint16) Message {
+	switch typ {
+	case TypeHelloRequest:
+		return &HelloRequest{}
+	case TypeHelloResponse:
+		return &HelloResponse{}
+	case TypeConnectRequest:
+		return &ConnectRequest{}
+	case TypeConnectResponse:
+		return &ConnectResponse{}
+	case TypeDeviceInfoResponse:
+		return &DeviceInfoResponse{}
+	case TypeListEntitiesBinarySensorResponse, TypeListEntitiesSensorResponse, TypeListEntitiesSwitchResponse, TypeListEntitiesLightResponse:
+		return &ListEntitiesResponse{MessageType: typ}
+	case TypeBinarySensorStateResponse, TypeSensorStateResponse, TypeSwitchStateResponse:
+		return &StateResponse{MessageType: typ}
+	case TypeLightStateResponse:
+		return &LightStateResponse{}
+	case TypeLightCommandRequest:
+		return &LightCommandRequest{}
+	case TypeSwitchCommandRequest:
+		return &SwitchCommandRequest{}
+	case TypeDisconnectRequest, TypeDisconnectResponse, TypePingRequest, TypePingResponse, TypeDeviceInfoRequest,
+		TypeListEntitiesRequest, TypeListEntitiesDoneResponse, TypeSubscribeStatesRequest:
+		return &Empty{MessageType: typ}
+	}
+	return &Unknown{MessageType: typ}
+}
+
+// field is one decoded field; which value is set depends on typ.
+type field struct {
+	num     protowire.Number
+	typ     protowire.Type
+	varint  uint64
+	fixed32 uint32
+	bytes   []byte
+}
+
+func (f field) string() string { return string(f.bytes) }
+func (f field) bool() bool     { return f.varint != 0 }
+func (f field) float() float32 { return math.Float32frombits(f.fixed32) }
+
+// Proto3 leaves out fields with zero values.
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendVarint(b, num, 1)
+}
+
+func appendFixed32(b []byte, num protowire.Number, v uint32) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed32Type)
+	return protowire.AppendFixed32(b, v)
+}
+
+func appendFloat(b []byte, num protowire.Number, v float32) []byte {
+	return appendFixed32(b, num, math.Float32bits(v))
+}
+
+// Empty is any message without fields: pings, disconnects and the requests that
+// only name what they want.
+type Empty struct {
+	MessageType uint16
+}
+
+func (m *Empty) Type() uint16            { return m.MessageType }
+func (m *Empty) marshal(b []byte) []byte { return b }
+func (m *Empty) unmarshal(f field) error { return nil }
+
+// Unknown is a message type this package doesn't decode. Its fields are dropped.
+type Unknown struct {
+	MessageType uint16
+}
+
+func (m *Unknown) Type() uint16            { return m.MessageType }
+func (m *Unknown) marshal(b []byte) []byte { return b }
+func (m *Unknown) unmarshal(f field) error { return nil }
+
+type HelloRequest struct {
+	ClientInfo      string
+	APIVersionMajor uint32
+	APIVersionMinor uint32
+}
+
+func (m *HelloRequest) Type() uint16 { return TypeHelloRequest }
+
+func (m *HelloRequest) marshal(b []byte) []byte {
+	b = appendString(b, 1, m.ClientInfo)
+	b = appendVarint(b, 2, uint64(m.APIVersionMajor))
+	return appendVarint(b, 3, uint64(m.APIVersionMinor))
+}
+
+func (m *HelloRequest) unmarshal(f field) error {
+	switch f.num {
+	case 1:
+		m.ClientInfo = f.string()
+	case 2:
+		m.APIVersionMajor = uint32(f.varint)
+	case 3:
+		m.APIVersionMinor = uint32(f.varint)
+	}
+	return nil
+}
+
+type HelloResponse struct {
+	APIVersionMajor uint32
+	APIVersionMinor uint32
+	ServerInfo      string
+	Name            string
+}
+
+func (m *HelloResponse) Type() uint16 { return TypeHelloResponse }
+
+func (m *HelloResponse) marshal(b []byte) []byte {
+	b = appendVarint(b, 1, uint64(m.APIVersionMajor))
+	b = appendVarint(b, 2, uint64(m.APIVersionMinor))
+	b = appendString(b, 3, m.ServerInfo)
+	return appendString(b, 4, m.Name)
+}
+
+func (m *HelloResponse) unmarshal(f field) error {
+	switch f.num {
+	case 1:
+		m.APIVersionMajor = uint32(f.varint)
+	case 2:
+		m.APIVersionMinor = uint32(f.varint)
+	case 3:
+		m.ServerInfo = f.string()
+	case 4:
+		m.Name = f.string()
+	}
+	return nil
+}
+
+// ConnectRequest authenticates with the legacy API password. Nodes using
+// encryption accept any password.
+type ConnectRequest struct {
+	Password string
+}
+
+func (m *ConnectRequest) Type() uint16 { return TypeConnectRequest }
+
+func (m *ConnectRequest) marshal(b []byte) []byte {
+	return appendString(b, 1, m.Password)
+}
+
+func (m *ConnectRequest) unmarshal(f field) error {
+	if f.num == 1 {
+		m.Password = f.string()
+	}
+	return nil
+}
+
+type ConnectResponse struct {
+	InvalidPassword bool
+}
+
+func (m *ConnectResponse) Type() uint16 { return TypeConnectResponse }
+
+func (m *ConnectResponse) marshal(b []byte) []byte {
+	return appendBool(b, 1, m.InvalidPassword)
+}
+
+func (m *ConnectResponse) unmarshal(f field) error {
+	if f.num == 1 {
+		m.InvalidPassword = f.bool()
+	}
+	return nil
+}
+
+type DeviceInfoResponse struct {
+	UsesPassword   bool
+	Name           string
+	MACAddress     string
+	ESPHomeVersion string
+	Model          string
+	Manufacturer   string
+	FriendlyName   string
+}
+
+func (m *DeviceInfoResponse) Type() uint16 { return TypeDeviceInfoResponse }
+
+func (m *DeviceInfoResponse) marshal(b []byte) []byte {
+	b = appendBool(b, 1, m.UsesPassword)
+	b = appendString(b, 2, m.Name)
+	b = appendString(b, 3, m.MACAddress)
+	b = appendString(b, 4, m.ESPHomeVersion)
+	b = appendString(b, 6, m.Model)
+	b = appendString(b, 12, m.Manufacturer)
+	return appendString(b, 13, m.FriendlyName)
+}
+
+func (m *DeviceInfoResponse) unmarshal(f field) error {
+	switch f.num {
+	case 1:
+		m.UsesPassword = f.bool()
+	case 2:
+		m.Name = f.string()
+	case 3:
+		m.MACAddress = f.string()
+	case 4:
+		m.ESPHomeVersion = f.string()
+	case 6:
+		m.Model = f.string()
+	case 12:
+		m.Manufacturer = f.string()
+	case 13:
+		m.FriendlyName = f.string()
+	}
+	return nil
+}
+
+// Light color mode bits
+const (
+	ColorModeOnOff            = 1 << 0
+	ColorModeBrightness       = 1 << 1
+	ColorModeWhite            = 1 << 2
+	ColorModeColorTemperature = 1 << 3
+	ColorModeColdWarmWhite    = 1 << 4
+	ColorModeRGB              = 1 << 5
+)
+
+// ListEntitiesResponse describes one binary sensor, sensor, switch or light. The
+// four messages share their first fields; the rest are only set for their type.
+type ListEntitiesResponse struct {
+	MessageType uint16
+	ObjectID    string
+	Key         uint32
+	Name        string
+	UniqueID    string
+	DeviceClass string
+
+	// Sensors
+	UnitOfMeasurement string
+	AccuracyDecimals  int32
+
+	// Lights
+	SupportedColorModes []uint32
+	MinMireds           float32
+	MaxMireds           float32
+	Effects             []string
+}
+
+func (m *ListEntitiesResponse) Type() uint16 { return m.MessageType }
+
+// Field numbers that differ between the four messages
+func (m *ListEntitiesResponse) deviceClassField() protowire.Number {
+	switch m.MessageType {
+	case TypeListEntitiesBinarySensorResponse:
+		return 5
+	case TypeListEntitiesSensorResponse:
+		return 9
+	case TypeListEntitiesSwitchResponse:
+		return 9
+	}
+	return 0
+}
+
+func (m *ListEntitiesResponse) marshal(b []byte) []byte {
+	b = appendString(b, 1, m.ObjectID)
+	b = appendFixed32(b, 2, m.Key)
+	b = appendString(b, 3, m.Name)
+	b = appendString(b, 4, m.UniqueID)
+	if num := m.deviceClassField(); num != 0 {
+		b = appendString(b, num, m.DeviceClass)
+	}
+	switch m.MessageType {
+	case TypeListEntitiesSensorResponse:
+		b = appendString(b, 6, m.UnitOfMeasurement)
+		b = appendVarint(b, 7, uint64(m.AccuracyDecimals))
+	case TypeListEntitiesLightResponse:
+		b = appendFloat(b, 9, m.MinMireds)
+		b = appendFloat(b, 10, m.MaxMireds)
+		for _, e := range m.Effects {
+			b = appendString(b, 11, e)
+		}
+		if len(m.SupportedColorModes) > 0 {
+			var packed []byte
+			for _, mode := range m.SupportedColorModes {
+				packed = protowire.AppendVarint(packed, uint64(mode))
+			}
+			b = protowire.AppendTag(b, 12, protowire.BytesType)
+			b = protowire.AppendBytes(b, packed)
+		}
+	}
+	return b
+}
+
+func (m *ListEntitiesResponse) unmarshal(f field) error {
+	switch {
+	case f.num == 1:
+		m.ObjectID = f.string()
+	case f.num == 2:
+		m.Key = f.fixed32
+	case f.num == 3:
+		m.Name = f.string()
+	case f.num == 4:
+		m.UniqueID = f.string()
+	case f.num == m.deviceClassField():
+		m.DeviceClass = f.string()
+	case m.MessageType == TypeListEntitiesSensorResponse && f.num == 6:
+		m.UnitOfMeasurement = f.string()
+	case m.MessageType == TypeListEntitiesSensorResponse && f.num == 7:
+		m.AccuracyDecimals = int32(f.varint)
+	case m.MessageType == TypeListEntitiesLightResponse && f.num == 9:
+		m.MinMireds = f.float()
+	case m.MessageType == TypeListEntitiesLightResponse && f.num == 10:
+		m.MaxMireds = f.float()
+	case m.MessageType == TypeListEntitiesLightResponse && f.num == 11:
+		m.Effects = append(m.Effects, f.string())
+	case m.MessageType == TypeListEntitiesLightResponse && f.num == 12:
+		// Packed, or one varint per element from older encoders
+		if f.typ == protowire.VarintType {
+			m.SupportedColorModes = append(m.SupportedColorModes, uint32(f.varint))
+			break
+		}
+		for b := f.bytes; len(b) > 0; {
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return fmt.Errorf("%w: supported_color_modes: %v", ErrBadFrame, protowire.ParseError(n))
+			}
+			m.SupportedColorModes = append(m.SupportedColorModes, uint32(v))
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+// StateResponse is the state of a binary sensor, sensor or switch. Sensors report a
+// float in Value; the others a bool in State.
+type StateResponse struct {
+	MessageType  uint16
+	Key          uint32
+	State        bool
+	Value        float32
+	MissingState bool
+}
+
+func (m *StateResponse) Type() uint16 { return m.MessageType }
+
+func (m *StateResponse) marshal(b []byte) []byte {
+	b = appendFixed32(b, 1, m.Key)
+	if m.MessageType == TypeSensorStateResponse {
+		b = appendFloat(b, 2, m.Value)
+	} else {
+		b = appendBool(b, 2, m.State)
+	}
+	if m.MessageType != TypeSwitchStateResponse {
+		b = appendBool(b, 3, m.MissingState)
+	}
+	return b
+}
+
+func (m *StateResponse) unmarshal(f field) error {
+	switch f.num {
+	case 1:
+		m.Key = f.fixed32
+	case 2:
+		if m.MessageType == TypeSensorStateResponse {
+			m.Value = f.float()
+		} else {
+			m.State = f.bool()
+		}
+	case 3:
+		m.MissingState = f.bool()
+	}
+	return nil
+}
+
+// LightStateResponse has channel values from 0 to 1 and the color temperature in
+// mireds.
+type LightStateResponse struct {
+	Key              uint32
+	State            bool
+	Brightness       float32
+	ColorMode        uint32
+	ColorBrightness  float32
+	Red, Green, Blue float32
+	ColorTemperature float32
+	Effect           string
+}
+
+func (m *LightStateResponse) Type() uint16 { return TypeLightStateResponse }
+
+func (m *LightStateResponse) marshal(b []byte) []byte {
+	b = appendFixed32(b, 1, m.Key)
+	b = appendBool(b, 2, m.State)
+	b = appendFloat(b, 3, m.Brightness)
+	b = appendFloat(b, 4, m.Red)
+	b = appendFloat(b, 5, m.Green)
+	b = appendFloat(b, 6, m.Blue)
+	b = appendFloat(b, 8, m.ColorTemperature)
+	b = appendString(b, 9, m.Effect)
+	b = appendFloat(b, 10, m.ColorBrightness)
+	return appendVarint(b, 11, uint64(m.ColorMode))
+}
+
+func (m *LightStateResponse) unmarshal(f field) error {
+	switch f.num {
+	case 1:
+		m.Key = f.fixed32
+	case 2:
+		m.State = f.bool()
+	case 3:
+		m.Brightness = f.float()
+	case 4:
+		m.Red = f.float()
+	case 5:
+		m.Green = f.float()
+	case 6:
+		m.Blue = f.float()
+	case 8:
+		m.ColorTemperature = f.float()
+	case 9:
+		m.Effect = f.string()
+	case 10:
+		m.ColorBrightness = f.float()
+	case 11:
+		m.ColorMode = uint32(f.varint)
+	}
+	return nil
+}
+
+// LightCommandRequest changes the fields whose Has flag is set.
+type LightCommandRequest struct {
+	Key                 uint32
+	HasState            bool
+	State               bool
+	HasBrightness       bool
+	Brightness          float32
+	HasRGB              bool
+	Red, Green, Blue    float32
+	HasColorTemperature bool
+	ColorTemperature    float32
+	HasColorMode        bool
+	ColorMode           uint32
+}
+
+func (m *LightCommandRequest) Type() uint16 { return TypeLightCommandRequest }
+
+func (m *LightCommandRequest) marshal(b []byte) []byte {
+	b = appendFixed32(b, 1, m.Key)
+	b = appendBool(b, 2, m.HasState)
+	b = appendBool(b, 3, m.State)
+	b = appendBool(b, 4, m.HasBrightness)
+	b = appendFloat(b, 5, m.Brightness)
+	b = appendBool(b, 6, m.HasRGB)
+	b = appendFloat(b, 7, m.Red)
+	b = appendFloat(b, 8, m.Green)
+	b = appendFloat(b, 9, m.Blue)
+	b = appendBool(b, 12, m.HasColorTemperature)
+	b = appendFloat(b, 13, m.ColorTemperature)
+	b = appendBool(b, 22, m.HasColorMode)
+	return appendVarint(b, 23, uint64(m.ColorMode))
+}
+
+func (m *LightCommandRequest) unmarshal(f field) error {
+	switch f.num {
+	case 1:
+		m.Key = f.fixed32
+	case 2:
+		m.HasState = f.bool()
+	case 3:
+		m.State = f.bool()
+	case 4:
+		m.HasBrightness = f.bool()
+	case 5:
+		m.Brightness = f.float()
+	case 6:
+		m.HasRGB = f.bool()
+	case 7:
+		m.Red = f.float()
+	case 8:
+		m.Green = f.float()
+	case 9:
+		m.Blue = f.float()
+	case 12:
+		m.HasColorTemperature = f.bool()
+	case 13:
+		m.ColorTemperature = f.float()
+	case 22:
+		m.HasColorMode = f.bool()
+	case 23:
+		m.ColorMode = uint32(f.varint)
+	}
+	return nil
+}
+
+type SwitchCommandRequest struct {
+	Key   uint32
+	State bool
+}
+
+func (m *SwitchCommandRequest) Type() uint16 { return TypeSwitchCommandRequest }
+
+func (m *SwitchCommandRequest) marshal(b []byte) []byte {
+	b = appendFixed32(b, 1, m.Key)
+	return appendBool(b, 2, m.State)
+}
+
+func (m *SwitchCommandRequest) unmarshal(f field) error {
+	switch f.num {
+	case 1:
+		m.Key = f.fixed32
+	case 2:
+		m.State = f.bool()
+	}
+	return nil
+}
@@ -0,0 +1,309 @@
+package esphome
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/providers/esphome/api"
+)
+
+// Protocol version the client speaks; nodes accept any 1.x.
+const (
+	apiVersionMajor = 1
+	apiVersionMinor = 10
+	clientInfo      = "SmartHomeHub"
+)
+
+const (
+	connectTimeout = 10 * time.Second
+
+	// Nodes close connections that have been idle for a few minutes, and a node
+	// that lost power doesn't close anything, so ping regularly and give up when
+	// nothing comes back.
+	keepaliveInterval = 20 * time.Second
+	readTimeout       = keepaliveInterval * 3 / 2
+)
+
+var (
+	ErrDisconnected    = errors.New("esphome api connection closed")
+	ErrInvalidPassword = errors.New("invalid esphome api password")
+	ErrUnsupported     = errors.New("unsupported esphome api version")
+)
+
+// NodeInfo is what a node reports when connecting.
+type NodeInfo struct {
+	Name           string
+	FriendlyName   string
+	MACAddress     string
+	Model          string
+	Manufacturer   string
+	ESPHomeVersion string
+	Entities       []*api.ListEntitiesResponse
+}
+
+// Client is a connection to one node's native API. Connect lists the node's entities;
+// Subscribe then starts delivering their states to onState.
+type Client struct {
+	address  string
+	psk      []byte
+	password string
+	onState  func(api.Message)
+
+	mu     sync.Mutex
+	conn   *api.Conn
+	closed chan struct{}
+}
+
+// NewClient creates a client for host[:port]. psk is the decoded encryption key, or
+// nil for nodes without encryption.
+func NewClient(address string, psk []byte, password string, onState func(api.Message)) *Client {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, strconv.Itoa(api.DefaultPort))
+	}
+	return &Client{address: address, psk: psk, password: password, onState: onState}
+}
+
+// Connect opens a new connection, replacing any existing one, and reads the node's
+// info and entities.
+func (c *Client) Connect(ctx context.Context) (NodeInfo, error) {
+	c.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	netConn, err := dialer.DialContext(ctx, "tcp", c.address)
+	if err != nil {
+		return NodeInfo{}, err
+	}
+	deadline, _ := ctx.Deadline()
+	netConn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { netConn.SetDeadline(time.Now()) })
+	defer stop()
+
+	info, conn, err := c.handshake(netConn)
+	if err != nil {
+		netConn.Close()
+		if ctx.Err() != nil {
+			return NodeInfo{}, ctx.Err()
+		}
+		return NodeInfo{}, err
+	}
+	if !stop() {
+		netConn.Close()
+		return NodeInfo{}, ctx.Err()
+	}
+	netConn.SetDeadline(time.Time{})
+
+	c.mu.Lock()
+	c.conn, c.closed = conn, nil
+	c.mu.Unlock()
+	return info, nil
+}
+
+func (c *Client) handshake(netConn net.Conn) (NodeInfo, *api.Conn, error) {
+	conn := api.NewPlaintextConn(netConn)
+	if c.psk != nil {
+		var err error
+		if conn, _, err = api.ClientHandshake(netConn, c.psk); err != nil {
+			return NodeInfo{}, nil, err
+		}
+	}
+
+	hello, err := request[*api.HelloResponse](conn, &api.HelloRequest{
+		ClientInfo:      clientInfo,
+		APIVersionMajor: apiVersionMajor,
+		APIVersionMinor: apiVersionMinor,
+	})
+	if err != nil {
+		return NodeInfo{}, nil, err
+	}
+	if hello.APIVersionMajor != apiVersionMajor {
+		return NodeInfo{}, nil, fmt.Errorf("%w: %d.%d", ErrUnsupported, hello.APIVersionMajor, hello.APIVersionMinor)
+	}
+
+	connect, err := request[*api.ConnectResponse](conn, &api.ConnectRequest{Password: c.password})
+	if err != nil {
+		return NodeInfo{}, nil, err
+	}
+	if connect.InvalidPassword {
+		return NodeInfo{}, nil, ErrInvalidPassword
+	}
+
+	device, err := request[*api.DeviceInfoResponse](conn, &api.Empty{MessageType: api.TypeDeviceInfoRequest})
+	if err != nil {
+		return NodeInfo{}, nil, err
+	}
+	info := NodeInfo{
+		Name:           device.Name,
+		FriendlyName:   device.FriendlyName,
+		MACAddress:     device.MACAddress,
+		Model:          device.Model,
+		Manufacturer:   device.Manufacturer,
+		ESPHomeVersion: device.ESPHomeVersion,
+	}
+
+	if err := conn.Send(&api.Empty{MessageType: api.TypeListEntitiesRequest}); err != nil {
+		return NodeInfo{}, nil, err
+	}
+	for {
+		msg, err := receive(conn)
+		if err != nil {
+			return NodeInfo{}, nil, err
+		}
+		switch msg := msg.(type) {
+		case *api.ListEntitiesResponse:
+			info.Entities = append(info.Entities, msg)
+		case *api.Empty:
+			if msg.MessageType == api.TypeListEntitiesDoneResponse {
+				return info, conn, nil
+			}
+		}
+		// Entity types the hub doesn't support are skipped
+	}
+}
+
+// request sends req and waits for the reply of type T.
+func request[T api.Message](conn *api.Conn, req api.Message) (T, error) {
+	var zero T
+	if err := conn.Send(req); err != nil {
+		return zero, err
+	}
+	for {
+		msg, err := receive(conn)
+		if err != nil {
+			return zero, err
+		}
+		if reply, ok := msg.(T); ok {
+			return reply, nil
+		}
+	}
+}
+
+// receive reads the next message, answering pings and failing on a disconnect
+// request on the way.
+func receive(conn *api.Conn) (api.Message, error) {
+	for {
+		msg, err := conn.Receive()
+		if err != nil {
+			return nil, err
+		}
+		switch msg.Type() {
+		case api.TypePingRequest:
+			if err := conn.Send(&api.Empty{MessageType: api.TypePingResponse}); err != nil {
+				return nil, err
+			}
+			continue
+		case api.TypeDisconnectRequest:
+			conn.Send(&api.Empty{MessageType: api.TypeDisconnectResponse})
+			return nil, ErrDisconnected
+		}
+		return msg, nil
+	}
+}
+
+// Subscribe starts reading state updates from the connection made by Connect; the
+// node first sends the state of every entity. The returned channel is closed when the
+// connection is.
+func (c *Client) Subscribe() (<-chan struct{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil || c.closed != nil {
+		return nil, ErrDisconnected
+	}
+
+	conn := c.conn
+	closed := make(chan struct{})
+	c.closed = closed
+	conn.NetConn().SetReadDeadline(time.Now().Add(readTimeout))
+	if err := conn.Send(&api.Empty{MessageType: api.TypeSubscribeStatesRequest}); err != nil {
+		conn.Close()
+		c.conn, c.closed = nil, nil
+		return nil, err
+	}
+
+	go c.read(conn, closed)
+	go c.keepalive(conn, closed)
+	return closed, nil
+}
+
+func (c *Client) read(conn *api.Conn, closed chan struct{}) {
+	defer func() {
+		conn.Close()
+		c.mu.Lock()
+		if c.conn == conn {
+			c.conn, c.closed = nil, nil
+		}
+		c.mu.Unlock()
+		close(closed)
+	}()
+
+	for {
+		msg, err := receive(conn)
+		if err != nil {
+			return
+		}
+		conn.NetConn().SetReadDeadline(time.Now().Add(readTimeout))
+		switch msg.(type) {
+		case *api.StateResponse, *api.LightStateResponse:
+			c.onState(msg)
+		}
+	}
+}
+
+func (c *Client) keepalive(conn *api.Conn, closed chan struct{}) {
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+			if err := conn.Send(&api.Empty{MessageType: api.TypePingRequest}); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// Send sends a command over the subscribed connection. The node doesn't reply;
+// the new state arrives as an update.
+func (c *Client) Send(ctx context.Context, msg api.Message) error {
+	c.mu.Lock()
+	conn := c.conn
+	subscribed := c.closed != nil
+	c.mu.Unlock()
+	if conn == nil || !subscribed {
+		return ErrDisconnected
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.NetConn().SetWriteDeadline(deadline)
+		defer conn.NetConn().SetWriteDeadline(time.Time{})
+	}
+	return conn.Send(msg)
+}
+
+// Close drops the connection.
+func (c *Client) Close() {
+	c.mu.Lock()
+	conn := c.conn
+	if c.closed == nil {
+		// Not subscribed, so there is no reader to clear it
+		c.conn = nil
+	}
+	c.mu.Unlock()
+	if conn != nil {
+		conn.Send(&api.Empty{MessageType: api.TypeDisconnectRequest})
+		conn.Close()
+	}
+}
@@ -0,0 +1,397 @@
+package esphome
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/esphome/api"
+)
+
+// Entity domains, as they appear in device IDs
+const (
+	domainBinarySensor = "binary_sensor"
+	domainLight        = "light"
+	domainSensor       = "sensor"
+	domainSwitch       = "switch"
+)
+
+func entityDomain(e *api.ListEntitiesResponse) string {
+	switch e.MessageType {
+	case api.TypeListEntitiesBinarySensorResponse:
+		return domainBinarySensor
+	case api.TypeListEntitiesLightResponse:
+		return domainLight
+	case api.TypeListEntitiesSensorResponse:
+		return domainSensor
+	case api.TypeListEntitiesSwitchResponse:
+		return domainSwitch
+	}
+	return ""
+}
+
+// entityDeviceID is esphome-<node>-<domain>-<object id>; node names are unique on a
+// network, and object IDs within a domain on a node.
+func entityDeviceID(node string, e *api.ListEntitiesResponse) device.ID {
+	return device.ID(fmt.Sprintf("esphome-%s-%s-%s", node, entityDomain(e), e.ObjectID))
+}
+
+// Device is one entity of a node: a light, switch, sensor or binary sensor. Its state
+// comes from the node's state updates; commands go over the node's connection.
+type Device struct {
+	id     device.ID
+	node   *node
+	domain string
+
+	mu        sync.RWMutex
+	entity    *api.ListEntitiesResponse
+	nodeName  string
+	light     *api.LightStateResponse
+	state     *api.StateResponse
+	updatedAt time.Time
+}
+
+func newDevice(id device.ID, n *node, entity *api.ListEntitiesResponse, nodeName string) *Device {
+	return &Device{id: id, node: n, domain: entityDomain(entity), entity: entity, nodeName: nodeName}
+}
+
+func (d *Device) ID() device.ID {
+	return d.id
+}
+
+// setEntity replaces the entity after a reconnect, keeping the last state until the
+// node sends the current one.
+func (d *Device) setEntity(entity *api.ListEntitiesResponse, nodeName string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entity = entity
+	d.nodeName = nodeName
+}
+
+// applyState applies a state update from the node.
+func (d *Device) applyState(msg api.Message) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch msg := msg.(type) {
+	case *api.LightStateResponse:
+		d.light = msg
+	case *api.StateResponse:
+		d.state = msg
+	}
+	d.updatedAt = time.Now()
+}
+
+func (d *Device) Execute(ctx context.Context, cmd device.Command) error {
+	d.mu.RLock()
+	msg, err := d.command(cmd)
+	d.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if !d.node.isConnected() {
+		return fmt.Errorf("%w: %w", device.ErrDeviceUnavailable, ErrDisconnected)
+	}
+
+	err = d.node.guard.Do(ctx, func(ctx context.Context) error {
+		return d.node.client.Send(ctx, msg)
+	})
+	if err != nil {
+		return err
+	}
+	// The node confirms with a state update; until then assume the command worked
+	d.applyCommand(msg)
+	return nil
+}
+
+// command maps a hub command to the message for the entity. Must be called with mu
+// held.
+func (d *Device) command(cmd device.Command) (api.Message, error) {
+	key := d.entity.Key
+	switch d.domain {
+	case domainSwitch:
+		switch cmd.Action {
+		case "turn_on", "turn_off":
+			return &api.SwitchCommandRequest{Key: key, State: cmd.Action == "turn_on"}, nil
+		case "toggle":
+			return &api.SwitchCommandRequest{Key: key, State: d.state == nil || !d.state.State}, nil
+		}
+
+	case domainLight:
+		modes := d.colorModes()
+		switch {
+		case cmd.Action == "turn_on" || cmd.Action == "turn_off":
+			return &api.LightCommandRequest{Key: key, HasState: true, State: cmd.Action == "turn_on"}, nil
+
+		case cmd.Action == "toggle":
+			return &api.LightCommandRequest{Key: key, HasState: true, State: d.light == nil || !d.light.State}, nil
+
+		case cmd.Action == "set_brightness" && modes&api.ColorModeBrightness != 0:
+			v, err := device.NumberParam(cmd.Params, "value", 0, 100)
+			if err != nil {
+				return nil, err
+			}
+			if v == 0 {
+				return &api.LightCommandRequest{Key: key, HasState: true, State: false}, nil
+			}
+			return &api.LightCommandRequest{Key: key, HasState: true, State: true, HasBrightness: true, Brightness: float32(v / 100)}, nil
+
+		case cmd.Action == "set_color" && modes&api.ColorModeRGB != 0:
+			h, err := device.NumberParam(cmd.Params, "hue", 0, 360)
+			if err != nil {
+				return nil, err
+			}
+			s, err := device.NumberParam(cmd.Params, "saturation", 0, 100)
+			if err != nil {
+				return nil, err
+			}
+			r, g, b := hsToRGB(h, s)
+			return &api.LightCommandRequest{
+				Key: key, HasState: true, State: true,
+				HasRGB: true, Red: float32(r) / 255, Green: float32(g) / 255, Blue: float32(b) / 255,
+			}, nil
+
+		case cmd.Action == "set_color_temperature" && modes&(api.ColorModeColorTemperature|api.ColorModeColdWarmWhite) != 0:
+			lo, hi := d.kelvinRange()
+			v, err := device.NumberParam(cmd.Params, "value", lo, hi)
+			if err != nil {
+				return nil, err
+			}
+			return &api.LightCommandRequest{
+				Key: key, HasState: true, State: true,
+				HasColorTemperature: true, ColorTemperature: float32(1e6 / v),
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", device.ErrUnknownCommand, cmd.Action)
+}
+
+// applyCommand updates the cached state as if the node had carried out msg.
+func (d *Device) applyCommand(msg api.Message) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch msg := msg.(type) {
+	case *api.SwitchCommandRequest:
+		d.state = &api.StateResponse{MessageType: api.TypeSwitchStateResponse, Key: msg.Key, State: msg.State}
+	case *api.LightCommandRequest:
+		light := api.LightStateResponse{Key: msg.Key}
+		if d.light != nil {
+			light = *d.light
+		}
+		if msg.HasState {
+			light.State = msg.State
+		}
+		if msg.HasBrightness {
+			light.Brightness = msg.Brightness
+		}
+		if msg.HasRGB {
+			light.Red, light.Green, light.Blue = msg.Red, msg.Green, msg.Blue
+			light.ColorMode &^= api.ColorModeColorTemperature | api.ColorModeColdWarmWhite
+			light.ColorMode |= api.ColorModeRGB
+		}
+		if msg.HasColorTemperature {
+			light.ColorTemperature = msg.ColorTemperature
+			light.ColorMode &^= api.ColorModeRGB
+			light.ColorMode |= api.ColorModeColorTemperature
+		}
+		d.light = &light
+	}
+	d.updatedAt = time.Now()
+}
+
+// colorModes is every capability bit of the light's supported color modes. Must be
+// called with mu held.
+func (d *Device) colorModes() uint32 {
+	var modes uint32
+	for _, mode := range d.entity.SupportedColorModes {
+		modes |= mode
+	}
+	return modes
+}
+
+// kelvinRange converts the light's mireds range to kelvin. Must be called with mu
+// held.
+func (d *Device) kelvinRange() (float64, float64) {
+	lo, hi := 2000.0, 6500.0
+	if d.entity.MaxMireds > 0 {
+		lo = math.Ceil(1e6 / float64(d.entity.MaxMireds))
+	}
+	if d.entity.MinMireds > 0 {
+		hi = math.Floor(1e6 / float64(d.entity.MinMireds))
+	}
+	return lo, hi
+}
+
+func (d *Device) actions() []string {
+	switch d.domain {
+	case domainSwitch:
+		return []string{"turn_on", "turn_off", "toggle"}
+	case domainLight:
+		actions := []string{"turn_on", "turn_off", "toggle"}
+		modes := d.colorModes()
+		if modes&api.ColorModeBrightness != 0 {
+			actions = append(actions, "set_brightness")
+		}
+		if modes&api.ColorModeRGB != 0 {
+			actions = append(actions, "set_color")
+		}
+		if modes&(api.ColorModeColorTemperature|api.ColorModeColdWarmWhite) != 0 {
+			actions = append(actions, "set_color_temperature")
+		}
+		return actions
+	}
+	return []string{}
+}
+
+func (d *Device) State(ctx context.Context) (device.State, error) {
+	connected := d.node.isConnected()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	attributes := map[string]interface{}{
+		"name":    d.entity.Name,
+		"node":    d.nodeName,
+		"actions": d.actions(),
+	}
+	if d.entity.Name == "" {
+		// Entities named after their node
+		attributes["name"] = d.nodeName
+	}
+
+	deviceType := "sensor"
+	switch d.domain {
+	case domainSwitch:
+		deviceType = "switch"
+		if d.state != nil {
+			attributes["power"] = device.OnOff(d.state.State)
+		}
+
+	case domainLight:
+		deviceType = "light"
+		if d.light != nil {
+			d.lightAttributes(attributes)
+		}
+
+	case domainSensor:
+		name := d.entity.DeviceClass
+		if name == "" {
+			name = "value"
+		}
+		if d.state != nil && !d.state.MissingState {
+			attributes[name] = round(float64(d.state.Value), int(d.entity.AccuracyDecimals))
+		}
+		if d.entity.UnitOfMeasurement != "" {
+			attributes["unit"] = d.entity.UnitOfMeasurement
+		}
+
+	case domainBinarySensor:
+		name := d.entity.DeviceClass
+		if name == "" {
+			name = "state"
+		}
+		if d.state != nil && !d.state.MissingState {
+			attributes[name] = d.state.State
+		}
+	}
+
+	state := device.State{
+		DeviceType: deviceType,
+		UpdatedAt:  d.updatedAt,
+		Attributes: attributes,
+	}
+	if !connected {
+		return state, fmt.Errorf("%w: %w", device.ErrDeviceUnavailable, ErrDisconnected)
+	}
+	return state, nil
+}
+
+// lightAttributes adds power, brightness and whichever of color and color
+// temperature the light is in. Must be called with mu held.
+func (d *Device) lightAttributes(attributes map[string]interface{}) {
+	l := d.light
+	modes := d.colorModes()
+	attributes["power"] = device.OnOff(l.State)
+	if modes&api.ColorModeBrightness != 0 {
+		attributes["brightness"] = int(math.Round(float64(l.Brightness) * 100))
+	}
+
+	// Lights reporting no mode (older firmware) get both
+	rgb := modes&api.ColorModeRGB != 0 && (l.ColorMode == 0 || l.ColorMode&api.ColorModeRGB != 0)
+	white := modes&(api.ColorModeColorTemperature|api.ColorModeColdWarmWhite) != 0 &&
+		(l.ColorMode == 0 || l.ColorMode&(api.ColorModeColorTemperature|api.ColorModeColdWarmWhite) != 0)
+	if rgb {
+		channel := func(v float32) int { return int(math.Round(float64(v) * 255)) }
+		attributes["hue"], attributes["saturation"] = rgbToHS(channel(l.Red), channel(l.Green), channel(l.Blue))
+	}
+	if white && l.ColorTemperature > 0 {
+		attributes["color_temperature"] = int(math.Round(1e6 / float64(l.ColorTemperature)))
+	}
+	if l.Effect != "" && l.Effect != "None" {
+		attributes["effect"] = l.Effect
+	}
+}
+
+// round rounds v to the decimals the node's YAML asks for; the float32 readings
+// otherwise come out as 21.299999237060547.
+func round(v float64, decimals int) float64 {
+	scale := math.Pow(10, float64(max(decimals, 0)))
+	return math.Round(v*scale) / scale
+}
+
+// hsToRGB converts a hue in degrees and saturation in percent to a fully bright
+// color.
+func hsToRGB(hue, sat float64) (int, int, int) {
+	s := sat / 100
+	c := s
+	x := c * (1 - math.Abs(math.Mod(math.Mod(hue, 360)/60, 2)-1))
+	m := 1 - c
+
+	var r, g, b float64
+	switch h := math.Mod(hue, 360); {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	scale := func(v float64) int { return int(math.Round((v + m) * 255)) }
+	return scale(r), scale(g), scale(b)
+}
+
+// rgbToHS returns the hue in degrees and saturation in percent of a color.
+func rgbToHS(r, g, b int) (int, int) {
+	max := math.Max(float64(r), math.Max(float64(g), float64(b)))
+	min := math.Min(float64(r), math.Min(float64(g), float64(b)))
+	if max == 0 {
+		return 0, 0
+	}
+	delta := max - min
+
+	var hue float64
+	switch {
+	case delta == 0:
+		hue = 0
+	case max == float64(r):
+		hue = math.Mod((float64(g)-float64(b))/delta, 6)
+	case max == float64(g):
+		hue = (float64(b)-float64(r))/delta + 2
+	default:
+		hue = (float64(r)-float64(g))/delta + 4
+	}
+	hue *= 60
+	if hue < 0 {
+		hue += 360
+	}
+	return int(math.Round(hue)) % 360, int(math.Round(delta / max * 100))
+}
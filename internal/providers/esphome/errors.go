@@ -0,0 +1,25 @@
+package esphome
+
+import (
+	"errors"
+	"io"
+
+	"github.com/legitlolly/SmartHomeHub/internal/providers/esphome/api"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+// ClassifyError retries network failures and dropped connections. A wrong password
+// or encryption key won't fix itself, so those are permanent.
+func ClassifyError(err error) resilience.Class {
+	if class, ok := resilience.ClassifyNetError(err); ok {
+		return class
+	}
+	if errors.Is(err, api.ErrHandshake) || errors.Is(err, api.ErrEncryptionNeeded) || errors.Is(err, ErrInvalidPassword) {
+		return resilience.Permanent
+	}
+	if errors.Is(err, ErrDisconnected) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return resilience.Transient
+	}
+
+	return resilience.Permanent
+}
@@ -0,0 +1,260 @@
+package esphome
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/esphome/api"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/esphome/esphometest"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+const testKey = "px7tsbK3C7bpXHr2OevEV2ZMg/FrNBw2+O2pNPbedtA="
+
+func startProvider(t *testing.T, devices ...DeviceConfig) (*device.Registry, *provider.Manager) {
+	t.Helper()
+
+	raw, _ := json.Marshal(Settings{Devices: devices})
+	p, err := NewProvider("esphome", raw, provider.Env{})
+	if err != nil {
+		t.Fatalf("NewProvider failed: %v", err)
+	}
+
+	registry := device.NewRegistry()
+	m := provider.NewManager(registry, provider.Env{})
+	m.Add(p, "esphome")
+	m.StartAll(context.Background())
+	t.Cleanup(func() { m.StopAll(context.Background()) })
+	return registry, m
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(resilience.ReconnectMinDelay + 2*time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func attr(dev device.Device, name string) any {
+	state, _ := dev.State(context.Background())
+	return state.Attributes[name]
+}
+
+func execute(t *testing.T, dev device.Device, action string, params map[string]any) {
+	t.Helper()
+	if err := dev.Execute(context.Background(), device.Command{DeviceID: dev.ID(), Action: action, Params: params}); err != nil {
+		t.Fatalf("Execute %s failed: %v", action, err)
+	}
+}
+
+func kitchenNode(t *testing.T, key string) *esphometest.Node {
+	node := esphometest.NewNode(t, "kitchen", key)
+	node.AddSwitch("kettle", "Kettle")
+	node.AddLight("strip", "Strip", esphometest.ModeRGB, esphometest.ModeColorTemperature)
+	node.AddSensor("temperature", "Temperature", "temperature", "°C", 1)
+	node.AddBinarySensor("motion", "Motion", "motion")
+	return node
+}
+
+func TestESPHome_ImportsEntities(t *testing.T) {
+	node := kitchenNode(t, testKey)
+	node.SetSensor("temperature", 21.34)
+	registry, _ := startProvider(t, DeviceConfig{Address: node.Address(), EncryptionKey: testKey})
+
+	if n := len(registry.List()); n != 4 {
+		t.Fatalf("Expected 4 devices, got %d", n)
+	}
+
+	tests := []struct {
+		id         device.ID
+		deviceType string
+		attributes map[string]any
+	}{
+		{"esphome-kitchen-switch-kettle", "switch", map[string]any{"name": "Kettle", "node": "kitchen", "power": "off"}},
+		{"esphome-kitchen-light-strip", "light", map[string]any{"power": "off", "brightness": 100, "hue": 0, "saturation": 0}},
+		{"esphome-kitchen-sensor-temperature", "sensor", map[string]any{"temperature": 21.3, "unit": "°C"}},
+		{"esphome-kitchen-binary_sensor-motion", "sensor", map[string]any{"motion": false}},
+	}
+	for _, tt := range tests {
+		dev, err := registry.Get(tt.id)
+		if err != nil {
+			t.Errorf("Expected %s to be registered: %v", tt.id, err)
+			continue
+		}
+		// States follow the subscription
+		waitFor(t, string(tt.id)+" state", func() bool {
+			state, _ := dev.State(context.Background())
+			return !state.UpdatedAt.IsZero()
+		})
+		state, err := dev.State(context.Background())
+		if err != nil {
+			t.Errorf("%s: State failed: %v", tt.id, err)
+		}
+		if state.DeviceType != tt.deviceType {
+			t.Errorf("%s: expected type %s, got %s", tt.id, tt.deviceType, state.DeviceType)
+		}
+		for k, v := range tt.attributes {
+			if state.Attributes[k] != v {
+				t.Errorf("%s: expected %s %v, got %v", tt.id, k, v, state.Attributes[k])
+			}
+		}
+	}
+
+	// Sensor and local changes arrive as state updates
+	sensor, _ := registry.Get("esphome-kitchen-binary_sensor-motion")
+	kettle, _ := registry.Get("esphome-kitchen-switch-kettle")
+	node.SetBinarySensor("motion", true)
+	node.SetSwitch("kettle", true)
+	waitFor(t, "state updates", func() bool {
+		return attr(sensor, "motion") == true && attr(kettle, "power") == "on"
+	})
+}
+
+func TestESPHome_Commands(t *testing.T) {
+	node := kitchenNode(t, "")
+	registry, _ := startProvider(t, DeviceConfig{Address: node.Address()})
+
+	kettle, _ := registry.Get("esphome-kitchen-switch-kettle")
+	execute(t, kettle, "turn_on", nil)
+	if attr(kettle, "power") != "on" {
+		t.Error("Expected kettle to be on straight away")
+	}
+	waitFor(t, "switch command", func() bool { return node.Switch("kettle") })
+	execute(t, kettle, "toggle", nil)
+	waitFor(t, "toggle", func() bool { return !node.Switch("kettle") })
+
+	strip, _ := registry.Get("esphome-kitchen-light-strip")
+	execute(t, strip, "set_brightness", map[string]any{"value": 40})
+	execute(t, strip, "set_color", map[string]any{"hue": 120, "saturation": 100})
+	waitFor(t, "light commands", func() bool {
+		l := node.Light("strip")
+		return l.State && l.Brightness == 0.4 && l.Green == 1 && l.Red == 0
+	})
+	if attr(strip, "hue") != 120 || attr(strip, "saturation") != 100 || attr(strip, "brightness") != 40 {
+		t.Errorf("Unexpected light state: hue %v, saturation %v, brightness %v", attr(strip, "hue"), attr(strip, "saturation"), attr(strip, "brightness"))
+	}
+
+	execute(t, strip, "set_color_temperature", map[string]any{"value": 2700})
+	waitFor(t, "color temperature", func() bool { return node.Light("strip").ColorMode == esphometest.ModeColorTemperature })
+	if ct := attr(strip, "color_temperature"); ct != 2700 {
+		t.Errorf("Expected color_temperature 2700, got %v", ct)
+	}
+	if _, ok := attr(strip, "hue").(int); ok {
+		t.Error("Expected no hue in color temperature mode")
+	}
+
+	commands := node.Commands()
+	if len(commands) != 5 {
+		t.Fatalf("Expected 5 commands, got %d", len(commands))
+	}
+	if cmd := commands[4].(*api.LightCommandRequest); !cmd.HasColorTemperature || cmd.ColorTemperature != float32(1e6/2700.0) {
+		t.Errorf("Unexpected color temperature command %+v", cmd)
+	}
+
+	ctx := context.Background()
+	err := strip.Execute(ctx, device.Command{Action: "set_color_temperature", Params: map[string]any{"value": 9000}})
+	if !errors.Is(err, device.ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter outside the light's range, got %v", err)
+	}
+	sensor, _ := registry.Get("esphome-kitchen-sensor-temperature")
+	if err := sensor.Execute(ctx, device.Command{Action: "turn_on"}); !errors.Is(err, device.ErrUnknownCommand) {
+		t.Errorf("Expected ErrUnknownCommand for a sensor, got %v", err)
+	}
+}
+
+func TestESPHome_ResyncsAfterReconnect(t *testing.T) {
+	node := kitchenNode(t, testKey)
+	registry, m := startProvider(t, DeviceConfig{Address: node.Address(), EncryptionKey: testKey})
+	kettle, _ := registry.Get("esphome-kitchen-switch-kettle")
+
+	node.RemoveEntity("motion")
+	node.AddSwitch("fan", "Fan")
+	node.Disconnect()
+	waitFor(t, "resync", func() bool {
+		_, removed := registry.Get("esphome-kitchen-binary_sensor-motion")
+		_, added := registry.Get("esphome-kitchen-switch-fan")
+		return removed != nil && added == nil
+	})
+	if _, err := kettle.State(context.Background()); err != nil {
+		t.Errorf("Expected state without error after reconnecting, got %v", err)
+	}
+	if health, _ := m.Health(context.Background(), "esphome"); health.Status != provider.HealthOK {
+		t.Errorf("Expected healthy provider after reconnecting, got %+v", health)
+	}
+}
+
+func TestESPHome_ConnectErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		node   *esphometest.Node
+		config func(address string) DeviceConfig
+		want   error
+	}{
+		{
+			name:   "plaintext client, encrypted node",
+			node:   kitchenNode(t, testKey),
+			config: func(address string) DeviceConfig { return DeviceConfig{Address: address} },
+			want:   api.ErrEncryptionNeeded,
+		},
+		{
+			name: "wrong key",
+			node: kitchenNode(t, testKey),
+			config: func(address string) DeviceConfig {
+				return DeviceConfig{Address: address, EncryptionKey: "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="}
+			},
+			want: api.ErrHandshake,
+		},
+		{
+			name: "encrypted client, plaintext node",
+			node: kitchenNode(t, ""),
+			config: func(address string) DeviceConfig {
+				return DeviceConfig{Address: address, EncryptionKey: testKey}
+			},
+			want: api.ErrHandshake,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, _ := json.Marshal(Settings{Devices: []DeviceConfig{tt.config(tt.node.Address())}})
+			p, err := NewProvider("esphome", raw, provider.Env{})
+			if err != nil {
+				t.Fatalf("NewProvider failed: %v", err)
+			}
+			if err := p.Start(context.Background(), device.NewRegistry()); err != nil {
+				t.Fatalf("Start failed: %v", err)
+			}
+			defer p.Stop(context.Background())
+
+			if err := p.Discover(context.Background()); !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+			if health := p.Health(context.Background()); health.Status == provider.HealthOK {
+				t.Errorf("Expected unhealthy provider, got %+v", health)
+			}
+		})
+	}
+
+	node := kitchenNode(t, "")
+	node.SetPassword("secret")
+	raw, _ := json.Marshal(Settings{Devices: []DeviceConfig{{Address: node.Address(), Password: "wrong"}}})
+	p, _ := NewProvider("esphome", raw, provider.Env{})
+	p.Start(context.Background(), device.NewRegistry())
+	defer p.Stop(context.Background())
+	if err := p.Discover(context.Background()); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("Expected ErrInvalidPassword, got %v", err)
+	}
+
+	raw, _ = json.Marshal(Settings{Devices: []DeviceConfig{{Address: node.Address(), EncryptionKey: "c2hvcnQ="}}})
+	if _, err := NewProvider("esphome", raw, provider.Env{}); !errors.Is(err, api.ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey, got %v", err)
+	}
+}
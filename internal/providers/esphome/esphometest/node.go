@@ -0,0 +1,371 @@
+// Package esphometest runs a fake ESPHome node for tests. It speaks the native API
+// in plaintext or with Noise encryption: hello, connect, device info, entity
+// listing, state subscriptions, pings, and switch and light commands.
+package esphometest
+
+import (
+	"hash/fnv"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/legitlolly/SmartHomeHub/internal/providers/esphome/api"
+)
+
+// Supported color modes, as the node lists them: the capability bits of each mode
+const (
+	ModeBrightness       = api.ColorModeOnOff | api.ColorModeBrightness
+	ModeColorTemperature = ModeBrightness | api.ColorModeColorTemperature
+	ModeRGB              = ModeBrightness | api.ColorModeRGB
+)
+
+type Node struct {
+	listener net.Listener
+	name     string
+	psk      []byte
+
+	mu       sync.Mutex
+	password string
+	entities []*entity
+	conns    map[*api.Conn]bool // subscribed
+	commands []api.Message
+}
+
+type entity struct {
+	info  *api.ListEntitiesResponse
+	state api.Message
+}
+
+// NewNode starts a fake node called name. With a base64 key it requires encryption;
+// with an empty one it speaks plaintext. It is closed when the test ends.
+func NewNode(t testing.TB, name, key string) *Node {
+	t.Helper()
+
+	n := &Node{name: name, conns: make(map[*api.Conn]bool)}
+	if key != "" {
+		psk, err := api.ParseKey(key)
+		if err != nil {
+			t.Fatalf("esphometest: %v", err)
+		}
+		n.psk = psk
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("esphometest: listen failed: %v", err)
+	}
+	n.listener = l
+	go n.serve()
+	t.Cleanup(func() {
+		l.Close()
+		n.Disconnect()
+	})
+	return n
+}
+
+// Address is host:port, for the provider's address setting.
+func (n *Node) Address() string {
+	return n.listener.Addr().String()
+}
+
+// SetPassword makes the node require the legacy API password.
+func (n *Node) SetPassword(password string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.password = password
+}
+
+// Commands returns the commands received so far, in order.
+func (n *Node) Commands() []api.Message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]api.Message(nil), n.commands...)
+}
+
+// Disconnect closes every connection; the node keeps accepting new ones.
+func (n *Node) Disconnect() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for conn := range n.conns {
+		conn.Close()
+	}
+}
+
+// entityKey hashes the object ID, as ESPHome does.
+func entityKey(objectID string) uint32 {
+	h := fnv.New32()
+	h.Write([]byte(objectID))
+	return h.Sum32()
+}
+
+func (n *Node) add(typ uint16, objectID, name string, state api.Message) *api.ListEntitiesResponse {
+	info := &api.ListEntitiesResponse{
+		MessageType: typ,
+		ObjectID:    objectID,
+		Key:         entityKey(objectID),
+		Name:        name,
+		UniqueID:    n.name + objectID,
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.entities = append(n.entities, &entity{info: info, state: state})
+	return info
+}
+
+// AddSwitch adds a switch that is off.
+func (n *Node) AddSwitch(objectID, name string) {
+	n.add(api.TypeListEntitiesSwitchResponse, objectID, name,
+		&api.StateResponse{MessageType: api.TypeSwitchStateResponse, Key: entityKey(objectID)})
+}
+
+// AddLight adds a light that is off at full brightness, supporting modes, with a
+// color temperature range of 153-500 mireds.
+func (n *Node) AddLight(objectID, name string, modes ...uint32) {
+	state := &api.LightStateResponse{Key: entityKey(objectID), Brightness: 1, ColorMode: modes[0], Red: 1, Green: 1, Blue: 1, ColorTemperature: 370}
+	info := n.add(api.TypeListEntitiesLightResponse, objectID, name, state)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	info.SupportedColorModes = modes
+	info.MinMireds, info.MaxMireds = 153, 500
+}
+
+// AddSensor adds a sensor with no reading yet.
+func (n *Node) AddSensor(objectID, name, deviceClass, unit string, decimals int) {
+	info := n.add(api.TypeListEntitiesSensorResponse, objectID, name,
+		&api.StateResponse{MessageType: api.TypeSensorStateResponse, Key: entityKey(objectID), MissingState: true})
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	info.DeviceClass, info.UnitOfMeasurement, info.AccuracyDecimals = deviceClass, unit, int32(decimals)
+}
+
+// AddBinarySensor adds a binary sensor that is off.
+func (n *Node) AddBinarySensor(objectID, name, deviceClass string) {
+	info := n.add(api.TypeListEntitiesBinarySensorResponse, objectID, name,
+		&api.StateResponse{MessageType: api.TypeBinarySensorStateResponse, Key: entityKey(objectID)})
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	info.DeviceClass = deviceClass
+}
+
+// RemoveEntity removes an entity, as flashing new firmware would. Connected clients
+// only see it gone after reconnecting.
+func (n *Node) RemoveEntity(objectID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i, e := range n.entities {
+		if e.info.ObjectID == objectID {
+			n.entities = append(n.entities[:i], n.entities[i+1:]...)
+			return
+		}
+	}
+}
+
+// SetSensor publishes a sensor reading.
+func (n *Node) SetSensor(objectID string, value float32) {
+	n.setState(&api.StateResponse{MessageType: api.TypeSensorStateResponse, Key: entityKey(objectID), Value: value})
+}
+
+// SetBinarySensor publishes a binary sensor state.
+func (n *Node) SetBinarySensor(objectID string, on bool) {
+	n.setState(&api.StateResponse{MessageType: api.TypeBinarySensorStateResponse, Key: entityKey(objectID), State: on})
+}
+
+// SetSwitch changes a switch locally, as its button would.
+func (n *Node) SetSwitch(objectID string, on bool) {
+	n.setState(&api.StateResponse{MessageType: api.TypeSwitchStateResponse, Key: entityKey(objectID), State: on})
+}
+
+// Switch returns whether a switch is on.
+func (n *Node) Switch(objectID string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if e := n.find(entityKey(objectID)); e != nil {
+		if state, ok := e.state.(*api.StateResponse); ok {
+			return state.State
+		}
+	}
+	return false
+}
+
+// Light returns a light's state.
+func (n *Node) Light(objectID string) api.LightStateResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if e := n.find(entityKey(objectID)); e != nil {
+		if state, ok := e.state.(*api.LightStateResponse); ok {
+			return *state
+		}
+	}
+	return api.LightStateResponse{}
+}
+
+// find returns the entity with key. Must be called with mu held.
+func (n *Node) find(key uint32) *entity {
+	for _, e := range n.entities {
+		if e.info.Key == key {
+			return e
+		}
+	}
+	return nil
+}
+
+// setState stores a state and sends it to every subscribed connection.
+func (n *Node) setState(state api.Message) {
+	n.mu.Lock()
+	var key uint32
+	switch s := state.(type) {
+	case *api.StateResponse:
+		key = s.Key
+	case *api.LightStateResponse:
+		key = s.Key
+	}
+	if e := n.find(key); e != nil {
+		e.state = state
+	}
+	var subscribed []*api.Conn
+	for conn, ok := range n.conns {
+		if ok {
+			subscribed = append(subscribed, conn)
+		}
+	}
+	n.mu.Unlock()
+
+	for _, conn := range subscribed {
+		conn.Send(state)
+	}
+}
+
+func (n *Node) serve() {
+	for {
+		conn, err := n.listener.Accept()
+		if err != nil {
+			return
+		}
+		go n.handle(conn)
+	}
+}
+
+func (n *Node) handle(netConn net.Conn) {
+	defer netConn.Close()
+
+	conn := api.NewPlaintextConn(netConn)
+	if n.psk != nil {
+		var err error
+		if conn, err = api.ServerHandshake(netConn, n.psk, n.name); err != nil {
+			return
+		}
+	}
+
+	n.mu.Lock()
+	n.conns[conn] = false
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		delete(n.conns, conn)
+		n.mu.Unlock()
+	}()
+
+	for {
+		// A plaintext node also just closes on a noise hello
+		msg, err := conn.Receive()
+		if err != nil {
+			return
+		}
+		if !n.reply(conn, msg) {
+			return
+		}
+	}
+}
+
+// reply handles one message, returning false when the connection should close.
+func (n *Node) reply(conn *api.Conn, msg api.Message) bool {
+	switch msg := msg.(type) {
+	case *api.HelloRequest:
+		conn.Send(&api.HelloResponse{APIVersionMajor: 1, APIVersionMinor: 10, ServerInfo: n.name + " (esphome v2024.6.0)", Name: n.name})
+
+	case *api.ConnectRequest:
+		n.mu.Lock()
+		invalid := n.psk == nil && msg.Password != n.password
+		n.mu.Unlock()
+		conn.Send(&api.ConnectResponse{InvalidPassword: invalid})
+		return !invalid
+
+	case *api.SwitchCommandRequest:
+		n.record(msg)
+		n.setState(&api.StateResponse{MessageType: api.TypeSwitchStateResponse, Key: msg.Key, State: msg.State})
+
+	case *api.LightCommandRequest:
+		n.record(msg)
+		n.mu.Lock()
+		var state api.LightStateResponse
+		if e := n.find(msg.Key); e != nil {
+			state = *e.state.(*api.LightStateResponse)
+		}
+		n.mu.Unlock()
+		if msg.HasState {
+			state.State = msg.State
+		}
+		if msg.HasBrightness {
+			state.Brightness = msg.Brightness
+		}
+		if msg.HasRGB {
+			state.Red, state.Green, state.Blue = msg.Red, msg.Green, msg.Blue
+			state.ColorMode = ModeRGB
+		}
+		if msg.HasColorTemperature {
+			state.ColorTemperature = msg.ColorTemperature
+			state.ColorMode = ModeColorTemperature
+		}
+		n.setState(&state)
+
+	case *api.Empty:
+		switch msg.MessageType {
+		case api.TypeDeviceInfoRequest:
+			conn.Send(&api.DeviceInfoResponse{
+				Name:           n.name,
+				MACAddress:     "AC:67:B2:00:00:01",
+				ESPHomeVersion: "2024.6.0",
+				Model:          "esp32dev",
+				Manufacturer:   "Espressif",
+			})
+
+		case api.TypeListEntitiesRequest:
+			n.mu.Lock()
+			entities := make([]*api.ListEntitiesResponse, len(n.entities))
+			for i, e := range n.entities {
+				entities[i] = e.info
+			}
+			n.mu.Unlock()
+			for _, e := range entities {
+				conn.Send(e)
+			}
+			conn.Send(&api.Empty{MessageType: api.TypeListEntitiesDoneResponse})
+
+		case api.TypeSubscribeStatesRequest:
+			n.mu.Lock()
+			n.conns[conn] = true
+			states := make([]api.Message, len(n.entities))
+			for i, e := range n.entities {
+				states[i] = e.state
+			}
+			n.mu.Unlock()
+			for _, state := range states {
+				conn.Send(state)
+			}
+
+		case api.TypePingRequest:
+			conn.Send(&api.Empty{MessageType: api.TypePingResponse})
+
+		case api.TypeDisconnectRequest:
+			conn.Send(&api.Empty{MessageType: api.TypeDisconnectResponse})
+			return false
+		}
+	}
+	return true
+}
+
+func (n *Node) record(msg api.Message) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.commands = append(n.commands, msg)
+}
@@ -0,0 +1,37 @@
+package esphome
+
+import (
+	"context"
+
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+// listen keeps the connection to a node open until ctx is cancelled, starting from
+// the result of the first connect. Every reconnect lists the entities again, so
+// changes to the node's YAML are picked up after it is flashed.
+func (p *Provider) listen(ctx context.Context, n *node, closed <-chan struct{}, err error) {
+	first := true
+	resilience.Reconnect(ctx, "esphome: "+n.address, func(ctx context.Context) (bool, error) {
+		if !first {
+			closed, err = p.connect(ctx, n)
+		}
+		first = false
+		if err != nil {
+			return false, err
+		}
+		err := n.wait(ctx, closed)
+		n.setConnected(false)
+		return true, err
+	})
+}
+
+func (n *node) wait(ctx context.Context, closed <-chan struct{}) error {
+	select {
+	case <-closed:
+		return ErrDisconnected
+	case <-ctx.Done():
+		n.client.Close()
+		<-closed
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,306 @@
+// Package esphome integrates ESPHome nodes through their native API, the protobuf
+// protocol on port 6053 that Home Assistant uses, with or without the Noise
+// encryption configured by api: encryption: key. Each light, switch, sensor and binary
+// sensor entity of a node becomes a hub device, kept current by the node's state
+// updates.
+package esphome
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/esphome/api"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+func init() {
+	provider.RegisterFactory("esphome", NewProvider)
+}
+
+type Settings struct {
+	Devices    []DeviceConfig      `json:"devices"`
+	Resilience resilience.Settings `json:"resilience"`
+}
+
+// DeviceConfig is one node. Nodes with encryption need its key; the password is
+// only for nodes still using the deprecated api: password: option.
+type DeviceConfig struct {
+	// host[:port], by default port 6053
+	Address       string `json:"address"`
+	EncryptionKey string `json:"encryption_key"`
+	Password      string `json:"password"`
+}
+
+type Provider struct {
+	name     string
+	settings Settings
+	guards   *resilience.Registry
+	nodes    []*node
+
+	mu        sync.Mutex
+	registry  provider.Registry
+	cancel    context.CancelFunc
+	listenCtx context.Context
+	wg        sync.WaitGroup
+}
+
+// node is the connection to one ESPHome node and the devices for its entities.
+type node struct {
+	address string
+	client  *Client
+	guard   *resilience.Guard
+
+	mu        sync.Mutex
+	info      NodeInfo
+	devices   map[uint32]*Device
+	connected bool
+	listening bool
+}
+
+func NewProvider(name string, raw json.RawMessage, env provider.Env) (provider.Provider, error) {
+	var settings Settings
+	if err := provider.DecodeSettings(raw, &settings); err != nil {
+		return nil, err
+	}
+
+	guards := env.Guards
+	if guards == nil {
+		guards = resilience.NewRegistry()
+	}
+	guards.Configure(name, settings.Resilience.Apply(resilience.DefaultConfig()), ClassifyError)
+
+	p := &Provider{
+		name:     name,
+		settings: settings,
+		guards:   guards,
+	}
+	for _, cfg := range settings.Devices {
+		var psk []byte
+		if cfg.EncryptionKey != "" {
+			var err error
+			if psk, err = api.ParseKey(cfg.EncryptionKey); err != nil {
+				return nil, fmt.Errorf("%s: %w", cfg.Address, err)
+			}
+		}
+
+		n := &node{devices: make(map[uint32]*Device)}
+		n.client = NewClient(cfg.Address, psk, cfg.Password, n.applyState)
+		n.address = n.client.address
+		n.guard = guards.Guard(name, n.address)
+		p.nodes = append(p.nodes, n)
+	}
+	return p, nil
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+func (p *Provider) Start(ctx context.Context, registry provider.Registry) error {
+	if len(p.nodes) == 0 {
+		return fmt.Errorf("%w: no devices", provider.ErrNotConfigured)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.registry = registry
+	p.listenCtx, p.cancel = context.WithCancel(context.Background())
+	return nil
+}
+
+// Discover connects to every node that isn't connected yet, registering a device for
+// each of its entities, and keeps the connections open from then on. Nodes that can't
+// be reached are retried in the background.
+func (p *Provider) Discover(ctx context.Context) error {
+	var errs []error
+	for _, n := range p.nodes {
+		n.mu.Lock()
+		listening := n.listening
+		n.mu.Unlock()
+		if listening {
+			continue
+		}
+
+		closed, err := p.connect(ctx, n)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", n.address, err))
+		}
+
+		p.mu.Lock()
+		n.mu.Lock()
+		if p.cancel != nil && !n.listening {
+			n.listening = true
+			p.wg.Add(1)
+			go func() {
+				defer p.wg.Done()
+				p.listen(p.listenCtx, n, closed, err)
+			}()
+		}
+		n.mu.Unlock()
+		p.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// connect connects to the node, syncs its devices with the entities it lists and
+// subscribes to their states.
+func (p *Provider) connect(ctx context.Context, n *node) (<-chan struct{}, error) {
+	var info NodeInfo
+	err := n.guard.Do(ctx, func(ctx context.Context) error {
+		var err error
+		info, err = n.client.Connect(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p.syncNode(n, info)
+	closed, err := n.client.Subscribe()
+	if err != nil {
+		return nil, err
+	}
+	n.setConnected(true)
+	return closed, nil
+}
+
+// syncNode registers devices for new entities and unregisters those the node no
+// longer has.
+func (p *Provider) syncNode(n *node, info NodeInfo) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cancel == nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.info = info
+	nodeName := info.FriendlyName
+	if nodeName == "" {
+		nodeName = info.Name
+	}
+
+	seen := make(map[uint32]bool)
+	for _, entity := range info.Entities {
+		id := entityDeviceID(info.Name, entity)
+		seen[entity.Key] = true
+		if d, ok := n.devices[entity.Key]; ok {
+			if d.id == id {
+				d.setEntity(entity, nodeName)
+				continue
+			}
+			// Renamed in the node's YAML
+			p.removeDevice(n, entity.Key)
+		}
+
+		d := newDevice(id, n, entity, nodeName)
+		if err := p.registry.Register(d); err != nil {
+			if !errors.Is(err, device.ErrDeviceAlreadyRegistered) {
+				log.Printf("esphome: failed to register %s: %v", id, err)
+			}
+			continue
+		}
+		n.devices[entity.Key] = d
+		log.Printf("esphome: registered %s %q (%s) as %s", entityDomain(entity), entity.Name, n.address, id)
+	}
+	for key := range n.devices {
+		if !seen[key] {
+			p.removeDevice(n, key)
+		}
+	}
+}
+
+// removeDevice unregisters an entity's device. Must be called with p.mu and n.mu held.
+func (p *Provider) removeDevice(n *node, key uint32) {
+	d, ok := n.devices[key]
+	if !ok {
+		return
+	}
+	p.registry.Unregister(d.id)
+	delete(n.devices, key)
+}
+
+func (p *Provider) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	cancel := p.cancel
+	p.cancel = nil
+	for _, n := range p.nodes {
+		n.mu.Lock()
+		for key := range n.devices {
+			p.removeDevice(n, key)
+		}
+		n.listening = false
+		n.mu.Unlock()
+	}
+	p.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		p.wg.Wait()
+	}
+	for _, n := range p.nodes {
+		n.client.Close()
+	}
+	return nil
+}
+
+// Health reports the breaker state of each node, and degraded while any of them is
+// disconnected.
+func (p *Provider) Health(ctx context.Context) provider.Health {
+	var disconnected []string
+	for _, n := range p.nodes {
+		if !n.isConnected() {
+			disconnected = append(disconnected, n.address)
+		}
+	}
+
+	health := provider.Health{Status: provider.HealthOK}
+	if guards, ok := p.guards.ProviderHealth(p.name); ok {
+		health.Status = guards.Status
+		health.Details = guards
+	}
+	if len(disconnected) > 0 && health.Status == provider.HealthOK {
+		health.Status = provider.HealthDegraded
+		health.Message = fmt.Sprintf("not connected to %v", disconnected)
+	}
+	return health
+}
+
+// applyState routes a state update to the entity's device. It runs on the client's
+// reader.
+func (n *node) applyState(msg api.Message) {
+	var key uint32
+	switch msg := msg.(type) {
+	case *api.LightStateResponse:
+		key = msg.Key
+	case *api.StateResponse:
+		key = msg.Key
+	}
+
+	n.mu.Lock()
+	d, ok := n.devices[key]
+	n.mu.Unlock()
+	if ok {
+		d.applyState(msg)
+	}
+}
+
+func (n *node) setConnected(connected bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.connected = connected
+}
+
+func (n *node) isConnected() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.connected
+}
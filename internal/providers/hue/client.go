@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/amimof/huego"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
 )
 
 // BridgeClient interface allows for mocking in tests
@@ -29,3 +30,37 @@ func (h *HuegoBridge) GetLightContext(ctx context.Context, id int) (*huego.Light
 func (h *HuegoBridge) SetLightStateContext(ctx context.Context, id int, state huego.State) (*huego.Response, error) {
 	return h.bridge.SetLightStateContext(ctx, id, state)
 }
+
+// GuardedBridge retries transient failures of the wrapped client and stops calling
+// the bridge while its circuit breaker is open. Share one per bridge between lights.
+type GuardedBridge struct {
+	client BridgeClient
+	guard  *resilience.Guard
+}
+
+func NewGuardedBridge(client BridgeClient, guard *resilience.Guard) *GuardedBridge {
+	return &GuardedBridge{
+		client: client,
+		guard:  guard,
+	}
+}
+
+func (g *GuardedBridge) GetLightContext(ctx context.Context, id int) (*huego.Light, error) {
+	var light *huego.Light
+	err := g.guard.Do(ctx, func(ctx context.Context) error {
+		var err error
+		light, err = g.client.GetLightContext(ctx, id)
+		return err
+	})
+	return light, err
+}
+
+func (g *GuardedBridge) SetLightStateContext(ctx context.Context, id int, state huego.State) (*huego.Response, error) {
+	var resp *huego.Response
+	err := g.guard.Do(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = g.client.SetLightStateContext(ctx, id, state)
+		return err
+	})
+	return resp, err
+}
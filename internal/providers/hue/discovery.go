@@ -2,6 +2,7 @@ package hue
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 
@@ -9,11 +10,18 @@ import (
 	"github.com/legitlolly/SmartHomeHub/internal/device"
 )
 
-// DiscoverAndRegisterLights discovers all lights on the bridge and registers them
-func DiscoverAndRegisterLights(ctx context.Context, registry Registry, ip, username string) error {
-	bridge := huego.New(ip, username)
-
-	lights, err := bridge.GetLightsContext(ctx)
+// Discover finds all lights on the bridge and registers the ones that are not
+// registered yet
+func (p *Provider) Discover(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var lights []huego.Light
+	err := p.guard.Do(ctx, func(ctx context.Context) error {
+		var err error
+		lights, err = p.bridge.GetLightsContext(ctx)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to discover lights: %w", MapHueError(err))
 	}
@@ -29,19 +37,16 @@ func DiscoverAndRegisterLights(ctx context.Context, registry Registry, ip, usern
 
 		log.Printf("  - Light %d: %s (Model: %s)", light.ID, light.Name, light.ModelID)
 
-		bridgeClient := NewHuegoBridge(ip, username)
-
-		hueDevice := NewHueDevice(deviceID, light.ID, bridgeClient)
-		if err := registry.Register(hueDevice); err != nil {
-			log.Printf("    Failed to register %s: %v", deviceID, err)
+		hueDevice := NewHueDevice(deviceID, light.ID, p.client)
+		if err := p.registry.Register(hueDevice); err != nil {
+			if !errors.Is(err, device.ErrDeviceAlreadyRegistered) {
+				log.Printf("    Failed to register %s: %v", deviceID, err)
+			}
 			continue
 		}
+		p.lights = append(p.lights, deviceID)
 		log.Printf("    Registered as: %s", deviceID)
 	}
 
 	return nil
 }
-
-type Registry interface {
-	Register(dev device.Device) error
-}
@@ -4,12 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
 )
 
 var (
 	ErrBridgeUnreachable    = errors.New("hue bridge is unreachable")
 	ErrLightNotFound        = errors.New("hue light not found")
-	ErrInvalidParameter     = errors.New("invalid parameter value")
 	ErrAuthenticationFailed = errors.New("authentication failed - check HUE_USERNAME")
 )
 
@@ -35,3 +36,15 @@ func MapHueError(err error) error {
 
 	return err
 }
+
+// ClassifyError tells the resilience layer which bridge errors are worth retrying
+func ClassifyError(err error) resilience.Class {
+	if class, ok := resilience.ClassifyNetError(err); ok {
+		return class
+	}
+	if errors.Is(MapHueError(err), ErrBridgeUnreachable) {
+		return resilience.Transient
+	}
+
+	return resilience.Permanent
+}
@@ -2,6 +2,7 @@ package hue
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -64,11 +65,11 @@ func (d *HueDevice) Execute(ctx context.Context, cmd device.Command) error {
 		case int:
 			brightness = v
 		default:
-			return fmt.Errorf("%w: brightness value must be a number", ErrInvalidParameter)
+			return fmt.Errorf("%w: brightness value must be a number", device.ErrInvalidParameter)
 		}
 
 		if brightness < 0 || brightness > 100 {
-			return fmt.Errorf("%w: brightness must be 0-100", ErrInvalidParameter)
+			return fmt.Errorf("%w: brightness must be 0-100", device.ErrInvalidParameter)
 		}
 
 		state.Bri = uint8((brightness * 254) / 100)
@@ -78,12 +79,12 @@ func (d *HueDevice) Execute(ctx context.Context, cmd device.Command) error {
 		}
 
 	default:
-		return fmt.Errorf("unknown command: %s", cmd.Action)
+		return fmt.Errorf("%w: %s", device.ErrUnknownCommand, cmd.Action)
 	}
 
 	_, err := d.client.SetLightStateContext(ctx, d.lightID, state)
 	if err != nil {
-		return MapHueError(err)
+		return deviceError(err)
 	}
 
 	d.updateCacheAfterCommand(cmd)
@@ -94,6 +95,7 @@ func (d *HueDevice) Execute(ctx context.Context, cmd device.Command) error {
 func (d *HueDevice) State(ctx context.Context) (device.State, error) {
 	light, err := d.client.GetLightContext(ctx, d.lightID)
 	if err != nil {
+		err = deviceError(err)
 		d.stateMutex.RLock()
 		defer d.stateMutex.RUnlock()
 
@@ -103,9 +105,9 @@ func (d *HueDevice) State(ctx context.Context) (device.State, error) {
 			Attributes: map[string]interface{}{
 				"power":      "unknown",
 				"brightness": 0,
-				"error":      MapHueError(err).Error(),
+				"error":      err.Error(),
 			},
-		}, MapHueError(err)
+		}, err
 	}
 
 	d.stateMutex.Lock()
@@ -117,7 +119,8 @@ func (d *HueDevice) State(ctx context.Context) (device.State, error) {
 		d.lastState.power = "off"
 	}
 
-	d.lastState.brightness = int(light.State.Bri) * 100 / 254
+	// Rounded, so a brightness set with set_brightness reads back the same
+	d.lastState.brightness = (int(light.State.Bri)*100 + 127) / 254
 
 	if light.State.Hue > 0 {
 		hue := int(light.State.Hue)
@@ -158,6 +161,16 @@ func (d *HueDevice) State(ctx context.Context) (device.State, error) {
 	}, nil
 }
 
+// deviceError maps a bridge error, marking the light unavailable when the bridge
+// can't be reached.
+func deviceError(err error) error {
+	err = MapHueError(err)
+	if errors.Is(err, ErrBridgeUnreachable) {
+		return fmt.Errorf("%w: %w", device.ErrDeviceUnavailable, err)
+	}
+	return err
+}
+
 func (d *HueDevice) updateCacheAfterCommand(cmd device.Command) {
 	d.stateMutex.Lock()
 	defer d.stateMutex.Unlock()
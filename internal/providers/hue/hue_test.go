@@ -4,14 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/amimof/huego"
 	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/device/devicetest"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
 )
 
-// MockBridgeClient implements BridgeClient for testing
+// MockBridgeClient implements BridgeClient for testing. Like the real bridge client it
+// fails calls whose context is done, and it is safe for concurrent use.
 type MockBridgeClient struct {
+	mu          sync.Mutex
 	lights      map[int]*huego.Light
 	getError    error
 	setError    error
@@ -26,8 +31,13 @@ func NewMockBridgeClient() *MockBridgeClient {
 }
 
 func (m *MockBridgeClient) GetLightContext(ctx context.Context, id int) (*huego.Light, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.callHistory = append(m.callHistory, fmt.Sprintf("GetLight(%d)", id))
 
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if m.getError != nil {
 		return nil, m.getError
 	}
@@ -37,12 +47,21 @@ func (m *MockBridgeClient) GetLightContext(ctx context.Context, id int) (*huego.
 		return nil, errors.New("light not found")
 	}
 
-	return light, nil
+	// A copy, as the bridge sends a fresh one with every response
+	copied := *light
+	state := *light.State
+	copied.State = &state
+	return &copied, nil
 }
 
 func (m *MockBridgeClient) SetLightStateContext(ctx context.Context, id int, state huego.State) (*huego.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.callHistory = append(m.callHistory, fmt.Sprintf("SetLightState(%d)", id))
 
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if m.setError != nil {
 		return nil, m.setError
 	}
@@ -70,6 +89,8 @@ func (m *MockBridgeClient) AddLight(id int, name string, on bool, bri uint8) {
 }
 
 func (m *MockBridgeClient) SimulateError(getErr, setErr error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.getError = getErr
 	m.setError = setErr
 }
@@ -209,7 +230,7 @@ func TestHueDevice_Execute_InvalidBrightness(t *testing.T) {
 		t.Fatal("Expected error for invalid brightness, got nil")
 	}
 
-	if !errors.Is(err, ErrInvalidParameter) {
+	if !errors.Is(err, device.ErrInvalidParameter) {
 		t.Errorf("Expected ErrInvalidParameter, got %v", err)
 	}
 }
@@ -232,7 +253,7 @@ func TestHueDevice_Execute_InvalidBrightnessType(t *testing.T) {
 		t.Fatal("Expected error for invalid brightness type, got nil")
 	}
 
-	if !errors.Is(err, ErrInvalidParameter) {
+	if !errors.Is(err, device.ErrInvalidParameter) {
 		t.Errorf("Expected ErrInvalidParameter, got %v", err)
 	}
 }
@@ -275,6 +296,80 @@ func TestHueDevice_Execute_UnknownCommand(t *testing.T) {
 	if err == nil {
 		t.Fatal("Expected error for unknown command, got nil")
 	}
+	if !errors.Is(err, device.ErrUnknownCommand) {
+		t.Errorf("Expected ErrUnknownCommand, got %v", err)
+	}
+}
+
+func TestHueDevice_State_BrightnessRounded(t *testing.T) {
+	tests := []struct {
+		bri  uint8
+		want int
+	}{
+		{0, 0},
+		{1, 0},
+		{2, 1},
+		{127, 50},
+		{190, 75},
+		{191, 75},
+		{254, 100},
+	}
+	for _, tt := range tests {
+		mock := NewMockBridgeClient()
+		mock.AddLight(1, "Test Light", true, tt.bri)
+		dev := NewHueDevice("test-hue-1", 1, mock)
+
+		state, err := dev.State(context.Background())
+		if err != nil {
+			t.Fatalf("State failed: %v", err)
+		}
+		if got := state.Attributes["brightness"]; got != tt.want {
+			t.Errorf("Expected bri %d to read as %d, got %v", tt.bri, tt.want, got)
+		}
+	}
+}
+
+func TestHueDevice_BrightnessReadsBack(t *testing.T) {
+	mock := NewMockBridgeClient()
+	mock.AddLight(1, "Test Light", true, 0)
+	dev := NewHueDevice("test-hue-1", 1, mock)
+	ctx := context.Background()
+
+	for value := 0; value <= 100; value++ {
+		cmd := device.Command{DeviceID: dev.ID(), Action: "set_brightness", Params: map[string]interface{}{"value": value}}
+		if err := dev.Execute(ctx, cmd); err != nil {
+			t.Fatalf("set_brightness %d failed: %v", value, err)
+		}
+		state, err := dev.State(ctx)
+		if err != nil {
+			t.Fatalf("State failed: %v", err)
+		}
+		if got := state.Attributes["brightness"]; got != value {
+			t.Errorf("Expected brightness %d to read back, got %v", value, got)
+		}
+	}
+}
+
+func TestHueDevice_BridgeUnreachableIsUnavailable(t *testing.T) {
+	ctx := context.Background()
+	mock := NewMockBridgeClient()
+	mock.AddLight(1, "Test Light", false, 0)
+	mock.SimulateError(errors.New("connection refused"), errors.New("connection refused"))
+	dev := NewHueDevice("test-hue-1", 1, mock)
+
+	err := dev.Execute(ctx, device.Command{DeviceID: dev.ID(), Action: "turn_on"})
+	if !errors.Is(err, device.ErrDeviceUnavailable) || !errors.Is(err, ErrBridgeUnreachable) {
+		t.Errorf("Expected Execute to fail with ErrDeviceUnavailable and ErrBridgeUnreachable, got %v", err)
+	}
+	if _, err := dev.State(ctx); !errors.Is(err, device.ErrDeviceUnavailable) || !errors.Is(err, ErrBridgeUnreachable) {
+		t.Errorf("Expected State to fail with ErrDeviceUnavailable and ErrBridgeUnreachable, got %v", err)
+	}
+
+	// A light the bridge doesn't know is not an outage
+	mock.SimulateError(errors.New("resource not found"), nil)
+	if _, err := dev.State(ctx); errors.Is(err, device.ErrDeviceUnavailable) || !errors.Is(err, ErrLightNotFound) {
+		t.Errorf("Expected ErrLightNotFound alone, got %v", err)
+	}
 }
 
 func TestHueDevice_ID(t *testing.T) {
@@ -366,3 +461,65 @@ func TestHueDevice_State_BasicAttributes(t *testing.T) {
 		t.Errorf("Expected name=Simple Light, got %v", state.Attributes["name"])
 	}
 }
+
+func TestGuardedBridge_RetriesUnreachableBridge(t *testing.T) {
+	ctx := context.Background()
+	mock := NewMockBridgeClient()
+	mock.AddLight(1, "Test Light", false, 0)
+	mock.SimulateError(nil, errors.New("connection reset by peer"))
+
+	cfg := resilience.DefaultConfig()
+	cfg.Retry[resilience.Transient] = resilience.RetryRule{MaxAttempts: 2}
+	cfg.Breaker.FailureThreshold = 2
+	guard := resilience.NewGuard("hue/test", cfg, ClassifyError)
+
+	dev := NewHueDevice("test-hue-1", 1, NewGuardedBridge(mock, guard))
+
+	cmd := device.Command{DeviceID: dev.ID(), Action: "turn_on"}
+	err := dev.Execute(ctx, cmd)
+	if !errors.Is(err, ErrBridgeUnreachable) {
+		t.Fatalf("Expected ErrBridgeUnreachable, got %v", err)
+	}
+	if len(mock.callHistory) != 2 {
+		t.Errorf("Expected 2 attempts, got %v", mock.callHistory)
+	}
+
+	// Two failures reach the threshold, so the next command never hits the bridge
+	err = dev.Execute(ctx, cmd)
+	if !errors.Is(err, resilience.ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if len(mock.callHistory) != 2 {
+		t.Errorf("Expected no further bridge calls, got %v", mock.callHistory)
+	}
+}
+
+func TestGuardedBridge_LightNotFoundNotRetried(t *testing.T) {
+	ctx := context.Background()
+	mock := NewMockBridgeClient()
+	mock.SimulateError(errors.New("resource, /lights/9, not available"), nil)
+
+	guard := resilience.NewGuard("hue/test", resilience.DefaultConfig(), ClassifyError)
+	dev := NewHueDevice("test-hue-9", 9, NewGuardedBridge(mock, guard))
+
+	if _, err := dev.State(ctx); !errors.Is(err, ErrLightNotFound) {
+		t.Fatalf("Expected ErrLightNotFound, got %v", err)
+	}
+	if len(mock.callHistory) != 1 {
+		t.Errorf("Expected a single attempt, got %v", mock.callHistory)
+	}
+}
+
+func TestHueDevice_Conformance(t *testing.T) {
+	// The checks run one after the other, so the last mock is the device's
+	var mock *MockBridgeClient
+	suite := devicetest.Light(func(t *testing.T) device.Device {
+		mock = NewMockBridgeClient()
+		mock.AddLight(1, "Test Light", false, 0)
+		return NewHueDevice("test-hue-1", 1, mock)
+	})
+	suite.Offline = func(t *testing.T, d device.Device) {
+		mock.SimulateError(errors.New("connection refused"), errors.New("connection refused"))
+	}
+	devicetest.Run(t, suite)
+}
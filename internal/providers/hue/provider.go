@@ -0,0 +1,108 @@
+package hue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/amimof/huego"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+func init() {
+	provider.RegisterFactory("hue", NewProvider)
+}
+
+type Settings struct {
+	BridgeIP   string              `json:"bridge_ip"`
+	Username   string              `json:"username"`
+	Resilience resilience.Settings `json:"resilience"`
+}
+
+// Provider exposes every light on one Hue bridge. Settings fall back to the
+// HUE_BRIDGE_IP and HUE_USERNAME environment variables.
+type Provider struct {
+	name     string
+	settings Settings
+	guards   *resilience.Registry
+
+	mu       sync.Mutex
+	registry provider.Registry
+	bridge   *huego.Bridge
+	client   BridgeClient
+	guard    *resilience.Guard
+	lights   []device.ID
+}
+
+func NewProvider(name string, raw json.RawMessage, env provider.Env) (provider.Provider, error) {
+	var settings Settings
+	if err := provider.DecodeSettings(raw, &settings); err != nil {
+		return nil, err
+	}
+	if settings.BridgeIP == "" {
+		settings.BridgeIP = os.Getenv("HUE_BRIDGE_IP")
+	}
+	if settings.Username == "" {
+		settings.Username = os.Getenv("HUE_USERNAME")
+	}
+
+	guards := env.Guards
+	if guards == nil {
+		guards = resilience.NewRegistry()
+	}
+	// Retry/breaker settings can also be tuned with HUE_RETRY_* and HUE_BREAKER_* variables
+	cfg := resilience.ConfigFromEnv("HUE", settings.Resilience.Apply(resilience.DefaultConfig()))
+	guards.Configure(name, cfg, ClassifyError)
+
+	return &Provider{
+		name:     name,
+		settings: settings,
+		guards:   guards,
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+func (p *Provider) Start(ctx context.Context, registry provider.Registry) error {
+	if p.settings.BridgeIP == "" || p.settings.Username == "" {
+		return fmt.Errorf("%w: set bridge_ip and username (or HUE_BRIDGE_IP and HUE_USERNAME)", provider.ErrNotConfigured)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.registry = registry
+	p.bridge = huego.New(p.settings.BridgeIP, p.settings.Username)
+	// One guard per bridge, shared by all of its lights
+	p.guard = p.guards.Guard(p.name, p.settings.BridgeIP)
+	p.client = NewGuardedBridge(NewHuegoBridge(p.settings.BridgeIP, p.settings.Username), p.guard)
+	return nil
+}
+
+func (p *Provider) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, id := range p.lights {
+		p.registry.Unregister(id)
+	}
+	p.lights = nil
+	return nil
+}
+
+func (p *Provider) Health(ctx context.Context) provider.Health {
+	health, ok := p.guards.ProviderHealth(p.name)
+	if !ok {
+		return provider.Health{Status: provider.HealthOK}
+	}
+	return provider.Health{
+		Status:  health.Status,
+		Details: health,
+	}
+}
@@ -0,0 +1,253 @@
+package kasa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultPort is used for both TCP commands and UDP discovery.
+const DefaultPort = 9999
+
+var ErrDevice = errors.New("kasa device error")
+
+// Client sends commands to one device over TCP, a connection per request as the
+// devices only serve one client at a time.
+type Client struct {
+	address string
+	timeout time.Duration
+}
+
+// NewClient creates a client for address, which is a host or host:port.
+func NewClient(address string) *Client {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, fmt.Sprint(DefaultPort))
+	}
+	return &Client{address: address, timeout: 5 * time.Second}
+}
+
+// Query sends a request, which maps module names to methods to params, and decodes
+// the reply into resp.
+func (c *Client) Query(ctx context.Context, req any, resp any) error {
+	plain, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	if err := writeMessage(conn, plain); err != nil {
+		return err
+	}
+	reply, err := readMessage(conn)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(reply, resp); err != nil {
+		return fmt.Errorf("%w: invalid reply: %v", ErrDevice, err)
+	}
+	return nil
+}
+
+// result is the err_code/err_msg every method reply carries.
+type result struct {
+	ErrCode int    `json:"err_code"`
+	ErrMsg  string `json:"err_msg,omitempty"`
+}
+
+func (r result) err(method string) error {
+	if r.ErrCode != 0 {
+		return fmt.Errorf("%w: %s: %d %s", ErrDevice, method, r.ErrCode, r.ErrMsg)
+	}
+	return nil
+}
+
+// SysInfo is the reply to system.get_sysinfo. Plugs and bulbs share most fields.
+type SysInfo struct {
+	result
+	Alias           string `json:"alias"`
+	Model           string `json:"model"`
+	MAC             string `json:"mac"`
+	MicMAC          string `json:"mic_mac"` // bulbs report the MAC here
+	DeviceID        string `json:"deviceId"`
+	Type            string `json:"type"`
+	MicType         string `json:"mic_type"`
+	SoftwareVersion string `json:"sw_ver"`
+	Feature         string `json:"feature"` // "TIM:ENE" on plugs with an energy meter
+	RSSI            int    `json:"rssi"`
+
+	// Plugs
+	RelayState *int `json:"relay_state,omitempty"`
+
+	// Bulbs
+	IsDimmable          int         `json:"is_dimmable"`
+	IsColor             int         `json:"is_color"`
+	IsVariableColorTemp int         `json:"is_variable_color_temp"`
+	LightState          *LightState `json:"light_state,omitempty"`
+}
+
+// LightState is a bulb's light_state. While off the last settings are in DftOnState.
+type LightState struct {
+	OnOff      int         `json:"on_off"`
+	Hue        int         `json:"hue"`
+	Saturation int         `json:"saturation"`
+	ColorTemp  int         `json:"color_temp"` // 0 in color mode
+	Brightness int         `json:"brightness"`
+	DftOnState *LightState `json:"dft_on_state,omitempty"`
+}
+
+func (s SysInfo) isBulb() bool {
+	return s.Type == "IOT.SMARTBULB" || s.MicType == "IOT.SMARTBULB"
+}
+
+func (s SysInfo) mac() string {
+	if s.MAC != "" {
+		return s.MAC
+	}
+	return s.MicMAC
+}
+
+// Realtime is the reply to emeter.get_realtime. Newer firmware reports milli-units,
+// older firmware plain units.
+type Realtime struct {
+	result
+	VoltageMV *float64 `json:"voltage_mv,omitempty"`
+	CurrentMA *float64 `json:"current_ma,omitempty"`
+	PowerMW   *float64 `json:"power_mw,omitempty"`
+	TotalWh   *float64 `json:"total_wh,omitempty"`
+	Voltage   *float64 `json:"voltage,omitempty"`
+	Current   *float64 `json:"current,omitempty"`
+	Power     *float64 `json:"power,omitempty"`
+	Total     *float64 `json:"total,omitempty"` // kWh
+}
+
+// readings converts either firmware format to W, V, A and kWh.
+func (r Realtime) readings() map[string]float64 {
+	m := make(map[string]float64)
+	pick := func(key string, milli, plain *float64) {
+		switch {
+		case milli != nil:
+			m[key] = *milli / 1000
+		case plain != nil:
+			m[key] = *plain
+		}
+	}
+	pick("power_watts", r.PowerMW, r.Power)
+	pick("voltage", r.VoltageMV, r.Voltage)
+	pick("current", r.CurrentMA, r.Current)
+	pick("energy_kwh", r.TotalWh, r.Total)
+	return m
+}
+
+// Module names. Bulbs use their own namespaces for the light and energy meter.
+const (
+	moduleSystem     = "system"
+	moduleEmeter     = "emeter"
+	moduleBulbEmeter = "smartlife.iot.common.emeter"
+	moduleLighting   = "smartlife.iot.smartbulb.lightingservice"
+	methodSysInfo    = "get_sysinfo"
+	methodRealtime   = "get_realtime"
+	methodSetRelay   = "set_relay_state"
+	methodTransition = "transition_light_state"
+)
+
+// statusReply is the reply to a combined sysinfo and energy meter query.
+type statusReply struct {
+	System struct {
+		SysInfo SysInfo `json:"get_sysinfo"`
+	} `json:"system"`
+	Emeter *struct {
+		Realtime Realtime `json:"get_realtime"`
+	} `json:"emeter,omitempty"`
+	BulbEmeter *struct {
+		Realtime Realtime `json:"get_realtime"`
+	} `json:"smartlife.iot.common.emeter,omitempty"`
+}
+
+// Status fetches sysinfo and, if withEmeter, energy readings in one round trip. The
+// readings are nil when the device has no energy meter.
+func (c *Client) Status(ctx context.Context, withEmeter, bulb bool) (SysInfo, map[string]float64, error) {
+	req := map[string]any{moduleSystem: map[string]any{methodSysInfo: struct{}{}}}
+	if withEmeter {
+		module := moduleEmeter
+		if bulb {
+			module = moduleBulbEmeter
+		}
+		req[module] = map[string]any{methodRealtime: struct{}{}}
+	}
+
+	var reply statusReply
+	if err := c.Query(ctx, req, &reply); err != nil {
+		return SysInfo{}, nil, err
+	}
+	info := reply.System.SysInfo
+	if err := info.err(methodSysInfo); err != nil {
+		return SysInfo{}, nil, err
+	}
+
+	var rt *Realtime
+	switch {
+	case reply.Emeter != nil:
+		rt = &reply.Emeter.Realtime
+	case reply.BulbEmeter != nil:
+		rt = &reply.BulbEmeter.Realtime
+	}
+	if rt == nil || rt.ErrCode != 0 {
+		// Not every model has a meter; that isn't an error
+		return info, nil, nil
+	}
+	return info, rt.readings(), nil
+}
+
+// SetRelay switches a plug.
+func (c *Client) SetRelay(ctx context.Context, on bool) error {
+	state := 0
+	if on {
+		state = 1
+	}
+	var reply struct {
+		System struct {
+			Set result `json:"set_relay_state"`
+		} `json:"system"`
+	}
+	req := map[string]any{moduleSystem: map[string]any{methodSetRelay: map[string]any{"state": state}}}
+	if err := c.Query(ctx, req, &reply); err != nil {
+		return err
+	}
+	return reply.System.Set.err(methodSetRelay)
+}
+
+// TransitionLightState changes a bulb. Fields not in params keep their value.
+func (c *Client) TransitionLightState(ctx context.Context, params map[string]any) (LightState, error) {
+	params["ignore_default"] = 1
+	var reply struct {
+		Lighting struct {
+			Set struct {
+				result
+				LightState
+			} `json:"transition_light_state"`
+		} `json:"smartlife.iot.smartbulb.lightingservice"`
+	}
+	req := map[string]any{moduleLighting: map[string]any{methodTransition: params}}
+	if err := c.Query(ctx, req, &reply); err != nil {
+		return LightState{}, err
+	}
+	if err := reply.Lighting.Set.err(methodTransition); err != nil {
+		return LightState{}, err
+	}
+	return reply.Lighting.Set.LightState, nil
+}
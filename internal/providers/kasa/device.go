@@ -0,0 +1,217 @@
+package kasa
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+// Device is a Kasa plug (a "plug" device) or bulb (a "light" device). State is read
+// from the device on every call, like Hue lights.
+type Device struct {
+	id      device.ID
+	address string
+	client  *Client
+	guard   *resilience.Guard
+
+	mu        sync.RWMutex
+	info      SysInfo
+	updatedAt time.Time
+}
+
+func newDevice(address string, info SysInfo, guard *resilience.Guard) *Device {
+	return &Device{
+		id:        deviceID(info),
+		address:   address,
+		client:    NewClient(address),
+		guard:     guard,
+		info:      info,
+		updatedAt: time.Now(),
+	}
+}
+
+func deviceID(info SysInfo) device.ID {
+	mac := strings.ToLower(strings.NewReplacer(":", "", "-", "").Replace(info.mac()))
+	return device.ID("kasa-" + mac)
+}
+
+func (d *Device) ID() device.ID {
+	return d.id
+}
+
+func (d *Device) sysInfo() SysInfo {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.info
+}
+
+func (d *Device) Execute(ctx context.Context, cmd device.Command) error {
+	info := d.sysInfo()
+	if !info.isBulb() {
+		switch cmd.Action {
+		case "turn_on", "turn_off":
+			return d.guard.Do(ctx, func(ctx context.Context) error {
+				return d.client.SetRelay(ctx, cmd.Action == "turn_on")
+			})
+		}
+		return fmt.Errorf("%w: %s", device.ErrUnknownCommand, cmd.Action)
+	}
+
+	params, err := bulbParams(info, cmd)
+	if err != nil {
+		return err
+	}
+
+	var light LightState
+	err = d.guard.Do(ctx, func(ctx context.Context) error {
+		var err error
+		light, err = d.client.TransitionLightState(ctx, params)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.info.LightState = &light
+	d.updatedAt = time.Now()
+	d.mu.Unlock()
+	return nil
+}
+
+// bulbParams builds transition_light_state params for a command, checking it against
+// what the bulb supports.
+func bulbParams(info SysInfo, cmd device.Command) (map[string]any, error) {
+	switch cmd.Action {
+	case "turn_on":
+		return map[string]any{"on_off": 1}, nil
+
+	case "turn_off":
+		return map[string]any{"on_off": 0}, nil
+
+	case "set_brightness":
+		if info.IsDimmable == 0 {
+			break
+		}
+		v, err := device.NumberParam(cmd.Params, "value", 0, 100)
+		if err != nil {
+			return nil, err
+		}
+		if v == 0 {
+			return map[string]any{"on_off": 0}, nil
+		}
+		return map[string]any{"on_off": 1, "brightness": int(v)}, nil
+
+	case "set_color":
+		if info.IsColor == 0 {
+			break
+		}
+		h, err := device.NumberParam(cmd.Params, "hue", 0, 360)
+		if err != nil {
+			return nil, err
+		}
+		s, err := device.NumberParam(cmd.Params, "saturation", 0, 100)
+		if err != nil {
+			return nil, err
+		}
+		// color_temp 0 switches the bulb to color mode
+		return map[string]any{"on_off": 1, "hue": int(h), "saturation": int(s), "color_temp": 0}, nil
+
+	case "set_color_temperature":
+		if info.IsVariableColorTemp == 0 {
+			break
+		}
+		v, err := device.NumberParam(cmd.Params, "value", 2500, 9000)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"on_off": 1, "color_temp": int(v)}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", device.ErrUnknownCommand, cmd.Action)
+}
+
+func (d *Device) State(ctx context.Context) (device.State, error) {
+	known := d.sysInfo()
+	withEmeter := known.isBulb() || strings.Contains(known.Feature, "ENE")
+
+	var (
+		info     SysInfo
+		readings map[string]float64
+	)
+	err := d.guard.Do(ctx, func(ctx context.Context) error {
+		var err error
+		info, readings, err = d.client.Status(ctx, withEmeter, known.isBulb())
+		return err
+	})
+	if err != nil {
+		d.mu.RLock()
+		updatedAt := d.updatedAt
+		d.mu.RUnlock()
+
+		state := device.State{
+			DeviceType: deviceType(known),
+			UpdatedAt:  updatedAt,
+			Attributes: map[string]interface{}{
+				"power": "unknown",
+				"name":  known.Alias,
+				"error": err.Error(),
+			},
+		}
+		return state, resilience.Unavailable(err)
+	}
+
+	d.mu.Lock()
+	d.info = info
+	d.updatedAt = time.Now()
+	updatedAt := d.updatedAt
+	d.mu.Unlock()
+
+	attributes := map[string]interface{}{
+		"power": "off",
+		"name":  info.Alias,
+		"model": info.Model,
+		"rssi":  info.RSSI,
+	}
+	if info.RelayState != nil && *info.RelayState == 1 {
+		attributes["power"] = "on"
+	}
+	if light := info.LightState; light != nil {
+		if light.OnOff == 1 {
+			attributes["power"] = "on"
+		} else if light.DftOnState != nil {
+			light = light.DftOnState
+		}
+		if info.IsDimmable == 1 {
+			attributes["brightness"] = light.Brightness
+		}
+		if info.IsColor == 1 && light.ColorTemp == 0 {
+			attributes["hue"] = light.Hue
+			attributes["saturation"] = light.Saturation
+		}
+		if info.IsVariableColorTemp == 1 && light.ColorTemp > 0 {
+			attributes["color_temperature"] = light.ColorTemp
+		}
+	}
+	for k, v := range readings {
+		attributes[k] = v
+	}
+
+	return device.State{
+		DeviceType: deviceType(info),
+		UpdatedAt:  updatedAt,
+		Attributes: attributes,
+	}, nil
+}
+
+func deviceType(info SysInfo) string {
+	if info.isBulb() {
+		return "light"
+	}
+	return "plug"
+}
@@ -0,0 +1,63 @@
+package kasa
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"time"
+)
+
+// Discovered is a device that answered a discovery broadcast.
+type Discovered struct {
+	// Address is where the reply came from, which is also the device's TCP address
+	Address string
+	SysInfo SysInfo
+}
+
+// Discover broadcasts a sysinfo request to target (normally 255.255.255.255:9999)
+// and collects the replies that arrive within timeout.
+func Discover(ctx context.Context, target string, timeout time.Duration) ([]Discovered, error) {
+	addr, err := net.ResolveUDPAddr("udp4", target)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	req, _ := json.Marshal(map[string]any{moduleSystem: map[string]any{methodSysInfo: struct{}{}}})
+	if _, err := conn.WriteToUDP(encrypt(req), addr); err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	seen := make(map[string]bool)
+	var found []Discovered
+	buf := make([]byte, 4096)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// The deadline ends discovery; whatever arrived until then is the result
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return found, ctx.Err()
+			}
+			return found, err
+		}
+
+		var reply statusReply
+		if err := json.Unmarshal(decrypt(buf[:n]), &reply); err != nil {
+			continue
+		}
+		info := reply.System.SysInfo
+		if info.mac() == "" || seen[info.mac()] {
+			continue
+		}
+		seen[info.mac()] = true
+		found = append(found, Discovered{Address: from.String(), SysInfo: info})
+	}
+}
@@ -0,0 +1,161 @@
+package kasa
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider/providertest"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/kasa/kasatest"
+)
+
+func hosts(fakes ...*kasatest.Device) Settings {
+	off := false
+	return Settings{Discovery: &off, Hosts: providertest.Addresses(fakes, (*kasatest.Device).Address)}
+}
+
+func TestProtocol_RoundTrip(t *testing.T) {
+	plain := []byte(`{"system":{"get_sysinfo":{}}}`)
+
+	// Known ciphertext from the protocol write-ups
+	want := []byte{0xd0, 0xf2, 0x81, 0xf8, 0x8b, 0xff, 0x9a, 0xf7, 0xd5, 0xef, 0x94, 0xb6, 0xd1, 0xb4, 0xc0, 0x9f}
+	if got := encrypt(plain); !bytes.Equal(got[:len(want)], want) {
+		t.Errorf("Unexpected ciphertext % x", got[:len(want)])
+	}
+
+	var buf bytes.Buffer
+	if err := writeMessage(&buf, plain); err != nil {
+		t.Fatalf("writeMessage failed: %v", err)
+	}
+	got, err := readMessage(&buf)
+	if err != nil {
+		t.Fatalf("readMessage failed: %v", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Errorf("Expected %s, got %s", plain, got)
+	}
+}
+
+func TestKasa_PlugRelayAndEnergy(t *testing.T) {
+	ctx := context.Background()
+	plug := kasatest.NewPlug(t, "Kettle", "50:C7:BF:01:02:03")
+	registry, _ := providertest.Start(t, NewProvider, "kasa", hosts(plug))
+
+	dev, err := registry.Get("kasa-50c7bf010203")
+	if err != nil {
+		t.Fatalf("Expected plug to be registered: %v", err)
+	}
+
+	if err := dev.Execute(ctx, device.Command{DeviceID: dev.ID(), Action: "turn_on"}); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if !plug.On() {
+		t.Error("Expected relay to be on")
+	}
+
+	state, err := dev.State(ctx)
+	if err != nil {
+		t.Fatalf("State failed: %v", err)
+	}
+	if state.DeviceType != "plug" || state.Attributes["power"] != "on" || state.Attributes["name"] != "Kettle" {
+		t.Errorf("Unexpected state: %+v", state)
+	}
+	if state.Attributes["power_watts"] != kasatest.Load {
+		t.Errorf("Expected power_watts %v, got %v", kasatest.Load, state.Attributes["power_watts"])
+	}
+	if state.Attributes["voltage"] != 231.5 || state.Attributes["energy_kwh"] != 1.5 {
+		t.Errorf("Unexpected energy readings: %v", state.Attributes)
+	}
+
+	if err := dev.Execute(ctx, device.Command{Action: "set_brightness", Params: map[string]any{"value": 10}}); !errors.Is(err, device.ErrUnknownCommand) {
+		t.Errorf("Expected ErrUnknownCommand for a plug, got %v", err)
+	}
+}
+
+func TestKasa_BulbColor(t *testing.T) {
+	ctx := context.Background()
+	bulb := kasatest.NewBulb(t, "Lamp", "1C:3B:F3:AA:BB:CC")
+	registry, _ := providertest.Start(t, NewProvider, "kasa", hosts(bulb))
+
+	dev, err := registry.Get("kasa-1c3bf3aabbcc")
+	if err != nil {
+		t.Fatalf("Expected bulb to be registered: %v", err)
+	}
+
+	// Off: settings come from dft_on_state
+	state, _ := dev.State(ctx)
+	if state.DeviceType != "light" || state.Attributes["power"] != "off" || state.Attributes["brightness"] != 80 {
+		t.Errorf("Unexpected state while off: %v", state.Attributes)
+	}
+
+	cmd := device.Command{DeviceID: dev.ID(), Action: "set_color", Params: map[string]any{"hue": float64(240), "saturation": 100}}
+	if err := dev.Execute(ctx, cmd); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if light := bulb.Light(); light["hue"] != 240 || light["color_temp"] != 0 || !bulb.On() {
+		t.Errorf("Expected bulb on in colour mode, got %v", light)
+	}
+
+	cmd = device.Command{DeviceID: dev.ID(), Action: "set_brightness", Params: map[string]any{"value": 30}}
+	if err := dev.Execute(ctx, cmd); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	state, _ = dev.State(ctx)
+	if state.Attributes["brightness"] != 30 || state.Attributes["hue"] != 240 || state.Attributes["saturation"] != 100 {
+		t.Errorf("Unexpected state: %v", state.Attributes)
+	}
+	if _, ok := state.Attributes["color_temperature"]; ok {
+		t.Error("Expected no color_temperature in colour mode")
+	}
+
+	cmd = device.Command{DeviceID: dev.ID(), Action: "set_color_temperature", Params: map[string]any{"value": 1000}}
+	if err := dev.Execute(ctx, cmd); !errors.Is(err, device.ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter, got %v", err)
+	}
+}
+
+func TestKasa_BroadcastDiscovery(t *testing.T) {
+	plug := kasatest.NewPlug(t, "Heater", "50:C7:BF:0A:0B:0C")
+	registry, _ := providertest.Start(t, NewProvider, "kasa", Settings{
+		BroadcastAddress: plug.BroadcastAddress(),
+		DiscoveryTimeout: config.Duration(200 * time.Millisecond),
+	})
+
+	dev, err := registry.Get("kasa-50c7bf0a0b0c")
+	if err != nil {
+		t.Fatalf("Expected discovered plug to be registered: %v", err)
+	}
+	if err := dev.Execute(context.Background(), device.Command{DeviceID: dev.ID(), Action: "turn_on"}); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if !plug.On() {
+		t.Error("Expected relay to be on")
+	}
+}
+
+func TestKasa_UnreachableDevice(t *testing.T) {
+	plug := kasatest.NewPlug(t, "Kettle", "50:C7:BF:01:02:03")
+	registry, _ := providertest.Start(t, NewProvider, "kasa", hosts(plug))
+	dev, _ := registry.Get("kasa-50c7bf010203")
+
+	// Point the device at a closed port
+	dev.(*Device).client = NewClient("127.0.0.1:1")
+
+	_, err := dev.State(context.Background())
+	if !errors.Is(err, device.ErrDeviceUnavailable) {
+		t.Errorf("Expected ErrDeviceUnavailable, got %v", err)
+	}
+}
+
+func TestKasa_Conformance(t *testing.T) {
+	providertest.LightConformance(t, "kasa-1c3bf3aabbcc", func(t *testing.T) *device.Registry {
+		bulb := kasatest.NewBulb(t, "Lamp", "1C:3B:F3:AA:BB:CC")
+		registry, _ := providertest.Start(t, NewProvider, "kasa", hosts(bulb))
+		return registry
+	})
+}
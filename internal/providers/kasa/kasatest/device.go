@@ -0,0 +1,285 @@
+// Package kasatest runs fake Kasa plugs and bulbs for tests. Each fake answers the
+// local protocol over TCP and discovery broadcasts over UDP on the same port.
+package kasatest
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Load is the power a switched on plug reports, in watts.
+const Load = 42.5
+
+type Device struct {
+	tcp net.Listener
+	udp *net.UDPConn
+
+	mu      sync.Mutex
+	sysinfo map[string]any
+	light   map[string]any // bulbs only
+	relay   int            // plugs only
+	totalWh float64
+}
+
+// NewPlug starts a fake HS110 plug with an energy meter. It is closed when the test ends.
+func NewPlug(t testing.TB, alias, mac string) *Device {
+	d := &Device{
+		totalWh: 1500,
+		sysinfo: map[string]any{
+			"sw_ver":      "1.5.4 Build 180815 Rel.121440",
+			"hw_ver":      "2.0",
+			"type":        "IOT.SMARTPLUGSWITCH",
+			"model":       "HS110(EU)",
+			"mac":         mac,
+			"deviceId":    deviceID(mac),
+			"alias":       alias,
+			"feature":     "TIM:ENE",
+			"relay_state": 0,
+			"led_off":     0,
+			"rssi":        -52,
+		},
+	}
+	d.start(t)
+	return d
+}
+
+// NewBulb starts a fake KL130 colour bulb. It is closed when the test ends.
+func NewBulb(t testing.TB, alias, mac string) *Device {
+	d := &Device{
+		sysinfo: map[string]any{
+			"sw_ver":                 "1.8.11 Build 191113 Rel.105336",
+			"hw_ver":                 "1.0",
+			"mic_type":               "IOT.SMARTBULB",
+			"model":                  "KL130(EU)",
+			"mic_mac":                strings.ReplaceAll(mac, ":", ""),
+			"deviceId":               deviceID(mac),
+			"alias":                  alias,
+			"is_dimmable":            1,
+			"is_color":               1,
+			"is_variable_color_temp": 1,
+			"rssi":                   -60,
+		},
+		light: map[string]any{"on_off": 0, "hue": 0, "saturation": 0, "color_temp": 2700, "brightness": 80},
+	}
+	d.start(t)
+	return d
+}
+
+func deviceID(mac string) string {
+	return strings.ReplaceAll(mac, ":", "") + strings.Repeat("0", 28)
+}
+
+func (d *Device) start(t testing.TB) {
+	t.Helper()
+
+	tcp, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	// Discovery replies come from the same port, like a real device's 9999
+	udp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: tcp.Addr().(*net.TCPAddr).Port})
+	if err != nil {
+		tcp.Close()
+		t.Fatalf("failed to listen: %v", err)
+	}
+	d.tcp, d.udp = tcp, udp
+
+	go d.serveTCP()
+	go d.serveUDP()
+	t.Cleanup(func() {
+		tcp.Close()
+		udp.Close()
+	})
+}
+
+// Address is the device's TCP address, for the provider's hosts setting.
+func (d *Device) Address() string {
+	return d.tcp.Addr().String()
+}
+
+// BroadcastAddress is where discovery requests reach this device.
+func (d *Device) BroadcastAddress() string {
+	return d.udp.LocalAddr().String()
+}
+
+// On reports whether the relay or bulb is on.
+func (d *Device) On() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.on()
+}
+
+// Light returns a copy of the bulb's light state.
+func (d *Device) Light() map[string]any {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c := make(map[string]any, len(d.light))
+	for k, v := range d.light {
+		c[k] = v
+	}
+	return c
+}
+
+func (d *Device) serveTCP() {
+	for {
+		conn, err := d.tcp.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			var header [4]byte
+			if _, err := io.ReadFull(conn, header[:]); err != nil {
+				return
+			}
+			cipher := make([]byte, binary.BigEndian.Uint32(header[:]))
+			if _, err := io.ReadFull(conn, cipher); err != nil {
+				return
+			}
+			reply := encrypt(d.handle(decrypt(cipher)))
+			binary.BigEndian.PutUint32(header[:], uint32(len(reply)))
+			conn.Write(append(header[:], reply...))
+		}()
+	}
+}
+
+func (d *Device) serveUDP() {
+	buf := make([]byte, 4096)
+	for {
+		n, from, err := d.udp.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		d.udp.WriteToUDP(encrypt(d.handle(decrypt(buf[:n]))), from)
+	}
+}
+
+// handle answers every module and method in a request.
+func (d *Device) handle(plain []byte) []byte {
+	var req map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(plain, &req); err != nil {
+		return []byte(`{"err_code":-1,"err_msg":"json error"}`)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	reply := make(map[string]any)
+	for module, methods := range req {
+		results := make(map[string]any)
+		for method, params := range methods {
+			results[method] = d.call(module, method, params)
+		}
+		reply[module] = results
+	}
+	data, _ := json.Marshal(reply)
+	return data
+}
+
+// call runs one method. Must be called with mu held.
+func (d *Device) call(module, method string, raw json.RawMessage) any {
+	var params map[string]any
+	json.Unmarshal(raw, &params)
+	bulb := d.light != nil
+
+	switch {
+	case module == "system" && method == "get_sysinfo":
+		info := make(map[string]any, len(d.sysinfo)+2)
+		for k, v := range d.sysinfo {
+			info[k] = v
+		}
+		if bulb {
+			info["light_state"] = d.lightState()
+		} else {
+			info["relay_state"] = d.relay
+		}
+		info["err_code"] = 0
+		return info
+
+	case module == "system" && method == "set_relay_state" && !bulb:
+		state, ok := params["state"].(float64)
+		if !ok {
+			return map[string]any{"err_code": -3, "err_msg": "invalid argument"}
+		}
+		d.relay = int(state)
+		return map[string]any{"err_code": 0}
+
+	case module == "emeter" && method == "get_realtime" && !bulb,
+		module == "smartlife.iot.common.emeter" && method == "get_realtime" && bulb:
+		power := 0.0
+		if d.on() {
+			power = Load
+			if bulb {
+				power = 9.5
+			}
+		}
+		return map[string]any{
+			"voltage_mv": 231500,
+			"current_ma": int(power / 231.5 * 1000),
+			"power_mw":   int(power * 1000),
+			"total_wh":   d.totalWh,
+			"err_code":   0,
+		}
+
+	case module == "smartlife.iot.smartbulb.lightingservice" && method == "transition_light_state" && bulb:
+		for _, key := range []string{"on_off", "hue", "saturation", "color_temp", "brightness"} {
+			if v, ok := params[key].(float64); ok {
+				d.light[key] = int(v)
+			}
+		}
+		state := d.lightState()
+		state["err_code"] = 0
+		return state
+	}
+
+	if module != "system" && !strings.HasPrefix(module, "smartlife.") && module != "emeter" {
+		return map[string]any{"err_code": -1, "err_msg": "module not support"}
+	}
+	return map[string]any{"err_code": -2, "err_msg": "member not support"}
+}
+
+func (d *Device) on() bool {
+	if d.light != nil {
+		return d.light["on_off"] == 1
+	}
+	return d.relay == 1
+}
+
+// lightState is the bulb's light_state; like real bulbs, the settings move into
+// dft_on_state while off. Must be called with mu held.
+func (d *Device) lightState() map[string]any {
+	settings := map[string]any{"mode": "normal"}
+	for _, key := range []string{"hue", "saturation", "color_temp", "brightness"} {
+		settings[key] = d.light[key]
+	}
+	if d.on() {
+		settings["on_off"] = 1
+		return settings
+	}
+	return map[string]any{"on_off": 0, "dft_on_state": settings}
+}
+
+func encrypt(plain []byte) []byte {
+	out := make([]byte, len(plain))
+	key := byte(171)
+	for i, c := range plain {
+		key ^= c
+		out[i] = key
+	}
+	return out
+}
+
+func decrypt(cipher []byte) []byte {
+	out := make([]byte, len(cipher))
+	key := byte(171)
+	for i, c := range cipher {
+		out[i] = key ^ c
+		key = c
+	}
+	return out
+}
@@ -0,0 +1,66 @@
+package kasa
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Kasa devices obfuscate their JSON with an autokey XOR cipher: each byte is XORed
+// with the previous ciphertext byte, starting from 171. Over TCP every message is
+// prefixed with its big-endian length; over UDP it is sent as is.
+const initialKey = 171
+
+// maxMessageSize bounds the length prefix; sysinfo replies are a few KB at most.
+const maxMessageSize = 1 << 20
+
+var ErrMessageTooLarge = errors.New("kasa message too large")
+
+// encrypt obfuscates a plaintext message.
+func encrypt(plain []byte) []byte {
+	out := make([]byte, len(plain))
+	key := byte(initialKey)
+	for i, c := range plain {
+		key ^= c
+		out[i] = key
+	}
+	return out
+}
+
+// decrypt reverses encrypt.
+func decrypt(cipher []byte) []byte {
+	out := make([]byte, len(cipher))
+	key := byte(initialKey)
+	for i, c := range cipher {
+		out[i] = key ^ c
+		key = c
+	}
+	return out
+}
+
+// writeMessage sends a length-prefixed, obfuscated message over a TCP connection.
+func writeMessage(w io.Writer, plain []byte) error {
+	buf := make([]byte, 4+len(plain))
+	binary.BigEndian.PutUint32(buf, uint32(len(plain)))
+	copy(buf[4:], encrypt(plain))
+	_, err := w.Write(buf)
+	return err
+}
+
+// readMessage reads one length-prefixed message from a TCP connection.
+func readMessage(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	if n > maxMessageSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, n)
+	}
+	cipher := make([]byte, n)
+	if _, err := io.ReadFull(r, cipher); err != nil {
+		return nil, err
+	}
+	return decrypt(cipher), nil
+}
@@ -0,0 +1,163 @@
+// Package kasa integrates TP-Link Kasa plugs and bulbs over their local protocol:
+// XOR-obfuscated JSON over TCP for commands and over UDP broadcast for discovery.
+package kasa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+func init() {
+	provider.RegisterFactory("kasa", NewProvider)
+}
+
+type Settings struct {
+	// Hosts are added directly, for devices that don't answer broadcasts (other subnets)
+	Hosts []string `json:"hosts"`
+	// Discovery broadcasts on the local network, on by default
+	Discovery        *bool               `json:"discovery,omitempty"`
+	BroadcastAddress string              `json:"broadcast_address"`
+	DiscoveryTimeout config.Duration     `json:"discovery_timeout"`
+	Resilience       resilience.Settings `json:"resilience"`
+}
+
+type Provider struct {
+	name     string
+	settings Settings
+	guards   *resilience.Registry
+
+	mu       sync.Mutex
+	registry provider.Registry
+	devices  map[device.ID]*Device
+}
+
+func NewProvider(name string, raw json.RawMessage, env provider.Env) (provider.Provider, error) {
+	var settings Settings
+	if err := provider.DecodeSettings(raw, &settings); err != nil {
+		return nil, err
+	}
+	if settings.BroadcastAddress == "" {
+		settings.BroadcastAddress = fmt.Sprintf("255.255.255.255:%d", DefaultPort)
+	}
+	if settings.DiscoveryTimeout <= 0 {
+		settings.DiscoveryTimeout = config.Duration(2 * time.Second)
+	}
+
+	guards := env.Guards
+	if guards == nil {
+		guards = resilience.NewRegistry()
+	}
+	guards.Configure(name, settings.Resilience.Apply(resilience.DefaultConfig()), resilience.ClassifyNetwork)
+
+	return &Provider{
+		name:     name,
+		settings: settings,
+		guards:   guards,
+		devices:  make(map[device.ID]*Device),
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+func (p *Provider) discoveryEnabled() bool {
+	return p.settings.Discovery == nil || *p.settings.Discovery
+}
+
+func (p *Provider) Start(ctx context.Context, registry provider.Registry) error {
+	if len(p.settings.Hosts) == 0 && !p.discoveryEnabled() {
+		return fmt.Errorf("%w: no hosts and discovery is off", provider.ErrNotConfigured)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.registry = registry
+	return nil
+}
+
+// Discover queries the configured hosts and broadcasts for the rest, registering
+// devices that aren't registered yet.
+func (p *Provider) Discover(ctx context.Context) error {
+	var (
+		found []Discovered
+		errs  []error
+	)
+
+	for _, host := range p.settings.Hosts {
+		client := NewClient(host)
+		var info SysInfo
+		err := p.guards.Guard(p.name, client.address).Do(ctx, func(ctx context.Context) error {
+			var err error
+			info, _, err = client.Status(ctx, false, false)
+			return err
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", host, err))
+			continue
+		}
+		found = append(found, Discovered{Address: client.address, SysInfo: info})
+	}
+
+	if p.discoveryEnabled() {
+		replies, err := Discover(ctx, p.settings.BroadcastAddress, p.settings.DiscoveryTimeout.Duration())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("broadcast discovery: %w", err))
+		}
+		found = append(found, replies...)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, f := range found {
+		id := deviceID(f.SysInfo)
+		if _, ok := p.devices[id]; ok {
+			continue
+		}
+
+		d := newDevice(f.Address, f.SysInfo, p.guards.Guard(p.name, f.Address))
+		if err := p.registry.Register(d); err != nil {
+			if !errors.Is(err, device.ErrDeviceAlreadyRegistered) {
+				log.Printf("kasa: failed to register %s: %v", id, err)
+			}
+			continue
+		}
+		p.devices[id] = d
+		log.Printf("kasa: registered %s (%s) at %s as %s", f.SysInfo.Alias, f.SysInfo.Model, f.Address, id)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *Provider) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id := range p.devices {
+		p.registry.Unregister(id)
+	}
+	p.devices = make(map[device.ID]*Device)
+	return nil
+}
+
+func (p *Provider) Health(ctx context.Context) provider.Health {
+	health, ok := p.guards.ProviderHealth(p.name)
+	if !ok {
+		return provider.Health{Status: provider.HealthOK}
+	}
+	return provider.Health{
+		Status:  health.Status,
+		Details: health,
+	}
+}
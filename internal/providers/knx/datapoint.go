@@ -0,0 +1,77 @@
+package knx
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/knx/knxnet"
+)
+
+var ErrInvalidDatapoint = errors.New("invalid datapoint definition")
+
+// Datapoint maps group addresses to an attribute, and to a command if it is
+// writable.
+type Datapoint struct {
+	// Name is the attribute name. A DPT 1 datapoint called power reads "on"/"off"
+	// and is switched with turn_on, turn_off and toggle.
+	Name string `json:"name"`
+	// DPT is the datapoint type: 1.xxx, 5.001 (percent), 5.xxx or 9.xxx
+	DPT string `json:"dpt"`
+	// Address is where the value is written, or where a sensor sends it
+	Address string `json:"address"`
+	// StateAddress is where an actuator reports the value, if not Address
+	StateAddress string `json:"state_address,omitempty"`
+	// Writable datapoints are set with Command (set_<name> by default) and a
+	// "value" param
+	Writable bool   `json:"writable,omitempty"`
+	Command  string `json:"command,omitempty"`
+
+	address      knxnet.GroupAddress
+	stateAddress knxnet.GroupAddress
+}
+
+// validate checks the definition, parses its addresses and fills in defaults.
+func (dp *Datapoint) validate() error {
+	if dp.Name == "" {
+		return fmt.Errorf("%w: missing name", ErrInvalidDatapoint)
+	}
+	if !knxnet.ValidDPT(dp.DPT) {
+		return fmt.Errorf("%w: %s: unsupported dpt %q", ErrInvalidDatapoint, dp.Name, dp.DPT)
+	}
+
+	var err error
+	if dp.address, err = knxnet.ParseGroupAddress(dp.Address); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidDatapoint, dp.Name, err)
+	}
+	dp.stateAddress = dp.address
+	if dp.StateAddress != "" {
+		if dp.stateAddress, err = knxnet.ParseGroupAddress(dp.StateAddress); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidDatapoint, dp.Name, err)
+		}
+	}
+
+	if dp.Writable && dp.Command == "" && !dp.isPower() {
+		dp.Command = "set_" + dp.Name
+	}
+	return nil
+}
+
+// isPower reports whether the datapoint is the device's on/off switch.
+func (dp *Datapoint) isPower() bool {
+	main, _, _ := strings.Cut(dp.DPT, ".")
+	return dp.Name == "power" && main == "1"
+}
+
+// decode turns telegram data into the attribute value.
+func (dp *Datapoint) decode(data []byte) (any, error) {
+	v, err := knxnet.DecodeDPT(dp.DPT, data)
+	if err != nil {
+		return nil, err
+	}
+	if on, ok := v.(bool); ok && dp.isPower() {
+		return device.OnOff(on), nil
+	}
+	return v, nil
+}
@@ -0,0 +1,166 @@
+package knx
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/knx/knxnet"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+// Device is one actuator or sensor declared in the config. Its attributes are the
+// last values seen on its group addresses; commands are group writes.
+type Device struct {
+	id       device.ID
+	config   DeviceConfig
+	tunnel   *Tunnel
+	guard    *resilience.Guard
+	power    *Datapoint
+	commands map[string]*Datapoint
+
+	mu        sync.RWMutex
+	values    map[string]any
+	connected bool
+	updatedAt time.Time
+}
+
+func newDevice(cfg DeviceConfig, tunnel *Tunnel, guard *resilience.Guard) *Device {
+	d := &Device{
+		id:       device.ID(cfg.ID),
+		config:   cfg,
+		tunnel:   tunnel,
+		guard:    guard,
+		commands: make(map[string]*Datapoint),
+		values:   make(map[string]any),
+	}
+	for i := range cfg.Datapoints {
+		dp := &cfg.Datapoints[i]
+		switch {
+		case !dp.Writable:
+		case dp.isPower() && dp.Command == "":
+			d.power = dp
+		default:
+			d.commands[dp.Command] = dp
+		}
+	}
+	return d
+}
+
+func (d *Device) ID() device.ID {
+	return d.id
+}
+
+// apply stores a value seen on one of the datapoint's addresses.
+func (d *Device) apply(dp *Datapoint, value any) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.values[dp.Name] = value
+	d.updatedAt = time.Now()
+}
+
+func (d *Device) setConnected(connected bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.connected = connected
+}
+
+func (d *Device) Execute(ctx context.Context, cmd device.Command) error {
+	dp, value, err := d.target(cmd)
+	if err != nil {
+		return err
+	}
+	data, short, err := knxnet.EncodeDPT(dp.DPT, value)
+	if err != nil {
+		return fmt.Errorf("%w: %v", device.ErrInvalidParameter, err)
+	}
+
+	d.mu.RLock()
+	connected := d.connected
+	d.mu.RUnlock()
+	if !connected {
+		return fmt.Errorf("%w: %w", device.ErrDeviceUnavailable, ErrDisconnected)
+	}
+
+	err = d.guard.Do(ctx, func(ctx context.Context) error {
+		return d.tunnel.Send(ctx, knxnet.CEMI{
+			Destination: dp.address,
+			APCI:        knxnet.GroupValueWrite,
+			Data:        data,
+			Short:       short,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	// The actuator reports back on the state address; until then assume it worked
+	if v, err := dp.decode(data); err == nil {
+		d.apply(dp, v)
+	}
+	return nil
+}
+
+// target maps a command to the datapoint to write and the value to write to it.
+func (d *Device) target(cmd device.Command) (*Datapoint, any, error) {
+	if d.power != nil {
+		switch cmd.Action {
+		case "turn_on", "turn_off":
+			return d.power, cmd.Action == "turn_on", nil
+		case "toggle":
+			d.mu.RLock()
+			on := d.values[d.power.Name] == "on"
+			d.mu.RUnlock()
+			return d.power, !on, nil
+		}
+	}
+
+	dp, ok := d.commands[cmd.Action]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", device.ErrUnknownCommand, cmd.Action)
+	}
+	value, ok := cmd.Params["value"]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: missing value", device.ErrInvalidParameter)
+	}
+	return dp, value, nil
+}
+
+func (d *Device) actions() []string {
+	actions := make([]string, 0, len(d.commands)+3)
+	if d.power != nil {
+		actions = append(actions, "turn_on", "turn_off", "toggle")
+	}
+	for command := range d.commands {
+		actions = append(actions, command)
+	}
+	slices.Sort(actions[len(actions)-len(d.commands):])
+	return actions
+}
+
+func (d *Device) State(ctx context.Context) (device.State, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	attributes := make(map[string]interface{}, len(d.values)+2)
+	for k, v := range d.values {
+		attributes[k] = v
+	}
+	if d.config.Name != "" {
+		attributes["name"] = d.config.Name
+	}
+	attributes["actions"] = d.actions()
+
+	state := device.State{
+		DeviceType: d.config.Type,
+		UpdatedAt:  d.updatedAt,
+		Attributes: attributes,
+	}
+	if !d.connected {
+		return state, fmt.Errorf("%w: %w", device.ErrDeviceUnavailable, ErrDisconnected)
+	}
+	return state, nil
+}
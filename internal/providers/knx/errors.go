@@ -0,0 +1,31 @@
+package knx
+
+import (
+	"errors"
+
+	"github.com/legitlolly/SmartHomeHub/internal/providers/knx/knxnet"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+// ClassifyError retries network failures, a lost tunnel and a gateway with no free
+// tunnels; telegrams the bus refused are permanent.
+func ClassifyError(err error) resilience.Class {
+	if class, ok := resilience.ClassifyNetError(err); ok {
+		return class
+	}
+	if errors.Is(err, ErrNoAck) {
+		return resilience.Timeout
+	}
+	if errors.Is(err, ErrDisconnected) {
+		return resilience.Transient
+	}
+	var statusErr *knxnet.StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.Status {
+		case knxnet.StatusNoMoreConnections, knxnet.StatusConnectionID, knxnet.StatusKNXConnection:
+			return resilience.Transient
+		}
+	}
+
+	return resilience.Permanent
+}
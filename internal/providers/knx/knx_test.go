@@ -0,0 +1,258 @@
+package knx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/knx/knxnet"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/knx/knxtest"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+func testDevices() []DeviceConfig {
+	return []DeviceConfig{
+		{ID: "kitchen-light", Name: "Kitchen light", Type: "light", Datapoints: []Datapoint{
+			{Name: "power", DPT: knxnet.DPTSwitch, Address: "1/0/1", StateAddress: "1/0/2", Writable: true},
+			{Name: "brightness", DPT: knxnet.DPTScaling, Address: "1/1/1", StateAddress: "1/1/2", Writable: true},
+		}},
+		{ID: "fan", Type: "switch", Datapoints: []Datapoint{
+			{Name: "power", DPT: knxnet.DPTSwitch, Address: "2/0/1", Writable: true},
+		}},
+		{ID: "hall-temperature", Datapoints: []Datapoint{
+			{Name: "temperature", DPT: knxnet.DPTTemperature, Address: "3/0/1"},
+		}},
+	}
+}
+
+func newGateway(t *testing.T) *knxtest.Gateway {
+	gw := knxtest.NewGateway(t)
+	gw.Link("1/0/1", "1/0/2")
+	gw.Link("1/1/1", "1/1/2")
+	return gw
+}
+
+func startProvider(t *testing.T, gw *knxtest.Gateway) (*device.Registry, *provider.Manager) {
+	t.Helper()
+
+	raw, _ := json.Marshal(Settings{Gateway: gw.Address(), Devices: testDevices()})
+	p, err := NewProvider("knx", raw, provider.Env{})
+	if err != nil {
+		t.Fatalf("NewProvider failed: %v", err)
+	}
+
+	registry := device.NewRegistry()
+	m := provider.NewManager(registry, provider.Env{})
+	m.Add(p, "knx")
+	m.StartAll(context.Background())
+	t.Cleanup(func() { m.StopAll(context.Background()) })
+
+	if status, _ := m.Status("knx"); status.State != provider.StateRunning || status.Error != "" {
+		t.Fatalf("Expected provider to be running, got %+v", status)
+	}
+	return registry, m
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(resilience.ReconnectMinDelay + 2*time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func attr(dev device.Device, name string) any {
+	state, _ := dev.State(context.Background())
+	return state.Attributes[name]
+}
+
+func execute(t *testing.T, dev device.Device, action string, params map[string]any) {
+	t.Helper()
+	if err := dev.Execute(context.Background(), device.Command{DeviceID: dev.ID(), Action: action, Params: params}); err != nil {
+		t.Fatalf("Execute %s failed: %v", action, err)
+	}
+}
+
+func TestNewProvider_Validation(t *testing.T) {
+	tests := map[string]DeviceConfig{
+		"no id":          {Datapoints: []Datapoint{{Name: "power", DPT: "1.001", Address: "1/0/1"}}},
+		"no datapoints":  {ID: "a"},
+		"bad dpt":        {ID: "a", Datapoints: []Datapoint{{Name: "level", DPT: "14.068", Address: "1/0/1"}}},
+		"bad address":    {ID: "a", Datapoints: []Datapoint{{Name: "power", DPT: "1.001", Address: "1/8/1"}}},
+		"bad state":      {ID: "a", Datapoints: []Datapoint{{Name: "power", DPT: "1.001", Address: "1/0/1", StateAddress: "x"}}},
+		"duplicate name": {ID: "a", Datapoints: []Datapoint{{Name: "power", DPT: "1.001", Address: "1/0/1"}, {Name: "power", DPT: "1.001", Address: "1/0/2"}}},
+		"duplicate command": {ID: "a", Datapoints: []Datapoint{
+			{Name: "level", DPT: "5.001", Address: "1/0/1", Writable: true, Command: "dim"},
+			{Name: "colour", DPT: "5.001", Address: "1/0/2", Writable: true, Command: "dim"},
+		}},
+	}
+	for name, cfg := range tests {
+		raw, _ := json.Marshal(Settings{Gateway: "127.0.0.1", Devices: []DeviceConfig{cfg}})
+		if _, err := NewProvider("knx", raw, provider.Env{}); !errors.Is(err, ErrInvalidDatapoint) {
+			t.Errorf("%s: expected ErrInvalidDatapoint, got %v", name, err)
+		}
+	}
+}
+
+func TestKNX_ReadsCurrentValues(t *testing.T) {
+	gw := newGateway(t)
+	gw.SetValue("1/0/2", knxnet.DPTSwitch, true)
+	gw.SetValue("1/1/2", knxnet.DPTScaling, 60)
+	gw.SetValue("3/0/1", knxnet.DPTTemperature, 19.5)
+	registry, _ := startProvider(t, gw)
+
+	if n := len(registry.List()); n != 3 {
+		t.Fatalf("Expected 3 devices, got %d", n)
+	}
+	light, _ := registry.Get("knx-kitchen-light")
+	sensor, _ := registry.Get("knx-hall-temperature")
+	waitFor(t, "initial values", func() bool {
+		return attr(light, "power") == "on" && attr(light, "brightness") == 60 && attr(sensor, "temperature") == 19.5
+	})
+
+	state, err := light.State(context.Background())
+	if err != nil {
+		t.Fatalf("State failed: %v", err)
+	}
+	if state.DeviceType != "light" || state.Attributes["name"] != "Kitchen light" {
+		t.Errorf("Unexpected state %+v", state)
+	}
+	want := []string{"turn_on", "turn_off", "toggle", "set_brightness"}
+	if got, _ := state.Attributes["actions"].([]string); len(got) != len(want) {
+		t.Errorf("Expected actions %v, got %v", want, got)
+	}
+	if state, _ := sensor.State(context.Background()); state.DeviceType != "sensor" {
+		t.Errorf("Expected the default sensor type, got %s", state.DeviceType)
+	}
+
+	// Each state address is read once
+	reads := 0
+	for _, cemi := range gw.Telegrams() {
+		if cemi.APCI == knxnet.GroupValueRead {
+			reads++
+		}
+	}
+	if reads != 4 {
+		t.Errorf("Expected 4 group reads, got %d", reads)
+	}
+}
+
+func TestKNX_Commands(t *testing.T) {
+	gw := newGateway(t)
+	registry, _ := startProvider(t, gw)
+
+	light, _ := registry.Get("knx-kitchen-light")
+	execute(t, light, "turn_on", nil)
+	if gw.Value("1/0/1", knxnet.DPTSwitch) != true {
+		t.Error("Expected a write of 1 to 1/0/1")
+	}
+	if attr(light, "power") != "on" {
+		t.Error("Expected light to be on")
+	}
+	execute(t, light, "set_brightness", map[string]any{"value": 40})
+	if gw.Value("1/1/1", knxnet.DPTScaling) != 40 {
+		t.Errorf("Expected brightness 40 on 1/1/1, got %v", gw.Value("1/1/1", knxnet.DPTScaling))
+	}
+	waitFor(t, "brightness status", func() bool { return attr(light, "brightness") == 40 })
+	execute(t, light, "toggle", nil)
+	if gw.Value("1/0/1", knxnet.DPTSwitch) != false || attr(light, "power") != "off" {
+		t.Error("Expected toggle to turn the light off")
+	}
+
+	fan, _ := registry.Get("knx-fan")
+	execute(t, fan, "toggle", nil)
+	if gw.Value("2/0/1", knxnet.DPTSwitch) != true || attr(fan, "power") != "on" {
+		t.Error("Expected toggle to turn the fan on")
+	}
+
+	ctx := context.Background()
+	err := light.Execute(ctx, device.Command{Action: "set_brightness", Params: map[string]any{"value": 120}})
+	if !errors.Is(err, device.ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter above 100%%, got %v", err)
+	}
+	err = light.Execute(ctx, device.Command{Action: "set_brightness"})
+	if !errors.Is(err, device.ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter without a value, got %v", err)
+	}
+	sensor, _ := registry.Get("knx-hall-temperature")
+	if err := sensor.Execute(ctx, device.Command{Action: "turn_on"}); !errors.Is(err, device.ErrUnknownCommand) {
+		t.Errorf("Expected ErrUnknownCommand for a sensor, got %v", err)
+	}
+}
+
+func TestKNX_BusTelegrams(t *testing.T) {
+	gw := newGateway(t)
+	registry, _ := startProvider(t, gw)
+
+	// A sensor reporting, and a wall switch turning the light on
+	sensor, _ := registry.Get("knx-hall-temperature")
+	light, _ := registry.Get("knx-kitchen-light")
+	gw.Send("3/0/1", knxnet.DPTTemperature, -4.2)
+	gw.Send("1/0/2", knxnet.DPTSwitch, true)
+	waitFor(t, "bus telegrams", func() bool {
+		return attr(sensor, "temperature") == -4.2 && attr(light, "power") == "on"
+	})
+}
+
+func TestKNX_ReconnectsAfterDisconnect(t *testing.T) {
+	gw := newGateway(t)
+	registry, m := startProvider(t, gw)
+	sensor, _ := registry.Get("knx-hall-temperature")
+
+	gw.SetBusy(true)
+	gw.Disconnect()
+	waitFor(t, "disconnect", func() bool {
+		_, err := sensor.State(context.Background())
+		return errors.Is(err, device.ErrDeviceUnavailable)
+	})
+	if health, _ := m.Health(context.Background(), "knx"); health.Status != provider.HealthDegraded {
+		t.Errorf("Expected degraded health while disconnected, got %+v", health)
+	}
+	fan, _ := registry.Get("knx-fan")
+	if err := fan.Execute(context.Background(), device.Command{Action: "turn_on"}); !errors.Is(err, device.ErrDeviceUnavailable) {
+		t.Errorf("Expected ErrDeviceUnavailable, got %v", err)
+	}
+
+	// Values sent while the tunnel was down are read after reconnecting
+	gw.SetValue("3/0/1", knxnet.DPTTemperature, 23.0)
+	gw.SetBusy(false)
+	waitFor(t, "reconnect", func() bool { return attr(sensor, "temperature") == 23.0 })
+	if _, err := sensor.State(context.Background()); err != nil {
+		t.Errorf("Expected state without error after reconnecting, got %v", err)
+	}
+	execute(t, fan, "turn_on", nil)
+}
+
+func TestKNX_GatewayBusy(t *testing.T) {
+	gw := newGateway(t)
+	gw.SetBusy(true)
+
+	raw, _ := json.Marshal(Settings{Gateway: gw.Address(), Devices: testDevices()})
+	p, err := NewProvider("knx", raw, provider.Env{})
+	if err != nil {
+		t.Fatalf("NewProvider failed: %v", err)
+	}
+	registry := device.NewRegistry()
+	if err := p.Start(context.Background(), registry); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer p.Stop(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = p.Discover(ctx)
+	var statusErr *knxnet.StatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != knxnet.StatusNoMoreConnections {
+		t.Errorf("Expected a no more connections status, got %v", err)
+	}
+	if n := len(registry.List()); n != 0 {
+		t.Errorf("Expected no devices to be registered, got %d", n)
+	}
+}
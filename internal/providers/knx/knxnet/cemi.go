@@ -0,0 +1,144 @@
+package knxnet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// cEMI message codes
+const (
+	LDataReq = 0x11 // to the bus
+	LDataCon = 0x2e // the gateway's confirmation of a request
+	LDataInd = 0x29 // from the bus
+)
+
+// Group services
+const (
+	GroupValueRead     = 0x0000
+	GroupValueResponse = 0x0040
+	GroupValueWrite    = 0x0080
+)
+
+const (
+	// Standard frame, no repeat, system broadcast, low priority
+	control1 = 0xbc
+	// The confirm bit of control1 is set when an L_Data.con failed
+	control1Error = 0x01
+	// Group destination, hop count 6
+	control2      = 0xe0
+	control2Group = 0x80
+)
+
+// CEMI is a group telegram. Data of up to 6 bits (DPT 1 and other small types) is
+// Short and packed into the APCI octet; longer data follows it.
+type CEMI struct {
+	Code        byte
+	Failed      bool // an L_Data.con for a telegram that didn't make it onto the bus
+	Source      IndividualAddress
+	Destination GroupAddress
+	// Group is false for telegrams to an individual address, which the hub ignores
+	Group bool
+	APCI  uint16
+	Data  []byte
+	Short bool
+}
+
+func (c *CEMI) append(b []byte) []byte {
+	ctrl1 := byte(control1)
+	if c.Failed {
+		ctrl1 |= control1Error
+	}
+	ctrl2 := byte(control2)
+	if !c.Group {
+		ctrl2 &^= control2Group
+	}
+	b = append(b, c.Code, 0, ctrl1, ctrl2)
+	b = binary.BigEndian.AppendUint16(b, uint16(c.Source))
+	b = binary.BigEndian.AppendUint16(b, uint16(c.Destination))
+
+	apci := []byte{byte(c.APCI>>8) & 0x03, byte(c.APCI) & 0xc0}
+	if c.Short || len(c.Data) == 0 {
+		if len(c.Data) > 0 {
+			apci[1] |= c.Data[0] & 0x3f
+		}
+		return append(append(b, 1), apci...)
+	}
+	b = append(b, byte(1+len(c.Data)))
+	b = append(b, apci...)
+	return append(b, c.Data...)
+}
+
+func (c *CEMI) decode(b []byte) error {
+	if len(b) < 2 || len(b) < 2+int(b[1]) {
+		return fmt.Errorf("%w: cemi of %d bytes", ErrShortFrame, len(b))
+	}
+	c.Code = b[0]
+	b = b[2+int(b[1]):] // additional info
+	if len(b) < 9 {
+		return fmt.Errorf("%w: cemi of %d bytes", ErrShortFrame, len(b))
+	}
+	c.Failed = b[0]&control1Error != 0
+	c.Group = b[1]&control2Group != 0
+	c.Source = IndividualAddress(binary.BigEndian.Uint16(b[2:]))
+	c.Destination = GroupAddress(binary.BigEndian.Uint16(b[4:]))
+	length := int(b[6])
+	if len(b) < 8+length {
+		return fmt.Errorf("%w: cemi data of %d bytes, expected %d", ErrShortFrame, len(b)-8, length)
+	}
+	apci := b[7:]
+	c.APCI = uint16(apci[0]&0x03)<<8 | uint16(apci[1]&0xc0)
+	if length <= 1 {
+		c.Short = true
+		c.Data = []byte{apci[1] & 0x3f}
+	} else {
+		c.Short = false
+		c.Data = append([]byte(nil), apci[2:1+length]...)
+	}
+	return nil
+}
+
+// GroupAddress is a destination on the bus, written main/middle/sub.
+type GroupAddress uint16
+
+// ParseGroupAddress parses three-level (1/2/3), two-level (1/515) and free (2563)
+// group addresses.
+func ParseGroupAddress(s string) (GroupAddress, error) {
+	parts := strings.Split(s, "/")
+	limits := map[int][]int{1: {65535}, 2: {31, 2047}, 3: {31, 7, 255}}[len(parts)]
+	shifts := map[int][]int{1: {0}, 2: {11, 0}, 3: {11, 8, 0}}[len(parts)]
+	if limits == nil {
+		return 0, fmt.Errorf("invalid group address %q", s)
+	}
+
+	var a uint16
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || n > limits[i] {
+			return 0, fmt.Errorf("invalid group address %q", s)
+		}
+		a |= uint16(n) << shifts[i]
+	}
+	return GroupAddress(a), nil
+}
+
+// MustParseGroupAddress is ParseGroupAddress for addresses known to be valid.
+func MustParseGroupAddress(s string) GroupAddress {
+	a, err := ParseGroupAddress(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+func (a GroupAddress) String() string {
+	return fmt.Sprintf("%d/%d/%d", a>>11, (a>>8)&0x07, a&0xff)
+}
+
+// IndividualAddress identifies a device on the bus, written area.line.device.
+type IndividualAddress uint16
+
+func (a IndividualAddress) String() string {
+	return fmt.Sprintf("%d.%d.%d", a>>12, (a>>8)&0x0f, a&0xff)
+}
@@ -0,0 +1,124 @@
+package knxnet
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+var ErrDatapoint = errors.New("invalid knx datapoint value")
+
+// Datapoint types the hub understands, by main number: 1.xxx are booleans, 5.001
+// a percentage, other 5.xxx a byte, and 9.xxx 2-byte floats such as temperatures.
+const (
+	DPTSwitch      = "1.001"
+	DPTScaling     = "5.001"
+	DPTTemperature = "9.001"
+)
+
+// ValidDPT reports whether dpt is one EncodeDPT and DecodeDPT handle.
+func ValidDPT(dpt string) bool {
+	main, _, _ := strings.Cut(dpt, ".")
+	return main == "1" || main == "5" || main == "9"
+}
+
+// EncodeDPT encodes v, a bool for DPT 1 and a number otherwise, as telegram data.
+func EncodeDPT(dpt string, v any) (data []byte, short bool, err error) {
+	main, _, _ := strings.Cut(dpt, ".")
+	if main == "1" {
+		on, ok := v.(bool)
+		if !ok {
+			return nil, false, fmt.Errorf("%w: DPT %s needs a bool", ErrDatapoint, dpt)
+		}
+		if on {
+			return []byte{1}, true, nil
+		}
+		return []byte{0}, true, nil
+	}
+
+	var n float64
+	switch v := v.(type) {
+	case float64:
+		n = v
+	case int:
+		n = float64(v)
+	default:
+		return nil, false, fmt.Errorf("%w: DPT %s needs a number", ErrDatapoint, dpt)
+	}
+
+	switch {
+	case dpt == DPTScaling:
+		if n < 0 || n > 100 {
+			return nil, false, fmt.Errorf("%w: DPT %s must be 0-100", ErrDatapoint, dpt)
+		}
+		return []byte{byte(math.Round(n * 255 / 100))}, false, nil
+	case main == "5":
+		if n < 0 || n > 255 {
+			return nil, false, fmt.Errorf("%w: DPT %s must be 0-255", ErrDatapoint, dpt)
+		}
+		return []byte{byte(math.Round(n))}, false, nil
+	case main == "9":
+		data, err := encodeFloat16(n)
+		return data, false, err
+	}
+	return nil, false, fmt.Errorf("%w: unsupported DPT %s", ErrDatapoint, dpt)
+}
+
+// DecodeDPT decodes telegram data: a bool for DPT 1, an int for DPT 5 and a float64
+// for DPT 9.
+func DecodeDPT(dpt string, data []byte) (any, error) {
+	main, _, _ := strings.Cut(dpt, ".")
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: no data", ErrDatapoint)
+	}
+
+	switch {
+	case main == "1":
+		return data[0]&1 != 0, nil
+	case dpt == DPTScaling:
+		return int(math.Round(float64(data[0]) * 100 / 255)), nil
+	case main == "5":
+		return int(data[0]), nil
+	case main == "9":
+		if len(data) != 2 {
+			return nil, fmt.Errorf("%w: DPT %s is 2 bytes, got %d", ErrDatapoint, dpt, len(data))
+		}
+		return decodeFloat16(data)
+	}
+	return nil, fmt.Errorf("%w: unsupported DPT %s", ErrDatapoint, dpt)
+}
+
+// encodeFloat16 encodes the KNX 2-byte float: 0.01 * mantissa * 2^exponent, with a
+// sign bit, a 4-bit exponent and an 11-bit mantissa in two's complement.
+func encodeFloat16(v float64) ([]byte, error) {
+	m := math.Round(v * 100)
+	e := 0
+	for m < -2048 || m > 2047 {
+		if e == 15 {
+			return nil, fmt.Errorf("%w: %g is out of range for a 2-byte float", ErrDatapoint, v)
+		}
+		e++
+		m = math.Round(v * 100 / float64(int(1)<<e))
+	}
+	mantissa := int(m)
+	raw := uint16(e)<<11 | uint16(mantissa)&0x07ff
+	if mantissa < 0 {
+		raw |= 0x8000
+	}
+	return []byte{byte(raw >> 8), byte(raw)}, nil
+}
+
+func decodeFloat16(data []byte) (float64, error) {
+	raw := uint16(data[0])<<8 | uint16(data[1])
+	if raw == 0x7fff {
+		return 0, fmt.Errorf("%w: invalid value", ErrDatapoint)
+	}
+	m := int(raw & 0x07ff)
+	if raw&0x8000 != 0 {
+		m -= 2048
+	}
+	e := int(raw>>11) & 0x0f
+	v := 0.01 * float64(m) * float64(int(1)<<e)
+	return math.Round(v*100) / 100, nil
+}
@@ -0,0 +1,330 @@
+// Package knxnet encodes and decodes KNXnet/IP tunnelling frames and the cEMI
+// telegrams they carry. It is shared by the provider and the fake gateway in knxtest.
+//
+// Every frame is a 6 byte big-endian header (header size, protocol version, service
+// type and total length) followed by a body whose layout depends on the service.
+package knxnet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	HeaderSize      = 6
+	ProtocolVersion = 0x10
+	// DefaultPort is where gateways listen
+	DefaultPort = 3671
+)
+
+// Services used for tunnelling
+const (
+	ServiceConnectRequest          = 0x0205
+	ServiceConnectResponse         = 0x0206
+	ServiceConnectionStateRequest  = 0x0207
+	ServiceConnectionStateResponse = 0x0208
+	ServiceDisconnectRequest       = 0x0209
+	ServiceDisconnectResponse      = 0x020a
+	ServiceTunnellingRequest       = 0x0420
+	ServiceTunnellingAck           = 0x0421
+)
+
+// Status codes of responses and acks
+const (
+	StatusOK                = 0x00
+	StatusConnectionID      = 0x21
+	StatusConnectionType    = 0x22
+	StatusConnectionOption  = 0x23
+	StatusNoMoreConnections = 0x24
+	StatusDataConnection    = 0x26
+	StatusKNXConnection     = 0x27
+	StatusTunnellingLayer   = 0x29
+)
+
+// Connection request options for a link layer tunnel
+const (
+	tunnelConnection = 0x04
+	tunnelLinkLayer  = 0x02
+)
+
+var (
+	ErrShortFrame    = errors.New("knxnet/ip frame too short")
+	ErrSizeMismatch  = errors.New("knxnet/ip frame size does not match header")
+	ErrWrongProtocol = errors.New("not a knxnet/ip frame")
+	ErrStatus        = errors.New("knxnet/ip error status")
+)
+
+// StatusError is a non-zero status in a response.
+type StatusError struct {
+	Status byte
+}
+
+func (e *StatusError) Error() string {
+	switch e.Status {
+	case StatusConnectionID:
+		return "unknown connection id"
+	case StatusConnectionType:
+		return "connection type not supported"
+	case StatusConnectionOption:
+		return "connection option not supported"
+	case StatusNoMoreConnections:
+		return "no more connections"
+	case StatusDataConnection:
+		return "data connection error"
+	case StatusKNXConnection:
+		return "knx connection error"
+	case StatusTunnellingLayer:
+		return "tunnelling layer not supported"
+	}
+	return fmt.Sprintf("status %#02x", e.Status)
+}
+
+func (e *StatusError) Unwrap() error {
+	return ErrStatus
+}
+
+// Message is a frame body.
+type Message interface {
+	Service() uint16
+	appendBody(b []byte) []byte
+	decodeBody(b []byte) error
+}
+
+// Encode builds a frame for msg.
+func Encode(msg Message) []byte {
+	b := make([]byte, HeaderSize, 32)
+	b[0] = HeaderSize
+	b[1] = ProtocolVersion
+	binary.BigEndian.PutUint16(b[2:], msg.Service())
+	b = msg.appendBody(b)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(b)))
+	return b
+}
+
+// Decode parses a frame. Services this package doesn't know are returned as
+// *Unknown.
+func Decode(b []byte) (Message, error) {
+	if len(b) < HeaderSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrShortFrame, len(b))
+	}
+	if b[0] != HeaderSize || b[1] != ProtocolVersion {
+		return nil, fmt.Errorf("%w: header % x", ErrWrongProtocol, b[:2])
+	}
+	if size := int(binary.BigEndian.Uint16(b[4:])); size != len(b) {
+		return nil, fmt.Errorf("%w: header says %d, got %d", ErrSizeMismatch, size, len(b))
+	}
+
+	msg := newMessage(binary.BigEndian.Uint16(b[2:]))
+	if err := msg.decodeBody(b[HeaderSize:]); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func newMessage(service uint16) Message {
+	switch service {
+	case ServiceConnectRequest:
+		return &ConnectRequest{}
+	case ServiceConnectResponse:
+		return &ConnectResponse{}
+	case ServiceConnectionStateRequest, ServiceDisconnectRequest:
+		return &ChannelRequest{ServiceType: service}
+	case ServiceConnectionStateResponse, ServiceDisconnectResponse:
+		return &ChannelResponse{ServiceType: service}
+	case ServiceTunnellingRequest:
+		return &TunnellingRequest{}
+	case ServiceTunnellingAck:
+		return &TunnellingAck{}
+	}
+	return &Unknown{ServiceType: service}
+}
+
+// need checks that a body has at least n bytes.
+func need(b []byte, n int, service uint16) error {
+	if len(b) < n {
+		return fmt.Errorf("%w: service %#04x needs %d body bytes, got %d", ErrShortFrame, service, n, len(b))
+	}
+	return nil
+}
+
+// HPAI is a host address for replies. The zero HPAI asks the gateway to reply to
+// wherever the request came from, which works through NAT.
+type HPAI struct {
+	IP   [4]byte
+	Port uint16
+}
+
+const hpaiSize = 8
+
+func (h HPAI) append(b []byte) []byte {
+	b = append(b, hpaiSize, 0x01) // UDP
+	b = append(b, h.IP[:]...)
+	return binary.BigEndian.AppendUint16(b, h.Port)
+}
+
+func decodeHPAI(b []byte) HPAI {
+	var h HPAI
+	copy(h.IP[:], b[2:6])
+	h.Port = binary.BigEndian.Uint16(b[6:])
+	return h
+}
+
+// Unknown keeps the raw body of a service this package doesn't decode.
+type Unknown struct {
+	ServiceType uint16
+	Body        []byte
+}
+
+func (m *Unknown) Service() uint16            { return m.ServiceType }
+func (m *Unknown) appendBody(b []byte) []byte { return append(b, m.Body...) }
+func (m *Unknown) decodeBody(b []byte) error  { m.Body = append([]byte(nil), b...); return nil }
+
+// ConnectRequest opens a link layer tunnel.
+type ConnectRequest struct {
+	Control HPAI
+	Data    HPAI
+}
+
+func (m *ConnectRequest) Service() uint16 { return ServiceConnectRequest }
+
+func (m *ConnectRequest) appendBody(b []byte) []byte {
+	b = m.Control.append(b)
+	b = m.Data.append(b)
+	return append(b, 4, tunnelConnection, tunnelLinkLayer, 0)
+}
+
+func (m *ConnectRequest) decodeBody(b []byte) error {
+	if err := need(b, 2*hpaiSize+4, ServiceConnectRequest); err != nil {
+		return err
+	}
+	m.Control = decodeHPAI(b)
+	m.Data = decodeHPAI(b[hpaiSize:])
+	return nil
+}
+
+// ConnectResponse assigns the tunnel a channel and an individual address on the bus.
+// Only Channel and Status are set when the status is an error.
+type ConnectResponse struct {
+	Channel byte
+	Status  byte
+	Data    HPAI
+	Address IndividualAddress
+}
+
+func (m *ConnectResponse) Service() uint16 { return ServiceConnectResponse }
+
+func (m *ConnectResponse) appendBody(b []byte) []byte {
+	b = append(b, m.Channel, m.Status)
+	if m.Status != StatusOK {
+		return b
+	}
+	b = m.Data.append(b)
+	b = append(b, 4, tunnelConnection)
+	return binary.BigEndian.AppendUint16(b, uint16(m.Address))
+}
+
+func (m *ConnectResponse) decodeBody(b []byte) error {
+	if err := need(b, 2, ServiceConnectResponse); err != nil {
+		return err
+	}
+	m.Channel, m.Status = b[0], b[1]
+	if m.Status != StatusOK {
+		return nil
+	}
+	if err := need(b, 2+hpaiSize+4, ServiceConnectResponse); err != nil {
+		return err
+	}
+	m.Data = decodeHPAI(b[2:])
+	m.Address = IndividualAddress(binary.BigEndian.Uint16(b[2+hpaiSize+2:]))
+	return nil
+}
+
+// ChannelRequest is a connection state (heartbeat) or disconnect request.
+type ChannelRequest struct {
+	ServiceType uint16
+	Channel     byte
+	Control     HPAI
+}
+
+func (m *ChannelRequest) Service() uint16 { return m.ServiceType }
+
+func (m *ChannelRequest) appendBody(b []byte) []byte {
+	return m.Control.append(append(b, m.Channel, 0))
+}
+
+func (m *ChannelRequest) decodeBody(b []byte) error {
+	if err := need(b, 2+hpaiSize, m.ServiceType); err != nil {
+		return err
+	}
+	m.Channel = b[0]
+	m.Control = decodeHPAI(b[2:])
+	return nil
+}
+
+// ChannelResponse answers a ChannelRequest.
+type ChannelResponse struct {
+	ServiceType uint16
+	Channel     byte
+	Status      byte
+}
+
+func (m *ChannelResponse) Service() uint16 { return m.ServiceType }
+
+func (m *ChannelResponse) appendBody(b []byte) []byte {
+	return append(b, m.Channel, m.Status)
+}
+
+func (m *ChannelResponse) decodeBody(b []byte) error {
+	if err := need(b, 2, m.ServiceType); err != nil {
+		return err
+	}
+	m.Channel, m.Status = b[0], b[1]
+	return nil
+}
+
+// TunnellingRequest carries a telegram in either direction. Each side numbers its
+// requests, and the other acks every one.
+type TunnellingRequest struct {
+	Channel  byte
+	Sequence byte
+	CEMI     CEMI
+}
+
+func (m *TunnellingRequest) Service() uint16 { return ServiceTunnellingRequest }
+
+func (m *TunnellingRequest) appendBody(b []byte) []byte {
+	b = append(b, 4, m.Channel, m.Sequence, 0)
+	return m.CEMI.append(b)
+}
+
+func (m *TunnellingRequest) decodeBody(b []byte) error {
+	if err := need(b, 4, ServiceTunnellingRequest); err != nil {
+		return err
+	}
+	if err := need(b, int(b[0]), ServiceTunnellingRequest); err != nil {
+		return err
+	}
+	m.Channel, m.Sequence = b[1], b[2]
+	return m.CEMI.decode(b[b[0]:])
+}
+
+type TunnellingAck struct {
+	Channel  byte
+	Sequence byte
+	Status   byte
+}
+
+func (m *TunnellingAck) Service() uint16 { return ServiceTunnellingAck }
+
+func (m *TunnellingAck) appendBody(b []byte) []byte {
+	return append(b, 4, m.Channel, m.Sequence, m.Status)
+}
+
+func (m *TunnellingAck) decodeBody(b []byte) error {
+	if err := need(b, 4, ServiceTunnellingAck); err != nil {
+		return err
+	}
+	m.Channel, m.Sequence, m.Status = b[1], b[2], b[3]
+	return nil
+}
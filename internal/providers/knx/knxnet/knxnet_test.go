@@ -0,0 +1,131 @@
+package knxnet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseGroupAddress(t *testing.T) {
+	tests := map[string]GroupAddress{
+		"1/2/3":    0x0a03,
+		"31/7/255": 0xffff,
+		"1/515":    0x0a03,
+		"2563":     0x0a03,
+	}
+	for s, want := range tests {
+		got, err := ParseGroupAddress(s)
+		if err != nil {
+			t.Errorf("%s: ParseGroupAddress failed: %v", s, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%s: expected %#04x, got %#04x", s, want, got)
+		}
+	}
+	if s := GroupAddress(0x0a03).String(); s != "1/2/3" {
+		t.Errorf("Expected 1/2/3, got %s", s)
+	}
+
+	for _, s := range []string{"", "32/0/0", "1/8/0", "1/2/256", "1/2/3/4", "a/b/c"} {
+		if _, err := ParseGroupAddress(s); err == nil {
+			t.Errorf("Expected %q to be rejected", s)
+		}
+	}
+}
+
+func TestDPT(t *testing.T) {
+	tests := []struct {
+		dpt   string
+		value any
+		data  string
+		short bool
+	}{
+		{DPTSwitch, true, "01", true},
+		{DPTSwitch, false, "00", true},
+		{DPTScaling, 100, "ff", false},
+		{DPTScaling, 50, "80", false},
+		{"5.010", 42, "2a", false},
+		{DPTTemperature, 21.5, "0c33", false},
+		{DPTTemperature, -30.0, "8a24", false},
+		{DPTTemperature, 0.0, "0000", false},
+	}
+	for _, tt := range tests {
+		data, short, err := EncodeDPT(tt.dpt, tt.value)
+		if err != nil {
+			t.Errorf("%s %v: EncodeDPT failed: %v", tt.dpt, tt.value, err)
+			continue
+		}
+		if hex.EncodeToString(data) != tt.data || short != tt.short {
+			t.Errorf("%s %v: expected %s (short %v), got %x (short %v)", tt.dpt, tt.value, tt.data, tt.short, data, short)
+		}
+		got, err := DecodeDPT(tt.dpt, data)
+		if err != nil {
+			t.Errorf("%s %v: DecodeDPT failed: %v", tt.dpt, tt.value, err)
+		}
+		if got != tt.value {
+			t.Errorf("%s: expected %v back, got %v", tt.dpt, tt.value, got)
+		}
+	}
+
+	if _, _, err := EncodeDPT(DPTScaling, 101); !errors.Is(err, ErrDatapoint) {
+		t.Errorf("Expected ErrDatapoint above 100%%, got %v", err)
+	}
+	if _, _, err := EncodeDPT(DPTSwitch, 1); !errors.Is(err, ErrDatapoint) {
+		t.Errorf("Expected ErrDatapoint for a number as DPT 1, got %v", err)
+	}
+	if _, err := DecodeDPT(DPTTemperature, []byte{0x7f, 0xff}); !errors.Is(err, ErrDatapoint) {
+		t.Errorf("Expected ErrDatapoint for the invalid 2-byte float, got %v", err)
+	}
+}
+
+func TestEncode_GroupWrite(t *testing.T) {
+	msg := &TunnellingRequest{Channel: 7, Sequence: 3, CEMI: CEMI{
+		Code:        LDataReq,
+		Destination: MustParseGroupAddress("1/0/1"),
+		Group:       true,
+		APCI:        GroupValueWrite,
+		Data:        []byte{1},
+		Short:       true,
+	}}
+	got := Encode(msg)
+	want, _ := hex.DecodeString("0610" + "0420" + "0015" + "04070300" + "1100bce0" + "0000" + "0801" + "01" + "0081")
+	if !bytes.Equal(got, want) {
+		t.Errorf("Unexpected frame\n got % x\nwant % x", got, want)
+	}
+}
+
+func TestDecode_RoundTrip(t *testing.T) {
+	messages := []Message{
+		&ConnectRequest{},
+		&ConnectResponse{Channel: 1, Address: 0x11fa, Data: HPAI{IP: [4]byte{192, 168, 1, 50}, Port: 3671}},
+		&ConnectResponse{Channel: 0, Status: StatusNoMoreConnections},
+		&ChannelRequest{ServiceType: ServiceConnectionStateRequest, Channel: 1},
+		&ChannelResponse{ServiceType: ServiceDisconnectResponse, Channel: 1},
+		&TunnellingAck{Channel: 1, Sequence: 255},
+		&TunnellingRequest{Channel: 1, Sequence: 9, CEMI: CEMI{
+			Code: LDataInd, Source: 0x1105, Destination: 0x1801, Group: true,
+			APCI: GroupValueResponse, Data: []byte{0x0c, 0x33},
+		}},
+		&TunnellingRequest{Channel: 1, CEMI: CEMI{Code: LDataCon, Failed: true, Destination: 0x0801, Group: true, Data: []byte{0}, Short: true}},
+	}
+	for _, msg := range messages {
+		got, err := Decode(Encode(msg))
+		if err != nil {
+			t.Errorf("%T: Decode failed: %v", msg, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, msg) {
+			t.Errorf("%T: expected %+v, got %+v", msg, msg, got)
+		}
+	}
+
+	if _, err := Decode([]byte{0x06, 0x10, 0x04, 0x20, 0x00, 0x10}); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("Expected ErrSizeMismatch, got %v", err)
+	}
+	if _, err := Decode([]byte{0x06, 0x20, 0x04, 0x20, 0x00, 0x06}); !errors.Is(err, ErrWrongProtocol) {
+		t.Errorf("Expected ErrWrongProtocol, got %v", err)
+	}
+}
@@ -0,0 +1,246 @@
+// Package knxtest runs a fake KNXnet/IP tunnelling gateway for tests. It accepts one
+// tunnel at a time, acks and confirms its telegrams, and stands in for the bus behind
+// it: group values are stored, actuators report written values on their status
+// addresses, and reads are answered.
+package knxtest
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/legitlolly/SmartHomeHub/internal/providers/knx/knxnet"
+)
+
+// The address the gateway gives the tunnel
+const (
+	tunnelAddress = knxnet.IndividualAddress(0x11fa) // 1.1.250
+	deviceAddress = knxnet.IndividualAddress(0x1105) // 1.1.5, the sender of bus telegrams
+)
+
+type Gateway struct {
+	conn *net.UDPConn
+
+	mu        sync.Mutex
+	client    *net.UDPAddr
+	channel   byte
+	sendSeq   byte
+	recvSeq   byte
+	busy      bool
+	values    map[knxnet.GroupAddress]value
+	links     map[knxnet.GroupAddress]knxnet.GroupAddress
+	telegrams []knxnet.CEMI
+}
+
+type value struct {
+	data  []byte
+	short bool
+}
+
+// NewGateway starts a fake gateway. It is closed when the test ends.
+func NewGateway(t testing.TB) *Gateway {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("knxtest: listen failed: %v", err)
+	}
+	g := &Gateway{
+		conn:   conn,
+		values: make(map[knxnet.GroupAddress]value),
+		links:  make(map[knxnet.GroupAddress]knxnet.GroupAddress),
+	}
+	go g.serve()
+	t.Cleanup(func() { conn.Close() })
+	return g
+}
+
+// Address is host:port, for the provider's gateway setting.
+func (g *Gateway) Address() string {
+	return g.conn.LocalAddr().String()
+}
+
+// Link makes an actuator on the bus: values written to address are stored and
+// reported on statusAddress.
+func (g *Gateway) Link(address, statusAddress string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.links[knxnet.MustParseGroupAddress(address)] = knxnet.MustParseGroupAddress(statusAddress)
+}
+
+// SetBusy makes the gateway refuse new tunnels, as one with all its tunnels in use
+// does.
+func (g *Gateway) SetBusy(busy bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.busy = busy
+}
+
+// SetValue stores a value without telling the tunnel, as if it had been sent while
+// the tunnel was down; it is returned when read.
+func (g *Gateway) SetValue(address, dpt string, v any) {
+	data, short, err := knxnet.EncodeDPT(dpt, v)
+	if err != nil {
+		panic(err)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[knxnet.MustParseGroupAddress(address)] = value{data, short}
+}
+
+// Send puts a group write on the bus, as a sensor or wall switch would.
+func (g *Gateway) Send(address, dpt string, v any) {
+	g.SetValue(address, dpt, v)
+	a := knxnet.MustParseGroupAddress(address)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.indicate(a, knxnet.GroupValueWrite)
+}
+
+// Value decodes the value last written to address, or returns nil.
+func (g *Gateway) Value(address, dpt string) any {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	v, ok := g.values[knxnet.MustParseGroupAddress(address)]
+	if !ok {
+		return nil
+	}
+	decoded, _ := knxnet.DecodeDPT(dpt, v.data)
+	return decoded
+}
+
+// Telegrams returns the telegrams the tunnel has sent, in order.
+func (g *Gateway) Telegrams() []knxnet.CEMI {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]knxnet.CEMI(nil), g.telegrams...)
+}
+
+// Disconnect ends the tunnel with a disconnect request, as a gateway restarting
+// does.
+func (g *Gateway) Disconnect() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.client == nil {
+		return
+	}
+	g.write(&knxnet.ChannelRequest{ServiceType: knxnet.ServiceDisconnectRequest, Channel: g.channel})
+	g.client = nil
+}
+
+func (g *Gateway) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := g.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		msg, err := knxnet.Decode(buf[:n])
+		if err != nil {
+			continue
+		}
+		g.handle(msg, addr)
+	}
+}
+
+func (g *Gateway) handle(msg knxnet.Message, addr *net.UDPAddr) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch msg := msg.(type) {
+	case *knxnet.ConnectRequest:
+		if g.busy || (g.client != nil && g.client.String() != addr.String()) {
+			g.reply(addr, &knxnet.ConnectResponse{Status: knxnet.StatusNoMoreConnections})
+			return
+		}
+		g.client = addr
+		g.channel++
+		g.sendSeq, g.recvSeq = 0, 0
+		g.write(&knxnet.ConnectResponse{Channel: g.channel, Address: tunnelAddress})
+
+	case *knxnet.ChannelRequest:
+		status := byte(knxnet.StatusOK)
+		if g.client == nil || msg.Channel != g.channel {
+			status = knxnet.StatusConnectionID
+		}
+		switch msg.Service() {
+		case knxnet.ServiceConnectionStateRequest:
+			g.reply(addr, &knxnet.ChannelResponse{ServiceType: knxnet.ServiceConnectionStateResponse, Channel: msg.Channel, Status: status})
+		case knxnet.ServiceDisconnectRequest:
+			g.reply(addr, &knxnet.ChannelResponse{ServiceType: knxnet.ServiceDisconnectResponse, Channel: msg.Channel, Status: status})
+			if status == knxnet.StatusOK {
+				g.client = nil
+			}
+		}
+
+	case *knxnet.TunnellingRequest:
+		if g.client == nil || msg.Channel != g.channel {
+			return
+		}
+		g.write(&knxnet.TunnellingAck{Channel: g.channel, Sequence: msg.Sequence})
+		if msg.Sequence != g.recvSeq {
+			return
+		}
+		g.recvSeq++
+		g.telegrams = append(g.telegrams, msg.CEMI)
+
+		con := msg.CEMI
+		con.Code = knxnet.LDataCon
+		con.Source = tunnelAddress
+		g.tunnel(con)
+		g.bus(msg.CEMI)
+	}
+}
+
+// bus carries out a telegram from the tunnel. Must be called with mu held.
+func (g *Gateway) bus(cemi knxnet.CEMI) {
+	switch cemi.APCI {
+	case knxnet.GroupValueWrite:
+		v := value{append([]byte(nil), cemi.Data...), cemi.Short}
+		g.values[cemi.Destination] = v
+		if status, ok := g.links[cemi.Destination]; ok {
+			g.values[status] = v
+			g.indicate(status, knxnet.GroupValueWrite)
+		}
+	case knxnet.GroupValueRead:
+		if _, ok := g.values[cemi.Destination]; ok {
+			g.indicate(cemi.Destination, knxnet.GroupValueResponse)
+		}
+	}
+}
+
+// indicate sends the tunnel a telegram with the value of address. Must be called
+// with mu held.
+func (g *Gateway) indicate(address knxnet.GroupAddress, apci uint16) {
+	v := g.values[address]
+	g.tunnel(knxnet.CEMI{
+		Code:        knxnet.LDataInd,
+		Source:      deviceAddress,
+		Destination: address,
+		Group:       true,
+		APCI:        apci,
+		Data:        v.data,
+		Short:       v.short,
+	})
+}
+
+// tunnel sends the client a tunnelling request. The fake doesn't wait for acks.
+// Must be called with mu held.
+func (g *Gateway) tunnel(cemi knxnet.CEMI) {
+	if g.client == nil {
+		return
+	}
+	g.write(&knxnet.TunnellingRequest{Channel: g.channel, Sequence: g.sendSeq, CEMI: cemi})
+	g.sendSeq++
+}
+
+// write sends msg to the client. Must be called with mu held.
+func (g *Gateway) write(msg knxnet.Message) {
+	if g.client != nil {
+		g.reply(g.client, msg)
+	}
+}
+
+func (g *Gateway) reply(addr *net.UDPAddr, msg knxnet.Message) {
+	g.conn.WriteToUDP(knxnet.Encode(msg), addr)
+}
@@ -0,0 +1,60 @@
+package knx
+
+import (
+	"context"
+	"log"
+
+	"github.com/legitlolly/SmartHomeHub/internal/providers/knx/knxnet"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+// listen keeps the tunnel open until ctx is cancelled, starting from the result of
+// the first connect. After every connect the current values are read, since the bus
+// doesn't repeat what happened while the tunnel was down.
+func (p *Provider) listen(ctx context.Context, closed <-chan struct{}, err error) {
+	first := true
+	resilience.Reconnect(ctx, "knx: "+p.tunnel.address, func(ctx context.Context) (bool, error) {
+		if !first {
+			closed, err = p.connect(ctx)
+		}
+		first = false
+		if err != nil {
+			return false, err
+		}
+		p.readAll(ctx)
+		err := p.wait(ctx, closed)
+		p.setConnected(false)
+		return true, err
+	})
+}
+
+func (p *Provider) wait(ctx context.Context, closed <-chan struct{}) error {
+	select {
+	case <-closed:
+		return ErrDisconnected
+	case <-ctx.Done():
+		p.tunnel.Close()
+		return ctx.Err()
+	}
+}
+
+// readAll sends a group read to every state address. The answers arrive as group
+// responses; actuators without the read flag set on an address just don't answer.
+func (p *Provider) readAll(ctx context.Context) {
+	seen := make(map[knxnet.GroupAddress]bool)
+	for _, d := range p.devices {
+		for _, dp := range d.config.Datapoints {
+			if seen[dp.stateAddress] {
+				continue
+			}
+			seen[dp.stateAddress] = true
+			err := p.tunnel.Send(ctx, knxnet.CEMI{Destination: dp.stateAddress, APCI: knxnet.GroupValueRead})
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("knx: reading %s failed: %v", dp.stateAddress, err)
+				}
+				return
+			}
+		}
+	}
+}
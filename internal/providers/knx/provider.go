@@ -0,0 +1,279 @@
+// Package knx integrates KNX installations through a KNXnet/IP tunnelling gateway or
+// router. Devices are declared in the config as datapoints on group addresses; the
+// values seen on the bus become attributes, and writable datapoints become commands
+// sent as group writes.
+package knx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/knx/knxnet"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+func init() {
+	provider.RegisterFactory("knx", NewProvider)
+}
+
+type Settings struct {
+	// Gateway is the tunnelling server: host[:port], port 3671 by default
+	Gateway    string              `json:"gateway"`
+	Devices    []DeviceConfig      `json:"devices"`
+	Resilience resilience.Settings `json:"resilience"`
+}
+
+type DeviceConfig struct {
+	// ID makes the device ID knx-<id>
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// Type is the hub device type, "sensor" by default
+	Type       string      `json:"type,omitempty"`
+	Datapoints []Datapoint `json:"datapoints"`
+}
+
+// validate checks the device and its datapoints, filling in defaults.
+func (c *DeviceConfig) validate() error {
+	if c.ID == "" {
+		return fmt.Errorf("%w: devices need an id", ErrInvalidDatapoint)
+	}
+	c.ID = "knx-" + c.ID
+	if c.Type == "" {
+		c.Type = "sensor"
+	}
+	if len(c.Datapoints) == 0 {
+		return fmt.Errorf("%w: %s has no datapoints", ErrInvalidDatapoint, c.ID)
+	}
+
+	names := make(map[string]bool)
+	commands := make(map[string]bool)
+	for i := range c.Datapoints {
+		dp := &c.Datapoints[i]
+		if err := dp.validate(); err != nil {
+			return fmt.Errorf("%s: %w", c.ID, err)
+		}
+		if names[dp.Name] {
+			return fmt.Errorf("%w: %s: duplicate datapoint %s", ErrInvalidDatapoint, c.ID, dp.Name)
+		}
+		names[dp.Name] = true
+		if dp.Command != "" {
+			if commands[dp.Command] {
+				return fmt.Errorf("%w: %s: duplicate command %s", ErrInvalidDatapoint, c.ID, dp.Command)
+			}
+			commands[dp.Command] = true
+		}
+	}
+	return nil
+}
+
+// binding is a datapoint listening on a group address.
+type binding struct {
+	device    *Device
+	datapoint *Datapoint
+}
+
+type Provider struct {
+	name     string
+	settings Settings
+	guards   *resilience.Registry
+	tunnel   *Tunnel
+	guard    *resilience.Guard
+	devices  []*Device
+	bindings map[knxnet.GroupAddress][]binding
+
+	mu        sync.Mutex
+	registry  provider.Registry
+	listening bool
+	connected bool
+	cancel    context.CancelFunc
+	listenCtx context.Context
+	wg        sync.WaitGroup
+}
+
+func NewProvider(name string, raw json.RawMessage, env provider.Env) (provider.Provider, error) {
+	var settings Settings
+	if err := provider.DecodeSettings(raw, &settings); err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool)
+	for i := range settings.Devices {
+		cfg := &settings.Devices[i]
+		if err := cfg.validate(); err != nil {
+			return nil, err
+		}
+		if ids[cfg.ID] {
+			return nil, fmt.Errorf("%w: duplicate device %s", ErrInvalidDatapoint, cfg.ID)
+		}
+		ids[cfg.ID] = true
+	}
+
+	guards := env.Guards
+	if guards == nil {
+		guards = resilience.NewRegistry()
+	}
+	guards.Configure(name, settings.Resilience.Apply(resilience.DefaultConfig()), ClassifyError)
+
+	p := &Provider{
+		name:     name,
+		settings: settings,
+		guards:   guards,
+		bindings: make(map[knxnet.GroupAddress][]binding),
+	}
+	p.tunnel = NewTunnel(settings.Gateway, p.handleTelegram)
+	p.guard = guards.Guard(name, p.tunnel.address)
+	for _, cfg := range settings.Devices {
+		d := newDevice(cfg, p.tunnel, p.guard)
+		p.devices = append(p.devices, d)
+		for i := range d.config.Datapoints {
+			dp := &d.config.Datapoints[i]
+			p.bindings[dp.address] = append(p.bindings[dp.address], binding{d, dp})
+			if dp.stateAddress != dp.address {
+				p.bindings[dp.stateAddress] = append(p.bindings[dp.stateAddress], binding{d, dp})
+			}
+		}
+	}
+	return p, nil
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+func (p *Provider) Start(ctx context.Context, registry provider.Registry) error {
+	if p.settings.Gateway == "" {
+		return fmt.Errorf("%w: no gateway", provider.ErrNotConfigured)
+	}
+	if len(p.devices) == 0 {
+		return fmt.Errorf("%w: no devices", provider.ErrNotConfigured)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.registry = registry
+	p.listenCtx, p.cancel = context.WithCancel(context.Background())
+	return nil
+}
+
+// Discover opens the tunnel and registers the configured devices, then keeps the
+// tunnel open, reading the current values on every connect.
+func (p *Provider) Discover(ctx context.Context) error {
+	p.mu.Lock()
+	listening := p.listening
+	p.mu.Unlock()
+	if listening {
+		return nil
+	}
+
+	closed, err := p.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.listening || p.cancel == nil {
+		return nil
+	}
+	for _, d := range p.devices {
+		if err := p.registry.Register(d); err != nil {
+			if !errors.Is(err, device.ErrDeviceAlreadyRegistered) {
+				log.Printf("knx: failed to register %s: %v", d.id, err)
+			}
+			continue
+		}
+		log.Printf("knx: registered %s (%d datapoints)", d.id, len(d.config.Datapoints))
+	}
+
+	p.listening = true
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.listen(p.listenCtx, closed, nil)
+	}()
+	return nil
+}
+
+func (p *Provider) connect(ctx context.Context) (<-chan struct{}, error) {
+	var closed <-chan struct{}
+	err := p.guard.Do(ctx, func(ctx context.Context) error {
+		var err error
+		closed, err = p.tunnel.Connect(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.setConnected(true)
+	return closed, nil
+}
+
+// handleTelegram applies group writes and read responses to the datapoints on the
+// address. It runs on the tunnel's reader.
+func (p *Provider) handleTelegram(cemi knxnet.CEMI) {
+	if cemi.APCI != knxnet.GroupValueWrite && cemi.APCI != knxnet.GroupValueResponse {
+		return
+	}
+	for _, b := range p.bindings[cemi.Destination] {
+		v, err := b.datapoint.decode(cemi.Data)
+		if err != nil {
+			log.Printf("knx: %s: %s on %s: %v", b.device.id, b.datapoint.Name, cemi.Destination, err)
+			continue
+		}
+		b.device.apply(b.datapoint, v)
+	}
+}
+
+func (p *Provider) setConnected(connected bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.connected = connected
+	for _, d := range p.devices {
+		d.setConnected(connected)
+	}
+}
+
+func (p *Provider) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	cancel := p.cancel
+	p.cancel = nil
+	if p.listening {
+		for _, d := range p.devices {
+			p.registry.Unregister(d.id)
+		}
+	}
+	p.listening = false
+	p.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		p.wg.Wait()
+	}
+	p.tunnel.Close()
+	return nil
+}
+
+// Health reports the breaker state of the gateway, and degraded while the tunnel is
+// down.
+func (p *Provider) Health(ctx context.Context) provider.Health {
+	p.mu.Lock()
+	connected := p.connected
+	p.mu.Unlock()
+
+	health := provider.Health{Status: provider.HealthOK}
+	if guards, ok := p.guards.ProviderHealth(p.name); ok {
+		health.Status = guards.Status
+		health.Details = guards
+	}
+	if health.Status == provider.HealthOK && !connected {
+		health.Status = provider.HealthDegraded
+		health.Message = fmt.Sprintf("no tunnel to %s", p.tunnel.address)
+	}
+	return health
+}
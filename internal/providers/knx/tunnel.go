@@ -0,0 +1,355 @@
+package knx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/providers/knx/knxnet"
+)
+
+const (
+	connectTimeout = 5 * time.Second
+
+	// A tunnelling request is resent once if its ack doesn't come within a second;
+	// after that the tunnel is considered broken.
+	ackTimeout = time.Second
+	// The gateway confirms each telegram once it is on the bus
+	confirmTimeout = 3 * time.Second
+
+	// Gateways drop tunnels that haven't been heard from for two minutes
+	heartbeatInterval = 60 * time.Second
+	heartbeatTimeout  = 10 * time.Second
+	heartbeatAttempts = 3
+)
+
+var (
+	ErrDisconnected = errors.New("knx tunnel closed")
+	ErrNoAck        = errors.New("knx gateway did not acknowledge")
+	ErrNotConfirmed = errors.New("knx telegram not confirmed")
+)
+
+// Tunnel is a KNXnet/IP tunnelling connection to a gateway. Telegrams from the bus are
+// passed to onTelegram on the reader goroutine.
+type Tunnel struct {
+	address    string
+	onTelegram func(knxnet.CEMI)
+
+	// One request at a time: the protocol allows no more than one unacked request
+	sendMu sync.Mutex
+
+	mu       sync.Mutex
+	conn     *net.UDPConn
+	channel  byte
+	sendSeq  byte
+	recvSeq  byte
+	acks     chan *knxnet.TunnellingAck
+	confirms chan knxnet.CEMI
+	states   chan *knxnet.ChannelResponse
+	closed   chan struct{}
+}
+
+// NewTunnel creates a tunnel to a gateway at host[:port].
+func NewTunnel(address string, onTelegram func(knxnet.CEMI)) *Tunnel {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, strconv.Itoa(knxnet.DefaultPort))
+	}
+	return &Tunnel{address: address, onTelegram: onTelegram}
+}
+
+// Connect opens a new tunnel, replacing any existing one. The returned channel is
+// closed when the tunnel is.
+func (t *Tunnel) Connect(ctx context.Context) (<-chan struct{}, error) {
+	t.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+
+	raddr, err := net.ResolveUDPAddr("udp", t.address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetReadDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	// Zero addresses: reply to wherever this comes from
+	if _, err := conn.Write(knxnet.Encode(&knxnet.ConnectRequest{})); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := readConnectResponse(conn)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	if resp.Status != knxnet.StatusOK {
+		conn.Close()
+		return nil, &knxnet.StatusError{Status: resp.Status}
+	}
+	if !stop() {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	closed := make(chan struct{})
+	t.mu.Lock()
+	t.conn = conn
+	t.channel = resp.Channel
+	t.sendSeq, t.recvSeq = 0, 0
+	t.acks = make(chan *knxnet.TunnellingAck, 1)
+	t.confirms = make(chan knxnet.CEMI, 8)
+	t.states = make(chan *knxnet.ChannelResponse, 1)
+	t.closed = closed
+	t.mu.Unlock()
+
+	go t.read(conn, closed)
+	go t.heartbeat(conn, closed)
+	return closed, nil
+}
+
+func readConnectResponse(conn *net.UDPConn) (*knxnet.ConnectResponse, error) {
+	buf := make([]byte, 512)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		msg, err := knxnet.Decode(buf[:n])
+		if err != nil {
+			continue
+		}
+		if resp, ok := msg.(*knxnet.ConnectResponse); ok {
+			return resp, nil
+		}
+	}
+}
+
+func (t *Tunnel) read(conn *net.UDPConn, closed chan struct{}) {
+	defer func() {
+		conn.Close()
+		t.mu.Lock()
+		if t.conn == conn {
+			t.conn = nil
+		}
+		t.mu.Unlock()
+		close(closed)
+	}()
+
+	buf := make([]byte, 512)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		msg, err := knxnet.Decode(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		t.mu.Lock()
+		channel := t.channel
+		t.mu.Unlock()
+
+		switch msg := msg.(type) {
+		case *knxnet.TunnellingRequest:
+			if msg.Channel != channel {
+				continue
+			}
+			conn.Write(knxnet.Encode(&knxnet.TunnellingAck{Channel: channel, Sequence: msg.Sequence}))
+			if !t.nextRecv(msg.Sequence) {
+				// A repeat of a request whose ack got lost
+				continue
+			}
+			switch msg.CEMI.Code {
+			case knxnet.LDataCon:
+				select {
+				case t.confirms <- msg.CEMI:
+				default:
+				}
+			case knxnet.LDataInd:
+				if msg.CEMI.Group {
+					t.onTelegram(msg.CEMI)
+				}
+			}
+
+		case *knxnet.TunnellingAck:
+			if msg.Channel == channel {
+				select {
+				case t.acks <- msg:
+				default:
+				}
+			}
+
+		case *knxnet.ChannelResponse:
+			if msg.Service() == knxnet.ServiceConnectionStateResponse && msg.Channel == channel {
+				select {
+				case t.states <- msg:
+				default:
+				}
+			}
+
+		case *knxnet.ChannelRequest:
+			if msg.Service() == knxnet.ServiceDisconnectRequest && msg.Channel == channel {
+				conn.Write(knxnet.Encode(&knxnet.ChannelResponse{ServiceType: knxnet.ServiceDisconnectResponse, Channel: channel}))
+				return
+			}
+		}
+	}
+}
+
+// nextRecv reports whether seq is the next request from the gateway, and if so
+// expects the one after it.
+func (t *Tunnel) nextRecv(seq byte) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if seq != t.recvSeq {
+		return false
+	}
+	t.recvSeq++
+	return true
+}
+
+// heartbeat checks the connection state regularly, closing the tunnel when the
+// gateway stops answering or no longer knows the channel.
+func (t *Tunnel) heartbeat(conn *net.UDPConn, closed chan struct{}) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
+		if !t.checkState(conn, closed) {
+			conn.Close()
+			return
+		}
+	}
+}
+
+func (t *Tunnel) checkState(conn *net.UDPConn, closed chan struct{}) bool {
+	t.mu.Lock()
+	channel, states := t.channel, t.states
+	t.mu.Unlock()
+
+	for range heartbeatAttempts {
+		conn.Write(knxnet.Encode(&knxnet.ChannelRequest{ServiceType: knxnet.ServiceConnectionStateRequest, Channel: channel}))
+		select {
+		case <-closed:
+			return false
+		case resp := <-states:
+			return resp.Status == knxnet.StatusOK
+		case <-time.After(heartbeatTimeout):
+		}
+	}
+	return false
+}
+
+// Send puts a telegram on the bus, waiting for the gateway's ack and confirmation.
+func (t *Tunnel) Send(ctx context.Context, cemi knxnet.CEMI) error {
+	t.sendMu.Lock()
+	defer t.sendMu.Unlock()
+
+	t.mu.Lock()
+	conn, channel, seq := t.conn, t.channel, t.sendSeq
+	acks, confirms, closed := t.acks, t.confirms, t.closed
+	t.mu.Unlock()
+	if conn == nil {
+		return ErrDisconnected
+	}
+
+	// Confirmations of earlier telegrams that timed out
+	for len(confirms) > 0 {
+		<-confirms
+	}
+
+	cemi.Code = knxnet.LDataReq
+	cemi.Group = true
+	frame := knxnet.Encode(&knxnet.TunnellingRequest{Channel: channel, Sequence: seq, CEMI: cemi})
+	acked := false
+	for attempt := 0; attempt < 2 && !acked; attempt++ {
+		if _, err := conn.Write(frame); err != nil {
+			return err
+		}
+		timer := time.NewTimer(ackTimeout)
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-closed:
+				timer.Stop()
+				return ErrDisconnected
+			case <-timer.C:
+				break wait
+			case ack := <-acks:
+				if ack.Sequence != seq {
+					continue
+				}
+				timer.Stop()
+				if ack.Status != knxnet.StatusOK {
+					return &knxnet.StatusError{Status: ack.Status}
+				}
+				acked = true
+				break wait
+			}
+		}
+	}
+	if !acked {
+		// The gateway is gone or out of step; start over with a new tunnel
+		conn.Close()
+		return ErrNoAck
+	}
+
+	t.mu.Lock()
+	t.sendSeq++
+	t.mu.Unlock()
+
+	timer := time.NewTimer(confirmTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-closed:
+			return ErrDisconnected
+		case <-timer.C:
+			return fmt.Errorf("%w: %s", ErrNotConfirmed, cemi.Destination)
+		case con := <-confirms:
+			if con.Destination != cemi.Destination || con.APCI != cemi.APCI {
+				continue
+			}
+			if con.Failed {
+				return fmt.Errorf("%w: %s", ErrNotConfirmed, cemi.Destination)
+			}
+			return nil
+		}
+	}
+}
+
+// Close disconnects from the gateway.
+func (t *Tunnel) Close() {
+	t.mu.Lock()
+	conn, channel := t.conn, t.channel
+	t.conn = nil
+	t.mu.Unlock()
+	if conn != nil {
+		conn.Write(knxnet.Encode(&knxnet.ChannelRequest{ServiceType: knxnet.ServiceDisconnectRequest, Channel: channel}))
+		conn.Close()
+	}
+}
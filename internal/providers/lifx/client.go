@@ -0,0 +1,142 @@
+package lifx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/providers/lifx/lan"
+)
+
+var ErrUnexpectedReply = errors.New("unexpected lifx reply")
+
+// resendInterval is how long to wait for a reply before sending a request again;
+// UDP packets to bulbs on busy Wi-Fi do get lost.
+const resendInterval = 300 * time.Millisecond
+
+// Client talks to one bulb. Requests are serialized, each on its own socket, and
+// matched to replies by source and sequence number.
+type Client struct {
+	address string
+	target  lan.Target
+	source  uint32
+	timeout time.Duration
+
+	mu  sync.Mutex
+	seq uint8
+}
+
+// NewClient creates a client for the bulb with target at address, which is a host
+// or host:port.
+func NewClient(address string, target lan.Target) *Client {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, fmt.Sprint(lan.DefaultPort))
+	}
+	return &Client{
+		address: address,
+		target:  target,
+		source:  newSource(),
+		timeout: 3 * time.Second,
+	}
+}
+
+// newSource picks a client ID. Zero makes bulbs broadcast their replies.
+func newSource() uint32 {
+	return rand.Uint32N(1<<32-2) + 2
+}
+
+// Request sends msg with res_required and returns the reply, which must be of type
+// want.
+func (c *Client) Request(ctx context.Context, msg lan.Message, want uint16) (lan.Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "udp4", c.address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	c.seq++
+	// A zero target (a host whose MAC isn't known yet) must be tagged
+	h := lan.Header{Tagged: c.target == lan.Target{}, Source: c.source, Target: c.target, ResRequired: true, Sequence: c.seq}
+	packet := lan.Encode(h, msg)
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	buf := make([]byte, 1024)
+	for {
+		if _, err := conn.Write(packet); err != nil {
+			return nil, err
+		}
+		resend := time.Now().Add(resendInterval)
+		if resend.After(deadline) {
+			resend = deadline
+		}
+		conn.SetReadDeadline(resend)
+
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() && ctx.Err() == nil && time.Now().Before(deadline) {
+					break // resend
+				}
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				// The read deadline can go off just before the context's own
+				if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+					return nil, context.DeadlineExceeded
+				}
+				return nil, err
+			}
+
+			rh, reply, err := lan.Decode(buf[:n])
+			if err != nil || rh.Source != c.source || rh.Sequence != c.seq {
+				continue // stray or late packet
+			}
+			if rh.Type != want {
+				return nil, fmt.Errorf("%w: expected type %d, got %d", ErrUnexpectedReply, want, rh.Type)
+			}
+			return reply, nil
+		}
+	}
+}
+
+// LightState fetches the color, power and label.
+func (c *Client) LightState(ctx context.Context) (lan.LightState, error) {
+	reply, err := c.Request(ctx, &lan.Empty{MessageType: lan.TypeLightGet}, lan.TypeLightState)
+	if err != nil {
+		return lan.LightState{}, err
+	}
+	return *reply.(*lan.LightState), nil
+}
+
+// SetPower switches the bulb on or off over duration.
+func (c *Client) SetPower(ctx context.Context, on bool, duration time.Duration) error {
+	msg := &lan.LightSetPower{Duration: uint32(duration.Milliseconds())}
+	if on {
+		msg.Level = 65535
+	}
+	_, err := c.Request(ctx, msg, lan.TypeLightStatePower)
+	return err
+}
+
+// SetColor changes the color over duration. The reply carries the state from
+// before the change, so it isn't returned.
+func (c *Client) SetColor(ctx context.Context, color lan.HSBK, duration time.Duration) error {
+	msg := &lan.LightSetColor{Color: color, Duration: uint32(duration.Milliseconds())}
+	_, err := c.Request(ctx, msg, lan.TypeLightState)
+	return err
+}
@@ -0,0 +1,194 @@
+package lifx
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/lifx/lan"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+// Kelvin range shared by current LIFX bulbs
+const (
+	minKelvin = 1500
+	maxKelvin = 9000
+)
+
+// Bulb is a LIFX light. State is read from the bulb on every call, like Hue lights;
+// the last known color is kept so brightness and color can be changed separately.
+type Bulb struct {
+	id     device.ID
+	client *Client
+	guard  *resilience.Guard
+
+	mu        sync.RWMutex
+	light     lan.LightState
+	updatedAt time.Time
+}
+
+func newBulb(found Discovered, light lan.LightState, guard *resilience.Guard) *Bulb {
+	return &Bulb{
+		id:        deviceID(found.Target),
+		client:    NewClient(found.Address, found.Target),
+		guard:     guard,
+		light:     light,
+		updatedAt: time.Now(),
+	}
+}
+
+func deviceID(target lan.Target) device.ID {
+	return device.ID("lifx-" + strings.ReplaceAll(target.String(), ":", ""))
+}
+
+func (b *Bulb) ID() device.ID {
+	return b.id
+}
+
+func (b *Bulb) Execute(ctx context.Context, cmd device.Command) error {
+	b.mu.RLock()
+	color := b.light.Color
+	b.mu.RUnlock()
+
+	// Every command other than turn_off leaves the bulb on; the set_ commands send
+	// the color first
+	on, setColor := true, false
+
+	switch cmd.Action {
+	case "turn_on":
+	case "turn_off":
+		on = false
+
+	case "set_brightness":
+		v, err := device.NumberParam(cmd.Params, "value", 0, 100)
+		if err != nil {
+			return err
+		}
+		if v == 0 {
+			on = false
+			break
+		}
+		color.Brightness = scaleTo16(v, 100)
+		setColor = true
+
+	case "set_color":
+		h, err := device.NumberParam(cmd.Params, "hue", 0, 360)
+		if err != nil {
+			return err
+		}
+		s, err := device.NumberParam(cmd.Params, "saturation", 0, 100)
+		if err != nil {
+			return err
+		}
+		color.Hue = uint16(math.Round(math.Mod(h, 360) / 360 * 65536))
+		color.Saturation = scaleTo16(s, 100)
+		setColor = true
+
+	case "set_color_temperature":
+		v, err := device.NumberParam(cmd.Params, "value", minKelvin, maxKelvin)
+		if err != nil {
+			return err
+		}
+		color.Kelvin = uint16(v)
+		color.Saturation = 0 // white
+		setColor = true
+
+	default:
+		return fmt.Errorf("%w: %s", device.ErrUnknownCommand, cmd.Action)
+	}
+
+	if color.Brightness == 0 && setColor {
+		// Turning on at zero brightness would look like nothing happened
+		color.Brightness = 65535
+	}
+
+	err := b.guard.Do(ctx, func(ctx context.Context) error {
+		if setColor {
+			if err := b.client.SetColor(ctx, color, 0); err != nil {
+				return err
+			}
+		}
+		return b.client.SetPower(ctx, on, 0)
+	})
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.light.Color = color
+	b.light.Power = 0
+	if on {
+		b.light.Power = 65535
+	}
+	b.updatedAt = time.Now()
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *Bulb) State(ctx context.Context) (device.State, error) {
+	var light lan.LightState
+	err := b.guard.Do(ctx, func(ctx context.Context) error {
+		var err error
+		light, err = b.client.LightState(ctx)
+		return err
+	})
+	if err != nil {
+		b.mu.RLock()
+		known, updatedAt := b.light, b.updatedAt
+		b.mu.RUnlock()
+
+		state := device.State{
+			DeviceType: "light",
+			UpdatedAt:  updatedAt,
+			Attributes: map[string]interface{}{
+				"power": "unknown",
+				"name":  known.Label,
+				"error": err.Error(),
+			},
+		}
+		return state, resilience.Unavailable(err)
+	}
+
+	b.mu.Lock()
+	b.light = light
+	b.updatedAt = time.Now()
+	updatedAt := b.updatedAt
+	b.mu.Unlock()
+
+	return device.State{
+		DeviceType: "light",
+		UpdatedAt:  updatedAt,
+		Attributes: attributes(light),
+	}, nil
+}
+
+func attributes(light lan.LightState) map[string]interface{} {
+	attributes := map[string]interface{}{
+		"power":      "off",
+		"name":       light.Label,
+		"brightness": scaleFrom16(light.Color.Brightness, 100),
+	}
+	if light.Power > 0 {
+		attributes["power"] = "on"
+	}
+	// Kelvin only shows at zero saturation; otherwise the bulb is in color mode
+	if light.Color.Saturation == 0 {
+		attributes["color_temperature"] = int(light.Color.Kelvin)
+	} else {
+		attributes["hue"] = scaleFrom16(light.Color.Hue, 360) % 360
+		attributes["saturation"] = scaleFrom16(light.Color.Saturation, 100)
+	}
+	return attributes
+}
+
+func scaleTo16(v, max float64) uint16 {
+	return uint16(math.Round(v / max * 65535))
+}
+
+func scaleFrom16(v uint16, max float64) int {
+	return int(math.Round(float64(v) / 65535 * max))
+}
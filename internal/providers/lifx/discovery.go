@@ -0,0 +1,72 @@
+package lifx
+
+import (
+	"context"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/providers/lifx/lan"
+)
+
+// Discovered is a bulb that answered GetService.
+type Discovered struct {
+	// Address is the reply's source IP with the advertised UDP port
+	Address string
+	Target  lan.Target
+}
+
+// Discover sends a tagged GetService to target, normally 255.255.255.255:56700 but
+// a single bulb's address works too, and collects the replies that arrive within
+// timeout.
+func Discover(ctx context.Context, target string, timeout time.Duration) ([]Discovered, error) {
+	addr, err := net.ResolveUDPAddr("udp4", target)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	source := newSource()
+	seq := uint8(rand.N(256))
+	req := lan.Encode(lan.Header{Tagged: true, Source: source, ResRequired: true, Sequence: seq}, &lan.Empty{MessageType: lan.TypeGetService})
+	if _, err := conn.WriteToUDP(req, addr); err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	seen := make(map[lan.Target]bool)
+	var found []Discovered
+	buf := make([]byte, 1024)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// The deadline ends discovery; whatever arrived until then is the result
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return found, ctx.Err()
+			}
+			return found, err
+		}
+
+		h, msg, err := lan.Decode(buf[:n])
+		if err != nil || h.Source != source || h.Sequence != seq {
+			continue
+		}
+		service, ok := msg.(*lan.StateService)
+		if !ok || service.Service != lan.ServiceUDP || seen[h.Target] {
+			continue
+		}
+		seen[h.Target] = true
+		found = append(found, Discovered{
+			Address: net.JoinHostPort(from.IP.String(), strconv.Itoa(int(service.Port))),
+			Target:  h.Target,
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package lan
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestEncode_GetServiceBroadcast(t *testing.T) {
+	got := Encode(Header{Tagged: true, Source: 0x12345678, ResRequired: true, Sequence: 7}, &Empty{MessageType: TypeGetService})
+
+	want, _ := hex.DecodeString("2400" + "0034" + "78563412" + "0000000000000000" + "000000000000" + "01" + "07" +
+		"0000000000000000" + "0200" + "0000")
+	if !bytes.Equal(got, want) {
+		t.Errorf("Unexpected packet\n got % x\nwant % x", got, want)
+	}
+}
+
+func TestEncode_SetColor(t *testing.T) {
+	target, err := ParseTarget("d0:73:d5:01:02:03")
+	if err != nil {
+		t.Fatalf("ParseTarget failed: %v", err)
+	}
+	msg := &LightSetColor{Color: HSBK{Hue: 21845, Saturation: 65535, Brightness: 32768, Kelvin: 3500}, Duration: 1024}
+	got := Encode(Header{Target: target, AckRequired: true, Sequence: 200}, msg)
+
+	if len(got) != HeaderSize+13 {
+		t.Fatalf("Expected %d bytes, got %d", HeaderSize+13, len(got))
+	}
+	if got[0] != 49 || got[1] != 0 {
+		t.Errorf("Unexpected size bytes % x", got[:2])
+	}
+	// Addressable but not tagged
+	if got[2] != 0x00 || got[3] != 0x14 {
+		t.Errorf("Unexpected protocol bytes % x", got[2:4])
+	}
+	if !bytes.Equal(got[8:16], []byte{0xd0, 0x73, 0xd5, 0x01, 0x02, 0x03, 0, 0}) {
+		t.Errorf("Unexpected target % x", got[8:16])
+	}
+	if got[22] != 2 || got[23] != 200 {
+		t.Errorf("Unexpected flags %#x, sequence %d", got[22], got[23])
+	}
+	if got[32] != 102 || got[33] != 0 {
+		t.Errorf("Unexpected type bytes % x", got[32:34])
+	}
+
+	want, _ := hex.DecodeString("00" + "5555" + "ffff" + "0080" + "ac0d" + "00040000")
+	if !bytes.Equal(got[HeaderSize:], want) {
+		t.Errorf("Unexpected payload\n got % x\nwant % x", got[HeaderSize:], want)
+	}
+}
+
+func TestDecode_RoundTrip(t *testing.T) {
+	target, _ := ParseTarget("d0:73:d5:aa:bb:cc")
+	messages := []Message{
+		&Empty{MessageType: TypeGetService},
+		&Empty{MessageType: TypeAcknowledgement},
+		&Empty{MessageType: TypeLightGet},
+		&StateService{Service: ServiceUDP, Port: DefaultPort},
+		&SetPower{Level: 65535},
+		&StatePower{Level: 0},
+		&StateLabel{Label: "Kitchen"},
+		&StateVersion{Vendor: 1, Product: 27},
+		&LightSetColor{Color: HSBK{Hue: 1, Saturation: 2, Brightness: 3, Kelvin: 4}, Duration: 5},
+		&LightState{Color: HSBK{Hue: 10000, Saturation: 20000, Brightness: 30000, Kelvin: 2700}, Power: 65535, Label: "Desk"},
+		&LightSetPower{Level: 65535, Duration: 250},
+		&LightStatePower{Level: 65535},
+		&Unknown{MessageType: 506, Payload: []byte{1, 2, 3}},
+	}
+
+	for _, msg := range messages {
+		h := Header{Source: 42, Target: target, ResRequired: true, AckRequired: true, Sequence: 9}
+		h2, got, err := Decode(Encode(h, msg))
+		if err != nil {
+			t.Errorf("Decode of %T failed: %v", msg, err)
+			continue
+		}
+		h.Type = msg.Type()
+		if h2 != h {
+			t.Errorf("Expected header %+v, got %+v", h, h2)
+		}
+		if !reflect.DeepEqual(got, msg) {
+			t.Errorf("Expected %#v, got %#v", msg, got)
+		}
+	}
+}
+
+func TestDecode_LightStateLayout(t *testing.T) {
+	b := Encode(Header{}, &LightState{Power: 65535, Label: "Lamp"})
+	if len(b) != HeaderSize+52 {
+		t.Fatalf("Expected a 52 byte payload, got %d", len(b)-HeaderSize)
+	}
+	payload := b[HeaderSize:]
+	if payload[10] != 0xff || payload[11] != 0xff || string(payload[12:16]) != "Lamp" || payload[16] != 0 {
+		t.Errorf("Unexpected payload % x", payload)
+	}
+}
+
+func TestDecode_Errors(t *testing.T) {
+	valid := Encode(Header{}, &StatePower{Level: 1})
+
+	if _, _, err := Decode(valid[:20]); !errors.Is(err, ErrShortPacket) {
+		t.Errorf("Expected ErrShortPacket, got %v", err)
+	}
+	if _, _, err := Decode(append(valid, 0)); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("Expected ErrSizeMismatch, got %v", err)
+	}
+
+	wrong := append([]byte(nil), valid...)
+	wrong[2] = 0x55
+	if _, _, err := Decode(wrong); !errors.Is(err, ErrWrongProtocol) {
+		t.Errorf("Expected ErrWrongProtocol, got %v", err)
+	}
+
+	// Header size fine, payload too short for the type
+	short := Encode(Header{}, &Unknown{MessageType: TypeLightState, Payload: make([]byte, 10)})
+	if _, _, err := Decode(short); !errors.Is(err, ErrShortPacket) {
+		t.Errorf("Expected ErrShortPacket for a short payload, got %v", err)
+	}
+}
+
+func TestTarget(t *testing.T) {
+	target, err := ParseTarget("D0:73:D5:01:02:03")
+	if err != nil {
+		t.Fatalf("ParseTarget failed: %v", err)
+	}
+	if target.String() != "d0:73:d5:01:02:03" {
+		t.Errorf("Unexpected target string %s", target)
+	}
+	if _, err := ParseTarget("d0:73:d5"); err == nil {
+		t.Error("Expected an error for a short MAC")
+	}
+}
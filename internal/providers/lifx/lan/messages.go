@@ -0,0 +1,319 @@
+package lan
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Message types used by the hub.
+const (
+	TypeGetService      = 2
+	TypeStateService    = 3
+	TypeGetPower        = 20
+	TypeSetPower        = 21
+	TypeStatePower      = 22
+	TypeGetLabel        = 23
+	TypeStateLabel      = 25
+	TypeGetVersion      = 32
+	TypeStateVersion    = 33
+	TypeAcknowledgement = 45
+	TypeLightGet        = 101
+	TypeLightSetColor   = 102
+	TypeLightState      = 107
+	TypeLightGetPower   = 116
+	TypeLightSetPower   = 117
+	TypeLightStatePower = 118
+)
+
+// ServiceUDP is the only service in StateService that matters
+const ServiceUDP = 1
+
+// Message is a packet payload.
+type Message interface {
+	Type() uint16
+	appendPayload(b []byte) []byte
+	decodePayload(b []byte) error
+}
+
+func newMessage(typ uint16) Message {
+	switch typ {
+	case TypeGetService, TypeGetPower, TypeGetLabel, TypeGetVersion, TypeAcknowledgement, TypeLightGet, TypeLightGetPower:
+		return &Empty{MessageType: typ}
+	case TypeStateService:
+		return &StateService{}
+	case TypeSetPower:
+		return &SetPower{}
+	case TypeStatePower:
+		return &StatePower{}
+	case TypeStateLabel:
+		return &StateLabel{}
+	case TypeStateVersion:
+		return &StateVersion{}
+	case TypeLightSetColor:
+		return &LightSetColor{}
+	case TypeLightState:
+		return &LightState{}
+	case TypeLightSetPower:
+		return &LightSetPower{}
+	case TypeLightStatePower:
+		return &LightStatePower{}
+	}
+	return &Unknown{MessageType: typ}
+}
+
+// need checks that a payload has at least n bytes.
+func need(b []byte, n int, typ uint16) error {
+	if len(b) < n {
+		return fmt.Errorf("%w: message %d needs %d payload bytes, got %d", ErrShortPacket, typ, n, len(b))
+	}
+	return nil
+}
+
+// Empty is any message without a payload: the Get requests and Acknowledgement.
+type Empty struct {
+	MessageType uint16
+}
+
+func (m *Empty) Type() uint16                  { return m.MessageType }
+func (m *Empty) appendPayload(b []byte) []byte { return b }
+func (m *Empty) decodePayload(b []byte) error  { return nil }
+
+// Unknown keeps the raw payload of a message type this package doesn't decode.
+type Unknown struct {
+	MessageType uint16
+	Payload     []byte
+}
+
+func (m *Unknown) Type() uint16                  { return m.MessageType }
+func (m *Unknown) appendPayload(b []byte) []byte { return append(b, m.Payload...) }
+
+func (m *Unknown) decodePayload(b []byte) error {
+	m.Payload = append([]byte(nil), b...)
+	return nil
+}
+
+type StateService struct {
+	Service uint8
+	Port    uint32
+}
+
+func (m *StateService) Type() uint16 { return TypeStateService }
+
+func (m *StateService) appendPayload(b []byte) []byte {
+	b = append(b, m.Service)
+	return binary.LittleEndian.AppendUint32(b, m.Port)
+}
+
+func (m *StateService) decodePayload(b []byte) error {
+	if err := need(b, 5, m.Type()); err != nil {
+		return err
+	}
+	m.Service = b[0]
+	m.Port = binary.LittleEndian.Uint32(b[1:])
+	return nil
+}
+
+// SetPower sets the device power: 0 is off, 65535 on.
+type SetPower struct {
+	Level uint16
+}
+
+func (m *SetPower) Type() uint16 { return TypeSetPower }
+
+func (m *SetPower) appendPayload(b []byte) []byte {
+	return binary.LittleEndian.AppendUint16(b, m.Level)
+}
+
+func (m *SetPower) decodePayload(b []byte) error {
+	if err := need(b, 2, m.Type()); err != nil {
+		return err
+	}
+	m.Level = binary.LittleEndian.Uint16(b)
+	return nil
+}
+
+type StatePower struct {
+	Level uint16
+}
+
+func (m *StatePower) Type() uint16 { return TypeStatePower }
+
+func (m *StatePower) appendPayload(b []byte) []byte {
+	return binary.LittleEndian.AppendUint16(b, m.Level)
+}
+
+func (m *StatePower) decodePayload(b []byte) error {
+	if err := need(b, 2, m.Type()); err != nil {
+		return err
+	}
+	m.Level = binary.LittleEndian.Uint16(b)
+	return nil
+}
+
+type StateLabel struct {
+	Label string
+}
+
+func (m *StateLabel) Type() uint16                  { return TypeStateLabel }
+func (m *StateLabel) appendPayload(b []byte) []byte { return appendLabel(b, m.Label) }
+
+func (m *StateLabel) decodePayload(b []byte) error {
+	if err := need(b, 32, m.Type()); err != nil {
+		return err
+	}
+	m.Label = decodeLabel(b[:32])
+	return nil
+}
+
+// StateVersion identifies the model; Product is LIFX's product ID.
+type StateVersion struct {
+	Vendor  uint32
+	Product uint32
+}
+
+func (m *StateVersion) Type() uint16 { return TypeStateVersion }
+
+func (m *StateVersion) appendPayload(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint32(b, m.Vendor)
+	b = binary.LittleEndian.AppendUint32(b, m.Product)
+	return binary.LittleEndian.AppendUint32(b, 0) // reserved
+}
+
+func (m *StateVersion) decodePayload(b []byte) error {
+	if err := need(b, 12, m.Type()); err != nil {
+		return err
+	}
+	m.Vendor = binary.LittleEndian.Uint32(b)
+	m.Product = binary.LittleEndian.Uint32(b[4:])
+	return nil
+}
+
+// HSBK is a LIFX color. Hue, saturation and brightness use the full uint16 range;
+// Kelvin is 1500-9000 and only matters at low saturation.
+type HSBK struct {
+	Hue        uint16
+	Saturation uint16
+	Brightness uint16
+	Kelvin     uint16
+}
+
+func (c HSBK) append(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, c.Hue)
+	b = binary.LittleEndian.AppendUint16(b, c.Saturation)
+	b = binary.LittleEndian.AppendUint16(b, c.Brightness)
+	return binary.LittleEndian.AppendUint16(b, c.Kelvin)
+}
+
+func decodeHSBK(b []byte) HSBK {
+	return HSBK{
+		Hue:        binary.LittleEndian.Uint16(b),
+		Saturation: binary.LittleEndian.Uint16(b[2:]),
+		Brightness: binary.LittleEndian.Uint16(b[4:]),
+		Kelvin:     binary.LittleEndian.Uint16(b[6:]),
+	}
+}
+
+// LightSetColor changes the color over Duration milliseconds.
+type LightSetColor struct {
+	Color    HSBK
+	Duration uint32
+}
+
+func (m *LightSetColor) Type() uint16 { return TypeLightSetColor }
+
+func (m *LightSetColor) appendPayload(b []byte) []byte {
+	b = append(b, 0) // reserved
+	b = m.Color.append(b)
+	return binary.LittleEndian.AppendUint32(b, m.Duration)
+}
+
+func (m *LightSetColor) decodePayload(b []byte) error {
+	if err := need(b, 13, m.Type()); err != nil {
+		return err
+	}
+	m.Color = decodeHSBK(b[1:])
+	m.Duration = binary.LittleEndian.Uint32(b[9:])
+	return nil
+}
+
+// LightState is the reply to LightGet and LightSetColor.
+type LightState struct {
+	Color HSBK
+	Power uint16
+	Label string
+}
+
+func (m *LightState) Type() uint16 { return TypeLightState }
+
+func (m *LightState) appendPayload(b []byte) []byte {
+	b = m.Color.append(b)
+	b = binary.LittleEndian.AppendUint16(b, 0) // reserved
+	b = binary.LittleEndian.AppendUint16(b, m.Power)
+	b = appendLabel(b, m.Label)
+	return binary.LittleEndian.AppendUint64(b, 0) // reserved
+}
+
+func (m *LightState) decodePayload(b []byte) error {
+	if err := need(b, 52, m.Type()); err != nil {
+		return err
+	}
+	m.Color = decodeHSBK(b)
+	m.Power = binary.LittleEndian.Uint16(b[10:])
+	m.Label = decodeLabel(b[12:44])
+	return nil
+}
+
+// LightSetPower is SetPower with a transition Duration in milliseconds.
+type LightSetPower struct {
+	Level    uint16
+	Duration uint32
+}
+
+func (m *LightSetPower) Type() uint16 { return TypeLightSetPower }
+
+func (m *LightSetPower) appendPayload(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, m.Level)
+	return binary.LittleEndian.AppendUint32(b, m.Duration)
+}
+
+func (m *LightSetPower) decodePayload(b []byte) error {
+	if err := need(b, 6, m.Type()); err != nil {
+		return err
+	}
+	m.Level = binary.LittleEndian.Uint16(b)
+	m.Duration = binary.LittleEndian.Uint32(b[2:])
+	return nil
+}
+
+type LightStatePower struct {
+	Level uint16
+}
+
+func (m *LightStatePower) Type() uint16 { return TypeLightStatePower }
+
+func (m *LightStatePower) appendPayload(b []byte) []byte {
+	return binary.LittleEndian.AppendUint16(b, m.Level)
+}
+
+func (m *LightStatePower) decodePayload(b []byte) error {
+	if err := need(b, 2, m.Type()); err != nil {
+		return err
+	}
+	m.Level = binary.LittleEndian.Uint16(b)
+	return nil
+}
+
+// Labels are 32 bytes of UTF-8, zero padded.
+func appendLabel(b []byte, label string) []byte {
+	var buf [32]byte
+	copy(buf[:], label)
+	return append(b, buf[:]...)
+}
+
+func decodeLabel(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
@@ -0,0 +1,167 @@
+package lifx
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider/providertest"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/lifx/lan"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/lifx/lifxtest"
+)
+
+func startProvider(t *testing.T, settings Settings) *device.Registry {
+	t.Helper()
+
+	settings.DiscoveryTimeout = config.Duration(100 * time.Millisecond)
+	registry, _ := providertest.Start(t, NewProvider, "lifx", settings)
+	return registry
+}
+
+func hosts(fakes ...*lifxtest.Bulb) Settings {
+	off := false
+	return Settings{Discovery: &off, Hosts: providertest.Addresses(fakes, (*lifxtest.Bulb).Address)}
+}
+
+func TestLifx_PowerAndColor(t *testing.T) {
+	ctx := context.Background()
+	bulb := lifxtest.NewBulb(t, "Desk", "d0:73:d5:01:02:03")
+	registry := startProvider(t, hosts(bulb))
+
+	dev, err := registry.Get("lifx-d073d5010203")
+	if err != nil {
+		t.Fatalf("Expected bulb to be registered: %v", err)
+	}
+
+	state, err := dev.State(ctx)
+	if err != nil {
+		t.Fatalf("State failed: %v", err)
+	}
+	if state.DeviceType != "light" || state.Attributes["power"] != "off" || state.Attributes["name"] != "Desk" {
+		t.Errorf("Unexpected state: %+v", state)
+	}
+	if state.Attributes["brightness"] != 100 || state.Attributes["color_temperature"] != 3500 {
+		t.Errorf("Unexpected white state: %v", state.Attributes)
+	}
+
+	if err := dev.Execute(ctx, device.Command{DeviceID: dev.ID(), Action: "turn_on"}); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if !bulb.On() {
+		t.Error("Expected bulb to be on")
+	}
+
+	cmd := device.Command{DeviceID: dev.ID(), Action: "set_color", Params: map[string]any{"hue": float64(240), "saturation": 50}}
+	if err := dev.Execute(ctx, cmd); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	cmd = device.Command{DeviceID: dev.ID(), Action: "set_brightness", Params: map[string]any{"value": 25}}
+	if err := dev.Execute(ctx, cmd); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if c := bulb.Color(); c.Hue != 43691 || c.Saturation != 32768 || c.Brightness != 16384 {
+		t.Errorf("Unexpected bulb color %+v", c)
+	}
+
+	state, _ = dev.State(ctx)
+	if state.Attributes["hue"] != 240 || state.Attributes["saturation"] != 50 || state.Attributes["brightness"] != 25 {
+		t.Errorf("Unexpected colour state: %v", state.Attributes)
+	}
+	if _, ok := state.Attributes["color_temperature"]; ok {
+		t.Error("Expected no color_temperature in colour mode")
+	}
+
+	cmd = device.Command{DeviceID: dev.ID(), Action: "set_color_temperature", Params: map[string]any{"value": 2700}}
+	if err := dev.Execute(ctx, cmd); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if c := bulb.Color(); c.Kelvin != 2700 || c.Saturation != 0 || c.Brightness != 16384 {
+		t.Errorf("Unexpected bulb color %+v", c)
+	}
+
+	if err := dev.Execute(ctx, device.Command{DeviceID: dev.ID(), Action: "turn_off"}); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if bulb.On() {
+		t.Error("Expected bulb to be off")
+	}
+
+	cmd = device.Command{DeviceID: dev.ID(), Action: "set_color_temperature", Params: map[string]any{"value": 1000}}
+	if err := dev.Execute(ctx, cmd); !errors.Is(err, device.ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter, got %v", err)
+	}
+	if err := dev.Execute(ctx, device.Command{DeviceID: dev.ID(), Action: "blink"}); !errors.Is(err, device.ErrUnknownCommand) {
+		t.Errorf("Expected ErrUnknownCommand, got %v", err)
+	}
+}
+
+func TestLifx_BroadcastDiscovery(t *testing.T) {
+	bulb := lifxtest.NewBulb(t, "Hall", "d0:73:d5:0a:0b:0c")
+	registry := startProvider(t, Settings{BroadcastAddress: bulb.Address()})
+
+	dev, err := registry.Get("lifx-d073d50a0b0c")
+	if err != nil {
+		t.Fatalf("Expected discovered bulb to be registered: %v", err)
+	}
+	if err := dev.Execute(context.Background(), device.Command{DeviceID: dev.ID(), Action: "turn_on"}); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if !bulb.On() {
+		t.Error("Expected bulb to be on")
+	}
+}
+
+func TestClient_ResendsLostPackets(t *testing.T) {
+	bulb := lifxtest.NewBulb(t, "Porch", "d0:73:d5:01:02:03")
+	target, _ := lan.ParseTarget("d0:73:d5:01:02:03")
+	client := NewClient(bulb.Address(), target)
+
+	bulb.Drop(2)
+	light, err := client.LightState(context.Background())
+	if err != nil {
+		t.Fatalf("LightState failed: %v", err)
+	}
+	if light.Label != "Porch" {
+		t.Errorf("Expected label Porch, got %q", light.Label)
+	}
+	if n := bulb.Packets(); n != 3 {
+		t.Errorf("Expected 3 packets, got %d", n)
+	}
+
+	// A bulb with another MAC ignores the request
+	other, _ := lan.ParseTarget("d0:73:d5:ff:ff:ff")
+	client = NewClient(bulb.Address(), other)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := client.LightState(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestLifx_UnreachableBulb(t *testing.T) {
+	bulb := lifxtest.NewBulb(t, "Desk", "d0:73:d5:01:02:03")
+	registry := startProvider(t, hosts(bulb))
+	dev, _ := registry.Get("lifx-d073d5010203")
+
+	bulb.Drop(100)
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	state, err := dev.State(ctx)
+	if !errors.Is(err, device.ErrDeviceUnavailable) {
+		t.Errorf("Expected ErrDeviceUnavailable, got %v", err)
+	}
+	if state.Attributes["power"] != "unknown" || state.Attributes["name"] != "Desk" {
+		t.Errorf("Unexpected state: %v", state.Attributes)
+	}
+}
+
+func TestLifx_Conformance(t *testing.T) {
+	providertest.LightConformance(t, "lifx-d073d5010203", func(t *testing.T) *device.Registry {
+		bulb := lifxtest.NewBulb(t, "Desk", "d0:73:d5:01:02:03")
+		return startProvider(t, hosts(bulb))
+	})
+}
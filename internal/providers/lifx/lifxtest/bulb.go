@@ -0,0 +1,160 @@
+// Package lifxtest runs fake LIFX bulbs for tests. Each fake answers the LAN protocol
+// on its own UDP port on 127.0.0.1.
+package lifxtest
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/legitlolly/SmartHomeHub/internal/providers/lifx/lan"
+)
+
+type Bulb struct {
+	conn   *net.UDPConn
+	target lan.Target
+
+	mu      sync.Mutex
+	label   string
+	color   lan.HSBK
+	power   uint16
+	drop    int
+	packets int
+}
+
+// NewBulb starts a fake bulb that is off, at full brightness and 3500K. It is closed
+// when the test ends.
+func NewBulb(t testing.TB, label, mac string) *Bulb {
+	t.Helper()
+
+	target, err := lan.ParseTarget(mac)
+	if err != nil {
+		t.Fatalf("invalid mac: %v", err)
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	b := &Bulb{
+		conn:   conn,
+		target: target,
+		label:  label,
+		color:  lan.HSBK{Brightness: 65535, Kelvin: 3500},
+	}
+	go b.serve()
+	t.Cleanup(func() { conn.Close() })
+	return b
+}
+
+// Address is the bulb's UDP address, for the provider's hosts and broadcast
+// settings.
+func (b *Bulb) Address() string {
+	return b.conn.LocalAddr().String()
+}
+
+func (b *Bulb) On() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.power > 0
+}
+
+func (b *Bulb) Color() lan.HSBK {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.color
+}
+
+// Drop makes the bulb ignore the next n packets, like a lossy network.
+func (b *Bulb) Drop(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.drop = n
+}
+
+// Packets is the number of packets received, dropped ones included.
+func (b *Bulb) Packets() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.packets
+}
+
+func (b *Bulb) serve() {
+	buf := make([]byte, 1024)
+	for {
+		n, from, err := b.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		h, msg, err := lan.Decode(buf[:n])
+		if err != nil {
+			continue
+		}
+		if !h.Tagged && h.Target != b.target {
+			continue
+		}
+
+		for _, reply := range b.handle(h, msg) {
+			rh := lan.Header{Source: h.Source, Target: b.target, Sequence: h.Sequence}
+			b.conn.WriteToUDP(lan.Encode(rh, reply), from)
+		}
+	}
+}
+
+// handle applies a request and returns the replies, an ack first if one was asked
+// for.
+func (b *Bulb) handle(h lan.Header, msg lan.Message) []lan.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.packets++
+	if b.drop > 0 {
+		b.drop--
+		return nil
+	}
+
+	var replies []lan.Message
+	if h.AckRequired {
+		replies = append(replies, &lan.Empty{MessageType: lan.TypeAcknowledgement})
+	}
+
+	var res lan.Message
+	switch m := msg.(type) {
+	case *lan.Empty:
+		switch m.MessageType {
+		case lan.TypeGetService:
+			// Real bulbs always answer GetService
+			port := uint32(b.conn.LocalAddr().(*net.UDPAddr).Port)
+			return append(replies, &lan.StateService{Service: lan.ServiceUDP, Port: port})
+		case lan.TypeGetPower:
+			res = &lan.StatePower{Level: b.power}
+		case lan.TypeLightGetPower:
+			res = &lan.LightStatePower{Level: b.power}
+		case lan.TypeGetLabel:
+			res = &lan.StateLabel{Label: b.label}
+		case lan.TypeGetVersion:
+			res = &lan.StateVersion{Vendor: 1, Product: 27} // LIFX A19
+		case lan.TypeLightGet:
+			res = b.state()
+		}
+	case *lan.SetPower:
+		res = &lan.StatePower{Level: b.power}
+		b.power = m.Level
+	case *lan.LightSetPower:
+		res = &lan.LightStatePower{Level: b.power}
+		b.power = m.Level
+	case *lan.LightSetColor:
+		res = b.state()
+		b.color = m.Color
+	}
+
+	// Set replies carry the state from before the change, as real bulbs do
+	if h.ResRequired && res != nil {
+		replies = append(replies, res)
+	}
+	return replies
+}
+
+func (b *Bulb) state() *lan.LightState {
+	return &lan.LightState{Color: b.color, Power: b.power, Label: b.label}
+}
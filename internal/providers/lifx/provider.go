@@ -0,0 +1,174 @@
+// Package lifx integrates LIFX bulbs over the LAN protocol: binary packets over UDP,
+// found by broadcasting GetService. The packet codec lives in package lan.
+package lifx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/lifx/lan"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+func init() {
+	provider.RegisterFactory("lifx", NewProvider)
+}
+
+type Settings struct {
+	// Hosts are asked directly, for bulbs that don't answer broadcasts (other subnets)
+	Hosts []string `json:"hosts"`
+	// Discovery broadcasts on the local network, on by default
+	Discovery        *bool               `json:"discovery,omitempty"`
+	BroadcastAddress string              `json:"broadcast_address"`
+	DiscoveryTimeout config.Duration     `json:"discovery_timeout"`
+	Resilience       resilience.Settings `json:"resilience"`
+}
+
+type Provider struct {
+	name     string
+	settings Settings
+	guards   *resilience.Registry
+
+	mu       sync.Mutex
+	registry provider.Registry
+	bulbs    map[device.ID]*Bulb
+}
+
+func NewProvider(name string, raw json.RawMessage, env provider.Env) (provider.Provider, error) {
+	var settings Settings
+	if err := provider.DecodeSettings(raw, &settings); err != nil {
+		return nil, err
+	}
+	if settings.BroadcastAddress == "" {
+		settings.BroadcastAddress = fmt.Sprintf("255.255.255.255:%d", lan.DefaultPort)
+	}
+	if settings.DiscoveryTimeout <= 0 {
+		settings.DiscoveryTimeout = config.Duration(2 * time.Second)
+	}
+
+	guards := env.Guards
+	if guards == nil {
+		guards = resilience.NewRegistry()
+	}
+	guards.Configure(name, settings.Resilience.Apply(resilience.DefaultConfig()), resilience.ClassifyNetwork)
+
+	return &Provider{
+		name:     name,
+		settings: settings,
+		guards:   guards,
+		bulbs:    make(map[device.ID]*Bulb),
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+func (p *Provider) discoveryEnabled() bool {
+	return p.settings.Discovery == nil || *p.settings.Discovery
+}
+
+func (p *Provider) Start(ctx context.Context, registry provider.Registry) error {
+	if len(p.settings.Hosts) == 0 && !p.discoveryEnabled() {
+		return fmt.Errorf("%w: no hosts and discovery is off", provider.ErrNotConfigured)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.registry = registry
+	return nil
+}
+
+// Discover sends GetService to the configured hosts and the broadcast address, then
+// registers the bulbs that aren't registered yet.
+func (p *Provider) Discover(ctx context.Context) error {
+	var (
+		found []Discovered
+		errs  []error
+	)
+
+	targets := make([]string, 0, len(p.settings.Hosts)+1)
+	for _, host := range p.settings.Hosts {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, fmt.Sprint(lan.DefaultPort))
+		}
+		targets = append(targets, host)
+	}
+	if p.discoveryEnabled() {
+		targets = append(targets, p.settings.BroadcastAddress)
+	}
+
+	for _, target := range targets {
+		replies, err := Discover(ctx, target, p.settings.DiscoveryTimeout.Duration())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", target, err))
+		}
+		found = append(found, replies...)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, f := range found {
+		id := deviceID(f.Target)
+		if _, ok := p.bulbs[id]; ok {
+			continue
+		}
+
+		guard := p.guards.Guard(p.name, f.Address)
+		client := NewClient(f.Address, f.Target)
+		var light lan.LightState
+		err := guard.Do(ctx, func(ctx context.Context) error {
+			var err error
+			light, err = client.LightState(ctx)
+			return err
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.Address, err))
+			continue
+		}
+
+		b := newBulb(f, light, guard)
+		if err := p.registry.Register(b); err != nil {
+			if !errors.Is(err, device.ErrDeviceAlreadyRegistered) {
+				log.Printf("lifx: failed to register %s: %v", id, err)
+			}
+			continue
+		}
+		p.bulbs[id] = b
+		log.Printf("lifx: registered %q at %s as %s", light.Label, f.Address, id)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *Provider) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id := range p.bulbs {
+		p.registry.Unregister(id)
+	}
+	p.bulbs = make(map[device.ID]*Bulb)
+	return nil
+}
+
+func (p *Provider) Health(ctx context.Context) provider.Health {
+	health, ok := p.guards.ProviderHealth(p.name)
+	if !ok {
+		return provider.Health{Status: provider.HealthOK}
+	}
+	return provider.Health{
+		Status:  health.Status,
+		Details: health,
+	}
+}
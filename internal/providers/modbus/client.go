@@ -0,0 +1,244 @@
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultPort is the standard Modbus TCP port.
+const DefaultPort = 502
+
+// Function codes
+const (
+	fcReadCoils              = 0x01
+	fcReadDiscreteInputs     = 0x02
+	fcReadHoldingRegisters   = 0x03
+	fcReadInputRegisters     = 0x04
+	fcWriteSingleCoil        = 0x05
+	fcWriteSingleRegister    = 0x06
+	fcWriteMultipleRegisters = 0x10
+)
+
+var (
+	ErrException   = errors.New("modbus exception")
+	ErrBadResponse = errors.New("invalid modbus response")
+)
+
+// ExceptionError is an exception response from the slave.
+type ExceptionError struct {
+	Function byte
+	Code     byte
+}
+
+func (e *ExceptionError) Error() string {
+	names := map[byte]string{
+		1:  "illegal function",
+		2:  "illegal data address",
+		3:  "illegal data value",
+		4:  "slave device failure",
+		6:  "slave device busy",
+		11: "gateway target device failed to respond",
+	}
+	name, ok := names[e.Code]
+	if !ok {
+		name = fmt.Sprintf("code %d", e.Code)
+	}
+	return fmt.Sprintf("function %#02x: %s", e.Function, name)
+}
+
+func (e *ExceptionError) Unwrap() error {
+	return ErrException
+}
+
+// Client talks to one unit over a Modbus TCP connection that is kept open between
+// requests and reopened after errors. Requests are serialized.
+type Client struct {
+	address string
+	unitID  byte
+	timeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	txID uint16
+}
+
+// NewClient creates a client for unitID at address, which is a host or host:port.
+func NewClient(address string, unitID byte) *Client {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, fmt.Sprint(DefaultPort))
+	}
+	return &Client{address: address, unitID: unitID, timeout: 5 * time.Second}
+}
+
+// Close closes the connection, if open.
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// send sends a PDU and returns the response PDU without the function code.
+func (c *Client) send(ctx context.Context, pdu []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		dialer := net.Dialer{Timeout: c.timeout}
+		conn, err := dialer.DialContext(ctx, "tcp", c.address)
+		if err != nil {
+			return nil, err
+		}
+		c.conn = conn
+	}
+
+	conn := c.conn
+	resp, err := c.roundTrip(ctx, conn, pdu)
+	var exception *ExceptionError
+	if err != nil && !errors.As(err, &exception) {
+		// The stream may be out of step; start over on the next request
+		conn.Close()
+		c.conn = nil
+	}
+	return resp, err
+}
+
+// roundTrip uses conn rather than c.conn, which send clears after a failure while
+// the AfterFunc below may still be running.
+func (c *Client) roundTrip(ctx context.Context, conn net.Conn, pdu []byte) ([]byte, error) {
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	c.txID++
+	frame := make([]byte, 7, 7+len(pdu))
+	binary.BigEndian.PutUint16(frame[0:], c.txID)
+	binary.BigEndian.PutUint16(frame[2:], 0) // protocol
+	binary.BigEndian.PutUint16(frame[4:], uint16(len(pdu)+1))
+	frame[6] = c.unitID
+	if _, err := conn.Write(append(frame, pdu...)); err != nil {
+		return nil, c.contextErr(ctx, err)
+	}
+
+	var header [7]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return nil, c.contextErr(ctx, err)
+	}
+	length := binary.BigEndian.Uint16(header[4:])
+	if length < 2 || length > 254 {
+		return nil, fmt.Errorf("%w: length %d", ErrBadResponse, length)
+	}
+	body := make([]byte, length-1)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, c.contextErr(ctx, err)
+	}
+	if tx := binary.BigEndian.Uint16(header[0:]); tx != c.txID {
+		return nil, fmt.Errorf("%w: transaction %d, expected %d", ErrBadResponse, tx, c.txID)
+	}
+
+	switch fc := body[0]; {
+	case fc == pdu[0]|0x80 && len(body) >= 2:
+		return nil, &ExceptionError{Function: pdu[0], Code: body[1]}
+	case fc != pdu[0]:
+		return nil, fmt.Errorf("%w: function %#02x, expected %#02x", ErrBadResponse, fc, pdu[0])
+	}
+	return body[1:], nil
+}
+
+func (c *Client) contextErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// ReadRegisters reads quantity holding or input registers.
+func (c *Client) ReadRegisters(ctx context.Context, table Table, address, quantity uint16) ([]uint16, error) {
+	fc := byte(fcReadHoldingRegisters)
+	if table == TableInput {
+		fc = fcReadInputRegisters
+	}
+	resp, err := c.send(ctx, readRequest(fc, address, quantity))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 1 || int(resp[0]) != 2*int(quantity) || len(resp) != 1+int(resp[0]) {
+		return nil, fmt.Errorf("%w: %d bytes for %d registers", ErrBadResponse, len(resp), quantity)
+	}
+	values := make([]uint16, quantity)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint16(resp[1+2*i:])
+	}
+	return values, nil
+}
+
+// ReadBits reads quantity coils or discrete inputs.
+func (c *Client) ReadBits(ctx context.Context, table Table, address, quantity uint16) ([]bool, error) {
+	fc := byte(fcReadCoils)
+	if table == TableDiscrete {
+		fc = fcReadDiscreteInputs
+	}
+	resp, err := c.send(ctx, readRequest(fc, address, quantity))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 1 || int(resp[0]) != (int(quantity)+7)/8 || len(resp) != 1+int(resp[0]) {
+		return nil, fmt.Errorf("%w: %d bytes for %d bits", ErrBadResponse, len(resp), quantity)
+	}
+	values := make([]bool, quantity)
+	for i := range values {
+		values[i] = resp[1+i/8]&(1<<(i%8)) != 0
+	}
+	return values, nil
+}
+
+// WriteCoil sets one coil.
+func (c *Client) WriteCoil(ctx context.Context, address uint16, on bool) error {
+	pdu := []byte{fcWriteSingleCoil, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(pdu[1:], address)
+	if on {
+		pdu[3] = 0xff
+	}
+	_, err := c.send(ctx, pdu)
+	return err
+}
+
+// WriteRegisters writes holding registers, with function 6 for a single one.
+func (c *Client) WriteRegisters(ctx context.Context, address uint16, values []uint16) error {
+	var pdu []byte
+	if len(values) == 1 {
+		pdu = []byte{fcWriteSingleRegister, 0, 0, 0, 0}
+		binary.BigEndian.PutUint16(pdu[1:], address)
+		binary.BigEndian.PutUint16(pdu[3:], values[0])
+	} else {
+		pdu = make([]byte, 6, 6+2*len(values))
+		pdu[0] = fcWriteMultipleRegisters
+		binary.BigEndian.PutUint16(pdu[1:], address)
+		binary.BigEndian.PutUint16(pdu[3:], uint16(len(values)))
+		pdu[5] = byte(2 * len(values))
+		for _, v := range values {
+			pdu = binary.BigEndian.AppendUint16(pdu, v)
+		}
+	}
+	_, err := c.send(ctx, pdu)
+	return err
+}
+
+func readRequest(fc byte, address, quantity uint16) []byte {
+	pdu := []byte{fc, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(pdu[1:], address)
+	binary.BigEndian.PutUint16(pdu[3:], quantity)
+	return pdu
+}
@@ -0,0 +1,206 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+// Device is one unit declared in the config. Its registers are polled, and State
+// returns the values from the last poll.
+type Device struct {
+	id       device.ID
+	config   DeviceConfig
+	client   *Client
+	guard    *resilience.Guard
+	blocks   []block
+	commands map[string]*Register
+
+	mu        sync.RWMutex
+	values    map[string]any
+	pollErr   error
+	updatedAt time.Time
+}
+
+func newDevice(cfg DeviceConfig, client *Client, guard *resilience.Guard) *Device {
+	d := &Device{
+		id:       device.ID(cfg.ID),
+		config:   cfg,
+		client:   client,
+		guard:    guard,
+		blocks:   plan(cfg.Registers),
+		commands: make(map[string]*Register),
+		values:   make(map[string]any),
+	}
+	for i := range cfg.Registers {
+		if r := &cfg.Registers[i]; r.Writable {
+			d.commands[r.Command] = r
+		}
+	}
+	return d
+}
+
+func (d *Device) ID() device.ID {
+	return d.id
+}
+
+// poll reads every register, keeping the last good values of blocks that fail.
+func (d *Device) poll(ctx context.Context) error {
+	values := make(map[string]any)
+	var errs []error
+	for _, b := range d.blocks {
+		if err := d.readBlock(ctx, b, values); err != nil {
+			errs = append(errs, fmt.Errorf("%s %d-%d: %w", b.table, b.address, b.address+b.quantity-1, err))
+		}
+	}
+	err := errors.Join(errs...)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for k, v := range values {
+		d.values[k] = v
+	}
+	d.pollErr = err
+	if len(values) > 0 {
+		d.updatedAt = time.Now()
+	}
+	return err
+}
+
+func (d *Device) readBlock(ctx context.Context, b block, values map[string]any) error {
+	return d.guard.Do(ctx, func(ctx context.Context) error {
+		if b.table.bits() {
+			bits, err := d.client.ReadBits(ctx, b.table, b.address, b.quantity)
+			if err != nil {
+				return err
+			}
+			for _, r := range b.members {
+				values[r.Name] = bits[r.Address-b.address]
+			}
+			return nil
+		}
+
+		regs, err := d.client.ReadRegisters(ctx, b.table, b.address, b.quantity)
+		if err != nil {
+			return err
+		}
+		for _, r := range b.members {
+			offset := r.Address - b.address
+			values[r.Name] = r.decode(regs[offset : offset+r.size()])
+		}
+		return nil
+	})
+}
+
+// run polls until ctx is cancelled.
+func (d *Device) run(ctx context.Context) {
+	ticker := time.NewTicker(d.config.PollInterval.Duration())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := d.poll(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("modbus: %s: poll failed: %v", d.id, err)
+		}
+	}
+}
+
+func (d *Device) Execute(ctx context.Context, cmd device.Command) error {
+	r, ok := d.commands[cmd.Action]
+	if !ok {
+		return fmt.Errorf("%w: %s", device.ErrUnknownCommand, cmd.Action)
+	}
+
+	var (
+		write func(ctx context.Context) error
+		value any
+	)
+	if r.Table == TableCoil {
+		on, err := boolParam(cmd.Params, "value")
+		if err != nil {
+			return err
+		}
+		value = on
+		write = func(ctx context.Context) error { return d.client.WriteCoil(ctx, r.Address, on) }
+	} else {
+		v, err := device.NumberParam(cmd.Params, "value", math.Inf(-1), math.Inf(1))
+		if err != nil {
+			return err
+		}
+		regs, err := r.encode(v)
+		if err != nil {
+			return err
+		}
+		value = r.decode(regs)
+		write = func(ctx context.Context) error { return d.client.WriteRegisters(ctx, r.Address, regs) }
+	}
+
+	if err := d.guard.Do(ctx, write); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.values[r.Name] = value
+	d.updatedAt = time.Now()
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *Device) State(ctx context.Context) (device.State, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	attributes := make(map[string]interface{}, len(d.values)+1)
+	for k, v := range d.values {
+		attributes[k] = v
+	}
+	if d.config.Name != "" {
+		attributes["name"] = d.config.Name
+	}
+
+	state := device.State{
+		DeviceType: d.config.Type,
+		UpdatedAt:  d.updatedAt,
+		Attributes: attributes,
+	}
+	if err := d.pollErr; err != nil {
+		attributes["error"] = err.Error()
+		return state, resilience.Unavailable(err)
+	}
+	return state, nil
+}
+
+// boolParam accepts true/false, 1/0 and "on"/"off".
+func boolParam(params map[string]any, key string) (bool, error) {
+	switch v := params[key].(type) {
+	case bool:
+		return v, nil
+	case float64:
+		if v == 0 || v == 1 {
+			return v == 1, nil
+		}
+	case int:
+		if v == 0 || v == 1 {
+			return v == 1, nil
+		}
+	case string:
+		switch strings.ToLower(v) {
+		case "on", "true":
+			return true, nil
+		case "off", "false":
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("%w: %s must be on or off", device.ErrInvalidParameter, key)
+}
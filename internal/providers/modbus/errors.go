@@ -0,0 +1,21 @@
+package modbus
+
+import (
+	"errors"
+	"io"
+
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+// ClassifyError retries network failures and dropped connections; exceptions
+// reported by the device itself are permanent.
+func ClassifyError(err error) resilience.Class {
+	if class, ok := resilience.ClassifyNetError(err); ok {
+		return class
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return resilience.Transient
+	}
+
+	return resilience.Permanent
+}
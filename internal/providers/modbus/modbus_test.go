@@ -0,0 +1,335 @@
+package modbus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/modbus/modbustest"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+func startProvider(t *testing.T, settings Settings) *device.Registry {
+	t.Helper()
+
+	raw, _ := json.Marshal(settings)
+	p, err := NewProvider("modbus", raw, provider.Env{})
+	if err != nil {
+		t.Fatalf("NewProvider failed: %v", err)
+	}
+
+	registry := device.NewRegistry()
+	m := provider.NewManager(registry, provider.Env{})
+	m.Add(p, "modbus")
+	m.StartAll(context.Background())
+	t.Cleanup(func() { m.StopAll(context.Background()) })
+
+	if status, _ := m.Status("modbus"); status.State != provider.StateRunning || status.Error != "" {
+		t.Fatalf("Expected provider to be running, got %+v", status)
+	}
+	return registry
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// hvac is a controller with a scaled setpoint, a float32 temperature in CDAB order
+// and a fan coil.
+func hvac(slave *modbustest.Slave) Settings {
+	return Settings{Devices: []DeviceConfig{{
+		ID:           "hvac",
+		Name:         "Heat pump",
+		Type:         "thermostat",
+		Address:      slave.Address(),
+		PollInterval: config.Duration(50 * time.Millisecond),
+		Registers: []Register{
+			{Name: "target_temperature", Table: TableHolding, Address: 0, Type: "int16", Scale: 0.1, Writable: true},
+			{Name: "mode", Table: TableHolding, Address: 1, Writable: true},
+			{Name: "current_temperature", Table: TableInput, Address: 10, Type: "float32", ByteOrder: "cdab"},
+			{Name: "outdoor_temperature", Table: TableInput, Address: 12, Type: "int16", Scale: 0.1},
+			{Name: "fan", Table: TableCoil, Address: 0, Writable: true, Command: "set_fan"},
+			{Name: "alarm", Table: TableDiscrete, Address: 0},
+		},
+	}}}
+}
+
+func newHVACSlave(t *testing.T) *modbustest.Slave {
+	slave := modbustest.NewSlave(t)
+	slave.SetHolding(0, 215, 2)
+	bits := math.Float32bits(19.5)
+	slave.SetInput(10, uint16(bits), uint16(bits>>16), uint16(0xffce)) // CDAB, then -5.0
+	slave.SetCoil(0, false)
+	slave.SetDiscrete(0, true)
+	return slave
+}
+
+func TestRegister_Decode(t *testing.T) {
+	tests := []struct {
+		register Register
+		regs     []uint16
+		want     any
+	}{
+		{Register{Type: "uint16"}, []uint16{65535}, 65535},
+		{Register{Type: "int16"}, []uint16{0xfffe}, -2},
+		{Register{Type: "int16", Scale: 0.1}, []uint16{0xff38}, -20.0},
+		{Register{Type: "uint16", Scale: 2, Offset: -10}, []uint16{30}, 50.0},
+		{Register{Type: "int32"}, []uint16{0xffff, 0xfffd}, -3},
+		{Register{Type: "uint32", ByteOrder: "CDAB"}, []uint16{0x0002, 0x0001}, 0x00010002},
+		{Register{Type: "float32"}, []uint16{0x4148, 0x0000}, 12.5},
+		{Register{Type: "float32", ByteOrder: "CDAB"}, []uint16{0x0000, 0x4148}, 12.5},
+		{Register{Type: "float32", ByteOrder: "BADC"}, []uint16{0x4841, 0x0000}, 12.5},
+		{Register{Type: "float32", ByteOrder: "DCBA"}, []uint16{0x0000, 0x4841}, 12.5},
+	}
+	for _, tt := range tests {
+		r := tt.register
+		r.Name, r.Table = "value", TableHolding
+		if err := r.validate(); err != nil {
+			t.Fatalf("validate failed: %v", err)
+		}
+		got := r.decode(tt.regs)
+		if got != tt.want {
+			t.Errorf("%s %s: expected %v (%T), got %v (%T)", r.Type, r.ByteOrder, tt.want, tt.want, got, got)
+			continue
+		}
+
+		// Encoding the decoded value gives back the registers
+		var value float64
+		switch v := got.(type) {
+		case int:
+			value = float64(v)
+		case float64:
+			value = v
+		}
+		regs, err := r.encode(value)
+		if err != nil {
+			t.Errorf("%s %s: encode failed: %v", r.Type, r.ByteOrder, err)
+			continue
+		}
+		for i := range regs {
+			if regs[i] != tt.regs[i] {
+				t.Errorf("%s %s: expected % x, got % x", r.Type, r.ByteOrder, tt.regs, regs)
+				break
+			}
+		}
+	}
+}
+
+func TestRegister_EncodeOutOfRange(t *testing.T) {
+	r := Register{Name: "setpoint", Table: TableHolding, Type: "int16", Scale: 0.1}
+	r.validate()
+	if _, err := r.encode(4000); !errors.Is(err, device.ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter, got %v", err)
+	}
+	r = Register{Name: "count", Table: TableHolding}
+	r.validate()
+	if _, err := r.encode(-1); !errors.Is(err, device.ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter, got %v", err)
+	}
+}
+
+func TestPlan_GroupsContiguousRegisters(t *testing.T) {
+	registers := []Register{
+		{Name: "a", Table: TableHolding, Address: 0},
+		{Name: "b", Table: TableHolding, Address: 1, Type: "float32"},
+		{Name: "c", Table: TableHolding, Address: 3},
+		{Name: "d", Table: TableHolding, Address: 10},
+		{Name: "e", Table: TableInput, Address: 2},
+		{Name: "f", Table: TableCoil, Address: 5},
+		{Name: "g", Table: TableCoil, Address: 6},
+		{Name: "h", Table: TableHolding, Address: 200, Type: "uint32"},
+		{Name: "i", Table: TableHolding, Address: 202},
+		{Name: "j", Table: TableHolding, Address: 326},
+		{Name: "k", Table: TableHolding, Address: 327},
+	}
+	for i := range registers {
+		registers[i].validate()
+	}
+
+	blocks := plan(registers)
+	type span struct {
+		table             Table
+		address, quantity uint16
+	}
+	want := []span{
+		{TableCoil, 5, 2},
+		{TableHolding, 0, 4},
+		{TableHolding, 10, 1},
+		// 200-326 would be 127 registers, over the limit of 125
+		{TableHolding, 200, 3},
+		{TableHolding, 326, 2},
+		{TableInput, 2, 1},
+	}
+	if len(blocks) != len(want) {
+		t.Fatalf("Expected %d blocks, got %+v", len(want), blocks)
+	}
+	for i, b := range blocks {
+		if got := (span{b.table, b.address, b.quantity}); got != want[i] {
+			t.Errorf("Block %d: expected %+v, got %+v", i, want[i], got)
+		}
+	}
+}
+
+func TestModbus_PollsRegistersIntoAttributes(t *testing.T) {
+	slave := newHVACSlave(t)
+	registry := startProvider(t, hvac(slave))
+
+	dev, err := registry.Get("modbus-hvac")
+	if err != nil {
+		t.Fatalf("Expected device to be registered: %v", err)
+	}
+	state, err := dev.State(context.Background())
+	if err != nil {
+		t.Fatalf("State failed: %v", err)
+	}
+	attributes := map[string]any{
+		"name":                "Heat pump",
+		"target_temperature":  21.5,
+		"mode":                2,
+		"current_temperature": 19.5,
+		"outdoor_temperature": -5.0,
+		"fan":                 false,
+		"alarm":               true,
+	}
+	if state.DeviceType != "thermostat" {
+		t.Errorf("Expected type thermostat, got %s", state.DeviceType)
+	}
+	for k, v := range attributes {
+		if state.Attributes[k] != v {
+			t.Errorf("Expected %s %v, got %v", k, v, state.Attributes[k])
+		}
+	}
+
+	// One read per table: coil 0, discrete 0, holding 0-1, input 10-12
+	want := []modbustest.Request{
+		{Function: 1, Address: 0, Quantity: 1},
+		{Function: 2, Address: 0, Quantity: 1},
+		{Function: 3, Address: 0, Quantity: 2},
+		{Function: 4, Address: 10, Quantity: 3},
+	}
+	if requests := slave.Requests(); len(requests) < 4 || !slices.Equal(requests[:4], want) {
+		t.Errorf("Expected first poll to be %+v, got %+v", want, requests)
+	}
+
+	// Changes on the device show up after the next poll
+	slave.SetInput(12, 30)
+	waitFor(t, "outdoor_temperature to update", func() bool {
+		state, _ := dev.State(context.Background())
+		return state.Attributes["outdoor_temperature"] == 3.0
+	})
+}
+
+func TestModbus_WritableRegistersAreCommands(t *testing.T) {
+	ctx := context.Background()
+	slave := newHVACSlave(t)
+	registry := startProvider(t, hvac(slave))
+	dev, _ := registry.Get("modbus-hvac")
+
+	cmd := device.Command{DeviceID: dev.ID(), Action: "set_target_temperature", Params: map[string]any{"value": 19.4}}
+	if err := dev.Execute(ctx, cmd); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if got := slave.Holding(0); got != 194 {
+		t.Errorf("Expected raw setpoint 194, got %d", got)
+	}
+
+	cmd = device.Command{DeviceID: dev.ID(), Action: "set_fan", Params: map[string]any{"value": "on"}}
+	if err := dev.Execute(ctx, cmd); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if !slave.Coil(0) {
+		t.Error("Expected fan coil to be on")
+	}
+
+	state, _ := dev.State(ctx)
+	if state.Attributes["target_temperature"] != 19.4 || state.Attributes["fan"] != true {
+		t.Errorf("Expected state to reflect the writes, got %v", state.Attributes)
+	}
+
+	cmd = device.Command{DeviceID: dev.ID(), Action: "set_current_temperature", Params: map[string]any{"value": 20}}
+	if err := dev.Execute(ctx, cmd); !errors.Is(err, device.ErrUnknownCommand) {
+		t.Errorf("Expected ErrUnknownCommand for an input register, got %v", err)
+	}
+	cmd = device.Command{DeviceID: dev.ID(), Action: "set_fan", Params: map[string]any{"value": "maybe"}}
+	if err := dev.Execute(ctx, cmd); !errors.Is(err, device.ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter, got %v", err)
+	}
+}
+
+func TestModbus_ExceptionResponse(t *testing.T) {
+	ctx := context.Background()
+	slave := newHVACSlave(t)
+	settings := hvac(slave)
+	settings.Devices[0].Registers = append(settings.Devices[0].Registers,
+		Register{Name: "filter_hours", Table: TableHolding, Address: 40, Writable: true})
+	slave.SetHolding(40, 100)
+	registry := startProvider(t, settings)
+	dev, _ := registry.Get("modbus-hvac")
+
+	// The register disappears from the device's map
+	client := dev.(*Device).client
+	_, err := client.ReadRegisters(ctx, TableHolding, 41, 1)
+	if !errors.Is(err, ErrException) {
+		t.Fatalf("Expected ErrException, got %v", err)
+	}
+	var exception *ExceptionError
+	if !errors.As(err, &exception) || exception.Code != 2 {
+		t.Errorf("Expected illegal data address, got %v", err)
+	}
+	if class := ClassifyError(err); class != resilience.Permanent {
+		t.Errorf("Expected exceptions to be permanent, got %v", class)
+	}
+
+	// The connection survives exceptions
+	if _, err := client.ReadRegisters(ctx, TableHolding, 40, 1); err != nil {
+		t.Errorf("Expected read after an exception to succeed: %v", err)
+	}
+}
+
+func TestModbus_ReconnectsAfterDisconnect(t *testing.T) {
+	slave := newHVACSlave(t)
+	registry := startProvider(t, hvac(slave))
+	dev, _ := registry.Get("modbus-hvac")
+
+	slave.Disconnect()
+	slave.SetHolding(1, 3)
+	waitFor(t, "mode to update after reconnecting", func() bool {
+		state, _ := dev.State(context.Background())
+		return state.Attributes["mode"] == 3
+	})
+}
+
+func TestNewProvider_RejectsInvalidConfig(t *testing.T) {
+	tests := map[string]DeviceConfig{
+		"no address":         {ID: "x", Registers: []Register{{Name: "a", Table: TableHolding}}},
+		"no registers":       {ID: "x", Address: "127.0.0.1"},
+		"unknown table":      {ID: "x", Address: "127.0.0.1", Registers: []Register{{Name: "a", Table: "eeprom"}}},
+		"unknown type":       {ID: "x", Address: "127.0.0.1", Registers: []Register{{Name: "a", Table: TableHolding, Type: "int64"}}},
+		"bad byte order":     {ID: "x", Address: "127.0.0.1", Registers: []Register{{Name: "a", Table: TableHolding, Type: "float32", ByteOrder: "ACBD"}}},
+		"writable input":     {ID: "x", Address: "127.0.0.1", Registers: []Register{{Name: "a", Table: TableInput, Writable: true}}},
+		"typed coil":         {ID: "x", Address: "127.0.0.1", Registers: []Register{{Name: "a", Table: TableCoil, Type: "uint16"}}},
+		"duplicate register": {ID: "x", Address: "127.0.0.1", Registers: []Register{{Name: "a", Table: TableHolding}, {Name: "a", Table: TableInput}}},
+		"duplicate command":  {ID: "x", Address: "127.0.0.1", Registers: []Register{{Name: "a", Table: TableHolding, Writable: true, Command: "set"}, {Name: "b", Table: TableCoil, Writable: true, Command: "set"}}},
+		"unnamed register":   {ID: "x", Address: "127.0.0.1", Registers: []Register{{Table: TableHolding}}},
+	}
+	for name, cfg := range tests {
+		raw, _ := json.Marshal(Settings{Devices: []DeviceConfig{cfg}})
+		if _, err := NewProvider("modbus", raw, provider.Env{}); !errors.Is(err, ErrInvalidRegister) {
+			t.Errorf("%s: expected ErrInvalidRegister, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+// Package modbustest runs a fake Modbus TCP slave for tests. Only addresses that
+// have been given a value exist; requests touching anything else get an illegal
+// data address exception, like a real device with a sparse map.
+package modbustest
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+// Request is one request the slave has answered.
+type Request struct {
+	Function byte
+	Address  uint16
+	Quantity uint16
+}
+
+type Slave struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	holding  map[uint16]uint16
+	input    map[uint16]uint16
+	coils    map[uint16]bool
+	discrete map[uint16]bool
+	requests []Request
+	conns    map[net.Conn]bool
+}
+
+// NewSlave starts a slave with empty tables. It is closed when the test ends.
+func NewSlave(t testing.TB) *Slave {
+	t.Helper()
+
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	s := &Slave{
+		listener: l,
+		holding:  make(map[uint16]uint16),
+		input:    make(map[uint16]uint16),
+		coils:    make(map[uint16]bool),
+		discrete: make(map[uint16]bool),
+		conns:    make(map[net.Conn]bool),
+	}
+	go s.serve()
+	t.Cleanup(func() {
+		l.Close()
+		s.Disconnect()
+	})
+	return s
+}
+
+// Address is the slave's host:port.
+func (s *Slave) Address() string {
+	return s.listener.Addr().String()
+}
+
+// SetHolding sets holding registers starting at address.
+func (s *Slave) SetHolding(address uint16, values ...uint16) {
+	s.setRegisters(s.holding, address, values)
+}
+
+// SetInput sets input registers starting at address.
+func (s *Slave) SetInput(address uint16, values ...uint16) {
+	s.setRegisters(s.input, address, values)
+}
+
+// SetCoil sets coils starting at address.
+func (s *Slave) SetCoil(address uint16, values ...bool) {
+	s.setBits(s.coils, address, values)
+}
+
+// SetDiscrete sets discrete inputs starting at address.
+func (s *Slave) SetDiscrete(address uint16, values ...bool) {
+	s.setBits(s.discrete, address, values)
+}
+
+func (s *Slave) setRegisters(table map[uint16]uint16, address uint16, values []uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range values {
+		table[address+uint16(i)] = v
+	}
+}
+
+func (s *Slave) setBits(table map[uint16]bool, address uint16, values []bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range values {
+		table[address+uint16(i)] = v
+	}
+}
+
+// Holding returns a holding register.
+func (s *Slave) Holding(address uint16) uint16 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.holding[address]
+}
+
+// Coil returns a coil.
+func (s *Slave) Coil(address uint16) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.coils[address]
+}
+
+// Requests returns the requests answered so far, including failed ones.
+func (s *Slave) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Disconnect drops every open connection; the slave keeps accepting new ones.
+func (s *Slave) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+func (s *Slave) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+
+		go func() {
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+				conn.Close()
+			}()
+			for {
+				var header [7]byte
+				if _, err := io.ReadFull(conn, header[:]); err != nil {
+					return
+				}
+				length := binary.BigEndian.Uint16(header[4:])
+				if length < 2 {
+					return
+				}
+				pdu := make([]byte, length-1)
+				if _, err := io.ReadFull(conn, pdu); err != nil {
+					return
+				}
+
+				reply := s.handle(pdu)
+				binary.BigEndian.PutUint16(header[4:], uint16(len(reply)+1))
+				if _, err := conn.Write(append(header[:], reply...)); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Exception codes
+const (
+	illegalFunction    = 1
+	illegalDataAddress = 2
+	illegalDataValue   = 3
+)
+
+// handle answers one request PDU.
+func (s *Slave) handle(pdu []byte) []byte {
+	fc := pdu[0]
+	if len(pdu) < 5 {
+		return []byte{fc | 0x80, illegalDataValue}
+	}
+	address := binary.BigEndian.Uint16(pdu[1:])
+	arg := binary.BigEndian.Uint16(pdu[3:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req := Request{Function: fc, Address: address, Quantity: 1}
+	if fc <= 4 || fc == 0x10 {
+		req.Quantity = arg
+	}
+	s.requests = append(s.requests, req)
+
+	switch fc {
+	case 1, 2:
+		table := s.coils
+		if fc == 2 {
+			table = s.discrete
+		}
+		if arg == 0 || arg > 2000 {
+			return []byte{fc | 0x80, illegalDataValue}
+		}
+		reply := make([]byte, 2+(arg+7)/8)
+		reply[0], reply[1] = fc, byte((arg+7)/8)
+		for i := range arg {
+			v, ok := table[address+i]
+			if !ok {
+				return []byte{fc | 0x80, illegalDataAddress}
+			}
+			if v {
+				reply[2+i/8] |= 1 << (i % 8)
+			}
+		}
+		return reply
+
+	case 3, 4:
+		table := s.holding
+		if fc == 4 {
+			table = s.input
+		}
+		if arg == 0 || arg > 125 {
+			return []byte{fc | 0x80, illegalDataValue}
+		}
+		reply := []byte{fc, byte(2 * arg)}
+		for i := range arg {
+			v, ok := table[address+i]
+			if !ok {
+				return []byte{fc | 0x80, illegalDataAddress}
+			}
+			reply = binary.BigEndian.AppendUint16(reply, v)
+		}
+		return reply
+
+	case 5:
+		if _, ok := s.coils[address]; !ok {
+			return []byte{fc | 0x80, illegalDataAddress}
+		}
+		if arg != 0 && arg != 0xff00 {
+			return []byte{fc | 0x80, illegalDataValue}
+		}
+		s.coils[address] = arg == 0xff00
+		return pdu[:5]
+
+	case 6:
+		if _, ok := s.holding[address]; !ok {
+			return []byte{fc | 0x80, illegalDataAddress}
+		}
+		s.holding[address] = arg
+		return pdu[:5]
+
+	case 0x10:
+		if len(pdu) < 6 || arg == 0 || int(pdu[5]) != 2*int(arg) || len(pdu) < 6+2*int(arg) {
+			return []byte{fc | 0x80, illegalDataValue}
+		}
+		for i := range arg {
+			if _, ok := s.holding[address+i]; !ok {
+				return []byte{fc | 0x80, illegalDataAddress}
+			}
+		}
+		for i := range arg {
+			s.holding[address+i] = binary.BigEndian.Uint16(pdu[6+2*i:])
+		}
+		return pdu[:5]
+	}
+	return []byte{fc | 0x80, illegalFunction}
+}
@@ -0,0 +1,230 @@
+// Package modbus integrates Modbus TCP devices such as HVAC controllers and energy
+// meters. Devices are declared in the config as register maps; readable registers
+// become attributes, refreshed by polling, and writable ones become commands.
+package modbus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+func init() {
+	provider.RegisterFactory("modbus", NewProvider)
+}
+
+const defaultPollInterval = 10 * time.Second
+
+type Settings struct {
+	Devices    []DeviceConfig      `json:"devices"`
+	Resilience resilience.Settings `json:"resilience"`
+}
+
+type DeviceConfig struct {
+	// ID makes the device ID modbus-<id>
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// Type is the hub device type, "sensor" by default
+	Type    string `json:"type,omitempty"`
+	Address string `json:"address"`
+	// UnitID defaults to 1; gateways use it to pick the device behind them
+	UnitID       *uint8          `json:"unit_id,omitempty"`
+	PollInterval config.Duration `json:"poll_interval"`
+	Registers    []Register      `json:"registers"`
+}
+
+// validate checks the device and its registers, filling in defaults.
+func (c *DeviceConfig) validate() error {
+	if c.ID == "" || c.Address == "" {
+		return fmt.Errorf("%w: devices need an id and an address", ErrInvalidRegister)
+	}
+	c.ID = "modbus-" + c.ID
+	if c.Type == "" {
+		c.Type = "sensor"
+	}
+	if c.UnitID == nil {
+		unit := uint8(1)
+		c.UnitID = &unit
+	}
+	if c.PollInterval <= 0 {
+		c.PollInterval = config.Duration(defaultPollInterval)
+	}
+	if len(c.Registers) == 0 {
+		return fmt.Errorf("%w: %s has no registers", ErrInvalidRegister, c.ID)
+	}
+
+	names := make(map[string]bool)
+	commands := make(map[string]bool)
+	for i := range c.Registers {
+		r := &c.Registers[i]
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("%s: %w", c.ID, err)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("%w: %s: duplicate register %s", ErrInvalidRegister, c.ID, r.Name)
+		}
+		names[r.Name] = true
+		if r.Writable {
+			if commands[r.Command] {
+				return fmt.Errorf("%w: %s: duplicate command %s", ErrInvalidRegister, c.ID, r.Command)
+			}
+			commands[r.Command] = true
+		}
+	}
+	return nil
+}
+
+type Provider struct {
+	name     string
+	settings Settings
+	guards   *resilience.Registry
+
+	mu        sync.Mutex
+	registry  provider.Registry
+	devices   map[device.ID]*Device
+	cancel    context.CancelFunc
+	listenCtx context.Context
+	wg        sync.WaitGroup
+}
+
+func NewProvider(name string, raw json.RawMessage, env provider.Env) (provider.Provider, error) {
+	var settings Settings
+	if err := provider.DecodeSettings(raw, &settings); err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool)
+	for i := range settings.Devices {
+		cfg := &settings.Devices[i]
+		if err := cfg.validate(); err != nil {
+			return nil, err
+		}
+		if ids[cfg.ID] {
+			return nil, fmt.Errorf("%w: duplicate device %s", ErrInvalidRegister, cfg.ID)
+		}
+		ids[cfg.ID] = true
+	}
+
+	guards := env.Guards
+	if guards == nil {
+		guards = resilience.NewRegistry()
+	}
+	guards.Configure(name, settings.Resilience.Apply(resilience.DefaultConfig()), ClassifyError)
+
+	return &Provider{
+		name:     name,
+		settings: settings,
+		guards:   guards,
+		devices:  make(map[device.ID]*Device),
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+func (p *Provider) Start(ctx context.Context, registry provider.Registry) error {
+	if len(p.settings.Devices) == 0 {
+		return fmt.Errorf("%w: no devices", provider.ErrNotConfigured)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.registry = registry
+	p.listenCtx, p.cancel = context.WithCancel(context.Background())
+	return nil
+}
+
+// Discover polls each device that hasn't been reached yet and registers it once a
+// poll succeeds. Polling continues in the background from then on.
+func (p *Provider) Discover(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var errs []error
+	for _, cfg := range p.settings.Devices {
+		id := device.ID(cfg.ID)
+		if _, ok := p.devices[id]; ok {
+			continue
+		}
+
+		client := NewClient(cfg.Address, *cfg.UnitID)
+		d := newDevice(cfg, client, p.guards.Guard(p.name, client.address))
+		if err := d.poll(ctx); err != nil {
+			d.client.Close()
+			errs = append(errs, fmt.Errorf("%s: %w", id, err))
+			continue
+		}
+		if err := p.registry.Register(d); err != nil {
+			d.client.Close()
+			if !errors.Is(err, device.ErrDeviceAlreadyRegistered) {
+				errs = append(errs, fmt.Errorf("%s: %w", id, err))
+			}
+			continue
+		}
+		p.devices[id] = d
+		log.Printf("modbus: registered %s at %s (unit %d, %d registers in %d reads)",
+			id, d.client.address, *cfg.UnitID, len(cfg.Registers), len(d.blocks))
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			d.run(p.listenCtx)
+		}()
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Provider) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	cancel := p.cancel
+	p.cancel = nil
+	devices := p.devices
+	for id := range devices {
+		p.registry.Unregister(id)
+	}
+	p.devices = make(map[device.ID]*Device)
+	p.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		p.wg.Wait()
+	}
+	for _, d := range devices {
+		d.client.Close()
+	}
+	return nil
+}
+
+// Health reports the breaker state of each device, and degraded while a configured
+// device hasn't been reached.
+func (p *Provider) Health(ctx context.Context) provider.Health {
+	p.mu.Lock()
+	var missing []string
+	for _, cfg := range p.settings.Devices {
+		if _, ok := p.devices[device.ID(cfg.ID)]; !ok {
+			missing = append(missing, cfg.ID)
+		}
+	}
+	p.mu.Unlock()
+
+	health := provider.Health{Status: provider.HealthOK}
+	if guards, ok := p.guards.ProviderHealth(p.name); ok {
+		health.Status = guards.Status
+		health.Details = guards
+	}
+	if health.Status == provider.HealthOK && len(missing) > 0 {
+		health.Status = provider.HealthDegraded
+		health.Message = fmt.Sprintf("not reached: %v", missing)
+	}
+	return health
+}
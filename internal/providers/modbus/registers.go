@@ -0,0 +1,236 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+)
+
+var ErrInvalidRegister = errors.New("invalid register definition")
+
+// Table is one of the four Modbus data tables.
+type Table string
+
+const (
+	TableHolding  Table = "holding"
+	TableInput    Table = "input"
+	TableCoil     Table = "coil"
+	TableDiscrete Table = "discrete"
+)
+
+func (t Table) bits() bool {
+	return t == TableCoil || t == TableDiscrete
+}
+
+// Register maps one value in a device's tables to an attribute, and to a command if
+// it is writable.
+type Register struct {
+	// Name is the attribute name
+	Name    string `json:"name"`
+	Table   Table  `json:"table"`
+	Address uint16 `json:"address"`
+	// Type is int16, uint16, int32, uint32 or float32; coils and discrete inputs are
+	// always bool
+	Type string `json:"type,omitempty"`
+	// ByteOrder of 32-bit values, as the letters of a big-endian ABCD: ABCD (the
+	// default), DCBA, BADC or CDAB
+	ByteOrder string `json:"byte_order,omitempty"`
+	// Value = raw * Scale + Offset; Scale defaults to 1
+	Scale  float64 `json:"scale,omitempty"`
+	Offset float64 `json:"offset,omitempty"`
+	// Writable holding registers and coils are set with Command (set_<name> by
+	// default) and a "value" param
+	Writable bool   `json:"writable,omitempty"`
+	Command  string `json:"command,omitempty"`
+}
+
+// validate checks the definition and fills in defaults.
+func (r *Register) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("%w: missing name", ErrInvalidRegister)
+	}
+	switch r.Table {
+	case TableHolding, TableInput:
+		if r.Type == "" {
+			r.Type = "uint16"
+		}
+		if !slices.Contains([]string{"int16", "uint16", "int32", "uint32", "float32"}, r.Type) {
+			return fmt.Errorf("%w: %s: unknown type %q", ErrInvalidRegister, r.Name, r.Type)
+		}
+	case TableCoil, TableDiscrete:
+		if r.Type != "" && r.Type != "bool" {
+			return fmt.Errorf("%w: %s: %s values are bool", ErrInvalidRegister, r.Name, r.Table)
+		}
+		r.Type = "bool"
+	default:
+		return fmt.Errorf("%w: %s: unknown table %q", ErrInvalidRegister, r.Name, r.Table)
+	}
+
+	r.ByteOrder = strings.ToUpper(r.ByteOrder)
+	if r.ByteOrder == "" {
+		r.ByteOrder = "ABCD"
+	}
+	if !slices.Contains([]string{"ABCD", "DCBA", "BADC", "CDAB"}, r.ByteOrder) {
+		return fmt.Errorf("%w: %s: unknown byte order %q", ErrInvalidRegister, r.Name, r.ByteOrder)
+	}
+	if r.Scale == 0 {
+		r.Scale = 1
+	}
+	if r.Writable {
+		if r.Table != TableHolding && r.Table != TableCoil {
+			return fmt.Errorf("%w: %s: %s registers are read-only", ErrInvalidRegister, r.Name, r.Table)
+		}
+		if r.Command == "" {
+			r.Command = "set_" + r.Name
+		}
+	}
+	return nil
+}
+
+// size is the number of registers or bits the value occupies.
+func (r *Register) size() uint16 {
+	switch r.Type {
+	case "int32", "uint32", "float32":
+		return 2
+	}
+	return 1
+}
+
+// scaled reports whether values need to be floats.
+func (r *Register) scaled() bool {
+	return r.Scale != 1 || r.Offset != 0
+}
+
+// decode turns raw registers into the attribute value: an int, or a float64 for
+// float32 and scaled values.
+func (r *Register) decode(regs []uint16) any {
+	var raw float64
+	switch r.Type {
+	case "int16":
+		raw = float64(int16(regs[0]))
+	case "uint16":
+		raw = float64(regs[0])
+	default:
+		b := binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(nil, regs[0]), regs[1])
+		r.swap(b)
+		bits := binary.BigEndian.Uint32(b)
+		switch r.Type {
+		case "int32":
+			raw = float64(int32(bits))
+		case "uint32":
+			raw = float64(bits)
+		case "float32":
+			return float64(math.Float32frombits(bits))*r.Scale + r.Offset
+		}
+	}
+	if !r.scaled() {
+		return int(raw)
+	}
+	// Round off the noise of scales like 0.1 so 194 reads as 19.4
+	return math.Round((raw*r.Scale+r.Offset)*1e9) / 1e9
+}
+
+// encode turns a value into registers, undoing the scaling.
+func (r *Register) encode(value float64) ([]uint16, error) {
+	raw := (value - r.Offset) / r.Scale
+	if r.Type != "float32" {
+		raw = math.Round(raw)
+	}
+
+	var bits uint32
+	switch r.Type {
+	case "int16":
+		if raw < math.MinInt16 || raw > math.MaxInt16 {
+			return nil, fmt.Errorf("%w: %g is out of range for %s", device.ErrInvalidParameter, value, r.Name)
+		}
+		return []uint16{uint16(int16(raw))}, nil
+	case "uint16":
+		if raw < 0 || raw > math.MaxUint16 {
+			return nil, fmt.Errorf("%w: %g is out of range for %s", device.ErrInvalidParameter, value, r.Name)
+		}
+		return []uint16{uint16(raw)}, nil
+	case "int32":
+		if raw < math.MinInt32 || raw > math.MaxInt32 {
+			return nil, fmt.Errorf("%w: %g is out of range for %s", device.ErrInvalidParameter, value, r.Name)
+		}
+		bits = uint32(int32(raw))
+	case "uint32":
+		if raw < 0 || raw > math.MaxUint32 {
+			return nil, fmt.Errorf("%w: %g is out of range for %s", device.ErrInvalidParameter, value, r.Name)
+		}
+		bits = uint32(raw)
+	case "float32":
+		bits = math.Float32bits(float32(raw))
+	}
+
+	b := binary.BigEndian.AppendUint32(nil, bits)
+	r.swap(b)
+	return []uint16{binary.BigEndian.Uint16(b), binary.BigEndian.Uint16(b[2:])}, nil
+}
+
+// swap converts four bytes between the wire layout and big-endian ABCD, in place.
+// Every supported order is its own inverse.
+func (r *Register) swap(b []byte) {
+	switch r.ByteOrder {
+	case "DCBA":
+		b[0], b[1], b[2], b[3] = b[3], b[2], b[1], b[0]
+	case "BADC":
+		b[0], b[1], b[2], b[3] = b[1], b[0], b[3], b[2]
+	case "CDAB":
+		b[0], b[1], b[2], b[3] = b[2], b[3], b[0], b[1]
+	}
+}
+
+// block is one read request covering several registers.
+type block struct {
+	table    Table
+	address  uint16
+	quantity uint16
+	members  []*Register
+}
+
+// Per-request limits from the spec
+const (
+	maxReadRegisters = 125
+	maxReadBits      = 2000
+)
+
+// plan groups registers into as few reads as possible: neighbours in the same table
+// are read together as long as the request stays within the spec's limits.
+func plan(registers []Register) []block {
+	sorted := make([]*Register, len(registers))
+	for i := range registers {
+		sorted[i] = &registers[i]
+	}
+	slices.SortFunc(sorted, func(a, b *Register) int {
+		if c := strings.Compare(string(a.Table), string(b.Table)); c != 0 {
+			return c
+		}
+		return int(a.Address) - int(b.Address)
+	})
+
+	var blocks []block
+	for _, r := range sorted {
+		end := uint32(r.Address) + uint32(r.size())
+		if n := len(blocks); n > 0 {
+			last := &blocks[n-1]
+			limit := uint32(maxReadRegisters)
+			if r.Table.bits() {
+				limit = maxReadBits
+			}
+			lastEnd := uint32(last.address) + uint32(last.quantity)
+			if last.table == r.Table && uint32(r.Address) <= lastEnd && max(end, lastEnd)-uint32(last.address) <= limit {
+				last.quantity = uint16(max(end, lastEnd) - uint32(last.address))
+				last.members = append(last.members, r)
+				continue
+			}
+		}
+		blocks = append(blocks, block{table: r.Table, address: r.Address, quantity: r.size(), members: []*Register{r}})
+	}
+	return blocks
+}
@@ -0,0 +1,273 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/mqttclient"
+)
+
+type DeviceConfig struct {
+	ID         device.ID `json:"id"`
+	DeviceType string    `json:"device_type"`
+
+	// StateTopic carries the device state, usually as JSON. Each attribute is
+	// extracted from the payload with a path like "$.brightness".
+	StateTopic string                      `json:"state_topic"`
+	Attributes map[string]AttributeMapping `json:"attributes"`
+
+	// Commands maps hub actions to a topic and payload. Both are Go templates with the
+	// command params plus .id and .action available. Params are inserted as they
+	// are, so payloads should quote them with json, e.g. {"brightness": {{json .value}}}.
+	// A param that would add a level or a wildcard to the topic is refused.
+	Commands map[string]CommandMapping `json:"commands"`
+
+	AvailabilityTopic   string `json:"availability_topic"`
+	PayloadAvailable    string `json:"payload_available"`
+	PayloadNotAvailable string `json:"payload_not_available"`
+}
+
+type AttributeMapping struct {
+	Path string `json:"path"`
+	// Values optionally translates raw values, e.g. {"ON": "on", "OFF": "off"}
+	Values map[string]any `json:"values,omitempty"`
+}
+
+type CommandMapping struct {
+	Topic   string `json:"topic"`
+	Payload string `json:"payload"`
+	Retain  bool   `json:"retain"`
+}
+
+type command struct {
+	topic   *template.Template
+	payload *template.Template
+	retain  bool
+}
+
+// Device is a device controlled through configured MQTT topics.
+type Device struct {
+	cfg      DeviceConfig
+	client   *mqttclient.Client
+	commands map[string]command
+	// subs are the state and availability subscriptions
+	subs []mqttclient.Subscription
+
+	mu         sync.RWMutex
+	attributes map[string]any
+	available  bool
+	updatedAt  time.Time
+}
+
+func NewDevice(cfg DeviceConfig, client *mqttclient.Client) (*Device, error) {
+	if cfg.ID == "" {
+		return nil, errors.New("device id is required")
+	}
+	if cfg.DeviceType == "" {
+		cfg.DeviceType = "generic"
+	}
+	if cfg.PayloadAvailable == "" {
+		cfg.PayloadAvailable = "online"
+	}
+	if cfg.PayloadNotAvailable == "" {
+		cfg.PayloadNotAvailable = "offline"
+	}
+
+	d := &Device{
+		cfg:        cfg,
+		client:     client,
+		commands:   make(map[string]command, len(cfg.Commands)),
+		attributes: make(map[string]any),
+		// Without an availability topic we have no way of knowing, assume online
+		available: true,
+		updatedAt: time.Now(),
+	}
+
+	for action, c := range cfg.Commands {
+		topic, err := template.New(action + " topic").Option("missingkey=error").Funcs(templateFuncs).Parse(c.Topic)
+		if err != nil {
+			return nil, fmt.Errorf("device %s: invalid topic for %s: %w", cfg.ID, action, err)
+		}
+		payload, err := template.New(action + " payload").Option("missingkey=error").Funcs(templateFuncs).Parse(c.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("device %s: invalid payload for %s: %w", cfg.ID, action, err)
+		}
+		d.commands[action] = command{topic: topic, payload: payload, retain: c.Retain}
+	}
+
+	return d, nil
+}
+
+func (d *Device) ID() device.ID {
+	return d.cfg.ID
+}
+
+// subscribe starts listening on the device's state and availability topics.
+func (d *Device) subscribe(ctx context.Context) error {
+	if d.cfg.StateTopic != "" {
+		sub, err := d.client.Subscribe(ctx, d.cfg.StateTopic, d.handleState)
+		d.subs = append(d.subs, sub)
+		if err != nil {
+			return fmt.Errorf("failed to subscribe to %s: %w", d.cfg.StateTopic, err)
+		}
+	}
+	if d.cfg.AvailabilityTopic != "" {
+		sub, err := d.client.Subscribe(ctx, d.cfg.AvailabilityTopic, d.handleAvailability)
+		d.subs = append(d.subs, sub)
+		if err != nil {
+			return fmt.Errorf("failed to subscribe to %s: %w", d.cfg.AvailabilityTopic, err)
+		}
+	}
+	return nil
+}
+
+// unsubscribe stops listening, leaving other devices on the same topics be.
+func (d *Device) unsubscribe(ctx context.Context) {
+	d.client.Unsubscribe(ctx, d.subs...)
+	d.subs = nil
+}
+
+func (d *Device) handleState(topic string, payload []byte) {
+	var decoded any
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		// Plain payloads like "ON" or "21.5"
+		decoded = parseScalar(string(payload))
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for name, mapping := range d.cfg.Attributes {
+		v, ok := extractPath(decoded, mapping.Path)
+		if !ok {
+			continue
+		}
+		if mapped, ok := mapping.Values[fmt.Sprint(v)]; ok {
+			v = mapped
+		}
+		d.attributes[name] = v
+	}
+	d.updatedAt = time.Now()
+}
+
+func (d *Device) handleAvailability(topic string, payload []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch strings.TrimSpace(string(payload)) {
+	case d.cfg.PayloadAvailable:
+		d.available = true
+	case d.cfg.PayloadNotAvailable:
+		d.available = false
+	}
+}
+
+func (d *Device) Execute(ctx context.Context, cmd device.Command) error {
+	c, ok := d.commands[cmd.Action]
+	if !ok {
+		return fmt.Errorf("%w: %s", device.ErrUnknownCommand, cmd.Action)
+	}
+
+	d.mu.RLock()
+	available := d.available
+	d.mu.RUnlock()
+	if !available {
+		return fmt.Errorf("%w: %s is offline", device.ErrDeviceUnavailable, d.cfg.ID)
+	}
+
+	data := make(map[string]any, len(cmd.Params)+2)
+	for k, v := range cmd.Params {
+		data[k] = v
+	}
+	data["id"] = string(d.cfg.ID)
+	data["action"] = cmd.Action
+
+	topic, err := render(c.topic, data)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", device.ErrInvalidParameter, cmd.Action, err)
+	}
+	if err := checkTopic(c.topic, data, cmd.Params, topic); err != nil {
+		return fmt.Errorf("%w: %s: %v", device.ErrInvalidParameter, cmd.Action, err)
+	}
+	payload, err := render(c.payload, data)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", device.ErrInvalidParameter, cmd.Action, err)
+	}
+
+	return d.client.Publish(ctx, topic, []byte(payload), c.retain)
+}
+
+// templateFuncs are available in command templates: json quotes a param for a
+// JSON payload.
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func render(t *template.Template, data map[string]any) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// checkTopic refuses a rendered topic that can't be published to, or that one of
+// params has given an extra level: a param with a separator must not be used in the
+// topic, which shows as the topic rendering the same without it.
+func checkTopic(t *template.Template, data, params map[string]any, topic string) error {
+	if topic == "" || strings.ContainsAny(topic, "+#\x00") {
+		return fmt.Errorf("can't publish to topic %q", topic)
+	}
+	for key, v := range params {
+		s, ok := v.(string)
+		if !ok || !strings.Contains(s, "/") {
+			continue
+		}
+		data[key] = ""
+		without, err := render(t, data)
+		data[key] = s
+		if err != nil || without != topic {
+			return fmt.Errorf("%s can't contain / in topic %q", key, topic)
+		}
+	}
+	return nil
+}
+
+func (d *Device) State(ctx context.Context) (device.State, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	attributes := make(map[string]interface{}, len(d.attributes)+1)
+	for k, v := range d.attributes {
+		attributes[k] = v
+	}
+	attributes["available"] = d.available
+
+	return device.State{
+		DeviceType: d.cfg.DeviceType,
+		UpdatedAt:  d.updatedAt,
+		Attributes: attributes,
+	}, nil
+}
+
+func parseScalar(s string) any {
+	s = strings.TrimSpace(s)
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	if b, err := strconv.ParseBool(s); err == nil {
+		return b
+	}
+	return s
+}
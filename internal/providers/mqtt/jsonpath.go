@@ -0,0 +1,70 @@
+package mqtt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// extractPath walks a decoded JSON value along a simple path such as "$.state",
+// "color.x" or "$.sensors[0].value". An empty path or "$" returns v itself.
+func extractPath(v any, path string) (any, bool) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return v, true
+	}
+
+	for _, part := range strings.Split(path, ".") {
+		name, indexes, err := splitIndexes(part)
+		if err != nil {
+			return nil, false
+		}
+
+		if name != "" {
+			obj, ok := v.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			if v, ok = obj[name]; !ok {
+				return nil, false
+			}
+		}
+
+		for _, i := range indexes {
+			arr, ok := v.([]any)
+			if !ok || i < 0 || i >= len(arr) {
+				return nil, false
+			}
+			v = arr[i]
+		}
+	}
+	return v, true
+}
+
+// splitIndexes splits "name[1][2]" into "name" and [1 2].
+func splitIndexes(part string) (string, []int, error) {
+	open := strings.IndexByte(part, '[')
+	if open < 0 {
+		return part, nil, nil
+	}
+
+	name := part[:open]
+	var indexes []int
+	rest := part[open:]
+	for rest != "" {
+		if rest[0] != '[' {
+			return "", nil, fmt.Errorf("invalid path segment %q", part)
+		}
+		end := strings.IndexByte(rest, ']')
+		if end < 0 {
+			return "", nil, fmt.Errorf("invalid path segment %q", part)
+		}
+		i, err := strconv.Atoi(rest[1:end])
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid index in %q", part)
+		}
+		indexes = append(indexes, i)
+		rest = rest[end+1:]
+	}
+	return name, indexes, nil
+}
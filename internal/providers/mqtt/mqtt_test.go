@@ -0,0 +1,200 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/mqttclient/mqtttest"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+)
+
+const testSettings = `{
+	"devices": [{
+		"id": "kitchen-light",
+		"device_type": "light",
+		"state_topic": "home/kitchen/light/state",
+		"attributes": {
+			"power": {"path": "$.state", "values": {"ON": "on", "OFF": "off"}},
+			"brightness": {"path": "$.brightness"},
+			"temperature": {"path": "$.sensors[0].value"}
+		},
+		"commands": {
+			"turn_on": {"topic": "home/kitchen/light/set", "payload": "{\"state\":\"ON\"}"},
+			"set_brightness": {"topic": "home/{{.id}}/set", "payload": "{\"brightness\":{{.value}}}"},
+			"set_scene": {"topic": "home/{{.room}}/scene", "payload": "{\"scene\":{{json .name}}}"}
+		},
+		"availability_topic": "home/kitchen/light/availability"
+	}]
+}`
+
+func startProvider(t *testing.T) (*device.Registry, string) {
+	t.Helper()
+	broker := mqtttest.NewBroker(t)
+
+	var settings map[string]any
+	json.Unmarshal([]byte(testSettings), &settings)
+	settings["broker"] = broker
+	raw, _ := json.Marshal(settings)
+
+	p, err := NewProvider("mqtt", raw, provider.Env{})
+	if err != nil {
+		t.Fatalf("NewProvider failed: %v", err)
+	}
+
+	registry := device.NewRegistry()
+	m := provider.NewManager(registry, provider.Env{})
+	m.Add(p, "mqtt")
+	m.StartAll(context.Background())
+	t.Cleanup(func() { m.StopAll(context.Background()) })
+
+	if status, _ := m.Status("mqtt"); status.State != provider.StateRunning || status.Error != "" {
+		t.Fatalf("Expected provider to be running, got %+v", status)
+	}
+	return registry, broker
+}
+
+func waitForState(t *testing.T, dev device.Device, cond func(device.State) bool) device.State {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		state, _ := dev.State(context.Background())
+		if cond(state) {
+			return state
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for state, last: %+v", state.Attributes)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMQTTDevice_StateFromTopic(t *testing.T) {
+	ctx := context.Background()
+	registry, broker := startProvider(t)
+	client := mqtttest.Connect(t, broker)
+
+	dev, err := registry.Get("kitchen-light")
+	if err != nil {
+		t.Fatalf("Expected kitchen-light to be registered: %v", err)
+	}
+
+	payload := `{"state":"ON","brightness":42,"sensors":[{"value":21.5}]}`
+	if err := client.Publish(ctx, "home/kitchen/light/state", []byte(payload), false); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	state := waitForState(t, dev, func(s device.State) bool { return s.Attributes["power"] == "on" })
+	if state.DeviceType != "light" {
+		t.Errorf("Expected device_type light, got %s", state.DeviceType)
+	}
+	if state.Attributes["brightness"] != float64(42) {
+		t.Errorf("Expected brightness 42, got %v", state.Attributes["brightness"])
+	}
+	if state.Attributes["temperature"] != 21.5 {
+		t.Errorf("Expected temperature 21.5, got %v", state.Attributes["temperature"])
+	}
+}
+
+func TestMQTTDevice_ExecutePublishesCommand(t *testing.T) {
+	ctx := context.Background()
+	registry, broker := startProvider(t)
+	client := mqtttest.Connect(t, broker)
+	messages := mqtttest.Subscribe(t, client, "home/#")
+
+	dev, _ := registry.Get("kitchen-light")
+
+	cmd := device.Command{DeviceID: dev.ID(), Action: "set_brightness", Params: map[string]any{"value": float64(60)}}
+	if err := dev.Execute(ctx, cmd); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	msg := messages.Next(t)
+	if msg.Topic != "home/kitchen-light/set" || msg.Payload != `{"brightness":60}` {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+
+	err := dev.Execute(ctx, device.Command{DeviceID: dev.ID(), Action: "set_brightness"})
+	if !errors.Is(err, device.ErrInvalidParameter) {
+		t.Errorf("Expected a missing value to be rejected, got %v", err)
+	}
+	err = dev.Execute(ctx, device.Command{DeviceID: dev.ID(), Action: "self_destruct"})
+	if !errors.Is(err, device.ErrUnknownCommand) {
+		t.Errorf("Expected ErrUnknownCommand, got %v", err)
+	}
+}
+
+func TestMQTTDevice_ParamsCantEscapeTemplates(t *testing.T) {
+	ctx := context.Background()
+	registry, broker := startProvider(t)
+	client := mqtttest.Connect(t, broker)
+	messages := mqtttest.Subscribe(t, client, "home/#")
+
+	dev, _ := registry.Get("kitchen-light")
+
+	params := map[string]any{"room": "kitchen", "name": `evening", "state": "OFF`}
+	if err := dev.Execute(ctx, device.Command{DeviceID: dev.ID(), Action: "set_scene", Params: params}); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	msg := messages.Next(t)
+	if msg.Topic != "home/kitchen/scene" || msg.Payload != `{"scene":"evening\", \"state\": \"OFF"}` {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+
+	for _, room := range []string{"kitchen/light", "#", "+", "kitchen\x00"} {
+		params := map[string]any{"room": room, "name": "evening"}
+		err := dev.Execute(ctx, device.Command{DeviceID: dev.ID(), Action: "set_scene", Params: params})
+		if !errors.Is(err, device.ErrInvalidParameter) {
+			t.Errorf("Expected room %q to be refused, got %v", room, err)
+		}
+	}
+
+	// A separator is fine in a param that only goes in the payload
+	params = map[string]any{"room": "kitchen", "name": "movies/late"}
+	if err := dev.Execute(ctx, device.Command{DeviceID: dev.ID(), Action: "set_scene", Params: params}); err != nil {
+		t.Errorf("Expected a separator in the payload to be allowed, got %v", err)
+	}
+}
+
+func TestMQTTDevice_Availability(t *testing.T) {
+	ctx := context.Background()
+	registry, broker := startProvider(t)
+	client := mqtttest.Connect(t, broker)
+
+	dev, _ := registry.Get("kitchen-light")
+	client.Publish(ctx, "home/kitchen/light/availability", []byte("offline"), false)
+
+	waitForState(t, dev, func(s device.State) bool { return s.Attributes["available"] == false })
+
+	err := dev.Execute(ctx, device.Command{DeviceID: dev.ID(), Action: "turn_on"})
+	if !errors.Is(err, device.ErrDeviceUnavailable) {
+		t.Errorf("Expected ErrDeviceUnavailable, got %v", err)
+	}
+}
+
+func TestExtractPath(t *testing.T) {
+	var v any
+	json.Unmarshal([]byte(`{"a":{"b":[1,{"c":"x"}]},"d":true}`), &v)
+
+	cases := []struct {
+		path string
+		want any
+		ok   bool
+	}{
+		{"$.d", true, true},
+		{"a.b[0]", float64(1), true},
+		{"$.a.b[1].c", "x", true},
+		{"$.a.b[5]", nil, false},
+		{"$.missing", nil, false},
+		{"$.a.b[x]", nil, false},
+	}
+	for _, c := range cases {
+		got, ok := extractPath(v, c.path)
+		if ok != c.ok || (ok && got != c.want) {
+			t.Errorf("extractPath(%q) = %v, %v; want %v, %v", c.path, got, ok, c.want, c.ok)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/mqttclient"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+)
+
+func init() {
+	provider.RegisterFactory("mqtt", NewProvider)
+}
+
+type Settings struct {
+	mqttclient.Settings
+	Devices []DeviceConfig `json:"devices"`
+}
+
+// Provider maps generic MQTT devices described in the config to hub devices.
+type Provider struct {
+	name     string
+	settings Settings
+
+	mu       sync.Mutex
+	registry provider.Registry
+	client   *mqttclient.Client
+	devices  map[device.ID]*Device
+}
+
+func NewProvider(name string, raw json.RawMessage, env provider.Env) (provider.Provider, error) {
+	var settings Settings
+	if err := provider.DecodeSettings(raw, &settings); err != nil {
+		return nil, err
+	}
+	if settings.ClientID == "" {
+		settings.ClientID = "smarthomehub-" + name
+	}
+
+	seen := make(map[device.ID]bool)
+	for _, d := range settings.Devices {
+		if d.ID == "" {
+			return nil, errors.New("every mqtt device needs an id")
+		}
+		if seen[d.ID] {
+			return nil, fmt.Errorf("mqtt device %s configured twice", d.ID)
+		}
+		seen[d.ID] = true
+	}
+
+	return &Provider{
+		name:     name,
+		settings: settings,
+		devices:  make(map[device.ID]*Device),
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+func (p *Provider) Start(ctx context.Context, registry provider.Registry) error {
+	if p.settings.Broker == "" {
+		return fmt.Errorf("%w: broker is required", provider.ErrNotConfigured)
+	}
+
+	client, err := mqttclient.Connect(ctx, p.settings.Settings)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.registry = registry
+	p.client = client
+	return nil
+}
+
+func (p *Provider) Discover(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var errs []error
+	for _, cfg := range p.settings.Devices {
+		if _, exists := p.devices[cfg.ID]; exists {
+			continue
+		}
+
+		d, err := NewDevice(cfg, p.client)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := d.subscribe(ctx); err != nil {
+			d.unsubscribe(ctx)
+			errs = append(errs, err)
+			continue
+		}
+		if err := p.registry.Register(d); err != nil {
+			d.unsubscribe(ctx)
+			errs = append(errs, fmt.Errorf("failed to register %s: %w", cfg.ID, err))
+			continue
+		}
+		p.devices[cfg.ID] = d
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Provider) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id := range p.devices {
+		p.registry.Unregister(id)
+	}
+	p.devices = make(map[device.ID]*Device)
+	if p.client != nil {
+		p.client.Close()
+		p.client = nil
+	}
+	return nil
+}
+
+func (p *Provider) Health(ctx context.Context) provider.Health {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.client == nil || !p.client.IsConnected() {
+		return provider.Health{Status: provider.HealthDown, Message: "not connected to broker"}
+	}
+
+	offline := 0
+	for _, d := range p.devices {
+		d.mu.RLock()
+		if !d.available {
+			offline++
+		}
+		d.mu.RUnlock()
+	}
+	if offline > 0 {
+		return provider.Health{
+			Status:  provider.HealthDegraded,
+			Message: fmt.Sprintf("%d of %d devices offline", offline, len(p.devices)),
+		}
+	}
+	return provider.Health{Status: provider.HealthOK}
+}
@@ -0,0 +1,166 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/trace"
+)
+
+// ErrUnexpectedCommand is returned for a command that isn't the next one in the
+// device's trace.
+var ErrUnexpectedCommand = errors.New("command not in the trace")
+
+// Device plays back one device's part of a trace. State reads return the recorded
+// states in turn, staying on the last one until a command moves the trace on; a
+// command must be the next one recorded and returns what it returned then, skipping
+// any reads the hub made in between that aren't being made now.
+type Device struct {
+	id       device.ID
+	provider string
+	timing   bool
+
+	mu       sync.Mutex
+	events   []trace.Event // commands and state reads
+	next     int
+	last     *trace.Event // the last state read returned
+	diverged bool
+}
+
+// Devices builds a device for every device in a trace, in the order they first
+// appear, keeping only those of the given providers if any are given.
+func Devices(events []trace.Event, providers ...string) []*Device {
+	keep := make(map[string]bool, len(providers))
+	for _, name := range providers {
+		keep[name] = true
+	}
+
+	var devices []*Device
+	byID := make(map[device.ID]*Device)
+	for _, e := range events {
+		if len(keep) > 0 && !keep[e.Provider] {
+			continue
+		}
+		d, ok := byID[e.Device]
+		if !ok {
+			d = &Device{id: e.Device, provider: e.Provider}
+			byID[e.Device] = d
+			devices = append(devices, d)
+		}
+		if e.Kind == trace.KindCommand || e.Kind == trace.KindState {
+			d.events = append(d.events, e)
+		}
+	}
+	return devices
+}
+
+func (d *Device) ID() device.ID {
+	return d.id
+}
+
+func (d *Device) Execute(ctx context.Context, cmd device.Command) error {
+	d.mu.Lock()
+	i := d.next
+	for i < len(d.events) && d.events[i].Kind != trace.KindCommand {
+		i++
+	}
+	if i == len(d.events) {
+		d.diverged = true
+		d.mu.Unlock()
+		return fmt.Errorf("%w: %s, the trace has no more commands", ErrUnexpectedCommand, cmd.Action)
+	}
+	e := d.events[i]
+	if e.Action != cmd.Action || !sameParams(e.Params, cmd.Params) {
+		d.diverged = true
+		d.mu.Unlock()
+		return fmt.Errorf("%w: %s %v, the trace has %s %v next", ErrUnexpectedCommand, cmd.Action, cmd.Params, e.Action, e.Params)
+	}
+	d.next = i + 1
+	d.mu.Unlock()
+
+	if err := d.wait(ctx, e); err != nil {
+		return err
+	}
+	return e.Err()
+}
+
+func (d *Device) State(ctx context.Context) (device.State, error) {
+	d.mu.Lock()
+	e := d.last
+	if d.next < len(d.events) && d.events[d.next].Kind == trace.KindState {
+		e = &d.events[d.next]
+		d.last = e
+		d.next++
+	}
+	if e == nil {
+		e = d.firstState()
+	}
+	d.mu.Unlock()
+
+	if e == nil {
+		return device.State{}, fmt.Errorf("%w: no state in the trace", device.ErrDeviceUnavailable)
+	}
+	if err := d.wait(ctx, *e); err != nil {
+		return device.State{}, err
+	}
+	if err := e.Err(); err != nil {
+		return device.State{}, err
+	}
+	return e.State.Device(), nil
+}
+
+// firstState is what a device reports before its trace has got to a state read: the
+// first successful one. Must be called with mu held.
+func (d *Device) firstState() *trace.Event {
+	for i := range d.events {
+		if d.events[i].Kind == trace.KindState && d.events[i].State != nil {
+			return &d.events[i]
+		}
+	}
+	return nil
+}
+
+// Diverged reports whether the device has been sent a command its trace doesn't
+// have next.
+func (d *Device) Diverged() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.diverged
+}
+
+func (d *Device) wait(ctx context.Context, e trace.Event) error {
+	if !d.timing || e.Took <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(e.Took.Duration())
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sameParams compares recorded params, which have been through JSON, with those of a
+// command, which may hold ints and the like.
+func sameParams(recorded, params map[string]any) bool {
+	if len(recorded) == 0 && len(params) == 0 {
+		return true
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return false
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(recorded, decoded)
+}
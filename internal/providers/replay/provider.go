@@ -0,0 +1,127 @@
+// Package replay serves a trace recorded from real devices back as devices, so a bug
+// report from someone's home can be reproduced without their hardware.
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/trace"
+)
+
+func init() {
+	provider.RegisterFactory("replay", NewProvider)
+}
+
+type Settings struct {
+	// Trace is the trace file to replay
+	Trace string `json:"trace"`
+	// Providers limits the replay to the devices of these recorded providers
+	Providers []string `json:"providers,omitempty"`
+	// Timing makes devices take as long to answer as they did when recorded
+	Timing bool `json:"timing,omitempty"`
+}
+
+type Provider struct {
+	name     string
+	settings Settings
+
+	mu       sync.Mutex
+	registry provider.Registry
+	devices  map[device.ID]*Device
+}
+
+func NewProvider(name string, raw json.RawMessage, env provider.Env) (provider.Provider, error) {
+	var settings Settings
+	if err := provider.DecodeSettings(raw, &settings); err != nil {
+		return nil, err
+	}
+	return &Provider{
+		name:     name,
+		settings: settings,
+		devices:  make(map[device.ID]*Device),
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+func (p *Provider) Start(ctx context.Context, registry provider.Registry) error {
+	if p.settings.Trace == "" {
+		return fmt.Errorf("%w: no trace", provider.ErrNotConfigured)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.registry = registry
+	return nil
+}
+
+// Discover reads the trace and registers a device for every device in it that isn't
+// registered yet. Registered devices carry on from where they are in their trace.
+func (p *Provider) Discover(ctx context.Context) error {
+	events, err := trace.Load(p.settings.Trace)
+	if err != nil {
+		return err
+	}
+	devices := Devices(events, p.settings.Providers...)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var errs []error
+	for _, d := range devices {
+		if _, ok := p.devices[d.ID()]; ok {
+			continue
+		}
+		d.timing = p.settings.Timing
+		if err := p.registry.Register(d); err != nil {
+			if !errors.Is(err, device.ErrDeviceAlreadyRegistered) {
+				errs = append(errs, fmt.Errorf("%s: %w", d.ID(), err))
+			}
+			continue
+		}
+		p.devices[d.ID()] = d
+		log.Printf("replay: registered %s from %s (%d events)", d.ID(), d.provider, len(d.events))
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Provider) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id := range p.devices {
+		p.registry.Unregister(id)
+	}
+	p.devices = make(map[device.ID]*Device)
+	return nil
+}
+
+// Health is degraded once a device has been sent a command its trace doesn't have
+// next, since the replay has gone off script from then on.
+func (p *Provider) Health(ctx context.Context) provider.Health {
+	p.mu.Lock()
+	var diverged []device.ID
+	for id, d := range p.devices {
+		if d.Diverged() {
+			diverged = append(diverged, id)
+		}
+	}
+	p.mu.Unlock()
+
+	if len(diverged) > 0 {
+		return provider.Health{
+			Status:  provider.HealthDegraded,
+			Message: fmt.Sprintf("off the trace: %v", diverged),
+		}
+	}
+	return provider.Health{Status: provider.HealthOK}
+}
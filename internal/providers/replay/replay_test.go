@@ -0,0 +1,130 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/simulator"
+	"github.com/legitlolly/SmartHomeHub/internal/trace"
+)
+
+// record runs some traffic through simulated devices recorded by the manager, as the
+// hub does with -record.
+func record(t *testing.T, path string) {
+	t.Helper()
+	ctx := context.Background()
+	registry := device.NewRegistry()
+	m := provider.NewManager(registry, provider.Env{})
+	raw := json.RawMessage(`{"devices": ["lamp", {"id": "door", "type": "lock"}, {"id": "flaky", "faults": {"offline": true}}]}`)
+	p, err := simulator.NewProvider("home", raw, provider.Env{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Add(p, "simulator")
+	recorder, err := trace.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Record(recorder)
+	m.StartAll(ctx)
+
+	lamp, _ := registry.Get("lamp")
+	lamp.State(ctx)
+	lamp.State(ctx) // a poll that won't happen on replay
+	lamp.Execute(ctx, device.Command{DeviceID: "lamp", Action: "set_brightness", Params: map[string]any{"value": 40}})
+	lamp.Execute(ctx, device.Command{DeviceID: "lamp", Action: "turn_on"})
+	lamp.State(ctx)
+	lamp.Execute(ctx, device.Command{DeviceID: "lamp", Action: "dance"})
+	flaky, _ := registry.Get("flaky")
+	flaky.State(ctx)
+
+	m.StopAll(ctx)
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	record(t, path)
+
+	raw, _ := json.Marshal(Settings{Trace: path})
+	p, err := NewProvider("replay", raw, provider.Env{})
+	if err != nil {
+		t.Fatalf("NewProvider failed: %v", err)
+	}
+	registry := device.NewRegistry()
+	ctx := context.Background()
+	p.Start(ctx, registry)
+	if err := p.Discover(ctx); err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	if n := len(registry.List()); n != 3 {
+		t.Fatalf("Expected the 3 recorded devices, got %d", n)
+	}
+
+	lamp, _ := registry.Get("lamp")
+	state, err := lamp.State(ctx)
+	if err != nil || state.Attributes["power"] != "off" {
+		t.Fatalf("Expected the recorded state, got %v %v", state, err)
+	}
+	// Params compare by value, whatever their Go type
+	if err := lamp.Execute(ctx, device.Command{DeviceID: "lamp", Action: "set_brightness", Params: map[string]any{"value": 40.0}}); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if err := lamp.Execute(ctx, device.Command{DeviceID: "lamp", Action: "turn_on"}); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	state, _ = lamp.State(ctx)
+	if state.Attributes["power"] != "on" || state.Attributes["brightness"] != 40.0 {
+		t.Errorf("Expected the state after the commands, got %v", state.Attributes)
+	}
+	if err := lamp.Execute(ctx, device.Command{DeviceID: "lamp", Action: "dance"}); err == nil || err.Error() != "unknown command" {
+		t.Errorf("Expected the recorded error, got %v", err)
+	}
+
+	flaky, _ := registry.Get("flaky")
+	if _, err := flaky.State(ctx); !errors.Is(err, device.ErrDeviceUnavailable) {
+		t.Errorf("Expected ErrDeviceUnavailable to survive the trace, got %v", err)
+	}
+	door, _ := registry.Get("door")
+	if _, err := door.State(ctx); !errors.Is(err, device.ErrDeviceUnavailable) {
+		t.Errorf("Expected a device never read to be unavailable, got %v", err)
+	}
+
+	// Going off script is reported
+	if health := p.Health(ctx); health.Status != provider.HealthOK {
+		t.Errorf("Expected ok before diverging, got %+v", health)
+	}
+	if err := lamp.Execute(ctx, device.Command{DeviceID: "lamp", Action: "turn_off"}); !errors.Is(err, ErrUnexpectedCommand) {
+		t.Errorf("Expected ErrUnexpectedCommand past the end of the trace, got %v", err)
+	}
+	if health := p.Health(ctx); health.Status != provider.HealthDegraded {
+		t.Errorf("Expected degraded after diverging, got %+v", health)
+	}
+}
+
+func TestReplay_Mismatch(t *testing.T) {
+	events := []trace.Event{
+		{Provider: "hue", Device: "hue-1", Kind: trace.KindCommand, Action: "set_brightness", Params: map[string]any{"value": 40.0}},
+		{Provider: "kasa", Device: "kasa-1", Kind: trace.KindRegister},
+	}
+	devices := Devices(events, "hue")
+	if len(devices) != 1 || devices[0].ID() != "hue-1" {
+		t.Fatalf("Expected only the hue device, got %v", devices)
+	}
+	err := devices[0].Execute(context.Background(), device.Command{Action: "set_brightness", Params: map[string]any{"value": 50}})
+	if !errors.Is(err, ErrUnexpectedCommand) || !devices[0].Diverged() {
+		t.Errorf("Expected ErrUnexpectedCommand for different params, got %v", err)
+	}
+
+	p, _ := NewProvider("replay", nil, provider.Env{})
+	if err := p.Start(context.Background(), device.NewRegistry()); !errors.Is(err, provider.ErrNotConfigured) {
+		t.Errorf("Expected ErrNotConfigured without a trace, got %v", err)
+	}
+}
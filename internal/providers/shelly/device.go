@@ -0,0 +1,248 @@
+package shelly
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+// node is one physical Shelly device. Its channels share the status cache, which is
+// kept up to date by websocket notifications while connected.
+type node struct {
+	address string
+	client  *Client
+	guard   *resilience.Guard
+	info    DeviceInfo
+
+	mu        sync.RWMutex
+	status    map[string]*ComponentStatus // "switch:0" -> status
+	connected bool                        // websocket notifications are flowing
+	updatedAt time.Time
+}
+
+func (n *node) call(ctx context.Context, method string, params any, result any) error {
+	return n.guard.Do(ctx, func(ctx context.Context) error {
+		return n.client.Call(ctx, method, params, result)
+	})
+}
+
+// refresh replaces the cache with a full Shelly.GetStatus.
+func (n *node) refresh(ctx context.Context) error {
+	var status map[string]ComponentStatus
+	if err := n.call(ctx, "Shelly.GetStatus", nil, &status); err != nil {
+		return err
+	}
+	n.setStatus(status)
+	return nil
+}
+
+func (n *node) setStatus(status map[string]ComponentStatus) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.status = make(map[string]*ComponentStatus)
+	for key, s := range status {
+		if _, _, ok := componentKey(key); ok {
+			s := s
+			n.status[key] = &s
+		}
+	}
+	n.updatedAt = time.Now()
+}
+
+// applyNotification merges a NotifyStatus update into the cache.
+func (n *node) applyNotification(update map[string]ComponentStatus) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for key, s := range update {
+		if existing, ok := n.status[key]; ok {
+			existing.merge(s)
+		}
+	}
+	n.updatedAt = time.Now()
+}
+
+func (n *node) setConnected(connected bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.connected = connected
+}
+
+func (n *node) isConnected() bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.connected
+}
+
+// Channel is one relay (switch device) or dimmer (light device) of a Shelly.
+type Channel struct {
+	id        device.ID
+	node      *node
+	component string // "switch" or "light"
+	index     int
+}
+
+func channelID(info DeviceInfo, component string, index int) device.ID {
+	return device.ID(fmt.Sprintf("%s-%s-%d", info.ID, component, index))
+}
+
+func (c *Channel) ID() device.ID {
+	return c.id
+}
+
+func (c *Channel) key() string {
+	return fmt.Sprintf("%s:%d", c.component, c.index)
+}
+
+// method returns the RPC method for this component, e.g. Switch.Set.
+func (c *Channel) method(name string) string {
+	if c.component == "light" {
+		return "Light." + name
+	}
+	return "Switch." + name
+}
+
+func (c *Channel) Execute(ctx context.Context, cmd device.Command) error {
+	params := map[string]any{"id": c.index}
+
+	switch cmd.Action {
+	case "turn_on":
+		params["on"] = true
+	case "turn_off":
+		params["on"] = false
+	case "toggle":
+		// Toggle would flip back on a retry, so set the opposite of the cached output
+		on, ok := c.output()
+		if !ok {
+			if err := c.refresh(ctx); err != nil {
+				return err
+			}
+			on, _ = c.output()
+		}
+		params["on"] = !on
+	case "set_brightness":
+		if c.component != "light" {
+			return fmt.Errorf("%w: %s", device.ErrUnknownCommand, cmd.Action)
+		}
+		v, err := device.NumberParam(cmd.Params, "value", 0, 100)
+		if err != nil {
+			return err
+		}
+		params["brightness"] = int(math.Round(v))
+		params["on"] = v > 0
+	default:
+		return fmt.Errorf("%w: %s", device.ErrUnknownCommand, cmd.Action)
+	}
+
+	if err := c.node.call(ctx, c.method("Set"), params, nil); err != nil {
+		return err
+	}
+	c.updateCache(params)
+	return nil
+}
+
+// output returns the cached output, if it is known.
+func (c *Channel) output() (on, ok bool) {
+	c.node.mu.RLock()
+	defer c.node.mu.RUnlock()
+	s, ok := c.node.status[c.key()]
+	if !ok || s.Output == nil {
+		return false, false
+	}
+	return *s.Output, true
+}
+
+// updateCache applies a successful Set without waiting for the notification.
+func (c *Channel) updateCache(params map[string]any) {
+	c.node.mu.Lock()
+	defer c.node.mu.Unlock()
+
+	s, ok := c.node.status[c.key()]
+	if !ok {
+		s = &ComponentStatus{ID: c.index}
+		c.node.status[c.key()] = s
+	}
+	if on, ok := params["on"].(bool); ok {
+		s.Output = &on
+	}
+	if b, ok := params["brightness"].(int); ok {
+		f := float64(b)
+		s.Brightness = &f
+	}
+	c.node.updatedAt = time.Now()
+}
+
+// refresh fetches this component's status, used when notifications aren't flowing.
+func (c *Channel) refresh(ctx context.Context) error {
+	var s ComponentStatus
+	if err := c.node.call(ctx, c.method("GetStatus"), map[string]any{"id": c.index}, &s); err != nil {
+		return err
+	}
+	c.node.mu.Lock()
+	defer c.node.mu.Unlock()
+	c.node.status[c.key()] = &s
+	c.node.updatedAt = time.Now()
+	return nil
+}
+
+func (c *Channel) State(ctx context.Context) (device.State, error) {
+	var err error
+	if !c.node.isConnected() {
+		err = c.refresh(ctx)
+	}
+
+	c.node.mu.RLock()
+	defer c.node.mu.RUnlock()
+
+	deviceType := "switch"
+	if c.component == "light" {
+		deviceType = "light"
+	}
+
+	attributes := map[string]interface{}{
+		"power": "unknown",
+		"model": c.node.info.Model,
+	}
+	if c.node.info.Name != "" {
+		attributes["name"] = c.node.info.Name
+	}
+
+	if s, ok := c.node.status[c.key()]; ok {
+		if s.Output != nil {
+			attributes["power"] = device.OnOff(*s.Output)
+		}
+		if s.Brightness != nil {
+			attributes["brightness"] = int(math.Round(*s.Brightness))
+		}
+		if s.APower != nil {
+			attributes["power_watts"] = *s.APower
+		}
+		if s.Voltage != nil {
+			attributes["voltage"] = *s.Voltage
+		}
+		if s.Current != nil {
+			attributes["current"] = *s.Current
+		}
+		if s.AEnergy != nil {
+			attributes["energy_kwh"] = s.AEnergy.Total / 1000
+		}
+		if s.Temperature != nil && s.Temperature.C != nil {
+			attributes["temperature"] = *s.Temperature.C
+		}
+	}
+
+	state := device.State{
+		DeviceType: deviceType,
+		UpdatedAt:  c.node.updatedAt,
+		Attributes: attributes,
+	}
+	if err != nil {
+		attributes["error"] = err.Error()
+		return state, resilience.Unavailable(err)
+	}
+	return state, nil
+}
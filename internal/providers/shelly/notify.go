@@ -0,0 +1,83 @@
+package shelly
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+// listen keeps a websocket open to the device and applies its NotifyStatus events to
+// the cache until ctx is cancelled. While it is down, channels poll over HTTP instead.
+func (n *node) listen(ctx context.Context) {
+	resilience.Reconnect(ctx, "shelly: "+n.address+": websocket closed", func(ctx context.Context) (bool, error) {
+		err := n.listenOnce(ctx)
+		connected := n.isConnected()
+		n.setConnected(false)
+		return connected, err
+	})
+}
+
+func (n *node) listenOnce(ctx context.Context) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, n.client.websocketURL(), nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	// The device only sends notifications to peers that have made a request, so ask for
+	// the full status, which also resyncs anything missed while disconnected.
+	const statusID = 1
+	if err := conn.WriteJSON(request{ID: statusID, Src: clientSource, Method: "Shelly.GetStatus"}); err != nil {
+		return err
+	}
+
+	for {
+		var f frame
+		if err := conn.ReadJSON(&f); err != nil {
+			return err
+		}
+
+		switch {
+		case f.ID == statusID && f.Result != nil:
+			var status map[string]ComponentStatus
+			if err := json.Unmarshal(f.Result, &status); err != nil {
+				return err
+			}
+			n.setStatus(status)
+			n.setConnected(true)
+
+		case f.Method == "NotifyStatus" || f.Method == "NotifyFullStatus":
+			update := decodeNotification(f.Params)
+			if f.Method == "NotifyFullStatus" {
+				n.setStatus(update)
+			} else {
+				n.applyNotification(update)
+			}
+		}
+	}
+}
+
+// decodeNotification picks the switch and light components out of notification
+// params, which also carry a "ts" and other components.
+func decodeNotification(params json.RawMessage) map[string]ComponentStatus {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(params, &raw); err != nil {
+		return nil
+	}
+	update := make(map[string]ComponentStatus)
+	for key, value := range raw {
+		if _, _, ok := componentKey(key); !ok {
+			continue
+		}
+		var s ComponentStatus
+		if err := json.Unmarshal(value, &s); err == nil {
+			update[key] = s
+		}
+	}
+	return update
+}
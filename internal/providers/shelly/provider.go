@@ -0,0 +1,189 @@
+// Package shelly integrates Shelly Gen2 and Gen3 devices through their local JSON-RPC
+// API. Relays become switch devices and dimmers light devices, both with the power
+// metering readings the device reports.
+package shelly
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+func init() {
+	provider.RegisterFactory("shelly", NewProvider)
+}
+
+type Settings struct {
+	// Devices are the addresses (host[:port] or http URL) of the Shellys to add
+	Devices    []string            `json:"devices"`
+	Resilience resilience.Settings `json:"resilience"`
+}
+
+type Provider struct {
+	name     string
+	settings Settings
+	guards   *resilience.Registry
+
+	mu       sync.Mutex
+	registry provider.Registry
+	nodes    []*node
+	channels map[device.ID]*Channel
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func NewProvider(name string, raw json.RawMessage, env provider.Env) (provider.Provider, error) {
+	var settings Settings
+	if err := provider.DecodeSettings(raw, &settings); err != nil {
+		return nil, err
+	}
+
+	guards := env.Guards
+	if guards == nil {
+		guards = resilience.NewRegistry()
+	}
+	guards.Configure(name, settings.Resilience.Apply(resilience.DefaultConfig()), resilience.ClassifyNetwork)
+
+	return &Provider{
+		name:     name,
+		settings: settings,
+		guards:   guards,
+		channels: make(map[device.ID]*Channel),
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+func (p *Provider) Start(ctx context.Context, registry provider.Registry) error {
+	if len(p.settings.Devices) == 0 {
+		return fmt.Errorf("%w: no devices", provider.ErrNotConfigured)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	listenCtx, cancel := context.WithCancel(context.Background())
+	p.registry = registry
+	p.cancel = cancel
+	p.nodes = nil
+	for _, address := range p.settings.Devices {
+		n := &node{
+			address: address,
+			client:  NewClient(address),
+			guard:   p.guards.Guard(p.name, address),
+			status:  make(map[string]*ComponentStatus),
+		}
+		p.nodes = append(p.nodes, n)
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			n.listen(listenCtx)
+		}()
+	}
+	return nil
+}
+
+// Discover reads the info and status of every device and registers a device for each
+// of its switch and light components.
+func (p *Provider) Discover(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var errs []error
+	for _, n := range p.nodes {
+		if err := p.discoverNode(ctx, n); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", n.address, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Provider) discoverNode(ctx context.Context, n *node) error {
+	var info DeviceInfo
+	if err := n.call(ctx, "Shelly.GetDeviceInfo", nil, &info); err != nil {
+		return err
+	}
+	if err := n.refresh(ctx); err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	n.info = info
+	keys := make([]string, 0, len(n.status))
+	for key := range n.status {
+		keys = append(keys, key)
+	}
+	n.mu.Unlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		component, index, _ := componentKey(key)
+		id := channelID(info, component, index)
+		if _, ok := p.channels[id]; ok {
+			continue
+		}
+
+		c := &Channel{id: id, node: n, component: component, index: index}
+		if err := p.registry.Register(c); err != nil {
+			if !errors.Is(err, device.ErrDeviceAlreadyRegistered) {
+				log.Printf("shelly: failed to register %s: %v", id, err)
+			}
+			continue
+		}
+		p.channels[id] = c
+		log.Printf("shelly: registered %s %s (%s) as %s", info.Model, key, n.address, id)
+	}
+	return nil
+}
+
+func (p *Provider) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	cancel := p.cancel
+	p.cancel = nil
+	for id := range p.channels {
+		p.registry.Unregister(id)
+	}
+	p.channels = make(map[device.ID]*Channel)
+	p.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		p.wg.Wait()
+	}
+	return nil
+}
+
+// Health combines the breaker state of each device with whether its websocket is
+// connected; devices without one still work but are polled.
+func (p *Provider) Health(ctx context.Context) provider.Health {
+	p.mu.Lock()
+	var disconnected []string
+	for _, n := range p.nodes {
+		if !n.isConnected() {
+			disconnected = append(disconnected, n.address)
+		}
+	}
+	p.mu.Unlock()
+
+	health := provider.Health{Status: provider.HealthOK}
+	if guards, ok := p.guards.ProviderHealth(p.name); ok {
+		health.Status = guards.Status
+		health.Details = guards
+	}
+	if len(disconnected) > 0 && health.Status == provider.HealthOK {
+		health.Status = provider.HealthDegraded
+		health.Message = fmt.Sprintf("no notifications from %v", disconnected)
+	}
+	return health
+}
@@ -0,0 +1,174 @@
+package shelly
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+var ErrRPC = errors.New("shelly rpc error")
+
+// RPCError is an error returned by the device in the "error" member of a response.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Message)
+}
+
+func (e *RPCError) Unwrap() error {
+	return ErrRPC
+}
+
+type request struct {
+	ID     int64  `json:"id"`
+	Src    string `json:"src"`
+	Method string `json:"method"`
+	Params any    `json:"params,omitempty"`
+}
+
+// frame is any message from the device: a response (ID set) or a notification
+// (Method set).
+type frame struct {
+	ID     int64           `json:"id"`
+	Src    string          `json:"src"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+// Client calls the Gen2 JSON-RPC API of one device over HTTP.
+type Client struct {
+	baseURL string
+	http    *http.Client
+	nextID  atomic.Int64
+}
+
+// NewClient creates a client for address, which is a host[:port] or an http URL.
+func NewClient(address string) *Client {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return &Client{
+		baseURL: strings.TrimSuffix(address, "/"),
+		http:    &http.Client{},
+	}
+}
+
+// websocketURL is where the device sends NotifyStatus events.
+func (c *Client) websocketURL() string {
+	return "ws" + strings.TrimPrefix(c.baseURL, "http") + "/rpc"
+}
+
+// Call invokes method and decodes its result into result, if not nil.
+func (c *Client) Call(ctx context.Context, method string, params any, result any) error {
+	body, err := json.Marshal(request{
+		ID:     c.nextID.Add(1),
+		Src:    clientSource,
+		Method: method,
+		Params: params,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/rpc", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var f frame
+	if err := json.NewDecoder(resp.Body).Decode(&f); err != nil {
+		return fmt.Errorf("%w: %s returned HTTP %d: %v", ErrRPC, method, resp.StatusCode, err)
+	}
+	if f.Error != nil {
+		return fmt.Errorf("%s: %w", method, f.Error)
+	}
+	if result == nil || len(f.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(f.Result, result)
+}
+
+const clientSource = "smarthomehub"
+
+// DeviceInfo is the result of Shelly.GetDeviceInfo.
+type DeviceInfo struct {
+	ID    string `json:"id"`
+	MAC   string `json:"mac"`
+	Model string `json:"model"`
+	Gen   int    `json:"gen"`
+	App   string `json:"app"`
+	Ver   string `json:"ver"`
+	Name  string `json:"name"`
+}
+
+// ComponentStatus is the status of a switch:N or light:N component, as returned by
+// Shelly.GetStatus and partially in NotifyStatus.
+type ComponentStatus struct {
+	ID         int      `json:"id"`
+	Output     *bool    `json:"output,omitempty"`
+	Brightness *float64 `json:"brightness,omitempty"`
+	APower     *float64 `json:"apower,omitempty"`
+	Voltage    *float64 `json:"voltage,omitempty"`
+	Current    *float64 `json:"current,omitempty"`
+	AEnergy    *struct {
+		Total float64 `json:"total"` // Wh
+	} `json:"aenergy,omitempty"`
+	Temperature *struct {
+		C *float64 `json:"tC"`
+	} `json:"temperature,omitempty"`
+}
+
+// merge applies a partial status from a notification.
+func (s *ComponentStatus) merge(update ComponentStatus) {
+	if update.Output != nil {
+		s.Output = update.Output
+	}
+	if update.Brightness != nil {
+		s.Brightness = update.Brightness
+	}
+	if update.APower != nil {
+		s.APower = update.APower
+	}
+	if update.Voltage != nil {
+		s.Voltage = update.Voltage
+	}
+	if update.Current != nil {
+		s.Current = update.Current
+	}
+	if update.AEnergy != nil {
+		s.AEnergy = update.AEnergy
+	}
+	if update.Temperature != nil {
+		s.Temperature = update.Temperature
+	}
+}
+
+// componentKey splits "switch:0" into its type and index.
+func componentKey(key string) (string, int, bool) {
+	typ, index, ok := strings.Cut(key, ":")
+	if !ok || (typ != "switch" && typ != "light") {
+		return "", 0, false
+	}
+	var n int
+	if _, err := fmt.Sscanf(index, "%d", &n); err != nil {
+		return "", 0, false
+	}
+	return typ, n, true
+}
@@ -0,0 +1,191 @@
+package shelly
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/provider/providertest"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/shelly/shellytest"
+)
+
+func startProvider(t *testing.T, fakes ...*shellytest.Device) (*device.Registry, *Provider) {
+	t.Helper()
+	registry, m := providertest.Start(t, NewProvider, "shelly", Settings{Devices: providertest.Addresses(fakes, (*shellytest.Device).Address)})
+	p, _ := m.Provider("shelly")
+	return registry, p.(*Provider)
+}
+
+func TestShelly_DiscoversRelaysAndDimmers(t *testing.T) {
+	ctx := context.Background()
+	fake := shellytest.NewDevice(t, "shellyplus2pm-a8032ab12345", "shellyplus2pm")
+	fake.AddSwitch(0)
+	fake.AddSwitch(1)
+	dimmer := shellytest.NewDevice(t, "shellydimmerg3-b0b21c001122", "shellydimmerg3")
+	dimmer.AddLight(0)
+
+	registry, _ := startProvider(t, fake, dimmer)
+
+	for id, wantType := range map[device.ID]string{
+		"shellyplus2pm-a8032ab12345-switch-0": "switch",
+		"shellyplus2pm-a8032ab12345-switch-1": "switch",
+		"shellydimmerg3-b0b21c001122-light-0": "light",
+	} {
+		dev, err := registry.Get(id)
+		if err != nil {
+			t.Fatalf("Expected %s to be registered: %v", id, err)
+		}
+		state, err := dev.State(ctx)
+		if err != nil {
+			t.Fatalf("State failed: %v", err)
+		}
+		if state.DeviceType != wantType {
+			t.Errorf("Expected %s to be a %s, got %s", id, wantType, state.DeviceType)
+		}
+	}
+
+	dev, _ := registry.Get("shellyplus2pm-a8032ab12345-switch-0")
+	state, _ := dev.State(ctx)
+	if state.Attributes["power"] != "off" || state.Attributes["voltage"] != 230.0 {
+		t.Errorf("Unexpected attributes: %v", state.Attributes)
+	}
+	if state.Attributes["energy_kwh"] != 1.2345 {
+		t.Errorf("Expected energy_kwh 1.2345, got %v", state.Attributes["energy_kwh"])
+	}
+	if state.Attributes["model"] != "shellyplus2pm" {
+		t.Errorf("Expected model shellyplus2pm, got %v", state.Attributes["model"])
+	}
+}
+
+func TestShelly_SwitchCommands(t *testing.T) {
+	ctx := context.Background()
+	fake := shellytest.NewDevice(t, "shellyplus1pm-aabbcc", "shellyplus1pm")
+	fake.AddSwitch(0)
+	registry, _ := startProvider(t, fake)
+
+	dev, _ := registry.Get("shellyplus1pm-aabbcc-switch-0")
+	if err := dev.Execute(ctx, device.Command{DeviceID: dev.ID(), Action: "turn_on"}); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if !fake.Output("switch:0") {
+		t.Error("Expected the relay to be on")
+	}
+
+	// Power readings arrive with the device's notification
+	providertest.WaitFor(t, 5*time.Second, "the power reading", func() bool {
+		state, _ := dev.State(ctx)
+		return state.Attributes["power_watts"] == shellytest.Load
+	})
+
+	if err := dev.Execute(ctx, device.Command{DeviceID: dev.ID(), Action: "toggle"}); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if fake.Output("switch:0") {
+		t.Error("Expected the relay to be off after toggle")
+	}
+
+	err := dev.Execute(ctx, device.Command{DeviceID: dev.ID(), Action: "set_brightness", Params: map[string]any{"value": 10}})
+	if !errors.Is(err, device.ErrUnknownCommand) {
+		t.Errorf("Expected ErrUnknownCommand for a relay, got %v", err)
+	}
+}
+
+func TestShelly_DimmerBrightness(t *testing.T) {
+	ctx := context.Background()
+	fake := shellytest.NewDevice(t, "shellydimmerg3-aabbcc", "shellydimmerg3")
+	fake.AddLight(0)
+	registry, _ := startProvider(t, fake)
+
+	dev, _ := registry.Get("shellydimmerg3-aabbcc-light-0")
+	cmd := device.Command{DeviceID: dev.ID(), Action: "set_brightness", Params: map[string]any{"value": float64(75)}}
+	if err := dev.Execute(ctx, cmd); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if fake.Brightness("light:0") != 75 || !fake.Output("light:0") {
+		t.Errorf("Expected the dimmer on at 75%%, got %v", fake.Brightness("light:0"))
+	}
+
+	state, _ := dev.State(ctx)
+	if state.Attributes["brightness"] != 75 || state.Attributes["power"] != "on" {
+		t.Errorf("Unexpected state: %v", state.Attributes)
+	}
+
+	cmd.Params["value"] = 150
+	if err := dev.Execute(ctx, cmd); !errors.Is(err, device.ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter, got %v", err)
+	}
+}
+
+func TestShelly_NotificationsUpdateState(t *testing.T) {
+	ctx := context.Background()
+	fake := shellytest.NewDevice(t, "shellyplus1-aabbcc", "shellyplus1")
+	fake.AddSwitch(0)
+	registry, p := startProvider(t, fake)
+
+	providertest.WaitFor(t, 5*time.Second, "the websocket", func() bool { return fake.Websockets() == 1 })
+	providertest.WaitFor(t, 5*time.Second, "the provider to be healthy", func() bool { return p.Health(ctx).Status == provider.HealthOK })
+
+	fake.Press("switch:0")
+
+	dev, _ := registry.Get("shellyplus1-aabbcc-switch-0")
+	providertest.WaitFor(t, 5*time.Second, "the notification", func() bool {
+		state, _ := dev.State(ctx)
+		return state.Attributes["power"] == "on"
+	})
+
+	// With notifications flowing, State is served from the cache
+	for _, call := range fake.Calls() {
+		if call == "Switch.GetStatus" {
+			t.Errorf("Expected no Switch.GetStatus while connected, got calls %v", fake.Calls())
+		}
+	}
+}
+
+func TestShelly_PollsWithoutWebsocket(t *testing.T) {
+	ctx := context.Background()
+	fake := shellytest.NewDevice(t, "shellyplus1-aabbcc", "shellyplus1")
+	fake.AddSwitch(0)
+	registry, p := startProvider(t, fake)
+
+	providertest.WaitFor(t, 5*time.Second, "the websocket", func() bool { return fake.Websockets() == 1 })
+	fake.CloseWebsockets()
+	providertest.WaitFor(t, 5*time.Second, "the provider to degrade", func() bool { return p.Health(ctx).Status == provider.HealthDegraded })
+
+	// Changed while disconnected, so only a poll can see it
+	fake.Press("switch:0")
+
+	dev, _ := registry.Get("shellyplus1-aabbcc-switch-0")
+	state, err := dev.State(ctx)
+	if err != nil {
+		t.Fatalf("State failed: %v", err)
+	}
+	if state.Attributes["power"] != "on" {
+		t.Errorf("Expected power on from polling, got %v", state.Attributes["power"])
+	}
+}
+
+func TestClient_RPCError(t *testing.T) {
+	fake := shellytest.NewDevice(t, "shellyplus1-aabbcc", "shellyplus1")
+	client := NewClient(fake.Address())
+
+	err := client.Call(context.Background(), "Switch.Set", map[string]any{"id": 3, "on": true}, nil)
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.Code != -105 {
+		t.Fatalf("Expected RPC error -105, got %v", err)
+	}
+	if !errors.Is(err, ErrRPC) {
+		t.Errorf("Expected error to wrap ErrRPC")
+	}
+}
+
+func TestShelly_Conformance(t *testing.T) {
+	providertest.LightConformance(t, "shellydimmerg3-aabbcc-light-0", func(t *testing.T) *device.Registry {
+		fake := shellytest.NewDevice(t, "shellydimmerg3-aabbcc", "shellydimmerg3")
+		fake.AddLight(0)
+		registry, _ := startProvider(t, fake)
+		return registry
+	})
+}
@@ -0,0 +1,330 @@
+// Package shellytest runs a fake Shelly Gen2 device for tests: the HTTP JSON-RPC
+// endpoint and the websocket that sends NotifyStatus events.
+package shellytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Load is the power a switched on channel reports drawing, in watts.
+const Load = 60.0
+
+type Device struct {
+	server *httptest.Server
+	id     string
+	model  string
+
+	mu         sync.Mutex
+	components map[string]map[string]any // "switch:0" -> status
+	calls      []string
+	conns      map[*websocket.Conn]*sync.Mutex
+}
+
+type request struct {
+	ID     int64           `json:"id"`
+	Src    string          `json:"src"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// NewDevice starts a fake device with no components. It is closed when the test ends.
+func NewDevice(t testing.TB, id, model string) *Device {
+	t.Helper()
+
+	d := &Device{
+		id:         id,
+		model:      model,
+		components: make(map[string]map[string]any),
+		conns:      make(map[*websocket.Conn]*sync.Mutex),
+	}
+	d.server = httptest.NewServer(http.HandlerFunc(d.serveHTTP))
+	t.Cleanup(func() {
+		d.CloseWebsockets()
+		d.server.Close()
+	})
+	return d
+}
+
+// Address is what the provider's devices setting should contain.
+func (d *Device) Address() string {
+	return d.server.URL
+}
+
+// AddSwitch adds a metered relay, switch:<index>.
+func (d *Device) AddSwitch(index int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.components[fmt.Sprintf("switch:%d", index)] = map[string]any{
+		"id":          index,
+		"source":      "init",
+		"output":      false,
+		"apower":      0.0,
+		"voltage":     230.0,
+		"current":     0.0,
+		"aenergy":     map[string]any{"total": 1234.5},
+		"temperature": map[string]any{"tC": 41.2, "tF": 106.2},
+	}
+}
+
+// AddLight adds a dimmer, light:<index>.
+func (d *Device) AddLight(index int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.components[fmt.Sprintf("light:%d", index)] = map[string]any{
+		"id":         index,
+		"source":     "init",
+		"output":     false,
+		"brightness": 50.0,
+		"apower":     0.0,
+		"voltage":    230.0,
+	}
+}
+
+// Press toggles a component as if its physical button was pressed, which the device
+// announces with NotifyStatus.
+func (d *Device) Press(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c := d.components[key]
+	d.setOutput(key, c, !c["output"].(bool), "button")
+}
+
+// Output reports whether a component is on.
+func (d *Device) Output(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.components[key]["output"].(bool)
+}
+
+// Brightness reports a dimmer's brightness.
+func (d *Device) Brightness(key string) float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.components[key]["brightness"].(float64)
+}
+
+// Calls lists the RPC methods called so far, over HTTP and websocket.
+func (d *Device) Calls() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.calls...)
+}
+
+// CloseWebsockets drops all notification connections.
+func (d *Device) CloseWebsockets() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for conn := range d.conns {
+		conn.Close()
+	}
+	d.conns = make(map[*websocket.Conn]*sync.Mutex)
+}
+
+// Websockets reports the number of connected notification peers.
+func (d *Device) Websockets() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.conns)
+}
+
+func (d *Device) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/rpc" {
+		http.NotFound(w, r)
+		return
+	}
+	if websocket.IsWebSocketUpgrade(r) {
+		d.serveWebsocket(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.handle(req))
+}
+
+var upgrader = websocket.Upgrader{}
+
+func (d *Device) serveWebsocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	writeMu := &sync.Mutex{}
+	registered := false
+	defer func() {
+		d.mu.Lock()
+		delete(d.conns, conn)
+		d.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		var req request
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		resp := d.handle(req)
+
+		writeMu.Lock()
+		err := conn.WriteJSON(resp)
+		writeMu.Unlock()
+		if err != nil {
+			return
+		}
+
+		// Like the real device, only peers that sent a request with a src get notifications
+		if !registered && req.Src != "" {
+			registered = true
+			d.mu.Lock()
+			d.conns[conn] = writeMu
+			d.mu.Unlock()
+		}
+	}
+}
+
+func (d *Device) handle(req request) map[string]any {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls = append(d.calls, req.Method)
+
+	resp := map[string]any{"id": req.ID, "src": d.id}
+	if req.Src != "" {
+		resp["dst"] = req.Src
+	}
+
+	result, rerr := d.call(req.Method, req.Params)
+	if rerr != nil {
+		resp["error"] = rerr
+	} else {
+		resp["result"] = result
+	}
+	return resp
+}
+
+// call runs an RPC method. Must be called with mu held.
+func (d *Device) call(method string, raw json.RawMessage) (any, *rpcError) {
+	var params struct {
+		ID         *int     `json:"id"`
+		On         *bool    `json:"on"`
+		Brightness *float64 `json:"brightness"`
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, &rpcError{Code: -103, Message: "Invalid argument: " + err.Error()}
+		}
+	}
+
+	switch method {
+	case "Shelly.GetDeviceInfo":
+		return map[string]any{
+			"id":    d.id,
+			"mac":   strings.ToUpper(strings.TrimPrefix(d.id, d.model+"-")),
+			"model": d.model,
+			"gen":   2,
+			"app":   d.model,
+			"ver":   "1.4.4",
+			"name":  nil,
+		}, nil
+
+	case "Shelly.GetStatus":
+		status := map[string]any{"sys": map[string]any{"uptime": 100}}
+		for key, c := range d.components {
+			status[key] = c
+		}
+		return status, nil
+	}
+
+	component, name, ok := strings.Cut(method, ".")
+	if !ok || (component != "Switch" && component != "Light") {
+		return nil, &rpcError{Code: 404, Message: fmt.Sprintf("No handler for %s", method)}
+	}
+	if params.ID == nil {
+		return nil, &rpcError{Code: -103, Message: "Missing required argument 'id'!"}
+	}
+	key := fmt.Sprintf("%s:%d", strings.ToLower(component), *params.ID)
+	c, ok := d.components[key]
+	if !ok {
+		return nil, &rpcError{Code: -105, Message: fmt.Sprintf("Argument 'id', value %d not found!", *params.ID)}
+	}
+
+	switch name {
+	case "GetStatus":
+		return c, nil
+
+	case "Set":
+		wasOn := c["output"].(bool)
+		if params.Brightness != nil {
+			if component != "Light" || *params.Brightness < 0 || *params.Brightness > 100 {
+				return nil, &rpcError{Code: -103, Message: "Invalid argument 'brightness'!"}
+			}
+			c["brightness"] = *params.Brightness
+		}
+		on := wasOn
+		if params.On != nil {
+			on = *params.On
+		}
+		d.setOutput(key, c, on, "rpc")
+		return map[string]any{"was_on": wasOn}, nil
+
+	case "Toggle":
+		wasOn := c["output"].(bool)
+		d.setOutput(key, c, !wasOn, "rpc")
+		return map[string]any{"was_on": wasOn}, nil
+	}
+	return nil, &rpcError{Code: 404, Message: fmt.Sprintf("No handler for %s", method)}
+}
+
+// setOutput changes a component and notifies websocket peers. Must be called with mu held.
+func (d *Device) setOutput(key string, c map[string]any, on bool, source string) {
+	c["output"] = on
+	c["source"] = source
+	power := 0.0
+	if on {
+		power = Load
+	}
+	c["apower"] = power
+	if _, metered := c["current"]; metered {
+		c["current"] = power / c["voltage"].(float64)
+	}
+
+	update := map[string]any{"id": c["id"], "output": on, "source": source, "apower": power}
+	if b, ok := c["brightness"]; ok {
+		update["brightness"] = b
+	}
+	notification := map[string]any{
+		"src":    d.id,
+		"dst":    "",
+		"method": "NotifyStatus",
+		"params": map[string]any{
+			"ts": float64(time.Now().UnixMilli()) / 1000,
+			key:  update,
+		},
+	}
+	for conn, writeMu := range d.conns {
+		writeMu.Lock()
+		conn.WriteJSON(notification)
+		writeMu.Unlock()
+	}
+}
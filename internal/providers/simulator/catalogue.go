@@ -0,0 +1,172 @@
+package simulator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+)
+
+var ErrUnknownType = errors.New("unknown simulated device type")
+
+// Faulty is implemented by every simulated device, for tests and the admin API to
+// make it misbehave.
+type Faulty interface {
+	device.Device
+	Faults() Faults
+	SetFaults(f Faults) error
+}
+
+// simulated is what the catalogue and scenarios need from a device.
+type simulated interface {
+	Faulty
+	// setRoom is called before the device is registered
+	setRoom(room string)
+	// advance runs the device's model up to now, and setState sets attributes,
+	// named and valued as State reports them. Both must be called with mu held,
+	// which apply does.
+	advance(now time.Time)
+	setState(state map[string]any) error
+	apply(fn func(now time.Time) error) error
+}
+
+// catalogue maps the device types in the config to their constructors.
+var catalogue = map[string]func(id device.ID, name string, clock Clock) simulated{
+	"light":      func(id device.ID, name string, clock Clock) simulated { return newLight(id, name, clock) },
+	"switch":     func(id device.ID, name string, clock Clock) simulated { return NewSwitch(id, name, clock) },
+	"thermostat": func(id device.ID, name string, clock Clock) simulated { return NewThermostat(id, name, clock) },
+	"climate":    func(id device.ID, name string, clock Clock) simulated { return NewClimateSensor(id, name, clock) },
+	"motion":     func(id device.ID, name string, clock Clock) simulated { return NewMotionSensor(id, name, clock) },
+	"lock":       func(id device.ID, name string, clock Clock) simulated { return NewLock(id, name, clock) },
+	"cover":      func(id device.ID, name string, clock Clock) simulated { return NewCover(id, name, clock) },
+}
+
+// Types lists the device types the simulator can create.
+func Types() []string {
+	types := make([]string, 0, len(catalogue))
+	for t := range catalogue {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+type DeviceConfig struct {
+	ID device.ID `json:"id"`
+	// Type is one of the catalogue's types, "light" by default
+	Type string `json:"type,omitempty"`
+	Name string `json:"name,omitempty"`
+	Room string `json:"room,omitempty"`
+	// State is the initial state, e.g. {"power": "on", "brightness": 40}
+	State map[string]any `json:"state,omitempty"`
+	// Faults the device starts with; they can be changed at runtime with SetFaults
+	Faults *Faults `json:"faults,omitempty"`
+}
+
+// UnmarshalJSON also takes a bare ID, for a light.
+func (c *DeviceConfig) UnmarshalJSON(data []byte) error {
+	var id string
+	if err := json.Unmarshal(data, &id); err == nil {
+		*c = DeviceConfig{ID: device.ID(id)}
+		return nil
+	}
+	type plain DeviceConfig
+	return json.Unmarshal(data, (*plain)(c))
+}
+
+// validate fills in the default type and checks the config by building a device
+// from it.
+func (c *DeviceConfig) validate() error {
+	if c.ID == "" {
+		return errors.New("simulator: devices need an id")
+	}
+	if c.Type == "" {
+		c.Type = "light"
+	}
+	if _, err := NewDevice(*c, SystemClock); err != nil {
+		return fmt.Errorf("%s: %w", c.ID, err)
+	}
+	return nil
+}
+
+// NewDevice creates a simulated device of one of the catalogue's types.
+func NewDevice(cfg DeviceConfig, clock Clock) (Faulty, error) {
+	create, ok := catalogue[cfg.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q (one of %s)", ErrUnknownType, cfg.Type, strings.Join(Types(), ", "))
+	}
+	dev := create(cfg.ID, cfg.Name, clock)
+	dev.setRoom(cfg.Room)
+	if err := dev.setState(cfg.State); err != nil {
+		return nil, err
+	}
+	if cfg.Faults != nil {
+		if err := dev.SetFaults(*cfg.Faults); err != nil {
+			return nil, err
+		}
+	}
+	return dev, nil
+}
+
+// SetState changes the attributes of a running simulated device, as a scenario does
+// to make the temperature drop or someone walk past: {"temperature": 17}. The
+// attributes are those State reports that can also be given as initial state.
+func SetState(dev device.Device, state map[string]any) error {
+	d, ok := dev.(simulated)
+	if !ok {
+		return fmt.Errorf("%w: %s is not simulated", ErrUnknownType, dev.ID())
+	}
+	return d.apply(func(now time.Time) error {
+		d.advance(now)
+		return d.setState(state)
+	})
+}
+
+// eachState calls the setter for every attribute of a state.
+func eachState(state map[string]any, setters map[string]func(v any) error) error {
+	for name, v := range state {
+		set, ok := setters[name]
+		if !ok {
+			return fmt.Errorf("%w: %s can't be set on this type", device.ErrInvalidParameter, name)
+		}
+		if err := set(v); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func numberState(v any, min, max float64, set func(float64)) error {
+	n, err := device.NumberParam(map[string]any{"value": v}, "value", min, max)
+	if err != nil {
+		return err
+	}
+	set(n)
+	return nil
+}
+
+func boolState(v any, set func(bool)) error {
+	b, ok := v.(bool)
+	if !ok {
+		return fmt.Errorf("%w: must be true or false", device.ErrInvalidParameter)
+	}
+	set(b)
+	return nil
+}
+
+// powerState takes "on"/"off" as well as a bool.
+func powerState(v any, set func(on bool)) error {
+	switch v {
+	case "on", true:
+		set(true)
+	case "off", false:
+		set(false)
+	default:
+		return fmt.Errorf("%w: must be on or off", device.ErrInvalidParameter)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package simulator
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the time the simulated devices run on. Their models (heating, drift,
+// travel) are worked out from it when the state is read, so a test can move it
+// forward instead of waiting.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is the wall clock.
+var SystemClock Clock = systemClock{}
+
+// VirtualClock is a clock that runs at any speed, including not at all, and can be
+// moved forward in steps. It starts paused.
+type VirtualClock struct {
+	mu     sync.Mutex
+	at     time.Time // the virtual time at anchor
+	anchor time.Time // the wall clock time it was last worked out
+	speed  float64
+}
+
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{at: start, anchor: time.Now()}
+}
+
+// now works out the virtual time. Must be called with mu held.
+func (c *VirtualClock) now() time.Time {
+	wall := time.Now()
+	c.at = c.at.Add(time.Duration(float64(wall.Sub(c.anchor)) * c.speed))
+	c.anchor = wall
+	return c.at
+}
+
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now()
+}
+
+// Advance moves the clock forward by d at once.
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d > 0 {
+		c.at = c.now().Add(d)
+	}
+}
+
+// SetSpeed makes the clock run speed times as fast as the wall clock: 1 is real
+// time, 60 a minute a second and 0 paused.
+func (c *VirtualClock) SetSpeed(speed float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now()
+	c.speed = max(speed, 0)
+}
+
+func (c *VirtualClock) Speed() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.speed
+}
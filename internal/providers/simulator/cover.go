@@ -0,0 +1,96 @@
+package simulator
+
+import (
+	"context"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+)
+
+// coverTravelTime is how long a cover takes to go from closed to fully open.
+const coverTravelTime = 20 * time.Second
+
+// Cover is a blind or shutter that moves at a fixed speed. Position 0 is closed and
+// 100 fully open.
+type Cover struct {
+	base
+	position float64
+	target   float64
+	moved    time.Time // when position was last worked out
+}
+
+func NewCover(id device.ID, name string, clock Clock) *Cover {
+	return &Cover{base: newBase(id, name, clock), moved: clock.Now()}
+}
+
+// advance moves the cover as far as it has travelled by now. Must be called with
+// mu held.
+func (d *Cover) advance(now time.Time) {
+	distance := 100 * float64(now.Sub(d.moved)) / float64(coverTravelTime)
+	d.moved = now
+	switch {
+	case d.position == d.target:
+		return
+	case d.position < d.target:
+		d.position = min(d.position+distance, d.target)
+	default:
+		d.position = max(d.position-distance, d.target)
+	}
+	if d.position == d.target {
+		d.updatedAt = now
+	}
+}
+
+func (d *Cover) coverState() string {
+	switch {
+	case d.target > d.position:
+		return "opening"
+	case d.target < d.position:
+		return "closing"
+	case d.position == 0:
+		return "closed"
+	default:
+		return "open"
+	}
+}
+
+func (d *Cover) Execute(ctx context.Context, cmd device.Command) error {
+	return d.execute(ctx, func(now time.Time) error {
+		d.advance(now)
+		switch cmd.Action {
+		case "open":
+			d.target = 100
+		case "close":
+			d.target = 0
+		case "stop":
+			d.target = d.position
+		case "set_position":
+			position, err := device.NumberParam(cmd.Params, "value", 0, 100)
+			if err != nil {
+				return err
+			}
+			d.target = position
+		default:
+			return device.ErrUnknownCommand
+		}
+		return nil
+	})
+}
+
+func (d *Cover) setState(state map[string]any) error {
+	return eachState(state, map[string]func(v any) error{
+		"position": func(v any) error {
+			return numberState(v, 0, 100, func(p float64) { d.position, d.target = p, p })
+		},
+	})
+}
+
+func (d *Cover) State(ctx context.Context) (device.State, error) {
+	return d.state("cover", func(now time.Time) map[string]any {
+		d.advance(now)
+		return map[string]any{
+			"position":    int(d.position + 0.5),
+			"cover_state": d.coverState(),
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+)
+
+var (
+	// ErrInjected is the failure of a command picked to fail by the device's faults
+	ErrInjected = errors.New("injected fault")
+	// ErrInvalidFaults is returned by SetFaults for a profile that makes no sense
+	ErrInvalidFaults = errors.New("invalid fault profile")
+)
+
+// timeoutHang caps how long a command picked to time out hangs when the caller
+// never gives up.
+const timeoutHang = time.Minute
+
+// Faults makes a simulated device misbehave. The zero value is a healthy device.
+// The random choices come from a source seeded from Seed, or the device ID, and
+// restarted whenever the faults are set, so a test sees the same failures every run.
+type Faults struct {
+	Latency Latency `json:"latency"`
+	// ErrorRate is the share of commands (0-1) failing with ErrInjected
+	ErrorRate float64 `json:"error_rate,omitempty"`
+	// FailNext fails the next commands regardless of ErrorRate
+	FailNext int `json:"fail_next,omitempty"`
+	// TimeoutRate is the share of commands (0-1) that never answer, hanging until
+	// the caller gives up
+	TimeoutRate float64 `json:"timeout_rate,omitempty"`
+	// Offline makes the device unavailable, for OfflineFor from when the faults are
+	// set, or until they are cleared if OfflineFor is zero
+	Offline    bool            `json:"offline,omitempty"`
+	OfflineFor config.Duration `json:"offline_for,omitempty"`
+	// Stuck devices keep reporting the state they had when they got stuck, while
+	// commands still take effect underneath
+	Stuck bool `json:"stuck,omitempty"`
+	// IgnoreCommands acknowledges commands without carrying them out, like a device
+	// that lost the message
+	IgnoreCommands bool   `json:"ignore_commands,omitempty"`
+	Seed           uint64 `json:"seed,omitempty"`
+
+	// OfflineUntil is when an OfflineFor window ends. It is set by SetFaults.
+	OfflineUntil time.Time `json:"offline_until,omitzero"`
+}
+
+// Latency is how long commands take. The zero value is the default fixed 100ms.
+type Latency struct {
+	// Distribution is "fixed" (Mean), "uniform" (Min to Max) or "normal" (Mean and
+	// StdDev, never below zero)
+	Distribution string          `json:"distribution,omitempty"`
+	Mean         config.Duration `json:"mean,omitempty"`
+	Min          config.Duration `json:"min,omitempty"`
+	Max          config.Duration `json:"max,omitempty"`
+	StdDev       config.Duration `json:"stddev,omitempty"`
+}
+
+func (f Faults) validate() error {
+	if f.ErrorRate < 0 || f.ErrorRate > 1 || f.TimeoutRate < 0 || f.TimeoutRate > 1 {
+		return fmt.Errorf("%w: rates must be 0-1", ErrInvalidFaults)
+	}
+	if f.FailNext < 0 || f.OfflineFor < 0 {
+		return fmt.Errorf("%w: fail_next and offline_for can't be negative", ErrInvalidFaults)
+	}
+
+	l := f.Latency
+	if l.Mean < 0 || l.Min < 0 || l.Max < 0 || l.StdDev < 0 {
+		return fmt.Errorf("%w: latencies can't be negative", ErrInvalidFaults)
+	}
+	switch l.Distribution {
+	case "", "fixed", "normal":
+	case "uniform":
+		if l.Max < l.Min {
+			return fmt.Errorf("%w: latency max is below min", ErrInvalidFaults)
+		}
+	default:
+		return fmt.Errorf("%w: unknown latency distribution %q", ErrInvalidFaults, l.Distribution)
+	}
+	return nil
+}
+
+// sample picks a command's latency.
+func (l Latency) sample(rng *rand.Rand) time.Duration {
+	switch l.Distribution {
+	case "fixed":
+		return l.Mean.Duration()
+	case "uniform":
+		return l.Min.Duration() + time.Duration(rng.Int64N(int64(l.Max-l.Min)+1))
+	case "normal":
+		return max(0, l.Mean.Duration()+time.Duration(rng.NormFloat64()*float64(l.StdDev)))
+	default:
+		return latency
+	}
+}
+
+// outcome is what the faults make of one command.
+type outcome int
+
+const (
+	succeed outcome = iota
+	fail
+	hang
+	ignore
+)
+
+// Faults returns the device's current faults.
+func (b *base) Faults() Faults {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.faults
+}
+
+// SetFaults replaces the device's faults; the zero Faults clears them.
+func (b *base) SetFaults(f Faults) error {
+	if err := f.validate(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	f.OfflineUntil = time.Time{}
+	if f.Offline && f.OfflineFor > 0 {
+		f.OfflineUntil = b.clock.Now().Add(f.OfflineFor.Duration())
+	}
+	seed := f.Seed
+	if seed == 0 {
+		seed = seedFor(b.id)
+	}
+	b.faults = f
+	b.faultRand = rand.New(rand.NewPCG(seed, seed))
+	if !f.Stuck {
+		b.frozen = nil
+	}
+	return nil
+}
+
+// offline reports whether the device is offline now. Must be called with mu held.
+func (b *base) offline(now time.Time) bool {
+	f := &b.faults
+	if !f.Offline {
+		return false
+	}
+	if !f.OfflineUntil.IsZero() && !now.Before(f.OfflineUntil) {
+		// The window is over
+		f.Offline, f.OfflineFor, f.OfflineUntil = false, 0, time.Time{}
+		return false
+	}
+	return true
+}
+
+// plan decides how a command goes. Must be called with mu held.
+func (b *base) plan() (time.Duration, outcome, error) {
+	if b.offline(b.clock.Now()) {
+		return 0, fail, fmt.Errorf("%w: %s is offline", device.ErrDeviceUnavailable, b.id)
+	}
+
+	f := &b.faults
+	delay := f.Latency.sample(b.faultRand)
+	switch {
+	case f.FailNext > 0:
+		f.FailNext--
+		return delay, fail, ErrInjected
+	case f.ErrorRate > 0 && b.faultRand.Float64() < f.ErrorRate:
+		return delay, fail, ErrInjected
+	case f.TimeoutRate > 0 && b.faultRand.Float64() < f.TimeoutRate:
+		return delay, hang, nil
+	case f.IgnoreCommands:
+		return delay, ignore, nil
+	}
+	return delay, succeed, nil
+}
+
+// wait sleeps for d, or less if ctx ends first.
+func wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
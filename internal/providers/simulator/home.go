@@ -0,0 +1,142 @@
+package simulator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrInvalidHome = errors.New("invalid simulated home")
+
+// Home describes a whole simulated house, room by room. It is read from a YAML or
+// JSON file:
+//
+//	name: Test house
+//	rooms:
+//	  - name: Living room
+//	    devices:
+//	      - type: light
+//	        count: 3
+//	        state: {power: on, brightness: 40}
+//	      - type: thermostat
+//	        state: {target_temperature: 21}
+//	        faults: {error_rate: 0.1}
+type Home struct {
+	Name  string `json:"name,omitempty"`
+	Rooms []Room `json:"rooms"`
+}
+
+type Room struct {
+	Name    string       `json:"name"`
+	Devices []RoomDevice `json:"devices"`
+}
+
+// RoomDevice is a DeviceConfig that can stand for several identical devices.
+type RoomDevice struct {
+	// ID defaults to <room>-<type>; with Count above 1, -1, -2, ... are appended
+	ID device.ID `json:"id,omitempty"`
+	// Type is one of the catalogue's types, "light" by default
+	Type string `json:"type,omitempty"`
+	// Name defaults to the room and type, numbered like the ID
+	Name   string         `json:"name,omitempty"`
+	Count  int            `json:"count,omitempty"`
+	State  map[string]any `json:"state,omitempty"`
+	Faults *Faults        `json:"faults,omitempty"`
+}
+
+// LoadHome reads a home file. Files ending in .yaml or .yml are YAML, anything else
+// JSON.
+func LoadHome(path string) (*Home, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read home: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		// Go through JSON so both formats share the field names and durations
+		var v any
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidHome, path, err)
+		}
+		if data, err = json.Marshal(v); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidHome, path, err)
+		}
+	}
+
+	var home Home
+	if err := json.Unmarshal(data, &home); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidHome, path, err)
+	}
+	return &home, nil
+}
+
+// Devices expands the rooms into device configs, checking each of them.
+func (h *Home) Devices() ([]DeviceConfig, error) {
+	var configs []DeviceConfig
+	ids := make(map[device.ID]bool)
+	for _, room := range h.Rooms {
+		if room.Name == "" {
+			return nil, fmt.Errorf("%w: rooms need a name", ErrInvalidHome)
+		}
+		for _, d := range room.Devices {
+			if d.Type == "" {
+				d.Type = "light"
+			}
+			if d.Count < 0 {
+				return nil, fmt.Errorf("%w: %s: negative count", ErrInvalidHome, room.Name)
+			}
+			count := max(d.Count, 1)
+
+			id := d.ID
+			if id == "" {
+				id = device.ID(slug(room.Name) + "-" + d.Type)
+			}
+			name := d.Name
+			if name == "" {
+				name = room.Name + " " + d.Type
+			}
+			for i := 1; i <= count; i++ {
+				cfg := DeviceConfig{ID: id, Type: d.Type, Name: name, Room: room.Name, State: d.State, Faults: d.Faults}
+				if count > 1 {
+					cfg.ID = device.ID(fmt.Sprintf("%s-%d", id, i))
+					cfg.Name = fmt.Sprintf("%s %d", name, i)
+				}
+				if ids[cfg.ID] {
+					return nil, fmt.Errorf("%w: %s: duplicate device %s, give it an id", ErrInvalidHome, room.Name, cfg.ID)
+				}
+				ids[cfg.ID] = true
+				if err := cfg.validate(); err != nil {
+					return nil, fmt.Errorf("%w: %s: %v", ErrInvalidHome, room.Name, err)
+				}
+				configs = append(configs, cfg)
+			}
+		}
+	}
+	return configs, nil
+}
+
+// slug turns a room name into an ID prefix: "Living room" becomes living-room.
+func slug(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(r)
+		default:
+			dash = true
+		}
+	}
+	return b.String()
+}
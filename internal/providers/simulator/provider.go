@@ -0,0 +1,192 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+)
+
+func init() {
+	provider.RegisterFactory("simulator", NewProvider)
+}
+
+type Settings struct {
+	Devices []DeviceConfig `json:"devices"`
+	// Home is a home file, read on every Discover, so rediscovering reloads it
+	Home string `json:"home,omitempty"`
+	// VirtualTime runs the devices on a VirtualClock, for scenarios. It starts at
+	// the current time and speed 1.
+	VirtualTime bool `json:"virtual_time,omitempty"`
+}
+
+// entry is a registered device and the config it was built from.
+type entry struct {
+	device Faulty
+	config DeviceConfig
+}
+
+// Provider registers the simulated devices listed in its settings and its home file.
+type Provider struct {
+	name     string
+	settings Settings
+	clock    Clock
+	virtual  *VirtualClock
+
+	mu       sync.Mutex
+	registry provider.Registry
+	devices  map[device.ID]entry
+}
+
+func NewProvider(name string, raw json.RawMessage, env provider.Env) (provider.Provider, error) {
+	var settings Settings
+	if err := provider.DecodeSettings(raw, &settings); err != nil {
+		return nil, err
+	}
+	for i := range settings.Devices {
+		if err := settings.Devices[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+	p := &Provider{
+		name:     name,
+		settings: settings,
+		clock:    SystemClock,
+		devices:  make(map[device.ID]entry),
+	}
+	if settings.VirtualTime {
+		p.virtual = NewVirtualClock(time.Now())
+		p.virtual.SetSpeed(1)
+		p.clock = p.virtual
+	}
+	return p, nil
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+// VirtualClock returns the clock the devices run on with virtual_time, or nil.
+func (p *Provider) VirtualClock() *VirtualClock {
+	return p.virtual
+}
+
+// Runner returns a scenario runner for the provider's devices, or nil without
+// virtual_time.
+func (p *Provider) Runner() *Runner {
+	if p.virtual == nil {
+		return nil
+	}
+	return NewRunner(p.virtual, p.device)
+}
+
+func (p *Provider) device(id device.ID) (device.Device, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.devices[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", device.ErrDeviceNotFound, id)
+	}
+	return e.device, nil
+}
+
+func (p *Provider) Start(ctx context.Context, registry provider.Registry) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.registry = registry
+	return nil
+}
+
+// configs returns the devices from the settings and the home file.
+func (p *Provider) configs() ([]DeviceConfig, error) {
+	configs := p.settings.Devices
+	if p.settings.Home == "" {
+		return configs, nil
+	}
+
+	home, err := LoadHome(p.settings.Home)
+	if err != nil {
+		return nil, err
+	}
+	fromHome, err := home.Devices()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.settings.Home, err)
+	}
+	ids := make(map[device.ID]bool, len(configs))
+	for _, cfg := range configs {
+		ids[cfg.ID] = true
+	}
+	for _, cfg := range fromHome {
+		if ids[cfg.ID] {
+			return nil, fmt.Errorf("%w: %s is also in the settings", ErrInvalidHome, cfg.ID)
+		}
+	}
+	return append(configs[:len(configs):len(configs)], fromHome...), nil
+}
+
+// Discover brings the registered devices in line with the config: new devices are
+// registered, changed ones are replaced with a fresh device and removed ones are
+// unregistered. Unchanged devices keep their state.
+func (p *Provider) Discover(ctx context.Context) error {
+	configs, err := p.configs()
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	wanted := make(map[device.ID]bool, len(configs))
+	for _, cfg := range configs {
+		wanted[cfg.ID] = true
+		current, exists := p.devices[cfg.ID]
+		if exists && reflect.DeepEqual(current.config, cfg) {
+			continue
+		}
+		dev, err := NewDevice(cfg, p.clock)
+		if err != nil {
+			return fmt.Errorf("%s: %w", cfg.ID, err)
+		}
+		if exists {
+			p.registry.Unregister(cfg.ID)
+			delete(p.devices, cfg.ID)
+		}
+		if err := p.registry.Register(dev); err != nil {
+			return err
+		}
+		p.devices[cfg.ID] = entry{device: dev, config: cfg}
+		if exists {
+			log.Printf("simulator: reset %s (%s)", cfg.ID, cfg.Type)
+		}
+	}
+
+	for id := range p.devices {
+		if !wanted[id] {
+			p.registry.Unregister(id)
+			delete(p.devices, id)
+			log.Printf("simulator: removed %s", id)
+		}
+	}
+	return nil
+}
+
+func (p *Provider) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id := range p.devices {
+		p.registry.Unregister(id)
+	}
+	p.devices = make(map[device.ID]entry)
+	return nil
+}
+
+func (p *Provider) Health(ctx context.Context) provider.Health {
+	return provider.Health{Status: provider.HealthOK}
+}
@@ -0,0 +1,212 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+)
+
+var ErrInvalidScenario = errors.New("invalid scenario")
+
+// Scenario is a timeline of things happening to simulated devices, with the states
+// expected along the way:
+//
+//	{"name": "evening", "steps": [
+//	  {"at": "0s", "device": "hall-motion", "set": {"motion": true}},
+//	  {"at": "5m", "device": "hall-climate", "set": {"temperature": 17}},
+//	  {"at": "30m", "device": "hall-thermostat", "expect": {"hvac_action": "heating"}}
+//	]}
+type Scenario struct {
+	Name  string `json:"name,omitempty"`
+	Steps []Step `json:"steps"`
+}
+
+// Step happens At after the start of the scenario. Set, Action and Expect are done
+// in that order; a step with none of them just moves the clock on.
+type Step struct {
+	At     config.Duration `json:"at"`
+	Device device.ID       `json:"device,omitempty"`
+	// Set changes attributes directly, as SetState does
+	Set map[string]any `json:"set,omitempty"`
+	// Action is a command, sent like any other with Params
+	Action string         `json:"action,omitempty"`
+	Params map[string]any `json:"params,omitempty"`
+	// Expect checks attributes. Values must be equal, numbers of any type
+	// comparing by value, or within a {"min": 19, "max": 21} range.
+	Expect map[string]any `json:"expect,omitempty"`
+}
+
+type Result struct {
+	Scenario string       `json:"scenario,omitempty"`
+	Passed   bool         `json:"passed"`
+	Started  time.Time    `json:"started"`
+	Steps    []StepResult `json:"steps"`
+}
+
+type StepResult struct {
+	At     config.Duration `json:"at"`
+	Device device.ID       `json:"device,omitempty"`
+	// Error is why Set or Action failed
+	Error string `json:"error,omitempty"`
+	// Failures are the expectations that weren't met
+	Failures []string `json:"failures,omitempty"`
+}
+
+// Err sums up what went wrong, or returns nil if the scenario passed. It makes Go
+// tests short: if err := result.Err(); err != nil { t.Fatal(err) }
+func (r *Result) Err() error {
+	if r.Passed {
+		return nil
+	}
+	var problems []string
+	for _, step := range r.Steps {
+		if step.Error != "" {
+			problems = append(problems, fmt.Sprintf("at %s: %s: %s", step.At.Duration(), step.Device, step.Error))
+		}
+		for _, failure := range step.Failures {
+			problems = append(problems, fmt.Sprintf("at %s: %s: %s", step.At.Duration(), step.Device, failure))
+		}
+	}
+	return fmt.Errorf("scenario %s failed: %s", r.Scenario, strings.Join(problems, "; "))
+}
+
+// Runner plays scenarios on a virtual clock, looking devices up with the given
+// function, such as a device registry's Get.
+type Runner struct {
+	clock   *VirtualClock
+	devices func(id device.ID) (device.Device, error)
+}
+
+func NewRunner(clock *VirtualClock, devices func(id device.ID) (device.Device, error)) *Runner {
+	return &Runner{clock: clock, devices: devices}
+}
+
+// Run plays the scenario from the clock's current time, moving the clock on to each
+// step in turn. The clock is paused while it runs, so no time passes between steps
+// but what the scenario says, and it goes back to its speed after. Failed steps are
+// recorded in the result; the error is for a scenario that can't be run at all.
+func (r *Runner) Run(ctx context.Context, s Scenario) (*Result, error) {
+	steps := append([]Step(nil), s.Steps...)
+	for i, step := range steps {
+		if step.At < 0 {
+			return nil, fmt.Errorf("%w: step %d is at a negative time", ErrInvalidScenario, i)
+		}
+		if step.Device == "" && (step.Set != nil || step.Action != "" || step.Expect != nil) {
+			return nil, fmt.Errorf("%w: step %d has no device", ErrInvalidScenario, i)
+		}
+	}
+	sort.SliceStable(steps, func(i, j int) bool { return steps[i].At < steps[j].At })
+
+	speed := r.clock.Speed()
+	r.clock.SetSpeed(0)
+	defer r.clock.SetSpeed(speed)
+
+	result := &Result{Scenario: s.Name, Passed: true, Started: r.clock.Now()}
+	for _, step := range steps {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if wait := result.Started.Add(step.At.Duration()).Sub(r.clock.Now()); wait > 0 {
+			r.clock.Advance(wait)
+		}
+
+		stepResult := r.run(ctx, step)
+		if stepResult.Error != "" || len(stepResult.Failures) > 0 {
+			result.Passed = false
+		}
+		result.Steps = append(result.Steps, stepResult)
+	}
+	return result, nil
+}
+
+func (r *Runner) run(ctx context.Context, step Step) StepResult {
+	result := StepResult{At: step.At, Device: step.Device}
+	if step.Device == "" {
+		return result
+	}
+	dev, err := r.devices(step.Device)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if step.Set != nil {
+		if err := SetState(dev, step.Set); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+	}
+	if step.Action != "" {
+		err := dev.Execute(ctx, device.Command{DeviceID: step.Device, Action: step.Action, Params: step.Params})
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+	}
+	if step.Expect != nil {
+		state, err := dev.State(ctx)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		names := make([]string, 0, len(step.Expect))
+		for name := range step.Expect {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if failure := expect(name, step.Expect[name], state.Attributes); failure != "" {
+				result.Failures = append(result.Failures, failure)
+			}
+		}
+	}
+	return result
+}
+
+// expect checks one attribute, returning what is wrong with it.
+func expect(name string, want any, attributes map[string]any) string {
+	got, ok := attributes[name]
+	if !ok {
+		return fmt.Sprintf("expected %s %v, but there is no %s", name, want, name)
+	}
+
+	if bounds, ok := want.(map[string]any); ok {
+		n, isNumber := toFloat(got)
+		if !isNumber {
+			return fmt.Sprintf("expected %s in %v, got %v", name, bounds, got)
+		}
+		if min, ok := toFloat(bounds["min"]); ok && n < min {
+			return fmt.Sprintf("expected %s at least %g, got %g", name, min, n)
+		}
+		if max, ok := toFloat(bounds["max"]); ok && n > max {
+			return fmt.Sprintf("expected %s at most %g, got %g", name, max, n)
+		}
+		return ""
+	}
+
+	if w, ok := toFloat(want); ok {
+		if g, ok := toFloat(got); ok && g == w {
+			return ""
+		}
+	} else if reflect.DeepEqual(got, want) {
+		return ""
+	}
+	return fmt.Sprintf("expected %s %v, got %v", name, want, got)
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
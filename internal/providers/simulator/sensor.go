@@ -0,0 +1,176 @@
+package simulator
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand/v2"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+)
+
+// Sensor readings change a step at a time. Each device has its own random source
+// seeded from its ID, so a given clock always produces the same readings.
+const (
+	sensorStep = time.Minute
+	// The model isn't run for more than this; older readings have no effect by then
+	sensorHorizon = 24 * time.Hour
+
+	defaultTemperature = 21.0
+	defaultHumidity    = 45.0
+	driftReversion     = 0.05 // share of the distance back to the base value per step
+
+	motionChance = 0.02 // per step
+	motionHold   = 90 * time.Second
+)
+
+func seedFor(id device.ID) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return h.Sum64()
+}
+
+func newRand(id device.ID) *rand.Rand {
+	seed := seedFor(id)
+	return rand.New(rand.NewPCG(seed, seed))
+}
+
+// steps calls step for every whole step between *last and now, moving *last along.
+func steps(last *time.Time, now time.Time, step func(at time.Time)) {
+	if now.Sub(*last) > sensorHorizon {
+		*last = now.Add(-sensorHorizon)
+	}
+	for ; !last.Add(sensorStep).After(now); *last = last.Add(sensorStep) {
+		step(last.Add(sensorStep))
+	}
+}
+
+// ClimateSensor reports temperature and humidity drifting around typical indoor
+// values, or the ones it was set up with.
+type ClimateSensor struct {
+	base
+	rng             *rand.Rand
+	temperature     float64
+	humidity        float64
+	baseTemperature float64
+	baseHumidity    float64
+	last            time.Time
+}
+
+func NewClimateSensor(id device.ID, name string, clock Clock) *ClimateSensor {
+	return &ClimateSensor{
+		base:            newBase(id, name, clock),
+		rng:             newRand(id),
+		temperature:     defaultTemperature,
+		humidity:        defaultHumidity,
+		baseTemperature: defaultTemperature,
+		baseHumidity:    defaultHumidity,
+		last:            clock.Now(),
+	}
+}
+
+// advance runs the drift up to now. Must be called with mu held.
+func (d *ClimateSensor) advance(now time.Time) {
+	steps(&d.last, now, func(at time.Time) {
+		d.temperature += (d.baseTemperature-d.temperature)*driftReversion + (d.rng.Float64()*2-1)*0.1
+		d.humidity += (d.baseHumidity-d.humidity)*driftReversion + (d.rng.Float64()*2-1)*0.5
+		d.humidity = min(max(d.humidity, 0), 100)
+		d.updatedAt = at
+	})
+}
+
+func (d *ClimateSensor) Execute(ctx context.Context, cmd device.Command) error {
+	return device.ErrUnknownCommand
+}
+
+// setState sets the readings, which are also the values they drift around from then on.
+func (d *ClimateSensor) setState(state map[string]any) error {
+	return eachState(state, map[string]func(v any) error{
+		"temperature": func(v any) error {
+			return numberState(v, -40, 60, func(t float64) { d.temperature, d.baseTemperature = t, t })
+		},
+		"humidity": func(v any) error {
+			return numberState(v, 0, 100, func(h float64) { d.humidity, d.baseHumidity = h, h })
+		},
+	})
+}
+
+func (d *ClimateSensor) State(ctx context.Context) (device.State, error) {
+	return d.state("sensor", func(now time.Time) map[string]any {
+		d.advance(now)
+		return map[string]any{
+			"temperature": round(d.temperature, 1),
+			"humidity":    round(d.humidity, 1),
+		}
+	})
+}
+
+// MotionSensor sees someone pass now and then, and reports motion for a while after.
+// The trigger command makes it see motion straight away.
+type MotionSensor struct {
+	base
+	rng        *rand.Rand
+	lastMotion time.Time
+	motion     bool
+	last       time.Time
+}
+
+func NewMotionSensor(id device.ID, name string, clock Clock) *MotionSensor {
+	return &MotionSensor{
+		base: newBase(id, name, clock),
+		rng:  newRand(id),
+		last: clock.Now(),
+	}
+}
+
+// advance runs the model up to now. Must be called with mu held.
+func (d *MotionSensor) advance(now time.Time) {
+	steps(&d.last, now, func(at time.Time) {
+		if d.rng.Float64() < motionChance {
+			d.detect(at)
+		}
+	})
+	if d.motion && now.Sub(d.lastMotion) >= motionHold {
+		d.motion = false
+		d.updatedAt = d.lastMotion.Add(motionHold)
+	}
+}
+
+// detect records motion at the given time. Must be called with mu held.
+func (d *MotionSensor) detect(at time.Time) {
+	if !d.motion || at.Sub(d.lastMotion) >= motionHold {
+		d.updatedAt = at
+	}
+	d.motion = true
+	d.lastMotion = at
+}
+
+func (d *MotionSensor) Execute(ctx context.Context, cmd device.Command) error {
+	if cmd.Action != "trigger" {
+		return device.ErrUnknownCommand
+	}
+	return d.execute(ctx, func(now time.Time) error {
+		d.advance(now)
+		d.detect(now)
+		return nil
+	})
+}
+
+func (d *MotionSensor) setState(state map[string]any) error {
+	return eachState(state, map[string]func(v any) error{
+		"motion": func(v any) error {
+			return boolState(v, func(motion bool) {
+				if motion {
+					d.detect(d.clock.Now())
+				}
+			})
+		},
+	})
+}
+
+func (d *MotionSensor) State(ctx context.Context) (device.State, error) {
+	return d.state("sensor", func(now time.Time) map[string]any {
+		d.advance(now)
+		return map[string]any{"motion": d.motion}
+	})
+}
@@ -3,81 +3,203 @@ package simulator
 import (
 	"context"
 	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
 	"sync"
 	"time"
 
 	"github.com/legitlolly/SmartHomeHub/internal/device"
 )
 
+// latency is how long every simulated command takes, as a round trip to a real
+// device would.
+const latency = 100 * time.Millisecond
+
+// base is what every simulated device has: its ID, the clock its model runs on, its
+// faults and the lock guarding its state.
+type base struct {
+	id    device.ID
+	name  string
+	room  string
+	clock Clock
+
+	mu        sync.Mutex
+	updatedAt time.Time
+	faults    Faults
+	faultRand *rand.Rand
+	frozen    *device.State // what a stuck device keeps reporting
+}
+
+func newBase(id device.ID, name string, clock Clock) base {
+	seed := seedFor(id)
+	return base{
+		id:        id,
+		name:      name,
+		clock:     clock,
+		updatedAt: clock.Now(),
+		faultRand: rand.New(rand.NewPCG(seed, seed)),
+	}
+}
+
+func (b *base) ID() device.ID {
+	return b.id
+}
+
+func (b *base) setRoom(room string) {
+	b.room = room
+}
+
+// execute applies a command after the simulated latency, unless the faults decide
+// otherwise. apply is called with mu held and the current time; the device counts
+// as updated if it succeeds.
+func (b *base) execute(ctx context.Context, apply func(now time.Time) error) error {
+	b.mu.Lock()
+	delay, outcome, err := b.plan()
+	b.mu.Unlock()
+	if errors.Is(err, device.ErrDeviceUnavailable) {
+		return err
+	}
+
+	if err := wait(ctx, delay); err != nil {
+		return err
+	}
+	switch outcome {
+	case fail:
+		return err
+	case hang:
+		if err := wait(ctx, timeoutHang); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: no answer", context.DeadlineExceeded)
+	case ignore:
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.clock.Now()
+	if err := apply(now); err != nil {
+		return err
+	}
+	b.updatedAt = now
+	return nil
+}
+
+// apply changes the device outside a command, without latency or faults. fn is
+// called with mu held and the current time.
+func (b *base) apply(fn func(now time.Time) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.clock.Now()
+	if err := fn(now); err != nil {
+		return err
+	}
+	b.updatedAt = now
+	return nil
+}
+
+// state builds the device state. attributes is called with mu held and the current
+// time, so time-based models can catch up first.
+func (b *base) state(deviceType string, attributes func(now time.Time) map[string]any) (device.State, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	// A stuck device that drops off the network is offline all the same
+	offline := b.offline(now)
+	if b.frozen != nil && !offline {
+		return copyState(*b.frozen), nil
+	}
+
+	attrs := attributes(now)
+	if b.name != "" {
+		attrs["name"] = b.name
+	}
+	if b.room != "" {
+		attrs["room"] = b.room
+	}
+	state := device.State{
+		DeviceType: deviceType,
+		UpdatedAt:  b.updatedAt,
+		Attributes: attrs,
+	}
+	if offline {
+		return state, fmt.Errorf("%w: %s is offline", device.ErrDeviceUnavailable, b.id)
+	}
+	if b.faults.Stuck {
+		frozen := copyState(state)
+		b.frozen = &frozen
+	}
+	return state, nil
+}
+
+func copyState(state device.State) device.State {
+	state.Attributes = maps.Clone(state.Attributes)
+	return state
+}
+
+// SimulatedDevice is a dimmable light.
 type SimulatedDevice struct {
-	id device.ID
+	base
 
 	// Internal state - just the raw values
 	power      string
 	brightness int
-	updatedAt  time.Time
-
-	stateMutex sync.RWMutex
 }
 
 func NewSimulatedDevice(id device.ID) *SimulatedDevice {
+	return newLight(id, "", SystemClock)
+}
+
+func newLight(id device.ID, name string, clock Clock) *SimulatedDevice {
 	return &SimulatedDevice{
-		id:         id,
+		base:       newBase(id, name, clock),
 		power:      "off", // Start off
 		brightness: 0,
-		updatedAt:  time.Now(),
 	}
 }
 
-func (d *SimulatedDevice) ID() device.ID {
-	return d.id
-}
-
 func (d *SimulatedDevice) Execute(ctx context.Context, cmd device.Command) error {
-	d.stateMutex.Lock()
-	defer d.stateMutex.Unlock()
-
-	// Simulated latency
-	time.Sleep(100 * time.Millisecond)
-
-	switch cmd.Action {
-	case "turn_on":
-		d.power = "on"
-	case "turn_off":
-		d.power = "off"
-	case "set_brightness":
-		var brightness int
-		switch v := cmd.Params["value"].(type) {
-		case float64:
-			brightness = int(v)
-		case int:
-			brightness = v
+	return d.execute(ctx, func(time.Time) error {
+		switch cmd.Action {
+		case "turn_on":
+			d.power = "on"
+		case "turn_off":
+			d.power = "off"
+		case "toggle":
+			d.power = device.OnOff(d.power != "on")
+		case "set_brightness":
+			brightness, err := device.NumberParam(cmd.Params, "value", 0, 100)
+			if err != nil {
+				return err
+			}
+			d.brightness = int(brightness)
 		default:
-			return errors.New("Invalid value for brightness")
+			return device.ErrUnknownCommand
 		}
-		if brightness < 0 || brightness > 100 {
-			return errors.New("Brightness must be 0-100")
-		}
-		d.brightness = brightness
-	default:
-		return errors.New("unknown command")
-	}
+		return nil
+	})
+}
 
-	d.updatedAt = time.Now()
-	return nil
+func (d *SimulatedDevice) advance(time.Time) {}
+
+// setState sets the power and brightness.
+func (d *SimulatedDevice) setState(state map[string]any) error {
+	return eachState(state, map[string]func(v any) error{
+		"power": func(v any) error { return powerState(v, func(on bool) { d.power = device.OnOff(on) }) },
+		"brightness": func(v any) error {
+			return numberState(v, 0, 100, func(n float64) { d.brightness = int(n) })
+		},
+	})
 }
 
 func (d *SimulatedDevice) State(ctx context.Context) (device.State, error) {
-	d.stateMutex.RLock()
-	defer d.stateMutex.RUnlock()
-
 	// Build the State struct from internal fields
-	return device.State{
-		DeviceType: "light",
-		UpdatedAt:  d.updatedAt,
-		Attributes: map[string]interface{}{
+	return d.state("light", func(time.Time) map[string]any {
+		return map[string]any{
 			"power":      d.power,
 			"brightness": d.brightness,
-		},
-	}, nil
+		}
+	})
 }
@@ -2,9 +2,19 @@ package simulator
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/legitlolly/SmartHomeHub/internal/config"
 	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/device/devicetest"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
 )
 
 func TestSimulatedDevice_ExecuteandState(t *testing.T) {
@@ -55,3 +65,647 @@ func TestSimulatedDevice_InvalidCommand(t *testing.T) {
 		t.Fatal("expected error for invalid command, got nil")
 	}
 }
+
+// testClock only moves when the test says so.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func attrs(t *testing.T, dev device.Device) map[string]any {
+	t.Helper()
+	state, err := dev.State(context.Background())
+	if err != nil {
+		t.Fatalf("State failed: %v", err)
+	}
+	return state.Attributes
+}
+
+func execute(t *testing.T, dev device.Device, action string, params map[string]any) {
+	t.Helper()
+	if err := dev.Execute(context.Background(), device.Command{DeviceID: dev.ID(), Action: action, Params: params}); err != nil {
+		t.Fatalf("Execute %s failed: %v", action, err)
+	}
+}
+
+func TestThermostat_ThermalModel(t *testing.T) {
+	clock := newTestClock()
+	dev := NewThermostat("radiator", "Radiator", clock)
+
+	a := attrs(t, dev)
+	if a["current_temperature"] != 18.0 || a["target_temperature"] != 20.0 || a["name"] != "Radiator" {
+		t.Fatalf("Unexpected initial state %v", a)
+	}
+
+	clock.Advance(5 * time.Minute)
+	a = attrs(t, dev)
+	if temp := a["current_temperature"].(float64); temp <= 18.5 || a["hvac_action"] != "heating" {
+		t.Errorf("Expected the room to be heating up, got %v %v", temp, a["hvac_action"])
+	}
+
+	// The heating cycles around the target once it is reached
+	clock.Advance(2 * time.Hour)
+	if temp := attrs(t, dev)["current_temperature"].(float64); temp < 19.6 || temp > 20.1 {
+		t.Errorf("Expected the room to be held near 20, got %v", temp)
+	}
+
+	execute(t, dev, "set_temperature", map[string]any{"value": 22.5})
+	clock.Advance(time.Minute)
+	if a := attrs(t, dev); a["target_temperature"] != 22.5 || a["hvac_action"] != "heating" {
+		t.Errorf("Expected heating towards 22.5, got %v", a)
+	}
+
+	// Switched off, the room cools towards the outside
+	execute(t, dev, "set_mode", map[string]any{"value": "off"})
+	clock.Advance(6 * time.Hour)
+	a = attrs(t, dev)
+	if temp := a["current_temperature"].(float64); temp > 16 || a["hvac_action"] != "off" {
+		t.Errorf("Expected the room to cool down with the heating off, got %v %v", temp, a["hvac_action"])
+	}
+
+	ctx := context.Background()
+	if err := dev.Execute(ctx, device.Command{Action: "set_temperature", Params: map[string]any{"value": 40}}); !errors.Is(err, device.ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter above the setpoint range, got %v", err)
+	}
+	if err := dev.Execute(ctx, device.Command{Action: "set_mode", Params: map[string]any{"value": "auto"}}); !errors.Is(err, device.ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter for an unknown mode, got %v", err)
+	}
+}
+
+func TestClimateSensor_Drifts(t *testing.T) {
+	clock := newTestClock()
+	dev := NewClimateSensor("hall", "", clock)
+	twin := NewClimateSensor("hall", "", clock)
+
+	clock.Advance(3 * time.Hour)
+	a := attrs(t, dev)
+	temp, humidity := a["temperature"].(float64), a["humidity"].(float64)
+	if temp == defaultTemperature && humidity == defaultHumidity {
+		t.Error("Expected the readings to drift")
+	}
+	if temp < 19 || temp > 23 || humidity < 35 || humidity > 55 {
+		t.Errorf("Expected readings near the base values, got %v %v", temp, humidity)
+	}
+
+	// The same ID and clock give the same readings
+	if b := attrs(t, twin); b["temperature"] != temp || b["humidity"] != humidity {
+		t.Errorf("Expected %v %v from the same seed, got %v %v", temp, humidity, b["temperature"], b["humidity"])
+	}
+	if err := dev.Execute(context.Background(), device.Command{Action: "turn_on"}); !errors.Is(err, device.ErrUnknownCommand) {
+		t.Errorf("Expected ErrUnknownCommand for a sensor, got %v", err)
+	}
+}
+
+func TestMotionSensor_Trigger(t *testing.T) {
+	clock := newTestClock()
+	dev := NewMotionSensor("landing", "", clock)
+
+	execute(t, dev, "trigger", nil)
+	if attrs(t, dev)["motion"] != true {
+		t.Error("Expected motion after the trigger")
+	}
+	clock.Advance(motionHold)
+	if attrs(t, dev)["motion"] != false {
+		t.Error("Expected motion to clear")
+	}
+
+	// Someone passes now and then on their own
+	seen := false
+	for i := 0; i < 24*60 && !seen; i++ {
+		clock.Advance(time.Minute)
+		seen = attrs(t, dev)["motion"] == true
+	}
+	if !seen {
+		t.Error("Expected random motion within a day")
+	}
+}
+
+func TestCover_TravelTime(t *testing.T) {
+	clock := newTestClock()
+	dev := NewCover("blind", "", clock)
+
+	if a := attrs(t, dev); a["position"] != 0 || a["cover_state"] != "closed" {
+		t.Fatalf("Expected the cover to start closed, got %v", a)
+	}
+	execute(t, dev, "open", nil)
+	clock.Advance(coverTravelTime / 2)
+	if a := attrs(t, dev); a["position"] != 50 || a["cover_state"] != "opening" {
+		t.Errorf("Expected the cover half way, got %v", a)
+	}
+	clock.Advance(coverTravelTime)
+	if a := attrs(t, dev); a["position"] != 100 || a["cover_state"] != "open" {
+		t.Errorf("Expected the cover open, got %v", a)
+	}
+
+	execute(t, dev, "set_position", map[string]any{"value": 20})
+	clock.Advance(coverTravelTime / 4)
+	if a := attrs(t, dev); a["position"] != 75 || a["cover_state"] != "closing" {
+		t.Errorf("Expected the cover closing at 75, got %v", a)
+	}
+	execute(t, dev, "stop", nil)
+	clock.Advance(coverTravelTime)
+	if a := attrs(t, dev); a["position"] != 75 || a["cover_state"] != "open" {
+		t.Errorf("Expected the cover stopped at 75, got %v", a)
+	}
+}
+
+func TestSwitchAndLock(t *testing.T) {
+	clock := newTestClock()
+	sw := NewSwitch("kettle", "", clock)
+	execute(t, sw, "toggle", nil)
+	if attrs(t, sw)["power"] != "on" {
+		t.Error("Expected toggle to turn the switch on")
+	}
+
+	lock := NewLock("front-door", "", clock)
+	if attrs(t, lock)["locked"] != true {
+		t.Error("Expected the lock to start locked")
+	}
+	execute(t, lock, "unlock", nil)
+	if attrs(t, lock)["locked"] != false {
+		t.Error("Expected the lock to be unlocked")
+	}
+	if err := lock.Execute(context.Background(), device.Command{Action: "turn_on"}); !errors.Is(err, device.ErrUnknownCommand) {
+		t.Errorf("Expected ErrUnknownCommand, got %v", err)
+	}
+}
+
+func TestProvider_Catalogue(t *testing.T) {
+	raw := json.RawMessage(`{"devices": ["lamp", {"id": "radiator", "type": "thermostat", "name": "Radiator"}, {"id": "blind", "type": "cover"}]}`)
+	p, err := NewProvider("simulator", raw, provider.Env{})
+	if err != nil {
+		t.Fatalf("NewProvider failed: %v", err)
+	}
+	registry := device.NewRegistry()
+	ctx := context.Background()
+	if err := p.Start(ctx, registry); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := p.Discover(ctx); err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+
+	for id, deviceType := range map[device.ID]string{"lamp": "light", "radiator": "thermostat", "blind": "cover"} {
+		dev, err := registry.Get(id)
+		if err != nil {
+			t.Errorf("Expected %s to be registered: %v", id, err)
+			continue
+		}
+		if state, _ := dev.State(ctx); state.DeviceType != deviceType {
+			t.Errorf("%s: expected type %s, got %s", id, deviceType, state.DeviceType)
+		}
+	}
+
+	raw = json.RawMessage(`{"devices": [{"id": "toaster", "type": "toaster"}]}`)
+	if _, err := NewProvider("simulator", raw, provider.Env{}); !errors.Is(err, ErrUnknownType) {
+		t.Errorf("Expected ErrUnknownType, got %v", err)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	clock := newTestClock()
+	dev := NewSwitch("kettle", "", clock)
+	ctx := context.Background()
+	instant := Latency{Distribution: "fixed"}
+	turnOn := device.Command{Action: "turn_on"}
+
+	// Failing the next commands
+	dev.SetFaults(Faults{Latency: instant, FailNext: 2})
+	for i := 0; i < 2; i++ {
+		if err := dev.Execute(ctx, turnOn); !errors.Is(err, ErrInjected) {
+			t.Fatalf("Expected ErrInjected, got %v", err)
+		}
+	}
+	execute(t, dev, "turn_on", nil)
+
+	// The same seed fails the same commands
+	outcomes := func() []bool {
+		dev.SetFaults(Faults{Latency: instant, ErrorRate: 0.5, Seed: 42})
+		var failed []bool
+		for i := 0; i < 20; i++ {
+			failed = append(failed, dev.Execute(ctx, device.Command{Action: "toggle"}) != nil)
+		}
+		return failed
+	}
+	first := outcomes()
+	if !slices.Contains(first, true) || !slices.Contains(first, false) {
+		t.Errorf("Expected some of the commands to fail, got %v", first)
+	}
+	if second := outcomes(); !slices.Equal(first, second) {
+		t.Errorf("Expected the same failures from the same seed, got %v and %v", first, second)
+	}
+
+	// Commands that never answer
+	dev.SetFaults(Faults{Latency: instant, TimeoutRate: 1})
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := dev.Execute(timeoutCtx, turnOn); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the command to time out, got %v", err)
+	}
+
+	// Ignored commands succeed without doing anything
+	dev.SetFaults(Faults{Latency: instant})
+	execute(t, dev, "turn_off", nil)
+	dev.SetFaults(Faults{Latency: instant, IgnoreCommands: true})
+	execute(t, dev, "turn_on", nil)
+	if attrs(t, dev)["power"] != "off" {
+		t.Error("Expected the command to be ignored")
+	}
+
+	// A stuck device keeps reporting the same state
+	dev.SetFaults(Faults{Latency: instant, Stuck: true})
+	attrs(t, dev)
+	execute(t, dev, "turn_on", nil)
+	if attrs(t, dev)["power"] != "off" {
+		t.Error("Expected the stuck state")
+	}
+	dev.SetFaults(Faults{Latency: instant, Stuck: true, Offline: true})
+	if _, err := dev.State(ctx); !errors.Is(err, device.ErrDeviceUnavailable) {
+		t.Errorf("Expected a stuck device to go offline, got %v", err)
+	}
+	dev.SetFaults(Faults{})
+	if attrs(t, dev)["power"] != "on" {
+		t.Error("Expected the real state once unstuck")
+	}
+}
+
+func TestFaults_Offline(t *testing.T) {
+	clock := newTestClock()
+	dev := NewThermostat("radiator", "", clock)
+	ctx := context.Background()
+
+	if err := dev.SetFaults(Faults{Offline: true, OfflineFor: config.Duration(time.Minute)}); err != nil {
+		t.Fatalf("SetFaults failed: %v", err)
+	}
+	if until := dev.Faults().OfflineUntil; !until.Equal(clock.Now().Add(time.Minute)) {
+		t.Errorf("Expected offline for a minute, got until %v", until)
+	}
+	if _, err := dev.State(ctx); !errors.Is(err, device.ErrDeviceUnavailable) {
+		t.Errorf("Expected ErrDeviceUnavailable from State, got %v", err)
+	}
+	err := dev.Execute(ctx, device.Command{Action: "set_mode", Params: map[string]any{"value": "off"}})
+	if !errors.Is(err, device.ErrDeviceUnavailable) {
+		t.Errorf("Expected ErrDeviceUnavailable from Execute, got %v", err)
+	}
+
+	clock.Advance(time.Minute)
+	if _, err := dev.State(ctx); err != nil {
+		t.Errorf("Expected the device back after the window, got %v", err)
+	}
+	if dev.Faults().Offline {
+		t.Error("Expected the offline fault to be over")
+	}
+}
+
+func TestFaults_Validation(t *testing.T) {
+	invalid := []Faults{
+		{ErrorRate: 1.5},
+		{TimeoutRate: -0.1},
+		{FailNext: -1},
+		{Latency: Latency{Distribution: "poisson"}},
+		{Latency: Latency{Distribution: "uniform", Min: config.Duration(time.Second)}},
+	}
+	dev := NewLock("front-door", "", newTestClock())
+	for _, f := range invalid {
+		if err := dev.SetFaults(f); !errors.Is(err, ErrInvalidFaults) {
+			t.Errorf("%+v: expected ErrInvalidFaults, got %v", f, err)
+		}
+	}
+
+	rng := newRand("latency")
+	uniform := Latency{Distribution: "uniform", Min: config.Duration(10 * time.Millisecond), Max: config.Duration(20 * time.Millisecond)}
+	normal := Latency{Distribution: "normal", Mean: config.Duration(time.Millisecond), StdDev: config.Duration(10 * time.Millisecond)}
+	for i := 0; i < 100; i++ {
+		if d := uniform.sample(rng); d < 10*time.Millisecond || d > 20*time.Millisecond {
+			t.Fatalf("Uniform latency %v out of range", d)
+		}
+		if d := normal.sample(rng); d < 0 {
+			t.Fatalf("Negative normal latency %v", d)
+		}
+	}
+	if d := (Latency{}).sample(rng); d != latency {
+		t.Errorf("Expected the default latency, got %v", d)
+	}
+}
+
+func TestLoadHome_Example(t *testing.T) {
+	home, err := LoadHome("../../../examples/home.yaml")
+	if err != nil {
+		t.Fatalf("LoadHome failed: %v", err)
+	}
+	configs, err := home.Devices()
+	if err != nil {
+		t.Fatalf("Devices failed: %v", err)
+	}
+	if len(configs) != 11 {
+		t.Errorf("Expected 11 devices, got %d", len(configs))
+	}
+
+	byID := make(map[device.ID]DeviceConfig)
+	for _, cfg := range configs {
+		byID[cfg.ID] = cfg
+	}
+	if cfg := byID["living-room-light-2"]; cfg.Name != "Living room light 2" || cfg.Room != "Living room" {
+		t.Errorf("Unexpected numbered light %+v", cfg)
+	}
+	if cfg := byID["hall-light"]; cfg.Faults == nil || cfg.Faults.Latency.Max != config.Duration(1500*time.Millisecond) {
+		t.Errorf("Expected the flaky light's faults, got %+v", cfg.Faults)
+	}
+
+	dev, _ := NewDevice(byID["living-room-thermostat"], newTestClock())
+	if a := attrs(t, dev); a["name"] != "Radiator" || a["room"] != "Living room" || a["target_temperature"] != 21.0 || a["current_temperature"] != 18.5 {
+		t.Errorf("Unexpected thermostat %v", a)
+	}
+}
+
+func TestHome_Invalid(t *testing.T) {
+	tests := map[string]Home{
+		"no room name": {Rooms: []Room{{Devices: []RoomDevice{{}}}}},
+		"duplicate":    {Rooms: []Room{{Name: "Hall", Devices: []RoomDevice{{Type: "lock"}, {Type: "lock"}}}}},
+		"unknown type": {Rooms: []Room{{Name: "Hall", Devices: []RoomDevice{{Type: "toaster"}}}}},
+		"bad state":    {Rooms: []Room{{Name: "Hall", Devices: []RoomDevice{{State: map[string]any{"brightness": 150}}}}}},
+		"wrong state":  {Rooms: []Room{{Name: "Hall", Devices: []RoomDevice{{Type: "lock", State: map[string]any{"power": "on"}}}}}},
+		"bad faults":   {Rooms: []Room{{Name: "Hall", Devices: []RoomDevice{{Faults: &Faults{ErrorRate: 2}}}}}},
+	}
+	for name, home := range tests {
+		if _, err := home.Devices(); !errors.Is(err, ErrInvalidHome) {
+			t.Errorf("%s: expected ErrInvalidHome, got %v", name, err)
+		}
+	}
+}
+
+func TestProvider_ReloadsHome(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "home.json")
+	writeHome := func(home string) {
+		if err := os.WriteFile(path, []byte(home), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeHome(`{"rooms": [{"name": "Kitchen", "devices": [
+		{"type": "light", "count": 2},
+		{"type": "lock", "state": {"locked": false}}
+	]}]}`)
+
+	raw, _ := json.Marshal(Settings{Devices: []DeviceConfig{{ID: "lamp"}}, Home: path})
+	p, err := NewProvider("simulator", raw, provider.Env{})
+	if err != nil {
+		t.Fatalf("NewProvider failed: %v", err)
+	}
+	registry := device.NewRegistry()
+	ctx := context.Background()
+	p.Start(ctx, registry)
+	if err := p.Discover(ctx); err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	if n := len(registry.List()); n != 4 {
+		t.Fatalf("Expected 4 devices, got %d", n)
+	}
+	light, _ := registry.Get("kitchen-light-1")
+	execute(t, light, "turn_on", nil)
+
+	// The lock changes, the second light goes and a switch is added
+	writeHome(`{"rooms": [{"name": "Kitchen", "devices": [
+		{"type": "light", "id": "kitchen-light-1", "name": "Kitchen light 1"},
+		{"type": "lock", "state": {"locked": true}},
+		{"type": "switch"}
+	]}]}`)
+	if err := p.Discover(ctx); err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	if _, err := registry.Get("kitchen-light-2"); err == nil {
+		t.Error("Expected the removed light to be unregistered")
+	}
+	if _, err := registry.Get("kitchen-switch"); err != nil {
+		t.Errorf("Expected the new switch: %v", err)
+	}
+	lock, _ := registry.Get("kitchen-lock")
+	if attrs(t, lock)["locked"] != true {
+		t.Error("Expected the changed lock to be rebuilt")
+	}
+	if again, _ := registry.Get("kitchen-light-1"); again != light || attrs(t, light)["power"] != "on" {
+		t.Error("Expected the unchanged light to keep its state")
+	}
+
+	// A broken file leaves the devices alone
+	writeHome(`{"rooms": [{"name": "Kitchen", "devices": [{"type": "toaster"}]}]}`)
+	if err := p.Discover(ctx); !errors.Is(err, ErrInvalidHome) {
+		t.Errorf("Expected ErrInvalidHome, got %v", err)
+	}
+	if n := len(registry.List()); n != 4 {
+		t.Errorf("Expected the 4 devices to stay, got %d", n)
+	}
+}
+
+func TestVirtualClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewVirtualClock(start)
+
+	time.Sleep(10 * time.Millisecond)
+	if !clock.Now().Equal(start) {
+		t.Errorf("Expected a new clock to be paused, got %v", clock.Now())
+	}
+	clock.Advance(time.Hour)
+	if got := clock.Now(); !got.Equal(start.Add(time.Hour)) {
+		t.Errorf("Expected %v after advancing, got %v", start.Add(time.Hour), got)
+	}
+
+	clock.SetSpeed(3600)
+	time.Sleep(20 * time.Millisecond)
+	clock.SetSpeed(0)
+	paused := clock.Now()
+	if ran := paused.Sub(start.Add(time.Hour)); ran < time.Minute {
+		t.Errorf("Expected over a minute at an hour a second, got %v", ran)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if !clock.Now().Equal(paused) {
+		t.Error("Expected the clock to stop when paused")
+	}
+}
+
+func TestScenario(t *testing.T) {
+	clock := NewVirtualClock(time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC))
+	registry := device.NewRegistry()
+	for _, cfg := range []DeviceConfig{
+		{ID: "hall-motion", Type: "motion"},
+		{ID: "hall-climate", Type: "climate"},
+		{ID: "hall-thermostat", Type: "thermostat"},
+		{ID: "hall-light", Type: "light"},
+	} {
+		dev, err := NewDevice(cfg, clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		registry.Register(dev)
+	}
+	clock.SetSpeed(1)
+
+	var scenario Scenario
+	err := json.Unmarshal([]byte(`{"name": "evening", "steps": [
+		{"at": "30m", "device": "hall-thermostat", "expect": {"current_temperature": {"min": 19, "max": 21}}},
+		{"at": "0s", "device": "hall-motion", "set": {"motion": true}},
+		{"at": "0s", "device": "hall-light", "action": "set_brightness", "params": {"value": 30}},
+		{"at": "5m", "device": "hall-climate", "set": {"temperature": 17}, "expect": {"temperature": 17}},
+		{"at": "5m", "device": "hall-motion", "expect": {"motion": false}},
+		{"at": "5m", "device": "hall-light", "expect": {"brightness": 30}},
+		{"at": "5m", "device": "hall-thermostat", "expect": {"hvac_action": "heating"}}
+	]}`), &scenario)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runner := NewRunner(clock, registry.Get)
+	start := clock.Now()
+	result, err := runner.Run(context.Background(), scenario)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if err := result.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Steps) != 7 || result.Steps[0].Device != "hall-motion" {
+		t.Errorf("Expected the steps in time order, got %+v", result.Steps)
+	}
+	if ran := clock.Now().Sub(start); ran < 30*time.Minute || ran > 31*time.Minute {
+		t.Errorf("Expected the clock to be 30m on, got %v", ran)
+	}
+	if clock.Speed() != 1 {
+		t.Errorf("Expected the clock to go back to speed 1, got %v", clock.Speed())
+	}
+
+	// Failed expectations and commands are reported, not returned
+	result, err = runner.Run(context.Background(), Scenario{Name: "broken", Steps: []Step{
+		{Device: "hall-light", Expect: map[string]any{"power": "on", "colour": "red"}},
+		{Device: "hall-light", Action: "fly"},
+		{At: config.Duration(time.Minute), Device: "attic-light", Action: "turn_on"},
+	}})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if result.Passed || len(result.Steps[0].Failures) != 2 || result.Steps[1].Error == "" || result.Steps[2].Error == "" {
+		t.Errorf("Expected every step to fail, got %+v", result.Steps)
+	}
+	if result.Err() == nil {
+		t.Error("Expected an error summing up the failures")
+	}
+
+	for _, steps := range [][]Step{
+		{{At: config.Duration(-time.Second), Device: "hall-light"}},
+		{{Expect: map[string]any{"power": "on"}}},
+	} {
+		if _, err := runner.Run(context.Background(), Scenario{Steps: steps}); !errors.Is(err, ErrInvalidScenario) {
+			t.Errorf("Expected ErrInvalidScenario for %+v, got %v", steps, err)
+		}
+	}
+}
+
+func TestProvider_VirtualTime(t *testing.T) {
+	raw, _ := json.Marshal(Settings{Devices: []DeviceConfig{{ID: "radiator", Type: "thermostat"}}, VirtualTime: true})
+	p, err := NewProvider("simulator", raw, provider.Env{})
+	if err != nil {
+		t.Fatalf("NewProvider failed: %v", err)
+	}
+	sim := p.(*Provider)
+	ctx := context.Background()
+	sim.Start(ctx, device.NewRegistry())
+	if err := sim.Discover(ctx); err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+
+	clock := sim.VirtualClock()
+	if clock == nil || clock.Speed() != 1 {
+		t.Fatal("Expected a virtual clock running in real time")
+	}
+	clock.Advance(2 * time.Hour)
+	result, err := sim.Runner().Run(ctx, Scenario{Steps: []Step{
+		{Device: "radiator", Expect: map[string]any{"current_temperature": map[string]any{"min": 19.5, "max": 20.5}}},
+	}})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if err := result.Err(); err != nil {
+		t.Error(err)
+	}
+
+	plain, _ := NewProvider("simulator", nil, provider.Env{})
+	if plain.(*Provider).VirtualClock() != nil || plain.(*Provider).Runner() != nil {
+		t.Error("Expected no virtual clock without virtual_time")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	// A short latency keeps the suite quick while commands still wait on the context
+	fast := func(dev Faulty) device.Device {
+		dev.SetFaults(Faults{Latency: Latency{Distribution: "fixed", Mean: config.Duration(time.Millisecond)}})
+		return dev
+	}
+	offline := func(t *testing.T, d device.Device) {
+		d.(Faulty).SetFaults(Faults{Offline: true})
+	}
+
+	t.Run("light", func(t *testing.T) {
+		suite := devicetest.Light(func(t *testing.T) device.Device {
+			return fast(NewSimulatedDevice("light"))
+		})
+		suite.Offline = offline
+		devicetest.Run(t, suite)
+	})
+
+	t.Run("switch", func(t *testing.T) {
+		devicetest.Run(t, devicetest.Suite{
+			New:        func(t *testing.T) device.Device { return fast(NewSwitch("switch", "", newTestClock())) },
+			DeviceType: "switch",
+			Commands: []devicetest.Command{
+				{Action: "turn_on", Expect: map[string]any{"power": "on"}},
+				{Action: "turn_off", Expect: map[string]any{"power": "off"}},
+			},
+			Offline: offline,
+		})
+	})
+
+	t.Run("lock", func(t *testing.T) {
+		devicetest.Run(t, devicetest.Suite{
+			New:        func(t *testing.T) device.Device { return fast(NewLock("lock", "", newTestClock())) },
+			DeviceType: "lock",
+			Commands: []devicetest.Command{
+				{Action: "unlock", Expect: map[string]any{"locked": false}},
+				{Action: "lock", Expect: map[string]any{"locked": true}},
+			},
+			Offline: offline,
+		})
+	})
+
+	t.Run("thermostat", func(t *testing.T) {
+		devicetest.Run(t, devicetest.Suite{
+			New:        func(t *testing.T) device.Device { return fast(NewThermostat("thermostat", "", newTestClock())) },
+			DeviceType: "thermostat",
+			Commands: []devicetest.Command{
+				{Action: "set_temperature", Params: map[string]any{"value": 22.5}, Expect: map[string]any{"target_temperature": 22.5}},
+				{Action: "set_mode", Params: map[string]any{"value": "cool"}, Expect: map[string]any{"mode": "cool"}},
+				{Action: "set_temperature", Params: map[string]any{"value": 18}, Expect: map[string]any{"target_temperature": 18}},
+				{Action: "set_mode", Params: map[string]any{"value": "heat"}, Expect: map[string]any{"mode": "heat"}},
+			},
+			Invalid: []devicetest.Command{
+				{Action: "set_temperature", Params: map[string]any{"value": 40}},
+				{Action: "set_temperature", Params: map[string]any{"value": "warm"}},
+				{Action: "set_mode", Params: map[string]any{"value": "auto"}},
+			},
+			Offline: offline,
+		})
+	})
+}
@@ -0,0 +1,86 @@
+package simulator
+
+import (
+	"context"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+)
+
+// Switch is an on/off relay or plug.
+type Switch struct {
+	base
+	on bool
+}
+
+func NewSwitch(id device.ID, name string, clock Clock) *Switch {
+	return &Switch{base: newBase(id, name, clock)}
+}
+
+func (d *Switch) Execute(ctx context.Context, cmd device.Command) error {
+	return d.execute(ctx, func(time.Time) error {
+		switch cmd.Action {
+		case "turn_on":
+			d.on = true
+		case "turn_off":
+			d.on = false
+		case "toggle":
+			d.on = !d.on
+		default:
+			return device.ErrUnknownCommand
+		}
+		return nil
+	})
+}
+
+func (d *Switch) advance(time.Time) {}
+
+func (d *Switch) setState(state map[string]any) error {
+	return eachState(state, map[string]func(v any) error{
+		"power": func(v any) error { return powerState(v, func(on bool) { d.on = on }) },
+	})
+}
+
+func (d *Switch) State(ctx context.Context) (device.State, error) {
+	return d.state("switch", func(time.Time) map[string]any {
+		return map[string]any{"power": device.OnOff(d.on)}
+	})
+}
+
+// Lock is a door lock. It starts locked.
+type Lock struct {
+	base
+	locked bool
+}
+
+func NewLock(id device.ID, name string, clock Clock) *Lock {
+	return &Lock{base: newBase(id, name, clock), locked: true}
+}
+
+func (d *Lock) Execute(ctx context.Context, cmd device.Command) error {
+	return d.execute(ctx, func(time.Time) error {
+		switch cmd.Action {
+		case "lock":
+			d.locked = true
+		case "unlock":
+			d.locked = false
+		default:
+			return device.ErrUnknownCommand
+		}
+		return nil
+	})
+}
+
+func (d *Lock) advance(time.Time) {}
+
+func (d *Lock) setState(state map[string]any) error {
+	return eachState(state, map[string]func(v any) error{
+		"locked": func(v any) error { return boolState(v, func(locked bool) { d.locked = locked }) },
+	})
+}
+
+func (d *Lock) State(ctx context.Context) (device.State, error) {
+	return d.state("lock", func(time.Time) map[string]any {
+		return map[string]any{"locked": d.locked}
+	})
+}
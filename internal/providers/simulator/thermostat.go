@@ -0,0 +1,144 @@
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+)
+
+// The thermal model of a room: it loses heat to the outside in proportion to the
+// difference, and the heating or cooling adds a fixed rate on top while running.
+const (
+	ambientTemperature = 12.0
+	heatLossTime       = 2 * time.Hour // time constant of the room cooling down
+	heatingRate        = 0.2           // °C per minute while heating or cooling
+	hysteresis         = 0.3           // how far below target heating starts again
+	thermalStep        = 10 * time.Second
+	// The model isn't run for more than this; the room has settled by then
+	thermalHorizon = 24 * time.Hour
+)
+
+var thermostatModes = map[string]bool{"heat": true, "cool": true, "off": true}
+
+// Thermostat heats or cools a simulated room towards its target temperature.
+type Thermostat struct {
+	base
+	mode    string
+	target  float64
+	current float64
+	running bool      // whether the heating or cooling is on
+	last    time.Time // how far the model has been run
+}
+
+func NewThermostat(id device.ID, name string, clock Clock) *Thermostat {
+	return &Thermostat{
+		base:    newBase(id, name, clock),
+		mode:    "heat",
+		target:  20,
+		current: 18,
+		last:    clock.Now(),
+	}
+}
+
+// advance runs the model up to now. Must be called with mu held.
+func (d *Thermostat) advance(now time.Time) {
+	if now.Sub(d.last) > thermalHorizon {
+		d.last = now.Add(-thermalHorizon)
+	}
+	for ; !d.last.Add(thermalStep).After(now); d.last = d.last.Add(thermalStep) {
+		switch d.mode {
+		case "heat":
+			d.running = d.current < d.target-hysteresis || (d.running && d.current < d.target)
+		case "cool":
+			d.running = d.current > d.target+hysteresis || (d.running && d.current > d.target)
+		default:
+			d.running = false
+		}
+
+		dt := thermalStep.Minutes()
+		change := -(d.current - ambientTemperature) * dt / heatLossTime.Minutes()
+		if d.running && d.mode == "heat" {
+			change += heatingRate * dt
+		} else if d.running {
+			change -= heatingRate * dt
+		}
+		d.current += change
+		d.updatedAt = d.last.Add(thermalStep)
+	}
+}
+
+func (d *Thermostat) action() string {
+	switch {
+	case d.mode == "off":
+		return "off"
+	case !d.running:
+		return "idle"
+	case d.mode == "heat":
+		return "heating"
+	default:
+		return "cooling"
+	}
+}
+
+func (d *Thermostat) Execute(ctx context.Context, cmd device.Command) error {
+	return d.execute(ctx, func(now time.Time) error {
+		d.advance(now)
+		switch cmd.Action {
+		case "set_temperature":
+			target, err := device.NumberParam(cmd.Params, "value", 5, 30)
+			if err != nil {
+				return err
+			}
+			d.target = target
+		case "set_mode":
+			mode, _ := cmd.Params["value"].(string)
+			if !thermostatModes[mode] {
+				return fmt.Errorf("%w: mode must be heat, cool or off", device.ErrInvalidParameter)
+			}
+			d.mode = mode
+		default:
+			return device.ErrUnknownCommand
+		}
+		return nil
+	})
+}
+
+func (d *Thermostat) setState(state map[string]any) error {
+	return eachState(state, map[string]func(v any) error{
+		"current_temperature": func(v any) error {
+			return numberState(v, -20, 50, func(t float64) { d.current = t })
+		},
+		"target_temperature": func(v any) error {
+			return numberState(v, 5, 30, func(t float64) { d.target = t })
+		},
+		"mode": func(v any) error {
+			mode, _ := v.(string)
+			if !thermostatModes[mode] {
+				return fmt.Errorf("%w: mode must be heat, cool or off", device.ErrInvalidParameter)
+			}
+			d.mode = mode
+			return nil
+		},
+	})
+}
+
+func (d *Thermostat) State(ctx context.Context) (device.State, error) {
+	return d.state("thermostat", func(now time.Time) map[string]any {
+		d.advance(now)
+		return map[string]any{
+			"current_temperature": round(d.current, 1),
+			"target_temperature":  d.target,
+			"mode":                d.mode,
+			"modes":               []string{"cool", "heat", "off"},
+			"hvac_action":         d.action(),
+		}
+	})
+}
+
+func round(v float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(v*scale) / scale
+}
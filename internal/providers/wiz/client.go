@@ -0,0 +1,198 @@
+package wiz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultPort is where bulbs listen for commands and discovery.
+const DefaultPort = 38899
+
+// resendInterval is how long to wait for a reply before sending a request again;
+// bulbs on busy Wi-Fi do drop packets.
+const resendInterval = 300 * time.Millisecond
+
+var ErrRPC = errors.New("wiz error")
+
+// RPCError is an error returned by the bulb in the "error" member of a reply.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Message)
+}
+
+func (e *RPCError) Unwrap() error {
+	return ErrRPC
+}
+
+type request struct {
+	ID     int    `json:"id"`
+	Method string `json:"method"`
+	Params any    `json:"params"`
+}
+
+// message is any datagram from a bulb: a reply (Result or Error set) or a push
+// (Params set).
+type message struct {
+	Method string          `json:"method"`
+	Env    string          `json:"env"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+// Pilot is the light state in getPilot replies, setPilot requests and syncPilot
+// pushes. A bulb reports either Temp or R, G and B, depending on its mode.
+type Pilot struct {
+	MAC     string `json:"mac,omitempty"`
+	RSSI    int    `json:"rssi,omitempty"`
+	State   *bool  `json:"state,omitempty"`
+	SceneID *int   `json:"sceneId,omitempty"`
+	Dimming *int   `json:"dimming,omitempty"`
+	Temp    *int   `json:"temp,omitempty"`
+	R       *int   `json:"r,omitempty"`
+	G       *int   `json:"g,omitempty"`
+	B       *int   `json:"b,omitempty"`
+}
+
+// merge applies the fields set in update. Setting a color or a temperature switches
+// modes, so the other one and any scene are cleared.
+func (p *Pilot) merge(update Pilot) {
+	if update.MAC != "" {
+		p.MAC = update.MAC
+	}
+	if update.RSSI != 0 {
+		p.RSSI = update.RSSI
+	}
+	if update.State != nil {
+		p.State = update.State
+	}
+	if update.Dimming != nil {
+		p.Dimming = update.Dimming
+	}
+	if update.Temp != nil {
+		p.Temp, p.R, p.G, p.B = update.Temp, nil, nil, nil
+	}
+	if update.R != nil && update.G != nil && update.B != nil {
+		p.Temp, p.R, p.G, p.B = nil, update.R, update.G, update.B
+	}
+	if update.SceneID != nil {
+		p.SceneID = update.SceneID
+	} else if update.Temp != nil || update.R != nil {
+		p.SceneID = nil
+	}
+}
+
+// Client talks to one bulb. Requests are serialized, each on its own socket.
+type Client struct {
+	address string
+	timeout time.Duration
+
+	mu     sync.Mutex
+	nextID int
+}
+
+// NewClient creates a client for address, which is a host or host:port.
+func NewClient(address string) *Client {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, fmt.Sprint(DefaultPort))
+	}
+	return &Client{address: address, timeout: 3 * time.Second}
+}
+
+// Call invokes method and decodes its result into result, if not nil.
+func (c *Client) Call(ctx context.Context, method string, params any, result any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "udp4", c.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	c.nextID++
+	if params == nil {
+		params = struct{}{}
+	}
+	req, err := json.Marshal(request{ID: c.nextID, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	buf := make([]byte, 2048)
+	for {
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		resend := time.Now().Add(resendInterval)
+		if resend.After(deadline) {
+			resend = deadline
+		}
+		conn.SetReadDeadline(resend)
+
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() && ctx.Err() == nil && time.Now().Before(deadline) {
+					break // resend
+				}
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return err
+			}
+
+			var msg message
+			if err := json.Unmarshal(buf[:n], &msg); err != nil || msg.Method != method {
+				continue // stray or late packet
+			}
+			if msg.Error != nil {
+				return msg.Error
+			}
+			if result == nil || msg.Result == nil {
+				return nil
+			}
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				return fmt.Errorf("%w: invalid %s result: %v", ErrRPC, method, err)
+			}
+			return nil
+		}
+	}
+}
+
+func (c *Client) Pilot(ctx context.Context) (Pilot, error) {
+	var pilot Pilot
+	err := c.Call(ctx, "getPilot", nil, &pilot)
+	return pilot, err
+}
+
+func (c *Client) SetPilot(ctx context.Context, pilot Pilot) error {
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := c.Call(ctx, "setPilot", pilot, &result); err != nil {
+		return err
+	}
+	if !result.Success {
+		return fmt.Errorf("%w: setPilot was not successful", ErrRPC)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package wiz
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+// Bulbs don't dim below 10%
+const minDimming = 10
+
+// Bulb is a WiZ light. While the bulb accepts the hub's registration it pushes
+// syncPilot messages that keep the cached pilot current; otherwise State polls.
+type Bulb struct {
+	id      device.ID
+	address string
+	client  *Client
+	guard   *resilience.Guard
+
+	mu         sync.RWMutex
+	pilot      Pilot
+	registered time.Time // last accepted registration
+	updatedAt  time.Time
+}
+
+func newBulb(found Discovered, pilot Pilot, guard *resilience.Guard) *Bulb {
+	return &Bulb{
+		id:        bulbID(found.MAC),
+		address:   found.Address,
+		client:    NewClient(found.Address),
+		guard:     guard,
+		pilot:     pilot,
+		updatedAt: time.Now(),
+	}
+}
+
+func bulbID(mac string) device.ID {
+	return device.ID("wiz-" + strings.ToLower(mac))
+}
+
+func (b *Bulb) ID() device.ID {
+	return b.id
+}
+
+func (b *Bulb) update(pilot Pilot) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pilot.merge(pilot)
+	b.updatedAt = time.Now()
+}
+
+func (b *Bulb) setRegistered(at time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.registered = at
+}
+
+// pushing reports whether the bulb accepted a registration recently enough to still
+// be sending syncPilot messages.
+func (b *Bulb) pushing() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return time.Since(b.registered) < 2*registerInterval
+}
+
+func (b *Bulb) Execute(ctx context.Context, cmd device.Command) error {
+	on := true
+	update := Pilot{State: &on}
+
+	switch cmd.Action {
+	case "turn_on":
+	case "turn_off":
+		on = false
+
+	case "toggle":
+		b.mu.RLock()
+		on = b.pilot.State == nil || !*b.pilot.State
+		b.mu.RUnlock()
+
+	case "set_brightness":
+		v, err := device.NumberParam(cmd.Params, "value", 0, 100)
+		if err != nil {
+			return err
+		}
+		if v == 0 {
+			on = false
+			break
+		}
+		dimming := max(minDimming, int(math.Round(v)))
+		update.Dimming = &dimming
+
+	case "set_color":
+		h, err := device.NumberParam(cmd.Params, "hue", 0, 360)
+		if err != nil {
+			return err
+		}
+		s, err := device.NumberParam(cmd.Params, "saturation", 0, 100)
+		if err != nil {
+			return err
+		}
+		r, g, bl := hsToRGB(h, s)
+		update.R, update.G, update.B = &r, &g, &bl
+
+	case "set_color_temperature":
+		v, err := device.NumberParam(cmd.Params, "value", 2200, 6500)
+		if err != nil {
+			return err
+		}
+		temp := int(v)
+		update.Temp = &temp
+
+	default:
+		return fmt.Errorf("%w: %s", device.ErrUnknownCommand, cmd.Action)
+	}
+
+	err := b.guard.Do(ctx, func(ctx context.Context) error {
+		return b.client.SetPilot(ctx, update)
+	})
+	if err != nil {
+		return err
+	}
+	b.update(update)
+	return nil
+}
+
+func (b *Bulb) State(ctx context.Context) (device.State, error) {
+	var err error
+	if !b.pushing() {
+		err = b.guard.Do(ctx, func(ctx context.Context) error {
+			pilot, err := b.client.Pilot(ctx)
+			if err == nil {
+				b.update(pilot)
+			}
+			return err
+		})
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	attributes := map[string]interface{}{
+		"power": "unknown",
+	}
+	p := b.pilot
+	if p.State != nil {
+		attributes["power"] = "off"
+		if *p.State {
+			attributes["power"] = "on"
+		}
+	}
+	if p.Dimming != nil {
+		attributes["brightness"] = *p.Dimming
+	}
+	if p.Temp != nil && *p.Temp > 0 {
+		attributes["color_temperature"] = *p.Temp
+	} else if p.R != nil && p.G != nil && p.B != nil {
+		attributes["hue"], attributes["saturation"] = rgbToHS(*p.R, *p.G, *p.B)
+	}
+	if p.SceneID != nil && *p.SceneID != 0 {
+		attributes["scene_id"] = *p.SceneID
+	}
+	if p.RSSI != 0 {
+		attributes["rssi"] = p.RSSI
+	}
+
+	state := device.State{
+		DeviceType: "light",
+		UpdatedAt:  b.updatedAt,
+		Attributes: attributes,
+	}
+	if err != nil {
+		attributes["error"] = err.Error()
+		return state, resilience.Unavailable(err)
+	}
+	return state, nil
+}
+
+// hsToRGB converts a hue in degrees and saturation in percent to a fully bright
+// color.
+func hsToRGB(hue, sat float64) (int, int, int) {
+	s := sat / 100
+	c := s
+	x := c * (1 - math.Abs(math.Mod(math.Mod(hue, 360)/60, 2)-1))
+	m := 1 - c
+
+	var r, g, b float64
+	switch h := math.Mod(hue, 360); {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	scale := func(v float64) int { return int(math.Round((v + m) * 255)) }
+	return scale(r), scale(g), scale(b)
+}
+
+// rgbToHS returns the hue in degrees and saturation in percent of a color.
+func rgbToHS(r, g, b int) (int, int) {
+	max := math.Max(float64(r), math.Max(float64(g), float64(b)))
+	min := math.Min(float64(r), math.Min(float64(g), float64(b)))
+	if max == 0 {
+		return 0, 0
+	}
+	delta := max - min
+
+	var hue float64
+	switch {
+	case delta == 0:
+		hue = 0
+	case max == float64(r):
+		hue = math.Mod((float64(g)-float64(b))/delta, 6)
+	case max == float64(g):
+		hue = (float64(b)-float64(r))/delta + 2
+	default:
+		hue = (float64(r)-float64(g))/delta + 4
+	}
+	hue *= 60
+	if hue < 0 {
+		hue += 360
+	}
+	return int(math.Round(hue)) % 360, int(math.Round(delta / max * 100))
+}
@@ -0,0 +1,79 @@
+package wiz
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"time"
+)
+
+// Discovered is a bulb that answered a discovery broadcast.
+type Discovered struct {
+	// Address is where the reply came from, which is also the bulb's command address
+	Address string
+	MAC     string
+}
+
+// registration asks bulbs to push syncPilot messages to the hub when Register is
+// set; without it, it's just a way to find them.
+type registration struct {
+	PhoneMAC string `json:"phoneMac"`
+	PhoneIP  string `json:"phoneIp"`
+	Register bool   `json:"register"`
+	ID       string `json:"id"`
+}
+
+// Discover broadcasts a registration without register to target (normally
+// 255.255.255.255:38899) and collects the replies that arrive within timeout.
+func Discover(ctx context.Context, target string, timeout time.Duration) ([]Discovered, error) {
+	addr, err := net.ResolveUDPAddr("udp4", target)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	// The phone fields are placeholders, bulbs only keep them when register is set
+	req, _ := json.Marshal(request{
+		Method: "registration",
+		Params: registration{PhoneMAC: "AAAAAAAAAAAA", PhoneIP: "1.2.3.4", ID: "1"},
+	})
+	if _, err := conn.WriteToUDP(req, addr); err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	seen := make(map[string]bool)
+	var found []Discovered
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// The deadline ends discovery; whatever arrived until then is the result
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return found, ctx.Err()
+			}
+			return found, err
+		}
+
+		var msg message
+		var result struct {
+			MAC     string `json:"mac"`
+			Success bool   `json:"success"`
+		}
+		if json.Unmarshal(buf[:n], &msg) != nil || msg.Method != "registration" || json.Unmarshal(msg.Result, &result) != nil {
+			continue
+		}
+		if result.MAC == "" || seen[result.MAC] {
+			continue
+		}
+		seen[result.MAC] = true
+		found = append(found, Discovered{Address: from.String(), MAC: result.MAC})
+	}
+}
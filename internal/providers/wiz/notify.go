@@ -0,0 +1,141 @@
+package wiz
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PushPort is where bulbs send syncPilot messages, whatever port registered.
+const PushPort = 38900
+
+// registerInterval is how often registrations are renewed; bulbs forget them after
+// about a minute.
+const registerInterval = 20 * time.Second
+
+// listener receives syncPilot pushes for every bulb on one UDP socket. It also sends
+// the registrations from that socket, so replies to them arrive there too.
+type listener struct {
+	conn     *net.UDPConn
+	phoneMAC string
+
+	mu    sync.Mutex
+	bulbs map[string]*Bulb // by lower case MAC
+}
+
+func newListener(address, phoneMAC string) (*listener, error) {
+	addr, err := net.ResolveUDPAddr("udp4", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &listener{conn: conn, phoneMAC: phoneMAC, bulbs: make(map[string]*Bulb)}, nil
+}
+
+// add starts pushes from b.
+func (l *listener) add(b *Bulb, mac string) {
+	l.mu.Lock()
+	l.bulbs[strings.ToLower(mac)] = b
+	l.mu.Unlock()
+	l.register(b)
+}
+
+func (l *listener) register(b *Bulb) {
+	addr, err := net.ResolveUDPAddr("udp4", b.address)
+	if err != nil {
+		return
+	}
+	// The bulb needs the hub's address as it sees it
+	probe, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		log.Printf("wiz: %s: %v", b.address, err)
+		return
+	}
+	phoneIP := probe.LocalAddr().(*net.UDPAddr).IP.String()
+	probe.Close()
+
+	req, _ := json.Marshal(request{
+		Method: "registration",
+		Params: registration{PhoneMAC: l.phoneMAC, PhoneIP: phoneIP, Register: true, ID: "1"},
+	})
+	if _, err := l.conn.WriteToUDP(req, addr); err != nil {
+		log.Printf("wiz: %s: registration failed: %v", b.address, err)
+	}
+}
+
+// run renews registrations and handles pushes until ctx is cancelled.
+func (l *listener) run(ctx context.Context) {
+	stop := context.AfterFunc(ctx, func() { l.conn.Close() })
+	defer stop()
+
+	go func() {
+		ticker := time.NewTicker(registerInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			l.mu.Lock()
+			bulbs := make([]*Bulb, 0, len(l.bulbs))
+			for _, b := range l.bulbs {
+				bulbs = append(bulbs, b)
+			}
+			l.mu.Unlock()
+			for _, b := range bulbs {
+				l.register(b)
+			}
+		}
+	}()
+
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		l.handle(buf[:n])
+	}
+}
+
+func (l *listener) handle(b []byte) {
+	var msg message
+	if err := json.Unmarshal(b, &msg); err != nil {
+		return
+	}
+
+	switch msg.Method {
+	case "registration":
+		var result struct {
+			MAC     string `json:"mac"`
+			Success bool   `json:"success"`
+		}
+		if json.Unmarshal(msg.Result, &result) == nil && result.Success {
+			if bulb := l.bulb(result.MAC); bulb != nil {
+				bulb.setRegistered(time.Now())
+			}
+		}
+
+	case "syncPilot":
+		var pilot Pilot
+		if json.Unmarshal(msg.Params, &pilot) == nil {
+			if bulb := l.bulb(pilot.MAC); bulb != nil {
+				bulb.update(pilot)
+			}
+		}
+	}
+}
+
+func (l *listener) bulb(mac string) *Bulb {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.bulbs[strings.ToLower(mac)]
+}
@@ -0,0 +1,221 @@
+// Package wiz integrates Philips WiZ bulbs over their local UDP JSON API on port
+// 38899, with state pushed back to the hub after it registers with each bulb.
+package wiz
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+func init() {
+	provider.RegisterFactory("wiz", NewProvider)
+}
+
+type Settings struct {
+	// Hosts are added directly, for bulbs that don't answer broadcasts (other subnets)
+	Hosts []string `json:"hosts"`
+	// Discovery broadcasts on the local network, on by default
+	Discovery        *bool           `json:"discovery,omitempty"`
+	BroadcastAddress string          `json:"broadcast_address"`
+	DiscoveryTimeout config.Duration `json:"discovery_timeout"`
+	// ListenAddress receives pushed state; bulbs always send to port 38900
+	ListenAddress string              `json:"listen_address"`
+	Resilience    resilience.Settings `json:"resilience"`
+}
+
+type Provider struct {
+	name     string
+	settings Settings
+	guards   *resilience.Registry
+
+	mu       sync.Mutex
+	registry provider.Registry
+	bulbs    map[device.ID]*Bulb
+	listener *listener // nil when the push port couldn't be opened
+	pushErr  error
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func NewProvider(name string, raw json.RawMessage, env provider.Env) (provider.Provider, error) {
+	var settings Settings
+	if err := provider.DecodeSettings(raw, &settings); err != nil {
+		return nil, err
+	}
+	if settings.BroadcastAddress == "" {
+		settings.BroadcastAddress = fmt.Sprintf("255.255.255.255:%d", DefaultPort)
+	}
+	if settings.DiscoveryTimeout <= 0 {
+		settings.DiscoveryTimeout = config.Duration(2 * time.Second)
+	}
+	if settings.ListenAddress == "" {
+		settings.ListenAddress = fmt.Sprintf(":%d", PushPort)
+	}
+
+	guards := env.Guards
+	if guards == nil {
+		guards = resilience.NewRegistry()
+	}
+	guards.Configure(name, settings.Resilience.Apply(resilience.DefaultConfig()), resilience.ClassifyNetwork)
+
+	return &Provider{
+		name:     name,
+		settings: settings,
+		guards:   guards,
+		bulbs:    make(map[device.ID]*Bulb),
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+func (p *Provider) discoveryEnabled() bool {
+	return p.settings.Discovery == nil || *p.settings.Discovery
+}
+
+// Start opens the push listener. Without it bulbs still work, their state is polled.
+func (p *Provider) Start(ctx context.Context, registry provider.Registry) error {
+	if len(p.settings.Hosts) == 0 && !p.discoveryEnabled() {
+		return fmt.Errorf("%w: no hosts and discovery is off", provider.ErrNotConfigured)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.registry = registry
+
+	mac := make([]byte, 6)
+	rand.Read(mac)
+	l, err := newListener(p.settings.ListenAddress, hex.EncodeToString(mac))
+	if err != nil {
+		log.Printf("wiz: push listener on %s failed, polling instead: %v", p.settings.ListenAddress, err)
+		p.pushErr = err
+		return nil
+	}
+	p.listener, p.pushErr = l, nil
+
+	var listenCtx context.Context
+	listenCtx, p.cancel = context.WithCancel(context.Background())
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		l.run(listenCtx)
+	}()
+	return nil
+}
+
+// Discover queries the configured hosts and broadcasts for the rest, registering
+// bulbs that aren't registered yet and asking them to push their state.
+func (p *Provider) Discover(ctx context.Context) error {
+	var (
+		found []Discovered
+		errs  []error
+	)
+
+	targets := make([]string, 0, len(p.settings.Hosts)+1)
+	for _, host := range p.settings.Hosts {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, fmt.Sprint(DefaultPort))
+		}
+		targets = append(targets, host)
+	}
+	if p.discoveryEnabled() {
+		targets = append(targets, p.settings.BroadcastAddress)
+	}
+
+	for _, target := range targets {
+		replies, err := Discover(ctx, target, p.settings.DiscoveryTimeout.Duration())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", target, err))
+		}
+		found = append(found, replies...)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, f := range found {
+		id := bulbID(f.MAC)
+		if _, ok := p.bulbs[id]; ok {
+			continue
+		}
+
+		guard := p.guards.Guard(p.name, f.Address)
+		client := NewClient(f.Address)
+		var pilot Pilot
+		err := guard.Do(ctx, func(ctx context.Context) error {
+			var err error
+			pilot, err = client.Pilot(ctx)
+			return err
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.Address, err))
+			continue
+		}
+
+		b := newBulb(f, pilot, guard)
+		if err := p.registry.Register(b); err != nil {
+			if !errors.Is(err, device.ErrDeviceAlreadyRegistered) {
+				log.Printf("wiz: failed to register %s: %v", id, err)
+			}
+			continue
+		}
+		p.bulbs[id] = b
+		if p.listener != nil {
+			p.listener.add(b, f.MAC)
+		}
+		log.Printf("wiz: registered bulb at %s as %s", f.Address, id)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *Provider) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	cancel := p.cancel
+	p.cancel = nil
+	p.listener = nil
+	for id := range p.bulbs {
+		p.registry.Unregister(id)
+	}
+	p.bulbs = make(map[device.ID]*Bulb)
+	p.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		p.wg.Wait()
+	}
+	return nil
+}
+
+// Health reports the breaker state of each bulb, and degraded while state is
+// polled because the push listener couldn't be opened.
+func (p *Provider) Health(ctx context.Context) provider.Health {
+	p.mu.Lock()
+	pushErr := p.pushErr
+	p.mu.Unlock()
+
+	health := provider.Health{Status: provider.HealthOK}
+	if guards, ok := p.guards.ProviderHealth(p.name); ok {
+		health.Status = guards.Status
+		health.Details = guards
+	}
+	if health.Status == provider.HealthOK && pushErr != nil {
+		health.Status = provider.HealthDegraded
+		health.Message = fmt.Sprintf("no push listener: %v", pushErr)
+	}
+	return health
+}
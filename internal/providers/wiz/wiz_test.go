@@ -0,0 +1,176 @@
+package wiz
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/provider/providertest"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/wiz/wiztest"
+)
+
+func startProvider(t *testing.T, settings Settings) (*device.Registry, *provider.Manager) {
+	t.Helper()
+
+	settings.DiscoveryTimeout = config.Duration(100 * time.Millisecond)
+	if settings.ListenAddress == "" {
+		settings.ListenAddress = "127.0.0.1:0"
+	}
+	return providertest.Start(t, NewProvider, "wiz", settings)
+}
+
+func hosts(fakes ...*wiztest.Bulb) Settings {
+	off := false
+	return Settings{Discovery: &off, Hosts: providertest.Addresses(fakes, (*wiztest.Bulb).Address)}
+}
+
+func TestColorConversion(t *testing.T) {
+	for _, c := range []struct{ hue, sat, r, g, b int }{
+		{0, 100, 255, 0, 0},
+		{120, 100, 0, 255, 0},
+		{240, 50, 128, 128, 255},
+		{0, 0, 255, 255, 255},
+	} {
+		r, g, b := hsToRGB(float64(c.hue), float64(c.sat))
+		if r != c.r || g != c.g || b != c.b {
+			t.Errorf("hsToRGB(%d, %d) = %d,%d,%d, expected %d,%d,%d", c.hue, c.sat, r, g, b, c.r, c.g, c.b)
+		}
+		if hue, sat := rgbToHS(r, g, b); hue != c.hue || sat != c.sat {
+			t.Errorf("rgbToHS(%d,%d,%d) = %d, %d, expected %d, %d", r, g, b, hue, sat, c.hue, c.sat)
+		}
+	}
+}
+
+func TestWiz_Commands(t *testing.T) {
+	ctx := context.Background()
+	bulb := wiztest.NewBulb(t, "A8BB50010203")
+	registry, _ := startProvider(t, hosts(bulb))
+
+	dev, err := registry.Get("wiz-a8bb50010203")
+	if err != nil {
+		t.Fatalf("Expected bulb to be registered: %v", err)
+	}
+
+	state, _ := dev.State(ctx)
+	if state.DeviceType != "light" || state.Attributes["power"] != "off" || state.Attributes["color_temperature"] != 2700 {
+		t.Errorf("Unexpected state: %+v", state)
+	}
+
+	cmd := device.Command{DeviceID: dev.ID(), Action: "set_color", Params: map[string]any{"hue": float64(240), "saturation": 50}}
+	if err := dev.Execute(ctx, cmd); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if p := bulb.Pilot(); p["state"] != true || p["b"] != 255 || p["r"] != 128 || p["temp"] != nil {
+		t.Errorf("Expected bulb on in colour mode, got %v", p)
+	}
+
+	// Below the bulb's minimum
+	cmd = device.Command{DeviceID: dev.ID(), Action: "set_brightness", Params: map[string]any{"value": 5}}
+	if err := dev.Execute(ctx, cmd); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if p := bulb.Pilot(); p["dimming"] != minDimming {
+		t.Errorf("Expected dimming %d, got %v", minDimming, p["dimming"])
+	}
+
+	state, _ = dev.State(ctx)
+	if state.Attributes["hue"] != 240 || state.Attributes["saturation"] != 50 || state.Attributes["brightness"] != minDimming {
+		t.Errorf("Unexpected colour state: %v", state.Attributes)
+	}
+	if _, ok := state.Attributes["color_temperature"]; ok {
+		t.Error("Expected no color_temperature in colour mode")
+	}
+
+	cmd = device.Command{DeviceID: dev.ID(), Action: "set_color_temperature", Params: map[string]any{"value": 1000}}
+	if err := dev.Execute(ctx, cmd); !errors.Is(err, device.ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter, got %v", err)
+	}
+	if err := dev.Execute(ctx, device.Command{DeviceID: dev.ID(), Action: "toggle"}); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if bulb.Pilot()["state"] != false {
+		t.Error("Expected toggle to turn the bulb off")
+	}
+}
+
+func TestWiz_PushedState(t *testing.T) {
+	ctx := context.Background()
+	bulb := wiztest.NewBulb(t, "a8bb50aabbcc")
+	registry, _ := startProvider(t, hosts(bulb))
+	dev, _ := registry.Get("wiz-a8bb50aabbcc")
+	b := dev.(*Bulb)
+
+	providertest.WaitFor(t, time.Second, "the registration", func() bool { return bulb.Registered() && b.pushing() })
+
+	// Changed in the app: the push updates the cache, State doesn't ask the bulb
+	bulb.Set(map[string]any{"state": true, "dimming": 35, "sceneId": 12})
+	providertest.WaitFor(t, time.Second, "the push", func() bool {
+		state, _ := dev.State(ctx)
+		return state.Attributes["power"] == "on" && state.Attributes["brightness"] == 35 && state.Attributes["scene_id"] == 12
+	})
+
+	// Nothing answers at this address, so polling would fail
+	b.client = NewClient("127.0.0.1:1")
+	if _, err := dev.State(ctx); err != nil {
+		t.Errorf("Expected the pushed state without polling, got %v", err)
+	}
+}
+
+func TestWiz_PollsWithoutPushListener(t *testing.T) {
+	ctx := context.Background()
+
+	// Take the listen address so the provider can't open it
+	taken, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer taken.Close()
+
+	bulb := wiztest.NewBulb(t, "a8bb50000001")
+	settings := hosts(bulb)
+	settings.ListenAddress = taken.LocalAddr().String()
+	registry, m := startProvider(t, settings)
+
+	if health, _ := m.Health(ctx, "wiz"); health.Status != provider.HealthDegraded {
+		t.Errorf("Expected degraded health without a push listener, got %+v", health)
+	}
+
+	dev, err := registry.Get("wiz-a8bb50000001")
+	if err != nil {
+		t.Fatalf("Expected bulb to be registered: %v", err)
+	}
+	bulb.Set(map[string]any{"state": true})
+	state, err := dev.State(ctx)
+	if err != nil || state.Attributes["power"] != "on" {
+		t.Errorf("Expected polled state on, got %v (%v)", state.Attributes, err)
+	}
+}
+
+func TestWiz_BroadcastDiscovery(t *testing.T) {
+	bulb := wiztest.NewBulb(t, "a8bb50090807")
+	registry, _ := startProvider(t, Settings{BroadcastAddress: bulb.Address()})
+
+	dev, err := registry.Get("wiz-a8bb50090807")
+	if err != nil {
+		t.Fatalf("Expected discovered bulb to be registered: %v", err)
+	}
+	if err := dev.Execute(context.Background(), device.Command{DeviceID: dev.ID(), Action: "turn_on"}); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if bulb.Pilot()["state"] != true {
+		t.Error("Expected bulb to be on")
+	}
+}
+
+func TestWiz_Conformance(t *testing.T) {
+	providertest.LightConformance(t, "wiz-a8bb50010203", func(t *testing.T) *device.Registry {
+		bulb := wiztest.NewBulb(t, "A8BB50010203")
+		registry, _ := startProvider(t, hosts(bulb))
+		return registry
+	})
+}
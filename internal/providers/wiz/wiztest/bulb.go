@@ -0,0 +1,172 @@
+// Package wiztest runs fake WiZ bulbs for tests. Each fake answers the UDP JSON API
+// on its own port on 127.0.0.1 and pushes syncPilot messages once registered.
+package wiztest
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type Bulb struct {
+	conn *net.UDPConn
+	mac  string
+
+	mu         sync.Mutex
+	pilot      map[string]any
+	subscriber *net.UDPAddr
+}
+
+// NewBulb starts a fake bulb that is off, at full brightness and 2700K. It is closed
+// when the test ends.
+func NewBulb(t testing.TB, mac string) *Bulb {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	b := &Bulb{
+		conn: conn,
+		mac:  strings.ToLower(mac),
+		pilot: map[string]any{
+			"state": false, "sceneId": 0, "dimming": 100, "temp": 2700, "rssi": -55,
+		},
+	}
+	go b.serve()
+	t.Cleanup(func() { conn.Close() })
+	return b
+}
+
+// Address is the bulb's UDP address, for the provider's hosts and broadcast
+// settings.
+func (b *Bulb) Address() string {
+	return b.conn.LocalAddr().String()
+}
+
+// Pilot returns a copy of the bulb's state.
+func (b *Bulb) Pilot() map[string]any {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := make(map[string]any, len(b.pilot))
+	for k, v := range b.pilot {
+		c[k] = v
+	}
+	return c
+}
+
+// Registered reports whether a client registered for pushes.
+func (b *Bulb) Registered() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.subscriber != nil
+}
+
+// Set changes the state as the app or a wall switch would, pushing it to the
+// registered client.
+func (b *Bulb) Set(pilot map[string]any) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.apply(pilot)
+}
+
+func (b *Bulb) serve() {
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := b.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		var req struct {
+			ID     int            `json:"id"`
+			Method string         `json:"method"`
+			Params map[string]any `json:"params"`
+		}
+		if err := json.Unmarshal(buf[:n], &req); err != nil {
+			continue
+		}
+
+		b.mu.Lock()
+		reply := map[string]any{"method": req.Method, "env": "pro", "id": req.ID}
+		if result, errMsg := b.call(req.Method, req.Params, from); errMsg != "" {
+			reply["error"] = map[string]any{"code": -32602, "message": errMsg}
+		} else {
+			reply["result"] = result
+		}
+		data, _ := json.Marshal(reply)
+		b.conn.WriteToUDP(data, from)
+		b.mu.Unlock()
+	}
+}
+
+// call runs one method, returning its result or an error message. Must be called
+// with mu held.
+func (b *Bulb) call(method string, params map[string]any, from *net.UDPAddr) (any, string) {
+	switch method {
+	case "getPilot":
+		result := map[string]any{"mac": b.mac}
+		for k, v := range b.pilot {
+			result[k] = v
+		}
+		return result, ""
+
+	case "registration":
+		if params["register"] == true {
+			// Real bulbs send to phoneIp:38900; the hub registers from that port
+			b.subscriber = from
+		}
+		return map[string]any{"mac": b.mac, "success": true}, ""
+
+	case "setPilot":
+		ranges := map[string][2]float64{"dimming": {10, 100}, "temp": {2200, 6500}, "r": {0, 255}, "g": {0, 255}, "b": {0, 255}}
+		for key, r := range ranges {
+			if v, ok := params[key]; ok {
+				n, isNumber := v.(float64)
+				if !isNumber || n < r[0] || n > r[1] {
+					return nil, "Invalid params"
+				}
+			}
+		}
+		update := make(map[string]any)
+		for key, v := range params {
+			if f, ok := v.(float64); ok {
+				update[key] = int(f)
+			} else {
+				update[key] = v
+			}
+		}
+		b.apply(update)
+		return map[string]any{"success": true}, ""
+	}
+	return nil, "Method not found"
+}
+
+// apply changes the state, switching between colour and white mode as real bulbs
+// do, and pushes it. Must be called with mu held.
+func (b *Bulb) apply(update map[string]any) {
+	if _, ok := update["temp"]; ok {
+		delete(b.pilot, "r")
+		delete(b.pilot, "g")
+		delete(b.pilot, "b")
+		b.pilot["sceneId"] = 0
+	}
+	if _, ok := update["r"]; ok {
+		delete(b.pilot, "temp")
+		b.pilot["sceneId"] = 0
+	}
+	for k, v := range update {
+		b.pilot[k] = v
+	}
+
+	if b.subscriber == nil {
+		return
+	}
+	params := map[string]any{"mac": b.mac, "src": "udp"}
+	for k, v := range b.pilot {
+		params[k] = v
+	}
+	data, _ := json.Marshal(map[string]any{"method": "syncPilot", "env": "pro", "params": params})
+	b.conn.WriteToUDP(data, b.subscriber)
+}
@@ -0,0 +1,142 @@
+package wled
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var ErrRequestFailed = errors.New("wled request failed")
+
+// State is WLED's /json/state. Fields are pointers so the same type can be posted as
+// a partial update.
+type State struct {
+	On         *bool     `json:"on,omitempty"`
+	Bri        *int      `json:"bri,omitempty"` // 1-255
+	Transition *int      `json:"transition,omitempty"`
+	Seg        []Segment `json:"seg,omitempty"`
+}
+
+type Segment struct {
+	ID    *int    `json:"id,omitempty"`
+	Start *int    `json:"start,omitempty"`
+	Stop  *int    `json:"stop,omitempty"`
+	On    *bool   `json:"on,omitempty"`
+	Bri   *int    `json:"bri,omitempty"`
+	Col   [][]int `json:"col,omitempty"` // primary, secondary and tertiary color
+	FX    *int    `json:"fx,omitempty"`
+	SX    *int    `json:"sx,omitempty"` // effect speed
+	IX    *int    `json:"ix,omitempty"` // effect intensity
+	Pal   *int    `json:"pal,omitempty"`
+}
+
+// Info is the part of /json/info the provider uses.
+type Info struct {
+	Ver  string `json:"ver"`
+	Name string `json:"name"`
+	MAC  string `json:"mac"`
+	Arch string `json:"arch"`
+	LEDs struct {
+		Count int  `json:"count"`
+		RGBW  bool `json:"rgbw"`
+	} `json:"leds"`
+}
+
+// Client talks to the JSON API of one WLED controller.
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// NewClient creates a client for address, which is a host[:port] or an http URL.
+func NewClient(address string) *Client {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return &Client{
+		baseURL: strings.TrimSuffix(address, "/"),
+		http:    &http.Client{},
+	}
+}
+
+// websocketURL is where WLED pushes state changes.
+func (c *Client) websocketURL() string {
+	return "ws" + strings.TrimPrefix(c.baseURL, "http") + "/ws"
+}
+
+func (c *Client) Info(ctx context.Context) (Info, error) {
+	var info Info
+	err := c.do(ctx, http.MethodGet, "/json/info", nil, &info)
+	return info, err
+}
+
+func (c *Client) State(ctx context.Context) (State, error) {
+	var state State
+	err := c.do(ctx, http.MethodGet, "/json/state", nil, &state)
+	return state, err
+}
+
+// SetState applies a partial state and returns the full state after the change.
+func (c *Client) SetState(ctx context.Context, update State) (State, error) {
+	body := struct {
+		State
+		V bool `json:"v"` // respond with the full state
+	}{update, true}
+
+	var state State
+	err := c.do(ctx, http.MethodPost, "/json/state", body, &state)
+	return state, err
+}
+
+// Effects lists effect names, indexed by effect ID.
+func (c *Client) Effects(ctx context.Context) ([]string, error) {
+	var names []string
+	err := c.do(ctx, http.MethodGet, "/json/eff", nil, &names)
+	return names, err
+}
+
+// Palettes lists palette names, indexed by palette ID.
+func (c *Client) Palettes(ctx context.Context) ([]string, error) {
+	var names []string
+	err := c.do(ctx, http.MethodGet, "/json/pal", nil, &names)
+	return names, err
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, result any) error {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s %s returned HTTP %d", ErrRequestFailed, method, path, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("%w: %s %s: %v", ErrRequestFailed, method, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,332 @@
+package wled
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+// Strip is one WLED controller, exposed as a light. Commands take an optional
+// "segment" param to control a single segment instead of the whole strip.
+type Strip struct {
+	id       device.ID
+	address  string
+	client   *Client
+	guard    *resilience.Guard
+	info     Info
+	effects  []string
+	palettes []string
+
+	mu        sync.RWMutex
+	state     State
+	connected bool // state is pushed over the websocket
+	updatedAt time.Time
+}
+
+func stripID(info Info) device.ID {
+	return device.ID("wled-" + strings.ToLower(info.MAC))
+}
+
+func (s *Strip) ID() device.ID {
+	return s.id
+}
+
+func (s *Strip) call(ctx context.Context, fn func(ctx context.Context) error) error {
+	return s.guard.Do(ctx, fn)
+}
+
+func (s *Strip) setState(state State) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state
+	s.updatedAt = time.Now()
+}
+
+func (s *Strip) setConnected(connected bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = connected
+}
+
+func (s *Strip) isConnected() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.connected
+}
+
+func (s *Strip) Execute(ctx context.Context, cmd device.Command) error {
+	update, err := s.updateFor(cmd)
+	if err != nil {
+		return err
+	}
+
+	var state State
+	err = s.call(ctx, func(ctx context.Context) error {
+		var err error
+		state, err = s.client.SetState(ctx, update)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.setState(state)
+	return nil
+}
+
+// updateFor builds the partial state for a command.
+func (s *Strip) updateFor(cmd device.Command) (State, error) {
+	segments, err := s.targetSegments(cmd.Params)
+	if err != nil {
+		return State{}, err
+	}
+	_, perSegment := cmd.Params["segment"]
+
+	// each applies a change to every targeted segment
+	each := func(apply func(seg *Segment)) State {
+		update := State{}
+		for _, id := range segments {
+			seg := Segment{ID: ptr(id)}
+			apply(&seg)
+			update.Seg = append(update.Seg, seg)
+		}
+		return update
+	}
+
+	switch cmd.Action {
+	case "turn_on", "turn_off":
+		on := cmd.Action == "turn_on"
+		if perSegment {
+			return each(func(seg *Segment) { seg.On = ptr(on) }), nil
+		}
+		return State{On: ptr(on)}, nil
+
+	case "toggle":
+		s.mu.RLock()
+		on := s.state.On != nil && *s.state.On
+		s.mu.RUnlock()
+		return State{On: ptr(!on)}, nil
+
+	case "set_brightness":
+		v, err := device.NumberParam(cmd.Params, "value", 0, 100)
+		if err != nil {
+			return State{}, err
+		}
+		bri := int(math.Round(v * 255 / 100))
+		if perSegment {
+			return each(func(seg *Segment) {
+				seg.On = ptr(bri > 0)
+				if bri > 0 {
+					seg.Bri = ptr(bri)
+				}
+			}), nil
+		}
+		if bri == 0 {
+			return State{On: ptr(false)}, nil
+		}
+		return State{On: ptr(true), Bri: ptr(bri)}, nil
+
+	case "set_color":
+		var rgb []int
+		for _, key := range []string{"r", "g", "b"} {
+			v, err := device.NumberParam(cmd.Params, key, 0, 255)
+			if err != nil {
+				return State{}, err
+			}
+			rgb = append(rgb, int(v))
+		}
+		return each(func(seg *Segment) { seg.Col = [][]int{rgb} }), nil
+
+	case "set_effect":
+		fx, err := lookup(cmd.Params, "effect", s.effects)
+		if err != nil {
+			return State{}, err
+		}
+		var speed, intensity *int
+		if _, ok := cmd.Params["speed"]; ok {
+			v, err := device.NumberParam(cmd.Params, "speed", 0, 255)
+			if err != nil {
+				return State{}, err
+			}
+			speed = ptr(int(v))
+		}
+		if _, ok := cmd.Params["intensity"]; ok {
+			v, err := device.NumberParam(cmd.Params, "intensity", 0, 255)
+			if err != nil {
+				return State{}, err
+			}
+			intensity = ptr(int(v))
+		}
+		return each(func(seg *Segment) {
+			seg.FX = ptr(fx)
+			seg.SX = speed
+			seg.IX = intensity
+		}), nil
+
+	case "set_palette":
+		pal, err := lookup(cmd.Params, "palette", s.palettes)
+		if err != nil {
+			return State{}, err
+		}
+		return each(func(seg *Segment) { seg.Pal = ptr(pal) }), nil
+	}
+
+	return State{}, fmt.Errorf("%w: %s", device.ErrUnknownCommand, cmd.Action)
+}
+
+// targetSegments returns the segment named by the "segment" param, or every segment.
+func (s *Strip) targetSegments(params map[string]any) ([]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ids []int
+	for _, seg := range s.state.Seg {
+		if seg.ID != nil {
+			ids = append(ids, *seg.ID)
+		}
+	}
+	if len(ids) == 0 {
+		ids = []int{0}
+	}
+
+	if _, ok := params["segment"]; !ok {
+		return ids, nil
+	}
+	v, err := device.NumberParam(params, "segment", 0, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if id == int(v) {
+			return []int{id}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no segment %d", device.ErrInvalidParameter, int(v))
+}
+
+func (s *Strip) State(ctx context.Context) (device.State, error) {
+	var err error
+	if !s.isConnected() {
+		err = s.call(ctx, func(ctx context.Context) error {
+			state, err := s.client.State(ctx)
+			if err == nil {
+				s.setState(state)
+			}
+			return err
+		})
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	attributes := map[string]interface{}{
+		"power":     "unknown",
+		"name":      s.info.Name,
+		"version":   s.info.Ver,
+		"led_count": s.info.LEDs.Count,
+	}
+	if s.state.On != nil {
+		attributes["power"] = device.OnOff(*s.state.On)
+	}
+	if s.state.Bri != nil {
+		attributes["brightness"] = toPercent(*s.state.Bri)
+	}
+
+	var segments []map[string]any
+	for i, seg := range s.state.Seg {
+		m := s.segmentAttributes(seg)
+		if i == 0 {
+			// The first segment stands for the whole strip in the top-level attributes
+			for _, key := range []string{"color", "effect", "palette"} {
+				if v, ok := m[key]; ok {
+					attributes[key] = v
+				}
+			}
+		}
+		segments = append(segments, m)
+	}
+	if segments != nil {
+		attributes["segments"] = segments
+	}
+
+	state := device.State{
+		DeviceType: "light",
+		UpdatedAt:  s.updatedAt,
+		Attributes: attributes,
+	}
+	if err != nil {
+		attributes["error"] = err.Error()
+		return state, resilience.Unavailable(err)
+	}
+	return state, nil
+}
+
+func (s *Strip) segmentAttributes(seg Segment) map[string]any {
+	m := map[string]any{}
+	if seg.ID != nil {
+		m["id"] = *seg.ID
+	}
+	if seg.Start != nil && seg.Stop != nil {
+		m["start"] = *seg.Start
+		m["stop"] = *seg.Stop
+	}
+	if seg.On != nil {
+		m["power"] = device.OnOff(*seg.On)
+	}
+	if seg.Bri != nil {
+		m["brightness"] = toPercent(*seg.Bri)
+	}
+	if len(seg.Col) > 0 && len(seg.Col[0]) >= 3 {
+		m["color"] = map[string]any{"r": seg.Col[0][0], "g": seg.Col[0][1], "b": seg.Col[0][2]}
+	}
+	if seg.FX != nil {
+		m["effect"] = name(s.effects, *seg.FX)
+	}
+	if seg.Pal != nil {
+		m["palette"] = name(s.palettes, *seg.Pal)
+	}
+	return m
+}
+
+func toPercent(bri int) int {
+	return int(math.Round(float64(bri) * 100 / 255))
+}
+
+// name returns the name for an effect or palette ID, or the ID if it isn't known.
+func name(names []string, id int) string {
+	if id >= 0 && id < len(names) {
+		return names[id]
+	}
+	return strconv.Itoa(id)
+}
+
+// lookup resolves an effect or palette param given by name or ID.
+func lookup(params map[string]any, key string, names []string) (int, error) {
+	switch v := params[key].(type) {
+	case string:
+		for i, n := range names {
+			if strings.EqualFold(n, v) {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("%w: unknown %s %q", device.ErrInvalidParameter, key, v)
+	case float64, int:
+		max := float64(len(names) - 1)
+		if len(names) == 0 {
+			max = 255
+		}
+		n, err := device.NumberParam(params, key, 0, max)
+		return int(n), err
+	}
+	return 0, fmt.Errorf("%w: %s must be a name or number", device.ErrInvalidParameter, key)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
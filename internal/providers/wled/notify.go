@@ -0,0 +1,44 @@
+package wled
+
+import (
+	"context"
+
+	"github.com/gorilla/websocket"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+// listen keeps the controller's websocket open until ctx is cancelled. WLED sends
+// the full state on connect and after every change. Older builds without websocket
+// support are polled instead.
+func (s *Strip) listen(ctx context.Context) {
+	resilience.Reconnect(ctx, "wled: "+s.address+": websocket closed", func(ctx context.Context) (bool, error) {
+		err := s.listenOnce(ctx)
+		connected := s.isConnected()
+		s.setConnected(false)
+		return connected, err
+	})
+}
+
+func (s *Strip) listenOnce(ctx context.Context) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, s.client.websocketURL(), nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	for {
+		var msg struct {
+			State *State `json:"state"`
+		}
+		if err := conn.ReadJSON(&msg); err != nil {
+			return err
+		}
+		if msg.State != nil {
+			s.setState(*msg.State)
+			s.setConnected(true)
+		}
+	}
+}
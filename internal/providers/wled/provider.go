@@ -0,0 +1,192 @@
+// Package wled integrates LED controllers running WLED through its JSON API, with
+// state pushed over WLED's websocket.
+package wled
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+func init() {
+	provider.RegisterFactory("wled", NewProvider)
+}
+
+type Settings struct {
+	// Devices are the addresses (host[:port] or http URL) of the controllers to add
+	Devices    []string            `json:"devices"`
+	Resilience resilience.Settings `json:"resilience"`
+}
+
+type Provider struct {
+	name     string
+	settings Settings
+	guards   *resilience.Registry
+
+	mu        sync.Mutex
+	registry  provider.Registry
+	strips    map[string]*Strip // by address, once discovered
+	cancel    context.CancelFunc
+	listenCtx context.Context
+	wg        sync.WaitGroup
+}
+
+func NewProvider(name string, raw json.RawMessage, env provider.Env) (provider.Provider, error) {
+	var settings Settings
+	if err := provider.DecodeSettings(raw, &settings); err != nil {
+		return nil, err
+	}
+
+	guards := env.Guards
+	if guards == nil {
+		guards = resilience.NewRegistry()
+	}
+	guards.Configure(name, settings.Resilience.Apply(resilience.DefaultConfig()), resilience.ClassifyNetwork)
+
+	return &Provider{
+		name:     name,
+		settings: settings,
+		guards:   guards,
+		strips:   make(map[string]*Strip),
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+func (p *Provider) Start(ctx context.Context, registry provider.Registry) error {
+	if len(p.settings.Devices) == 0 {
+		return fmt.Errorf("%w: no devices", provider.ErrNotConfigured)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.registry = registry
+	p.listenCtx, p.cancel = context.WithCancel(context.Background())
+	return nil
+}
+
+// Discover registers each controller that hasn't been reached yet. Its websocket is
+// opened once it is registered.
+func (p *Provider) Discover(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var errs []error
+	for _, address := range p.settings.Devices {
+		if _, ok := p.strips[address]; ok {
+			continue
+		}
+		if err := p.discoverStrip(ctx, address); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", address, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Provider) discoverStrip(ctx context.Context, address string) error {
+	s := &Strip{
+		address: address,
+		client:  NewClient(address),
+		guard:   p.guards.Guard(p.name, address),
+	}
+
+	err := s.call(ctx, func(ctx context.Context) error {
+		var err error
+		if s.info, err = s.client.Info(ctx); err != nil {
+			return err
+		}
+		if s.effects, err = s.client.Effects(ctx); err != nil {
+			return err
+		}
+		if s.palettes, err = s.client.Palettes(ctx); err != nil {
+			return err
+		}
+		state, err := s.client.State(ctx)
+		if err != nil {
+			return err
+		}
+		s.setState(state)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.id = stripID(s.info)
+	if err := p.registry.Register(s); err != nil {
+		if errors.Is(err, device.ErrDeviceAlreadyRegistered) {
+			return nil
+		}
+		return err
+	}
+	p.strips[address] = s
+	log.Printf("wled: registered %s (%d LEDs, %d segments) as %s", s.info.Name, s.info.LEDs.Count, len(s.state.Seg), s.id)
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		s.listen(p.listenCtx)
+	}()
+	return nil
+}
+
+func (p *Provider) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	cancel := p.cancel
+	p.cancel = nil
+	for _, s := range p.strips {
+		p.registry.Unregister(s.id)
+	}
+	p.strips = make(map[string]*Strip)
+	p.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		p.wg.Wait()
+	}
+	return nil
+}
+
+// Health reports the breaker state of each controller, and degraded while a
+// configured controller hasn't been found or has no websocket.
+func (p *Provider) Health(ctx context.Context) provider.Health {
+	p.mu.Lock()
+	var missing, polled []string
+	for _, address := range p.settings.Devices {
+		s, ok := p.strips[address]
+		switch {
+		case !ok:
+			missing = append(missing, address)
+		case !s.isConnected():
+			polled = append(polled, address)
+		}
+	}
+	p.mu.Unlock()
+
+	health := provider.Health{Status: provider.HealthOK}
+	if guards, ok := p.guards.ProviderHealth(p.name); ok {
+		health.Status = guards.Status
+		health.Details = guards
+	}
+	if health.Status == provider.HealthOK {
+		switch {
+		case len(missing) > 0:
+			health.Status = provider.HealthDegraded
+			health.Message = fmt.Sprintf("not found: %v", missing)
+		case len(polled) > 0:
+			health.Status = provider.HealthDegraded
+			health.Message = fmt.Sprintf("no websocket for %v", polled)
+		}
+	}
+	return health
+}
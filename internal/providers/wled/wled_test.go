@@ -0,0 +1,159 @@
+package wled
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/provider/providertest"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/wled/wledtest"
+)
+
+func startProvider(t *testing.T, fakes ...*wledtest.Device) (*device.Registry, *Provider) {
+	t.Helper()
+	registry, m := providertest.Start(t, NewProvider, "wled", Settings{Devices: providertest.Addresses(fakes, (*wledtest.Device).Address)})
+	p, _ := m.Provider("wled")
+	return registry, p.(*Provider)
+}
+
+func execute(t *testing.T, dev device.Device, action string, params map[string]any) {
+	t.Helper()
+	if err := dev.Execute(context.Background(), device.Command{DeviceID: dev.ID(), Action: action, Params: params}); err != nil {
+		t.Fatalf("%s failed: %v", action, err)
+	}
+}
+
+func TestWLED_DiscoverAndState(t *testing.T) {
+	fake := wledtest.NewDevice(t, "Desk Strip", "A8032AB1C2D3", 60, [2]int{0, 30}, [2]int{30, 60})
+	registry, _ := startProvider(t, fake)
+
+	dev, err := registry.Get("wled-a8032ab1c2d3")
+	if err != nil {
+		t.Fatalf("Expected strip to be registered: %v", err)
+	}
+	state, err := dev.State(context.Background())
+	if err != nil {
+		t.Fatalf("State failed: %v", err)
+	}
+
+	if state.DeviceType != "light" || state.Attributes["power"] != "on" || state.Attributes["brightness"] != 50 {
+		t.Errorf("Unexpected state: %v", state.Attributes)
+	}
+	if state.Attributes["effect"] != "Solid" || state.Attributes["palette"] != "Default" {
+		t.Errorf("Expected effect Solid and palette Default, got %v and %v", state.Attributes["effect"], state.Attributes["palette"])
+	}
+	segments, _ := state.Attributes["segments"].([]map[string]any)
+	if len(segments) != 2 || segments[1]["start"] != 30 {
+		t.Errorf("Expected two segments, got %v", state.Attributes["segments"])
+	}
+}
+
+func TestWLED_Commands(t *testing.T) {
+	fake := wledtest.NewDevice(t, "Desk Strip", "A8032AB1C2D3", 60, [2]int{0, 30}, [2]int{30, 60})
+	registry, _ := startProvider(t, fake)
+	dev, _ := registry.Get("wled-a8032ab1c2d3")
+
+	execute(t, dev, "turn_off", nil)
+	if fake.On() {
+		t.Error("Expected strip to be off")
+	}
+
+	execute(t, dev, "set_brightness", map[string]any{"value": 100})
+	if !fake.On() || fake.Bri() != 255 {
+		t.Errorf("Expected on at bri 255, got %v %d", fake.On(), fake.Bri())
+	}
+
+	execute(t, dev, "set_effect", map[string]any{"effect": "rainbow", "speed": 200})
+	for _, id := range []int{0, 1} {
+		if seg := fake.Segment(id); seg["fx"] != 4 || seg["sx"] != 200 {
+			t.Errorf("Expected segment %d to run Rainbow at speed 200, got %v", id, seg)
+		}
+	}
+
+	execute(t, dev, "set_palette", map[string]any{"palette": 3, "segment": 1})
+	if fake.Segment(0)["pal"] != 0 || fake.Segment(1)["pal"] != 3 {
+		t.Errorf("Expected only segment 1 to use palette 3, got %v and %v", fake.Segment(0)["pal"], fake.Segment(1)["pal"])
+	}
+
+	execute(t, dev, "set_color", map[string]any{"r": 0, "g": 0, "b": 255, "segment": 0})
+	state, _ := dev.State(context.Background())
+	color, _ := state.Attributes["color"].(map[string]any)
+	if color["b"] != 255 || color["r"] != 0 {
+		t.Errorf("Expected blue, got %v", state.Attributes["color"])
+	}
+
+	execute(t, dev, "turn_off", map[string]any{"segment": 1})
+	if fake.Segment(1)["on"] != false || !fake.On() {
+		t.Errorf("Expected only segment 1 off")
+	}
+}
+
+func TestWLED_InvalidCommands(t *testing.T) {
+	fake := wledtest.NewDevice(t, "Desk Strip", "A8032AB1C2D3", 60)
+	registry, _ := startProvider(t, fake)
+	dev, _ := registry.Get("wled-a8032ab1c2d3")
+	ctx := context.Background()
+
+	for _, cmd := range []device.Command{
+		{Action: "set_effect", Params: map[string]any{"effect": "Disco Inferno"}},
+		{Action: "set_palette", Params: map[string]any{"palette": 99}},
+		{Action: "turn_on", Params: map[string]any{"segment": 5}},
+		{Action: "set_color", Params: map[string]any{"r": 300, "g": 0, "b": 0}},
+	} {
+		if err := dev.Execute(ctx, cmd); !errors.Is(err, device.ErrInvalidParameter) {
+			t.Errorf("%s %v: expected device.ErrInvalidParameter, got %v", cmd.Action, cmd.Params, err)
+		}
+	}
+	if err := dev.Execute(ctx, device.Command{Action: "dance"}); !errors.Is(err, device.ErrUnknownCommand) {
+		t.Errorf("Expected ErrUnknownCommand, got %v", err)
+	}
+}
+
+func TestWLED_WebsocketPushesState(t *testing.T) {
+	fake := wledtest.NewDevice(t, "Desk Strip", "A8032AB1C2D3", 60)
+	registry, p := startProvider(t, fake)
+	ctx := context.Background()
+
+	providertest.WaitFor(t, 5*time.Second, "the provider to be healthy", func() bool { return p.Health(ctx).Status == provider.HealthOK })
+
+	// Changed from the WLED UI
+	fake.Apply(`{"on": false}`)
+
+	dev, _ := registry.Get("wled-a8032ab1c2d3")
+	providertest.WaitFor(t, 5*time.Second, "the notification", func() bool {
+		state, _ := dev.State(ctx)
+		return state.Attributes["power"] == "off"
+	})
+}
+
+func TestWLED_PollsWithoutWebsocket(t *testing.T) {
+	fake := wledtest.NewDevice(t, "Desk Strip", "A8032AB1C2D3", 60)
+	fake.DisableWebsocket()
+	registry, p := startProvider(t, fake)
+	ctx := context.Background()
+
+	if h := p.Health(ctx); h.Status != provider.HealthDegraded {
+		t.Errorf("Expected degraded without websocket, got %+v", h)
+	}
+
+	fake.Apply(`{"bri": 255}`)
+	dev, _ := registry.Get("wled-a8032ab1c2d3")
+	state, err := dev.State(ctx)
+	if err != nil {
+		t.Fatalf("State failed: %v", err)
+	}
+	if state.Attributes["brightness"] != 100 {
+		t.Errorf("Expected brightness 100 from polling, got %v", state.Attributes["brightness"])
+	}
+}
+
+func TestWLED_Conformance(t *testing.T) {
+	providertest.LightConformance(t, "wled-a8032ab1c2d3", func(t *testing.T) *device.Registry {
+		fake := wledtest.NewDevice(t, "Desk Strip", "A8032AB1C2D3", 60, [2]int{0, 60})
+		registry, _ := startProvider(t, fake)
+		return registry
+	})
+}
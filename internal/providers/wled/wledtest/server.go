@@ -0,0 +1,308 @@
+// Package wledtest runs a fake WLED controller for tests: the /json endpoints and
+// the /ws websocket that pushes the state after every change.
+package wledtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	Effects  = []string{"Solid", "Blink", "Breathe", "Wipe", "Rainbow", "Fireworks"}
+	Palettes = []string{"Default", "* Random Cycle", "Party", "Ocean", "Lava"}
+)
+
+type Device struct {
+	server *httptest.Server
+	name   string
+	mac    string
+	leds   int
+
+	mu       sync.Mutex
+	on       bool
+	bri      int
+	segments []map[string]any
+	noSocket bool
+	conns    map[*websocket.Conn]bool
+}
+
+// NewDevice starts a fake controller with one segment per [start, stop) range, or a
+// single segment over all LEDs. It is closed when the test ends.
+func NewDevice(t testing.TB, name, mac string, leds int, segments ...[2]int) *Device {
+	t.Helper()
+
+	if len(segments) == 0 {
+		segments = [][2]int{{0, leds}}
+	}
+	d := &Device{
+		name:  name,
+		mac:   mac,
+		leds:  leds,
+		on:    true,
+		bri:   128,
+		conns: make(map[*websocket.Conn]bool),
+	}
+	for i, r := range segments {
+		d.segments = append(d.segments, map[string]any{
+			"id": i, "start": r[0], "stop": r[1], "len": r[1] - r[0],
+			"on": true, "bri": 255,
+			"col": [][]int{{255, 160, 0}, {0, 0, 0}, {0, 0, 0}},
+			"fx":  0, "sx": 128, "ix": 128, "pal": 0, "sel": i == 0,
+		})
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /json/info", d.handleInfo)
+	mux.HandleFunc("GET /json/state", d.handleState)
+	mux.HandleFunc("POST /json/state", d.handleSetState)
+	mux.HandleFunc("POST /json", d.handleSetState)
+	mux.HandleFunc("GET /json/eff", func(w http.ResponseWriter, r *http.Request) { writeJSON(w, Effects) })
+	mux.HandleFunc("GET /json/pal", func(w http.ResponseWriter, r *http.Request) { writeJSON(w, Palettes) })
+	mux.HandleFunc("GET /ws", d.handleWebsocket)
+
+	d.server = httptest.NewServer(mux)
+	t.Cleanup(func() {
+		d.CloseWebsockets()
+		d.server.Close()
+	})
+	return d
+}
+
+// Address is what the provider's devices setting should contain.
+func (d *Device) Address() string {
+	return d.server.URL
+}
+
+// DisableWebsocket makes /ws unavailable, like builds without websocket support.
+func (d *Device) DisableWebsocket() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.noSocket = true
+}
+
+// CloseWebsockets drops all websocket clients.
+func (d *Device) CloseWebsockets() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for conn := range d.conns {
+		conn.Close()
+	}
+	d.conns = make(map[*websocket.Conn]bool)
+}
+
+// Websockets reports the number of connected websocket clients.
+func (d *Device) Websockets() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.conns)
+}
+
+// On reports the master power state.
+func (d *Device) On() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.on
+}
+
+// Bri reports the master brightness, 1-255.
+func (d *Device) Bri() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.bri
+}
+
+// Segment returns a copy of segment id's state.
+func (d *Device) Segment(id int) map[string]any {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	seg := make(map[string]any)
+	for k, v := range d.segments[id] {
+		seg[k] = v
+	}
+	return seg
+}
+
+// Apply changes the state as if done from the WLED UI, and pushes it to websocket
+// clients.
+func (d *Device) Apply(update string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var u map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(update), &u); err != nil {
+		panic(err)
+	}
+	d.apply(u)
+}
+
+func (d *Device) handleInfo(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	writeJSON(w, d.info())
+}
+
+func (d *Device) handleState(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	writeJSON(w, d.state())
+}
+
+func (d *Device) handleSetState(w http.ResponseWriter, r *http.Request) {
+	var u map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		http.Error(w, `{"error":9}`, http.StatusBadRequest)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.apply(u)
+
+	if v, ok := u["v"]; ok && string(v) == "true" {
+		writeJSON(w, d.state())
+		return
+	}
+	writeJSON(w, map[string]any{"success": true})
+}
+
+var upgrader = websocket.Upgrader{}
+
+func (d *Device) handleWebsocket(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	noSocket := d.noSocket
+	d.mu.Unlock()
+	if noSocket {
+		http.NotFound(w, r)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	d.mu.Lock()
+	d.conns[conn] = true
+	err = conn.WriteJSON(map[string]any{"state": d.state(), "info": d.info()})
+	d.mu.Unlock()
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	// Clients may also send state updates over the socket
+	for {
+		var u map[string]json.RawMessage
+		if err := conn.ReadJSON(&u); err != nil {
+			break
+		}
+		d.mu.Lock()
+		d.apply(u)
+		d.mu.Unlock()
+	}
+
+	d.mu.Lock()
+	delete(d.conns, conn)
+	d.mu.Unlock()
+	conn.Close()
+}
+
+// apply merges a state update and notifies websocket clients. Must be called with mu held.
+func (d *Device) apply(u map[string]json.RawMessage) {
+	if v, ok := u["on"]; ok {
+		if string(v) == `"t"` {
+			d.on = !d.on
+		} else {
+			json.Unmarshal(v, &d.on)
+		}
+	}
+	if v, ok := u["bri"]; ok {
+		json.Unmarshal(v, &d.bri)
+	}
+	if v, ok := u["seg"]; ok {
+		var segs []map[string]any
+		if err := json.Unmarshal(v, &segs); err != nil {
+			// A single object applies to every segment
+			var seg map[string]any
+			if json.Unmarshal(v, &seg) == nil {
+				for i := range d.segments {
+					segs = append(segs, withID(seg, i))
+				}
+			}
+		}
+		for i, seg := range segs {
+			id := i
+			if n, ok := seg["id"].(float64); ok {
+				id = int(n)
+			}
+			if id < 0 || id >= len(d.segments) {
+				continue
+			}
+			for k, v := range seg {
+				if k == "id" {
+					continue
+				}
+				if n, ok := v.(float64); ok && k != "col" {
+					v = int(n)
+				}
+				d.segments[id][k] = v
+			}
+		}
+	}
+
+	msg := map[string]any{"state": d.state(), "info": d.info()}
+	for conn := range d.conns {
+		conn.WriteJSON(msg)
+	}
+}
+
+func withID(seg map[string]any, id int) map[string]any {
+	c := map[string]any{"id": float64(id)}
+	for k, v := range seg {
+		c[k] = v
+	}
+	return c
+}
+
+// state is /json/state. Must be called with mu held.
+func (d *Device) state() map[string]any {
+	segs := make([]map[string]any, len(d.segments))
+	for i, seg := range d.segments {
+		segs[i] = make(map[string]any)
+		for k, v := range seg {
+			segs[i][k] = v
+		}
+	}
+	return map[string]any{
+		"on":         d.on,
+		"bri":        d.bri,
+		"transition": 7,
+		"ps":         -1,
+		"pl":         -1,
+		"mainseg":    0,
+		"seg":        segs,
+	}
+}
+
+// info is /json/info. Must be called with mu held.
+func (d *Device) info() map[string]any {
+	return map[string]any{
+		"ver":      "0.14.4",
+		"name":     d.name,
+		"mac":      d.mac,
+		"arch":     "esp32",
+		"leds":     map[string]any{"count": d.leds, "rgbw": false},
+		"fxcount":  len(Effects),
+		"palcount": len(Palettes),
+		"ws":       len(d.conns),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,219 @@
+package yeelight
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultPort is the control port in discovery replies from every current bulb.
+const DefaultPort = 55443
+
+var (
+	ErrRPC          = errors.New("yeelight error")
+	ErrDisconnected = errors.New("yeelight connection closed")
+)
+
+// RPCError is an error returned by the bulb in the "error" member of a reply.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Message)
+}
+
+func (e *RPCError) Unwrap() error {
+	return ErrRPC
+}
+
+type request struct {
+	ID     int64  `json:"id"`
+	Method string `json:"method"`
+	Params []any  `json:"params"`
+}
+
+// frame is any line from the bulb: a reply (ID set) or a props notification.
+type frame struct {
+	ID     int64             `json:"id"`
+	Method string            `json:"method"`
+	Params map[string]string `json:"params"`
+	Result []any             `json:"result"`
+	Error  *RPCError         `json:"error"`
+}
+
+// Client keeps one TCP connection to a bulb, shared by requests and property
+// notifications. Bulbs only accept a handful of connections, so it is opened once
+// and reopened by Connect after it drops.
+type Client struct {
+	address string
+	timeout time.Duration
+	onProps func(map[string]string)
+
+	mu      sync.Mutex
+	conn    net.Conn
+	closed  chan struct{}
+	nextID  int64
+	pending map[int64]chan frame
+}
+
+// NewClient creates a client for address, which is a host or host:port. onProps is
+// called from the reader with every props notification.
+func NewClient(address string, onProps func(map[string]string)) *Client {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, fmt.Sprint(DefaultPort))
+	}
+	return &Client{
+		address: address,
+		timeout: 5 * time.Second,
+		onProps: onProps,
+		pending: make(map[int64]chan frame),
+	}
+}
+
+// Connect opens the connection if it isn't open and returns a channel that is
+// closed when it drops.
+func (c *Client) Connect(ctx context.Context) (<-chan struct{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != nil {
+		return c.closed, nil
+	}
+
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.address)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	c.closed = make(chan struct{})
+	go c.read(conn, c.closed)
+	return c.closed, nil
+}
+
+// Close drops the connection; pending calls fail with ErrDisconnected.
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+func (c *Client) read(conn net.Conn, closed chan struct{}) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var f frame
+		if err := json.Unmarshal(scanner.Bytes(), &f); err != nil {
+			continue
+		}
+		if f.Method == "props" {
+			if c.onProps != nil {
+				c.onProps(f.Params)
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		ch, ok := c.pending[f.ID]
+		delete(c.pending, f.ID)
+		c.mu.Unlock()
+		if ok {
+			ch <- f
+		}
+	}
+
+	conn.Close()
+	c.mu.Lock()
+	c.conn = nil
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+	close(closed)
+}
+
+// Call invokes method and returns its result, which is ["ok"] for commands and the
+// property values for get_prop.
+func (c *Client) Call(ctx context.Context, method string, params ...any) ([]any, error) {
+	if _, err := c.Connect(ctx); err != nil {
+		return nil, err
+	}
+	if params == nil {
+		params = []any{}
+	}
+
+	c.mu.Lock()
+	conn := c.conn
+	if conn == nil {
+		c.mu.Unlock()
+		return nil, ErrDisconnected
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan frame, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	forget := func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}
+
+	line, _ := json.Marshal(request{ID: id, Method: method, Params: params})
+	conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	if _, err := conn.Write(append(line, '\r', '\n')); err != nil {
+		forget()
+		return nil, err
+	}
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	select {
+	case f, ok := <-ch:
+		if !ok {
+			return nil, ErrDisconnected
+		}
+		if f.Error != nil {
+			return nil, f.Error
+		}
+		return f.Result, nil
+	case <-timer.C:
+		forget()
+		return nil, fmt.Errorf("%w: no reply to %s", context.DeadlineExceeded, method)
+	case <-ctx.Done():
+		forget()
+		return nil, ctx.Err()
+	}
+}
+
+// props are the properties the hub reads and gets notified about.
+var props = []string{"power", "bright", "color_mode", "ct", "rgb", "hue", "sat", "name"}
+
+// Props reads the props properties.
+func (c *Client) Props(ctx context.Context) (map[string]string, error) {
+	names := make([]any, len(props))
+	for i, p := range props {
+		names[i] = p
+	}
+	result, err := c.Call(ctx, "get_prop", names...)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(props))
+	for i, v := range result {
+		if s, ok := v.(string); ok && i < len(props) {
+			values[props[i]] = s
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,253 @@
+package yeelight
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+// Transitions use the bulb's smooth effect
+const transition = 300
+
+// Color modes reported in the color_mode property
+const (
+	modeRGB = "1"
+	modeCT  = "2"
+	modeHSV = "3"
+)
+
+// Bulb is a Yeelight light. Its properties are cached from notifications on the
+// control connection, and read from the bulb while that connection is down.
+type Bulb struct {
+	id     device.ID
+	model  string
+	client *Client
+	guard  *resilience.Guard
+	// commands runs one command or property read at a time, so another command
+	// can't turn the bulb off between a command's power-on and its call, and a
+	// read's reply can't be applied over a newer notification
+	commands sync.Mutex
+
+	mu        sync.RWMutex
+	props     map[string]string
+	connected bool
+	updatedAt time.Time
+}
+
+func newBulb(d Discovered, guard *resilience.Guard) *Bulb {
+	b := &Bulb{
+		id:        bulbID(d.ID),
+		model:     d.Model,
+		guard:     guard,
+		props:     make(map[string]string),
+		updatedAt: time.Now(),
+	}
+	b.client = NewClient(d.Address, b.setProps)
+	b.setProps(d.Props)
+	return b
+}
+
+func bulbID(id string) device.ID {
+	return device.ID("yeelight-" + strings.ToLower(strings.TrimPrefix(id, "0x")))
+}
+
+func (b *Bulb) ID() device.ID {
+	return b.id
+}
+
+func (b *Bulb) setProps(props map[string]string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for k, v := range props {
+		b.props[k] = v
+	}
+	b.updatedAt = time.Now()
+}
+
+// refresh reads every property from the bulb.
+func (b *Bulb) refresh(ctx context.Context) error {
+	b.commands.Lock()
+	defer b.commands.Unlock()
+	props, err := b.client.Props(ctx)
+	if err != nil {
+		return err
+	}
+	b.setProps(props)
+	return nil
+}
+
+func (b *Bulb) prop(name string) string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.props[name]
+}
+
+func (b *Bulb) setConnected(connected bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.connected = connected
+}
+
+func (b *Bulb) isConnected() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.connected
+}
+
+func (b *Bulb) Execute(ctx context.Context, cmd device.Command) error {
+	var (
+		method string
+		params []any
+	)
+
+	switch cmd.Action {
+	case "turn_on", "turn_off":
+		method, params = "set_power", []any{strings.TrimPrefix(cmd.Action, "turn_"), "smooth", transition}
+
+	case "toggle":
+		// The bulb's own toggle would flip back on a retry, so ask for the opposite
+		// of the cached power instead
+		power := "on"
+		if b.prop("power") == "on" {
+			power = "off"
+		}
+		method, params = "set_power", []any{power, "smooth", transition}
+
+	case "set_brightness":
+		v, err := device.NumberParam(cmd.Params, "value", 0, 100)
+		if err != nil {
+			return err
+		}
+		if v == 0 {
+			method, params = "set_power", []any{"off", "smooth", transition}
+			break
+		}
+		// The bulb's range starts at 1
+		method, params = "set_bright", []any{int(math.Max(1, math.Round(v))), "smooth", transition}
+
+	case "set_color":
+		h, err := device.NumberParam(cmd.Params, "hue", 0, 360)
+		if err != nil {
+			return err
+		}
+		s, err := device.NumberParam(cmd.Params, "saturation", 0, 100)
+		if err != nil {
+			return err
+		}
+		method, params = "set_hsv", []any{int(math.Round(h)) % 360, int(math.Round(s)), "smooth", transition}
+
+	case "set_color_temperature":
+		v, err := device.NumberParam(cmd.Params, "value", 1700, 6500)
+		if err != nil {
+			return err
+		}
+		method, params = "set_ct_abx", []any{int(v), "smooth", transition}
+
+	default:
+		return fmt.Errorf("%w: %s", device.ErrUnknownCommand, cmd.Action)
+	}
+
+	return b.guard.Do(ctx, func(ctx context.Context) error {
+		b.commands.Lock()
+		defer b.commands.Unlock()
+
+		// Bulbs reject everything but set_power and toggle while off
+		if method != "set_power" && b.prop("power") != "on" {
+			if _, err := b.client.Call(ctx, "set_power", "on", "smooth", transition); err != nil {
+				return err
+			}
+			b.setProps(map[string]string{"power": "on"})
+		}
+		_, err := b.client.Call(ctx, method, params...)
+		return err
+	})
+}
+
+func (b *Bulb) State(ctx context.Context) (device.State, error) {
+	var err error
+	if !b.isConnected() {
+		err = b.guard.Do(ctx, b.refresh)
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	attributes := map[string]interface{}{
+		"power": "unknown",
+		"name":  b.props["name"],
+		"model": b.model,
+	}
+	if p := b.props["power"]; p == "on" || p == "off" {
+		attributes["power"] = p
+	}
+	if v, ok := intProp(b.props, "bright"); ok {
+		attributes["brightness"] = v
+	}
+	switch b.props["color_mode"] {
+	case modeCT:
+		if v, ok := intProp(b.props, "ct"); ok {
+			attributes["color_temperature"] = v
+		}
+	case modeHSV:
+		hue, okH := intProp(b.props, "hue")
+		sat, okS := intProp(b.props, "sat")
+		if okH && okS {
+			attributes["hue"] = hue
+			attributes["saturation"] = sat
+		}
+	case modeRGB:
+		if v, ok := intProp(b.props, "rgb"); ok {
+			attributes["hue"], attributes["saturation"] = rgbToHS(v>>16&0xff, v>>8&0xff, v&0xff)
+		}
+	}
+
+	state := device.State{
+		DeviceType: "light",
+		UpdatedAt:  b.updatedAt,
+		Attributes: attributes,
+	}
+	if err != nil {
+		attributes["error"] = err.Error()
+		return state, resilience.Unavailable(err)
+	}
+	return state, nil
+}
+
+func intProp(props map[string]string, name string) (int, bool) {
+	v, err := strconv.Atoi(props[name])
+	return v, err == nil
+}
+
+// rgbToHS returns the hue in degrees and saturation in percent of a color.
+func rgbToHS(r, g, b int) (int, int) {
+	max := math.Max(float64(r), math.Max(float64(g), float64(b)))
+	min := math.Min(float64(r), math.Min(float64(g), float64(b)))
+	if max == 0 {
+		return 0, 0
+	}
+	delta := max - min
+
+	var hue float64
+	switch {
+	case delta == 0:
+		hue = 0
+	case max == float64(r):
+		hue = math.Mod((float64(g)-float64(b))/delta, 6)
+	case max == float64(g):
+		hue = (float64(b)-float64(r))/delta + 2
+	default:
+		hue = (float64(r)-float64(g))/delta + 4
+	}
+	hue *= 60
+	if hue < 0 {
+		hue += 360
+	}
+	return int(math.Round(hue)) % 360, int(math.Round(delta / max * 100))
+}
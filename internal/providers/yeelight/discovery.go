@@ -0,0 +1,104 @@
+package yeelight
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// MulticastAddress is where bulbs listen for searches and announce themselves.
+const MulticastAddress = "239.255.255.250:1982"
+
+const searchRequest = "M-SEARCH * HTTP/1.1\r\n" +
+	"HOST: 239.255.255.250:1982\r\n" +
+	"MAN: \"ssdp:discover\"\r\n" +
+	"ST: wifi_bulb\r\n\r\n"
+
+// Discovered is a bulb that answered a search.
+type Discovered struct {
+	ID      string // 0x-prefixed hex
+	Address string // control host:port, from the Location header
+	Model   string
+	Support []string
+	Props   map[string]string
+}
+
+// Discover sends a search to target (normally MulticastAddress) and collects the
+// replies that arrive within timeout.
+func Discover(ctx context.Context, target string, timeout time.Duration) ([]Discovered, error) {
+	addr, err := net.ResolveUDPAddr("udp4", target)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	if _, err := conn.WriteToUDP([]byte(searchRequest), addr); err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	seen := make(map[string]bool)
+	var found []Discovered
+	buf := make([]byte, 4096)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// The deadline ends discovery; whatever arrived until then is the result
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return found, ctx.Err()
+			}
+			return found, err
+		}
+
+		d, ok := parseAnnouncement(buf[:n])
+		if !ok || seen[d.ID] {
+			continue
+		}
+		seen[d.ID] = true
+		found = append(found, d)
+	}
+}
+
+// parseAnnouncement reads a search reply or NOTIFY: an HTTP-like status line
+// followed by headers, with the bulb's properties as extra headers.
+func parseAnnouncement(b []byte) (Discovered, bool) {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(b)))
+	if _, err := r.ReadLine(); err != nil {
+		return Discovered{}, false
+	}
+	header, err := r.ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return Discovered{}, false
+	}
+
+	location, err := url.Parse(header.Get("Location"))
+	if err != nil || location.Scheme != "yeelight" || header.Get("Id") == "" {
+		return Discovered{}, false
+	}
+
+	d := Discovered{
+		ID:      header.Get("Id"),
+		Address: location.Host,
+		Model:   header.Get("Model"),
+		Support: strings.Fields(header.Get("Support")),
+		Props:   make(map[string]string),
+	}
+	for _, p := range props {
+		if v := header.Get(p); v != "" {
+			d.Props[p] = v
+		}
+	}
+	return d, true
+}
@@ -0,0 +1,20 @@
+package yeelight
+
+import (
+	"errors"
+
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+// ClassifyError retries network failures and dropped connections; errors reported by
+// the bulb itself are permanent.
+func ClassifyError(err error) resilience.Class {
+	if class, ok := resilience.ClassifyNetError(err); ok {
+		return class
+	}
+	if errors.Is(err, ErrDisconnected) {
+		return resilience.Transient
+	}
+
+	return resilience.Permanent
+}
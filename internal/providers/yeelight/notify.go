@@ -0,0 +1,40 @@
+package yeelight
+
+import (
+	"context"
+
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+// listen keeps the control connection open until ctx is cancelled, so props
+// notifications keep the cache current. The properties are read again after every
+// reconnect, as changes made while disconnected weren't notified.
+func (b *Bulb) listen(ctx context.Context) {
+	resilience.Reconnect(ctx, "yeelight: "+b.client.address+": connection closed", func(ctx context.Context) (bool, error) {
+		err := b.listenOnce(ctx)
+		connected := b.isConnected()
+		b.setConnected(false)
+		return connected, err
+	})
+}
+
+func (b *Bulb) listenOnce(ctx context.Context) error {
+	closed, err := b.client.Connect(ctx)
+	if err != nil {
+		return err
+	}
+	if err := b.refresh(ctx); err != nil {
+		b.client.Close()
+		return err
+	}
+	b.setConnected(true)
+
+	select {
+	case <-closed:
+		return ErrDisconnected
+	case <-ctx.Done():
+		b.client.Close()
+		<-closed
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,194 @@
+// Package yeelight integrates Yeelight bulbs over their LAN control protocol:
+// line-delimited JSON-RPC over TCP, found with SSDP-like searches on port 1982.
+// LAN control has to be enabled for each bulb in the Yeelight app.
+package yeelight
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+func init() {
+	provider.RegisterFactory("yeelight", NewProvider)
+}
+
+// searchPort is where bulbs answer searches, unicast as well as multicast.
+const searchPort = 1982
+
+type Settings struct {
+	// Hosts are searched directly, for bulbs that multicast doesn't reach
+	Hosts []string `json:"hosts"`
+	// Discovery searches the local network by multicast, on by default
+	Discovery        *bool               `json:"discovery,omitempty"`
+	MulticastAddress string              `json:"multicast_address"`
+	DiscoveryTimeout config.Duration     `json:"discovery_timeout"`
+	Resilience       resilience.Settings `json:"resilience"`
+}
+
+type Provider struct {
+	name     string
+	settings Settings
+	guards   *resilience.Registry
+
+	mu        sync.Mutex
+	registry  provider.Registry
+	bulbs     map[device.ID]*Bulb
+	cancel    context.CancelFunc
+	listenCtx context.Context
+	wg        sync.WaitGroup
+}
+
+func NewProvider(name string, raw json.RawMessage, env provider.Env) (provider.Provider, error) {
+	var settings Settings
+	if err := provider.DecodeSettings(raw, &settings); err != nil {
+		return nil, err
+	}
+	if settings.MulticastAddress == "" {
+		settings.MulticastAddress = MulticastAddress
+	}
+	if settings.DiscoveryTimeout <= 0 {
+		settings.DiscoveryTimeout = config.Duration(2 * time.Second)
+	}
+
+	guards := env.Guards
+	if guards == nil {
+		guards = resilience.NewRegistry()
+	}
+	guards.Configure(name, settings.Resilience.Apply(resilience.DefaultConfig()), ClassifyError)
+
+	return &Provider{
+		name:     name,
+		settings: settings,
+		guards:   guards,
+		bulbs:    make(map[device.ID]*Bulb),
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+func (p *Provider) discoveryEnabled() bool {
+	return p.settings.Discovery == nil || *p.settings.Discovery
+}
+
+func (p *Provider) Start(ctx context.Context, registry provider.Registry) error {
+	if len(p.settings.Hosts) == 0 && !p.discoveryEnabled() {
+		return fmt.Errorf("%w: no hosts and discovery is off", provider.ErrNotConfigured)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.registry = registry
+	p.listenCtx, p.cancel = context.WithCancel(context.Background())
+	return nil
+}
+
+// Discover searches the configured hosts and the multicast group, registering bulbs
+// that aren't registered yet. Each bulb's control connection is opened once it is
+// registered.
+func (p *Provider) Discover(ctx context.Context) error {
+	var (
+		found []Discovered
+		errs  []error
+	)
+
+	targets := make([]string, 0, len(p.settings.Hosts)+1)
+	for _, host := range p.settings.Hosts {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, fmt.Sprint(searchPort))
+		}
+		targets = append(targets, host)
+	}
+	if p.discoveryEnabled() {
+		targets = append(targets, p.settings.MulticastAddress)
+	}
+
+	for _, target := range targets {
+		replies, err := Discover(ctx, target, p.settings.DiscoveryTimeout.Duration())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", target, err))
+		}
+		found = append(found, replies...)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, d := range found {
+		id := bulbID(d.ID)
+		if _, ok := p.bulbs[id]; ok {
+			continue
+		}
+
+		b := newBulb(d, p.guards.Guard(p.name, d.Address))
+		if err := p.registry.Register(b); err != nil {
+			if !errors.Is(err, device.ErrDeviceAlreadyRegistered) {
+				log.Printf("yeelight: failed to register %s: %v", id, err)
+			}
+			continue
+		}
+		p.bulbs[id] = b
+		log.Printf("yeelight: registered %s (%s) at %s as %s", d.Props["name"], d.Model, d.Address, id)
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			b.listen(p.listenCtx)
+		}()
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *Provider) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	cancel := p.cancel
+	p.cancel = nil
+	for id := range p.bulbs {
+		p.registry.Unregister(id)
+	}
+	p.bulbs = make(map[device.ID]*Bulb)
+	p.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		p.wg.Wait()
+	}
+	return nil
+}
+
+// Health reports the breaker state of each bulb, and degraded while a bulb's control
+// connection is down.
+func (p *Provider) Health(ctx context.Context) provider.Health {
+	p.mu.Lock()
+	var disconnected []device.ID
+	for id, b := range p.bulbs {
+		if !b.isConnected() {
+			disconnected = append(disconnected, id)
+		}
+	}
+	p.mu.Unlock()
+
+	health := provider.Health{Status: provider.HealthOK}
+	if guards, ok := p.guards.ProviderHealth(p.name); ok {
+		health.Status = guards.Status
+		health.Details = guards
+	}
+	if health.Status == provider.HealthOK && len(disconnected) > 0 {
+		health.Status = provider.HealthDegraded
+		health.Message = fmt.Sprintf("not connected: %v", disconnected)
+	}
+	return health
+}
@@ -0,0 +1,164 @@
+package yeelight
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/provider/providertest"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/yeelight/yeelighttest"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+func startProvider(t *testing.T, settings Settings) (*device.Registry, *provider.Manager) {
+	t.Helper()
+
+	settings.DiscoveryTimeout = config.Duration(100 * time.Millisecond)
+	return providertest.Start(t, NewProvider, "yeelight", settings)
+}
+
+// waitTimeout allows for one reconnect delay
+const waitTimeout = resilience.ReconnectMinDelay + 2*time.Second
+
+func hosts(fakes ...*yeelighttest.Bulb) Settings {
+	off := false
+	return Settings{Discovery: &off, Hosts: providertest.Addresses(fakes, (*yeelighttest.Bulb).SearchAddress)}
+}
+
+func TestParseAnnouncement(t *testing.T) {
+	reply := "HTTP/1.1 200 OK\r\n" +
+		"Cache-Control: max-age=3600\r\n" +
+		"Location: yeelight://192.168.1.239:55443\r\n" +
+		"id: 0x000000000015243f\r\n" +
+		"model: color\r\n" +
+		"support: get_prop set_power toggle\r\n" +
+		"power: on\r\n" +
+		"bright: 100\r\n" +
+		"name: my_bulb\r\n"
+
+	d, ok := parseAnnouncement([]byte(reply))
+	if !ok {
+		t.Fatal("Expected the reply to parse")
+	}
+	if d.ID != "0x000000000015243f" || d.Address != "192.168.1.239:55443" || d.Model != "color" {
+		t.Errorf("Unexpected bulb %+v", d)
+	}
+	if len(d.Support) != 3 || d.Props["power"] != "on" || d.Props["name"] != "my_bulb" {
+		t.Errorf("Unexpected support %v or props %v", d.Support, d.Props)
+	}
+	if bulbID(d.ID) != "yeelight-000000000015243f" {
+		t.Errorf("Unexpected ID %s", bulbID(d.ID))
+	}
+
+	if _, ok := parseAnnouncement([]byte("HTTP/1.1 200 OK\r\nLocation: http://example.com\r\n")); ok {
+		t.Error("Expected a non-yeelight reply to be ignored")
+	}
+}
+
+func TestYeelight_Commands(t *testing.T) {
+	ctx := context.Background()
+	bulb := yeelighttest.NewBulb(t, "Bedside", 0x15243f)
+	registry, _ := startProvider(t, hosts(bulb))
+
+	dev, err := registry.Get("yeelight-000000000015243f")
+	if err != nil {
+		t.Fatalf("Expected bulb to be registered: %v", err)
+	}
+
+	state, _ := dev.State(ctx)
+	if state.DeviceType != "light" || state.Attributes["power"] != "off" || state.Attributes["name"] != "Bedside" {
+		t.Errorf("Unexpected state: %+v", state)
+	}
+	if state.Attributes["color_temperature"] != 4000 {
+		t.Errorf("Unexpected white state: %v", state.Attributes)
+	}
+
+	// The bulb is off, so it is turned on first
+	cmd := device.Command{DeviceID: dev.ID(), Action: "set_color", Params: map[string]any{"hue": float64(120), "saturation": 80}}
+	if err := dev.Execute(ctx, cmd); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if bulb.Prop("power") != "on" || bulb.Prop("hue") != "120" || bulb.Prop("color_mode") != "3" {
+		t.Errorf("Expected bulb on in colour mode, got power=%s hue=%s", bulb.Prop("power"), bulb.Prop("hue"))
+	}
+
+	cmd = device.Command{DeviceID: dev.ID(), Action: "set_brightness", Params: map[string]any{"value": 40}}
+	if err := dev.Execute(ctx, cmd); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	state, _ = dev.State(ctx)
+	if state.Attributes["hue"] != 120 || state.Attributes["saturation"] != 80 || state.Attributes["brightness"] != 40 {
+		t.Errorf("Unexpected colour state: %v", state.Attributes)
+	}
+
+	cmd = device.Command{DeviceID: dev.ID(), Action: "set_color_temperature", Params: map[string]any{"value": 9000}}
+	if err := dev.Execute(ctx, cmd); !errors.Is(err, device.ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter, got %v", err)
+	}
+	if err := dev.Execute(ctx, device.Command{DeviceID: dev.ID(), Action: "toggle"}); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if bulb.Prop("power") != "off" {
+		t.Error("Expected toggle to turn the bulb off")
+	}
+}
+
+func TestYeelight_NotificationsAndReconnect(t *testing.T) {
+	ctx := context.Background()
+	bulb := yeelighttest.NewBulb(t, "Lounge", 0xabc)
+	registry, m := startProvider(t, hosts(bulb))
+	dev, _ := registry.Get("yeelight-0000000000000abc")
+	b := dev.(*Bulb)
+
+	providertest.WaitFor(t, waitTimeout, "the control connection", b.isConnected)
+
+	// Changed in the app: the notification updates the cache without a get_prop
+	bulb.Set(map[string]string{"power": "on", "bright": "15"})
+	providertest.WaitFor(t, waitTimeout, "the notification", func() bool {
+		state, _ := dev.State(ctx)
+		return state.Attributes["power"] == "on" && state.Attributes["brightness"] == 15
+	})
+
+	bulb.Disconnect()
+	providertest.WaitFor(t, waitTimeout, "the connection to drop", func() bool { return !b.isConnected() })
+	if health, _ := m.Health(ctx, "yeelight"); health.Status != provider.HealthDegraded {
+		t.Errorf("Expected degraded health while disconnected, got %+v", health)
+	}
+
+	// Missed while disconnected; read again on reconnect
+	bulb.Set(map[string]string{"bright": "70"})
+	providertest.WaitFor(t, waitTimeout, "the reconnect", func() bool { return b.isConnected() && bulb.Connections() == 1 })
+	state, _ := dev.State(ctx)
+	if state.Attributes["brightness"] != 70 {
+		t.Errorf("Expected brightness 70 after reconnecting, got %v", state.Attributes["brightness"])
+	}
+}
+
+func TestYeelight_MulticastDiscovery(t *testing.T) {
+	bulb := yeelighttest.NewBulb(t, "Hall", 0x1)
+	registry, _ := startProvider(t, Settings{MulticastAddress: bulb.SearchAddress()})
+
+	dev, err := registry.Get("yeelight-0000000000000001")
+	if err != nil {
+		t.Fatalf("Expected discovered bulb to be registered: %v", err)
+	}
+	if err := dev.Execute(context.Background(), device.Command{DeviceID: dev.ID(), Action: "turn_on"}); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if bulb.Prop("power") != "on" {
+		t.Error("Expected bulb to be on")
+	}
+}
+
+func TestYeelight_Conformance(t *testing.T) {
+	providertest.LightConformance(t, "yeelight-000000000015243f", func(t *testing.T) *device.Registry {
+		bulb := yeelighttest.NewBulb(t, "Bedside", 0x15243f)
+		registry, _ := startProvider(t, hosts(bulb))
+		return registry
+	})
+}
@@ -0,0 +1,255 @@
+// Package yeelighttest runs fake Yeelight bulbs for tests. Each fake answers searches
+// over UDP and the control protocol over TCP on 127.0.0.1, and notifies every
+// connection of property changes like a real bulb.
+package yeelighttest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+type Bulb struct {
+	id     string
+	tcp    net.Listener
+	search *net.UDPConn
+
+	mu    sync.Mutex
+	props map[string]string
+	conns map[net.Conn]bool
+}
+
+// NewBulb starts a fake color bulb that is off, at full brightness and 4000K. It is
+// closed when the test ends.
+func NewBulb(t testing.TB, name string, id uint64) *Bulb {
+	t.Helper()
+
+	tcp, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	search, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		tcp.Close()
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	b := &Bulb{
+		id:     fmt.Sprintf("0x%016x", id),
+		tcp:    tcp,
+		search: search,
+		props: map[string]string{
+			"power": "off", "bright": "100", "color_mode": "2", "ct": "4000",
+			"rgb": "16777215", "hue": "0", "sat": "0", "name": name,
+		},
+		conns: make(map[net.Conn]bool),
+	}
+	go b.serveSearch()
+	go b.serveTCP()
+	t.Cleanup(func() {
+		tcp.Close()
+		search.Close()
+		b.Disconnect()
+	})
+	return b
+}
+
+// SearchAddress is where the bulb answers searches, for the provider's hosts or
+// multicast_address settings.
+func (b *Bulb) SearchAddress() string {
+	return b.search.LocalAddr().String()
+}
+
+// Prop returns a property value.
+func (b *Bulb) Prop(name string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.props[name]
+}
+
+// Set changes properties as the app or a wall switch would, notifying connections.
+func (b *Bulb) Set(props map[string]string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.update(props)
+}
+
+// Connections is the number of open control connections.
+func (b *Bulb) Connections() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.conns)
+}
+
+// Disconnect closes every control connection.
+func (b *Bulb) Disconnect() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for conn := range b.conns {
+		conn.Close()
+	}
+}
+
+func (b *Bulb) serveSearch() {
+	buf := make([]byte, 1024)
+	for {
+		_, from, err := b.search.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		reply := "HTTP/1.1 200 OK\r\n" +
+			"Cache-Control: max-age=3600\r\n" +
+			"Location: yeelight://" + b.tcp.Addr().String() + "\r\n" +
+			"Server: POSIX UPnP/1.0 YGLC/1\r\n" +
+			"id: " + b.id + "\r\n" +
+			"model: color\r\n" +
+			"fw_ver: 18\r\n" +
+			"support: get_prop set_default set_power toggle set_bright start_cf stop_cf set_scene cron_add cron_get cron_del set_ct_abx set_rgb set_hsv set_adjust set_music set_name\r\n"
+		for _, key := range []string{"power", "bright", "color_mode", "ct", "rgb", "hue", "sat", "name"} {
+			reply += key + ": " + b.props[key] + "\r\n"
+		}
+		b.mu.Unlock()
+		b.search.WriteToUDP([]byte(reply), from)
+	}
+}
+
+func (b *Bulb) serveTCP() {
+	for {
+		conn, err := b.tcp.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.conns[conn] = true
+		b.mu.Unlock()
+
+		go func() {
+			defer func() {
+				b.mu.Lock()
+				delete(b.conns, conn)
+				b.mu.Unlock()
+				conn.Close()
+			}()
+
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				var req struct {
+					ID     int64  `json:"id"`
+					Method string `json:"method"`
+					Params []any  `json:"params"`
+				}
+				if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+					continue
+				}
+
+				b.mu.Lock()
+				reply := map[string]any{"id": req.ID}
+				if result, err := b.call(req.Method, req.Params); err != "" {
+					reply["error"] = map[string]any{"code": -1, "message": err}
+				} else {
+					reply["result"] = result
+				}
+				line, _ := json.Marshal(reply)
+				conn.Write(append(line, '\r', '\n'))
+				b.mu.Unlock()
+			}
+		}()
+	}
+}
+
+// call runs one method, returning its result or an error message. Must be called
+// with mu held.
+func (b *Bulb) call(method string, params []any) ([]any, string) {
+	if method == "get_prop" {
+		result := make([]any, len(params))
+		for i, p := range params {
+			name, _ := p.(string)
+			result[i] = b.props[name]
+		}
+		return result, ""
+	}
+
+	number := func(i int) (int, bool) {
+		if i >= len(params) {
+			return 0, false
+		}
+		v, ok := params[i].(float64)
+		return int(v), ok
+	}
+
+	switch method {
+	case "set_power":
+		if len(params) == 0 || (params[0] != "on" && params[0] != "off") {
+			return nil, "invalid params"
+		}
+		b.update(map[string]string{"power": params[0].(string)})
+		return []any{"ok"}, ""
+	case "toggle":
+		power := "on"
+		if b.props["power"] == "on" {
+			power = "off"
+		}
+		b.update(map[string]string{"power": power})
+		return []any{"ok"}, ""
+	}
+
+	// Like real bulbs, nothing else works while off
+	if b.props["power"] != "on" {
+		return nil, "method not supported"
+	}
+
+	switch method {
+	case "set_bright":
+		v, ok := number(0)
+		if !ok || v < 1 || v > 100 {
+			return nil, "invalid params"
+		}
+		b.update(map[string]string{"bright": strconv.Itoa(v)})
+	case "set_ct_abx":
+		v, ok := number(0)
+		if !ok || v < 1700 || v > 6500 {
+			return nil, "invalid params"
+		}
+		b.update(map[string]string{"ct": strconv.Itoa(v), "color_mode": "2"})
+	case "set_hsv":
+		hue, okH := number(0)
+		sat, okS := number(1)
+		if !okH || !okS || hue < 0 || hue > 359 || sat < 0 || sat > 100 {
+			return nil, "invalid params"
+		}
+		b.update(map[string]string{"hue": strconv.Itoa(hue), "sat": strconv.Itoa(sat), "color_mode": "3"})
+	case "set_rgb":
+		v, ok := number(0)
+		if !ok || v < 0 || v > 0xffffff {
+			return nil, "invalid params"
+		}
+		b.update(map[string]string{"rgb": strconv.Itoa(v), "color_mode": "1"})
+	default:
+		return nil, "method not supported"
+	}
+	return []any{"ok"}, ""
+}
+
+// update changes properties and notifies every connection of the ones that changed.
+// Must be called with mu held.
+func (b *Bulb) update(props map[string]string) {
+	changed := make(map[string]string)
+	for k, v := range props {
+		if b.props[k] != v {
+			b.props[k] = v
+			changed[k] = v
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+	line, _ := json.Marshal(map[string]any{"method": "props", "params": changed})
+	for conn := range b.conns {
+		conn.Write(append(line, '\r', '\n'))
+	}
+}
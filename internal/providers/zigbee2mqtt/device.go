@@ -0,0 +1,286 @@
+package zigbee2mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/mqttclient"
+)
+
+// Device is a Zigbee device managed by zigbee2mqtt.
+type Device struct {
+	id        device.ID
+	info      bridgeDevice
+	caps      capabilities
+	baseTopic string
+	client    *mqttclient.Client
+	subs      []mqttclient.Subscription
+
+	mu         sync.RWMutex
+	attributes map[string]any
+	available  bool
+	updatedAt  time.Time
+}
+
+func newDevice(info bridgeDevice, baseTopic string, client *mqttclient.Client) *Device {
+	return &Device{
+		id:         deviceID(info.IEEEAddress),
+		info:       info,
+		caps:       deriveCapabilities(info.Definition),
+		baseTopic:  baseTopic,
+		client:     client,
+		attributes: make(map[string]any),
+		available:  true,
+		updatedAt:  time.Now(),
+	}
+}
+
+func deviceID(ieee string) device.ID {
+	return device.ID("zigbee-" + ieee)
+}
+
+func (d *Device) ID() device.ID {
+	return d.id
+}
+
+func (d *Device) stateTopic() string {
+	return d.baseTopic + "/" + d.info.FriendlyName
+}
+
+func (d *Device) availabilityTopic() string {
+	return d.stateTopic() + "/availability"
+}
+
+func (d *Device) subscribe(ctx context.Context) error {
+	sub, err := d.client.Subscribe(ctx, d.stateTopic(), d.handleState)
+	d.subs = append(d.subs, sub)
+	if err != nil {
+		return err
+	}
+	sub, err = d.client.Subscribe(ctx, d.availabilityTopic(), d.handleAvailability)
+	d.subs = append(d.subs, sub)
+	return err
+}
+
+func (d *Device) unsubscribe(ctx context.Context) {
+	d.client.Unsubscribe(ctx, d.subs...)
+	d.subs = nil
+}
+
+func (d *Device) handleState(topic string, payload []byte) {
+	var values map[string]any
+	if err := json.Unmarshal(payload, &values); err != nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for property, v := range values {
+		if _, exposed := d.caps.properties[property]; !exposed {
+			continue
+		}
+		name, value := d.translate(property, v)
+		d.attributes[name] = value
+	}
+	d.updatedAt = time.Now()
+}
+
+// translate maps a zigbee2mqtt property to the attribute names and units the rest
+// of the hub uses for lights, covers and locks. Anything else keeps its own name.
+func (d *Device) translate(property string, v any) (string, any) {
+	c := d.caps
+	switch {
+	case c.onOff != nil && property == c.onOff.Property:
+		if fmt.Sprint(v) == fmt.Sprint(onValue(c.onOff)) {
+			return "power", "on"
+		}
+		return "power", "off"
+	case c.brightness != nil && property == c.brightness.Property:
+		if n, ok := v.(float64); ok {
+			return "brightness", int(math.Round(n * 100 / maxValue(c.brightness, 254)))
+		}
+	case c.colorTemp != nil && property == c.colorTemp.Property:
+		return "color_temperature", v
+	case c.cover != nil && property == c.cover.Property:
+		return "cover_state", strings.ToLower(fmt.Sprint(v))
+	case c.lock != nil && property == c.lock.Property:
+		return "locked", fmt.Sprint(v) == "LOCK"
+	}
+	return property, v
+}
+
+func (d *Device) handleAvailability(topic string, payload []byte) {
+	// zigbee2mqtt sends either "online"/"offline" or {"state": "online"}
+	status := strings.TrimSpace(string(payload))
+	var wrapped struct {
+		State string `json:"state"`
+	}
+	if json.Unmarshal(payload, &wrapped) == nil && wrapped.State != "" {
+		status = wrapped.State
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.available = status != "offline"
+}
+
+func (d *Device) Execute(ctx context.Context, cmd device.Command) error {
+	d.mu.RLock()
+	available := d.available
+	d.mu.RUnlock()
+	if !available {
+		return fmt.Errorf("%w: %s is offline", device.ErrDeviceUnavailable, d.info.FriendlyName)
+	}
+
+	payload, err := d.payloadFor(cmd)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return d.client.Publish(ctx, d.stateTopic()+"/set", data, false)
+}
+
+// payloadFor builds the /set message for a hub command.
+func (d *Device) payloadFor(cmd device.Command) (map[string]any, error) {
+	c := d.caps
+
+	switch {
+	case cmd.Action == "turn_on" && c.onOff != nil:
+		return map[string]any{c.onOff.Property: onValue(c.onOff)}, nil
+
+	case cmd.Action == "turn_off" && c.onOff != nil:
+		return map[string]any{c.onOff.Property: offValue(c.onOff)}, nil
+
+	case cmd.Action == "set_brightness" && c.brightness != nil:
+		v, err := device.NumberParam(cmd.Params, "value", 0, 100)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{c.brightness.Property: int(math.Round(v * maxValue(c.brightness, 254) / 100))}, nil
+
+	case cmd.Action == "set_color_temperature" && c.colorTemp != nil:
+		min, max := 0.0, math.MaxFloat64
+		if c.colorTemp.ValueMin != nil {
+			min = *c.colorTemp.ValueMin
+		}
+		if c.colorTemp.ValueMax != nil {
+			max = *c.colorTemp.ValueMax
+		}
+		v, err := device.NumberParam(cmd.Params, "value", min, max)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{c.colorTemp.Property: int(v)}, nil
+
+	case cmd.Action == "set_color" && (c.colorXY != nil || c.colorHS != nil):
+		if _, ok := cmd.Params["x"]; ok {
+			x, err := device.NumberParam(cmd.Params, "x", 0, 1)
+			if err != nil {
+				return nil, err
+			}
+			y, err := device.NumberParam(cmd.Params, "y", 0, 1)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{"color": map[string]any{"x": x, "y": y}}, nil
+		}
+		h, err := device.NumberParam(cmd.Params, "hue", 0, 360)
+		if err != nil {
+			return nil, err
+		}
+		s, err := device.NumberParam(cmd.Params, "saturation", 0, 100)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"color": map[string]any{"hue": h, "saturation": s}}, nil
+
+	case (cmd.Action == "open" || cmd.Action == "close" || cmd.Action == "stop") && c.cover != nil:
+		return map[string]any{c.cover.Property: strings.ToUpper(cmd.Action)}, nil
+
+	case cmd.Action == "set_position" && c.position != nil:
+		v, err := device.NumberParam(cmd.Params, "value", 0, 100)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{c.position.Property: int(v)}, nil
+
+	case (cmd.Action == "lock" || cmd.Action == "unlock") && c.lock != nil:
+		return map[string]any{c.lock.Property: strings.ToUpper(cmd.Action)}, nil
+
+	case cmd.Action == "set_temperature" && c.setpoint != nil:
+		min, max := 5.0, 35.0
+		if c.setpoint.ValueMin != nil {
+			min = *c.setpoint.ValueMin
+		}
+		if c.setpoint.ValueMax != nil {
+			max = *c.setpoint.ValueMax
+		}
+		v, err := device.NumberParam(cmd.Params, "value", min, max)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{c.setpoint.Property: v}, nil
+
+	case cmd.Action == "set" && len(c.writableSet) > 0:
+		// Raw access to any writable property: {"params": {"power_on_behavior": "previous"}}
+		if len(cmd.Params) == 0 {
+			return nil, fmt.Errorf("%w: set needs at least one property", device.ErrInvalidParameter)
+		}
+		for property := range cmd.Params {
+			if _, ok := c.writableSet[property]; !ok {
+				return nil, fmt.Errorf("%w: %s is not writable", device.ErrInvalidParameter, property)
+			}
+		}
+		return cmd.Params, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", device.ErrUnknownCommand, cmd.Action)
+}
+
+func (d *Device) State(ctx context.Context) (device.State, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	attributes := make(map[string]interface{}, len(d.attributes)+5)
+	for k, v := range d.attributes {
+		attributes[k] = v
+	}
+	attributes["available"] = d.available
+	attributes["name"] = d.info.FriendlyName
+	attributes["actions"] = d.caps.actions()
+	if d.info.Definition != nil {
+		attributes["model"] = d.info.Definition.Model
+		attributes["vendor"] = d.info.Definition.Vendor
+	}
+
+	return device.State{
+		DeviceType: d.caps.deviceType,
+		UpdatedAt:  d.updatedAt,
+		Attributes: attributes,
+	}, nil
+}
+
+func onValue(e *expose) any {
+	if e.ValueOn != nil {
+		return e.ValueOn
+	}
+	return "ON"
+}
+
+func offValue(e *expose) any {
+	if e.ValueOff != nil {
+		return e.ValueOff
+	}
+	return "OFF"
+}
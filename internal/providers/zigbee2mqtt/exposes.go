@@ -0,0 +1,186 @@
+package zigbee2mqtt
+
+// bridgeDevice is one entry of the zigbee2mqtt/bridge/devices message.
+type bridgeDevice struct {
+	IEEEAddress  string      `json:"ieee_address"`
+	FriendlyName string      `json:"friendly_name"`
+	Type         string      `json:"type"` // Coordinator, Router or EndDevice
+	Supported    bool        `json:"supported"`
+	Disabled     bool        `json:"disabled"`
+	Definition   *definition `json:"definition"`
+}
+
+type definition struct {
+	Model       string   `json:"model"`
+	Vendor      string   `json:"vendor"`
+	Description string   `json:"description"`
+	Exposes     []expose `json:"exposes"`
+}
+
+// expose is either a generic feature (binary, numeric, enum, ...) or a specific one
+// (light, switch, cover, lock, climate) grouping generic features.
+type expose struct {
+	Type     string   `json:"type"`
+	Name     string   `json:"name"`
+	Property string   `json:"property"`
+	Endpoint string   `json:"endpoint"`
+	Access   int      `json:"access"`
+	ValueOn  any      `json:"value_on"`
+	ValueOff any      `json:"value_off"`
+	ValueMin *float64 `json:"value_min"`
+	ValueMax *float64 `json:"value_max"`
+	Values   []string `json:"values"`
+	Unit     string   `json:"unit"`
+	Features []expose `json:"features"`
+}
+
+// Access bits, see the zigbee2mqtt exposes documentation
+const (
+	accessState = 1 // published in the device state
+	accessSet   = 2 // can be written with /set
+)
+
+// capabilities is what the hub knows it can do with a device.
+type capabilities struct {
+	deviceType string
+
+	// Properties in the device state, keyed by property name
+	properties map[string]expose
+
+	// Specific features, each pointing at the generic features it groups
+	onOff       *expose // state of a light or switch
+	brightness  *expose
+	colorTemp   *expose
+	colorXY     *expose
+	colorHS     *expose
+	cover       *expose
+	position    *expose
+	lock        *expose
+	setpoint    *expose
+	writableSet map[string]expose
+}
+
+// deriveCapabilities walks a device's exposes. The first specific feature decides the
+// device type; devices that only publish values are sensors.
+func deriveCapabilities(def *definition) capabilities {
+	caps := capabilities{
+		properties:  make(map[string]expose),
+		writableSet: make(map[string]expose),
+	}
+	if def == nil {
+		caps.deviceType = "generic"
+		return caps
+	}
+
+	for _, e := range def.Exposes {
+		switch e.Type {
+		case "light", "switch", "cover", "lock", "climate", "fan":
+			if caps.deviceType == "" {
+				caps.deviceType = specificType(e.Type)
+			}
+			for _, f := range e.Features {
+				caps.addSpecificFeature(e.Type, f)
+			}
+		default:
+			caps.addGeneric(e)
+		}
+	}
+
+	if caps.deviceType == "" {
+		if len(caps.writableSet) > 0 {
+			caps.deviceType = "generic"
+		} else {
+			caps.deviceType = "sensor"
+		}
+	}
+	return caps
+}
+
+func specificType(t string) string {
+	if t == "climate" {
+		return "thermostat"
+	}
+	return t
+}
+
+func (c *capabilities) addSpecificFeature(parent string, f expose) {
+	c.addGeneric(f)
+
+	feature := f
+	switch {
+	case (parent == "light" || parent == "switch") && f.Name == "state":
+		if c.onOff == nil {
+			c.onOff = &feature
+		}
+	case parent == "light" && f.Name == "brightness":
+		c.brightness = &feature
+	case parent == "light" && f.Name == "color_temp":
+		c.colorTemp = &feature
+	case parent == "light" && f.Name == "color_xy":
+		c.colorXY = &feature
+	case parent == "light" && f.Name == "color_hs":
+		c.colorHS = &feature
+	case parent == "cover" && f.Name == "state":
+		c.cover = &feature
+	case parent == "cover" && f.Name == "position":
+		c.position = &feature
+	case parent == "lock" && f.Name == "state":
+		c.lock = &feature
+	case parent == "climate" && (f.Name == "occupied_heating_setpoint" || f.Name == "current_heating_setpoint"):
+		if c.setpoint == nil {
+			c.setpoint = &feature
+		}
+	}
+}
+
+func (c *capabilities) addGeneric(e expose) {
+	if e.Property == "" {
+		return
+	}
+	if e.Access&accessState != 0 {
+		c.properties[e.Property] = e
+	}
+	if e.Access&accessSet != 0 {
+		c.writableSet[e.Property] = e
+	}
+}
+
+// actions lists the hub commands the capabilities translate to.
+func (c capabilities) actions() []string {
+	var actions []string
+	if c.onOff != nil {
+		actions = append(actions, "turn_on", "turn_off")
+	}
+	if c.brightness != nil {
+		actions = append(actions, "set_brightness")
+	}
+	if c.colorTemp != nil {
+		actions = append(actions, "set_color_temperature")
+	}
+	if c.colorXY != nil || c.colorHS != nil {
+		actions = append(actions, "set_color")
+	}
+	if c.cover != nil {
+		actions = append(actions, "open", "close", "stop")
+	}
+	if c.position != nil {
+		actions = append(actions, "set_position")
+	}
+	if c.lock != nil {
+		actions = append(actions, "lock", "unlock")
+	}
+	if c.setpoint != nil {
+		actions = append(actions, "set_temperature")
+	}
+	if len(c.writableSet) > 0 {
+		actions = append(actions, "set")
+	}
+	return actions
+}
+
+func maxValue(e *expose, fallback float64) float64 {
+	if e != nil && e.ValueMax != nil && *e.ValueMax > 0 {
+		return *e.ValueMax
+	}
+	return fallback
+}
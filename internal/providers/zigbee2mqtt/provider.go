@@ -0,0 +1,217 @@
+package zigbee2mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/mqttclient"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+)
+
+func init() {
+	provider.RegisterFactory("zigbee2mqtt", NewProvider)
+}
+
+type Settings struct {
+	mqttclient.Settings
+	BaseTopic string `json:"base_topic"`
+}
+
+// Provider registers every device zigbee2mqtt reports on <base>/bridge/devices and
+// keeps the list in sync as devices are paired, renamed or removed.
+type Provider struct {
+	name     string
+	settings Settings
+
+	mu       sync.Mutex
+	registry provider.Registry
+	client   *mqttclient.Client
+	devices  map[device.ID]*Device
+	// last bridge/devices payload, reapplied by Discover
+	lastList []bridgeDevice
+	received chan struct{}
+}
+
+func NewProvider(name string, raw json.RawMessage, env provider.Env) (provider.Provider, error) {
+	settings := Settings{BaseTopic: "zigbee2mqtt"}
+	if err := provider.DecodeSettings(raw, &settings); err != nil {
+		return nil, err
+	}
+	if settings.ClientID == "" {
+		settings.ClientID = "smarthomehub-" + name
+	}
+	return &Provider{
+		name:     name,
+		settings: settings,
+		devices:  make(map[device.ID]*Device),
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+func (p *Provider) Start(ctx context.Context, registry provider.Registry) error {
+	if p.settings.Broker == "" {
+		return fmt.Errorf("%w: broker is required", provider.ErrNotConfigured)
+	}
+
+	client, err := mqttclient.Connect(ctx, p.settings.Settings)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.registry = registry
+	p.client = client
+	p.received = make(chan struct{})
+	p.mu.Unlock()
+
+	// bridge/devices is retained, so we get the current list straight away
+	topic := p.settings.BaseTopic + "/bridge/devices"
+	if _, err := client.Subscribe(ctx, topic, p.handleDevices); err != nil {
+		client.Close()
+		return fmt.Errorf("failed to subscribe to %s: %w", topic, err)
+	}
+	return nil
+}
+
+func (p *Provider) handleDevices(topic string, payload []byte) {
+	var list []bridgeDevice
+	if err := json.Unmarshal(payload, &list); err != nil {
+		log.Printf("zigbee2mqtt: invalid device list: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Messages can still be in flight after Stop
+	if p.client == nil {
+		return
+	}
+
+	p.lastList = list
+	p.sync(ctx)
+
+	select {
+	case <-p.received:
+	default:
+		close(p.received)
+	}
+}
+
+// sync registers new devices, drops removed ones and recreates devices whose
+// friendly name or definition changed. Must be called with mu held.
+func (p *Provider) sync(ctx context.Context) {
+	seen := make(map[device.ID]bool)
+	for _, info := range p.lastList {
+		if info.Type == "Coordinator" || info.Disabled || !info.Supported {
+			continue
+		}
+		id := deviceID(info.IEEEAddress)
+		seen[id] = true
+
+		if existing, ok := p.devices[id]; ok {
+			if existing.info.FriendlyName == info.FriendlyName && reflect.DeepEqual(existing.info.Definition, info.Definition) {
+				continue
+			}
+			p.remove(ctx, existing)
+		}
+
+		d := newDevice(info, p.settings.BaseTopic, p.client)
+		if err := d.subscribe(ctx); err != nil {
+			d.unsubscribe(ctx)
+			log.Printf("zigbee2mqtt: failed to subscribe for %s: %v", info.FriendlyName, err)
+			continue
+		}
+		if err := p.registry.Register(d); err != nil {
+			d.unsubscribe(ctx)
+			log.Printf("zigbee2mqtt: failed to register %s: %v", id, err)
+			continue
+		}
+		p.devices[id] = d
+		log.Printf("zigbee2mqtt: registered %s (%s) as %s", info.FriendlyName, d.caps.deviceType, id)
+	}
+
+	for id, d := range p.devices {
+		if !seen[id] {
+			p.remove(ctx, d)
+		}
+	}
+}
+
+func (p *Provider) remove(ctx context.Context, d *Device) {
+	d.unsubscribe(ctx)
+	p.registry.Unregister(d.id)
+	delete(p.devices, d.id)
+}
+
+// Discover waits briefly for the first device list, then reapplies the latest one.
+func (p *Provider) Discover(ctx context.Context) error {
+	p.mu.Lock()
+	received := p.received
+	p.mu.Unlock()
+
+	select {
+	case <-received:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(5 * time.Second):
+		return fmt.Errorf("no device list received on %s/bridge/devices", p.settings.BaseTopic)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sync(ctx)
+	return nil
+}
+
+func (p *Provider) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, d := range p.devices {
+		p.registry.Unregister(d.id)
+	}
+	p.devices = make(map[device.ID]*Device)
+	if p.client != nil {
+		p.client.Close()
+		p.client = nil
+	}
+	return nil
+}
+
+func (p *Provider) Health(ctx context.Context) provider.Health {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.client == nil || !p.client.IsConnected() {
+		return provider.Health{Status: provider.HealthDown, Message: "not connected to broker"}
+	}
+
+	offline := 0
+	for _, d := range p.devices {
+		d.mu.RLock()
+		if !d.available {
+			offline++
+		}
+		d.mu.RUnlock()
+	}
+	if offline > 0 {
+		return provider.Health{
+			Status:  provider.HealthDegraded,
+			Message: fmt.Sprintf("%d of %d devices offline", offline, len(p.devices)),
+		}
+	}
+	return provider.Health{Status: provider.HealthOK}
+}
@@ -0,0 +1,215 @@
+package zigbee2mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/mqttclient"
+	"github.com/legitlolly/SmartHomeHub/internal/mqttclient/mqtttest"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+)
+
+const testDevices = `[
+	{"ieee_address": "0x00124b0000000000", "friendly_name": "Coordinator", "type": "Coordinator", "supported": true},
+	{
+		"ieee_address": "0x0017880100000001", "friendly_name": "living_room_lamp", "type": "Router", "supported": true,
+		"definition": {"model": "9290012573A", "vendor": "Philips", "exposes": [
+			{"type": "light", "features": [
+				{"type": "binary", "name": "state", "property": "state", "access": 7, "value_on": "ON", "value_off": "OFF"},
+				{"type": "numeric", "name": "brightness", "property": "brightness", "access": 7, "value_min": 0, "value_max": 254},
+				{"type": "numeric", "name": "color_temp", "property": "color_temp", "access": 7, "value_min": 150, "value_max": 500},
+				{"type": "composite", "name": "color_xy", "property": "color", "access": 7}
+			]},
+			{"type": "enum", "name": "power_on_behavior", "property": "power_on_behavior", "access": 7, "values": ["off", "on", "previous"]},
+			{"type": "numeric", "name": "linkquality", "property": "linkquality", "access": 1}
+		]}
+	},
+	{
+		"ieee_address": "0x00158d0000000002", "friendly_name": "bathroom_sensor", "type": "EndDevice", "supported": true,
+		"definition": {"model": "WSDCGQ11LM", "vendor": "Aqara", "exposes": [
+			{"type": "numeric", "name": "temperature", "property": "temperature", "access": 1, "unit": "°C"},
+			{"type": "numeric", "name": "humidity", "property": "humidity", "access": 1, "unit": "%"},
+			{"type": "numeric", "name": "battery", "property": "battery", "access": 1}
+		]}
+	},
+	{
+		"ieee_address": "0x00158d0000000003", "friendly_name": "bedroom_blind", "type": "EndDevice", "supported": true,
+		"definition": {"model": "ZNCLDJ11LM", "vendor": "Aqara", "exposes": [
+			{"type": "cover", "features": [
+				{"type": "enum", "name": "state", "property": "state", "access": 7, "values": ["OPEN", "CLOSE", "STOP"]},
+				{"type": "numeric", "name": "position", "property": "position", "access": 7, "value_min": 0, "value_max": 100}
+			]}
+		]}
+	}
+]`
+
+func startProvider(t *testing.T) (*device.Registry, *mqttclient.Client) {
+	t.Helper()
+	ctx := context.Background()
+	broker := mqtttest.NewBroker(t)
+	client := mqtttest.Connect(t, broker)
+
+	if err := client.Publish(ctx, "zigbee2mqtt/bridge/devices", []byte(testDevices), true); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	raw, _ := json.Marshal(map[string]any{"broker": broker})
+	p, err := NewProvider("zigbee", raw, provider.Env{})
+	if err != nil {
+		t.Fatalf("NewProvider failed: %v", err)
+	}
+
+	registry := device.NewRegistry()
+	m := provider.NewManager(registry, provider.Env{})
+	m.Add(p, "zigbee2mqtt")
+	m.StartAll(ctx)
+	t.Cleanup(func() { m.StopAll(context.Background()) })
+
+	if status, _ := m.Status("zigbee"); status.State != provider.StateRunning || status.Error != "" {
+		t.Fatalf("Expected provider to be running, got %+v", status)
+	}
+	return registry, client
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestZigbee2MQTT_DiscoversDevicesFromExposes(t *testing.T) {
+	ctx := context.Background()
+	registry, _ := startProvider(t)
+
+	if n := len(registry.List()); n != 3 {
+		t.Fatalf("Expected 3 devices (coordinator skipped), got %d", n)
+	}
+
+	cases := map[device.ID]string{
+		"zigbee-0x0017880100000001": "light",
+		"zigbee-0x00158d0000000002": "sensor",
+		"zigbee-0x00158d0000000003": "cover",
+	}
+	for id, wantType := range cases {
+		dev, err := registry.Get(id)
+		if err != nil {
+			t.Fatalf("Expected %s to be registered: %v", id, err)
+		}
+		state, _ := dev.State(ctx)
+		if state.DeviceType != wantType {
+			t.Errorf("%s: expected type %s, got %s", id, wantType, state.DeviceType)
+		}
+	}
+
+	lamp, _ := registry.Get("zigbee-0x0017880100000001")
+	state, _ := lamp.State(ctx)
+	actions := state.Attributes["actions"].([]string)
+	want := []string{"turn_on", "turn_off", "set_brightness", "set_color_temperature", "set_color", "set"}
+	if len(actions) != len(want) {
+		t.Errorf("Expected actions %v, got %v", want, actions)
+	}
+}
+
+func TestZigbee2MQTT_StateFromDeviceTopic(t *testing.T) {
+	ctx := context.Background()
+	registry, client := startProvider(t)
+
+	client.Publish(ctx, "zigbee2mqtt/living_room_lamp", []byte(`{"state":"ON","brightness":127,"color_temp":370,"linkquality":90,"update":{"state":"idle"}}`), false)
+	client.Publish(ctx, "zigbee2mqtt/bathroom_sensor", []byte(`{"temperature":21.4,"humidity":48,"battery":97}`), false)
+
+	lamp, _ := registry.Get("zigbee-0x0017880100000001")
+	waitFor(t, "lamp state", func() bool {
+		state, _ := lamp.State(ctx)
+		return state.Attributes["power"] == "on"
+	})
+	state, _ := lamp.State(ctx)
+	if state.Attributes["brightness"] != 50 {
+		t.Errorf("Expected brightness scaled to 50, got %v", state.Attributes["brightness"])
+	}
+	if state.Attributes["color_temperature"] != float64(370) {
+		t.Errorf("Expected color_temperature 370, got %v", state.Attributes["color_temperature"])
+	}
+	if _, ok := state.Attributes["update"]; ok {
+		t.Error("Properties that are not exposed should be ignored")
+	}
+
+	sensor, _ := registry.Get("zigbee-0x00158d0000000002")
+	waitFor(t, "sensor state", func() bool {
+		state, _ := sensor.State(ctx)
+		return state.Attributes["temperature"] == 21.4 && state.Attributes["humidity"] == float64(48)
+	})
+
+	client.Publish(ctx, "zigbee2mqtt/bathroom_sensor/availability", []byte(`{"state":"offline"}`), false)
+	waitFor(t, "sensor offline", func() bool {
+		state, _ := sensor.State(ctx)
+		return state.Attributes["available"] == false
+	})
+}
+
+func TestZigbee2MQTT_CommandsPublishToSetTopic(t *testing.T) {
+	ctx := context.Background()
+	registry, client := startProvider(t)
+	messages := mqtttest.Subscribe(t, client, "zigbee2mqtt/+/set")
+
+	lamp, _ := registry.Get("zigbee-0x0017880100000001")
+	blind, _ := registry.Get("zigbee-0x00158d0000000003")
+
+	cases := []struct {
+		dev     device.Device
+		cmd     device.Command
+		topic   string
+		payload string
+	}{
+		{lamp, device.Command{Action: "turn_on"}, "zigbee2mqtt/living_room_lamp/set", `{"state":"ON"}`},
+		{lamp, device.Command{Action: "set_brightness", Params: map[string]any{"value": float64(50)}}, "zigbee2mqtt/living_room_lamp/set", `{"brightness":127}`},
+		{lamp, device.Command{Action: "set", Params: map[string]any{"power_on_behavior": "previous"}}, "zigbee2mqtt/living_room_lamp/set", `{"power_on_behavior":"previous"}`},
+		{blind, device.Command{Action: "set_position", Params: map[string]any{"value": 30}}, "zigbee2mqtt/bedroom_blind/set", `{"position":30}`},
+		{blind, device.Command{Action: "close"}, "zigbee2mqtt/bedroom_blind/set", `{"state":"CLOSE"}`},
+	}
+	for _, c := range cases {
+		if err := c.dev.Execute(ctx, c.cmd); err != nil {
+			t.Fatalf("%s failed: %v", c.cmd.Action, err)
+		}
+		msg := messages.Next(t)
+		if msg.Topic != c.topic || msg.Payload != c.payload {
+			t.Errorf("%s: expected %s %s, got %s %s", c.cmd.Action, c.topic, c.payload, msg.Topic, msg.Payload)
+		}
+	}
+
+	if err := blind.Execute(ctx, device.Command{Action: "turn_on"}); !errors.Is(err, device.ErrUnknownCommand) {
+		t.Errorf("Expected ErrUnknownCommand for turn_on on a cover, got %v", err)
+	}
+	err := lamp.Execute(ctx, device.Command{Action: "set", Params: map[string]any{"linkquality": 5}})
+	if !errors.Is(err, device.ErrInvalidParameter) {
+		t.Errorf("Expected read-only property to be rejected, got %v", err)
+	}
+}
+
+func TestZigbee2MQTT_DeviceListUpdates(t *testing.T) {
+	ctx := context.Background()
+	registry, client := startProvider(t)
+
+	var list []map[string]any
+	json.Unmarshal([]byte(testDevices), &list)
+	list = list[:2] // only the coordinator and the lamp remain
+	list[1]["friendly_name"] = "lounge_lamp"
+	payload, _ := json.Marshal(list)
+	client.Publish(ctx, "zigbee2mqtt/bridge/devices", payload, true)
+
+	waitFor(t, "device list update", func() bool { return len(registry.List()) == 1 })
+
+	lamp, _ := registry.Get("zigbee-0x0017880100000001")
+	state, _ := lamp.State(ctx)
+	if state.Attributes["name"] != "lounge_lamp" {
+		t.Errorf("Expected renamed lamp, got %v", state.Attributes["name"])
+	}
+}
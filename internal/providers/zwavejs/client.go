@@ -0,0 +1,345 @@
+package zwavejs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultPort is zwave-js-server's default websocket port.
+const DefaultPort = 3000
+
+// schemaVersion is the newest API schema the hub speaks. Older servers are asked for
+// their newest; the parts used here haven't changed since schema 4.
+const (
+	schemaVersion    = 35
+	minSchemaVersion = 4
+)
+
+var (
+	ErrCommand           = errors.New("zwave-js command failed")
+	ErrDisconnected      = errors.New("zwave-js server connection closed")
+	ErrUnsupportedSchema = errors.New("unsupported zwave-js server schema")
+)
+
+// CommandError is a failed result from the server.
+type CommandError struct {
+	Code string
+	// ZWaveCode and ZWaveMessage are set for errors from the driver itself
+	ZWaveCode    int
+	ZWaveMessage string
+}
+
+func (e *CommandError) Error() string {
+	if e.ZWaveMessage != "" {
+		return fmt.Sprintf("%s: %s (%d)", e.Code, e.ZWaveMessage, e.ZWaveCode)
+	}
+	return e.Code
+}
+
+func (e *CommandError) Unwrap() error {
+	return ErrCommand
+}
+
+// Version is the first message the server sends.
+type Version struct {
+	DriverVersion    string `json:"driverVersion"`
+	ServerVersion    string `json:"serverVersion"`
+	HomeID           uint32 `json:"homeId"`
+	MinSchemaVersion int    `json:"minSchemaVersion"`
+	MaxSchemaVersion int    `json:"maxSchemaVersion"`
+}
+
+// frame is any message from the server: the version, a result or an event.
+type frame struct {
+	Type      string          `json:"type"`
+	MessageID string          `json:"messageId"`
+	Success   bool            `json:"success"`
+	Result    json.RawMessage `json:"result"`
+	ErrorCode string          `json:"errorCode"`
+	// zwaveErrorCode and zwaveErrorMessage come with errorCode "zwave_error"
+	ZWaveErrorCode    int             `json:"zwaveErrorCode"`
+	ZWaveErrorMessage string          `json:"zwaveErrorMessage"`
+	Event             json.RawMessage `json:"event"`
+	Version
+}
+
+// listenID is the message ID of start_listening, whose result the reader hands to
+// onState before any event that follows it.
+const listenID = "start_listening"
+
+// Client keeps one websocket to a zwave-js-server, shared by commands and the events
+// the server sends once the client has started listening.
+type Client struct {
+	url     string
+	timeout time.Duration
+	onState func(Version, ControllerState)
+	onEvent func(Event)
+
+	connectMu sync.Mutex // serializes Connect
+
+	mu      sync.Mutex
+	writeMu sync.Mutex
+	conn    *websocket.Conn
+	closed  chan struct{}
+	version Version
+	nextID  int64
+	pending map[string]chan frame
+}
+
+// NewClient creates a client for address, which is a ws:// URL or a host[:port].
+// onState is called with the full state after every connect, and onEvent with each
+// event after it; both run on the reader, in the order the server sent them.
+func NewClient(address string, onState func(Version, ControllerState), onEvent func(Event)) *Client {
+	return &Client{
+		url:     websocketURL(address),
+		timeout: 10 * time.Second,
+		onState: onState,
+		onEvent: onEvent,
+		pending: make(map[string]chan frame),
+	}
+}
+
+func websocketURL(address string) string {
+	if !strings.Contains(address, "://") {
+		address = "ws://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return address
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(DefaultPort))
+	}
+	return u.String()
+}
+
+// Connect opens the connection if it isn't open, negotiates the schema and starts
+// listening. It returns a channel that is closed when the connection drops.
+func (c *Client) Connect(ctx context.Context) (<-chan struct{}, error) {
+	c.connectMu.Lock()
+	defer c.connectMu.Unlock()
+
+	c.mu.Lock()
+	if c.conn != nil {
+		closed := c.closed
+		c.mu.Unlock()
+		return closed, nil
+	}
+	c.mu.Unlock()
+
+	dialer := websocket.Dialer{HandshakeTimeout: c.timeout}
+	conn, _, err := dialer.DialContext(ctx, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var version frame
+	conn.SetReadDeadline(time.Now().Add(c.timeout))
+	if err := conn.ReadJSON(&version); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+	if version.Type != "version" {
+		conn.Close()
+		return nil, fmt.Errorf("%w: expected version message, got %q", ErrUnsupportedSchema, version.Type)
+	}
+	schema := min(schemaVersion, version.MaxSchemaVersion)
+	if schema < minSchemaVersion || schema < version.MinSchemaVersion {
+		conn.Close()
+		return nil, fmt.Errorf("%w: server supports %d-%d", ErrUnsupportedSchema, version.MinSchemaVersion, version.MaxSchemaVersion)
+	}
+
+	closed := make(chan struct{})
+	c.mu.Lock()
+	c.conn = conn
+	c.closed = closed
+	c.version = version.Version
+	c.mu.Unlock()
+	go c.read(conn, closed)
+
+	if err := c.call(ctx, "", map[string]any{"command": "set_api_schema", "schemaVersion": schema}, nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := c.call(ctx, listenID, map[string]any{"command": "start_listening"}, nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return closed, nil
+}
+
+// Close drops the connection; pending calls fail with ErrDisconnected.
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+func (c *Client) read(conn *websocket.Conn, closed chan struct{}) {
+	for {
+		var f frame
+		if err := conn.ReadJSON(&f); err != nil {
+			break
+		}
+
+		switch f.Type {
+		case "event":
+			var e Event
+			if err := json.Unmarshal(f.Event, &e); err == nil && c.onEvent != nil {
+				c.onEvent(e)
+			}
+			continue
+		case "result":
+		default:
+			continue
+		}
+
+		if f.MessageID == listenID && f.Success && c.onState != nil {
+			var result struct {
+				State ControllerState `json:"state"`
+			}
+			if err := json.Unmarshal(f.Result, &result); err == nil {
+				c.mu.Lock()
+				version := c.version
+				c.mu.Unlock()
+				c.onState(version, result.State)
+			}
+		}
+
+		c.mu.Lock()
+		ch, ok := c.pending[f.MessageID]
+		delete(c.pending, f.MessageID)
+		c.mu.Unlock()
+		if ok {
+			ch <- f
+		}
+	}
+
+	conn.Close()
+	c.mu.Lock()
+	c.conn = nil
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+	close(closed)
+}
+
+// Call sends a command with the given arguments and decodes its result into result,
+// if not nil.
+func (c *Client) Call(ctx context.Context, command string, args map[string]any, result any) error {
+	msg := make(map[string]any, len(args)+1)
+	for k, v := range args {
+		msg[k] = v
+	}
+	msg["command"] = command
+	return c.call(ctx, "", msg, result)
+}
+
+// call sends msg under id, or the next free ID if id is empty.
+func (c *Client) call(ctx context.Context, id string, msg map[string]any, result any) error {
+	command := msg["command"]
+
+	c.mu.Lock()
+	conn := c.conn
+	if conn == nil {
+		c.mu.Unlock()
+		return ErrDisconnected
+	}
+	if id == "" {
+		c.nextID++
+		id = strconv.FormatInt(c.nextID, 10)
+	}
+	ch := make(chan frame, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	forget := func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}
+
+	msg["messageId"] = id
+	c.writeMu.Lock()
+	conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	err := conn.WriteJSON(msg)
+	c.writeMu.Unlock()
+	if err != nil {
+		forget()
+		return err
+	}
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	select {
+	case f, ok := <-ch:
+		if !ok {
+			return ErrDisconnected
+		}
+		if !f.Success {
+			return fmt.Errorf("%s: %w", command, &CommandError{
+				Code:         f.ErrorCode,
+				ZWaveCode:    f.ZWaveErrorCode,
+				ZWaveMessage: f.ZWaveErrorMessage,
+			})
+		}
+		if result == nil || len(f.Result) == 0 {
+			return nil
+		}
+		return json.Unmarshal(f.Result, result)
+	case <-timer.C:
+		forget()
+		return fmt.Errorf("%w: no result for %s", context.DeadlineExceeded, command)
+	case <-ctx.Done():
+		forget()
+		return ctx.Err()
+	}
+}
+
+// Set value statuses that mean the value wasn't set, from zwave-js's SetValueStatus.
+var setValueFailures = map[int]string{
+	0: "not supported by the device",
+	2: "failed",
+	3: "endpoint not found",
+	4: "not implemented",
+	5: "invalid value",
+}
+
+// SetValue writes a value. Schema 29 and later report a status; older servers only
+// report success.
+func (c *Client) SetValue(ctx context.Context, nodeID int, id ValueID, value any) error {
+	var result struct {
+		Success *bool `json:"success"`
+		Result  *struct {
+			Status  int    `json:"status"`
+			Message string `json:"message"`
+		} `json:"result"`
+	}
+	err := c.Call(ctx, "node.set_value", map[string]any{"nodeId": nodeID, "valueId": id, "value": value}, &result)
+	if err != nil {
+		return err
+	}
+	if r := result.Result; r != nil {
+		if reason, failed := setValueFailures[r.Status]; failed {
+			return fmt.Errorf("%w: set value %s", ErrCommand, reason)
+		}
+	} else if result.Success != nil && !*result.Success {
+		return fmt.Errorf("%w: set value failed", ErrCommand)
+	}
+	return nil
+}
@@ -0,0 +1,440 @@
+package zwavejs
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+// Device is one Z-Wave node. Its values come from the server's state and value
+// events; commands are set_value calls.
+type Device struct {
+	id     device.ID
+	nodeID int
+	client *Client
+	guard  *resilience.Guard
+
+	mu        sync.RWMutex
+	node      Node
+	values    map[string]*Value
+	connected bool
+	updatedAt time.Time
+}
+
+// nodeDeviceID is zwave-<home id>-<node id>, unique across networks.
+func nodeDeviceID(homeID uint32, nodeID int) device.ID {
+	return device.ID(fmt.Sprintf("zwave-%08x-%d", homeID, nodeID))
+}
+
+func newDevice(id device.ID, node Node, client *Client, guard *resilience.Guard) *Device {
+	d := &Device{id: id, nodeID: node.NodeID, client: client, guard: guard, connected: true}
+	d.setNode(node)
+	return d
+}
+
+func (d *Device) ID() device.ID {
+	return d.id
+}
+
+// setNode replaces everything known about the node.
+func (d *Device) setNode(node Node) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.values = make(map[string]*Value, len(node.Values))
+	for i := range node.Values {
+		v := node.Values[i]
+		d.values[v.key()] = &v
+	}
+	node.Values = nil
+	d.node = node
+	d.connected = true
+	d.updatedAt = time.Now()
+}
+
+// applyValue applies a value event. Values the node didn't report before are added
+// with the names from the event and no metadata.
+func (d *Device) applyValue(args valueArgs, removed bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := args.key()
+	switch v, ok := d.values[key]; {
+	case removed:
+		delete(d.values, key)
+	case ok:
+		v.Value = args.NewValue
+	default:
+		d.values[key] = &Value{
+			ValueID:          args.ValueID,
+			CommandClassName: args.CommandClassName,
+			PropertyName:     args.PropertyName,
+			PropertyKeyName:  args.PropertyKeyName,
+			Value:            args.NewValue,
+		}
+	}
+	d.updatedAt = time.Now()
+}
+
+func (d *Device) setStatus(status int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.node.Status = status
+	d.updatedAt = time.Now()
+}
+
+func (d *Device) setConnected(connected bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.connected = connected
+}
+
+// find returns the value of cc with property, from the lowest endpoint that has
+// it. Must be called with mu held.
+func (d *Device) find(cc int, property string) *Value {
+	var found *Value
+	for _, v := range d.values {
+		if v.is(cc, property) && (found == nil || v.Endpoint < found.Endpoint) {
+			found = v
+		}
+	}
+	return found
+}
+
+// setpoint returns the heating setpoint, or failing that the first one. Must be
+// called with mu held.
+func (d *Device) setpoint() *Value {
+	var found *Value
+	for _, v := range d.values {
+		if !v.is(ccThermostatSetpoint, "setpoint") || !v.Metadata.Writeable {
+			continue
+		}
+		if fmt.Sprint(v.PropertyKey) == "1" {
+			return v
+		}
+		if found == nil || fmt.Sprint(v.PropertyKey) < fmt.Sprint(found.PropertyKey) {
+			found = v
+		}
+	}
+	return found
+}
+
+// deviceType picks the hub type from the most specific command class the node has.
+// Must be called with mu held.
+func (d *Device) deviceType() string {
+	switch {
+	case d.find(ccDoorLock, "currentMode") != nil:
+		return "lock"
+	case d.find(ccThermostatMode, "mode") != nil || d.setpoint() != nil:
+		return "thermostat"
+	case d.find(ccMultilevelSwitch, "currentValue") != nil:
+		return "light"
+	case d.find(ccBinarySwitch, "currentValue") != nil:
+		return "switch"
+	}
+	for _, v := range d.values {
+		if v.CommandClass == ccBinarySensor || v.CommandClass == ccMultilevelSensor || v.CommandClass == ccMeter {
+			return "sensor"
+		}
+	}
+	return "generic"
+}
+
+// actions lists the hub commands the node supports. Must be called with mu held.
+func (d *Device) actions() []string {
+	var actions []string
+	if d.writable(ccBinarySwitch, "targetValue") != nil || d.writable(ccMultilevelSwitch, "targetValue") != nil {
+		actions = append(actions, "turn_on", "turn_off", "toggle")
+	}
+	if d.writable(ccMultilevelSwitch, "targetValue") != nil {
+		actions = append(actions, "set_brightness")
+	}
+	if d.writable(ccDoorLock, "targetMode") != nil {
+		actions = append(actions, "lock", "unlock")
+	}
+	if d.setpoint() != nil {
+		actions = append(actions, "set_temperature")
+	}
+	if d.writable(ccThermostatMode, "mode") != nil {
+		actions = append(actions, "set_mode")
+	}
+	return actions
+}
+
+// writable is find for values that can be set. Must be called with mu held.
+func (d *Device) writable(cc int, property string) *Value {
+	if v := d.find(cc, property); v != nil && v.Metadata.Writeable {
+		return v
+	}
+	return nil
+}
+
+func (d *Device) Execute(ctx context.Context, cmd device.Command) error {
+	d.mu.RLock()
+	connected, dead := d.connected, d.node.Status == statusDead
+	id, value, err := d.target(cmd)
+	d.mu.RUnlock()
+
+	if err != nil {
+		return err
+	}
+	if !connected {
+		return fmt.Errorf("%w: %w", device.ErrDeviceUnavailable, ErrDisconnected)
+	}
+	if dead {
+		return fmt.Errorf("%w: node %d is dead", device.ErrDeviceUnavailable, d.nodeID)
+	}
+	return d.guard.Do(ctx, func(ctx context.Context) error {
+		return d.client.SetValue(ctx, d.nodeID, id, value)
+	})
+}
+
+// target maps a hub command to the value to set. Must be called with mu held.
+func (d *Device) target(cmd device.Command) (ValueID, any, error) {
+	binary := d.writable(ccBinarySwitch, "targetValue")
+	multilevel := d.writable(ccMultilevelSwitch, "targetValue")
+
+	switch {
+	case cmd.Action == "turn_on" || cmd.Action == "turn_off" || cmd.Action == "toggle":
+		on := cmd.Action == "turn_on"
+		if cmd.Action == "toggle" {
+			on = d.attributes()["power"] != "on"
+		}
+		if binary != nil {
+			return binary.ValueID, on, nil
+		}
+		if multilevel != nil {
+			// 255 restores the last level
+			if on {
+				return multilevel.ValueID, 255, nil
+			}
+			return multilevel.ValueID, 0, nil
+		}
+
+	case cmd.Action == "set_brightness" && multilevel != nil:
+		v, err := device.NumberParam(cmd.Params, "value", 0, 100)
+		if err != nil {
+			return ValueID{}, nil, err
+		}
+		return multilevel.ValueID, int(math.Round(v * 99 / 100)), nil
+
+	case cmd.Action == "lock" || cmd.Action == "unlock":
+		if lock := d.writable(ccDoorLock, "targetMode"); lock != nil {
+			if cmd.Action == "lock" {
+				return lock.ValueID, 255, nil
+			}
+			return lock.ValueID, 0, nil
+		}
+
+	case cmd.Action == "set_temperature":
+		if setpoint := d.setpoint(); setpoint != nil {
+			lo, hi := 5.0, 35.0
+			if setpoint.Metadata.Min != nil {
+				lo = *setpoint.Metadata.Min
+			}
+			if setpoint.Metadata.Max != nil {
+				hi = *setpoint.Metadata.Max
+			}
+			v, err := device.NumberParam(cmd.Params, "value", lo, hi)
+			if err != nil {
+				return ValueID{}, nil, err
+			}
+			return setpoint.ValueID, v, nil
+		}
+
+	case cmd.Action == "set_mode":
+		if mode := d.writable(ccThermostatMode, "mode"); mode != nil {
+			name, _ := cmd.Params["value"].(string)
+			for raw, label := range mode.Metadata.States {
+				if strings.EqualFold(label, name) {
+					var n int
+					fmt.Sscan(raw, &n)
+					return mode.ValueID, n, nil
+				}
+			}
+			return ValueID{}, nil, fmt.Errorf("%w: value must be one of %v", device.ErrInvalidParameter, modeNames(mode))
+		}
+	}
+
+	return ValueID{}, nil, fmt.Errorf("%w: %s", device.ErrUnknownCommand, cmd.Action)
+}
+
+func modeNames(v *Value) []string {
+	names := make([]string, 0, len(v.Metadata.States))
+	for _, label := range v.Metadata.States {
+		names = append(names, strings.ToLower(label))
+	}
+	slices.Sort(names)
+	return names
+}
+
+// attributes maps the node's values to hub attributes. Where several endpoints have
+// the same value, the lowest wins. Must be called with mu held.
+func (d *Device) attributes() map[string]any {
+	values := make([]*Value, 0, len(d.values))
+	for _, v := range d.values {
+		values = append(values, v)
+	}
+	slices.SortFunc(values, func(a, b *Value) int {
+		if c := cmp.Compare(a.Endpoint, b.Endpoint); c != 0 {
+			return c
+		}
+		return strings.Compare(a.key(), b.key())
+	})
+
+	attributes := make(map[string]any)
+	for _, v := range values {
+		if v.Value == nil {
+			continue
+		}
+		name, value, ok := attribute(v)
+		if _, seen := attributes[name]; ok && !seen {
+			attributes[name] = value
+		}
+	}
+
+	// Dimmers have no separate on/off value
+	if _, ok := attributes["power"]; !ok {
+		if b, ok := attributes["brightness"].(int); ok {
+			attributes["power"] = device.OnOff(b > 0)
+		}
+	}
+	return attributes
+}
+
+// attribute names a value the way the rest of the hub does, where there is an
+// equivalent: power, brightness 0-100, locked, power_watts, energy_kwh.
+func attribute(v *Value) (string, any, bool) {
+	switch {
+	case v.is(ccBinarySwitch, "currentValue"):
+		return "power", device.OnOff(v.Value == true), true
+
+	case v.is(ccMultilevelSwitch, "currentValue"):
+		// 0-99, where 99 is full
+		if n, ok := v.Value.(float64); ok {
+			return "brightness", int(math.Round(min(n, 99) * 100 / 99)), true
+		}
+
+	case v.CommandClass == ccBinarySensor:
+		// Property is the sensor type: "Motion", "Door/Window", or "Any"
+		name := snakeCase(fmt.Sprint(v.Property))
+		if name == "any" {
+			name = "triggered"
+		}
+		return name, v.Value, true
+
+	case v.CommandClass == ccMultilevelSensor:
+		name := snakeCase(fmt.Sprint(v.Property))
+		if name == "air_temperature" {
+			name = "temperature"
+		}
+		return name, v.Value, true
+
+	case v.is(ccMeter, "value"):
+		switch v.Metadata.Unit {
+		case "W":
+			return "power_watts", v.Value, true
+		case "kWh":
+			return "energy_kwh", v.Value, true
+		case "V":
+			return "voltage", v.Value, true
+		case "A":
+			return "current", v.Value, true
+		}
+
+	case v.is(ccDoorLock, "currentMode"):
+		// 255 is secured; the modes in between are variants of unsecured
+		return "locked", v.Value == float64(255), true
+
+	case v.is(ccThermostatSetpoint, "setpoint"):
+		if fmt.Sprint(v.PropertyKey) == "1" {
+			return "target_temperature", v.Value, true
+		}
+		return snakeCase(v.PropertyKeyName) + "_setpoint", v.Value, v.PropertyKeyName != ""
+
+	case v.is(ccThermostatMode, "mode"):
+		if n, ok := v.Value.(float64); ok {
+			if label, ok := v.Metadata.States[fmt.Sprint(n)]; ok {
+				return "mode", strings.ToLower(label), true
+			}
+			return "mode", n, true
+		}
+
+	case v.is(ccBattery, "level"):
+		return "battery", v.Value, true
+	}
+	return "", nil, false
+}
+
+// snakeCase turns labels like "Door/Window" into door_window.
+func snakeCase(s string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if underscore && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+			underscore = false
+		} else {
+			underscore = true
+		}
+	}
+	return b.String()
+}
+
+func (d *Device) State(ctx context.Context) (device.State, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	attributes := d.attributes()
+	attributes["available"] = d.node.Status != statusDead
+	attributes["name"] = d.name()
+	attributes["actions"] = d.actions()
+	if mode := d.writable(ccThermostatMode, "mode"); mode != nil {
+		attributes["modes"] = modeNames(mode)
+	}
+	if d.node.Location != "" {
+		attributes["location"] = d.node.Location
+	}
+	if d.node.Status == statusAsleep {
+		attributes["asleep"] = true
+	}
+	if c := d.node.DeviceConfig; c != nil {
+		attributes["model"] = c.Label
+		attributes["manufacturer"] = c.Manufacturer
+	} else if d.node.Label != "" {
+		attributes["model"] = d.node.Label
+	}
+
+	state := device.State{
+		DeviceType: d.deviceType(),
+		UpdatedAt:  d.updatedAt,
+		Attributes: attributes,
+	}
+	if !d.connected {
+		return state, fmt.Errorf("%w: %w", device.ErrDeviceUnavailable, ErrDisconnected)
+	}
+	return state, nil
+}
+
+// name is the node's name, falling back to its description from the device database.
+// Must be called with mu held.
+func (d *Device) name() string {
+	if d.node.Name != "" {
+		return d.node.Name
+	}
+	if c := d.node.DeviceConfig; c != nil && c.Description != "" {
+		return c.Description
+	}
+	return fmt.Sprintf("Node %d", d.nodeID)
+}
@@ -0,0 +1,24 @@
+package zwavejs
+
+import (
+	"errors"
+	"io"
+
+	"github.com/gorilla/websocket"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+// ClassifyError retries network failures and dropped connections; failed commands
+// reported by the server are permanent.
+func ClassifyError(err error) resilience.Class {
+	if class, ok := resilience.ClassifyNetError(err); ok {
+		return class
+	}
+	var closeErr *websocket.CloseError
+	if errors.Is(err, ErrDisconnected) || errors.Is(err, websocket.ErrBadHandshake) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &closeErr) {
+		return resilience.Transient
+	}
+
+	return resilience.Permanent
+}
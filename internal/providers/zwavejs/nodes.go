@@ -0,0 +1,112 @@
+package zwavejs
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Command classes the hub maps to attributes and commands.
+const (
+	ccBinarySwitch       = 0x25
+	ccMultilevelSwitch   = 0x26
+	ccBinarySensor       = 0x30
+	ccMultilevelSensor   = 0x31
+	ccMeter              = 0x32
+	ccThermostatMode     = 0x40
+	ccThermostatSetpoint = 0x43
+	ccDoorLock           = 0x62
+	ccBattery            = 0x80
+)
+
+// Node statuses
+const (
+	statusUnknown = 0
+	statusAsleep  = 1
+	statusAwake   = 2
+	statusDead    = 3
+	statusAlive   = 4
+)
+
+// ControllerState is the result of start_listening.
+type ControllerState struct {
+	Nodes []Node `json:"nodes"`
+}
+
+type Node struct {
+	NodeID           int    `json:"nodeId"`
+	Name             string `json:"name"`
+	Location         string `json:"location"`
+	Status           int    `json:"status"`
+	Ready            bool   `json:"ready"`
+	IsControllerNode bool   `json:"isControllerNode"`
+	// Label is the model from the device database, e.g. "ZW100"
+	Label        string `json:"label"`
+	DeviceConfig *struct {
+		Manufacturer string `json:"manufacturer"`
+		Label        string `json:"label"`
+		Description  string `json:"description"`
+	} `json:"deviceConfig"`
+	Values []Value `json:"values"`
+}
+
+// ValueID addresses one value of a node. Property is a string or a number, and so
+// is PropertyKey when present.
+type ValueID struct {
+	CommandClass int `json:"commandClass"`
+	Endpoint     int `json:"endpoint"`
+	Property     any `json:"property"`
+	PropertyKey  any `json:"propertyKey,omitempty"`
+}
+
+// key identifies the value within its node.
+func (id ValueID) key() string {
+	return fmt.Sprintf("%d/%d/%v/%v", id.CommandClass, id.Endpoint, id.Property, id.PropertyKey)
+}
+
+// is reports whether the value is property of command class cc.
+func (id ValueID) is(cc int, property string) bool {
+	return id.CommandClass == cc && fmt.Sprint(id.Property) == property
+}
+
+type Value struct {
+	ValueID
+	CommandClassName string   `json:"commandClassName"`
+	PropertyName     string   `json:"propertyName"`
+	PropertyKeyName  string   `json:"propertyKeyName"`
+	Metadata         Metadata `json:"metadata"`
+	Value            any      `json:"value"`
+}
+
+type Metadata struct {
+	Type      string   `json:"type"`
+	Readable  bool     `json:"readable"`
+	Writeable bool     `json:"writeable"`
+	Min       *float64 `json:"min"`
+	Max       *float64 `json:"max"`
+	Unit      string   `json:"unit"`
+	Label     string   `json:"label"`
+	// States names the values of enums, e.g. {"0": "Off", "1": "Heat"}
+	States map[string]string `json:"states"`
+}
+
+// Event is the "event" member of an event message. Node events carry NodeID;
+// "node added" and "node removed" from the controller carry the whole Node.
+type Event struct {
+	Source string `json:"source"`
+	Event  string `json:"event"`
+	NodeID int    `json:"nodeId"`
+	Node   *Node  `json:"node"`
+	// Args of value events
+	Args json.RawMessage `json:"args"`
+	// NodeState is the full node in "ready" events
+	NodeState *Node `json:"nodeState"`
+}
+
+// valueArgs are the args of "value added", "value updated" and "value removed".
+type valueArgs struct {
+	ValueID
+	CommandClassName string `json:"commandClassName"`
+	PropertyName     string `json:"propertyName"`
+	PropertyKeyName  string `json:"propertyKeyName"`
+	NewValue         any    `json:"newValue"`
+}
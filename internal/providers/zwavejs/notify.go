@@ -0,0 +1,86 @@
+package zwavejs
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+// listen keeps the connection to the server open until ctx is cancelled. Every
+// reconnect starts listening again, which resyncs all nodes.
+func (p *Provider) listen(ctx context.Context) {
+	resilience.Reconnect(ctx, "zwavejs: "+p.client.url+": connection closed", func(ctx context.Context) (bool, error) {
+		err := p.listenOnce(ctx)
+		connected := p.isConnected()
+		p.setConnected(false)
+		return connected, err
+	})
+}
+
+func (p *Provider) listenOnce(ctx context.Context) error {
+	closed, err := p.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-closed:
+		return ErrDisconnected
+	case <-ctx.Done():
+		p.client.Close()
+		<-closed
+		return ctx.Err()
+	}
+}
+
+// handleEvent applies node and controller events. It runs on the client's reader.
+func (p *Provider) handleEvent(e Event) {
+	if e.Source == "controller" {
+		switch {
+		case e.Event == "node added" && e.Node != nil:
+			p.mu.Lock()
+			p.addNode(*e.Node)
+			p.mu.Unlock()
+		case e.Event == "node removed" && e.Node != nil:
+			p.mu.Lock()
+			p.removeNode(e.Node.NodeID)
+			p.mu.Unlock()
+			log.Printf("zwavejs: node %d was removed from the network", e.Node.NodeID)
+		}
+		return
+	}
+	if e.Source != "node" {
+		return
+	}
+
+	p.mu.Lock()
+	d, ok := p.nodes[e.NodeID]
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	switch e.Event {
+	case "value added", "value updated", "value removed":
+		var args valueArgs
+		if err := json.Unmarshal(e.Args, &args); err != nil {
+			return
+		}
+		d.applyValue(args, e.Event == "value removed")
+	case "ready":
+		// Sent once the interview completes, with everything found
+		if e.NodeState != nil {
+			d.setNode(*e.NodeState)
+		}
+	case "dead":
+		d.setStatus(statusDead)
+	case "alive":
+		d.setStatus(statusAlive)
+	case "sleep":
+		d.setStatus(statusAsleep)
+	case "wake up":
+		d.setStatus(statusAwake)
+	}
+}
@@ -0,0 +1,247 @@
+// Package zwavejs integrates Z-Wave networks through zwave-js-server, the websocket
+// API of Z-Wave JS (also run by Z-Wave JS UI and the Home Assistant add-on). Nodes
+// become hub devices with attributes mapped from their command classes, kept current
+// by the server's value events.
+package zwavejs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+func init() {
+	provider.RegisterFactory("zwavejs", NewProvider)
+}
+
+type Settings struct {
+	// URL of the server's websocket: ws://host:3000, or just host[:port]
+	URL        string              `json:"url"`
+	Resilience resilience.Settings `json:"resilience"`
+}
+
+type Provider struct {
+	name     string
+	settings Settings
+	guards   *resilience.Registry
+	client   *Client
+	guard    *resilience.Guard
+
+	mu        sync.Mutex
+	registry  provider.Registry
+	homeID    uint32
+	nodes     map[int]*Device
+	listening bool
+	connected bool
+	cancel    context.CancelFunc
+	listenCtx context.Context
+	wg        sync.WaitGroup
+}
+
+func NewProvider(name string, raw json.RawMessage, env provider.Env) (provider.Provider, error) {
+	var settings Settings
+	if err := provider.DecodeSettings(raw, &settings); err != nil {
+		return nil, err
+	}
+
+	guards := env.Guards
+	if guards == nil {
+		guards = resilience.NewRegistry()
+	}
+	guards.Configure(name, settings.Resilience.Apply(resilience.DefaultConfig()), ClassifyError)
+
+	p := &Provider{
+		name:     name,
+		settings: settings,
+		guards:   guards,
+		nodes:    make(map[int]*Device),
+	}
+	p.client = NewClient(settings.URL, p.handleState, p.handleEvent)
+	p.guard = guards.Guard(name, p.client.url)
+	return p, nil
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+func (p *Provider) Start(ctx context.Context, registry provider.Registry) error {
+	if p.settings.URL == "" {
+		return fmt.Errorf("%w: no url", provider.ErrNotConfigured)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.registry = registry
+	p.listenCtx, p.cancel = context.WithCancel(context.Background())
+	return nil
+}
+
+// Discover connects to the server, registering every node it has, and keeps the
+// connection open from then on. Nodes included or excluded later are picked up from
+// the server's events, so once connected there is nothing left to do.
+func (p *Provider) Discover(ctx context.Context) error {
+	p.mu.Lock()
+	listening := p.listening
+	p.mu.Unlock()
+	if listening {
+		return nil
+	}
+
+	// Not under mu: the state arrives on the client's reader, which takes it
+	if _, err := p.connect(ctx); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.listening || p.cancel == nil {
+		return nil
+	}
+	p.listening = true
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.listen(p.listenCtx)
+	}()
+	return nil
+}
+
+func (p *Provider) connect(ctx context.Context) (<-chan struct{}, error) {
+	var closed <-chan struct{}
+	err := p.guard.Do(ctx, func(ctx context.Context) error {
+		var err error
+		closed, err = p.client.Connect(ctx)
+		return err
+	})
+	return closed, err
+}
+
+// handleState syncs the nodes with the server's full state, after every connect.
+func (p *Provider) handleState(version Version, state ControllerState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cancel == nil {
+		return
+	}
+
+	if p.homeID != version.HomeID {
+		// A different network (or the first connect): start over
+		for nodeID := range p.nodes {
+			p.removeNode(nodeID)
+		}
+		p.homeID = version.HomeID
+	}
+
+	seen := make(map[int]bool)
+	for _, node := range state.Nodes {
+		if node.IsControllerNode {
+			continue
+		}
+		seen[node.NodeID] = true
+		if d, ok := p.nodes[node.NodeID]; ok {
+			d.setNode(node)
+			continue
+		}
+		p.addNode(node)
+	}
+	// Excluded while disconnected
+	for nodeID := range p.nodes {
+		if !seen[nodeID] {
+			p.removeNode(nodeID)
+		}
+	}
+	p.connected = true
+}
+
+// addNode registers a node. Must be called with mu held.
+func (p *Provider) addNode(node Node) {
+	if p.cancel == nil || node.IsControllerNode {
+		return
+	}
+	if _, ok := p.nodes[node.NodeID]; ok {
+		return
+	}
+
+	d := newDevice(nodeDeviceID(p.homeID, node.NodeID), node, p.client, p.guard)
+	if err := p.registry.Register(d); err != nil {
+		if !errors.Is(err, device.ErrDeviceAlreadyRegistered) {
+			log.Printf("zwavejs: failed to register node %d: %v", node.NodeID, err)
+		}
+		return
+	}
+	p.nodes[node.NodeID] = d
+	d.mu.RLock()
+	log.Printf("zwavejs: registered node %d (%s) as %s", node.NodeID, d.name(), d.id)
+	d.mu.RUnlock()
+}
+
+// removeNode unregisters a node. Must be called with mu held.
+func (p *Provider) removeNode(nodeID int) {
+	d, ok := p.nodes[nodeID]
+	if !ok {
+		return
+	}
+	p.registry.Unregister(d.id)
+	delete(p.nodes, nodeID)
+}
+
+func (p *Provider) setConnected(connected bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.connected = connected
+	for _, d := range p.nodes {
+		d.setConnected(connected)
+	}
+}
+
+func (p *Provider) isConnected() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.connected
+}
+
+func (p *Provider) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	cancel := p.cancel
+	p.cancel = nil
+	for nodeID := range p.nodes {
+		p.removeNode(nodeID)
+	}
+	p.listening = false
+	p.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		p.wg.Wait()
+	}
+	p.client.Close()
+	return nil
+}
+
+// Health reports the breaker state of the server connection, and degraded while it
+// is down.
+func (p *Provider) Health(ctx context.Context) provider.Health {
+	p.mu.Lock()
+	connected := p.connected
+	p.mu.Unlock()
+
+	health := provider.Health{Status: provider.HealthOK}
+	if guards, ok := p.guards.ProviderHealth(p.name); ok {
+		health.Status = guards.Status
+		health.Details = guards
+	}
+	if health.Status == provider.HealthOK && !connected {
+		health.Status = provider.HealthDegraded
+		health.Message = fmt.Sprintf("not connected to %s", p.client.url)
+	}
+	return health
+}
@@ -0,0 +1,254 @@
+package zwavejs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+	"github.com/legitlolly/SmartHomeHub/internal/provider"
+	"github.com/legitlolly/SmartHomeHub/internal/providers/zwavejs/zwavejstest"
+	"github.com/legitlolly/SmartHomeHub/internal/resilience"
+)
+
+const homeID = 0xc0ffee01
+
+func startProvider(t *testing.T, server *zwavejstest.Server) (*device.Registry, *provider.Manager) {
+	t.Helper()
+
+	raw, _ := json.Marshal(Settings{URL: server.URL()})
+	p, err := NewProvider("zwavejs", raw, provider.Env{})
+	if err != nil {
+		t.Fatalf("NewProvider failed: %v", err)
+	}
+
+	registry := device.NewRegistry()
+	m := provider.NewManager(registry, provider.Env{})
+	m.Add(p, "zwavejs")
+	m.StartAll(context.Background())
+	t.Cleanup(func() { m.StopAll(context.Background()) })
+
+	if status, _ := m.Status("zwavejs"); status.State != provider.StateRunning || status.Error != "" {
+		t.Fatalf("Expected provider to be running, got %+v", status)
+	}
+	return registry, m
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(resilience.ReconnectMinDelay + 2*time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func attr(dev device.Device, name string) any {
+	state, _ := dev.State(context.Background())
+	return state.Attributes[name]
+}
+
+func execute(t *testing.T, dev device.Device, action string, params map[string]any) {
+	t.Helper()
+	if err := dev.Execute(context.Background(), device.Command{DeviceID: dev.ID(), Action: action, Params: params}); err != nil {
+		t.Fatalf("Execute %s failed: %v", action, err)
+	}
+}
+
+func TestWebsocketURL(t *testing.T) {
+	tests := map[string]string{
+		"192.168.1.10":              "ws://192.168.1.10:3000",
+		"zwave.local:3001":          "ws://zwave.local:3001",
+		"ws://192.168.1.10":         "ws://192.168.1.10:3000",
+		"wss://zwave.example.com:8": "wss://zwave.example.com:8",
+	}
+	for address, want := range tests {
+		if got := websocketURL(address); got != want {
+			t.Errorf("%s: expected %s, got %s", address, want, got)
+		}
+	}
+}
+
+func TestZWave_ImportsNodes(t *testing.T) {
+	server := zwavejstest.NewServer(t, homeID)
+	server.AddSwitch(2, "Kettle")
+	server.AddDimmer(3, "Hall light")
+	server.AddLock(4, "Front door")
+	server.AddThermostat(5, "Radiator")
+	server.AddMultisensor(6, "Landing sensor")
+	registry, _ := startProvider(t, server)
+
+	if n := len(registry.List()); n != 5 {
+		t.Fatalf("Expected 5 devices without the controller, got %d", n)
+	}
+	if got := server.Commands(); !slices.Equal(got, []string{"set_api_schema", "start_listening"}) {
+		t.Errorf("Unexpected handshake %v", got)
+	}
+
+	tests := []struct {
+		id         device.ID
+		deviceType string
+		attributes map[string]any
+	}{
+		{"zwave-c0ffee01-2", "switch", map[string]any{"name": "Kettle", "power": "off", "power_watts": 0.0, "energy_kwh": 12.34, "model": "ZW139"}},
+		{"zwave-c0ffee01-3", "light", map[string]any{"power": "off", "brightness": 0}},
+		{"zwave-c0ffee01-4", "lock", map[string]any{"locked": false, "battery": 80.0}},
+		{"zwave-c0ffee01-5", "thermostat", map[string]any{"mode": "heat", "target_temperature": 20.0, "energy_save_heating_setpoint": 16.0, "temperature": 19.5}},
+		{"zwave-c0ffee01-6", "sensor", map[string]any{"motion": false, "temperature": 21.3, "humidity": 45.0, "battery": 100.0, "available": true}},
+	}
+	for _, tt := range tests {
+		dev, err := registry.Get(tt.id)
+		if err != nil {
+			t.Errorf("Expected %s to be registered: %v", tt.id, err)
+			continue
+		}
+		state, err := dev.State(context.Background())
+		if err != nil {
+			t.Errorf("%s: State failed: %v", tt.id, err)
+		}
+		if state.DeviceType != tt.deviceType {
+			t.Errorf("%s: expected type %s, got %s", tt.id, tt.deviceType, state.DeviceType)
+		}
+		for k, v := range tt.attributes {
+			if state.Attributes[k] != v {
+				t.Errorf("%s: expected %s %v, got %v", tt.id, k, v, state.Attributes[k])
+			}
+		}
+	}
+}
+
+func TestZWave_Commands(t *testing.T) {
+	server := zwavejstest.NewServer(t, homeID)
+	server.AddSwitch(2, "Kettle")
+	server.AddDimmer(3, "Hall light")
+	server.AddLock(4, "Front door")
+	server.AddThermostat(5, "Radiator")
+	server.AddMultisensor(6, "Landing sensor")
+	registry, _ := startProvider(t, server)
+
+	// The server sends the value updates before the result, so state is current
+	// as soon as Execute returns
+	kettle, _ := registry.Get("zwave-c0ffee01-2")
+	execute(t, kettle, "turn_on", nil)
+	if server.Value(2, zwavejstest.BinarySwitch, "targetValue", nil) != true {
+		t.Error("Expected targetValue to be set")
+	}
+	if attr(kettle, "power") != "on" || attr(kettle, "power_watts") != 60.0 {
+		t.Errorf("Expected kettle on and drawing power, got %v %v", attr(kettle, "power"), attr(kettle, "power_watts"))
+	}
+	execute(t, kettle, "toggle", nil)
+	if attr(kettle, "power") != "off" {
+		t.Error("Expected toggle to turn the kettle off")
+	}
+
+	light, _ := registry.Get("zwave-c0ffee01-3")
+	execute(t, light, "set_brightness", map[string]any{"value": 100})
+	if level := server.Value(3, zwavejstest.MultilevelSwitch, "currentValue", nil); level != 99.0 {
+		t.Errorf("Expected level 99, got %v", level)
+	}
+	execute(t, light, "turn_off", nil)
+	if attr(light, "power") != "off" {
+		t.Error("Expected light to be off")
+	}
+	execute(t, light, "turn_on", nil)
+	if attr(light, "power") != "on" || attr(light, "brightness") != 100 {
+		t.Errorf("Expected turn_on to restore full brightness, got %v", attr(light, "brightness"))
+	}
+
+	lock, _ := registry.Get("zwave-c0ffee01-4")
+	execute(t, lock, "lock", nil)
+	if attr(lock, "locked") != true {
+		t.Error("Expected door to be locked")
+	}
+
+	radiator, _ := registry.Get("zwave-c0ffee01-5")
+	execute(t, radiator, "set_temperature", map[string]any{"value": 21.5})
+	execute(t, radiator, "set_mode", map[string]any{"value": "off"})
+	if attr(radiator, "target_temperature") != 21.5 || attr(radiator, "mode") != "off" {
+		t.Errorf("Unexpected thermostat state: target %v, mode %v", attr(radiator, "target_temperature"), attr(radiator, "mode"))
+	}
+
+	ctx := context.Background()
+	err := radiator.Execute(ctx, device.Command{Action: "set_temperature", Params: map[string]any{"value": 40}})
+	if !errors.Is(err, device.ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter above the setpoint range, got %v", err)
+	}
+	err = radiator.Execute(ctx, device.Command{Action: "set_mode", Params: map[string]any{"value": "cool"}})
+	if !errors.Is(err, device.ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter for an unsupported mode, got %v", err)
+	}
+	sensor, _ := registry.Get("zwave-c0ffee01-6")
+	if err := sensor.Execute(ctx, device.Command{Action: "turn_on"}); !errors.Is(err, device.ErrUnknownCommand) {
+		t.Errorf("Expected ErrUnknownCommand for a sensor, got %v", err)
+	}
+}
+
+func TestZWave_Events(t *testing.T) {
+	server := zwavejstest.NewServer(t, homeID)
+	server.AddSwitch(2, "Kettle")
+	server.AddDimmer(3, "Hall light")
+	server.AddMultisensor(6, "Landing sensor")
+	registry, _ := startProvider(t, server)
+
+	sensor, _ := registry.Get("zwave-c0ffee01-6")
+	server.SetValue(6, zwavejstest.BinarySensor, "Motion", nil, true)
+	server.SetValue(6, zwavejstest.MultilevelSensor, "Air temperature", nil, 22.8)
+	waitFor(t, "value updates", func() bool {
+		return attr(sensor, "motion") == true && attr(sensor, "temperature") == 22.8
+	})
+
+	// A dead node can't be commanded
+	kettle, _ := registry.Get("zwave-c0ffee01-2")
+	server.SetDead(2, true)
+	waitFor(t, "node to be dead", func() bool { return attr(kettle, "available") == false })
+	err := kettle.Execute(context.Background(), device.Command{Action: "turn_on"})
+	if !errors.Is(err, device.ErrDeviceUnavailable) {
+		t.Errorf("Expected ErrDeviceUnavailable, got %v", err)
+	}
+
+	// Inclusion and exclusion
+	server.AddLock(7, "Back door")
+	waitFor(t, "included node", func() bool {
+		_, err := registry.Get("zwave-c0ffee01-7")
+		return err == nil
+	})
+	server.RemoveNode(3)
+	waitFor(t, "excluded node", func() bool {
+		_, err := registry.Get("zwave-c0ffee01-3")
+		return err != nil
+	})
+}
+
+func TestZWave_ResyncsAfterReconnect(t *testing.T) {
+	server := zwavejstest.NewServer(t, homeID)
+	server.AddSwitch(2, "Kettle")
+	server.AddDimmer(3, "Hall light")
+	registry, m := startProvider(t, server)
+	kettle, _ := registry.Get("zwave-c0ffee01-2")
+
+	server.Disconnect()
+	waitFor(t, "disconnect", func() bool {
+		_, err := kettle.State(context.Background())
+		return errors.Is(err, device.ErrDeviceUnavailable)
+	})
+	if health, _ := m.Health(context.Background(), "zwavejs"); health.Status != provider.HealthDegraded {
+		t.Errorf("Expected degraded health while disconnected, got %+v", health)
+	}
+
+	// Changes while disconnected aren't sent as events, but come with the new state
+	server.SetValue(2, zwavejstest.BinarySwitch, "currentValue", nil, true)
+	server.RemoveNode(3)
+
+	waitFor(t, "resync", func() bool {
+		_, err := registry.Get("zwave-c0ffee01-3")
+		return err != nil && attr(kettle, "power") == "on"
+	})
+	if _, err := kettle.State(context.Background()); err != nil {
+		t.Errorf("Expected state without error after reconnecting, got %v", err)
+	}
+}
@@ -0,0 +1,431 @@
+// Package zwavejstest runs a fake zwave-js-server for tests. It speaks the server's
+// JSON protocol over a websocket: the version greeting, set_api_schema,
+// start_listening with the full state, node.set_value, and node and controller
+// events for every connection that is listening.
+package zwavejstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// Command classes of the nodes the fake builds
+const (
+	BinarySwitch       = 0x25
+	MultilevelSwitch   = 0x26
+	BinarySensor       = 0x30
+	MultilevelSensor   = 0x31
+	Meter              = 0x32
+	ThermostatMode     = 0x40
+	ThermostatSetpoint = 0x43
+	DoorLock           = 0x62
+	Battery            = 0x80
+)
+
+type Server struct {
+	server *httptest.Server
+	homeID uint32
+
+	mu       sync.Mutex
+	nodes    map[int]*node
+	conns    map[*websocket.Conn]*conn
+	commands []string
+}
+
+type conn struct {
+	ws        *websocket.Conn
+	writeMu   sync.Mutex
+	listening bool
+}
+
+func (c *conn) send(msg any) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.ws.WriteJSON(msg)
+}
+
+type node struct {
+	id        int
+	name      string
+	model     string
+	status    int
+	values    []*value
+	lastLevel float64 // multilevel switches restore this on 255
+}
+
+type value struct {
+	CommandClass     int            `json:"commandClass"`
+	CommandClassName string         `json:"commandClassName"`
+	Endpoint         int            `json:"endpoint"`
+	Property         any            `json:"property"`
+	PropertyKey      any            `json:"propertyKey,omitempty"`
+	PropertyName     string         `json:"propertyName"`
+	PropertyKeyName  string         `json:"propertyKeyName,omitempty"`
+	Metadata         map[string]any `json:"metadata"`
+	Value            any            `json:"value"`
+}
+
+func (v *value) is(cc int, endpoint int, property, propertyKey any) bool {
+	return v.CommandClass == cc && v.Endpoint == endpoint &&
+		fmt.Sprint(v.Property) == fmt.Sprint(property) && fmt.Sprint(v.PropertyKey) == fmt.Sprint(propertyKey)
+}
+
+// NewServer starts a fake server for the network homeID, with only the controller
+// node. It is closed when the test ends.
+func NewServer(t testing.TB, homeID uint32) *Server {
+	t.Helper()
+
+	s := &Server{
+		homeID: homeID,
+		nodes:  map[int]*node{1: {id: 1, name: "Controller", model: "ZW090", status: 4}},
+		conns:  make(map[*websocket.Conn]*conn),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveWebsocket))
+	t.Cleanup(func() {
+		s.Disconnect()
+		s.server.Close()
+	})
+	return s
+}
+
+// URL is the websocket URL, for the provider's url setting.
+func (s *Server) URL() string {
+	return "ws" + strings.TrimPrefix(s.server.URL, "http")
+}
+
+// Commands returns the commands received so far, in order.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// Disconnect closes every websocket; the server keeps accepting new ones.
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ws := range s.conns {
+		ws.Close()
+	}
+}
+
+func meta(typ string, writeable bool, extra ...any) map[string]any {
+	m := map[string]any{"type": typ, "readable": true, "writeable": writeable}
+	for i := 0; i+1 < len(extra); i += 2 {
+		m[extra[i].(string)] = extra[i+1]
+	}
+	return m
+}
+
+// AddSwitch adds an on/off switch that is off.
+func (s *Server) AddSwitch(nodeID int, name string) {
+	s.addNode(&node{id: nodeID, name: name, model: "ZW139", values: []*value{
+		{CommandClass: BinarySwitch, CommandClassName: "Binary Switch", Property: "currentValue", PropertyName: "currentValue", Metadata: meta("boolean", false), Value: false},
+		{CommandClass: BinarySwitch, CommandClassName: "Binary Switch", Property: "targetValue", PropertyName: "targetValue", Metadata: meta("boolean", true), Value: false},
+		{CommandClass: Meter, CommandClassName: "Meter", Property: "value", PropertyKey: 66049, PropertyName: "value", PropertyKeyName: "Electric_W_Consumed", Metadata: meta("number", false, "unit", "W"), Value: 0.0},
+		{CommandClass: Meter, CommandClassName: "Meter", Property: "value", PropertyKey: 65537, PropertyName: "value", PropertyKeyName: "Electric_kWh_Consumed", Metadata: meta("number", false, "unit", "kWh"), Value: 12.34},
+	}})
+}
+
+// AddDimmer adds a multilevel switch that is off, with its last level at 99.
+func (s *Server) AddDimmer(nodeID int, name string) {
+	s.addNode(&node{id: nodeID, name: name, model: "ZW111", lastLevel: 99, values: []*value{
+		{CommandClass: MultilevelSwitch, CommandClassName: "Multilevel Switch", Property: "currentValue", PropertyName: "currentValue", Metadata: meta("number", false, "min", 0, "max", 99), Value: 0.0},
+		{CommandClass: MultilevelSwitch, CommandClassName: "Multilevel Switch", Property: "targetValue", PropertyName: "targetValue", Metadata: meta("number", true, "min", 0, "max", 99), Value: 0.0},
+	}})
+}
+
+// AddLock adds a door lock that is unlocked.
+func (s *Server) AddLock(nodeID int, name string) {
+	s.addNode(&node{id: nodeID, name: name, model: "BE469ZP", values: []*value{
+		{CommandClass: DoorLock, CommandClassName: "Door Lock", Property: "currentMode", PropertyName: "currentMode", Metadata: meta("number", false, "states", map[string]string{"0": "Unsecured", "255": "Secured"}), Value: 0.0},
+		{CommandClass: DoorLock, CommandClassName: "Door Lock", Property: "targetMode", PropertyName: "targetMode", Metadata: meta("number", true, "states", map[string]string{"0": "Unsecured", "255": "Secured"}), Value: 0.0},
+		{CommandClass: Battery, CommandClassName: "Battery", Property: "level", PropertyName: "level", Metadata: meta("number", false, "unit", "%"), Value: 80.0},
+	}})
+}
+
+// AddThermostat adds a thermostat heating to 20°C in a 19.5°C room.
+func (s *Server) AddThermostat(nodeID int, name string) {
+	modes := map[string]string{"0": "Off", "1": "Heat", "11": "Energy heat"}
+	s.addNode(&node{id: nodeID, name: name, model: "TRV", values: []*value{
+		{CommandClass: ThermostatMode, CommandClassName: "Thermostat Mode", Property: "mode", PropertyName: "mode", Metadata: meta("number", true, "states", modes), Value: 1.0},
+		{CommandClass: ThermostatSetpoint, CommandClassName: "Thermostat Setpoint", Property: "setpoint", PropertyKey: 1, PropertyName: "setpoint", PropertyKeyName: "Heating", Metadata: meta("number", true, "min", 8, "max", 28, "unit", "°C"), Value: 20.0},
+		{CommandClass: ThermostatSetpoint, CommandClassName: "Thermostat Setpoint", Property: "setpoint", PropertyKey: 11, PropertyName: "setpoint", PropertyKeyName: "Energy Save Heating", Metadata: meta("number", true, "min", 8, "max", 28, "unit", "°C"), Value: 16.0},
+		{CommandClass: MultilevelSensor, CommandClassName: "Multilevel Sensor", Property: "Air temperature", PropertyName: "Air temperature", Metadata: meta("number", false, "unit", "°C"), Value: 19.5},
+	}})
+}
+
+// AddMultisensor adds a battery powered motion, temperature and humidity sensor.
+func (s *Server) AddMultisensor(nodeID int, name string) {
+	s.addNode(&node{id: nodeID, name: name, model: "ZW100", values: []*value{
+		{CommandClass: BinarySensor, CommandClassName: "Binary Sensor", Property: "Motion", PropertyName: "Motion", Metadata: meta("boolean", false), Value: false},
+		{CommandClass: MultilevelSensor, CommandClassName: "Multilevel Sensor", Property: "Air temperature", PropertyName: "Air temperature", Metadata: meta("number", false, "unit", "°C"), Value: 21.3},
+		{CommandClass: MultilevelSensor, CommandClassName: "Multilevel Sensor", Property: "Humidity", PropertyName: "Humidity", Metadata: meta("number", false, "unit", "%"), Value: 45.0},
+		{CommandClass: Battery, CommandClassName: "Battery", Property: "level", PropertyName: "level", Metadata: meta("number", false, "unit", "%"), Value: 100.0},
+	}})
+}
+
+// addNode adds a node, announcing it like an inclusion if anyone is listening.
+func (s *Server) addNode(n *node) {
+	if n.status == 0 {
+		n.status = 4 // alive
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodes[n.id] = n
+	s.broadcast(map[string]any{"source": "controller", "event": "node added", "node": n.json(), "result": map[string]any{}})
+}
+
+// RemoveNode excludes a node.
+func (s *Server) RemoveNode(nodeID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodes[nodeID]
+	if !ok {
+		return
+	}
+	delete(s.nodes, nodeID)
+	s.broadcast(map[string]any{"source": "controller", "event": "node removed", "node": n.json(), "reason": 0})
+}
+
+// SetValue changes a value as if the device had reported it, sending "value updated".
+func (s *Server) SetValue(nodeID, commandClass int, property, propertyKey any, v any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n, ok := s.nodes[nodeID]; ok {
+		s.update(n, commandClass, 0, property, propertyKey, v)
+	}
+}
+
+// Value returns a value of a node on endpoint 0.
+func (s *Server) Value(nodeID, commandClass int, property, propertyKey any) any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n, ok := s.nodes[nodeID]; ok {
+		for _, v := range n.values {
+			if v.is(commandClass, 0, property, propertyKey) {
+				return v.Value
+			}
+		}
+	}
+	return nil
+}
+
+// SetDead marks a node dead or alive, sending the matching event.
+func (s *Server) SetDead(nodeID int, dead bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodes[nodeID]
+	if !ok {
+		return
+	}
+	event := "alive"
+	n.status = 4
+	if dead {
+		event = "dead"
+		n.status = 3
+	}
+	s.broadcast(map[string]any{"source": "node", "event": event, "nodeId": nodeID, "oldStatus": 4})
+}
+
+// update sets a value and notifies listeners. Must be called with mu held.
+func (s *Server) update(n *node, commandClass, endpoint int, property, propertyKey, newValue any) {
+	for _, v := range n.values {
+		if !v.is(commandClass, endpoint, property, propertyKey) {
+			continue
+		}
+		prev := v.Value
+		v.Value = newValue
+		s.broadcast(map[string]any{"source": "node", "event": "value updated", "nodeId": n.id, "args": map[string]any{
+			"commandClassName": v.CommandClassName,
+			"commandClass":     v.CommandClass,
+			"endpoint":         v.Endpoint,
+			"property":         v.Property,
+			"propertyKey":      v.PropertyKey,
+			"propertyName":     v.PropertyName,
+			"propertyKeyName":  v.PropertyKeyName,
+			"newValue":         newValue,
+			"prevValue":        prev,
+		}})
+		return
+	}
+}
+
+// broadcast sends an event to every listening connection. Must be called with mu held.
+func (s *Server) broadcast(event map[string]any) {
+	for _, c := range s.conns {
+		if c.listening {
+			c.send(map[string]any{"type": "event", "event": event})
+		}
+	}
+}
+
+func (n *node) json() map[string]any {
+	return map[string]any{
+		"nodeId":           n.id,
+		"name":             n.name,
+		"location":         "",
+		"status":           n.status,
+		"ready":            true,
+		"isControllerNode": n.id == 1,
+		"label":            n.model,
+		"deviceConfig":     map[string]any{"manufacturer": "Fake Labs", "label": n.model, "description": n.model + " device"},
+		"values":           n.values,
+	}
+}
+
+var upgrader = websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+
+func (s *Server) serveWebsocket(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &conn{ws: ws}
+	s.mu.Lock()
+	s.conns[ws] = c
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, ws)
+		s.mu.Unlock()
+		ws.Close()
+	}()
+
+	c.send(map[string]any{
+		"type":             "version",
+		"driverVersion":    "12.4.0",
+		"serverVersion":    "1.35.0",
+		"homeId":           s.homeID,
+		"minSchemaVersion": 0,
+		"maxSchemaVersion": 35,
+	})
+
+	for {
+		var msg map[string]any
+		if err := ws.ReadJSON(&msg); err != nil {
+			return
+		}
+		s.handle(c, msg)
+	}
+}
+
+func (s *Server) handle(c *conn, msg map[string]any) {
+	command, _ := msg["command"].(string)
+	result := map[string]any{"type": "result", "messageId": msg["messageId"], "success": true}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands = append(s.commands, command)
+
+	switch command {
+	case "set_api_schema":
+		result["result"] = map[string]any{}
+
+	case "start_listening":
+		nodes := make([]map[string]any, 0, len(s.nodes))
+		for _, n := range s.nodes {
+			nodes = append(nodes, n.json())
+		}
+		result["result"] = map[string]any{"state": map[string]any{
+			"driver":     map[string]any{},
+			"controller": map[string]any{"homeId": s.homeID},
+			"nodes":      nodes,
+		}}
+		c.send(result)
+		c.listening = true
+		return
+
+	case "node.set_value":
+		status, errMsg := s.setValue(msg)
+		if errMsg != "" {
+			result["success"] = false
+			result["errorCode"] = "zwave_error"
+			result["zwaveErrorCode"] = 204
+			result["zwaveErrorMessage"] = errMsg
+		} else {
+			result["result"] = map[string]any{"status": status}
+		}
+
+	default:
+		result["success"] = false
+		result["errorCode"] = "unknown_command"
+	}
+	c.send(result)
+}
+
+// setValue applies node.set_value, returning a SetValueStatus or a driver error.
+// Must be called with mu held.
+func (s *Server) setValue(msg map[string]any) (int, string) {
+	nodeID, _ := msg["nodeId"].(float64)
+	n, ok := s.nodes[int(nodeID)]
+	if !ok {
+		return 0, fmt.Sprintf("Node %v was not found", msg["nodeId"])
+	}
+	if n.status == 3 {
+		return 0, fmt.Sprintf("Node %d is dead", n.id)
+	}
+
+	raw, _ := json.Marshal(msg["valueId"])
+	var id struct {
+		CommandClass int `json:"commandClass"`
+		Endpoint     int `json:"endpoint"`
+		Property     any `json:"property"`
+		PropertyKey  any `json:"propertyKey"`
+	}
+	json.Unmarshal(raw, &id)
+
+	var target *value
+	for _, v := range n.values {
+		if v.is(id.CommandClass, id.Endpoint, id.Property, id.PropertyKey) {
+			target = v
+		}
+	}
+	if target == nil || target.Metadata["writeable"] != true {
+		return 0, "" // NoDeviceSupport
+	}
+
+	newValue := msg["value"]
+	switch {
+	case id.CommandClass == BinarySwitch && id.Property == "targetValue":
+		on, ok := newValue.(bool)
+		if !ok {
+			return 5, "" // InvalidValue
+		}
+		s.update(n, id.CommandClass, id.Endpoint, "targetValue", nil, on)
+		s.update(n, id.CommandClass, id.Endpoint, "currentValue", nil, on)
+		watts := 0.0
+		if on {
+			watts = 60.0
+		}
+		s.update(n, Meter, id.Endpoint, "value", 66049, watts)
+
+	case id.CommandClass == MultilevelSwitch && id.Property == "targetValue":
+		level, ok := newValue.(float64)
+		if !ok || level < 0 || (level > 99 && level != 255) {
+			return 5, ""
+		}
+		if level == 255 {
+			level = n.lastLevel
+		} else if level > 0 {
+			n.lastLevel = level
+		}
+		s.update(n, id.CommandClass, id.Endpoint, "targetValue", nil, level)
+		s.update(n, id.CommandClass, id.Endpoint, "currentValue", nil, level)
+
+	case id.CommandClass == DoorLock && id.Property == "targetMode":
+		s.update(n, id.CommandClass, id.Endpoint, "targetMode", nil, newValue)
+		s.update(n, id.CommandClass, id.Endpoint, "currentMode", nil, newValue)
+
+	default:
+		s.update(n, id.CommandClass, id.Endpoint, id.Property, id.PropertyKey, newValue)
+	}
+	return 255, "" // Success
+}
@@ -0,0 +1,147 @@
+package resilience
+
+import (
+	"sync"
+	"time"
+)
+
+type BreakerState string
+
+const (
+	StateClosed   BreakerState = "closed"
+	StateOpen     BreakerState = "open"
+	StateHalfOpen BreakerState = "half_open"
+)
+
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	// outcomeIgnored releases a slot without affecting the breaker, e.g. when the
+	// caller gave up before the provider answered or the call was refused.
+	outcomeIgnored
+)
+
+// Breaker is a consecutive-failure circuit breaker with half-open probing.
+type Breaker struct {
+	cfg BreakerConfig
+	now func() time.Time
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	successes int // successful probes while half-open
+	probes    int // probes currently in flight while half-open
+	openedAt  time.Time
+	opens     int64
+}
+
+func NewBreaker(cfg BreakerConfig) *Breaker {
+	if cfg.HalfOpenProbes <= 0 {
+		cfg.HalfOpenProbes = 1
+	}
+	return &Breaker{
+		cfg:   cfg,
+		now:   time.Now,
+		state: StateClosed,
+	}
+}
+
+// allow reserves a slot for a call. The returned func must be called exactly once
+// with the outcome of the call.
+func (b *Breaker) allow() (func(outcome), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen {
+		if b.now().Sub(b.openedAt) < b.cfg.OpenTimeout {
+			return nil, ErrCircuitOpen
+		}
+		b.state = StateHalfOpen
+		b.successes = 0
+		b.probes = 0
+	}
+
+	if b.state == StateHalfOpen {
+		if b.probes >= b.cfg.HalfOpenProbes {
+			return nil, ErrCircuitOpen
+		}
+		b.probes++
+		return b.release(true), nil
+	}
+
+	return b.release(false), nil
+}
+
+func (b *Breaker) release(probe bool) func(outcome) {
+	var once sync.Once
+	return func(o outcome) {
+		once.Do(func() { b.record(probe, o) })
+	}
+}
+
+func (b *Breaker) record(probe bool, o outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe && b.state == StateHalfOpen {
+		b.probes--
+		switch o {
+		case outcomeSuccess:
+			b.successes++
+			if b.successes >= b.cfg.HalfOpenProbes {
+				b.state = StateClosed
+				b.failures = 0
+			}
+		case outcomeFailure:
+			b.trip()
+		}
+		return
+	}
+
+	switch o {
+	case outcomeSuccess:
+		b.failures = 0
+	case outcomeFailure:
+		b.failures++
+		if b.state == StateClosed && b.cfg.FailureThreshold > 0 && b.failures >= b.cfg.FailureThreshold {
+			b.trip()
+		}
+	}
+}
+
+// trip must be called with mu held.
+func (b *Breaker) trip() {
+	b.state = StateOpen
+	b.openedAt = b.now()
+	b.successes = 0
+	b.opens++
+}
+
+type BreakerSnapshot struct {
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	OpenedAt            *time.Time   `json:"opened_at,omitempty"`
+	Opens               int64        `json:"opens"`
+}
+
+func (b *Breaker) Snapshot() BreakerSnapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	snap := BreakerSnapshot{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		Opens:               b.opens,
+	}
+	// An open breaker whose timeout has passed will let the next call through.
+	if b.state == StateOpen && b.now().Sub(b.openedAt) >= b.cfg.OpenTimeout {
+		snap.State = StateHalfOpen
+	}
+	if b.state != StateClosed {
+		openedAt := b.openedAt
+		snap.OpenedAt = &openedAt
+	}
+	return snap
+}
@@ -0,0 +1,161 @@
+package resilience
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+// Guard runs calls against a single target (e.g. one Hue bridge) with retries and a
+// circuit breaker.
+type Guard struct {
+	name     string
+	cfg      Config
+	classify Classifier
+	breaker  *Breaker
+	metrics  metrics
+
+	// Overridable in tests
+	sleep  func(ctx context.Context, d time.Duration) error
+	random func() float64
+}
+
+type metrics struct {
+	calls         atomic.Int64
+	attempts      atomic.Int64
+	successes     atomic.Int64
+	failures      atomic.Int64
+	retries       atomic.Int64
+	shortCircuits atomic.Int64
+}
+
+type Metrics struct {
+	Calls         int64 `json:"calls"`
+	Attempts      int64 `json:"attempts"`
+	Successes     int64 `json:"successes"`
+	Failures      int64 `json:"failures"`
+	Retries       int64 `json:"retries"`
+	ShortCircuits int64 `json:"short_circuits"`
+}
+
+func NewGuard(name string, cfg Config, classify Classifier) *Guard {
+	if classify == nil {
+		classify = func(error) Class { return Transient }
+	}
+	return &Guard{
+		name:     name,
+		cfg:      cfg,
+		classify: classify,
+		breaker:  NewBreaker(cfg.Breaker),
+		sleep:    sleepContext,
+		random:   rand.Float64,
+	}
+}
+
+func (g *Guard) Name() string {
+	return g.name
+}
+
+// Do calls fn until it succeeds, fails with an error that should not be retried, runs
+// out of attempts or ctx is done. If the breaker is open fn is not called and the
+// returned error wraps ErrCircuitOpen.
+func (g *Guard) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	g.metrics.calls.Add(1)
+
+	for attempt := 1; ; attempt++ {
+		done, err := g.breaker.allow()
+		if err != nil {
+			g.metrics.shortCircuits.Add(1)
+			return fmt.Errorf("%s: %w", g.name, err)
+		}
+
+		g.metrics.attempts.Add(1)
+		err = fn(ctx)
+		if err == nil {
+			done(outcomeSuccess)
+			g.metrics.successes.Add(1)
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			// The caller gave up; that says nothing about the health of the target.
+			done(outcomeIgnored)
+			g.metrics.failures.Add(1)
+			return err
+		}
+
+		class := g.classify(err)
+		if class == Permanent {
+			// The target answered, but a refusal (a missing light, a bad parameter)
+			// doesn't show it is healthy either, so it can't close the breaker.
+			done(outcomeIgnored)
+			g.metrics.failures.Add(1)
+			return err
+		}
+		done(outcomeFailure)
+
+		rule, ok := g.cfg.Retry[class]
+		if !ok || attempt >= rule.MaxAttempts {
+			g.metrics.failures.Add(1)
+			return err
+		}
+
+		g.metrics.retries.Add(1)
+		if serr := g.sleep(ctx, g.backoff(rule, attempt)); serr != nil {
+			g.metrics.failures.Add(1)
+			return err
+		}
+	}
+}
+
+// backoff returns the delay before the attempt following attempt: BaseDelay doubled
+// per attempt, capped at MaxDelay, with up to Jitter of it randomised.
+func (g *Guard) backoff(rule RetryRule, attempt int) time.Duration {
+	delay := rule.BaseDelay
+	for i := 1; i < attempt && (rule.MaxDelay <= 0 || delay < rule.MaxDelay); i++ {
+		delay *= 2
+	}
+	if rule.MaxDelay > 0 && delay > rule.MaxDelay {
+		delay = rule.MaxDelay
+	}
+
+	if j := g.cfg.Jitter; j > 0 {
+		if j > 1 {
+			j = 1
+		}
+		spread := float64(delay) * j
+		delay = time.Duration(float64(delay) - spread + g.random()*spread)
+	}
+	return delay
+}
+
+func (g *Guard) Metrics() Metrics {
+	return Metrics{
+		Calls:         g.metrics.calls.Load(),
+		Attempts:      g.metrics.attempts.Load(),
+		Successes:     g.metrics.successes.Load(),
+		Failures:      g.metrics.failures.Load(),
+		Retries:       g.metrics.retries.Load(),
+		ShortCircuits: g.metrics.shortCircuits.Load(),
+	}
+}
+
+func (g *Guard) Breaker() BreakerSnapshot {
+	return g.breaker.Snapshot()
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
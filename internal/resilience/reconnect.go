@@ -0,0 +1,39 @@
+package resilience
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// The wait between reconnects starts at ReconnectMinDelay and doubles while connecting
+// keeps failing, up to ReconnectMaxDelay.
+const (
+	ReconnectMinDelay = time.Second
+	ReconnectMaxDelay = 30 * time.Second
+)
+
+// Reconnect runs session again each time it ends, until ctx is cancelled. session
+// returns when its connection is lost, saying whether it had connected at all; only a
+// session that never connected makes the next wait longer. Each reconnect is logged
+// as "<label>: <err>, reconnecting in <delay>".
+func Reconnect(ctx context.Context, label string, session func(ctx context.Context) (connected bool, err error)) {
+	delay := ReconnectMinDelay
+	for {
+		connected, err := session(ctx)
+		if connected {
+			delay = ReconnectMinDelay
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("%s: %v, reconnecting in %s", label, err, delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, ReconnectMaxDelay)
+	}
+}
@@ -0,0 +1,145 @@
+package resilience
+
+import (
+	"expvar"
+	"sort"
+	"sync"
+)
+
+// Registry holds the per-provider configuration and the guards created for each of
+// the provider's targets, so their health can be reported in one place.
+type Registry struct {
+	mu      sync.RWMutex
+	configs map[string]providerConfig
+	guards  map[string]map[string]*Guard // provider -> target -> guard
+}
+
+type providerConfig struct {
+	cfg      Config
+	classify Classifier
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		configs: make(map[string]providerConfig),
+		guards:  make(map[string]map[string]*Guard),
+	}
+}
+
+// Configure sets the config and error classifier used for guards created for provider
+// after this call.
+func (r *Registry) Configure(provider string, cfg Config, classify Classifier) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.configs[provider] = providerConfig{cfg: cfg, classify: classify}
+}
+
+// Guard returns the guard for target within provider, creating it on first use.
+func (r *Registry) Guard(provider, target string) *Guard {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	targets, ok := r.guards[provider]
+	if !ok {
+		targets = make(map[string]*Guard)
+		r.guards[provider] = targets
+	}
+	if g, ok := targets[target]; ok {
+		return g
+	}
+
+	pc, ok := r.configs[provider]
+	if !ok {
+		pc = providerConfig{cfg: DefaultConfig()}
+	}
+	g := NewGuard(provider+"/"+target, pc.cfg, pc.classify)
+	targets[target] = g
+	return g
+}
+
+type TargetHealth struct {
+	Target  string          `json:"target"`
+	Breaker BreakerSnapshot `json:"breaker"`
+	Metrics Metrics         `json:"metrics"`
+}
+
+type ProviderHealth struct {
+	Provider string         `json:"provider"`
+	Status   string         `json:"status"` // ok, degraded or down
+	Targets  []TargetHealth `json:"targets"`
+}
+
+// ProviderHealth reports the breaker state and metrics of every target of provider.
+func (r *Registry) ProviderHealth(provider string) (ProviderHealth, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, configured := r.configs[provider]
+	targets, ok := r.guards[provider]
+	if !ok && !configured {
+		return ProviderHealth{}, false
+	}
+
+	health := ProviderHealth{
+		Provider: provider,
+		Targets:  make([]TargetHealth, 0, len(targets)),
+	}
+	open := 0
+	for target, g := range targets {
+		th := TargetHealth{
+			Target:  target,
+			Breaker: g.Breaker(),
+			Metrics: g.Metrics(),
+		}
+		if th.Breaker.State != StateClosed {
+			open++
+		}
+		health.Targets = append(health.Targets, th)
+	}
+	sort.Slice(health.Targets, func(i, j int) bool {
+		return health.Targets[i].Target < health.Targets[j].Target
+	})
+
+	switch {
+	case open == 0:
+		health.Status = "ok"
+	case open == len(targets):
+		health.Status = "down"
+	default:
+		health.Status = "degraded"
+	}
+	return health, true
+}
+
+func (r *Registry) providers() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	for p := range r.configs {
+		seen[p] = true
+	}
+	for p := range r.guards {
+		seen[p] = true
+	}
+	names := make([]string, 0, len(seen))
+	for p := range seen {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Publish exposes the health of every provider through expvar under name. It must
+// only be called once per name.
+func (r *Registry) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any {
+		all := make(map[string]ProviderHealth)
+		for _, p := range r.providers() {
+			if h, ok := r.ProviderHealth(p); ok {
+				all[p] = h
+			}
+		}
+		return all
+	}))
+}
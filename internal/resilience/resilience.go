@@ -0,0 +1,220 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Class tells the retry policy and the circuit breaker how to treat a failed call.
+type Class string
+
+const (
+	// Permanent errors are returned straight away and do not count against the breaker
+	// (bad parameters, auth failures, unknown lights - the bridge answered us).
+	Permanent Class = "permanent"
+	// Transient errors are retried and count as breaker failures.
+	Transient Class = "transient"
+	// Timeout errors are retried like transient ones but usually with fewer attempts,
+	// since each attempt has already cost a full timeout.
+	Timeout Class = "timeout"
+)
+
+// Classifier maps an error returned by a provider client to a Class.
+type Classifier func(err error) Class
+
+// ClassifyNetError classifies the errors every network client shares: a cancelled
+// call is permanent, a deadline or network timeout is a timeout and any other network
+// error is transient. ok is false for other errors, which are left to the provider.
+func ClassifyNetError(err error) (class Class, ok bool) {
+	if errors.Is(err, context.Canceled) {
+		return Permanent, true
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return Timeout, true
+	}
+	if errors.As(err, &netErr) {
+		return Transient, true
+	}
+	return "", false
+}
+
+// ClassifyNetwork is the Classifier for devices whose only retryable failures are
+// network ones; errors the device itself returns are permanent.
+func ClassifyNetwork(err error) Class {
+	if class, ok := ClassifyNetError(err); ok {
+		return class
+	}
+	return Permanent
+}
+
+// Unavailable marks a failed state read as the device being unavailable, keeping the
+// cause. An open breaker already says so, and a cancelled read says nothing about the
+// device, so both are returned as they are.
+func Unavailable(err error) error {
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, context.Canceled) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", device.ErrDeviceUnavailable, err)
+}
+
+// RetryRule controls how often and how quickly one class of error is retried.
+type RetryRule struct {
+	MaxAttempts int // total attempts including the first call
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+type BreakerConfig struct {
+	FailureThreshold int           // consecutive failures before the breaker opens
+	OpenTimeout      time.Duration // how long to stay open before letting a probe through
+	HalfOpenProbes   int           // successful probes needed to close again
+}
+
+type Config struct {
+	Retry   map[Class]RetryRule
+	Jitter  float64 // fraction of each delay that is randomised, 0-1
+	Breaker BreakerConfig
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Retry: map[Class]RetryRule{
+			Transient: {MaxAttempts: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: 2 * time.Second},
+			Timeout:   {MaxAttempts: 2, BaseDelay: 250 * time.Millisecond, MaxDelay: 2 * time.Second},
+		},
+		Jitter: 0.2,
+		Breaker: BreakerConfig{
+			FailureThreshold: 5,
+			OpenTimeout:      30 * time.Second,
+			HalfOpenProbes:   1,
+		},
+	}
+}
+
+// ConfigFromEnv overrides fields of base from <PREFIX>_* environment variables, e.g.
+// HUE_RETRY_MAX_ATTEMPTS or HUE_BREAKER_OPEN_TIMEOUT. Unset or malformed values are ignored.
+func ConfigFromEnv(prefix string, base Config) Config {
+	cfg := base
+	cfg.Retry = make(map[Class]RetryRule, len(base.Retry))
+	for class, rule := range base.Retry {
+		cfg.Retry[class] = rule
+	}
+
+	if n, ok := envInt(prefix + "_RETRY_MAX_ATTEMPTS"); ok {
+		for class, rule := range cfg.Retry {
+			rule.MaxAttempts = n
+			cfg.Retry[class] = rule
+		}
+	}
+	if d, ok := envDuration(prefix + "_RETRY_BASE_DELAY"); ok {
+		for class, rule := range cfg.Retry {
+			rule.BaseDelay = d
+			cfg.Retry[class] = rule
+		}
+	}
+	if d, ok := envDuration(prefix + "_RETRY_MAX_DELAY"); ok {
+		for class, rule := range cfg.Retry {
+			rule.MaxDelay = d
+			cfg.Retry[class] = rule
+		}
+	}
+	if n, ok := envInt(prefix + "_BREAKER_THRESHOLD"); ok {
+		cfg.Breaker.FailureThreshold = n
+	}
+	if d, ok := envDuration(prefix + "_BREAKER_OPEN_TIMEOUT"); ok {
+		cfg.Breaker.OpenTimeout = d
+	}
+
+	return cfg
+}
+
+func envInt(key string) (int, bool) {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+func envDuration(key string) (time.Duration, bool) {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0, false
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, false
+	}
+	return d, true
+}
+
+// Settings is the config file form of Config, used in provider settings. Fields that
+// are left out keep the value from the base config.
+type Settings struct {
+	Retry   map[Class]RetrySettings `json:"retry,omitempty"`
+	Jitter  *float64                `json:"jitter,omitempty"`
+	Breaker BreakerSettings         `json:"breaker"`
+}
+
+type RetrySettings struct {
+	MaxAttempts int             `json:"max_attempts,omitempty"`
+	BaseDelay   config.Duration `json:"base_delay,omitempty"`
+	MaxDelay    config.Duration `json:"max_delay,omitempty"`
+}
+
+type BreakerSettings struct {
+	FailureThreshold int             `json:"failure_threshold,omitempty"`
+	OpenTimeout      config.Duration `json:"open_timeout,omitempty"`
+	HalfOpenProbes   int             `json:"half_open_probes,omitempty"`
+}
+
+func (s Settings) Apply(base Config) Config {
+	cfg := base
+	cfg.Retry = make(map[Class]RetryRule, len(base.Retry))
+	for class, rule := range base.Retry {
+		cfg.Retry[class] = rule
+	}
+
+	for class, rs := range s.Retry {
+		rule := cfg.Retry[class]
+		if rs.MaxAttempts > 0 {
+			rule.MaxAttempts = rs.MaxAttempts
+		}
+		if rs.BaseDelay > 0 {
+			rule.BaseDelay = rs.BaseDelay.Duration()
+		}
+		if rs.MaxDelay > 0 {
+			rule.MaxDelay = rs.MaxDelay.Duration()
+		}
+		cfg.Retry[class] = rule
+	}
+	if s.Jitter != nil {
+		cfg.Jitter = *s.Jitter
+	}
+	if s.Breaker.FailureThreshold > 0 {
+		cfg.Breaker.FailureThreshold = s.Breaker.FailureThreshold
+	}
+	if s.Breaker.OpenTimeout > 0 {
+		cfg.Breaker.OpenTimeout = s.Breaker.OpenTimeout.Duration()
+	}
+	if s.Breaker.HalfOpenProbes > 0 {
+		cfg.Breaker.HalfOpenProbes = s.Breaker.HalfOpenProbes
+	}
+	return cfg
+}
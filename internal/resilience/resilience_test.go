@@ -0,0 +1,318 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+)
+
+var errFlaky = errors.New("flaky")
+var errBad = errors.New("bad request")
+
+func classify(err error) Class {
+	if errors.Is(err, errBad) {
+		return Permanent
+	}
+	return Transient
+}
+
+func newTestGuard(cfg Config) (*Guard, *[]time.Duration) {
+	g := NewGuard("test/bridge", cfg, classify)
+	var slept []time.Duration
+	g.sleep = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return ctx.Err()
+	}
+	g.random = func() float64 { return 1 }
+	return g, &slept
+}
+
+func testConfig() Config {
+	return Config{
+		Retry: map[Class]RetryRule{
+			Transient: {MaxAttempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: 15 * time.Millisecond},
+		},
+		Breaker: BreakerConfig{FailureThreshold: 3, OpenTimeout: time.Minute},
+	}
+}
+
+func TestGuard_RetriesTransientErrors(t *testing.T) {
+	g, slept := newTestGuard(testConfig())
+
+	calls := 0
+	err := g.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return errFlaky
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Expected success after retries, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", calls)
+	}
+	// 10ms then doubled to 20ms but capped at 15ms
+	if len(*slept) != 2 || (*slept)[0] != 10*time.Millisecond || (*slept)[1] != 15*time.Millisecond {
+		t.Errorf("Unexpected backoff delays: %v", *slept)
+	}
+
+	m := g.Metrics()
+	if m.Calls != 1 || m.Attempts != 3 || m.Retries != 2 || m.Successes != 1 {
+		t.Errorf("Unexpected metrics: %+v", m)
+	}
+}
+
+func TestGuard_PermanentErrorsAreNotRetried(t *testing.T) {
+	g, _ := newTestGuard(testConfig())
+
+	calls := 0
+	err := g.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return errBad
+	})
+
+	if !errors.Is(err, errBad) {
+		t.Fatalf("Expected errBad, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 call, got %d", calls)
+	}
+	if g.Breaker().ConsecutiveFailures != 0 {
+		t.Errorf("Permanent errors should not count against the breaker")
+	}
+}
+
+func TestGuard_BreakerOpensAndProbes(t *testing.T) {
+	cfg := testConfig()
+	cfg.Retry[Transient] = RetryRule{MaxAttempts: 1}
+	g, _ := newTestGuard(cfg)
+
+	now := time.Now()
+	g.breaker.now = func() time.Time { return now }
+
+	failing := func(ctx context.Context) error { return errFlaky }
+	for i := 0; i < 3; i++ {
+		g.Do(context.Background(), failing)
+	}
+
+	if state := g.Breaker().State; state != StateOpen {
+		t.Fatalf("Expected breaker to be open, got %s", state)
+	}
+
+	called := false
+	err := g.Do(context.Background(), func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if called {
+		t.Fatal("Call should have been short-circuited")
+	}
+
+	// After the open timeout one probe is let through; a failure re-opens the breaker
+	now = now.Add(time.Minute)
+	if err := g.Do(context.Background(), failing); !errors.Is(err, errFlaky) {
+		t.Fatalf("Expected probe to reach the target, got %v", err)
+	}
+	if state := g.Breaker().State; state != StateOpen {
+		t.Fatalf("Expected failed probe to re-open the breaker, got %s", state)
+	}
+
+	now = now.Add(time.Minute)
+	if err := g.Do(context.Background(), func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatalf("Expected probe to succeed, got %v", err)
+	}
+	if state := g.Breaker().State; state != StateClosed {
+		t.Fatalf("Expected successful probe to close the breaker, got %s", state)
+	}
+	if opens := g.Breaker().Opens; opens != 2 {
+		t.Errorf("Expected 2 opens, got %d", opens)
+	}
+}
+
+func TestGuard_PermanentErrorIsNeutralProbe(t *testing.T) {
+	cfg := testConfig()
+	cfg.Retry[Transient] = RetryRule{MaxAttempts: 1}
+	g, _ := newTestGuard(cfg)
+
+	now := time.Now()
+	g.breaker.now = func() time.Time { return now }
+
+	failing := func(ctx context.Context) error { return errFlaky }
+	for i := 0; i < 3; i++ {
+		g.Do(context.Background(), failing)
+	}
+
+	// A probe refused with a permanent error neither closes nor re-opens the breaker,
+	// and the next call probes again
+	now = now.Add(time.Minute)
+	if err := g.Do(context.Background(), func(ctx context.Context) error { return errBad }); !errors.Is(err, errBad) {
+		t.Fatalf("Expected the probe to reach the target, got %v", err)
+	}
+	if state := g.Breaker().State; state != StateHalfOpen {
+		t.Fatalf("Expected the breaker to stay half-open, got %s", state)
+	}
+	if opens := g.Breaker().Opens; opens != 1 {
+		t.Errorf("Expected 1 open, got %d", opens)
+	}
+
+	if err := g.Do(context.Background(), func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatalf("Expected the next probe to be let through, got %v", err)
+	}
+	if state := g.Breaker().State; state != StateClosed {
+		t.Fatalf("Expected a successful probe to close the breaker, got %s", state)
+	}
+}
+
+func TestGuard_PermanentErrorDoesNotResetFailures(t *testing.T) {
+	g, _ := newTestGuard(testConfig())
+	g.cfg.Retry[Transient] = RetryRule{MaxAttempts: 1}
+
+	failing := func(ctx context.Context) error { return errFlaky }
+	g.Do(context.Background(), failing)
+	g.Do(context.Background(), failing)
+	g.Do(context.Background(), func(ctx context.Context) error { return errBad })
+	if n := g.Breaker().ConsecutiveFailures; n != 2 {
+		t.Fatalf("Expected 2 consecutive failures, got %d", n)
+	}
+	g.Do(context.Background(), failing)
+	if state := g.Breaker().State; state != StateOpen {
+		t.Errorf("Expected the third failure to open the breaker, got %s", state)
+	}
+}
+
+func TestGuard_StopsWhenContextCancelled(t *testing.T) {
+	g, _ := newTestGuard(testConfig())
+	ctx, cancel := context.WithCancel(context.Background())
+
+	calls := 0
+	err := g.Do(ctx, func(ctx context.Context) error {
+		calls++
+		cancel()
+		return errFlaky
+	})
+
+	if !errors.Is(err, errFlaky) {
+		t.Fatalf("Expected errFlaky, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 call, got %d", calls)
+	}
+	if g.Breaker().ConsecutiveFailures != 0 {
+		t.Errorf("Cancelled calls should not count against the breaker")
+	}
+}
+
+func TestGuard_BackoffJitter(t *testing.T) {
+	cfg := testConfig()
+	cfg.Jitter = 0.5
+	g, _ := newTestGuard(cfg)
+	rule := RetryRule{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	g.random = func() float64 { return 0 }
+	if d := g.backoff(rule, 1); d != 50*time.Millisecond {
+		t.Errorf("Expected 50ms with minimum jitter, got %v", d)
+	}
+	g.random = func() float64 { return 1 }
+	if d := g.backoff(rule, 2); d != 200*time.Millisecond {
+		t.Errorf("Expected 200ms with maximum jitter, got %v", d)
+	}
+	if d := g.backoff(rule, 10); d != time.Second {
+		t.Errorf("Expected delay capped at 1s, got %v", d)
+	}
+}
+
+func TestRegistry_ProviderHealth(t *testing.T) {
+	r := NewRegistry()
+	r.Configure("hue", testConfig(), classify)
+
+	health, ok := r.ProviderHealth("hue")
+	if !ok {
+		t.Fatal("Expected configured provider to report health")
+	}
+	if health.Status != "ok" || len(health.Targets) != 0 {
+		t.Errorf("Unexpected health: %+v", health)
+	}
+
+	if r.Guard("hue", "10.0.0.2") != r.Guard("hue", "10.0.0.2") {
+		t.Fatal("Expected the same guard for the same target")
+	}
+	r.Guard("hue", "10.0.0.3").breaker.trip()
+
+	health, _ = r.ProviderHealth("hue")
+	if health.Status != "degraded" || len(health.Targets) != 2 {
+		t.Errorf("Expected degraded with 2 targets, got %+v", health)
+	}
+
+	if _, ok := r.ProviderHealth("unknown"); ok {
+		t.Error("Expected unknown provider to be reported as missing")
+	}
+}
+
+func TestClassifyNetError(t *testing.T) {
+	tests := []struct {
+		err   error
+		class Class
+		ok    bool
+	}{
+		{context.Canceled, Permanent, true},
+		{fmt.Errorf("dial: %w", context.DeadlineExceeded), Timeout, true},
+		{&net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}, Timeout, true},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, Transient, true},
+		{errBad, "", false},
+	}
+	for _, tt := range tests {
+		if class, ok := ClassifyNetError(tt.err); class != tt.class || ok != tt.ok {
+			t.Errorf("ClassifyNetError(%v) = %q, %v; expected %q, %v", tt.err, class, ok, tt.class, tt.ok)
+		}
+	}
+	if class := ClassifyNetwork(errBad); class != Permanent {
+		t.Errorf("Expected a device error to be permanent, got %q", class)
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	cause := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+	err := Unavailable(cause)
+	if !errors.Is(err, device.ErrDeviceUnavailable) || !errors.Is(err, cause) {
+		t.Errorf("Expected the device to be unavailable with the cause kept, got %v", err)
+	}
+	for _, err := range []error{ErrCircuitOpen, fmt.Errorf("read: %w", context.Canceled)} {
+		if errors.Is(Unavailable(err), device.ErrDeviceUnavailable) {
+			t.Errorf("Expected %v to be returned as it is", err)
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sessions := 0
+	start := time.Now()
+	Reconnect(ctx, "test", func(ctx context.Context) (bool, error) {
+		sessions++
+		if sessions == 2 {
+			cancel()
+		}
+		return true, errFlaky
+	})
+
+	if sessions != 2 {
+		t.Errorf("Expected 2 sessions, got %d", sessions)
+	}
+	if elapsed := time.Since(start); elapsed < ReconnectMinDelay {
+		t.Errorf("Expected to wait %s before reconnecting, waited %s", ReconnectMinDelay, elapsed)
+	}
+}
@@ -0,0 +1,220 @@
+// Package trace records the traffic between the hub and real devices, every command
+// sent and every state read, into a trace file that the replay provider can serve back
+// as devices. A trace from someone's home reproduces what the hub saw there without
+// their hardware.
+//
+// A trace file is JSON lines, one Event per line, in the order they happened.
+package trace
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/legitlolly/SmartHomeHub/internal/config"
+	"github.com/legitlolly/SmartHomeHub/internal/device"
+)
+
+const (
+	// KindRegister is a device being registered by its provider
+	KindRegister = "register"
+	KindCommand  = "command"
+	KindState    = "state"
+)
+
+type Event struct {
+	Time     time.Time `json:"time"`
+	Provider string    `json:"provider"`
+	Device   device.ID `json:"device"`
+	Kind     string    `json:"kind"`
+	// Action and Params are the command sent, for KindCommand
+	Action string         `json:"action,omitempty"`
+	Params map[string]any `json:"params,omitempty"`
+	// State is the state read, for KindState
+	State *State `json:"state,omitempty"`
+	// Error is what the command or read returned, and Unavailable whether it was
+	// device.ErrDeviceUnavailable
+	Error       string `json:"error,omitempty"`
+	Unavailable bool   `json:"unavailable,omitempty"`
+	// Took is how long the device took to answer
+	Took config.Duration `json:"took,omitempty"`
+}
+
+// State is a device.State as written to a trace.
+type State struct {
+	DeviceType string         `json:"device_type"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	Attributes map[string]any `json:"attributes"`
+}
+
+func (s *State) Device() device.State {
+	return device.State{DeviceType: s.DeviceType, UpdatedAt: s.UpdatedAt, Attributes: s.Attributes}
+}
+
+// Err turns the recorded error back into an error, or nil if there was none.
+func (e *Event) Err() error {
+	switch {
+	case e.Unavailable:
+		return &replayedError{msg: e.Error, wrapped: device.ErrDeviceUnavailable}
+	case e.Error != "":
+		return &replayedError{msg: e.Error}
+	}
+	return nil
+}
+
+// replayedError keeps the message of a recorded error, and the sentinel it wrapped
+// where the hub depends on it.
+type replayedError struct {
+	msg     string
+	wrapped error
+}
+
+func (e *replayedError) Error() string { return e.msg }
+func (e *replayedError) Unwrap() error { return e.wrapped }
+
+// Recorder appends events to a trace. It is safe for concurrent use.
+type Recorder struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+	failed bool
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	r := &Recorder{enc: json.NewEncoder(w)}
+	if c, ok := w.(io.Closer); ok {
+		r.closer = c
+	}
+	return r
+}
+
+// Create opens a trace file for recording, appending to it if it exists.
+func Create(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace: %w", err)
+	}
+	return NewRecorder(f), nil
+}
+
+// Record writes an event, timestamped now if it has no time. Write errors are logged
+// once rather than failing the device call being recorded.
+func (r *Recorder) Record(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(e); err != nil && !r.failed {
+		r.failed = true
+		log.Printf("trace: recording failed: %v", err)
+	}
+}
+
+func (r *Recorder) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// Wrap returns d recording every command and state read, as the provider's.
+// The recording unwraps to d with device.Unwrap.
+func (r *Recorder) Wrap(provider string, d device.Device) device.Device {
+	return &recordingDevice{Device: d, provider: provider, recorder: r}
+}
+
+// Registered records a device being registered, so devices that are never used
+// still replay.
+func (r *Recorder) Registered(provider string, id device.ID) {
+	r.Record(Event{Provider: provider, Device: id, Kind: KindRegister})
+}
+
+type recordingDevice struct {
+	device.Device
+	provider string
+	recorder *Recorder
+}
+
+// Unwrap returns the recorded device, so callers can still reach its own methods.
+func (d *recordingDevice) Unwrap() device.Device {
+	return d.Device
+}
+
+func (d *recordingDevice) Execute(ctx context.Context, cmd device.Command) error {
+	start := time.Now()
+	err := d.Device.Execute(ctx, cmd)
+	d.recorder.Record(withError(Event{
+		Time:     start,
+		Provider: d.provider,
+		Device:   d.ID(),
+		Kind:     KindCommand,
+		Action:   cmd.Action,
+		Params:   cmd.Params,
+		Took:     config.Duration(time.Since(start)),
+	}, err))
+	return err
+}
+
+func (d *recordingDevice) State(ctx context.Context) (device.State, error) {
+	start := time.Now()
+	state, err := d.Device.State(ctx)
+	e := Event{
+		Time:     start,
+		Provider: d.provider,
+		Device:   d.ID(),
+		Kind:     KindState,
+		Took:     config.Duration(time.Since(start)),
+	}
+	if err == nil {
+		e.State = &State{DeviceType: state.DeviceType, UpdatedAt: state.UpdatedAt, Attributes: state.Attributes}
+	}
+	d.recorder.Record(withError(e, err))
+	return state, err
+}
+
+func withError(e Event, err error) Event {
+	if err != nil {
+		e.Error = err.Error()
+		e.Unavailable = errors.Is(err, device.ErrDeviceUnavailable)
+	}
+	return e
+}
+
+// Read reads the events of a trace.
+func Read(r io.Reader) ([]Event, error) {
+	var events []Event
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("invalid trace: line %d: %w", line, err)
+		}
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read trace: %w", err)
+	}
+	return events, nil
+}
+
+// Load reads a trace file.
+func Load(path string) ([]Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trace: %w", err)
+	}
+	defer f.Close()
+	return Read(f)
+}